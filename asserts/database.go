@@ -25,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"time"
 )
 
@@ -326,6 +327,82 @@ func (db *Database) Check(assert Assertion) error {
 	return nil
 }
 
+// Recheck re-checks all the assertions held in the main backstore
+// against the current knowledge, reporting the ones that would no
+// longer be accepted to invalidCb together with the reason.
+//
+// Unlike Check, it checks the validity of the signing key at the time
+// the assertion was signed, i.e. its timestamp, so assertions signed
+// with keys that expired since stay valid. Assertions signed with a key
+// that was revoked, by a newer revision of its account-key ending its
+// validity before they were signed, are reported as such.
+func (db *Database) Recheck(invalidCb func(assert Assertion, reason error)) error {
+	names := make([]string, 0, len(typeRegistry))
+	for name := range typeRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		assertType := typeRegistry[name]
+		if assertType.flags&noAuthority != 0 {
+			// not stored
+			continue
+		}
+		var candidates []Assertion
+		foundCb := func(assert Assertion) {
+			candidates = append(candidates, assert)
+		}
+		err := db.bs.Search(assertType, nil, foundCb, assertType.MaxSupportedFormat())
+		if err != nil {
+			return err
+		}
+		for _, assert := range candidates {
+			reason, err := db.recheck(assert)
+			if err != nil {
+				return err
+			}
+			if reason != nil {
+				invalidCb(assert, reason)
+			}
+		}
+	}
+	return nil
+}
+
+// recheck returns the reason the stored assertion is not valid
+// anymore, if any.
+func (db *Database) recheck(assert Assertion) (reason error, err error) {
+	if !assert.SupportedFormat() {
+		return &UnsupportedFormatError{Ref: assert.Ref(), Format: assert.Format()}, nil
+	}
+
+	accKey, err := db.findAccountKey(assert.AuthorityID(), assert.SignKeyID())
+	if err == ErrNotFound {
+		return fmt.Errorf("no matching public key %q for signature by %q", assert.SignKeyID(), assert.AuthorityID()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the signing key was valid when the assertion was added, if it
+	// is not at the signing time now its validity was cut short
+	signTime := accKey.Since()
+	if tstamped, ok := assert.(timestamped); ok {
+		signTime = tstamped.Timestamp()
+		if !accKey.isKeyValidAt(signTime) {
+			return fmt.Errorf("assertion is signed with public key %q from %q that was revoked (key valid since %q until %q)", assert.SignKeyID(), assert.AuthorityID(), accKey.Since(), accKey.Until()), nil
+		}
+	}
+
+	for _, checker := range db.checkers {
+		if err := checker(assert, accKey, db, signTime); err != nil {
+			return err, nil
+		}
+	}
+	return nil, nil
+}
+
 // Add persists the assertion after ensuring it is properly signed and consistent with all the stored knowledge.
 // It will return an error when trying to add an older revision of the assertion than the one currently stored.
 func (db *Database) Add(assert Assertion) error {
@@ -246,6 +246,107 @@ func (chks *checkSuite) TestCheckExpiredPubKey(c *C) {
 	c.Assert(err, ErrorMatches, `assertion is signed with expired public key "[[:alnum:]_-]+" from "canonical"`)
 }
 
+func (chks *checkSuite) TestRecheck(c *C) {
+	trustedKey := testPrivKey0
+
+	err := chks.bs.Put(asserts.TestOnlyType, chks.a)
+	c.Assert(err, IsNil)
+
+	cfg := &asserts.DatabaseConfig{
+		Backstore: chks.bs,
+		Trusted:   []asserts.Assertion{asserts.BootstrapAccountKeyForTest("canonical", trustedKey.PublicKey())},
+	}
+	db, err := asserts.OpenDatabase(cfg)
+	c.Assert(err, IsNil)
+
+	var invalid []asserts.Assertion
+	err = db.Recheck(func(a asserts.Assertion, reason error) {
+		invalid = append(invalid, a)
+	})
+	c.Assert(err, IsNil)
+	c.Check(invalid, HasLen, 0)
+}
+
+func (chks *checkSuite) TestRecheckExpiredPubKey(c *C) {
+	trustedKey := testPrivKey0
+
+	err := chks.bs.Put(asserts.TestOnlyType, chks.a)
+	c.Assert(err, IsNil)
+
+	cfg := &asserts.DatabaseConfig{
+		Backstore: chks.bs,
+		Trusted:   []asserts.Assertion{asserts.ExpiredAccountKeyForTest("canonical", trustedKey.PublicKey())},
+	}
+	db, err := asserts.OpenDatabase(cfg)
+	c.Assert(err, IsNil)
+
+	// the key expired after the assertion was signed
+	var invalid []asserts.Assertion
+	err = db.Recheck(func(a asserts.Assertion, reason error) {
+		invalid = append(invalid, a)
+	})
+	c.Assert(err, IsNil)
+	c.Check(invalid, HasLen, 0)
+}
+
+func (chks *checkSuite) TestRecheckNoPubKey(c *C) {
+	err := chks.bs.Put(asserts.TestOnlyType, chks.a)
+	c.Assert(err, IsNil)
+
+	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{Backstore: chks.bs})
+	c.Assert(err, IsNil)
+
+	var reasons []error
+	err = db.Recheck(func(a asserts.Assertion, reason error) {
+		reasons = append(reasons, reason)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(reasons, HasLen, 1)
+	c.Check(reasons[0], ErrorMatches, `no matching public key "[[:alnum:]_-]+" for signature by "canonical"`)
+}
+
+func (chks *checkSuite) TestRecheckRevokedPubKey(c *C) {
+	storeDB, db := makeStoreAndCheckDB(c)
+
+	acct := assertstest.NewAccount(storeDB, "developer1", nil, "")
+	err := db.Add(acct)
+	c.Assert(err, IsNil)
+
+	var invalid []asserts.Assertion
+	var reasons []error
+	invalidCb := func(a asserts.Assertion, reason error) {
+		invalid = append(invalid, a)
+		reasons = append(reasons, reason)
+	}
+	err = db.Recheck(invalidCb)
+	c.Assert(err, IsNil)
+	c.Check(invalid, HasLen, 0)
+
+	// revoke the store key before the account was signed
+	storeKey, err := db.Find(asserts.AccountKeyType, map[string]string{
+		"public-key-sha3-384": storeDB.KeyID,
+	})
+	c.Assert(err, IsNil)
+	revoked, err := asserts.AssembleAndSignInTest(asserts.AccountKeyType, map[string]interface{}{
+		"authority-id":        "canonical",
+		"account-id":          "canonical",
+		"name":                storeKey.(*asserts.AccountKey).Name(),
+		"public-key-sha3-384": storeDB.KeyID,
+		"revision":            "1",
+		"since":               time.Now().Add(-3 * time.Hour).Format(time.RFC3339),
+		"until":               time.Now().Add(-2 * time.Hour).Format(time.RFC3339),
+	}, storeKey.Body(), testPrivKey0)
+	c.Assert(err, IsNil)
+	err = db.Add(revoked)
+	c.Assert(err, IsNil)
+
+	err = db.Recheck(invalidCb)
+	c.Assert(err, IsNil)
+	c.Assert(invalid, HasLen, 1)
+	c.Check(invalid[0].Ref(), DeepEquals, acct.Ref())
+	c.Check(reasons[0], ErrorMatches, `assertion is signed with public key "[[:alnum:]_-]+" from "canonical" that was revoked .*`)
+}
+
 func (chks *checkSuite) TestCheckForgery(c *C) {
 	trustedKey := testPrivKey0
 
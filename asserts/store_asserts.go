@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package asserts
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Store holds a store assertion, defining the configuration needed to
+// connect a device to a store, in particular to an on-premise proxy
+// store caching the main store.
+type Store struct {
+	assertionBase
+	url       *url.URL
+	timestamp time.Time
+}
+
+// Store returns the identifying name of the store.
+func (store *Store) Store() string {
+	return store.HeaderString("store")
+}
+
+// OperatorID returns the account id of the store's operator.
+func (store *Store) OperatorID() string {
+	return store.HeaderString("operator-id")
+}
+
+// URL returns the base URL of the store's APIs.
+func (store *Store) URL() *url.URL {
+	return store.url
+}
+
+// Timestamp returns the time when the store assertion was issued.
+func (store *Store) Timestamp() time.Time {
+	return store.timestamp
+}
+
+// Implement further consistency checks.
+func (store *Store) checkConsistency(db RODatabase, acck *AccountKey) error {
+	// a store assertion can be issued by a trusted authority or by
+	// the store operator itself
+	if !db.IsTrustedAccount(store.AuthorityID()) && store.AuthorityID() != store.OperatorID() {
+		return fmt.Errorf("store assertion %q is not signed by a directly trusted authority or its operator: %s", store.Store(), store.AuthorityID())
+	}
+	_, err := db.Find(AccountType, map[string]string{
+		"account-id": store.OperatorID(),
+	})
+	if err == ErrNotFound {
+		return fmt.Errorf("store assertion %q does not have a matching account assertion for the operator %q", store.Store(), store.OperatorID())
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// sanity
+var _ consistencyChecker = (*Store)(nil)
+
+// Prerequisites returns references to this store's prerequisite assertions.
+func (store *Store) Prerequisites() []*Ref {
+	return []*Ref{
+		{Type: AccountType, PrimaryKey: []string{store.OperatorID()}},
+	}
+}
+
+func assembleStore(assert assertionBase) (Assertion, error) {
+	_, err := checkNotEmptyString(assert.headers, "operator-id")
+	if err != nil {
+		return nil, err
+	}
+
+	urlStr, err := checkNotEmptyString(assert.headers, "url")
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid \"url\" header: %v", err)
+	}
+	// devices send their credentials to the store
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("\"url\" header scheme must be https: %q", urlStr)
+	}
+	if u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("\"url\" header must be a base URL with a host and without query or fragment: %q", urlStr)
+	}
+
+	timestamp, err := checkRFC3339Date(assert.headers, "timestamp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		assertionBase: assert,
+		url:           u,
+		timestamp:     timestamp,
+	}, nil
+}
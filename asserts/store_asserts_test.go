@@ -0,0 +1,165 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package asserts_test
+
+import (
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+)
+
+var _ = Suite(&storeSuite{})
+
+type storeSuite struct {
+	ts     time.Time
+	tsLine string
+}
+
+func (s *storeSuite) SetUpSuite(c *C) {
+	s.ts = time.Now().Truncate(time.Second).UTC()
+	s.tsLine = "timestamp: " + s.ts.Format(time.RFC3339) + "\n"
+}
+
+const storeExample = "type: store\n" +
+	"authority-id: canonical\n" +
+	"store: store1\n" +
+	"operator-id: op-id1\n" +
+	"url: https://proxy.example.com/store/\n" +
+	"TSLINE" +
+	"body-length: 0\n" +
+	"sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij" +
+	"\n\n" +
+	"AXNpZw=="
+
+func (s *storeSuite) TestDecodeOK(c *C) {
+	encoded := strings.Replace(storeExample, "TSLINE", s.tsLine, 1)
+	a, err := asserts.Decode([]byte(encoded))
+	c.Assert(err, IsNil)
+	c.Check(a.Type(), Equals, asserts.StoreType)
+	store := a.(*asserts.Store)
+	c.Check(store.AuthorityID(), Equals, "canonical")
+	c.Check(store.Store(), Equals, "store1")
+	c.Check(store.OperatorID(), Equals, "op-id1")
+	c.Check(store.URL().String(), Equals, "https://proxy.example.com/store/")
+	c.Check(store.Timestamp(), Equals, s.ts)
+}
+
+const (
+	storeErrPrefix = "assertion store: "
+)
+
+func (s *storeSuite) TestDecodeInvalid(c *C) {
+	encoded := strings.Replace(storeExample, "TSLINE", s.tsLine, 1)
+
+	invalidTests := []struct{ original, invalid, expectedErr string }{
+		{"store: store1\n", "", `"store" header is mandatory`},
+		{"store: store1\n", "store: \n", `"store" header should not be empty`},
+		{"operator-id: op-id1\n", "", `"operator-id" header is mandatory`},
+		{"operator-id: op-id1\n", "operator-id: \n", `"operator-id" header should not be empty`},
+		{"url: https://proxy.example.com/store/\n", "", `"url" header is mandatory`},
+		{"url: https://proxy.example.com/store/\n", "url: \n", `"url" header should not be empty`},
+		{"url: https://proxy.example.com/store/\n", "url: ftp://proxy.example.com/\n", `"url" header scheme must be https: "ftp://proxy.example.com/"`},
+		{"url: https://proxy.example.com/store/\n", "url: http://proxy.example.com/\n", `"url" header scheme must be https: "http://proxy.example.com/"`},
+		{"url: https://proxy.example.com/store/\n", "url: https:///store/\n", `"url" header must be a base URL with a host and without query or fragment: "https:///store/"`},
+		{"url: https://proxy.example.com/store/\n", "url: https://proxy.example.com/?a=b\n", `"url" header must be a base URL with a host and without query or fragment: .*`},
+		{s.tsLine, "", `"timestamp" header is mandatory`},
+		{s.tsLine, "timestamp: 12:30\n", `"timestamp" header is not a RFC3339 date: .*`},
+	}
+
+	for _, test := range invalidTests {
+		invalid := strings.Replace(encoded, test.original, test.invalid, 1)
+		_, err := asserts.Decode([]byte(invalid))
+		c.Check(err, ErrorMatches, storeErrPrefix+test.expectedErr)
+	}
+}
+
+func (s *storeSuite) TestCheckSignedByTrusted(c *C) {
+	storeDB, db := makeStoreAndCheckDB(c)
+
+	operator := assertstest.NewAccount(storeDB, "operator1", map[string]interface{}{
+		"account-id": "op-id1",
+	}, "")
+	err := db.Add(operator)
+	c.Assert(err, IsNil)
+
+	store, err := storeDB.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "store1",
+		"operator-id": "op-id1",
+		"url":         "https://proxy.example.com/",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+
+	err = db.Check(store)
+	c.Assert(err, IsNil)
+}
+
+func (s *storeSuite) TestCheckSignedByOperator(c *C) {
+	storeDB, db := makeStoreAndCheckDB(c)
+	operatorDB := setup3rdPartySigning(c, "operator1", storeDB, db)
+
+	store, err := operatorDB.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "store1",
+		"operator-id": "operator1",
+		"url":         "https://proxy.example.com/",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+
+	err = db.Check(store)
+	c.Assert(err, IsNil)
+	c.Check(store.(*asserts.Store).Prerequisites(), DeepEquals, []*asserts.Ref{
+		{Type: asserts.AccountType, PrimaryKey: []string{"operator1"}},
+	})
+}
+
+func (s *storeSuite) TestCheckUntrustedAuthority(c *C) {
+	storeDB, db := makeStoreAndCheckDB(c)
+	otherDB := setup3rdPartySigning(c, "other", storeDB, db)
+
+	store, err := otherDB.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "store1",
+		"operator-id": "canonical",
+		"url":         "https://proxy.example.com/",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+
+	err = db.Check(store)
+	c.Assert(err, ErrorMatches, `store assertion "store1" is not signed by a directly trusted authority or its operator: other`)
+}
+
+func (s *storeSuite) TestCheckMissingOperatorAccount(c *C) {
+	storeDB, db := makeStoreAndCheckDB(c)
+
+	store, err := storeDB.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "store1",
+		"operator-id": "op-id1",
+		"url":         "https://proxy.example.com/",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+
+	err = db.Check(store)
+	c.Assert(err, ErrorMatches, `store assertion "store1" does not have a matching account assertion for the operator "op-id1"`)
+}
@@ -0,0 +1,623 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package boot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/partition"
+	"github.com/snapcore/snapd/snap"
+)
+
+var mountInfoPath = "/proc/self/mountinfo"
+
+// gadgetBackupList is the file in a gadget backup directory listing
+// what was backed up.
+const gadgetBackupList = "backup.json"
+
+// bootVarNames are the variables snapd keeps in the bootloader
+// environment, they are carried over when the gadget replaces it.
+var bootVarNames = []string{"snap_mode", "snap_core", "snap_try_core", "snap_kernel", "snap_try_kernel", "snap_gadget", "snap_try_gadget"}
+
+// gadgetAssetUpdate is a file, or some raw content of a volume, to
+// update from the new gadget.
+type gadgetAssetUpdate struct {
+	// Path is the file to update, or the device of the volume for
+	// raw content.
+	Path string `json:"path"`
+	// Raw is set for content written at Offset of the device.
+	Raw    bool  `json:"raw,omitempty"`
+	Offset int64 `json:"offset,omitempty"`
+	// Backup is the name of the file in the backup directory with
+	// the previous content, or empty if there was no file at Path.
+	Backup string `json:"backup,omitempty"`
+
+	data []byte
+}
+
+// current returns what the update replaces, or nil if there is no
+// file to replace.
+func (u *gadgetAssetUpdate) current() ([]byte, error) {
+	if !u.Raw {
+		data, err := ioutil.ReadFile(u.Path)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		return data, err
+	}
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, len(u.data))
+	if _, err := f.ReadAt(data, u.Offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (u *gadgetAssetUpdate) write(data []byte) error {
+	if !u.Raw {
+		if err := os.MkdirAll(filepath.Dir(u.Path), 0755); err != nil {
+			return err
+		}
+		return osutil.AtomicWriteFile(u.Path, data, 0644, 0)
+	}
+	f, err := os.OpenFile(u.Path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(data, u.Offset); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// check verifies that what the update replaces is now data.
+func (u *gadgetAssetUpdate) check(data []byte) error {
+	written, err := u.current()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(written, data) {
+		if u.Raw {
+			return fmt.Errorf("content at offset %d of %s does not match what was written", u.Offset, u.Path)
+		}
+		return fmt.Errorf("%s does not match what was written", u.Path)
+	}
+	return nil
+}
+
+// UpdateGadgetAssets updates the grub config and the content of the
+// volumes of the current gadget snap that changed in the update of
+// it, bootloader binaries included, after checking that the volumes
+// of both are compatible. What is updated is first backed up to
+// backupDir, checked once written, and restored if the update fails.
+// The boot variables set on the system are kept when the bootloader
+// environment gets replaced. If anything was updated, a try boot of
+// the new gadget is set up, so that the system is only committed to
+// it once it boots successfully.
+func UpdateGadgetAssets(current, update *snap.Info, backupDir string) error {
+	// a previous attempt was interrupted
+	if err := restoreGadgetBackup(backupDir); err != nil {
+		return fmt.Errorf("cannot update gadget assets: %v", err)
+	}
+
+	currentGadget, err := snap.ReadGadgetInfo(current, false)
+	if err != nil {
+		return fmt.Errorf("cannot update gadget assets: %v", err)
+	}
+	updateGadget, err := snap.ReadGadgetInfo(update, false)
+	if err != nil {
+		return fmt.Errorf("cannot update gadget assets: %v", err)
+	}
+	if err := checkGadgetUpdate(currentGadget, updateGadget); err != nil {
+		return fmt.Errorf("cannot update gadget assets: %v", err)
+	}
+
+	updates, err := gadgetAssetUpdates(current, update, updateGadget)
+	if err != nil {
+		return fmt.Errorf("cannot update gadget assets: %v", err)
+	}
+	if len(updates) == 0 {
+		return setGadgetBoot(current, update, false)
+	}
+
+	bootloader, err := partition.FindBootloader()
+	if err != nil {
+		return fmt.Errorf("cannot update gadget assets: %s", err)
+	}
+	bootVars, err := bootloader.GetBootVars(bootVarNames...)
+	if err != nil {
+		return fmt.Errorf("cannot update gadget assets: %v", err)
+	}
+
+	if err := backupGadgetAssets(updates, backupDir); err != nil {
+		os.RemoveAll(backupDir)
+		return fmt.Errorf("cannot back up gadget assets: %v", err)
+	}
+	if err := writeGadgetAssets(updates, bootloader, bootVars); err != nil {
+		if rerr := restoreGadgetBackup(backupDir); rerr != nil {
+			return fmt.Errorf("cannot update gadget assets: %v, and cannot restore them: %v", err, rerr)
+		}
+		if rerr := keepBootVars(bootloader, bootVars); rerr != nil {
+			return fmt.Errorf("cannot update gadget assets: %v, and cannot restore boot variables: %v", err, rerr)
+		}
+		return fmt.Errorf("cannot update gadget assets: %v", err)
+	}
+
+	return setGadgetBoot(current, update, true)
+}
+
+// writeGadgetAssets writes and checks the updates, then sets back the
+// boot variables in case the bootloader environment was replaced.
+func writeGadgetAssets(updates []*gadgetAssetUpdate, bootloader partition.Bootloader, bootVars map[string]string) error {
+	for _, u := range updates {
+		if err := u.write(u.data); err != nil {
+			return err
+		}
+		if err := u.check(u.data); err != nil {
+			return err
+		}
+	}
+	return keepBootVars(bootloader, bootVars)
+}
+
+// keepBootVars sets back the boot variables that replacing the
+// bootloader environment changed.
+func keepBootVars(bootloader partition.Bootloader, bootVars map[string]string) error {
+	m, err := bootloader.GetBootVars(bootVarNames...)
+	if err != nil {
+		return err
+	}
+	changed := make(map[string]string)
+	for name, value := range bootVars {
+		if m[name] != value {
+			changed[name] = value
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return bootloader.SetBootVars(changed)
+}
+
+// UndoGadgetAssetsUpdate restores the gadget assets backed up in
+// backupDir by UpdateGadgetAssets and goes back to booting with the
+// current gadget.
+func UndoGadgetAssetsUpdate(current, update *snap.Info, backupDir string) error {
+	bootloader, err := partition.FindBootloader()
+	if err != nil {
+		return fmt.Errorf("cannot restore gadget boot: %s", err)
+	}
+	bootVars, err := bootloader.GetBootVars(bootVarNames...)
+	if err != nil {
+		return err
+	}
+	if err := restoreGadgetBackup(backupDir); err != nil {
+		return fmt.Errorf("cannot restore gadget assets: %v", err)
+	}
+	// the backup of the bootloader environment has the boot
+	// variables of before the update
+	if err := keepBootVars(bootloader, bootVars); err != nil {
+		return err
+	}
+
+	m, err := bootloader.GetBootVars("snap_mode", "snap_try_core", "snap_try_kernel", "snap_try_gadget", "snap_gadget")
+	if err != nil {
+		return err
+	}
+	blobName := filepath.Base(update.MountFile())
+	vars := make(map[string]string)
+	if m["snap_try_gadget"] == blobName {
+		vars["snap_try_gadget"] = ""
+		if m["snap_mode"] == "try" && m["snap_try_core"] == "" && m["snap_try_kernel"] == "" {
+			vars["snap_mode"] = ""
+		}
+	}
+	if m["snap_gadget"] == blobName {
+		vars["snap_gadget"] = filepath.Base(current.MountFile())
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	return bootloader.SetBootVars(vars)
+}
+
+// setGadgetBoot records the new gadget in the boot environment: as the
+// one to try on the next boot if its assets were updated, with the
+// current one to fall back to, or directly as the good one otherwise.
+func setGadgetBoot(current, update *snap.Info, try bool) error {
+	bootloader, err := partition.FindBootloader()
+	if err != nil {
+		return fmt.Errorf("cannot set next boot: %s", err)
+	}
+	m, err := bootloader.GetBootVars("snap_gadget", "snap_try_gadget")
+	if err != nil {
+		return err
+	}
+
+	blobName := filepath.Base(update.MountFile())
+	if m["snap_gadget"] == blobName {
+		if m["snap_try_gadget"] == "" {
+			return nil
+		}
+		// left over from a boot that fell back
+		return bootloader.SetBootVars(map[string]string{"snap_try_gadget": ""})
+	}
+	if !try {
+		return bootloader.SetBootVars(map[string]string{
+			"snap_gadget":     blobName,
+			"snap_try_gadget": "",
+		})
+	}
+	vars := map[string]string{
+		"snap_try_gadget": blobName,
+		"snap_mode":       "try",
+	}
+	// the first update of the gadget assets has no good gadget
+	// recorded yet, without it a failed boot would not be reverted
+	if currentBlob := filepath.Base(current.MountFile()); m["snap_gadget"] != currentBlob {
+		vars["snap_gadget"] = currentBlob
+	}
+	return bootloader.SetBootVars(vars)
+}
+
+// checkGadgetUpdate checks that the volumes of the update of a gadget
+// are laid out like the current ones: only their content can change.
+func checkGadgetUpdate(current, update *snap.GadgetInfo) error {
+	if len(current.Volumes) != len(update.Volumes) {
+		return fmt.Errorf("volumes of the new gadget do not match the current ones")
+	}
+	for name, cv := range current.Volumes {
+		uv, ok := update.Volumes[name]
+		if !ok {
+			return fmt.Errorf("volume %q is missing from the new gadget", name)
+		}
+		if cv.VolumeSchema() != uv.VolumeSchema() || cv.ID != uv.ID || cv.Bootloader != uv.Bootloader {
+			return fmt.Errorf("volume %q of the new gadget is not compatible with the current one", name)
+		}
+		cs, err := snap.LayoutVolume(&cv)
+		if err != nil {
+			return err
+		}
+		us, err := snap.LayoutVolume(&uv)
+		if err != nil {
+			return err
+		}
+		if len(cs) != len(us) {
+			return fmt.Errorf("volume %q of the new gadget has %d structures instead of %d", name, len(us), len(cs))
+		}
+		for i := range cs {
+			c, u := &cs[i], &us[i]
+			if !sameLayout(c, u) {
+				return fmt.Errorf("structure %s of volume %q of the new gadget is not compatible with the current one", u, name)
+			}
+		}
+	}
+	return nil
+}
+
+func sameLayout(c, u *snap.LaidOutStructure) bool {
+	if c.Name != u.Name || c.Label != u.Label || c.Type != u.Type || c.ID != u.ID || c.Filesystem != u.Filesystem {
+		return false
+	}
+	if c.StartOffset != u.StartOffset || c.Size != u.Size {
+		return false
+	}
+	if c.OffsetWrite == nil || u.OffsetWrite == nil {
+		return c.OffsetWrite == u.OffsetWrite
+	}
+	return *c.OffsetWrite == *u.OffsetWrite
+}
+
+// gadgetAssetUpdates returns what differs between the assets of the
+// update of the gadget and what is installed.
+func gadgetAssetUpdates(current, update *snap.Info, updateGadget *snap.GadgetInfo) ([]*gadgetAssetUpdate, error) {
+	var updates []*gadgetAssetUpdate
+	add := func(u *gadgetAssetUpdate) error {
+		data, err := u.current()
+		if err != nil {
+			return err
+		}
+		if data == nil || !bytes.Equal(data, u.data) {
+			updates = append(updates, u)
+		}
+		return nil
+	}
+
+	// the grub config, only if the gadget changed it, it is usually
+	// modified on the system; the u-boot one is its environment,
+	// which is only replaced by the content of the volumes
+	updateConfig, systemConfig, err := partition.FindBootConfig(update.MountDir())
+	if err == nil && filepath.Base(updateConfig) == "grub.conf" {
+		currentConfig, _, _ := partition.FindBootConfig(current.MountDir())
+		data, err := ioutil.ReadFile(updateConfig)
+		if err != nil {
+			return nil, err
+		}
+		currentData, err := ioutil.ReadFile(currentConfig)
+		if err != nil || !bytes.Equal(data, currentData) {
+			updates = append(updates, &gadgetAssetUpdate{Path: systemConfig, data: data})
+		}
+	}
+
+	names := make([]string, 0, len(updateGadget.Volumes))
+	for name := range updateGadget.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vol := updateGadget.Volumes[name]
+		structures, err := snap.LayoutVolume(&vol)
+		if err != nil {
+			return nil, err
+		}
+		var device string
+		for i := range structures {
+			ls := &structures[i]
+			if ls.HasFilesystem() {
+				if len(ls.VolumeStructure.Content) == 0 {
+					continue
+				}
+				mountPoint, err := structureMountPoint(ls)
+				if err != nil {
+					return nil, err
+				}
+				for _, vc := range ls.VolumeStructure.Content {
+					if err := contentUpdates(update.MountDir(), mountPoint, vc, add); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			if len(ls.Content) == 0 {
+				continue
+			}
+			if device == "" {
+				if device, err = volumeDevice(name, structures); err != nil {
+					return nil, err
+				}
+			}
+			if err := rawContentUpdates(update.MountDir(), device, ls, structures, add); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return updates, nil
+}
+
+// contentUpdates adds the updates of the files of some content of a
+// structure with a filesystem, mounted at mountPoint.
+func contentUpdates(gadgetDir, mountPoint string, vc snap.VolumeContent, add func(*gadgetAssetUpdate) error) error {
+	src := filepath.Join(gadgetDir, vc.Source)
+	dst := filepath.Join(mountPoint, vc.Target)
+	if strings.HasSuffix(vc.Target, "/") && !strings.HasSuffix(vc.Source, "/") {
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return add(&gadgetAssetUpdate{Path: filepath.Join(dst, rel), data: data})
+	})
+}
+
+// rawContentUpdates adds the updates of the raw content of a
+// structure, and of where it is written with offset-write.
+func rawContentUpdates(gadgetDir, device string, ls *snap.LaidOutStructure, structures []snap.LaidOutStructure, add func(*gadgetAssetUpdate) error) error {
+	var next uint64
+	for _, lc := range ls.Content {
+		offset := next
+		if lc.StartOffset != nil {
+			offset = *lc.StartOffset
+		}
+		data, err := ioutil.ReadFile(filepath.Join(gadgetDir, lc.Image))
+		if err != nil {
+			return err
+		}
+		size := lc.Size
+		if size == 0 {
+			size = uint64(len(data))
+		}
+		if uint64(len(data)) > size || offset+size > ls.Size {
+			return fmt.Errorf("content %q does not fit in structure %s", lc.Image, ls)
+		}
+		start := ls.StartOffset + offset
+		if err := add(&gadgetAssetUpdate{Path: device, Raw: true, Offset: int64(start), data: data}); err != nil {
+			return err
+		}
+		if lc.OffsetWrite != nil {
+			var base uint64
+			for _, s := range structures {
+				if s.Name == lc.OffsetWrite.RelativeTo && s.Name != "" {
+					base = s.StartOffset
+				}
+			}
+			value := make([]byte, 4)
+			binary.LittleEndian.PutUint32(value, uint32(start/snap.SectorSize))
+			if err := add(&gadgetAssetUpdate{Path: device, Raw: true, Offset: int64(base + lc.OffsetWrite.Offset), data: value}); err != nil {
+				return err
+			}
+		}
+		next = offset + size
+	}
+	return nil
+}
+
+// structureDevice returns the device of the partition of a structure
+// with a filesystem, found by its label.
+func structureDevice(ls *snap.LaidOutStructure) (string, error) {
+	if ls.Label == "" {
+		return "", fmt.Errorf("cannot find the device of structure %s: structure has no label", ls)
+	}
+	device, err := filepath.EvalSymlinks(filepath.Join(dirs.GlobalRootDir, "/dev/disk/by-label", ls.Label))
+	if err != nil {
+		return "", fmt.Errorf("cannot find the device of structure %s: %v", ls, err)
+	}
+	return device, nil
+}
+
+// structureMountPoint returns where the filesystem of a structure is
+// mounted.
+func structureMountPoint(ls *snap.LaidOutStructure) (string, error) {
+	device, err := structureDevice(ls)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := strings.Fields(scanner.Text())
+		// the mount source is the second field after the "-"
+		// separator, see proc(5)
+		i := 6
+		for i < len(l) && l[i] != "-" {
+			i++
+		}
+		if i+2 >= len(l) {
+			continue
+		}
+		if l[i+2] == device {
+			return l[4], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("cannot find where structure %s is mounted", ls)
+}
+
+// volumeDevice returns the device of the whole volume, the disk of the
+// partitions of its structures with a filesystem.
+func volumeDevice(name string, structures []snap.LaidOutStructure) (string, error) {
+	for i := range structures {
+		ls := &structures[i]
+		if !ls.HasFilesystem() || ls.Label == "" {
+			continue
+		}
+		part, err := structureDevice(ls)
+		if err != nil {
+			continue
+		}
+		// /sys/class/block/<partition> links to .../<disk>/<partition>
+		sysfsPath, err := filepath.EvalSymlinks(filepath.Join(dirs.GlobalRootDir, "/sys/class/block", filepath.Base(part)))
+		if err != nil {
+			continue
+		}
+		return filepath.Join(filepath.Dir(part), filepath.Base(filepath.Dir(sysfsPath))), nil
+	}
+	return "", fmt.Errorf("cannot find the device of volume %q", name)
+}
+
+// backupGadgetAssets saves what the updates are going to replace to
+// backupDir.
+func backupGadgetAssets(updates []*gadgetAssetUpdate, backupDir string) error {
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return err
+	}
+	for i, u := range updates {
+		data, err := u.current()
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		u.Backup = strconv.Itoa(i)
+		if err := osutil.AtomicWriteFile(filepath.Join(backupDir, u.Backup), data, 0600, 0); err != nil {
+			return err
+		}
+	}
+	list, err := json.Marshal(updates)
+	if err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(filepath.Join(backupDir, gadgetBackupList), list, 0600, 0)
+}
+
+// restoreGadgetBackup puts back what was backed up to backupDir, if
+// anything, and removes it.
+func restoreGadgetBackup(backupDir string) error {
+	list, err := ioutil.ReadFile(filepath.Join(backupDir, gadgetBackupList))
+	if os.IsNotExist(err) {
+		return os.RemoveAll(backupDir)
+	}
+	if err != nil {
+		return err
+	}
+	var updates []*gadgetAssetUpdate
+	if err := json.Unmarshal(list, &updates); err != nil {
+		return fmt.Errorf("cannot decode gadget backup: %v", err)
+	}
+
+	for i := len(updates) - 1; i >= 0; i-- {
+		u := updates[i]
+		if u.Backup == "" {
+			if err := os.Remove(u.Path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(backupDir, u.Backup))
+		if err != nil {
+			return err
+		}
+		if err := u.write(data); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(backupDir)
+}
@@ -0,0 +1,506 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package boot_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/boot/boottest"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/partition"
+	"github.com/snapcore/snapd/snap"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+type gadgetSuite struct {
+	root       string
+	bootloader *boottest.MockBootloader
+	mountDir   string
+	disk       string
+	backupDir  string
+
+	current, update *snap.Info
+
+	restore func()
+}
+
+var _ = Suite(&gadgetSuite{})
+
+const gadgetYaml = `
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - type: mbr
+        size: 440
+        content:
+          - image: pc-boot.img
+      - type: 21686148-6449-6E6F-744E-656564454649
+        size: 1M
+        content:
+          - image: pc-core.img
+            offset-write: 92
+      - label: system-boot
+        type: C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+        filesystem: vfat
+        size: 50M
+        content:
+          - source: grubx64.efi
+            target: EFI/boot/grubx64.efi
+          - source: ubuntu/
+            target: EFI/ubuntu/
+`
+
+func (s *gadgetSuite) SetUpTest(c *C) {
+	root, err := filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+	s.root = root
+	dirs.SetRootDir(s.root)
+	s.bootloader = boottest.NewMockBootloader("mock", c.MkDir())
+	partition.ForceBootloader(s.bootloader)
+	s.backupDir = filepath.Join(dirs.SnapGadgetBackupDir, "1")
+
+	// the disk, its system-boot partition and where it is mounted
+	s.disk = filepath.Join(s.root, "/dev/sda")
+	s.mountDir = filepath.Join(s.root, "/boot/efi")
+	for _, dir := range []string{"/dev/disk/by-label", "/sys/class/block", "/sys/devices/pci0/block/sda/sda1", "/boot/efi"} {
+		c.Assert(os.MkdirAll(filepath.Join(s.root, dir), 0755), IsNil)
+	}
+	c.Assert(ioutil.WriteFile(s.disk, make([]byte, 2<<20), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "/dev/sda1"), nil, 0644), IsNil)
+	c.Assert(os.Symlink("../../sda1", filepath.Join(s.root, "/dev/disk/by-label/system-boot")), IsNil)
+	c.Assert(os.Symlink("../../devices/pci0/block/sda/sda1", filepath.Join(s.root, "/sys/class/block/sda1")), IsNil)
+	mountInfo := filepath.Join(s.root, "mountinfo")
+	c.Assert(ioutil.WriteFile(mountInfo, []byte(fmt.Sprintf(`
+21 1 8:2 / / rw,relatime shared:1 - ext4 /dev/sda2 rw,data=ordered
+36 21 8:1 / %s rw,relatime shared:7 - vfat %s rw,fmask=0022
+`, s.mountDir, filepath.Join(s.root, "/dev/sda1"))), 0644), IsNil)
+	s.restore = boot.MockMountInfoPath(mountInfo)
+
+	s.current = s.makeGadget(c, snap.R(1), map[string]string{
+		"grub.conf":        "",
+		"pc-boot.img":      "boot code",
+		"pc-core.img":      "core image",
+		"grubx64.efi":      "grub",
+		"ubuntu/grub.cfg":  "menuentry",
+		"ubuntu/grubenv":   "env",
+		"meta/gadget.yaml": gadgetYaml,
+	})
+	// what is installed
+	s.writeDisk(c, 0, "boot code")
+	s.writeDisk(c, 1<<20, "core image")
+	s.writeDisk(c, 92, "\x00\x08\x00\x00")
+	s.writeMounted(c, "EFI/boot/grubx64.efi", "grub")
+	s.writeMounted(c, "EFI/ubuntu/grub.cfg", "menuentry")
+	s.writeMounted(c, "EFI/ubuntu/grubenv", "env modified on the system")
+}
+
+func (s *gadgetSuite) TearDownTest(c *C) {
+	s.restore()
+	dirs.SetRootDir("")
+	partition.ForceBootloader(nil)
+}
+
+func (s *gadgetSuite) makeGadget(c *C, rev snap.Revision, files map[string]string) *snap.Info {
+	info := &snap.Info{
+		SideInfo: snap.SideInfo{RealName: "pc", Revision: rev},
+		Type:     snap.TypeGadget,
+	}
+	for name, content := range files {
+		fn := filepath.Join(info.MountDir(), name)
+		c.Assert(os.MkdirAll(filepath.Dir(fn), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(fn, []byte(content), 0644), IsNil)
+	}
+	return info
+}
+
+func (s *gadgetSuite) writeDisk(c *C, offset int64, data string) {
+	f, err := os.OpenFile(s.disk, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = f.WriteAt([]byte(data), offset)
+	c.Assert(err, IsNil)
+}
+
+func (s *gadgetSuite) readDisk(c *C, offset int64, size int) string {
+	f, err := os.Open(s.disk)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	b := make([]byte, size)
+	_, err = f.ReadAt(b, offset)
+	c.Assert(err, IsNil)
+	return string(b)
+}
+
+func (s *gadgetSuite) writeMounted(c *C, name, content string) {
+	fn := filepath.Join(s.mountDir, name)
+	c.Assert(os.MkdirAll(filepath.Dir(fn), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(fn, []byte(content), 0644), IsNil)
+}
+
+func (s *gadgetSuite) checkMounted(c *C, name, content string) {
+	data, err := ioutil.ReadFile(filepath.Join(s.mountDir, name))
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, content)
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsAndUndo(c *C) {
+	s.update = s.makeGadget(c, snap.R(2), map[string]string{
+		"grub.conf":        "updated config",
+		"pc-boot.img":      "boot code",
+		"pc-core.img":      "updated core image",
+		"grubx64.efi":      "updated grub",
+		"ubuntu/grub.cfg":  "menuentry",
+		"ubuntu/grubenv":   "env",
+		"ubuntu/new.cfg":   "new",
+		"meta/gadget.yaml": gadgetYaml,
+	})
+	systemConfig := filepath.Join(s.root, "/boot/grub/grub.cfg")
+	c.Assert(os.MkdirAll(filepath.Dir(systemConfig), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(systemConfig, nil, 0644), IsNil)
+
+	err := boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+
+	c.Check(s.readDisk(c, 0, 9), Equals, "boot code")
+	c.Check(s.readDisk(c, 1<<20, 18), Equals, "updated core image")
+	c.Check(s.readDisk(c, 92, 4), Equals, "\x00\x08\x00\x00")
+	s.checkMounted(c, "EFI/boot/grubx64.efi", "updated grub")
+	s.checkMounted(c, "EFI/ubuntu/grub.cfg", "menuentry")
+	s.checkMounted(c, "EFI/ubuntu/grubenv", "env")
+	s.checkMounted(c, "EFI/ubuntu/new.cfg", "new")
+	data, err := ioutil.ReadFile(systemConfig)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "updated config")
+	c.Check(osutil.FileExists(filepath.Join(s.backupDir, "backup.json")), Equals, true)
+
+	// the new gadget gets tried on the next boot, falling back to
+	// the current one which gets recorded as the good one on this
+	// first update
+	c.Check(s.bootloader.BootVars, DeepEquals, map[string]string{
+		"snap_gadget":     "pc_1.snap",
+		"snap_try_gadget": "pc_2.snap",
+		"snap_mode":       "try",
+	})
+	c.Check(boot.KernelOrOsRebootRequired(s.update), Equals, true)
+
+	err = boot.UndoGadgetAssetsUpdate(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+
+	c.Check(s.readDisk(c, 1<<20, 18), Equals, "core image\x00\x00\x00\x00\x00\x00\x00\x00")
+	s.checkMounted(c, "EFI/boot/grubx64.efi", "grub")
+	s.checkMounted(c, "EFI/ubuntu/grubenv", "env modified on the system")
+	c.Check(osutil.FileExists(filepath.Join(s.mountDir, "EFI/ubuntu/new.cfg")), Equals, false)
+	data, err = ioutil.ReadFile(systemConfig)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "")
+	c.Check(osutil.FileExists(s.backupDir), Equals, false)
+	c.Check(s.bootloader.BootVars, DeepEquals, map[string]string{
+		"snap_gadget":     "pc_1.snap",
+		"snap_try_gadget": "",
+		"snap_mode":       "",
+	})
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsKeepsGoodGadget(c *C) {
+	s.update = s.makeGadget(c, snap.R(2), map[string]string{
+		"pc-boot.img":      "boot code",
+		"pc-core.img":      "core image",
+		"grubx64.efi":      "grub",
+		"ubuntu/grub.cfg":  "updated menuentry",
+		"meta/gadget.yaml": gadgetYaml,
+	})
+	s.bootloader.BootVars["snap_gadget"] = "pc_1.snap"
+
+	err := boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+
+	s.checkMounted(c, "EFI/ubuntu/grub.cfg", "updated menuentry")
+	c.Check(s.bootloader.BootVars, DeepEquals, map[string]string{
+		"snap_gadget":     "pc_1.snap",
+		"snap_try_gadget": "pc_2.snap",
+		"snap_mode":       "try",
+	})
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsMovedContent(c *C) {
+	const bareYaml = `
+      - type: bare
+        size: 1M
+        content:
+          - image: first.img
+          - image: second.img
+            offset-write: 96
+`
+	s.current = s.makeGadget(c, snap.R(1), map[string]string{
+		"first.img":        "first",
+		"second.img":       "second",
+		"meta/gadget.yaml": gadgetYaml + bareYaml,
+	})
+	// the bare structure follows system-boot, at 52M
+	const start = 52 << 20
+	c.Assert(os.Truncate(s.disk, 53<<20), IsNil)
+	s.writeDisk(c, start, "firstsecond")
+	s.writeDisk(c, 96, offsetWriteValue(start/512))
+
+	// a bigger first image moves the second one to the next sector
+	s.update = s.makeGadget(c, snap.R(2), map[string]string{
+		"pc-boot.img":      "boot code",
+		"pc-core.img":      "core image",
+		"grubx64.efi":      "grub",
+		"ubuntu/grub.cfg":  "menuentry",
+		"ubuntu/grubenv":   "env modified on the system",
+		"first.img":        strings.Repeat("x", 600),
+		"second.img":       "second",
+		"meta/gadget.yaml": gadgetYaml + bareYaml,
+	})
+
+	err := boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+
+	c.Check(s.readDisk(c, start, 606), Equals, strings.Repeat("x", 600)+"second")
+	c.Check(s.readDisk(c, 96, 4), Equals, offsetWriteValue(start/512+1))
+
+	err = boot.UndoGadgetAssetsUpdate(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+
+	c.Check(s.readDisk(c, start, 11), Equals, "firstsecond")
+	c.Check(s.readDisk(c, 96, 4), Equals, offsetWriteValue(start/512))
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsFailureRestores(c *C) {
+	s.update = s.makeGadget(c, snap.R(2), map[string]string{
+		"pc-boot.img":        "boot code",
+		"pc-core.img":        "updated core image",
+		"grubx64.efi":        "updated grub",
+		"ubuntu/grub.cfg":    "menuentry",
+		"ubuntu/new/new.cfg": "new",
+		"meta/gadget.yaml":   gadgetYaml,
+	})
+	// the directory of new.cfg cannot be created
+	c.Assert(os.Symlink(filepath.Join(s.root, "missing"), filepath.Join(s.mountDir, "EFI/ubuntu/new")), IsNil)
+
+	err := boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, ErrorMatches, "cannot update gadget assets: mkdir .*/EFI/ubuntu/new: file exists")
+
+	// what was updated before got restored
+	c.Check(s.readDisk(c, 1<<20, 18), Equals, "core image\x00\x00\x00\x00\x00\x00\x00\x00")
+	s.checkMounted(c, "EFI/boot/grubx64.efi", "grub")
+	c.Check(osutil.FileExists(s.backupDir), Equals, false)
+	// and there is nothing to try
+	c.Check(s.bootloader.BootVars, HasLen, 0)
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsUbootKeepsBootVars(c *C) {
+	partition.ForceBootloader(nil)
+
+	const ubootYaml = `
+volumes:
+  pi:
+    bootloader: u-boot
+    structure:
+      - label: system-boot
+        type: 0C
+        filesystem: vfat
+        size: 128M
+        content:
+          - source: boot-assets/
+            target: /
+`
+	mountDir := filepath.Join(s.root, "/boot/uboot")
+	c.Assert(os.MkdirAll(mountDir, 0755), IsNil)
+	mountInfo := filepath.Join(s.root, "mountinfo")
+	c.Assert(ioutil.WriteFile(mountInfo, []byte(fmt.Sprintf(`
+21 1 8:2 / / rw,relatime shared:1 - ext4 /dev/sda2 rw,data=ordered
+36 21 8:1 / %s rw,relatime shared:7 - vfat %s rw,fmask=0022
+`, mountDir, filepath.Join(s.root, "/dev/sda1"))), 0644), IsNil)
+
+	makeEnv := func(path string, vars map[string]string) string {
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+		env, err := uenv.Create(path, 4096)
+		c.Assert(err, IsNil)
+		for k, v := range vars {
+			env.Set(k, v)
+		}
+		c.Assert(env.Save(), IsNil)
+		data, err := ioutil.ReadFile(path)
+		c.Assert(err, IsNil)
+		return string(data)
+	}
+	dir := c.MkDir()
+	oldEnv := makeEnv(filepath.Join(dir, "old.env"), map[string]string{"bootcmd": "old"})
+	newEnv := makeEnv(filepath.Join(dir, "new.env"), map[string]string{"bootcmd": "new"})
+	s.current = s.makeGadget(c, snap.R(1), map[string]string{
+		"uboot.conf":            "",
+		"boot-assets/uboot.env": oldEnv,
+		"meta/gadget.yaml":      ubootYaml,
+	})
+	s.update = s.makeGadget(c, snap.R(2), map[string]string{
+		"uboot.conf":            "",
+		"boot-assets/uboot.env": newEnv,
+		"meta/gadget.yaml":      ubootYaml,
+	})
+	// what is installed, with the boot variables set on the system
+	envFile := filepath.Join(mountDir, "uboot.env")
+	makeEnv(envFile, map[string]string{
+		"bootcmd":     "old",
+		"snap_core":   "core_1.snap",
+		"snap_kernel": "pi-kernel_1.snap",
+	})
+	checkEnv := func(expected string) {
+		env, err := uenv.Open(envFile)
+		c.Assert(err, IsNil)
+		c.Check(env.String(), Equals, expected)
+	}
+
+	err := boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+
+	// the environment of the new gadget, with the boot variables
+	checkEnv("bootcmd=new\nsnap_core=core_1.snap\nsnap_gadget=pc_1.snap\nsnap_kernel=pi-kernel_1.snap\nsnap_mode=try\nsnap_try_gadget=pc_2.snap\n")
+
+	err = boot.UndoGadgetAssetsUpdate(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+
+	checkEnv("bootcmd=old\nsnap_core=core_1.snap\nsnap_gadget=pc_1.snap\nsnap_kernel=pi-kernel_1.snap\n")
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsUnchanged(c *C) {
+	s.update = s.makeGadget(c, snap.R(2), map[string]string{
+		"pc-boot.img":      "boot code",
+		"pc-core.img":      "core image",
+		"grubx64.efi":      "grub",
+		"ubuntu/grub.cfg":  "menuentry",
+		"meta/gadget.yaml": gadgetYaml,
+	})
+	c.Assert(os.Remove(filepath.Join(s.current.MountDir(), "ubuntu/grubenv")), IsNil)
+
+	err := boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+
+	c.Check(osutil.FileExists(s.backupDir), Equals, false)
+	// no need to try it
+	c.Check(s.bootloader.BootVars, DeepEquals, map[string]string{
+		"snap_gadget":     "pc_2.snap",
+		"snap_try_gadget": "",
+	})
+	c.Check(boot.KernelOrOsRebootRequired(s.update), Equals, false)
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsIncompatible(c *C) {
+	for _, t := range []struct {
+		gadgetYaml string
+		err        string
+	}{
+		{`
+volumes:
+  other:
+    bootloader: grub
+`, `volume "pc" is missing from the new gadget`},
+		{`
+volumes:
+  pc:
+    schema: mbr
+    bootloader: grub
+`, `volume "pc" of the new gadget is not compatible with the current one`},
+		{`
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - type: mbr
+        size: 440
+`, `volume "pc" of the new gadget has 1 structures instead of 3`},
+		{string(bytes.Replace([]byte(gadgetYaml), []byte("size: 50M"), []byte("size: 60M"), 1)),
+			`structure #2 \("system-boot"\) of volume "pc" of the new gadget is not compatible with the current one`},
+		{string(bytes.Replace([]byte(gadgetYaml), []byte("offset-write: 92"), []byte("offset-write: 96"), 1)), ``},
+	} {
+		update := s.makeGadget(c, snap.R(2), map[string]string{
+			"pc-boot.img":      "boot code",
+			"pc-core.img":      "core image",
+			"grubx64.efi":      "grub",
+			"ubuntu/grub.cfg":  "menuentry",
+			"meta/gadget.yaml": t.gadgetYaml,
+		})
+		err := boot.UpdateGadgetAssets(s.current, update, s.backupDir)
+		if t.err == "" {
+			c.Check(err, IsNil)
+			continue
+		}
+		c.Check(err, ErrorMatches, "cannot update gadget assets: "+t.err)
+		c.Check(s.bootloader.BootVars, HasLen, 0)
+	}
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsInterrupted(c *C) {
+	s.update = s.makeGadget(c, snap.R(2), map[string]string{
+		"pc-boot.img":      "boot code",
+		"pc-core.img":      "core image",
+		"grubx64.efi":      "grub",
+		"ubuntu/grub.cfg":  "updated menuentry",
+		"meta/gadget.yaml": gadgetYaml,
+	})
+
+	err := boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+	s.checkMounted(c, "EFI/ubuntu/grub.cfg", "updated menuentry")
+
+	// running it again starts over from the backup
+	err = boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+	s.checkMounted(c, "EFI/ubuntu/grub.cfg", "updated menuentry")
+
+	err = boot.UndoGadgetAssetsUpdate(s.current, s.update, s.backupDir)
+	c.Assert(err, IsNil)
+	s.checkMounted(c, "EFI/ubuntu/grub.cfg", "menuentry")
+}
+
+func (s *gadgetSuite) TestUpdateGadgetAssetsNotMounted(c *C) {
+	s.update = s.makeGadget(c, snap.R(2), map[string]string{
+		"pc-boot.img":      "boot code",
+		"pc-core.img":      "core image",
+		"grubx64.efi":      "grub",
+		"meta/gadget.yaml": gadgetYaml,
+	})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "mountinfo"), nil, 0644), IsNil)
+	err := boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, ErrorMatches, `cannot update gadget assets: cannot find where structure #2 \("system-boot"\) is mounted`)
+
+	c.Assert(os.Remove(filepath.Join(s.root, "/dev/disk/by-label/system-boot")), IsNil)
+	err = boot.UpdateGadgetAssets(s.current, s.update, s.backupDir)
+	c.Assert(err, ErrorMatches, `cannot update gadget assets: cannot find the device of volume "pc"`)
+}
+
+func offsetWriteValue(start uint32) string {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, start)
+	return string(b)
+}
@@ -142,9 +142,10 @@ func SetNextBoot(s *snap.Info) error {
 	})
 }
 
-// KernelOrOsRebootRequired returns whether a reboot is required to swith to the given OS or kernel snap.
+// KernelOrOsRebootRequired returns whether a reboot is required to swith to the given OS or kernel snap,
+// or to a gadget snap whose assets were updated.
 func KernelOrOsRebootRequired(s *snap.Info) bool {
-	if s.Type != snap.TypeKernel && s.Type != snap.TypeOS {
+	if s.Type != snap.TypeKernel && s.Type != snap.TypeOS && s.Type != snap.TypeGadget {
 		return false
 	}
 
@@ -162,6 +163,9 @@ func KernelOrOsRebootRequired(s *snap.Info) bool {
 	case snap.TypeOS:
 		nextBoot = "snap_try_core"
 		goodBoot = "snap_core"
+	case snap.TypeGadget:
+		nextBoot = "snap_try_gadget"
+		goodBoot = "snap_gadget"
 	}
 
 	m, err := bootloader.GetBootVars(nextBoot, goodBoot)
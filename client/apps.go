@@ -0,0 +1,199 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AppOptions represent the options of the Apps call.
+type AppOptions struct {
+	// If Service is true, only return apps that are services
+	// (app.IsService() is true); otherwise, return all apps.
+	Service bool
+}
+
+// Apps returns information about the apps of the given snaps (or
+// snap.app names), or of all installed snaps if names is empty.
+func (client *Client) Apps(names []string, opts AppOptions) ([]*AppInfo, error) {
+	q := url.Values{}
+	if len(names) > 0 {
+		q.Add("names", strings.Join(names, ","))
+	}
+	if opts.Service {
+		q.Add("select", "service")
+	}
+
+	var appInfos []*AppInfo
+	_, err := client.doSync("GET", "/v2/apps", q, nil, nil, &appInfos)
+
+	return appInfos, err
+}
+
+// serviceInstruction is the request body to control services.
+type serviceInstruction struct {
+	Action  string   `json:"action"`
+	Names   []string `json:"names"`
+	Enable  bool     `json:"enable,omitempty"`
+	Disable bool     `json:"disable,omitempty"`
+}
+
+func (client *Client) doServiceAction(inst *serviceInstruction) (changeID string, err error) {
+	b, err := json.Marshal(inst)
+	if err != nil {
+		return "", err
+	}
+	return client.doAsync("POST", "/v2/apps", nil, nil, bytes.NewReader(b))
+}
+
+// StartOptions represent the different options of the Start call.
+type StartOptions struct {
+	// Enable, as well as starting, the listed services. A
+	// disabled service does not start on boot.
+	Enable bool
+}
+
+// Start services.
+//
+// It takes a list of names that can be snaps, of which all their
+// services are started, or snap.service which are individual
+// services to start; it shouldn't be empty.
+func (client *Client) Start(names []string, opts StartOptions) (changeID string, err error) {
+	return client.doServiceAction(&serviceInstruction{
+		Action: "start",
+		Names:  names,
+		Enable: opts.Enable,
+	})
+}
+
+// StopOptions represent the different options of the Stop call.
+type StopOptions struct {
+	// Disable, as well as stopping, the listed services. A
+	// service that is not disabled starts on boot.
+	Disable bool
+}
+
+// Stop services.
+//
+// It takes a list of names that can be snaps, of which all their
+// services are stopped, or snap.service which are individual
+// services to stop; it shouldn't be empty.
+func (client *Client) Stop(names []string, opts StopOptions) (changeID string, err error) {
+	return client.doServiceAction(&serviceInstruction{
+		Action:  "stop",
+		Names:   names,
+		Disable: opts.Disable,
+	})
+}
+
+// Restart services.
+//
+// It takes a list of names that can be snaps, of which all their
+// services are restarted, or snap.service which are individual
+// services to restart; it shouldn't be empty.
+func (client *Client) Restart(names []string) (changeID string, err error) {
+	return client.doServiceAction(&serviceInstruction{
+		Action: "restart",
+		Names:  names,
+	})
+}
+
+// Log holds the information of a single syslog entry
+type Log struct {
+	Timestamp time.Time `json:"timestamp"` // Timestamp of the event
+	Message   string    `json:"message"`   // Message of the event
+	SID       string    `json:"sid"`       // SID a.k.a. SYSLOG_IDENTIFIER
+	PID       string    `json:"pid"`       // PID of the process that generated the event
+}
+
+func (l Log) String() string {
+	return fmt.Sprintf("%s %s[%s]: %s", l.Timestamp.Format(time.RFC3339), l.SID, l.PID, l.Message)
+}
+
+// LogOptions represent the options of the Logs call.
+type LogOptions struct {
+	N      int  // The maximum number of log lines to retrieve initially. If <0, no limit.
+	Follow bool // Whether to continue returning new lines as they appear
+}
+
+// Logs asks for the logs of a series of services, by name.
+func (client *Client) Logs(names []string, opts LogOptions) (<-chan Log, error) {
+	query := url.Values{}
+	if len(names) > 0 {
+		query.Set("names", strings.Join(names, ","))
+	}
+	query.Set("n", strconv.Itoa(opts.N))
+	if opts.Follow {
+		query.Set("follow", strconv.FormatBool(opts.Follow))
+	}
+
+	rsp, err := client.raw("GET", "/v2/logs", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		defer rsp.Body.Close()
+		return nil, parseError(rsp)
+	}
+
+	ch := make(chan Log, 20)
+	go func() {
+		// logs come in application/json-seq, described in RFC7464: it's
+		// a series of <RS><arbitrary, valid JSON><LF>. Decoders are
+		// expected to skip invalid or truncated or empty records.
+		scanner := bufio.NewScanner(rsp.Body)
+		for scanner.Scan() {
+			buf := scanner.Bytes() // the scanner prunes the ending LF
+			if len(buf) < 1 {
+				// truncated record? skip
+				continue
+			}
+			idx := bytes.IndexByte(buf, 0x1E) // find the initial RS
+			if idx < 0 {
+				// no RS? skip
+				continue
+			}
+			buf = buf[idx+1:] // drop the initial RS
+			var rec struct {
+				Log
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(buf, &rec); err != nil || rec.Error != "" {
+				// truncated/corrupted/binary record, or the
+				// server giving up? skip
+				continue
+			}
+			ch <- rec.Log
+		}
+		close(ch)
+		rsp.Body.Close()
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientAppsCallsEndpoint(c *check.C) {
+	cs.cli.Apps([]string{"foo", "bar.baz"}, client.AppOptions{Service: true})
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/apps")
+	c.Check(cs.req.URL.Query().Get("names"), check.Equals, "foo,bar.baz")
+	c.Check(cs.req.URL.Query().Get("select"), check.Equals, "service")
+}
+
+func (cs *clientSuite) TestClientAppsNoNames(c *check.C) {
+	cs.cli.Apps(nil, client.AppOptions{})
+	c.Check(cs.req.URL.RawQuery, check.Equals, "")
+}
+
+func (cs *clientSuite) TestClientApps(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": [
+		{"snap": "foo", "name": "bar"},
+		{"snap": "foo", "name": "svc", "daemon": "simple", "enabled": true, "active": true}
+	]}`
+	apps, err := cs.cli.Apps(nil, client.AppOptions{})
+	c.Assert(err, check.IsNil)
+	c.Check(apps, check.DeepEquals, []*client.AppInfo{
+		{Snap: "foo", Name: "bar"},
+		{Snap: "foo", Name: "svc", Daemon: "simple", Enabled: true, Active: true},
+	})
+	c.Check(apps[0].IsService(), check.Equals, false)
+	c.Check(apps[1].IsService(), check.Equals, true)
+}
+
+func (cs *clientSuite) TestClientServiceActions(c *check.C) {
+	for _, t := range []struct {
+		op   func() (string, error)
+		body map[string]interface{}
+	}{
+		{
+			func() (string, error) { return cs.cli.Start([]string{"foo"}, client.StartOptions{Enable: true}) },
+			map[string]interface{}{"action": "start", "names": []interface{}{"foo"}, "enable": true},
+		}, {
+			func() (string, error) { return cs.cli.Stop([]string{"foo.svc"}, client.StopOptions{Disable: true}) },
+			map[string]interface{}{"action": "stop", "names": []interface{}{"foo.svc"}, "disable": true},
+		}, {
+			func() (string, error) { return cs.cli.Restart([]string{"foo", "bar"}) },
+			map[string]interface{}{"action": "restart", "names": []interface{}{"foo", "bar"}},
+		},
+	} {
+		cs.rsp = `{"type": "async", "status-code": 202, "result": {}, "change": "chgid"}`
+		id, err := t.op()
+		c.Assert(err, check.IsNil)
+		c.Check(id, check.Equals, "chgid")
+		c.Check(cs.req.Method, check.Equals, "POST")
+		c.Check(cs.req.URL.Path, check.Equals, "/v2/apps")
+
+		var body map[string]interface{}
+		c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+		c.Check(body, check.DeepEquals, t.body)
+	}
+}
+
+func (cs *clientSuite) TestClientLogs(c *check.C) {
+	cs.rsp = "\x1e" + `{"timestamp":"2009-11-10T23:00:00Z","message":"hello","sid":"xyzzy","pid":"42"}` + "\n" +
+		"\x1e" + `{"timestamp":"2009-11-10T23:00:01Z","message":"bye","sid":"xyzzy","pid":"42"}` + "\n" +
+		"\x1e" + `{"error": "problem reading"}` + "\n"
+	ch, err := cs.cli.Logs([]string{"foo", "bar.baz"}, client.LogOptions{N: -1, Follow: true})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/logs")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"names":  []string{"foo,bar.baz"},
+		"n":      []string{"-1"},
+		"follow": []string{"true"},
+	})
+
+	var logs []client.Log
+	for l := range ch {
+		logs = append(logs, l)
+	}
+	c.Assert(logs, check.HasLen, 2)
+	c.Check(logs[0].String(), check.Equals, "2009-11-10T23:00:00Z xyzzy[42]: hello")
+	c.Check(logs[1].Message, check.Equals, "bye")
+}
+
+func (cs *clientSuite) TestClientLogsError(c *check.C) {
+	cs.status = 404
+	cs.header = http.Header{"Content-Type": []string{"application/json"}}
+	cs.rsp = `{"type": "error", "status-code": 404, "result": {"message": "snap \"foo\" not found"}}`
+	_, err := cs.cli.Logs([]string{"foo"}, client.LogOptions{N: 10})
+	c.Check(err, check.ErrorMatches, `snap "foo" not found`)
+}
@@ -43,8 +43,32 @@ func (client *Client) Ack(b []byte) error {
 	return nil
 }
 
+// AckBundle imports an offline bundle, a tarball (optionally gzip
+// compressed) of snaps together with the assertions needed to verify
+// them, adding the assertions to the system assertion database and
+// installing or refreshing the snaps. It returns the ID of the change
+// doing the installation.
+func (client *Client) AckBundle(r io.Reader) (changeID string, err error) {
+	headers := map[string]string{
+		"Content-Type": "application/x-tar",
+	}
+	return client.doAsync("POST", "/v2/assertions", nil, headers, r)
+}
+
 // Known queries assertions with type assertTypeName and matching assertion headers.
 func (client *Client) Known(assertTypeName string, headers map[string]string) ([]asserts.Assertion, error) {
+	return client.known(assertTypeName, headers, false)
+}
+
+// KnownInvalid queries assertions like Known but restricted to those
+// that were found not to be valid anymore, e.g. because their signing
+// key expired or was revoked, by the last re-validation of the system
+// assertion database.
+func (client *Client) KnownInvalid(assertTypeName string, headers map[string]string) ([]asserts.Assertion, error) {
+	return client.known(assertTypeName, headers, true)
+}
+
+func (client *Client) known(assertTypeName string, headers map[string]string, onlyInvalid bool) ([]asserts.Assertion, error) {
 	path := fmt.Sprintf("/v2/assertions/%s", assertTypeName)
 	q := url.Values{}
 
@@ -53,6 +77,9 @@ func (client *Client) Known(assertTypeName string, headers map[string]string) ([
 			q.Set(k, v)
 		}
 	}
+	if onlyInvalid {
+		q.Set("invalid", "true")
+	}
 
 	response, err := client.raw("GET", path, q, nil, nil)
 	if err != nil {
@@ -20,6 +20,7 @@
 package client_test
 
 import (
+	"bytes"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -45,6 +46,20 @@ func (cs *clientSuite) TestClientAssert(c *C) {
 	c.Check(cs.req.URL.Path, Equals, "/v2/assertions")
 }
 
+func (cs *clientSuite) TestClientAckBundle(c *C) {
+	cs.rsp = `{"type": "async", "status-code": 202, "result": {}, "change": "42"}`
+	bundle := []byte("tarball")
+	id, err := cs.cli.AckBundle(bytes.NewReader(bundle))
+	c.Assert(err, IsNil)
+	c.Check(id, Equals, "42")
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, IsNil)
+	c.Check(body, DeepEquals, bundle)
+	c.Check(cs.req.Method, Equals, "POST")
+	c.Check(cs.req.URL.Path, Equals, "/v2/assertions")
+	c.Check(cs.req.Header.Get("Content-Type"), Equals, "application/x-tar")
+}
+
 func (cs *clientSuite) TestClientAssertsCallsEndpoint(c *C) {
 	_, _ = cs.cli.Known("snap-revision", nil)
 	c.Check(cs.req.Method, Equals, "GET")
@@ -135,6 +150,22 @@ func (cs *clientSuite) TestClientAssertsNoAssertions(c *C) {
 	c.Check(a, HasLen, 0)
 }
 
+func (cs *clientSuite) TestClientAssertsInvalid(c *C) {
+	cs.header = http.Header{}
+	cs.header.Add("X-Ubuntu-Assertions-Count", "0")
+	cs.rsp = ""
+	cs.status = http.StatusOK
+	a, err := cs.cli.KnownInvalid("snap-revision", map[string]string{"snap-id": "snap-id-1"})
+	c.Assert(err, IsNil)
+	c.Check(a, HasLen, 0)
+	c.Check(cs.req.Method, Equals, "GET")
+	c.Check(cs.req.URL.Path, Equals, "/v2/assertions/snap-revision")
+	c.Check(cs.req.URL.Query(), DeepEquals, url.Values{
+		"snap-id": []string{"snap-id-1"},
+		"invalid": []string{"true"},
+	})
+}
+
 func (cs *clientSuite) TestClientAssertsMissingAssertions(c *C) {
 	cs.header = http.Header{}
 	cs.header.Add("X-Ubuntu-Assertions-Count", "4")
@@ -162,3 +162,53 @@ func (client *Client) Changes(opts *ChangesOptions) ([]*Change, error) {
 
 	return chgs, err
 }
+
+// A TimingSpan records how long a task handler run, or a manager's
+// part of an ensure pass, took.
+type TimingSpan struct {
+	Label    string        `json:"label"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Outcome  string        `json:"outcome,omitempty"`
+}
+
+// TaskTimings holds the handler runs of a task.
+type TaskTimings struct {
+	ID      string       `json:"id"`
+	Kind    string       `json:"kind"`
+	Summary string       `json:"summary"`
+	Status  string       `json:"status"`
+	Timings []TimingSpan `json:"timings,omitempty"`
+
+	SpawnTime time.Time `json:"spawn-time,omitempty"`
+	ReadyTime time.Time `json:"ready-time,omitempty"`
+}
+
+// ChangeTimings holds the timings of the tasks of a change, and of
+// the ensure passes that ran while the change was in progress.
+type ChangeTimings struct {
+	ID            string         `json:"id"`
+	Kind          string         `json:"kind"`
+	Summary       string         `json:"summary"`
+	Status        string         `json:"status"`
+	Tasks         []*TaskTimings `json:"tasks,omitempty"`
+	EnsureTimings []TimingSpan   `json:"ensure-timings,omitempty"`
+
+	SpawnTime time.Time `json:"spawn-time,omitempty"`
+	ReadyTime time.Time `json:"ready-time,omitempty"`
+}
+
+// ChangeTimings fetches the timings of the change with the given ID.
+func (client *Client) ChangeTimings(id string) (*ChangeTimings, error) {
+	query := url.Values{
+		"aspect":    []string{"change-timings"},
+		"change-id": []string{id},
+	}
+
+	var timings ChangeTimings
+	if _, err := client.doSync("GET", "/v2/debug", query, nil, nil, &timings); err != nil {
+		return nil, err
+	}
+
+	return &timings, nil
+}
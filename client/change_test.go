@@ -24,6 +24,7 @@ import (
 
 	"github.com/snapcore/snapd/client"
 	"io/ioutil"
+	"net/url"
 	"time"
 )
 
@@ -213,3 +214,54 @@ func (cs *clientSuite) TestClientAbort(c *check.C) {
 
 	c.Assert(string(body), check.Equals, "{\"action\":\"abort\"}\n")
 }
+
+func (cs *clientSuite) TestClientChangeTimings(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "foo",
+  "summary": "...",
+  "status": "Done",
+  "spawn-time": "2016-04-21T01:02:03Z",
+  "ready-time": "2016-04-21T01:02:05Z",
+  "tasks": [{"id": "1", "kind": "bar", "summary": "...", "status": "Done", "timings": [{"label": "do", "start": "2016-04-21T01:02:03Z", "duration": 1500000000, "outcome": "done"}], "spawn-time": "2016-04-21T01:02:03Z", "ready-time": "2016-04-21T01:02:05Z"}],
+  "ensure-timings": [{"label": "snapstate.SnapManager", "start": "2016-04-21T01:02:04Z", "duration": 2000000, "outcome": "done"}]
+}}`
+
+	timings, err := cs.cli.ChangeTimings("uno")
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/debug")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"aspect":    []string{"change-timings"},
+		"change-id": []string{"uno"},
+	})
+	c.Check(timings, check.DeepEquals, &client.ChangeTimings{
+		ID:      "uno",
+		Kind:    "foo",
+		Summary: "...",
+		Status:  "Done",
+		Tasks: []*client.TaskTimings{{
+			ID:      "1",
+			Kind:    "bar",
+			Summary: "...",
+			Status:  "Done",
+			Timings: []client.TimingSpan{{
+				Label:    "do",
+				Start:    time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC),
+				Duration: 1500 * time.Millisecond,
+				Outcome:  "done",
+			}},
+			SpawnTime: time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC),
+			ReadyTime: time.Date(2016, 04, 21, 1, 2, 5, 0, time.UTC),
+		}},
+		EnsureTimings: []client.TimingSpan{{
+			Label:    "snapstate.SnapManager",
+			Start:    time.Date(2016, 04, 21, 1, 2, 4, 0, time.UTC),
+			Duration: 2 * time.Millisecond,
+			Outcome:  "done",
+		}},
+
+		SpawnTime: time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC),
+		ReadyTime: time.Date(2016, 04, 21, 1, 2, 5, 0, time.UTC),
+	})
+}
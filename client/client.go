@@ -60,6 +60,11 @@ type Config struct {
 
 	// Socket is the path to the unix socket to use
 	Socket string
+
+	// Interactive controls whether the daemon may interact with
+	// the user (e.g. ask for a password via polkit) to authorize
+	// requests.
+	Interactive bool
 }
 
 // A Client knows how to talk to the snappy daemon.
@@ -68,6 +73,7 @@ type Client struct {
 	doer    doer
 
 	disableAuth bool
+	interactive bool
 }
 
 // New returns a new instance of Client
@@ -87,6 +93,7 @@ func New(config *Config) *Client {
 				Transport: &http.Transport{Dial: unixDialer(config.Socket)},
 			},
 			disableAuth: config.DisableAuth,
+			interactive: config.Interactive,
 		}
 	}
 
@@ -98,6 +105,7 @@ func New(config *Config) *Client {
 		baseURL:     *baseURL,
 		doer:        &http.Client{},
 		disableAuth: config.DisableAuth,
+		interactive: config.Interactive,
 	}
 }
 
@@ -154,6 +162,10 @@ func (client *Client) raw(method, urlpath string, query url.Values, headers map[
 		req.Header.Set(key, value)
 	}
 
+	if client.interactive {
+		req.Header.Set("X-Allow-Interaction", "true")
+	}
+
 	if !client.disableAuth {
 		// set Authorization header if there are user's credentials
 		err = client.setAuthorization(req)
@@ -253,25 +265,32 @@ func (client *Client) doSync(method, path string, query url.Values, headers map[
 }
 
 func (client *Client) doAsync(method, path string, query url.Values, headers map[string]string, body io.Reader) (changeID string, err error) {
+	_, changeID, err = client.doAsyncFull(method, path, query, headers, body)
+	return changeID, err
+}
+
+// doAsyncFull is like doAsync but also returns the result carried by
+// the async response, if any.
+func (client *Client) doAsyncFull(method, path string, query url.Values, headers map[string]string, body io.Reader) (result json.RawMessage, changeID string, err error) {
 	var rsp response
 
 	if err := client.do(method, path, query, headers, body, &rsp); err != nil {
-		return "", err
+		return nil, "", err
 	}
 	if err := rsp.err(); err != nil {
-		return "", err
+		return nil, "", err
 	}
 	if rsp.Type != "async" {
-		return "", fmt.Errorf("expected async response for %q on %q, got %q", method, path, rsp.Type)
+		return nil, "", fmt.Errorf("expected async response for %q on %q, got %q", method, path, rsp.Type)
 	}
 	if rsp.StatusCode != http.StatusAccepted {
-		return "", fmt.Errorf("operation not accepted")
+		return nil, "", fmt.Errorf("operation not accepted")
 	}
 	if rsp.Change == "" {
-		return "", fmt.Errorf("async response without change reference")
+		return nil, "", fmt.Errorf("async response without change reference")
 	}
 
-	return rsp.Change, nil
+	return rsp.Result, rsp.Change, nil
 }
 
 type ServerVersion struct {
@@ -357,6 +376,13 @@ type OSRelease struct {
 	VersionID string `json:"version-id,omitempty"`
 }
 
+// RefreshInfo contains information about refreshes.
+type RefreshInfo struct {
+	Schedule string `json:"schedule"`
+	Last     string `json:"last,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
 // SysInfo holds system information
 type SysInfo struct {
 	Series    string    `json:"series,omitempty"`
@@ -366,6 +392,8 @@ type SysInfo struct {
 	Managed   bool      `json:"managed"`
 
 	KernelVersion string `json:"kernel-version,omitempty"`
+
+	Refresh RefreshInfo `json:"refresh,omitempty"`
 }
 
 func (rsp *response) err() error {
@@ -170,12 +170,24 @@ func (cs *clientSuite) TestClientHonorsDisableAuth(c *C) {
 	c.Check(authorization, Equals, "")
 }
 
+func (cs *clientSuite) TestClientSetsAllowInteraction(c *C) {
+	var v string
+	_ = cs.cli.Do("GET", "/this", nil, nil, &v)
+	c.Check(cs.req.Header.Get("X-Allow-Interaction"), Equals, "")
+
+	cli := client.New(&client.Config{Interactive: true})
+	cli.SetDoer(cs)
+	_ = cli.Do("GET", "/this", nil, nil, &v)
+	c.Check(cs.req.Header.Get("X-Allow-Interaction"), Equals, "true")
+}
+
 func (cs *clientSuite) TestClientSysInfo(c *C) {
 	cs.rsp = `{"type": "sync", "result":
                      {"series": "16",
                       "version": "2",
                       "os-release": {"id": "ubuntu", "version-id": "16.04"},
-                      "on-classic": true}}`
+                      "on-classic": true,
+                      "refresh": {"schedule": "9:00-11:00", "last": "2017-02-06T10:00:00Z"}}}`
 	sysInfo, err := cs.cli.SysInfo()
 	c.Check(err, IsNil)
 	c.Check(sysInfo, DeepEquals, &client.SysInfo{
@@ -186,6 +198,10 @@ func (cs *clientSuite) TestClientSysInfo(c *C) {
 			VersionID: "16.04",
 		},
 		OnClassic: true,
+		Refresh: client.RefreshInfo{
+			Schedule: "9:00-11:00",
+			Last:     "2017-02-06T10:00:00Z",
+		},
 	})
 }
 
@@ -0,0 +1,160 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Event is a status transition, a progress update, or a message
+// logged, for a change or one of its tasks.
+type Event struct {
+	// Type is one of "change-status", "task-status", "task-progress"
+	// or "task-log".
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	ChangeID   string    `json:"change-id"`
+	ChangeKind string    `json:"change-kind"`
+	TaskID     string    `json:"task-id,omitempty"`
+	TaskKind   string    `json:"task-kind,omitempty"`
+
+	Status   string        `json:"status,omitempty"`
+	Progress *TaskProgress `json:"progress,omitempty"`
+	Message  string        `json:"message,omitempty"`
+}
+
+// EventOptions represent the options of the Subscribe call.
+type EventOptions struct {
+	// ChangeID restricts the events to the ones of the given change.
+	ChangeID string
+	// Kinds restricts the events to the ones of changes of the
+	// given kinds.
+	Kinds []string
+}
+
+// An EventSubscription delivers the events the daemon streams for
+// a Subscribe call.
+type EventSubscription struct {
+	events chan Event
+	body   io.ReadCloser
+	done   chan struct{}
+	err    error
+
+	closeOnce sync.Once
+}
+
+// Subscribe asks the daemon to stream the events of changes and
+// their tasks as they happen.
+func (client *Client) Subscribe(opts EventOptions) (*EventSubscription, error) {
+	query := url.Values{}
+	if opts.ChangeID != "" {
+		query.Set("change-id", opts.ChangeID)
+	}
+	if len(opts.Kinds) > 0 {
+		query.Set("kind", strings.Join(opts.Kinds, ","))
+	}
+
+	rsp, err := client.raw("GET", "/v2/events", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		defer rsp.Body.Close()
+		return nil, parseError(rsp)
+	}
+
+	sub := &EventSubscription{
+		events: make(chan Event, 20),
+		body:   rsp.Body,
+		done:   make(chan struct{}),
+	}
+	go sub.run()
+
+	return sub, nil
+}
+
+func (sub *EventSubscription) run() {
+	defer close(sub.events)
+	defer sub.body.Close()
+
+	// events come in application/json-seq, described in RFC7464,
+	// see Logs
+	scanner := bufio.NewScanner(sub.body)
+	for scanner.Scan() {
+		buf := scanner.Bytes()
+		idx := bytes.IndexByte(buf, 0x1E)
+		if idx < 0 {
+			continue
+		}
+		buf = buf[idx+1:]
+		var rec struct {
+			Event
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(buf, &rec); err != nil {
+			// truncated/corrupted record? skip
+			continue
+		}
+		if rec.Error != "" {
+			sub.err = errors.New(rec.Error)
+			return
+		}
+		select {
+		case sub.events <- rec.Event:
+		case <-sub.done:
+			return
+		}
+	}
+	select {
+	case <-sub.done:
+		// closed by us
+	default:
+		sub.err = scanner.Err()
+	}
+}
+
+// Events returns the channel the events are delivered on. It is
+// closed when the stream ends, after which Err can be checked.
+func (sub *EventSubscription) Events() <-chan Event {
+	return sub.events
+}
+
+// Err returns the error that ended the stream, if any. It must only
+// be called after the Events channel is closed.
+func (sub *EventSubscription) Err() error {
+	return sub.err
+}
+
+// Close ends the subscription.
+func (sub *EventSubscription) Close() error {
+	sub.closeOnce.Do(func() {
+		close(sub.done)
+	})
+	return sub.body.Close()
+}
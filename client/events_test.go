@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package client_test
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientSubscribe(c *check.C) {
+	cs.rsp = "\x1e" + `{"type":"task-status","time":"2009-11-10T23:00:00Z","change-id":"1","change-kind":"install","task-id":"2","task-kind":"download","status":"Doing"}` + "\n" +
+		"\x1e" + `{"type":"task-progress","time":"2009-11-10T23:00:01Z","change-id":"1","change-kind":"install","task-id":"2","task-kind":"download","progress":{"label":"foo","done":1,"total":2}}` + "\n" +
+		"\x1e" + `garbage` + "\n" +
+		"\x1e" + `{"type":"change-status","time":"2009-11-10T23:00:02Z","change-id":"1","change-kind":"install","status":"Done"}` + "\n"
+	sub, err := cs.cli.Subscribe(client.EventOptions{ChangeID: "1", Kinds: []string{"install", "refresh"}})
+	c.Assert(err, check.IsNil)
+	defer sub.Close()
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/events")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"change-id": []string{"1"},
+		"kind":      []string{"install,refresh"},
+	})
+
+	var evs []client.Event
+	for ev := range sub.Events() {
+		evs = append(evs, ev)
+	}
+	c.Check(sub.Err(), check.IsNil)
+	c.Check(evs, check.DeepEquals, []client.Event{{
+		Type:       "task-status",
+		Time:       time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC),
+		ChangeID:   "1",
+		ChangeKind: "install",
+		TaskID:     "2",
+		TaskKind:   "download",
+		Status:     "Doing",
+	}, {
+		Type:       "task-progress",
+		Time:       time.Date(2009, 11, 10, 23, 0, 1, 0, time.UTC),
+		ChangeID:   "1",
+		ChangeKind: "install",
+		TaskID:     "2",
+		TaskKind:   "download",
+		Progress:   &client.TaskProgress{Label: "foo", Done: 1, Total: 2},
+	}, {
+		Type:       "change-status",
+		Time:       time.Date(2009, 11, 10, 23, 0, 2, 0, time.UTC),
+		ChangeID:   "1",
+		ChangeKind: "install",
+		Status:     "Done",
+	}})
+}
+
+func (cs *clientSuite) TestClientSubscribeStreamError(c *check.C) {
+	cs.rsp = "\x1e" + `{"type":"task-log","time":"2009-11-10T23:00:00Z","change-id":"1","change-kind":"install","task-id":"2","task-kind":"download","message":"hello"}` + "\n" +
+		"\x1e" + `{"error": "events were not consumed fast enough"}` + "\n"
+	sub, err := cs.cli.Subscribe(client.EventOptions{})
+	c.Assert(err, check.IsNil)
+	defer sub.Close()
+	c.Check(cs.req.URL.Query(), check.HasLen, 0)
+
+	var evs []client.Event
+	for ev := range sub.Events() {
+		evs = append(evs, ev)
+	}
+	c.Assert(evs, check.HasLen, 1)
+	c.Check(evs[0].Message, check.Equals, "hello")
+	c.Check(sub.Err(), check.ErrorMatches, "events were not consumed fast enough")
+}
+
+func (cs *clientSuite) TestClientSubscribeError(c *check.C) {
+	cs.status = 404
+	cs.header = http.Header{"Content-Type": []string{"application/json"}}
+	cs.rsp = `{"type": "error", "status-code": 404, "result": {"message": "cannot find change with id \"42\""}}`
+	_, err := cs.cli.Subscribe(client.EventOptions{ChangeID: "42"})
+	c.Check(err, check.ErrorMatches, `cannot find change with id "42"`)
+}
@@ -22,6 +22,7 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"net/url"
 )
 
 // Plug represents the potential of a given snap to connect to a slot.
@@ -64,6 +65,40 @@ type Interfaces struct {
 	Slots []Slot `json:"slots"`
 }
 
+// Connection describes a connection between a plug and a slot.
+type Connection struct {
+	Slot      SlotRef `json:"slot"`
+	Plug      PlugRef `json:"plug"`
+	Interface string  `json:"interface"`
+	// Manual is set for connections that were established manually.
+	Manual bool `json:"manual,omitempty"`
+	// Gadget is set for connections that were requested by the gadget.
+	Gadget bool `json:"gadget,omitempty"`
+}
+
+// Connections contains information about the connections between
+// plugs and slots, and the plugs and slots involved.
+type Connections struct {
+	// Established are the connections currently in place.
+	Established []Connection `json:"established"`
+	// Undesired are automatic connections that were manually
+	// disconnected and will not be made again automatically.
+	Undesired []Connection `json:"undesired"`
+	Plugs     []Plug       `json:"plugs"`
+	Slots     []Slot       `json:"slots"`
+}
+
+// ConnectionOptions contains the criteria for selecting matching
+// connections, plugs and slots.
+type ConnectionOptions struct {
+	// Snap limits the results to the connections, plugs and slots
+	// involving the given snap.
+	Snap string
+	// All includes unconnected plugs and slots, and undesired
+	// connections.
+	All bool
+}
+
 // InterfaceAction represents an action performed on the interface system.
 type InterfaceAction struct {
 	Action string `json:"action"`
@@ -77,6 +112,21 @@ func (client *Client) Interfaces() (interfaces Interfaces, err error) {
 	return
 }
 
+// Connections returns the connections, plugs and slots matching the
+// given options.
+func (client *Client) Connections(opts *ConnectionOptions) (Connections, error) {
+	var conns Connections
+	query := url.Values{}
+	if opts != nil && opts.Snap != "" {
+		query.Set("snap", opts.Snap)
+	}
+	if opts != nil && opts.All {
+		query.Set("select", "all")
+	}
+	_, err := client.doSync("GET", "/v2/connections", query, nil, nil, &conns)
+	return conns, err
+}
+
 // performInterfaceAction performs a single action on the interface system.
 func (client *Client) performInterfaceAction(sa *InterfaceAction) (changeID string, err error) {
 	b, err := json.Marshal(sa)
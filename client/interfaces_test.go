@@ -168,3 +168,75 @@ func (cs *clientSuite) TestClientDisconnect(c *check.C) {
 		},
 	})
 }
+
+func (cs *clientSuite) TestClientConnectionsCallsEndpoint(c *check.C) {
+	_, _ = cs.cli.Connections(nil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/connections")
+	c.Check(cs.req.URL.RawQuery, check.Equals, "")
+}
+
+func (cs *clientSuite) TestClientConnectionsQuery(c *check.C) {
+	_, _ = cs.cli.Connections(&client.ConnectionOptions{Snap: "foo", All: true})
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/connections")
+	c.Check(cs.req.URL.Query().Get("snap"), check.Equals, "foo")
+	c.Check(cs.req.URL.Query().Get("select"), check.Equals, "all")
+}
+
+func (cs *clientSuite) TestClientConnections(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"result": {
+			"established": [
+				{
+					"plug": {"snap": "canonical-pi2", "plug": "pin-13"},
+					"slot": {"snap": "keyboard-lights", "slot": "capslock-led"},
+					"interface": "bool-file",
+					"manual": true
+				}
+			],
+			"undesired": [
+				{
+					"plug": {"snap": "canonical-pi2", "plug": "pin-14"},
+					"slot": {"snap": "keyboard-lights", "slot": "numlock-led"},
+					"interface": "bool-file",
+					"gadget": true
+				}
+			],
+			"plugs": [
+				{
+					"snap": "canonical-pi2",
+					"plug": "pin-13",
+					"interface": "bool-file",
+					"connections": [
+						{"snap": "keyboard-lights", "slot": "capslock-led"}
+					]
+				}
+			],
+			"slots": []
+		}
+	}`
+	conns, err := cs.cli.Connections(&client.ConnectionOptions{All: true})
+	c.Assert(err, check.IsNil)
+	c.Check(conns, check.DeepEquals, client.Connections{
+		Established: []client.Connection{{
+			Plug:      client.PlugRef{Snap: "canonical-pi2", Name: "pin-13"},
+			Slot:      client.SlotRef{Snap: "keyboard-lights", Name: "capslock-led"},
+			Interface: "bool-file",
+			Manual:    true,
+		}},
+		Undesired: []client.Connection{{
+			Plug:      client.PlugRef{Snap: "canonical-pi2", Name: "pin-14"},
+			Slot:      client.SlotRef{Snap: "keyboard-lights", Name: "numlock-led"},
+			Interface: "bool-file",
+			Gadget:    true,
+		}},
+		Plugs: []client.Plug{{
+			Snap:        "canonical-pi2",
+			Name:        "pin-13",
+			Interface:   "bool-file",
+			Connections: []client.SlotRef{{Snap: "keyboard-lights", Name: "capslock-led"}},
+		}},
+		Slots: []client.Slot{},
+	})
+}
@@ -0,0 +1,40 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package client_test
+
+import (
+	"encoding/json"
+
+	"gopkg.in/check.v1"
+)
+
+func (cs *clientSuite) TestClientRemodel(c *check.C) {
+	cs.rsp = `{"type": "async", "status-code": 202, "result": {}, "change": "d728"}`
+	id, err := cs.cli.Remodel([]byte("model-assertion"))
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, "d728")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/model")
+
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"new-model": "model-assertion",
+	})
+}
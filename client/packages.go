@@ -62,9 +62,20 @@ type Snap struct {
 }
 
 type AppInfo struct {
+	Snap    string   `json:"snap,omitempty"`
 	Name    string   `json:"name"`
 	Daemon  string   `json:"daemon"`
 	Aliases []string `json:"aliases"`
+	Enabled bool     `json:"enabled,omitempty"`
+	Active  bool     `json:"active,omitempty"`
+
+	Timer       string    `json:"timer,omitempty"`
+	NextTrigger time.Time `json:"next-trigger,omitempty"`
+}
+
+// IsService returns whether the app is a service.
+func (a *AppInfo) IsService() bool {
+	return a != nil && a.Daemon != ""
 }
 
 type Screenshot struct {
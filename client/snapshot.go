@@ -0,0 +1,184 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/snap"
+)
+
+// A Snapshot is a collection of archives with a simple metadata
+// json file (and hashsums of everything).
+type Snapshot struct {
+	// SetID is the ID of the snapshot set (a snapshot set is the
+	// result of a "snap save" invocation)
+	SetID uint64 `json:"set"`
+	// the time this snapshot's data collection was started
+	Time time.Time `json:"time"`
+
+	// information about the snap this data is for
+	Snap     string        `json:"snap"`
+	Revision snap.Revision `json:"revision"`
+	SnapID   string        `json:"snap-id,omitempty"`
+	Version  string        `json:"version,omitempty"`
+
+	// the snap's configuration at snapshot time
+	Conf map[string]*json.RawMessage `json:"conf,omitempty"`
+
+	// the hash of the archives' data, keyed by archive name
+	// ("archive.tgz" for the system-wide data, "user/<name>.tgz"
+	// for each user's data)
+	SHA3_384 map[string]string `json:"sha3-384"`
+	// the sum of the archive sizes
+	Size int64 `json:"size,omitempty"`
+
+	// Auto is true for snapshots taken automatically, e.g. when
+	// the snap is removed
+	Auto bool `json:"auto,omitempty"`
+}
+
+// A SnapshotSet is a set of snapshots taken at the same time, by
+// the same request.
+type SnapshotSet struct {
+	ID        uint64      `json:"id"`
+	Snapshots []*Snapshot `json:"snapshots"`
+}
+
+// Time returns the earliest time of the snapshots in the set.
+func (ss SnapshotSet) Time() time.Time {
+	if len(ss.Snapshots) == 0 {
+		return time.Time{}
+	}
+	t := ss.Snapshots[0].Time
+	for _, sn := range ss.Snapshots[1:] {
+		if sn.Time.Before(t) {
+			t = sn.Time
+		}
+	}
+	return t
+}
+
+// Size returns the sum of the sizes of the snapshots in the set.
+func (ss SnapshotSet) Size() int64 {
+	var sum int64
+	for _, sn := range ss.Snapshots {
+		sum += sn.Size
+	}
+	return sum
+}
+
+// SnapshotSets lists the snapshot sets in the system that belong to
+// the given set (if non-zero) and are for the given snaps (if
+// non-empty).
+func (client *Client) SnapshotSets(setID uint64, snapNames []string) ([]SnapshotSet, error) {
+	q := url.Values{}
+	if setID > 0 {
+		q.Add("set", strconv.FormatUint(setID, 10))
+	}
+	if len(snapNames) > 0 {
+		q.Add("snaps", strings.Join(snapNames, ","))
+	}
+
+	var snapshotSets []SnapshotSet
+	_, err := client.doSync("GET", "/v2/snapshots", q, nil, nil, &snapshotSets)
+	return snapshotSets, err
+}
+
+// snapshotAction is the request body to act on snapshots.
+type snapshotAction struct {
+	SetID  uint64   `json:"set,omitempty"`
+	Action string   `json:"action"`
+	Snaps  []string `json:"snaps,omitempty"`
+	Users  []string `json:"users,omitempty"`
+}
+
+func (client *Client) snapshotAction(action *snapshotAction) (result json.RawMessage, changeID string, err error) {
+	b, err := json.Marshal(action)
+	if err != nil {
+		return nil, "", err
+	}
+	return client.doAsyncFull("POST", "/v2/snapshots", nil, nil, bytes.NewReader(b))
+}
+
+// SnapshotMany takes snapshots of the data of the given snaps (or of
+// all installed snaps if empty), for the given users (or for all
+// users if empty), as a new snapshot set whose ID is returned.
+func (client *Client) SnapshotMany(snapNames []string, users []string) (setID uint64, changeID string, err error) {
+	result, changeID, err := client.snapshotAction(&snapshotAction{
+		Action: "save",
+		Snaps:  snapNames,
+		Users:  users,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	var x struct {
+		SetID uint64 `json:"set-id"`
+	}
+	if err := json.Unmarshal(result, &x); err != nil {
+		return 0, "", fmt.Errorf("cannot unmarshal snapshot set ID: %v", err)
+	}
+
+	return x.SetID, changeID, nil
+}
+
+// CheckSnapshots verifies the archives of the snapshot set with the
+// given ID, optionally limited to the given snaps and users.
+func (client *Client) CheckSnapshots(setID uint64, snapNames []string, users []string) (changeID string, err error) {
+	_, changeID, err = client.snapshotAction(&snapshotAction{
+		SetID:  setID,
+		Action: "check",
+		Snaps:  snapNames,
+		Users:  users,
+	})
+	return changeID, err
+}
+
+// RestoreSnapshots restores the data and configuration of the snaps
+// in the snapshot set with the given ID, optionally limited to the
+// given snaps and users.
+func (client *Client) RestoreSnapshots(setID uint64, snapNames []string, users []string) (changeID string, err error) {
+	_, changeID, err = client.snapshotAction(&snapshotAction{
+		SetID:  setID,
+		Action: "restore",
+		Snaps:  snapNames,
+		Users:  users,
+	})
+	return changeID, err
+}
+
+// ForgetSnapshots permanently removes the snapshot set with the given
+// ID, optionally limited to the given snaps.
+func (client *Client) ForgetSnapshots(setID uint64, snapNames []string) (changeID string, err error) {
+	_, changeID, err = client.snapshotAction(&snapshotAction{
+		SetID:  setID,
+		Action: "forget",
+		Snaps:  snapNames,
+	})
+	return changeID, err
+}
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/snap"
+)
+
+func (cs *clientSuite) TestClientSnapshotSetsCallsEndpoint(c *check.C) {
+	cs.cli.SnapshotSets(42, []string{"foo", "bar"})
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/snapshots")
+	c.Check(cs.req.URL.Query().Get("set"), check.Equals, "42")
+	c.Check(cs.req.URL.Query().Get("snaps"), check.Equals, "foo,bar")
+
+	cs.cli.SnapshotSets(0, nil)
+	c.Check(cs.req.URL.RawQuery, check.Equals, "")
+}
+
+func (cs *clientSuite) TestClientSnapshotSets(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": [
+		{"id": 1, "snapshots": [
+			{"set": 1, "time": "2017-10-01T12:00:00Z", "snap": "foo", "revision": "7", "version": "1.0", "conf": {"a": 1}, "sha3-384": {"archive.tgz": "abcd"}, "size": 10},
+			{"set": 1, "time": "2017-10-01T11:00:00Z", "snap": "bar", "revision": "x1", "sha3-384": {}, "size": 5, "auto": true}
+		]}
+	]}`
+	sets, err := cs.cli.SnapshotSets(0, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(sets, check.HasLen, 1)
+	c.Check(sets[0].ID, check.Equals, uint64(1))
+	c.Assert(sets[0].Snapshots, check.HasLen, 2)
+
+	sn := sets[0].Snapshots[0]
+	c.Check(sn.Snap, check.Equals, "foo")
+	c.Check(sn.Revision, check.Equals, snap.R(7))
+	c.Check(sn.Version, check.Equals, "1.0")
+	c.Check(string(*sn.Conf["a"]), check.Equals, "1")
+	c.Check(sn.SHA3_384, check.DeepEquals, map[string]string{"archive.tgz": "abcd"})
+	c.Check(sets[0].Snapshots[1].Revision, check.Equals, snap.R(-1))
+	c.Check(sets[0].Snapshots[1].Auto, check.Equals, true)
+
+	c.Check(sets[0].Time().Equal(time.Date(2017, 10, 1, 11, 0, 0, 0, time.UTC)), check.Equals, true)
+	c.Check(sets[0].Size(), check.Equals, int64(15))
+
+	c.Check(client.SnapshotSet{}.Time().IsZero(), check.Equals, true)
+}
+
+func (cs *clientSuite) TestClientSnapshotMany(c *check.C) {
+	cs.rsp = `{"type": "async", "status-code": 202, "result": {"set-id": 42, "snaps": ["foo"]}, "change": "chgid"}`
+	setID, changeID, err := cs.cli.SnapshotMany([]string{"foo"}, []string{"bar"})
+	c.Assert(err, check.IsNil)
+	c.Check(setID, check.Equals, uint64(42))
+	c.Check(changeID, check.Equals, "chgid")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/snapshots")
+
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action": "save",
+		"snaps":  []interface{}{"foo"},
+		"users":  []interface{}{"bar"},
+	})
+}
+
+func (cs *clientSuite) TestClientSnapshotActions(c *check.C) {
+	for _, t := range []struct {
+		op   func() (string, error)
+		body map[string]interface{}
+	}{
+		{
+			func() (string, error) { return cs.cli.CheckSnapshots(42, []string{"foo"}, []string{"bar"}) },
+			map[string]interface{}{"action": "check", "set": 42.0, "snaps": []interface{}{"foo"}, "users": []interface{}{"bar"}},
+		}, {
+			func() (string, error) { return cs.cli.RestoreSnapshots(42, nil, nil) },
+			map[string]interface{}{"action": "restore", "set": 42.0},
+		}, {
+			func() (string, error) { return cs.cli.ForgetSnapshots(42, []string{"foo"}) },
+			map[string]interface{}{"action": "forget", "set": 42.0, "snaps": []interface{}{"foo"}},
+		},
+	} {
+		cs.rsp = `{"type": "async", "status-code": 202, "result": {}, "change": "chgid"}`
+		id, err := t.op()
+		c.Assert(err, check.IsNil)
+		c.Check(id, check.Equals, "chgid")
+		c.Check(cs.req.Method, check.Equals, "POST")
+		c.Check(cs.req.URL.Path, check.Equals, "/v2/snapshots")
+
+		var body map[string]interface{}
+		c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+		c.Check(body, check.DeepEquals, t.body)
+	}
+}
@@ -205,6 +205,18 @@ static void sc_setup_mount_profiles(const char *snap_name)
 		die("cannot open %s", profile_path);
 	}
 
+	// Record the applied entries as the current profile of the namespace so
+	// that snap-update-ns can later compute changes relative to it.
+	FILE *current_f __attribute__ ((cleanup(sc_cleanup_endmntent))) = NULL;
+	char current_path[PATH_MAX];
+	sc_must_snprintf(current_path, sizeof(current_path),
+			 "/run/snapd/ns/snap.%s.fstab", snap_name);
+	debug("opening current mount profile %s", current_path);
+	current_f = setmntent(current_path, "w");
+	if (current_f == NULL) {
+		die("cannot open %s", current_path);
+	}
+
 	struct mntent *m = NULL;
 	while ((m = getmntent(f)) != NULL) {
 		debug("read mount entry\n"
@@ -228,6 +240,9 @@ static void sc_setup_mount_profiles(const char *snap_name)
 			flags &= ~MS_RDONLY;
 		}
 		sc_do_mount(m->mnt_fsname, m->mnt_dir, NULL, flags, NULL);
+		if (addmntent(current_f, m) != 0) {
+			die("cannot write to %s", current_path);
+		}
 	}
 }
 
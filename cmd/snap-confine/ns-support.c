@@ -597,6 +597,15 @@ void sc_discard_preserved_ns_group(struct sc_ns_group *group)
 			break;
 		}
 	}
+	// Remove snap.${group_name}.fstab which describes the mounts applied to
+	// the preserved namespace (see snap-update-ns).
+	char fstab_fname[PATH_MAX];
+	sc_must_snprintf(fstab_fname, sizeof fstab_fname, "snap.%s.fstab",
+			 group->name);
+	debug("removing current mount profile %s", fstab_fname);
+	if (unlink(fstab_fname) < 0 && errno != ENOENT) {
+		die("cannot remove current mount profile %s", fstab_fname);
+	}
 	// Get back to the original directory
 	if (fchdir(old_dir_fd) < 0) {
 		die("cannot move back to original directory");
@@ -17,37 +17,38 @@
 #ifndef SNAP_CONFINE_SECCOMP_SUPPORT_H
 #define SNAP_CONFINE_SECCOMP_SUPPORT_H
 
-#include <seccomp.h>
+#include <linux/filter.h>
 
 /**
- * Prepare seccomp profile associated with the security tag.
+ * Read the compiled seccomp program associated with the security tag.
  *
- * This function loads the seccomp profile from
- * /var/lib/snapd/seccomp/profiles/$SECURITY_TAG and stores it into
- * scmp_filter_ctx object.
+ * This function reads the BPF program from
+ * /var/lib/snapd/seccomp/profiles/$SECURITY_TAG.bin. The program is compiled
+ * by snap-seccomp from the profile source written by snapd.
  *
- * The object is returned to the caller and can be made effective with a call
- * to sc_load_seccomp_context(). The returned value should be cleaned up with
- * seccomp_release().
+ * The program is returned to the caller and can be made effective with a call
+ * to sc_load_seccomp_filter(). The returned value should be cleaned up with
+ * sc_cleanup_seccomp_filter(). If the profile is unrestricted (or in complain
+ * mode) then NULL is returned as there is nothing to load.
  *
  * This function calls die() on all errors.
  **/
-
-scmp_filter_ctx sc_prepare_seccomp_context(const char *security_tag);
+struct sock_fprog *sc_read_seccomp_filter(const char *security_tag);
 
 /**
- * Load a seccomp context.
+ * Load a seccomp program into the kernel.
  *
- * This function calls seccomp_load(3) and handles errors if it fails.
+ * This function uses prctl(2) with PR_SET_SECCOMP and handles errors if it
+ * fails. Passing NULL does nothing.
  **/
-void sc_load_seccomp_context(scmp_filter_ctx ctx);
+void sc_load_seccomp_filter(struct sock_fprog *prog);
 
 /**
- * Release a seccomp context with seccomp_release(3)
+ * Release a seccomp program returned by sc_read_seccomp_filter().
  *
  * This function is designed to be used with
- * __attribute__((cleanup(sc_cleanup_seccomp_release))).
+ * __attribute__((cleanup(sc_cleanup_seccomp_filter))).
  **/
-void sc_cleanup_seccomp_release(scmp_filter_ctx * ptr);
+void sc_cleanup_seccomp_filter(struct sock_fprog **ptr);
 
 #endif
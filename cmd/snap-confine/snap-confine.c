@@ -106,9 +106,9 @@ int main(int argc, char **argv)
 	}
 	// TODO: check for similar situation and linux capabilities.
 #ifdef HAVE_SECCOMP
-	scmp_filter_ctx seccomp_ctx
-	    __attribute__ ((cleanup(sc_cleanup_seccomp_release))) = NULL;
-	seccomp_ctx = sc_prepare_seccomp_context(security_tag);
+	struct sock_fprog *seccomp_prog
+	    __attribute__ ((cleanup(sc_cleanup_seccomp_filter))) = NULL;
+	seccomp_prog = sc_read_seccomp_filter(security_tag);
 #endif				// ifdef HAVE_SECCOMP
 
 	if (geteuid() == 0) {
@@ -201,7 +201,7 @@ int main(int argc, char **argv)
 	// https://wiki.ubuntu.com/SecurityTeam/Specifications/SnappyConfinement
 	sc_maybe_aa_change_onexec(&apparmor, security_tag);
 #ifdef HAVE_SECCOMP
-	sc_load_seccomp_context(seccomp_ctx);
+	sc_load_seccomp_filter(seccomp_prog);
 #endif				// ifdef HAVE_SECCOMP
 
 	// Permanently drop if not root
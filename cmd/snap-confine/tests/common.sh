@@ -38,12 +38,24 @@ set_thread_area
 EOF
 }
 
-L="$(pwd)/snap-confine/snap-confine"
-export L
+SNAP_CONFINE="$(pwd)/snap-confine/snap-confine"
+export SNAP_CONFINE
 
 TMP="$(mktemp -d)"
 trap 'rm -rf $TMP' EXIT
 
+# snap-confine loads seccomp profiles compiled by snap-seccomp so compile the
+# profile written by the test (if any) before running snap-confine.
+run_snap_confine() {
+    rm -f "$TMP/$1.bin"
+    if [ -f "$TMP/$1" ]; then
+        "${SNAP_SECCOMP:-snap-seccomp}" compile "$TMP/$1" "$TMP/$1.bin" 2>/dev/null || true
+    fi
+    "$SNAP_CONFINE" "$@"
+}
+
+L=run_snap_confine
+
 export SNAPPY_LAUNCHER_SECCOMP_PROFILE_DIR="$TMP"
 export SNAPPY_LAUNCHER_INSIDE_TESTS="1"
 export SNAP_CONFINE_NO_ROOT=1
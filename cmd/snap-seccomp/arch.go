@@ -0,0 +1,196 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// Audit architecture identifiers, as found in linux/audit.h. The kernel
+// reports them in the arch field of struct seccomp_data.
+const (
+	auditArch64Bit = 0x80000000
+	auditArchLE    = 0x40000000
+
+	emI386    = 3
+	emPPC     = 20
+	emPPC64   = 21
+	emS390    = 22
+	emARM     = 40
+	emX86_64  = 62
+	emAARCH64 = 183
+)
+
+// seccompArch describes an architecture that filters can be compiled for.
+type seccompArch struct {
+	name      string
+	auditArch uint32
+	// is64Bit is true if system call arguments are 64 bits wide.
+	is64Bit bool
+	// isBigEndian is true if the architecture stores the most
+	// significant word of each argument first.
+	isBigEndian bool
+	syscalls    map[string]uint32
+	// constants overrides the values of the symbolic argument
+	// constants that differ on this architecture.
+	constants map[string]uint64
+}
+
+var (
+	archX86 = &seccompArch{
+		name:      "386",
+		auditArch: emI386 | auditArchLE,
+		syscalls:  syscalls386,
+	}
+	archAmd64 = &seccompArch{
+		name:      "amd64",
+		auditArch: emX86_64 | auditArch64Bit | auditArchLE,
+		is64Bit:   true,
+		syscalls:  syscallsAmd64,
+	}
+	archArm = &seccompArch{
+		name:      "arm",
+		auditArch: emARM | auditArchLE,
+		syscalls:  syscallsArm,
+	}
+	archArm64 = &seccompArch{
+		name:      "arm64",
+		auditArch: emAARCH64 | auditArch64Bit | auditArchLE,
+		is64Bit:   true,
+		syscalls:  syscallsArm64,
+	}
+	archPpc = &seccompArch{
+		name:        "ppc",
+		auditArch:   emPPC,
+		isBigEndian: true,
+		syscalls:    syscallsPpc,
+		constants:   ppcConstants,
+	}
+	archPpc64 = &seccompArch{
+		name:        "ppc64",
+		auditArch:   emPPC64 | auditArch64Bit,
+		is64Bit:     true,
+		isBigEndian: true,
+		syscalls:    syscallsPpc64,
+		constants:   ppcConstants,
+	}
+	archPpc64le = &seccompArch{
+		name:      "ppc64le",
+		auditArch: emPPC64 | auditArch64Bit | auditArchLE,
+		is64Bit:   true,
+		syscalls:  syscallsPpc64le,
+		constants: ppcConstants,
+	}
+	archS390x = &seccompArch{
+		name:        "s390x",
+		auditArch:   emS390 | auditArch64Bit,
+		is64Bit:     true,
+		isBigEndian: true,
+		syscalls:    syscallsS390x,
+	}
+)
+
+// archesByGoArch maps Go architecture names to seccomp architectures.
+var archesByGoArch = map[string]*seccompArch{
+	"386":     archX86,
+	"amd64":   archAmd64,
+	"arm":     archArm,
+	"arm64":   archArm64,
+	"ppc64":   archPpc64,
+	"ppc64le": archPpc64le,
+	"s390x":   archS390x,
+}
+
+// compatArches maps architectures to the architecture of the 32 bit
+// programs they can run as well. There is no 32 bit little endian powerpc
+// userspace, so ppc64le has no compat architecture.
+var compatArches = map[*seccompArch]*seccompArch{
+	archAmd64: archX86,
+	archArm64: archArm,
+	archPpc64: archPpc,
+}
+
+// archFromMachine returns the seccomp architecture of the given kernel
+// machine name (as reported by uname -m) or nil if it is not known.
+func archFromMachine(machine string) *seccompArch {
+	switch {
+	case machine == "i686" || machine == "i386":
+		return archX86
+	case machine == "x86_64":
+		return archAmd64
+	case strings.HasPrefix(machine, "armv7"):
+		return archArm
+	case strings.HasPrefix(machine, "aarch64"):
+		return archArm64
+	case strings.HasPrefix(machine, "ppc64le"):
+		return archPpc64le
+	case strings.HasPrefix(machine, "ppc64"):
+		return archPpc64
+	case machine == "ppc":
+		return archPpc
+	case strings.HasPrefix(machine, "s390x"):
+		return archS390x
+	}
+	return nil
+}
+
+// kernelMachine returns the machine name of the running kernel.
+func kernelMachine() string {
+	var buf syscall.Utsname
+	if err := syscall.Uname(&buf); err != nil {
+		return ""
+	}
+	// Utsname uses [65]int8 or [65]uint8, depending on the
+	// architecture, so convert it byte by byte.
+	machine := make([]byte, 0, len(buf.Machine))
+	for _, c := range buf.Machine {
+		if c == 0 {
+			break
+		}
+		machine = append(machine, byte(c))
+	}
+	return string(machine)
+}
+
+var (
+	goArch     = runtime.GOARCH
+	hostArchFn = func() *seccompArch { return archFromMachine(kernelMachine()) }
+)
+
+// targetArches returns the architectures a filter needs to handle: the
+// native one of snap-seccomp (and snap-confine), and either the compat
+// architecture of that or, when running a 32 bit userspace on a 64 bit
+// kernel, the one of the kernel.
+func targetArches() []*seccompArch {
+	native := archesByGoArch[goArch]
+	if native == nil {
+		return nil
+	}
+	host := hostArchFn()
+	if host == nil || host == native {
+		if compat := compatArches[native]; compat != nil {
+			return []*seccompArch{native, compat}
+		}
+		return []*seccompArch{native}
+	}
+	return []*seccompArch{native, host}
+}
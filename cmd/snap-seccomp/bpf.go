@@ -0,0 +1,292 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Classic BPF opcodes, see linux/filter.h and linux/bpf_common.h.
+const (
+	bpfLdWAbs = 0x00 | 0x00 | 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJmpJa  = 0x05 | 0x00        // BPF_JMP | BPF_JA
+	bpfJmpJeq = 0x05 | 0x10        // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJmpJgt = 0x05 | 0x20        // BPF_JMP | BPF_JGT | BPF_K
+	bpfJmpJge = 0x05 | 0x30        // BPF_JMP | BPF_JGE | BPF_K
+	bpfRetK   = 0x06 | 0x00        // BPF_RET | BPF_K
+
+	// bpfMaxInsns is the longest program the kernel accepts.
+	bpfMaxInsns = 4096
+)
+
+// Seccomp filter return values and offsets into struct seccomp_data,
+// see linux/seccomp.h.
+const (
+	seccompRetKill  = 0x00000000
+	seccompRetAllow = 0x7fff0000
+
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+
+	// x32SyscallBit is set in the system call numbers of the x32 ABI,
+	// which shares the audit architecture with x86_64.
+	x32SyscallBit = 0x40000000
+)
+
+// insn is a classic BPF instruction (struct sock_filter).
+type insn struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+// jump targets used while generating the instructions of a single rule;
+// they are resolved to relative offsets once the rule is complete.
+const (
+	// toNext continues with the next instruction.
+	toNext = iota
+	// toPass continues with the next argument filter of the rule.
+	toPass
+	// toFail skips to the next rule.
+	toFail
+)
+
+// ruleInsn is an instruction of a rule with unresolved jump targets.
+type ruleInsn struct {
+	insn
+	jt, jf int
+}
+
+func load(offset uint32) ruleInsn {
+	return ruleInsn{insn: insn{code: bpfLdWAbs, k: offset}}
+}
+
+func jump(code uint16, k uint32, jt, jf int) ruleInsn {
+	return ruleInsn{insn: insn{code: code, k: k}, jt: jt, jf: jf}
+}
+
+// argOffsets returns the offsets of the low and high 32 bit words of the
+// given system call argument.
+func argOffsets(arch *seccompArch, pos int) (lo, hi uint32) {
+	base := uint32(offsetArgs + 8*pos)
+	if arch.isBigEndian {
+		return base + 4, base
+	}
+	return base, base + 4
+}
+
+// genArgFilter returns the instructions checking a single argument. They
+// jump to toPass if the argument matches and to toFail otherwise.
+func genArgFilter(arch *seccompArch, f *argFilter) []ruleInsn {
+	value := f.valueFor(arch)
+	lo, hi := argOffsets(arch, f.pos)
+	lv, hv := uint32(value), uint32(value>>32)
+
+	if !arch.is64Bit {
+		// arguments are 32 bits wide, so their high word is always zero
+		if hv != 0 {
+			switch f.op {
+			case opEqual, opGreater, opGreaterEqual:
+				return []ruleInsn{jump(bpfJmpJeq, 0, toFail, toFail)}
+			default:
+				return nil
+			}
+		}
+		switch f.op {
+		case opEqual:
+			return []ruleInsn{load(lo), jump(bpfJmpJeq, lv, toPass, toFail)}
+		case opNotEqual:
+			return []ruleInsn{load(lo), jump(bpfJmpJeq, lv, toFail, toPass)}
+		case opGreater:
+			return []ruleInsn{load(lo), jump(bpfJmpJgt, lv, toPass, toFail)}
+		case opGreaterEqual:
+			return []ruleInsn{load(lo), jump(bpfJmpJge, lv, toPass, toFail)}
+		case opLess:
+			return []ruleInsn{load(lo), jump(bpfJmpJge, lv, toFail, toPass)}
+		case opLessEqual:
+			return []ruleInsn{load(lo), jump(bpfJmpJgt, lv, toFail, toPass)}
+		}
+		panic(fmt.Sprintf("unknown argument comparison %d", f.op))
+	}
+
+	// compare the high words first; only if they are equal do the low
+	// words matter
+	switch f.op {
+	case opEqual:
+		return []ruleInsn{
+			load(hi), jump(bpfJmpJeq, hv, toNext, toFail),
+			load(lo), jump(bpfJmpJeq, lv, toPass, toFail),
+		}
+	case opNotEqual:
+		return []ruleInsn{
+			load(hi), jump(bpfJmpJeq, hv, toNext, toPass),
+			load(lo), jump(bpfJmpJeq, lv, toFail, toPass),
+		}
+	case opGreater:
+		return []ruleInsn{
+			load(hi), jump(bpfJmpJgt, hv, toPass, toNext), jump(bpfJmpJeq, hv, toNext, toFail),
+			load(lo), jump(bpfJmpJgt, lv, toPass, toFail),
+		}
+	case opGreaterEqual:
+		return []ruleInsn{
+			load(hi), jump(bpfJmpJgt, hv, toPass, toNext), jump(bpfJmpJeq, hv, toNext, toFail),
+			load(lo), jump(bpfJmpJge, lv, toPass, toFail),
+		}
+	case opLess:
+		return []ruleInsn{
+			load(hi), jump(bpfJmpJgt, hv, toFail, toNext), jump(bpfJmpJeq, hv, toNext, toPass),
+			load(lo), jump(bpfJmpJge, lv, toFail, toPass),
+		}
+	case opLessEqual:
+		return []ruleInsn{
+			load(hi), jump(bpfJmpJgt, hv, toFail, toNext), jump(bpfJmpJeq, hv, toNext, toPass),
+			load(lo), jump(bpfJmpJgt, lv, toFail, toPass),
+		}
+	}
+	panic(fmt.Sprintf("unknown argument comparison %d", f.op))
+}
+
+// genRule returns the instructions allowing the system call with the given
+// number if its arguments match the filters of the rule. The
+// accumulator must hold the system call number if nrLoaded is set; the
+// second return value tells whether it still does after the rule.
+func genRule(arch *seccompArch, nr uint32, r *rule, nrLoaded bool) ([]insn, bool) {
+	// jump targets are collected as indexes of instructions of the rule,
+	// with -1 standing for the end of the rule
+	const end = -1
+	var insns []insn
+	var targets [][2]int
+	add := func(ris ...ruleInsn) {
+		pass := len(insns) + len(ris)
+		for _, ri := range ris {
+			i := len(insns)
+			var ts [2]int
+			for j, t := range [2]int{ri.jt, ri.jf} {
+				switch t {
+				case toNext:
+					ts[j] = i + 1
+				case toPass:
+					ts[j] = pass
+				case toFail:
+					ts[j] = end
+				}
+			}
+			insns = append(insns, ri.insn)
+			targets = append(targets, ts)
+		}
+	}
+
+	if !nrLoaded {
+		add(load(offsetNr))
+	}
+	add(jump(bpfJmpJeq, nr, toNext, toFail))
+	for i := range r.args {
+		add(genArgFilter(arch, &r.args[i])...)
+	}
+	add(ruleInsn{insn: insn{code: bpfRetK, k: seccompRetAllow}})
+
+	for i := range insns {
+		if insns[i].code&0x07 != 0x05 {
+			// not a jump
+			continue
+		}
+		jt, jf := targets[i][0], targets[i][1]
+		if jt == end {
+			jt = len(insns)
+		}
+		if jf == end {
+			jf = len(insns)
+		}
+		insns[i].jt = uint8(jt - i - 1)
+		insns[i].jf = uint8(jf - i - 1)
+	}
+	return insns, len(r.args) == 0
+}
+
+// genArch returns the instructions applying the profile to system calls
+// made with the given architecture. They start after the architecture
+// has been checked and end by killing the process if no rule allowed the
+// system call.
+func genArch(arch *seccompArch, p *profile) []insn {
+	insns := []insn{{code: bpfLdWAbs, k: offsetNr}}
+	if arch == archAmd64 {
+		// the x32 ABI is not supported
+		insns = append(insns,
+			insn{code: bpfJmpJge, jt: 0, jf: 1, k: x32SyscallBit},
+			insn{code: bpfRetK, k: seccompRetKill})
+	}
+	nrLoaded := true
+	for i := range p.rules {
+		nr, ok := arch.syscalls[p.rules[i].syscall]
+		if !ok {
+			// not available on this architecture
+			continue
+		}
+		var ruleInsns []insn
+		ruleInsns, nrLoaded = genRule(arch, nr, &p.rules[i], nrLoaded)
+		insns = append(insns, ruleInsns...)
+	}
+	return append(insns, insn{code: bpfRetK, k: seccompRetKill})
+}
+
+// compileProfile compiles the profile into a BPF program for the given
+// architectures. System calls made with any other architecture kill the
+// process.
+func compileProfile(p *profile, arches []*seccompArch) ([]insn, error) {
+	if p.unrestricted || p.complain {
+		// FIXME: complain mode is the equivalent of unrestricted until
+		// seccomp logging is in order.
+		return []insn{{code: bpfRetK, k: seccompRetAllow}}, nil
+	}
+
+	var prog []insn
+	for _, arch := range arches {
+		section := genArch(arch, p)
+		prog = append(prog,
+			insn{code: bpfLdWAbs, k: offsetArch},
+			insn{code: bpfJmpJeq, jt: 1, jf: 0, k: arch.auditArch},
+			// skip to the check of the next architecture
+			insn{code: bpfJmpJa, k: uint32(len(section))})
+		prog = append(prog, section...)
+	}
+	prog = append(prog, insn{code: bpfRetK, k: seccompRetKill})
+
+	if len(prog) > bpfMaxInsns {
+		return nil, fmt.Errorf("filter is too long (%d instructions, %d max)", len(prog), bpfMaxInsns)
+	}
+	return prog, nil
+}
+
+// encodeProgram returns the program in the layout of an array of struct
+// sock_filter, using the given byte order.
+func encodeProgram(prog []insn, order binary.ByteOrder) []byte {
+	buf := make([]byte, 8*len(prog))
+	for i, in := range prog {
+		b := buf[8*i:]
+		order.PutUint16(b[0:], in.code)
+		b[2] = in.jt
+		b[3] = in.jf
+		order.PutUint32(b[4:], in.k)
+	}
+	return buf
+}
@@ -0,0 +1,108 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// snap-seccomp compiles the seccomp profiles written by snapd into BPF
+// programs, so that snap-confine only needs to load them into the kernel
+// when starting an application.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+type cmdCompile struct {
+	Positionals struct {
+		Input  string `positional-arg-name:"<profile>" required:"yes"`
+		Output string `positional-arg-name:"<output>" required:"yes"`
+	} `positional-args:"true" required:"true"`
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	parser := flags.NewParser(nil, flags.HelpFlag|flags.PassDoubleDash)
+	if _, err := parser.AddCommand("compile", "Compile a seccomp profile",
+		"The compile command compiles the given seccomp profile into a BPF program\nthat can be loaded by snap-confine.", &cmdCompile{}); err != nil {
+		return err
+	}
+	if _, err := parser.ParseArgs(args); err != nil {
+		if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
+			fmt.Fprintln(os.Stdout, err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (x *cmdCompile) Execute(args []string) error {
+	return compile(x.Positionals.Input, x.Positionals.Output)
+}
+
+// nativeByteOrder returns the byte order of the native architecture.
+func nativeByteOrder(arches []*seccompArch) binary.ByteOrder {
+	if arches[0].isBigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// compile compiles the profile at the given path into a BPF program for
+// the running system, written to the given output path.
+func compile(in, out string) error {
+	arches := targetArches()
+	if len(arches) == 0 {
+		return fmt.Errorf("cannot compile seccomp profiles on architecture %q", goArch)
+	}
+
+	content, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	known := func(syscall string) bool {
+		for _, arch := range arches {
+			if _, ok := arch.syscalls[syscall]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	p, err := parseProfile(content, known)
+	if err != nil {
+		return fmt.Errorf("cannot parse %s: %v", in, err)
+	}
+	prog, err := compileProfile(p, arches)
+	if err != nil {
+		return fmt.Errorf("cannot compile %s: %v", in, err)
+	}
+
+	return osutil.AtomicWriteFile(out, encodeProgram(prog, nativeByteOrder(arches)), 0644, 0)
+}
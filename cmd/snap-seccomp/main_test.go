@@ -0,0 +1,409 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type snapSeccompSuite struct{}
+
+var _ = Suite(&snapSeccompSuite{})
+
+// seccompData mirrors struct seccomp_data.
+type seccompData struct {
+	nr   uint32
+	arch uint32
+	args [6]uint64
+}
+
+// bytes returns the data as the given architecture lays it out.
+func (d *seccompData) bytes(arch *seccompArch) []byte {
+	var order binary.ByteOrder = binary.LittleEndian
+	if arch.isBigEndian {
+		order = binary.BigEndian
+	}
+	buf := make([]byte, 64)
+	order.PutUint32(buf[0:], d.nr)
+	order.PutUint32(buf[4:], d.arch)
+	for i, arg := range d.args {
+		order.PutUint64(buf[16+8*i:], arg)
+	}
+	return buf
+}
+
+// runFilter interprets the (seccomp subset of) classic BPF program for a
+// system call made with the given architecture, and returns the action.
+func runFilter(c *C, prog []insn, arch *seccompArch, d seccompData) uint32 {
+	d.arch = arch.auditArch
+	data := d.bytes(arch)
+	var order binary.ByteOrder = binary.LittleEndian
+	if arch.isBigEndian {
+		order = binary.BigEndian
+	}
+	var a uint32
+	for pc := 0; pc < len(prog); pc++ {
+		in := prog[pc]
+		switch in.code {
+		case bpfLdWAbs:
+			c.Assert(in.k%4, Equals, uint32(0))
+			c.Assert(int(in.k)+4 <= len(data), Equals, true)
+			a = order.Uint32(data[in.k:])
+		case bpfJmpJa:
+			pc += int(in.k)
+		case bpfJmpJeq, bpfJmpJgt, bpfJmpJge:
+			var cond bool
+			switch in.code {
+			case bpfJmpJeq:
+				cond = a == in.k
+			case bpfJmpJgt:
+				cond = a > in.k
+			case bpfJmpJge:
+				cond = a >= in.k
+			}
+			if cond {
+				pc += int(in.jt)
+			} else {
+				pc += int(in.jf)
+			}
+		case bpfRetK:
+			return in.k
+		default:
+			c.Fatalf("unexpected instruction %#v", in)
+		}
+	}
+	c.Fatalf("program did not return")
+	return 0
+}
+
+func mustCompile(c *C, content string, arches ...*seccompArch) []insn {
+	known := func(syscall string) bool {
+		for _, arch := range arches {
+			if _, ok := arch.syscalls[syscall]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	p, err := parseProfile([]byte(content), known)
+	c.Assert(err, IsNil)
+	prog, err := compileProfile(p, arches)
+	c.Assert(err, IsNil)
+	return prog
+}
+
+func (s *snapSeccompSuite) TestParseProfile(c *C) {
+	known := func(syscall string) bool { return syscall != "unknown" }
+	p, err := parseProfile([]byte(`# a comment
+read
+  write
+
+unknown potato
+socket AF_UNIX SOCK_STREAM
+setpriority - 0 <=10
+mbind 1 !2 >3 >=4 <5 <=6
+`), known)
+	c.Assert(err, IsNil)
+	c.Check(p, DeepEquals, &profile{rules: []rule{
+		{syscall: "read"},
+		{syscall: "write"},
+		{syscall: "socket", args: []argFilter{
+			{pos: 0, op: opEqual, value: 1, name: "AF_UNIX"},
+			{pos: 1, op: opEqual, value: 1, name: "SOCK_STREAM"},
+		}},
+		{syscall: "setpriority", args: []argFilter{
+			{pos: 1, op: opEqual, value: 0},
+			{pos: 2, op: opLessEqual, value: 10},
+		}},
+		{syscall: "mbind", args: []argFilter{
+			{pos: 0, op: opEqual, value: 1},
+			{pos: 1, op: opNotEqual, value: 2},
+			{pos: 2, op: opGreater, value: 3},
+			{pos: 3, op: opGreaterEqual, value: 4},
+			{pos: 4, op: opLess, value: 5},
+			{pos: 5, op: opLessEqual, value: 6},
+		}},
+	}})
+}
+
+func (s *snapSeccompSuite) TestParseProfileMarkers(c *C) {
+	known := func(string) bool { return true }
+	p, err := parseProfile([]byte("@unrestricted\nread\n"), known)
+	c.Assert(err, IsNil)
+	c.Check(p.unrestricted, Equals, true)
+	c.Check(p.complain, Equals, false)
+
+	p, err = parseProfile([]byte("# comment\n@complain  \nread\n"), known)
+	c.Assert(err, IsNil)
+	c.Check(p.unrestricted, Equals, false)
+	c.Check(p.complain, Equals, true)
+}
+
+func (s *snapSeccompSuite) TestParseProfileEmbeddedNUL(c *C) {
+	known := func(string) bool { return true }
+	// anything after a NUL is ignored
+	p, err := parseProfile([]byte("socket SOCK_STREAM\x00bad stuff\n"), known)
+	c.Assert(err, IsNil)
+	c.Check(p.rules, HasLen, 1)
+
+	_, err = parseProfile([]byte("socket S\x00CK_STREAM\n"), known)
+	c.Check(err, ErrorMatches, `cannot parse line 1: invalid argument "S"`)
+}
+
+func (s *snapSeccompSuite) TestParseProfileErrors(c *C) {
+	known := func(string) bool { return true }
+	for _, arg := range []string{
+		"bar", "-1", "0 - -1 0", "--10", "0:10", "1-10", "0,1", "0x0", "a1",
+		"1a", "1-", `1\n1`, `1\ 2`, ">", "<=", "!",
+		"AF_UNI", "AF_UNIXX", "AF_UN!X", "PF_UNI", "- SOCK_STREA",
+		"999999999999999999999999999999999999999999999999999999999999999",
+	} {
+		_, err := parseProfile([]byte("setpriority "+arg+"\n"), known)
+		c.Check(err, ErrorMatches, `cannot parse line 1: invalid argument .*`, Commentf("%q", arg))
+	}
+
+	for _, args := range []string{"- - - - - - 7", "1 2 3 4 5 6 7"} {
+		_, err := parseProfile([]byte("read\nmbind "+args+"\n"), known)
+		c.Check(err, ErrorMatches, `cannot parse line 2: too many arguments \(6 max\)`)
+	}
+
+	_, err := parseProfile([]byte("# some comment\nbaddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd\n"), known)
+	c.Check(err, ErrorMatches, `line 2 is too long \(80 characters max\)`)
+}
+
+func (s *snapSeccompSuite) TestParseProfileIgnoresArgsOfUnknownSyscalls(c *C) {
+	known := func(syscall string) bool { return syscall == "read" }
+	p, err := parseProfile([]byte("read\n  # not a comment, but not a syscall either\n"), known)
+	c.Assert(err, IsNil)
+	c.Check(p.rules, HasLen, 1)
+}
+
+func (s *snapSeccompSuite) TestCompileUnrestrictedAndComplain(c *C) {
+	for _, content := range []string{"@unrestricted\nread\n", "@complain\nread\n"} {
+		prog := mustCompile(c, content, archAmd64)
+		c.Check(prog, DeepEquals, []insn{{code: bpfRetK, k: seccompRetAllow}})
+	}
+}
+
+func (s *snapSeccompSuite) TestCompileWhitelist(c *C) {
+	prog := mustCompile(c, "read\nwrite\nsocketcall\n", archAmd64, archX86)
+
+	read := seccompData{nr: archAmd64.syscalls["read"]}
+	c.Check(runFilter(c, prog, archAmd64, read), Equals, uint32(seccompRetAllow))
+	write := seccompData{nr: archAmd64.syscalls["write"]}
+	c.Check(runFilter(c, prog, archAmd64, write), Equals, uint32(seccompRetAllow))
+	open := seccompData{nr: archAmd64.syscalls["open"]}
+	c.Check(runFilter(c, prog, archAmd64, open), Equals, uint32(seccompRetKill))
+	// x32 system calls are not allowed
+	x32read := seccompData{nr: x32SyscallBit | archAmd64.syscalls["read"]}
+	c.Check(runFilter(c, prog, archAmd64, x32read), Equals, uint32(seccompRetKill))
+
+	// the compat architecture uses its own numbers
+	read = seccompData{nr: archX86.syscalls["read"]}
+	c.Check(runFilter(c, prog, archX86, read), Equals, uint32(seccompRetAllow))
+	socketcall := seccompData{nr: archX86.syscalls["socketcall"]}
+	c.Check(runFilter(c, prog, archX86, socketcall), Equals, uint32(seccompRetAllow))
+	open = seccompData{nr: archX86.syscalls["open"]}
+	c.Check(runFilter(c, prog, archX86, open), Equals, uint32(seccompRetKill))
+
+	// other architectures are not allowed at all
+	read = seccompData{nr: archArm.syscalls["read"]}
+	c.Check(runFilter(c, prog, archArm, read), Equals, uint32(seccompRetKill))
+}
+
+func (s *snapSeccompSuite) TestCompileWhitelistPpcCompat(c *C) {
+	prog := mustCompile(c, "read\nsocketcall\n", archPpc64, archPpc)
+
+	read := seccompData{nr: archPpc64.syscalls["read"]}
+	c.Check(runFilter(c, prog, archPpc64, read), Equals, uint32(seccompRetAllow))
+	read = seccompData{nr: archPpc.syscalls["read"]}
+	c.Check(runFilter(c, prog, archPpc, read), Equals, uint32(seccompRetAllow))
+	socketcall := seccompData{nr: archPpc.syscalls["socketcall"]}
+	c.Check(runFilter(c, prog, archPpc, socketcall), Equals, uint32(seccompRetAllow))
+	open := seccompData{nr: archPpc.syscalls["open"]}
+	c.Check(runFilter(c, prog, archPpc, open), Equals, uint32(seccompRetKill))
+
+	// ppc64le shares the machine but not the audit architecture
+	read = seccompData{nr: archPpc64le.syscalls["read"]}
+	c.Check(runFilter(c, prog, archPpc64le, read), Equals, uint32(seccompRetKill))
+}
+
+func (s *snapSeccompSuite) TestCompileArgs(c *C) {
+	for _, t := range []struct {
+		rules   string
+		allowed []uint64
+		denied  []uint64
+	}{
+		{"setpriority - - 10", []uint64{10}, []uint64{0, 9, 11, 10 | 1<<32}},
+		{"setpriority - - !10", []uint64{0, 9, 11, 10 | 1<<32}, []uint64{10}},
+		{"setpriority - - >10", []uint64{11, 1 << 32, 1<<32 | 5}, []uint64{0, 10}},
+		{"setpriority - - >=10", []uint64{10, 11, 1 << 32}, []uint64{0, 9}},
+		{"setpriority - - <10", []uint64{0, 9}, []uint64{10, 11, 1 << 32, 1<<32 | 5}},
+		{"setpriority - - <=10", []uint64{0, 9, 10}, []uint64{11, 1 << 32}},
+		{"setpriority - - 4294967306", []uint64{10 | 1<<32}, []uint64{10}},
+		{"setpriority - - <=9\nsetpriority - - >=11", []uint64{9, 11}, []uint64{10}},
+		{"setpriority - - >=9\nsetpriority - - <=11", []uint64{9, 10, 11}, nil},
+	} {
+		for _, arch := range []*seccompArch{archAmd64, archS390x} {
+			prog := mustCompile(c, t.rules, arch)
+			nr := arch.syscalls["setpriority"]
+			for _, v := range t.allowed {
+				d := seccompData{nr: nr, args: [6]uint64{0, 0, v}}
+				c.Check(runFilter(c, prog, arch, d), Equals, uint32(seccompRetAllow), Commentf("%s: %s %d", arch.name, t.rules, v))
+			}
+			for _, v := range t.denied {
+				d := seccompData{nr: nr, args: [6]uint64{0, 0, v}}
+				c.Check(runFilter(c, prog, arch, d), Equals, uint32(seccompRetKill), Commentf("%s: %s %d", arch.name, t.rules, v))
+			}
+		}
+	}
+}
+
+func (s *snapSeccompSuite) TestCompileArgs32Bit(c *C) {
+	for _, t := range []struct {
+		rules string
+		allow bool
+	}{
+		{"setpriority - - 10", true},
+		{"setpriority - - 11", false},
+		{"setpriority - - <=10", true},
+		{"setpriority - - >10", false},
+		{"setpriority - - 4294967306", false},
+		{"setpriority - - !4294967306", true},
+		{"setpriority - - <4294967306", true},
+		{"setpriority - - >=4294967306", false},
+	} {
+		prog := mustCompile(c, t.rules, archArm)
+		d := seccompData{nr: archArm.syscalls["setpriority"], args: [6]uint64{0, 0, 10}}
+		expected := uint32(seccompRetKill)
+		if t.allow {
+			expected = seccompRetAllow
+		}
+		c.Check(runFilter(c, prog, archArm, d), Equals, expected, Commentf("%s", t.rules))
+	}
+}
+
+func (s *snapSeccompSuite) TestCompileAllArgs(c *C) {
+	prog := mustCompile(c, "mbind 1 !2 >3 >=4 <5 <=6\n", archArm64)
+	nr := archArm64.syscalls["mbind"]
+	d := seccompData{nr: nr, args: [6]uint64{1, 0, 4, 4, 4, 6}}
+	c.Check(runFilter(c, prog, archArm64, d), Equals, uint32(seccompRetAllow))
+	for i, bad := range []uint64{2, 2, 3, 3, 5, 7} {
+		d := seccompData{nr: nr, args: [6]uint64{1, 0, 4, 4, 4, 6}}
+		d.args[i] = bad
+		c.Check(runFilter(c, prog, archArm64, d), Equals, uint32(seccompRetKill), Commentf("arg %d", i))
+	}
+}
+
+func (s *snapSeccompSuite) TestCompileArchConstants(c *C) {
+	for _, t := range []struct {
+		arch  *seccompArch
+		value uint64
+	}{
+		{archAmd64, 0x5412},
+		{archPpc64le, 0x80017472},
+		{archPpc64, 0x80017472},
+	} {
+		prog := mustCompile(c, "ioctl - TIOCSTI\n", t.arch)
+		d := seccompData{nr: t.arch.syscalls["ioctl"], args: [6]uint64{0, t.value}}
+		c.Check(runFilter(c, prog, t.arch, d), Equals, uint32(seccompRetAllow))
+		d.args[1] = 0x5413
+		c.Check(runFilter(c, prog, t.arch, d), Equals, uint32(seccompRetKill))
+	}
+}
+
+func (s *snapSeccompSuite) TestTargetArches(c *C) {
+	defer func(goarch string, hostArch func() *seccompArch) {
+		goArch = goarch
+		hostArchFn = hostArch
+	}(goArch, hostArchFn)
+
+	for _, t := range []struct {
+		goarch  string
+		machine string
+		arches  []*seccompArch
+	}{
+		{"amd64", "x86_64", []*seccompArch{archAmd64, archX86}},
+		{"386", "x86_64", []*seccompArch{archX86, archAmd64}},
+		{"386", "i686", []*seccompArch{archX86}},
+		{"arm64", "aarch64", []*seccompArch{archArm64, archArm}},
+		{"arm", "armv7l", []*seccompArch{archArm}},
+		{"arm", "aarch64", []*seccompArch{archArm, archArm64}},
+		{"ppc64", "ppc64", []*seccompArch{archPpc64, archPpc}},
+		{"ppc64le", "ppc64le", []*seccompArch{archPpc64le}},
+		{"s390x", "s390x", []*seccompArch{archS390x}},
+		{"s390x", "", []*seccompArch{archS390x}},
+		{"mips", "mips", nil},
+	} {
+		goArch = t.goarch
+		machine := t.machine
+		hostArchFn = func() *seccompArch { return archFromMachine(machine) }
+		c.Check(targetArches(), DeepEquals, t.arches, Commentf("%s on %s", t.goarch, t.machine))
+	}
+}
+
+func (s *snapSeccompSuite) TestCompile(c *C) {
+	defer func(goarch string, hostArch func() *seccompArch) {
+		goArch = goarch
+		hostArchFn = hostArch
+	}(goArch, hostArchFn)
+	goArch = "s390x"
+	hostArchFn = func() *seccompArch { return archS390x }
+
+	dir := c.MkDir()
+	in := filepath.Join(dir, "snap.foo.app.src")
+	out := filepath.Join(dir, "snap.foo.app.bin")
+	c.Assert(ioutil.WriteFile(in, []byte("@complain\n"), 0644), IsNil)
+
+	c.Assert(run([]string{"compile", in, out}), IsNil)
+	bin, err := ioutil.ReadFile(out)
+	c.Assert(err, IsNil)
+	// a single big endian "ret ALLOW"
+	c.Check(bin, DeepEquals, []byte{0, 0x06, 0, 0, 0x7f, 0xff, 0, 0})
+}
+
+func (s *snapSeccompSuite) TestCompileErrors(c *C) {
+	dir := c.MkDir()
+	in := filepath.Join(dir, "snap.foo.app.src")
+	out := filepath.Join(dir, "snap.foo.app.bin")
+
+	err := run([]string{"compile", in, out})
+	c.Check(err, ErrorMatches, `open .*/snap.foo.app.src: no such file or directory`)
+
+	c.Assert(ioutil.WriteFile(in, []byte("read\nsocket AF_POTATO\n"), 0644), IsNil)
+	err = run([]string{"compile", in, out})
+	c.Check(err, ErrorMatches, `cannot parse .*/snap.foo.app.src: cannot parse line 2: invalid argument "AF_POTATO"`)
+
+	err = run([]string{"compile", in})
+	c.Check(err, ErrorMatches, "the required argument `<output>` was not provided")
+}
+
+func (s *snapSeccompSuite) TestEncodeProgram(c *C) {
+	prog := []insn{{code: bpfJmpJeq, jt: 1, jf: 2, k: 0x01020304}}
+	c.Check(encodeProgram(prog, binary.LittleEndian), DeepEquals, []byte{0x15, 0, 1, 2, 4, 3, 2, 1})
+	c.Check(encodeProgram(prog, binary.BigEndian), DeepEquals, []byte{0, 0x15, 1, 2, 1, 2, 3, 4})
+}
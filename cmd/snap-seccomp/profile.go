@@ -0,0 +1,202 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxLineLength is the longest line that can be used in a profile
+	// (snap-confine historically used a 80 character line buffer).
+	maxLineLength = 80
+	// maxArgs is the number of system call arguments a rule can filter on.
+	maxArgs = 6
+)
+
+// argOp is the comparison an argument filter performs.
+type argOp int
+
+const (
+	opEqual argOp = iota
+	opNotEqual
+	opGreater
+	opGreaterEqual
+	opLess
+	opLessEqual
+)
+
+// argFilter compares a single system call argument with a value.
+type argFilter struct {
+	pos   int
+	op    argOp
+	value uint64
+	// name is the symbolic name of the value, if one was used.
+	name string
+}
+
+// valueFor returns the value to compare with on the given architecture.
+func (f *argFilter) valueFor(arch *seccompArch) uint64 {
+	if v, ok := arch.constants[f.name]; ok && f.name != "" {
+		return v
+	}
+	return f.value
+}
+
+// rule allows a system call, optionally only when its arguments match all
+// of the filters.
+type rule struct {
+	syscall string
+	args    []argFilter
+}
+
+// profile is a parsed seccomp profile, as written by snapd.
+type profile struct {
+	// unrestricted is set by the "@unrestricted" marker, used with
+	// classic confinement.
+	unrestricted bool
+	// complain is set by the "@complain" marker, used with devmode.
+	complain bool
+	rules    []rule
+}
+
+// readNumber parses an argument value, which is either a decimal number
+// or one of the known symbolic constants.
+func readNumber(s string) (value uint64, name string, err error) {
+	if s == "" || s[0] == '-' {
+		return 0, "", fmt.Errorf("invalid argument %q", s)
+	}
+	isNumber := true
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			isNumber = false
+			break
+		}
+	}
+	if isNumber {
+		value, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid argument %q: out of range", s)
+		}
+		return value, "", nil
+	}
+	value, ok := argConstants[s]
+	if !ok {
+		return 0, "", fmt.Errorf("invalid argument %q", s)
+	}
+	return value, s, nil
+}
+
+// parseArg parses the filter for the argument at the given position,
+// e.g. "AF_UNIX", "10", ">=0" or "!2".
+func parseArg(pos int, token string) (argFilter, error) {
+	f := argFilter{pos: pos, op: opEqual}
+	s := token
+	switch {
+	case len(token) == 1:
+		// a single digit
+	case strings.HasPrefix(token, ">="):
+		f.op, s = opGreaterEqual, token[2:]
+	case strings.HasPrefix(token, "<="):
+		f.op, s = opLessEqual, token[2:]
+	case strings.HasPrefix(token, "!"):
+		f.op, s = opNotEqual, token[1:]
+	case strings.HasPrefix(token, ">"):
+		f.op, s = opGreater, token[1:]
+	case strings.HasPrefix(token, "<"):
+		f.op, s = opLess, token[1:]
+	}
+	var err error
+	f.value, f.name, err = readNumber(s)
+	return f, err
+}
+
+// parseRule parses the tokens of a profile line such as "socket AF_UNIX"
+// or "setpriority - - <=10".
+func parseRule(tokens []string) (rule, error) {
+	r := rule{syscall: tokens[0]}
+	args := tokens[1:]
+	if len(args) > maxArgs {
+		return rule{}, fmt.Errorf("too many arguments (%d max)", maxArgs)
+	}
+	for pos, token := range args {
+		// "-" means any value
+		if token == "-" {
+			continue
+		}
+		f, err := parseArg(pos, token)
+		if err != nil {
+			return rule{}, err
+		}
+		r.args = append(r.args, f)
+	}
+	return r, nil
+}
+
+// parseProfile parses the text of a seccomp profile: a whitelist of system
+// calls, one per line, each optionally followed by filters on its
+// arguments. Lines starting with "#" are comments. Lines for system calls
+// that are not known (as told by the given function) are ignored.
+func parseProfile(content []byte, known func(syscall string) bool) (*profile, error) {
+	p := &profile{}
+	for i, line := range strings.Split(string(content), "\n") {
+		lineno := i + 1
+		// comment, ignore
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(line) > maxLineLength {
+			return nil, fmt.Errorf("line %d is too long (%d characters max)", lineno, maxLineLength)
+		}
+		// like snap-confine did, ignore anything after an embedded NUL
+		if idx := bytes.IndexByte([]byte(line), 0); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimRight(line, " \t\r\n\v\f")
+		switch line {
+		case "":
+			continue
+		case "@unrestricted":
+			p.unrestricted = true
+			continue
+		case "@complain":
+			p.complain = true
+			continue
+		}
+		tokens := strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' })
+		if len(tokens) == 0 {
+			continue
+		}
+		// as this is a whitelist, system calls that do not exist on
+		// any of the architectures can be ignored
+		if !known(tokens[0]) {
+			continue
+		}
+		r, err := parseRule(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse line %d: %v", lineno, err)
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
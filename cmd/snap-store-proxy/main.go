@@ -0,0 +1,121 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+// snap-store-proxy is an on-premise proxy for the snap store, caching
+// snaps, deltas and assertions for a fleet of devices sharing an
+// uplink.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/cmd"
+	"github.com/snapcore/snapd/httputil"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/strutil"
+)
+
+type options struct {
+	Dir          string `long:"dir" required:"yes" description:"Directory to keep the cached snaps, deltas and assertions in"`
+	MaxCacheSize string `long:"max-cache-size" default:"20G" description:"Size the cached snaps and deltas are kept under"`
+	Addr         string `long:"addr" default:"localhost:8443" description:"Address to listen on"`
+	TLSCert      string `long:"tls-cert" description:"Certificate file to serve https with"`
+	TLSKey       string `long:"tls-key" description:"Key file of the certificate to serve https with"`
+	URL          string `long:"url" description:"Base https URL the devices reach the proxy at, defaults to https://<addr>/ when serving https, needed otherwise"`
+}
+
+func main() {
+	if err := logger.SimpleSetup(); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to activate logging: %v\n", err)
+	}
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	var opts options
+	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
+	if _, err := parser.ParseArgs(args); err != nil {
+		if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
+			fmt.Fprintln(os.Stdout, err)
+			return nil
+		}
+		return err
+	}
+
+	proxy, err := newProxyFromOptions(&opts)
+	if err != nil {
+		return err
+	}
+
+	httputil.SetUserAgentFromVersion(cmd.Version, "snap-store-proxy")
+	logger.Noticef("serving the store at %s", proxy.url)
+	if opts.TLSCert != "" {
+		return http.ListenAndServeTLS(opts.Addr, opts.TLSCert, opts.TLSKey, proxy)
+	}
+	// https is provided by a front end
+	return http.ListenAndServe(opts.Addr, proxy)
+}
+
+func newProxyFromOptions(opts *options) (*Proxy, error) {
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return nil, fmt.Errorf("need both a certificate and its key to serve https")
+	}
+	rawURL := opts.URL
+	if rawURL == "" {
+		if opts.TLSCert == "" {
+			return nil, fmt.Errorf("need the https URL the proxy is reached at when not serving https itself")
+		}
+		rawURL = "https://" + opts.Addr + "/"
+	}
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	// devices only accept store assertions with https URLs
+	if proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("proxy URL must use https: %q", rawURL)
+	}
+	maxCacheSize, err := strutil.ParseByteSize(opts.MaxCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maximum cache size: %v", err)
+	}
+
+	// proxy the same store snapd would use, honouring the same
+	// environment overrides
+	cfg := store.DefaultConfig()
+	apiURL, err := cfg.SearchURI.Parse("..")
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := NewProxy(opts.Dir, proxyURL, apiURL, cfg.AssertionsURI)
+	if err != nil {
+		return nil, err
+	}
+	proxy.maxCacheSize = maxCacheSize
+	return proxy, nil
+}
@@ -0,0 +1,539 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/httputil"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// Proxy is an on-premise caching proxy for the snap store.
+//
+// It forwards the store API requests under api/v1/ to the main store,
+// rewriting the download URLs of snaps and deltas in the responses to
+// point to itself, so that the blobs are downloaded only once and then
+// served from its cache directory. Assertions requested under
+// v1/assertions/ are forwarded to the main store as well, and kept in
+// the cache to be served when the store cannot be reached. The least
+// recently used entries are evicted when the cache grows over its size
+// limit.
+//
+// Devices are pointed at the proxy with a store assertion carrying
+// its URL and the proxy.store core option; they still verify all
+// snaps against the assertions signed for them.
+type Proxy struct {
+	url           *url.URL
+	apiURL        *url.URL
+	assertionsURL *url.URL
+
+	snapsDir      string
+	assertionsDir string
+
+	maxCacheSize           int64
+	maxAssertionsCacheSize int64
+
+	client *http.Client
+	mux    *http.ServeMux
+
+	mu    sync.Mutex
+	inUse map[string]*cacheEntryLock
+}
+
+// cacheEntryLock serializes the fetching of a cache entry, and keeps
+// it from being evicted while in use.
+type cacheEntryLock struct {
+	sync.Mutex
+	users int
+}
+
+const (
+	defaultMaxCacheSize           = 20 * 1000 * 1000 * 1000
+	defaultMaxAssertionsCacheSize = 100 * 1000 * 1000
+)
+
+// NewProxy creates a new proxy reachable at the base URL proxyURL,
+// forwarding to the store APIs at apiURL and to its assertions service
+// at assertionsURL, and caching under cacheDir.
+func NewProxy(cacheDir string, proxyURL, apiURL, assertionsURL *url.URL) (*Proxy, error) {
+	p := &Proxy{
+		url:           withSlash(proxyURL),
+		apiURL:        withSlash(apiURL),
+		assertionsURL: withSlash(assertionsURL),
+		snapsDir:      filepath.Join(cacheDir, "snaps"),
+		assertionsDir: filepath.Join(cacheDir, "assertions"),
+		client:        httputil.NewHTTPClient(nil),
+		mux:           http.NewServeMux(),
+		inUse:         make(map[string]*cacheEntryLock),
+
+		maxCacheSize:           defaultMaxCacheSize,
+		maxAssertionsCacheSize: defaultMaxAssertionsCacheSize,
+	}
+	for _, dir := range []string{p.snapsDir, p.assertionsDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("cannot create cache directory: %v", err)
+		}
+	}
+
+	p.mux.HandleFunc("/api/v1/", p.forwardAPI)
+	p.mux.HandleFunc("/v1/assertions/", p.serveAssertion)
+	p.mux.HandleFunc("/download/", p.serveDownload)
+
+	return p, nil
+}
+
+func withSlash(u *url.URL) *url.URL {
+	v := *u
+	if !strings.HasSuffix(v.Path, "/") {
+		v.Path += "/"
+	}
+	return &v
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the proxy might be served under a prefix of its URL
+	prefix := strings.TrimSuffix(p.url.Path, "/")
+	if prefix != "" {
+		http.StripPrefix(prefix, p.mux).ServeHTTP(w, r)
+		return
+	}
+	p.mux.ServeHTTP(w, r)
+}
+
+// forwardedHeaders are the request headers passed along to the store.
+var forwardedHeaders = []string{
+	"Accept",
+	"Authorization",
+	"Content-Type",
+	"Snap-Accept-Delta-Format",
+	"User-Agent",
+	"X-Device-Authorization",
+	"X-Ubuntu-Architecture",
+	"X-Ubuntu-Classic",
+	"X-Ubuntu-Confinement",
+	"X-Ubuntu-Delta-Formats",
+	"X-Ubuntu-Device-Channel",
+	"X-Ubuntu-No-CDN",
+	"X-Ubuntu-Release",
+	"X-Ubuntu-Series",
+	"X-Ubuntu-Store",
+	"X-Ubuntu-Wire-Protocol",
+}
+
+// credentialHeaders are the forwarded headers that are only passed
+// along over https.
+var credentialHeaders = map[string]bool{
+	"Authorization":          true,
+	"X-Device-Authorization": true,
+}
+
+func (p *Proxy) upstreamRequest(method string, u *url.URL, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range forwardedHeaders {
+		if credentialHeaders[h] && u.Scheme != "https" {
+			continue
+		}
+		if v := header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	return p.client.Do(req)
+}
+
+func upstreamURL(base *url.URL, r *http.Request, prefix string) *url.URL {
+	u := base.ResolveReference(&url.URL{Path: strings.TrimPrefix(r.URL.Path, prefix)})
+	u.RawQuery = r.URL.RawQuery
+	return u
+}
+
+func copyHeaders(w http.ResponseWriter, resp *http.Response) {
+	for k, vs := range resp.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+}
+
+func (p *Proxy) forwardAPI(w http.ResponseWriter, r *http.Request) {
+	resp, err := p.upstreamRequest(r.Method, upstreamURL(p.apiURL, r, "/api/v1/"), r.Body, r.Header)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot reach the store: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	copyHeaders(w, resp)
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	var doc interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		http.Error(w, fmt.Sprintf("cannot decode store response: %v", err), http.StatusBadGateway)
+		return
+	}
+	doc, err = p.rewriteDownloadURLs(doc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(resp.StatusCode)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// rewriteDownloadURLs walks the decoded JSON document, replacing
+// the anonymous and authenticated download URLs with the proxy ones.
+func (p *Proxy) rewriteDownloadURLs(doc interface{}) (interface{}, error) {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for k, sub := range v {
+			if s, ok := sub.(string); ok && (k == "anon_download_url" || k == "download_url") && s != "" {
+				proxied, err := p.downloadURL(s, k == "download_url")
+				if err != nil {
+					return nil, err
+				}
+				v[k] = proxied
+				continue
+			}
+			rewritten, err := p.rewriteDownloadURLs(sub)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = rewritten
+		}
+	case []interface{}:
+		for i, sub := range v {
+			rewritten, err := p.rewriteDownloadURLs(sub)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = rewritten
+		}
+	}
+	return doc, nil
+}
+
+func cacheKey(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// download is the record of an original download URL kept in the
+// cache next to the blob.
+type download struct {
+	URL string `json:"url"`
+	// Auth is set for downloads that need the store to authorize
+	// each device or user getting them.
+	Auth bool `json:"auth,omitempty"`
+}
+
+// downloadURL records the original download URL in the cache and
+// returns the proxy URL for it.
+func (p *Proxy) downloadURL(orig string, auth bool) (string, error) {
+	key := cacheKey(orig)
+	recordPath := filepath.Join(p.snapsDir, key+".url")
+	if osutil.FileExists(recordPath) {
+		touch(recordPath)
+	} else {
+		record, err := json.Marshal(&download{URL: orig, Auth: auth})
+		if err != nil {
+			return "", err
+		}
+		if err := osutil.AtomicWriteFile(recordPath, record, 0644, 0); err != nil {
+			return "", fmt.Errorf("cannot record download URL: %v", err)
+		}
+	}
+	return p.url.ResolveReference(&url.URL{Path: "download/" + key}).String(), nil
+}
+
+// touch marks a cache entry as recently used.
+func touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+var validCacheKey = regexp.MustCompile("^[0-9a-f]{64}$")
+
+func (p *Proxy) serveDownload(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/download/")
+	if !validCacheKey.MatchString(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	lock := p.use(key)
+	defer p.release(key, lock)
+
+	blobPath := filepath.Join(p.snapsDir, key)
+	status, err := p.fetchBlob(lock, key, blobPath, r)
+	if err != nil {
+		logger.Noticef("cannot fetch blob %s: %v", key, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	touch(blobPath + ".url")
+
+	// ServeFile handles Range requests, so interrupted downloads
+	// can be resumed
+	http.ServeFile(w, r, blobPath)
+}
+
+// use marks the cache entry with the given key as in use until
+// release is called, and returns the lock to fetch it with.
+func (p *Proxy) use(key string) *cacheEntryLock {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lock := p.inUse[key]
+	if lock == nil {
+		lock = &cacheEntryLock{}
+		p.inUse[key] = lock
+	}
+	lock.users++
+	return lock
+}
+
+func (p *Proxy) release(key string, lock *cacheEntryLock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lock.users--
+	if lock.users == 0 {
+		delete(p.inUse, key)
+	}
+}
+
+// fetchBlob makes sure the blob with the given key is in the cache,
+// downloading it from its original URL if needed. For downloads that
+// need authorization the store is asked every time whether the client
+// may get the blob. On error it also returns the HTTP status to
+// respond with.
+func (p *Proxy) fetchBlob(lock *cacheEntryLock, key, blobPath string, r *http.Request) (int, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	data, err := ioutil.ReadFile(blobPath + ".url")
+	if os.IsNotExist(err) {
+		return http.StatusNotFound, fmt.Errorf("unknown download")
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	var record download
+	if err := json.Unmarshal(data, &record); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot read download record: %v", err)
+	}
+	origURL, err := url.Parse(record.URL)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	header := http.Header{}
+	header.Set("User-Agent", r.Header.Get("User-Agent"))
+	if record.Auth {
+		// pass along the credentials of the client, to let the
+		// store decide whether it can get the blob
+		header = r.Header
+	}
+
+	if osutil.FileExists(blobPath) {
+		if !record.Auth {
+			return 0, nil
+		}
+		resp, err := p.upstreamRequest("HEAD", origURL, nil, header)
+		if err != nil {
+			return http.StatusBadGateway, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, fmt.Errorf("download not authorized by the store: %s", resp.Status)
+		}
+		return 0, nil
+	}
+
+	resp, err := p.upstreamRequest("GET", origURL, nil, header)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		status := http.StatusBadGateway
+		if resp.StatusCode < 500 {
+			status = resp.StatusCode
+		}
+		return status, fmt.Errorf("unexpected status from the store: %s", resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile(p.snapsDir, key+".partial")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return http.StatusBadGateway, err
+	}
+	if err := tmp.Close(); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := os.Rename(tmp.Name(), blobPath); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	p.evict(p.snapsDir, p.maxCacheSize)
+	return 0, nil
+}
+
+// minEntrySize is the size accounted for the smallest files, for the
+// size limit to also bound the number of cache entries.
+const minEntrySize = 4096
+
+type cacheEntry struct {
+	key   string
+	files []string
+	size  int64
+	used  time.Time
+}
+
+type byLastUse []*cacheEntry
+
+func (es byLastUse) Len() int           { return len(es) }
+func (es byLastUse) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
+func (es byLastUse) Less(i, j int) bool { return es[i].used.Before(es[j].used) }
+
+// evict removes the least recently used entries of the cache in dir
+// until it is under maxSize, skipping the ones in use. The files of
+// an entry are named after its key.
+func (p *Proxy) evict(dir string, maxSize int64) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logger.Noticef("cannot evict cache entries: %v", err)
+		return
+	}
+	var total int64
+	entries := make(map[string]*cacheEntry)
+	for _, fi := range fis {
+		if len(fi.Name()) < 64 || !validCacheKey.MatchString(fi.Name()[:64]) {
+			continue
+		}
+		key := fi.Name()[:64]
+		entry := entries[key]
+		if entry == nil {
+			entry = &cacheEntry{key: key}
+			entries[key] = entry
+		}
+		size := fi.Size()
+		if size < minEntrySize {
+			size = minEntrySize
+		}
+		entry.files = append(entry.files, filepath.Join(dir, fi.Name()))
+		entry.size += size
+		if fi.ModTime().After(entry.used) {
+			entry.used = fi.ModTime()
+		}
+		total += size
+	}
+	if total <= maxSize {
+		return
+	}
+
+	lru := make([]*cacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		lru = append(lru, entry)
+	}
+	sort.Sort(byLastUse(lru))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range lru {
+		if total <= maxSize {
+			break
+		}
+		if p.inUse[entry.key] != nil {
+			continue
+		}
+		for _, fn := range entry.files {
+			if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				logger.Noticef("cannot evict cache entry %s: %v", entry.key, err)
+			}
+		}
+		total -= entry.size
+	}
+}
+
+func (p *Proxy) serveAssertion(w http.ResponseWriter, r *http.Request) {
+	cachePath := filepath.Join(p.assertionsDir, cacheKey(r.URL.Path+"?"+r.URL.RawQuery))
+
+	resp, err := p.upstreamRequest(r.Method, upstreamURL(p.assertionsURL, r, "/v1/assertions/"), nil, r.Header)
+	if err == nil && resp.StatusCode < 500 {
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot read store response: %v", err), http.StatusBadGateway)
+			return
+		}
+		if resp.StatusCode == http.StatusOK {
+			if err := osutil.AtomicWriteFile(cachePath, body, 0644, 0); err != nil {
+				logger.Noticef("cannot cache assertion %s: %v", r.URL.Path, err)
+			}
+			p.evict(p.assertionsDir, p.maxAssertionsCacheSize)
+		}
+		copyHeaders(w, resp)
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+		return
+	}
+	if err == nil {
+		resp.Body.Close()
+		err = fmt.Errorf("unexpected status from the store: %s", resp.Status)
+	}
+
+	// the store is not available, fallback to the cache
+	body, cerr := ioutil.ReadFile(cachePath)
+	if cerr != nil {
+		http.Error(w, fmt.Sprintf("cannot reach the store: %v", err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x.ubuntu.assertion")
+	w.Write(body)
+}
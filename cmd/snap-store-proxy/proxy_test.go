@@ -0,0 +1,346 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type proxySuite struct {
+	upstream *httptest.Server
+	server   *httptest.Server
+	proxy    *Proxy
+
+	blobHits      int
+	authHits      []string
+	assertionsErr bool
+}
+
+var _ = Suite(&proxySuite{})
+
+const testAssertion = `type: snap-declaration
+authority-id: canonical
+series: 16
+snap-id: foo-id
+`
+
+func (s *proxySuite) SetUpTest(c *C) {
+	s.blobHits = 0
+	s.authHits = nil
+	s.assertionsErr = false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/snaps/details/foo", func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("X-Device-Authorization"), Equals, `Macaroon root="device-macaroon"`)
+		c.Check(r.URL.Query().Get("channel"), Equals, "stable")
+		w.Header().Set("Content-Type", "application/hal+json")
+		fmt.Fprintf(w, `{
+  "package_name": "foo",
+  "anon_download_url": "%[1]s/blobs/foo_1.snap",
+  "download_url": "%[1]s/auth/foo_1.snap",
+  "deltas": [{"anon_download_url": "%[1]s/blobs/foo_0_1.delta", "format": "xdelta3"}]
+}`, s.upstream.URL)
+	})
+	mux.HandleFunc("/blobs/", func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("X-Device-Authorization"), Equals, "")
+		s.blobHits++
+		w.Write([]byte("blob-content-of-" + r.URL.Path[len("/blobs/"):]))
+	})
+	mux.HandleFunc("/auth/foo_1.snap", func(w http.ResponseWriter, r *http.Request) {
+		s.authHits = append(s.authHits, r.Method)
+		if r.Header.Get("Authorization") != `Macaroon root="user-macaroon"` {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("paid-blob-content"))
+	})
+	mux.HandleFunc("/v1/assertions/snap-declaration/16/foo-id", func(w http.ResponseWriter, r *http.Request) {
+		if s.assertionsErr {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c.Check(r.URL.Query().Get("max-format"), Equals, "1")
+		w.Header().Set("Content-Type", "application/x.ubuntu.assertion")
+		w.Write([]byte(testAssertion))
+	})
+	s.upstream = httptest.NewTLSServer(mux)
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.proxy.ServeHTTP(w, r)
+	}))
+
+	proxyURL, err := url.Parse(s.server.URL)
+	c.Assert(err, IsNil)
+	apiURL, err := url.Parse(s.upstream.URL + "/api/v1/")
+	c.Assert(err, IsNil)
+	assertionsURL, err := url.Parse(s.upstream.URL + "/v1/assertions/")
+	c.Assert(err, IsNil)
+	s.proxy, err = NewProxy(c.MkDir(), proxyURL, apiURL, assertionsURL)
+	c.Assert(err, IsNil)
+	s.proxy.client = s.upstream.Client()
+}
+
+func (s *proxySuite) TearDownTest(c *C) {
+	s.server.Close()
+	s.upstream.Close()
+}
+
+func (s *proxySuite) get(c *C, path string, header http.Header) *http.Response {
+	req, err := http.NewRequest("GET", s.server.URL+path, nil)
+	c.Assert(err, IsNil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	return resp
+}
+
+func (s *proxySuite) details(c *C) map[string]interface{} {
+	header := http.Header{}
+	header.Set("X-Device-Authorization", `Macaroon root="device-macaroon"`)
+	resp := s.get(c, "/api/v1/snaps/details/foo?channel=stable", header)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Check(resp.Header.Get("Content-Type"), Equals, "application/hal+json")
+
+	var details map[string]interface{}
+	err := json.NewDecoder(resp.Body).Decode(&details)
+	c.Assert(err, IsNil)
+	return details
+}
+
+func (s *proxySuite) TestDetailsRewritesDownloadURLs(c *C) {
+	details := s.details(c)
+
+	c.Check(details["package_name"], Equals, "foo")
+	c.Check(details["anon_download_url"], Matches, s.server.URL+"/download/[0-9a-f]{64}")
+	c.Check(details["download_url"], Matches, s.server.URL+"/download/[0-9a-f]{64}")
+	c.Check(details["download_url"], Not(Equals), details["anon_download_url"])
+	deltas := details["deltas"].([]interface{})
+	c.Assert(deltas, HasLen, 1)
+	delta := deltas[0].(map[string]interface{})
+	c.Check(delta["anon_download_url"], Matches, s.server.URL+"/download/[0-9a-f]{64}")
+	c.Check(delta["anon_download_url"], Not(Equals), details["anon_download_url"])
+}
+
+func (s *proxySuite) TestDownloadIsCached(c *C) {
+	details := s.details(c)
+	downloadURL := details["anon_download_url"].(string)
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(downloadURL)
+		c.Assert(err, IsNil)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Check(resp.StatusCode, Equals, http.StatusOK)
+		c.Check(string(body), Equals, "blob-content-of-foo_1.snap")
+	}
+	c.Check(s.blobHits, Equals, 1)
+
+	// partial downloads can be resumed
+	req, err := http.NewRequest("GET", downloadURL, nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Range", "bytes=5-")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Check(resp.StatusCode, Equals, http.StatusPartialContent)
+	c.Check(string(body), Equals, "content-of-foo_1.snap")
+	c.Check(s.blobHits, Equals, 1)
+	c.Check(s.proxy.inUse, HasLen, 0)
+}
+
+func (s *proxySuite) TestAuthDownloadIsAuthorizedByTheStore(c *C) {
+	details := s.details(c)
+	downloadURL := details["download_url"].(string)
+
+	userAuth := http.Header{}
+	userAuth.Set("Authorization", `Macaroon root="user-macaroon"`)
+	for _, t := range []struct {
+		header http.Header
+		status int
+		body   string
+	}{
+		{nil, http.StatusUnauthorized, ""},
+		{userAuth, http.StatusOK, "paid-blob-content"},
+		// cached, but the store is still asked
+		{nil, http.StatusUnauthorized, ""},
+		{userAuth, http.StatusOK, "paid-blob-content"},
+	} {
+		resp := s.get(c, downloadURL[len(s.server.URL):], t.header)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Check(resp.StatusCode, Equals, t.status)
+		if t.body != "" {
+			c.Check(string(body), Equals, t.body)
+		}
+	}
+	c.Check(s.authHits, DeepEquals, []string{"GET", "GET", "HEAD", "HEAD"})
+}
+
+func (s *proxySuite) TestNoCredentialsOverHTTP(c *C) {
+	var header http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer upstream.Close()
+	apiURL, err := url.Parse(upstream.URL + "/api/v1/")
+	c.Assert(err, IsNil)
+	s.proxy.apiURL = apiURL
+
+	req := http.Header{}
+	req.Set("Authorization", `Macaroon root="user-macaroon"`)
+	req.Set("X-Device-Authorization", `Macaroon root="device-macaroon"`)
+	req.Set("X-Ubuntu-Series", "16")
+	resp := s.get(c, "/api/v1/snaps/details/foo", req)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusNotFound)
+
+	c.Check(header.Get("X-Ubuntu-Series"), Equals, "16")
+	c.Check(header.Get("Authorization"), Equals, "")
+	c.Check(header.Get("X-Device-Authorization"), Equals, "")
+}
+
+func (s *proxySuite) TestDownloadEvictsLeastRecentlyUsed(c *C) {
+	// room for a single entry of a blob and its URL record
+	s.proxy.maxCacheSize = 3 * minEntrySize
+
+	details := s.details(c)
+	snapURL := details["anon_download_url"].(string)
+	deltaURL := details["deltas"].([]interface{})[0].(map[string]interface{})["anon_download_url"].(string)
+	snapKey := snapURL[len(snapURL)-64:]
+	deltaKey := deltaURL[len(deltaURL)-64:]
+
+	resp, err := http.Get(snapURL)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+	c.Check(osutil.FileExists(filepath.Join(s.proxy.snapsDir, snapKey)), Equals, true)
+	// used a while ago
+	old := time.Now().Add(-time.Hour)
+	for _, fn := range []string{snapKey, snapKey + ".url"} {
+		c.Assert(os.Chtimes(filepath.Join(s.proxy.snapsDir, fn), old, old), IsNil)
+	}
+
+	resp, err = http.Get(deltaURL)
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+	c.Check(string(body), Equals, "blob-content-of-foo_0_1.delta")
+
+	c.Check(osutil.FileExists(filepath.Join(s.proxy.snapsDir, snapKey)), Equals, false)
+	c.Check(osutil.FileExists(filepath.Join(s.proxy.snapsDir, snapKey+".url")), Equals, false)
+	c.Check(osutil.FileExists(filepath.Join(s.proxy.snapsDir, deltaKey)), Equals, true)
+	c.Check(s.proxy.inUse, HasLen, 0)
+
+	// the evicted download is unknown now
+	resp, err = http.Get(snapURL)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusNotFound)
+}
+
+func (s *proxySuite) TestDownloadUnknown(c *C) {
+	for _, path := range []string{
+		"/download/" + cacheKey("http://example.com/unknown.snap"),
+		"/download/../../etc/passwd",
+		"/download/not-a-key",
+	} {
+		resp := s.get(c, path, nil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, Not(Equals), http.StatusOK, Commentf(path))
+	}
+}
+
+func (s *proxySuite) TestAssertionFallbackToCache(c *C) {
+	const path = "/v1/assertions/snap-declaration/16/foo-id?max-format=1"
+
+	resp := s.get(c, path, nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+	c.Check(string(body), Equals, testAssertion)
+
+	// the store is having trouble, the cached assertion is served
+	s.assertionsErr = true
+	resp = s.get(c, path, nil)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+	c.Check(resp.Header.Get("Content-Type"), Equals, "application/x.ubuntu.assertion")
+	c.Check(string(body), Equals, testAssertion)
+
+	// nothing cached for other queries
+	resp = s.get(c, "/v1/assertions/snap-declaration/16/foo-id?max-format=2", nil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusBadGateway)
+}
+
+func (s *proxySuite) TestNewProxyFromOptions(c *C) {
+	proxy, err := newProxyFromOptions(&options{Dir: c.MkDir(), MaxCacheSize: "20G", Addr: "localhost:8999", TLSCert: "cert.pem", TLSKey: "key.pem"})
+	c.Assert(err, IsNil)
+	c.Check(proxy.url.String(), Equals, "https://localhost:8999/")
+	c.Check(proxy.apiURL.Path, Equals, "/api/v1/")
+	c.Check(proxy.assertionsURL.Path, Equals, "/v1/assertions/")
+	c.Check(proxy.maxCacheSize, Equals, int64(20*1000*1000*1000))
+
+	proxy, err = newProxyFromOptions(&options{Dir: c.MkDir(), MaxCacheSize: "1M", Addr: ":8999", URL: "https://proxy.example.com/store"})
+	c.Assert(err, IsNil)
+	c.Check(proxy.url.String(), Equals, "https://proxy.example.com/store/")
+	c.Check(proxy.maxCacheSize, Equals, int64(1000*1000))
+
+	for _, t := range []struct {
+		opts   options
+		errStr string
+	}{
+		{options{MaxCacheSize: "20G", Addr: ":8999"}, "need the https URL the proxy is reached at when not serving https itself"},
+		{options{MaxCacheSize: "20G", Addr: ":8999", TLSCert: "cert.pem"}, "need both a certificate and its key to serve https"},
+		{options{MaxCacheSize: "20G", Addr: ":8999", URL: "http://proxy.example.com/"}, `proxy URL must use https: "http://proxy.example.com/"`},
+		{options{MaxCacheSize: "lots", Addr: ":8999", URL: "https://proxy.example.com/"}, `invalid maximum cache size: .*`},
+	} {
+		t.opts.Dir = c.MkDir()
+		_, err := newProxyFromOptions(&t.opts)
+		c.Check(err, ErrorMatches, t.errStr)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// IMPORTANT: all the code in this file may be run with elevated privileges
+// when invoking snap-update-ns from the setuid snap-confine.
+//
+// This file is a preprocessor for snap-update-ns' main() function. It will
+// perform some basic checks and then switch to the mount namespace of the
+// snap given on the command line. This has to happen before the Go runtime
+// starts any threads as setns(2) with CLONE_NEWNS fails in multi-threaded
+// processes.
+
+#define _GNU_SOURCE
+
+#include "bootstrap.h"
+
+#include <errno.h>
+#include <fcntl.h>
+#include <limits.h>
+#include <sched.h>
+#include <stdio.h>
+#include <string.h>
+#include <unistd.h>
+
+int bootstrap_errno = 0;
+const char *bootstrap_msg = NULL;
+
+// validate_snap_name performs full validation of the given name.
+//
+// The rules are the same as those in snap/validate.go: the name may contain
+// lowercase letters, digits and dashes, must contain at least one letter and
+// may not start or end with a dash, nor contain two dashes in a row.
+int validate_snap_name(const char *snap_name)
+{
+	size_t len = strlen(snap_name);
+	int has_letter = 0;
+
+	if (len == 0) {
+		bootstrap_msg = "snap name cannot be empty";
+		return -1;
+	}
+	if (snap_name[0] == '-' || snap_name[len - 1] == '-') {
+		bootstrap_msg = "snap name cannot start or end with a dash";
+		return -1;
+	}
+	for (size_t i = 0; i < len; i++) {
+		char c = snap_name[i];
+		if (c >= 'a' && c <= 'z') {
+			has_letter = 1;
+		} else if (c == '-') {
+			if (snap_name[i + 1] == '-') {
+				bootstrap_msg =
+				    "snap name cannot contain two consecutive dashes";
+				return -1;
+			}
+		} else if (!(c >= '0' && c <= '9')) {
+			bootstrap_msg =
+			    "snap name must use lower case letters, digits or dashes";
+			return -1;
+		}
+	}
+	if (!has_letter) {
+		bootstrap_msg = "snap name must contain at least one letter";
+		return -1;
+	}
+	return 0;
+}
+
+// bootstrap prepares snap-update-ns to work in the namespace of the snap given
+// on command line.
+//
+// Errors are reported through bootstrap_msg and bootstrap_errno so that
+// they can be presented by the Go code once it is running.
+__attribute__ ((constructor))
+void bootstrap(int argc, char **argv, char **envp)
+{
+	// Options, including those used by "go test", are handled by the Go
+	// code so nothing is done here unless the first argument is a snap
+	// name.
+	if (argc < 2 || argv[1][0] == '-') {
+		bootstrap_msg = "snap name not provided";
+		return;
+	}
+	const char *snap_name = argv[1];
+	if (validate_snap_name(snap_name) < 0) {
+		return;
+	}
+	// NOTE: This value has to be synchronized with snap-confine.
+	char buf[PATH_MAX] = { 0 };
+	int n = snprintf(buf, sizeof buf, "/run/snapd/ns/%s.mnt", snap_name);
+	if (n < 0 || (size_t)n >= sizeof buf) {
+		bootstrap_msg = "cannot format mount namespace file name";
+		return;
+	}
+	int fd = open(buf, O_RDONLY | O_CLOEXEC | O_NOFOLLOW);
+	if (fd < 0) {
+		bootstrap_errno = errno;
+		bootstrap_msg = "cannot open mount namespace file";
+		return;
+	}
+	if (setns(fd, CLONE_NEWNS) < 0) {
+		bootstrap_errno = errno;
+		bootstrap_msg = "cannot switch mount namespace";
+	}
+	close(fd);
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+#ifndef SNAP_UPDATE_NS_BOOTSTRAP_H
+#define SNAP_UPDATE_NS_BOOTSTRAP_H
+
+// bootstrap_errno contains a copy of errno if a system call fails.
+extern int bootstrap_errno;
+// bootstrap_msg contains a static string if something fails.
+extern const char *bootstrap_msg;
+
+// bootstrap prepares snap-update-ns to work in the namespace of the snap given
+// on command line.
+void bootstrap(int argc, char **argv, char **envp);
+
+// validate_snap_name checks if the given snap name is well-formed.
+int validate_snap_name(const char *snap_name);
+
+#endif
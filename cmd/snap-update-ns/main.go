@@ -20,10 +20,17 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces/mount"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
 )
 
 var opts struct {
@@ -49,6 +56,116 @@ func run() error {
 	if err := parseArgs(os.Args[1:]); err != nil {
 		return err
 	}
+	if err := bootstrapError(); err != nil {
+		return err
+	}
+
+	return updateNamespace(opts.Positionals.SnapName)
+}
+
+// desiredProfilePath returns the path of the mount profile that snapd wants
+// the snap to have, as written by the mount security backend.
+func desiredProfilePath(snapName string) string {
+	return filepath.Join(dirs.SnapMountPolicyDir, fmt.Sprintf("snap.%s.fstab", snapName))
+}
+
+// currentProfilePath returns the path of the mount profile describing the
+// mounts that are applied to the preserved namespace of the snap.
+func currentProfilePath(snapName string) string {
+	// NOTE: This value has to be synchronized with snap-confine
+	return filepath.Join(dirs.SnapRunNsDir, fmt.Sprintf("snap.%s.fstab", snapName))
+}
+
+// loadProfile loads a mount profile, a missing file is an empty profile.
+func loadProfile(fname string) ([]mount.Entry, error) {
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return mount.LoadFSTab(f)
+}
 
-	return fmt.Errorf("not implemented")
+// saveProfile atomically writes a mount profile.
+func saveProfile(fname string, entries []mount.Entry) error {
+	var buf bytes.Buffer
+	if err := mount.SaveFSTab(&buf, entries); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(fname, buf.Bytes(), 0644, 0)
+}
+
+var performChange = (*mount.Change).Perform
+
+// applyChanges performs the given changes in order and returns the changes
+// that were performed. If any change fails the changes performed so far are
+// undone in reverse order and the ones that could not be undone are
+// returned along with the original error.
+func applyChanges(changes []mount.Change) ([]mount.Change, error) {
+	var performed []mount.Change
+	for _, change := range changes {
+		if err := performChange(&change); err != nil {
+			for i := len(performed) - 1; i >= 0; i-- {
+				undo := performed[i].Reverse()
+				if undoErr := performChange(&undo); undoErr != nil {
+					logger.Noticef("cannot undo mount namespace change: %s: %s", undo, undoErr)
+					continue
+				}
+				performed = append(performed[:i], performed[i+1:]...)
+			}
+			return performed, fmt.Errorf("cannot %s %q: %s", change.Action, change.Entry.Dir, err)
+		}
+		performed = append(performed, change)
+	}
+	return performed, nil
+}
+
+// profileAfter computes the profile resulting from applying the given
+// (performed) changes to the given profile.
+func profileAfter(profile []mount.Entry, changes []mount.Change) []mount.Entry {
+	result := make([]mount.Entry, 0, len(profile))
+	result = append(result, profile...)
+	for _, change := range changes {
+		switch change.Action {
+		case mount.Mount:
+			result = append(result, change.Entry)
+		case mount.Unmount:
+			for i := len(result) - 1; i >= 0; i-- {
+				if filepath.Clean(result[i].Dir) == filepath.Clean(change.Entry.Dir) {
+					result = append(result[:i], result[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return result
+}
+
+// updateNamespace applies the changes between the current and the desired
+// mount profile of the given snap to the (already entered) mount namespace.
+func updateNamespace(snapName string) error {
+	desired, err := loadProfile(desiredProfilePath(snapName))
+	if err != nil {
+		return fmt.Errorf("cannot load desired mount profile of snap %q: %s", snapName, err)
+	}
+	current, err := loadProfile(currentProfilePath(snapName))
+	if err != nil {
+		return fmt.Errorf("cannot load current mount profile of snap %q: %s", snapName, err)
+	}
+
+	changes := mount.NeededChanges(current, desired)
+	performed, applyErr := applyChanges(changes)
+
+	// Record what is really in the namespace now, even on failure, so that
+	// the next run starts from an accurate picture.
+	if err := saveProfile(currentProfilePath(snapName), profileAfter(current, performed)); err != nil {
+		return fmt.Errorf("cannot save current mount profile of snap %q: %s", snapName, err)
+	}
+	return applyErr
 }
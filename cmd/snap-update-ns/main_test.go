@@ -20,9 +20,15 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces/mount"
 )
 
 func Test(t *testing.T) { TestingT(t) }
@@ -30,3 +36,138 @@ func Test(t *testing.T) { TestingT(t) }
 type snapUpdateNsSuite struct{}
 
 var _ = Suite(&snapUpdateNsSuite{})
+
+// mockSystemCalls replaces the way mount changes are performed with the
+// given functions.
+func mockSystemCalls(mountFn func(source, target, fstype string, flags uintptr, data string) error, unmountFn func(target string, flags int) error) (restore func()) {
+	old := performChange
+	performChange = func(change *mount.Change) error {
+		switch change.Action {
+		case mount.Mount:
+			return mountFn(change.Entry.Name, change.Entry.Dir, change.Entry.Type, 0, "")
+		case mount.Unmount:
+			return unmountFn(change.Entry.Dir, 0)
+		}
+		return fmt.Errorf("unexpected action %q", change.Action)
+	}
+	return func() { performChange = old }
+}
+
+func (s *snapUpdateNsSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+}
+
+func (s *snapUpdateNsSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("")
+}
+
+func (s *snapUpdateNsSuite) TestUpdateNamespace(c *C) {
+	var calls []string
+	restore := mockSystemCalls(func(source, target, fstype string, flags uintptr, data string) error {
+		calls = append(calls, fmt.Sprintf("mount %s %s", source, target))
+		return nil
+	}, func(target string, flags int) error {
+		calls = append(calls, fmt.Sprintf("unmount %s", target))
+		return nil
+	})
+	defer restore()
+
+	c.Assert(os.MkdirAll(dirs.SnapMountPolicyDir, 0755), IsNil)
+	c.Assert(os.MkdirAll(dirs.SnapRunNsDir, 0755), IsNil)
+	desired := "/snap/producer/1/new /snap/consumer/1/new none bind,ro 0 0\n/snap/producer/1/kept /snap/consumer/1/kept none bind,ro 0 0\n"
+	current := "/snap/producer/1/kept /snap/consumer/1/kept none bind,ro 0 0\n/snap/producer/1/old /snap/consumer/1/old none bind,ro 0 0\n"
+	c.Assert(ioutil.WriteFile(desiredProfilePath("consumer"), []byte(desired), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(currentProfilePath("consumer"), []byte(current), 0644), IsNil)
+
+	c.Assert(updateNamespace("consumer"), IsNil)
+	c.Check(calls, DeepEquals, []string{
+		"unmount /snap/consumer/1/old",
+		"mount /snap/producer/1/new /snap/consumer/1/new",
+	})
+
+	// the current profile now describes the namespace
+	content, err := ioutil.ReadFile(currentProfilePath("consumer"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "/snap/producer/1/kept /snap/consumer/1/kept none bind,ro 0 0\n/snap/producer/1/new /snap/consumer/1/new none bind,ro 0 0\n")
+}
+
+func (s *snapUpdateNsSuite) TestUpdateNamespaceNoProfiles(c *C) {
+	restore := mockSystemCalls(func(source, target, fstype string, flags uintptr, data string) error {
+		c.Fatalf("unexpected mount")
+		return nil
+	}, func(target string, flags int) error {
+		c.Fatalf("unexpected unmount")
+		return nil
+	})
+	defer restore()
+
+	c.Assert(updateNamespace("consumer"), IsNil)
+	content, err := ioutil.ReadFile(currentProfilePath("consumer"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "")
+}
+
+func (s *snapUpdateNsSuite) TestUpdateNamespaceRollback(c *C) {
+	var calls []string
+	restore := mockSystemCalls(func(source, target, fstype string, flags uintptr, data string) error {
+		calls = append(calls, fmt.Sprintf("mount %s %s", source, target))
+		if target == "/snap/consumer/1/b" {
+			return fmt.Errorf("boom")
+		}
+		return nil
+	}, func(target string, flags int) error {
+		calls = append(calls, fmt.Sprintf("unmount %s", target))
+		return nil
+	})
+	defer restore()
+
+	c.Assert(os.MkdirAll(dirs.SnapMountPolicyDir, 0755), IsNil)
+	c.Assert(os.MkdirAll(dirs.SnapRunNsDir, 0755), IsNil)
+	desired := "/snap/producer/1/a /snap/consumer/1/a none bind,ro 0 0\n/snap/producer/1/b /snap/consumer/1/b none bind,ro 0 0\n"
+	current := "/snap/producer/1/old /snap/consumer/1/old none bind,ro 0 0\n"
+	c.Assert(ioutil.WriteFile(desiredProfilePath("consumer"), []byte(desired), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(currentProfilePath("consumer"), []byte(current), 0644), IsNil)
+
+	err := updateNamespace("consumer")
+	c.Assert(err, ErrorMatches, `cannot mount "/snap/consumer/1/b": boom`)
+	c.Check(calls, DeepEquals, []string{
+		"unmount /snap/consumer/1/old",
+		"mount /snap/producer/1/a /snap/consumer/1/a",
+		"mount /snap/producer/1/b /snap/consumer/1/b",
+		// rollback
+		"unmount /snap/consumer/1/a",
+		"mount /snap/producer/1/old /snap/consumer/1/old",
+	})
+
+	// the current profile is unchanged
+	content, err := ioutil.ReadFile(currentProfilePath("consumer"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, current)
+}
+
+func (s *snapUpdateNsSuite) TestUpdateNamespaceFailedRollback(c *C) {
+	restore := mockSystemCalls(func(source, target, fstype string, flags uintptr, data string) error {
+		if target == "/snap/consumer/1/b" {
+			return fmt.Errorf("boom")
+		}
+		return nil
+	}, func(target string, flags int) error {
+		if target == "/snap/consumer/1/a" {
+			return fmt.Errorf("busy")
+		}
+		return nil
+	})
+	defer restore()
+
+	c.Assert(os.MkdirAll(dirs.SnapMountPolicyDir, 0755), IsNil)
+	desired := "/snap/producer/1/a /snap/consumer/1/a none bind,ro 0 0\n/snap/producer/1/b /snap/consumer/1/b none bind,ro 0 0\n"
+	c.Assert(ioutil.WriteFile(desiredProfilePath("consumer"), []byte(desired), 0644), IsNil)
+
+	err := updateNamespace("consumer")
+	c.Assert(err, ErrorMatches, `cannot mount "/snap/consumer/1/b": boom`)
+
+	// the mount that could not be undone is recorded
+	content, err := ioutil.ReadFile(currentProfilePath("consumer"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "/snap/producer/1/a /snap/consumer/1/a none bind,ro 0 0\n")
+}
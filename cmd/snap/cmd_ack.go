@@ -20,8 +20,13 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"github.com/snapcore/snapd/i18n"
 
@@ -29,6 +34,7 @@ import (
 )
 
 type cmdAck struct {
+	waitMixin
 	AckOptions struct {
 		AssertionFile flags.Filename
 	} `positional-args:"true" required:"true"`
@@ -44,14 +50,21 @@ will replace.
 To succeed the assertion must be valid, its signature verified with a known
 public key and the assertion consistent with and its prerequisite in the
 database.
+
+Instead of a single assertion file, a bundle can be given: a directory or a
+tarball (optionally gzip compressed) containing snaps (*.snap) together with
+the assertions (*.assert) needed to verify them, that is their
+snap-declarations, snap-revisions and the chain of account-keys signing them.
+The assertions are added to the system assertion database and the snaps are
+then installed, or refreshed, without needing access to the store.
 `)
 
 func init() {
 	addCommand("ack", shortAckHelp, longAckHelp, func() flags.Commander {
 		return &cmdAck{}
-	}, nil, []argDesc{{
+	}, waitDescs, []argDesc{{
 		name: i18n.G("<assertion file>"),
-		desc: i18n.G("Assertion file"),
+		desc: i18n.G("Assertion file, or bundle directory or tarball"),
 	}})
 }
 
@@ -64,11 +77,103 @@ func ackFile(assertFile string) error {
 	return Client().Ack(assertData)
 }
 
+// isTarball returns whether the file at path looks like a tarball,
+// possibly gzip compressed.
+func isTarball(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var head [512]byte
+	n, err := io.ReadFull(f, head[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	if n >= 2 && head[0] == 0x1f && head[1] == 0x8b {
+		return true, nil
+	}
+	return n >= 262 && bytes.Equal(head[257:262], []byte("ustar")), nil
+}
+
+// tarDir streams a tarball of the regular files directly in dir.
+func tarDir(dir string) (io.ReadCloser, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		for _, fi := range fis {
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+			if err := addFileToTar(tw, filepath.Join(dir, fi.Name()), fi); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr, nil
+}
+
+func addFileToTar(tw *tar.Writer, path string, fi os.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func (x *cmdAck) ackBundle(path string, isDir bool) error {
+	var bundle io.ReadCloser
+	var err error
+	if isDir {
+		bundle, err = tarDir(path)
+	} else {
+		bundle, err = os.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+
+	cli := Client()
+	changeID, err := cli.AckBundle(bundle)
+	if err != nil {
+		return fmt.Errorf(i18n.G("cannot import bundle: %v"), err)
+	}
+	if _, err := x.wait(cli, changeID); err != nil && err != noWait {
+		return err
+	}
+	return nil
+}
+
 func (x *cmdAck) Execute(args []string) error {
 	if len(args) > 0 {
 		return ErrExtraArgs
 	}
-	if err := ackFile(string(x.AckOptions.AssertionFile)); err != nil {
+	path := string(x.AckOptions.AssertionFile)
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return x.ackBundle(path, true)
+	}
+	if tarball, err := isTarball(path); err == nil && tarball {
+		return x.ackBundle(path, false)
+	}
+	if err := ackFile(path); err != nil {
 		return fmt.Errorf("cannot assert: %v", err)
 	}
 	return nil
@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func readTar(c *check.C, r io.Reader) map[string]string {
+	content := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.IsNil)
+		data, err := ioutil.ReadAll(tr)
+		c.Assert(err, check.IsNil)
+		content[hdr.Name] = string(data)
+	}
+	return content
+}
+
+func (s *SnapSuite) mockAckBundleServer(c *check.C, checkBody func(r *http.Request)) *int {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Assert(r.Method, check.Equals, "POST")
+			c.Assert(r.URL.Path, check.Equals, "/v2/assertions")
+			c.Assert(r.Header.Get("Content-Type"), check.Equals, "application/x-tar")
+			checkBody(r)
+			fmt.Fprint(w, `{"type":"async","status-code":202,"change":"7"}`)
+		case 1:
+			c.Check(r.URL.Path, check.Equals, "/v2/changes/7")
+			fmt.Fprint(w, `{"type":"sync","result":{"ready":true,"status":"Done"}}`)
+		default:
+			c.Fatalf("unexpected request #%d to %q", n, r.URL.Path)
+		}
+		n++
+	})
+	return &n
+}
+
+func (s *SnapSuite) TestAckBundleDir(c *check.C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "foo.snap"), []byte("snap"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "foo.assert"), []byte("assertions"), 0644), check.IsNil)
+
+	n := s.mockAckBundleServer(c, func(r *http.Request) {
+		c.Check(readTar(c, r.Body), check.DeepEquals, map[string]string{
+			"foo.snap":   "snap",
+			"foo.assert": "assertions",
+		})
+	})
+
+	rest, err := snap.Parser().ParseArgs([]string{"ack", dir})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(*n, check.Equals, 2)
+	c.Check(s.Stdout(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestAckBundleTarball(c *check.C) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "foo.snap", Mode: 0644, Size: 4}), check.IsNil)
+	_, err := tw.Write([]byte("snap"))
+	c.Assert(err, check.IsNil)
+	c.Assert(tw.Close(), check.IsNil)
+	c.Assert(gzw.Close(), check.IsNil)
+	tarball := filepath.Join(c.MkDir(), "bundle.tar.gz")
+	c.Assert(ioutil.WriteFile(tarball, buf.Bytes(), 0644), check.IsNil)
+
+	n := s.mockAckBundleServer(c, func(r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		c.Assert(err, check.IsNil)
+		c.Check(body, check.DeepEquals, buf.Bytes())
+	})
+
+	_, err = snap.Parser().ParseArgs([]string{"ack", tarball})
+	c.Assert(err, check.IsNil)
+	c.Check(*n, check.Equals, 2)
+}
+
+func (s *SnapSuite) TestAckBundleError(c *check.C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "foo.snap"), []byte("snap"), 0644), check.IsNil)
+
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{"type":"error","status-code":400,"result":{"message":"cannot import bundle: boom"}}`)
+	})
+
+	_, err := snap.Parser().ParseArgs([]string{"ack", dir})
+	c.Check(err, check.ErrorMatches, "cannot import bundle: cannot import bundle: boom")
+}
+
+func (s *SnapSuite) TestAckAssertionFile(c *check.C) {
+	fn := filepath.Join(c.MkDir(), "some.assert")
+	c.Assert(ioutil.WriteFile(fn, []byte("assertion"), 0644), check.IsNil)
+
+	var types []string
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		types = append(types, r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		c.Assert(err, check.IsNil)
+		c.Check(string(body), check.Equals, "assertion")
+		fmt.Fprint(w, `{"type":"sync","result":{}}`)
+	})
+
+	_, err := snap.Parser().ParseArgs([]string{"ack", fn})
+	c.Assert(err, check.IsNil)
+	c.Assert(types, check.HasLen, 1)
+	c.Check(types[0], check.Not(check.Equals), "application/x-tar")
+}
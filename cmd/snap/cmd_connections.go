@@ -0,0 +1,202 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+)
+
+type cmdConnections struct {
+	All        bool `long:"all"`
+	Positional struct {
+		Snap installedSnapName `positional-arg-name:"<snap>"`
+	} `positional-args:"true"`
+}
+
+var shortConnectionsHelp = i18n.G("List interface connections")
+var longConnectionsHelp = i18n.G(`
+The connections command lists the connections between plugs and slots
+in the system.
+
+Unless <snap> is provided, the listing is for connections for all snaps
+in the system. In this mode, pass --all to also list unconnected plugs
+and slots, and the automatic connections that were manually disconnected.
+
+$ snap connections <snap>
+
+Lists the connections of the specified snap, as well as its unconnected
+plugs and slots.
+
+The Notes column says whether a connection was made manually, requested
+by the gadget, or was made automatically and then disconnected.
+`)
+
+func init() {
+	addCommand("connections", shortConnectionsHelp, longConnectionsHelp, func() flags.Commander {
+		return &cmdConnections{}
+	}, map[string]string{
+		"all": i18n.G("Show unconnected plugs and slots, and disconnected automatic connections"),
+	}, []argDesc{{
+		// TRANSLATORS: This needs to be wrapped in <>s.
+		name: i18n.G("<snap>"),
+		// TRANSLATORS: This should probably not start with a lowercase letter.
+		desc: i18n.G("Constrain listing to a specific snap"),
+	}})
+}
+
+type connectionRow struct {
+	iface string
+	plug  string
+	slot  string
+	notes string
+}
+
+type byConnectionRow []connectionRow
+
+func (r byConnectionRow) Len() int      { return len(r) }
+func (r byConnectionRow) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byConnectionRow) Less(i, j int) bool {
+	if r[i].iface != r[j].iface {
+		return r[i].iface < r[j].iface
+	}
+	if r[i].plug != r[j].plug {
+		return r[i].plug < r[j].plug
+	}
+	return r[i].slot < r[j].slot
+}
+
+func plugRefStr(snap, name string) string {
+	return fmt.Sprintf("%s:%s", snap, name)
+}
+
+func slotRefStr(snap, name string) string {
+	// The OS snap is special and enable abbreviated display syntax
+	// on the slot-side of the connection.
+	if snap == "core" || snap == "ubuntu-core" {
+		return ":" + name
+	}
+	return fmt.Sprintf("%s:%s", snap, name)
+}
+
+func connectionNotes(conn client.Connection) string {
+	switch {
+	case conn.Gadget:
+		return i18n.G("gadget")
+	case conn.Manual:
+		return i18n.G("manual")
+	}
+	return "-"
+}
+
+func (x *cmdConnections) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	snapName := string(x.Positional.Snap)
+	opts := &client.ConnectionOptions{
+		Snap: snapName,
+		// a single snap's unconnected plugs and slots are always of interest
+		All: x.All || snapName != "",
+	}
+	conns, err := Client().Connections(opts)
+	if err != nil {
+		return err
+	}
+
+	var rows []connectionRow
+	for _, conn := range conns.Established {
+		rows = append(rows, connectionRow{
+			iface: conn.Interface,
+			plug:  plugRefStr(conn.Plug.Snap, conn.Plug.Name),
+			slot:  slotRefStr(conn.Slot.Snap, conn.Slot.Name),
+			notes: connectionNotes(conn),
+		})
+	}
+	for _, conn := range conns.Undesired {
+		rows = append(rows, connectionRow{
+			iface: conn.Interface,
+			plug:  plugRefStr(conn.Plug.Snap, conn.Plug.Name),
+			slot:  slotRefStr(conn.Slot.Snap, conn.Slot.Name),
+			notes: i18n.G("disconnected"),
+		})
+	}
+	undesiredPlugs := make(map[client.PlugRef]bool, len(conns.Undesired))
+	undesiredSlots := make(map[client.SlotRef]bool, len(conns.Undesired))
+	for _, conn := range conns.Undesired {
+		undesiredPlugs[conn.Plug] = true
+		undesiredSlots[conn.Slot] = true
+	}
+	for _, plug := range conns.Plugs {
+		if len(plug.Connections) > 0 || undesiredPlugs[client.PlugRef{Snap: plug.Snap, Name: plug.Name}] {
+			continue
+		}
+		if snapName != "" && plug.Snap != snapName {
+			continue
+		}
+		rows = append(rows, connectionRow{
+			iface: plug.Interface,
+			plug:  plugRefStr(plug.Snap, plug.Name),
+			slot:  "-",
+			notes: "-",
+		})
+	}
+	for _, slot := range conns.Slots {
+		if len(slot.Connections) > 0 || undesiredSlots[client.SlotRef{Snap: slot.Snap, Name: slot.Name}] {
+			continue
+		}
+		if snapName != "" && slot.Snap != snapName {
+			continue
+		}
+		rows = append(rows, connectionRow{
+			iface: slot.Interface,
+			plug:  "-",
+			slot:  slotRefStr(slot.Snap, slot.Name),
+			notes: "-",
+		})
+	}
+
+	if len(rows) == 0 {
+		if snapName != "" {
+			fmt.Fprintf(Stderr, i18n.G("No connections for snap %q.\n"), snapName)
+		} else {
+			fmt.Fprintln(Stderr, i18n.G("No connections."))
+		}
+		return nil
+	}
+
+	sort.Sort(byConnectionRow(rows))
+
+	w := tabWriter()
+	defer w.Flush()
+
+	fmt.Fprintln(w, i18n.G("Interface\tPlug\tSlot\tNotes"))
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.iface, row.plug, row.slot, row.notes)
+	}
+
+	return nil
+}
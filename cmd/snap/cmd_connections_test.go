@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	. "github.com/snapcore/snapd/cmd/snap"
+)
+
+const connectionsJSON = `{"type":"sync","result":{
+"established":[
+ {"plug":{"snap":"consumer","plug":"plug"},"slot":{"snap":"producer","slot":"slot"},"interface":"test"},
+ {"plug":{"snap":"consumer","plug":"network"},"slot":{"snap":"core","slot":"network"},"interface":"network","manual":true},
+ {"plug":{"snap":"consumer","plug":"gpio"},"slot":{"snap":"pi","slot":"pin-13"},"interface":"gpio","gadget":true}
+],
+"undesired":[
+ {"plug":{"snap":"other","plug":"plug"},"slot":{"snap":"producer","slot":"slot"},"interface":"test"}
+],
+"plugs":[
+ {"snap":"consumer","plug":"plug","interface":"test","connections":[{"snap":"producer","slot":"slot"}]},
+ {"snap":"other","plug":"plug","interface":"test"},
+ {"snap":"other","plug":"home","interface":"home"}
+],
+"slots":[
+ {"snap":"producer","slot":"slot","interface":"test","connections":[{"snap":"consumer","plug":"plug"}]},
+ {"snap":"producer","slot":"spare","interface":"test"}
+]}}`
+
+func (s *SnapSuite) TestConnections(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "GET")
+		c.Check(r.URL.Path, check.Equals, "/v2/connections")
+		c.Check(r.URL.Query().Get("select"), check.Equals, "all")
+		c.Check(r.URL.Query().Get("snap"), check.Equals, "")
+		fmt.Fprint(w, connectionsJSON)
+	})
+
+	rest, err := Parser().ParseArgs([]string{"connections", "--all"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, ""+
+		"Interface  Plug              Slot            Notes\n"+
+		"gpio       consumer:gpio     pi:pin-13       gadget\n"+
+		"home       other:home        -               -\n"+
+		"network    consumer:network  :network        manual\n"+
+		"test       -                 producer:spare  -\n"+
+		"test       consumer:plug     producer:slot   -\n"+
+		"test       other:plug        producer:slot   disconnected\n")
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestConnectionsOfSnap(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Query().Get("select"), check.Equals, "all")
+		c.Check(r.URL.Query().Get("snap"), check.Equals, "other")
+		fmt.Fprint(w, `{"type":"sync","result":{
+"established":[],
+"undesired":[{"plug":{"snap":"other","plug":"plug"},"slot":{"snap":"producer","slot":"slot"},"interface":"test"}],
+"plugs":[{"snap":"other","plug":"plug","interface":"test"},{"snap":"other","plug":"home","interface":"home"}],
+"slots":[]}}`)
+	})
+
+	_, err := Parser().ParseArgs([]string{"connections", "other"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, ""+
+		"Interface  Plug        Slot           Notes\n"+
+		"home       other:home  -              -\n"+
+		"test       other:plug  producer:slot  disconnected\n")
+}
+
+func (s *SnapSuite) TestConnectionsNone(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Query().Get("select"), check.Equals, "")
+		fmt.Fprint(w, `{"type":"sync","result":{"established":[],"undesired":[],"plugs":[],"slots":[]}}`)
+	})
+
+	_, err := Parser().ParseArgs([]string{"connections"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "")
+	c.Check(s.Stderr(), check.Equals, "No connections.\n")
+}
@@ -0,0 +1,131 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/i18n"
+)
+
+type cmdChangeTimings struct {
+	Positional struct {
+		ID changeID `positional-arg-name:"<change-id>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addDebugCommand("timings",
+		i18n.G("Get the timings of the tasks of a change"),
+		i18n.G(`
+The timings command displays how long the task handlers of a change ran,
+including retries, and how long the ensure passes of each manager took
+while the change was in progress. The ensure timings are only kept in
+memory for the most recent passes, and are lost when snapd restarts.
+`),
+		func() flags.Commander {
+			return &cmdChangeTimings{}
+		})
+}
+
+// formatTiming formats a duration with millisecond precision, or "-"
+// if there was nothing to time.
+func formatTiming(d time.Duration, n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return (d - d%time.Millisecond).String()
+}
+
+type ensureTiming struct {
+	label string
+	runs  int
+	total time.Duration
+	max   time.Duration
+}
+
+type ensureTimingsByTotal []*ensureTiming
+
+func (s ensureTimingsByTotal) Len() int           { return len(s) }
+func (s ensureTimingsByTotal) Less(i, j int) bool { return s[i].total > s[j].total }
+func (s ensureTimingsByTotal) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (x *cmdChangeTimings) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	timings, err := Client().ChangeTimings(string(x.Positional.ID))
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	fmt.Fprintf(w, i18n.G("ID\tStatus\tRuns\tDoing\tUndoing\tSummary\n"))
+	for _, t := range timings.Tasks {
+		var doing, undoing time.Duration
+		var nDo, nUndo int
+		for _, span := range t.Timings {
+			switch span.Label {
+			case "do":
+				doing += span.Duration
+				nDo++
+			case "undo":
+				undoing += span.Duration
+				nUndo++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", t.ID, t.Status, nDo+nUndo, formatTiming(doing, nDo), formatTiming(undoing, nUndo), t.Summary)
+	}
+	w.Flush()
+
+	if len(timings.EnsureTimings) == 0 {
+		return nil
+	}
+
+	byLabel := make(map[string]*ensureTiming)
+	var ensures []*ensureTiming
+	for _, span := range timings.EnsureTimings {
+		et := byLabel[span.Label]
+		if et == nil {
+			et = &ensureTiming{label: span.Label}
+			byLabel[span.Label] = et
+			ensures = append(ensures, et)
+		}
+		et.runs++
+		et.total += span.Duration
+		if span.Duration > et.max {
+			et.max = span.Duration
+		}
+	}
+	sort.Sort(ensureTimingsByTotal(ensures))
+
+	fmt.Fprintln(Stdout)
+	fmt.Fprintf(w, i18n.G("Manager\tEnsures\tTotal\tMax\n"))
+	for _, et := range ensures {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", et.label, et.runs, formatTiming(et.total, et.runs), formatTiming(et.max, et.runs))
+	}
+	w.Flush()
+
+	return nil
+}
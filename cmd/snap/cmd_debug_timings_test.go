@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestDebugTimings(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/debug")
+			c.Check(r.URL.Query().Get("aspect"), check.Equals, "change-timings")
+			c.Check(r.URL.Query().Get("change-id"), check.Equals, "42")
+			fmt.Fprintln(w, `{"type": "sync", "result": {
+  "id": "42", "kind": "install", "summary": "Install \"foo\" snap", "status": "Error",
+  "tasks": [
+    {"id": "1", "kind": "download", "summary": "Download snap \"foo\"", "status": "Undone", "timings": [
+      {"label": "do", "start": "2016-04-21T01:02:03Z", "duration": 1000000000, "outcome": "retry"},
+      {"label": "do", "start": "2016-04-21T01:02:05Z", "duration": 500300000, "outcome": "done"},
+      {"label": "undo", "start": "2016-04-21T01:02:07Z", "duration": 20000000, "outcome": "done"},
+      {"label": "cleanup", "start": "2016-04-21T01:02:08Z", "duration": 1000000, "outcome": "done"}]},
+    {"id": "2", "kind": "link-snap", "summary": "Make snap \"foo\" available", "status": "Error", "timings": [
+      {"label": "do", "start": "2016-04-21T01:02:06Z", "duration": 3000000, "outcome": "error"}]},
+    {"id": "3", "kind": "start-snap-services", "summary": "Start snap \"foo\" services", "status": "Hold"}
+  ],
+  "ensure-timings": [
+    {"label": "ifacestate.InterfaceManager", "start": "2016-04-21T01:02:03Z", "duration": 1000000},
+    {"label": "snapstate.SnapManager", "start": "2016-04-21T01:02:03Z", "duration": 2000000},
+    {"label": "snapstate.SnapManager", "start": "2016-04-21T01:02:06Z", "duration": 5000000}
+  ]}}`)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"debug", "timings", "42"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, `ID   Status  Runs  Doing  Undoing  Summary
+1    Undone  3     1.5s   20ms     Download snap "foo"
+2    Error   1     3ms    -        Make snap "foo" available
+3    Hold    0     -      -        Start snap "foo" services
+
+Manager                      Ensures  Total  Max
+snapstate.SnapManager        2        7ms    5ms
+ifacestate.InterfaceManager  1        1ms    1ms
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestDebugTimingsNoEnsures(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type": "sync", "result": {
+  "id": "42", "kind": "install", "summary": "...", "status": "Do",
+  "tasks": [{"id": "1", "kind": "download", "summary": "Download", "status": "Do"}]}}`)
+	})
+	_, err := snap.Parser().ParseArgs([]string{"debug", "timings", "42"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, `ID   Status  Runs  Doing  Undoing  Summary
+1    Do      0     -      -        Download
+`)
+}
+
+func (s *SnapSuite) TestDebugTimingsError(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, `{"type": "error", "status-code": 404, "result": {"message": "cannot find change with id \"42\""}}`)
+	})
+	_, err := snap.Parser().ParseArgs([]string{"debug", "timings", "42"})
+	c.Check(err, check.ErrorMatches, `cannot find change with id "42"`)
+}
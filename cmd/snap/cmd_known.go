@@ -38,7 +38,8 @@ type cmdKnown struct {
 		HeaderFilters  []string `required:"0"`
 	} `positional-args:"true" required:"true"`
 
-	Remote bool `long:"remote"`
+	Remote  bool `long:"remote"`
+	Invalid bool `long:"invalid"`
 }
 
 var shortKnownHelp = i18n.G("Shows known assertions of the provided type")
@@ -46,12 +47,19 @@ var longKnownHelp = i18n.G(`
 The known command shows known assertions of the provided type.
 If header=value pairs are provided after the assertion type, the assertions
 shown must also have the specified headers matching the provided values.
+
+With --invalid only the known assertions that were found not to be valid
+anymore, for example because their signing key expired or was revoked,
+are shown.
 `)
 
 func init() {
 	addCommand("known", shortKnownHelp, longKnownHelp, func() flags.Commander {
 		return &cmdKnown{}
-	}, nil, []argDesc{
+	}, map[string]string{
+		"remote":  i18n.G("Query the store for the assertion instead of the system"),
+		"invalid": i18n.G("Show only the known assertions that are not valid anymore"),
+	}, []argDesc{
 		{
 			name: i18n.G("<assertion type>"),
 			desc: i18n.G("Assertion type name"),
@@ -98,6 +106,9 @@ func (x *cmdKnown) Execute(args []string) error {
 	if len(args) > 0 {
 		return ErrExtraArgs
 	}
+	if x.Remote && x.Invalid {
+		return fmt.Errorf(i18n.G("cannot use --remote and --invalid together"))
+	}
 
 	// TODO: share this kind of parsing once it's clearer how often is used in snap
 	headers := map[string]string{}
@@ -111,9 +122,12 @@ func (x *cmdKnown) Execute(args []string) error {
 
 	var assertions []asserts.Assertion
 	var err error
-	if x.Remote {
+	switch {
+	case x.Remote:
 		assertions, err = downloadAssertion(x.KnownOptions.AssertTypeName, headers)
-	} else {
+	case x.Invalid:
+		assertions, err = Client().KnownInvalid(x.KnownOptions.AssertTypeName, headers)
+	default:
 		assertions, err = Client().Known(x.KnownOptions.AssertTypeName, headers)
 	}
 	if err != nil {
@@ -88,3 +88,36 @@ func (s *SnapSuite) TestKnownRemoteMissingPrimaryKey(c *check.C) {
 	_, err := snap.Parser().ParseArgs([]string{"known", "--remote", "model", "series=16", "brand-id=canonical"})
 	c.Assert(err, check.ErrorMatches, `missing primary header "model" to query remote assertion`)
 }
+
+func (s *SnapSuite) TestKnownInvalid(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/assertions/model")
+			c.Check(r.URL.Query(), check.DeepEquals, url.Values{
+				"brand-id": []string{"canonical"},
+				"invalid":  []string{"true"},
+			})
+			w.Header().Set("X-Ubuntu-Assertions-Count", "1")
+			fmt.Fprint(w, mockModelAssertion)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+
+	rest, err := snap.Parser().ParseArgs([]string{"known", "--invalid", "model", "brand-id=canonical"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, mockModelAssertion)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *SnapSuite) TestKnownRemoteAndInvalid(c *check.C) {
+	_, err := snap.Parser().ParseArgs([]string{"known", "--remote", "--invalid", "model"})
+	c.Assert(err, check.ErrorMatches, `cannot use --remote and --invalid together`)
+}
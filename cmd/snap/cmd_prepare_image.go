@@ -36,6 +36,7 @@ type cmdPrepareImage struct {
 
 	ExtraSnaps []string `long:"extra-snaps"`
 	Channel    string   `long:"channel"`
+	ImageFile  string   `long:"image-file"`
 }
 
 func init() {
@@ -47,6 +48,7 @@ func init() {
 		}, map[string]string{
 			"extra-snaps": "Extra snaps to be installed",
 			"channel":     "The channel to use",
+			"image-file":  "Also write a disk image to this file",
 		}, []argDesc{
 			{
 				name: i18n.G("<model-assertion>"),
@@ -67,6 +69,7 @@ func (x *cmdPrepareImage) Execute(args []string) error {
 		GadgetUnpackDir: filepath.Join(x.Positional.Rootdir, "gadget"),
 		Channel:         x.Channel,
 		Snaps:           x.ExtraSnaps,
+		ImageFile:       x.ImageFile,
 	}
 
 	return image.Prepare(opts)
@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/i18n"
+)
+
+var (
+	shortRemodelHelp = i18n.G("Remodel this device")
+	longRemodelHelp  = i18n.G(`
+The remodel command changes the model assertion of the device, either to
+a new revision or a full new model.
+
+In the process it installs any new required snaps, switches the kernel
+and gadget snaps if the new model asks for different ones, and registers
+the device again if needed to obtain a serial matching the new model.
+
+The new model assertion must come from the same brand as the current one.
+`)
+)
+
+type cmdRemodel struct {
+	waitMixin
+	RemodelOptions struct {
+		NewModelFile flags.Filename
+	} `positional-args:"true" required:"true"`
+}
+
+func init() {
+	addCommand("remodel", shortRemodelHelp, longRemodelHelp, func() flags.Commander {
+		return &cmdRemodel{}
+	}, waitDescs, []argDesc{{
+		// TRANSLATORS: This needs to be wrapped in <>s.
+		name: i18n.G("<new model file>"),
+		// TRANSLATORS: This should probably not start with a lowercase letter.
+		desc: i18n.G("New model file"),
+	}})
+}
+
+func (x *cmdRemodel) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	newModelFile := string(x.RemodelOptions.NewModelFile)
+	modelData, err := ioutil.ReadFile(newModelFile)
+	if err != nil {
+		return err
+	}
+	a, err := asserts.Decode(modelData)
+	if err != nil {
+		return fmt.Errorf(i18n.G("cannot decode new model assertion: %v"), err)
+	}
+	newModel, ok := a.(*asserts.Model)
+	if !ok {
+		return fmt.Errorf(i18n.G("%q is not a model assertion"), newModelFile)
+	}
+
+	cli := Client()
+	changeID, err := cli.Remodel(modelData)
+	if err != nil {
+		return fmt.Errorf(i18n.G("cannot remodel: %v"), err)
+	}
+	if _, err := x.wait(cli, changeID); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+
+	// TRANSLATORS: the first %s is the brand id, the second the model name
+	fmt.Fprintf(Stdout, i18n.G("New model %s/%s set\n"), newModel.BrandID(), newModel.Model())
+	return nil
+}
@@ -0,0 +1,106 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) writeModel(c *check.C) (fn string, encoded []byte) {
+	brandPrivKey, _ := assertstest.GenerateKey(752)
+	brandSigning := assertstest.NewSigningDB("my-brand", brandPrivKey)
+	model, err := brandSigning.Sign(asserts.ModelType, map[string]interface{}{
+		"series":       "16",
+		"brand-id":     "my-brand",
+		"model":        "my-model",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+		"architecture": "amd64",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	encoded = asserts.Encode(model)
+	fn = filepath.Join(c.MkDir(), "new-model")
+	c.Assert(ioutil.WriteFile(fn, encoded, 0644), check.IsNil)
+	return fn, encoded
+}
+
+func (s *SnapSuite) TestRemodel(c *check.C) {
+	fn, encoded := s.writeModel(c)
+
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/model")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"new-model": string(encoded),
+			})
+			fmt.Fprint(w, `{"type":"async","status-code":202,"change":"42"}`)
+		case 1:
+			c.Check(r.URL.Path, check.Equals, "/v2/changes/42")
+			fmt.Fprint(w, `{"type":"sync","result":{"ready":true,"status":"Done"}}`)
+		default:
+			c.Fatalf("unexpected request #%d to %q", n, r.URL.Path)
+		}
+		n++
+	})
+
+	rest, err := snap.Parser().ParseArgs([]string{"remodel", fn})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(n, check.Equals, 2)
+	c.Check(s.Stdout(), check.Equals, "New model my-brand/my-model set\n")
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestRemodelError(c *check.C) {
+	fn, _ := s.writeModel(c)
+
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{"type":"error","status-code":400,"result":{"message":"cannot remodel device: boom"}}`)
+	})
+
+	_, err := snap.Parser().ParseArgs([]string{"remodel", fn})
+	c.Check(err, check.ErrorMatches, "cannot remodel: cannot remodel device: boom")
+}
+
+func (s *SnapSuite) TestRemodelNotAModel(c *check.C) {
+	fn := filepath.Join(c.MkDir(), "garbage")
+	c.Assert(ioutil.WriteFile(fn, []byte("garbage"), 0644), check.IsNil)
+
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request to %q", r.URL.Path)
+	})
+
+	_, err := snap.Parser().ParseArgs([]string{"remodel", fn})
+	c.Check(err, check.ErrorMatches, "cannot decode new model assertion: .*")
+}
@@ -0,0 +1,177 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestServices(c *C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v2/apps")
+		c.Check(r.URL.Query().Get("names"), Equals, "foo")
+		c.Check(r.URL.Query().Get("select"), Equals, "service")
+		fmt.Fprintln(w, `{"type": "sync", "result": [
+			{"snap": "foo", "name": "bar", "daemon": "simple", "enabled": true, "active": true},
+			{"snap": "foo", "name": "baz", "daemon": "forking"}
+		]}`)
+		n++
+	})
+	rest, err := Parser().ParseArgs([]string{"services", "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, `Service  Startup   Current
+foo.bar  enabled   active
+foo.baz  disabled  inactive
+`)
+	c.Check(s.Stderr(), Equals, "")
+	c.Check(n, Equals, 1)
+}
+
+func (s *SnapSuite) TestServicesWithTimer(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/v2/apps")
+		fmt.Fprintln(w, `{"type": "sync", "result": [
+			{"snap": "foo", "name": "bar", "daemon": "simple", "enabled": true, "active": true},
+			{"snap": "foo", "name": "tick", "daemon": "oneshot", "enabled": true, "active": true, "timer": "9:00-10:00", "next-trigger": "2018-01-11T09:12:00Z"},
+			{"snap": "foo", "name": "tock", "daemon": "oneshot", "timer": "9:00-10:00"}
+		]}`)
+	})
+	_, err := Parser().ParseArgs([]string{"services", "foo"})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, `Service   Startup   Current   Next
+foo.bar   enabled   active    -
+foo.tick  enabled   active    2018-01-11T09:12:00Z
+foo.tock  disabled  inactive  -
+`)
+	c.Check(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestServicesNone(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.RawQuery, Equals, "select=service")
+		fmt.Fprintln(w, `{"type": "sync", "result": []}`)
+	})
+	_, err := Parser().ParseArgs([]string{"services"})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, "")
+	c.Check(s.Stderr(), Equals, "There are no services provided by installed snaps.\n")
+}
+
+func (s *SnapSuite) testServiceOp(c *C, args []string, body map[string]interface{}) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/apps":
+			c.Check(r.Method, Equals, "POST")
+			c.Check(DecodedRequestBody(c, r), DeepEquals, body)
+			fmt.Fprintln(w, `{"type":"async", "status-code": 202, "change": "zzz"}`)
+		case "/v2/changes/zzz":
+			c.Check(r.Method, Equals, "GET")
+			fmt.Fprintln(w, `{"type":"sync", "result":{"ready": true, "status": "Done"}}`)
+		default:
+			c.Fatalf("unexpected path %q", r.URL.Path)
+		}
+	})
+	rest, err := Parser().ParseArgs(args)
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, "")
+}
+
+func (s *SnapSuite) TestServiceStart(c *C) {
+	s.testServiceOp(c, []string{"start", "--enable", "foo", "bar.baz"}, map[string]interface{}{
+		"action": "start",
+		"names":  []interface{}{"foo", "bar.baz"},
+		"enable": true,
+	})
+}
+
+func (s *SnapSuite) TestServiceStop(c *C) {
+	s.testServiceOp(c, []string{"stop", "--disable", "foo.bar"}, map[string]interface{}{
+		"action":  "stop",
+		"names":   []interface{}{"foo.bar"},
+		"disable": true,
+	})
+}
+
+func (s *SnapSuite) TestServiceRestart(c *C) {
+	s.testServiceOp(c, []string{"restart", "foo"}, map[string]interface{}{
+		"action": "restart",
+		"names":  []interface{}{"foo"},
+	})
+}
+
+func (s *SnapSuite) TestServiceOpNeedsNames(c *C) {
+	for _, cmd := range []string{"start", "stop", "restart"} {
+		_, err := Parser().ParseArgs([]string{cmd})
+		c.Check(err, ErrorMatches, "the required argument .* was not provided", Commentf(cmd))
+	}
+}
+
+func (s *SnapSuite) TestLogs(c *C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v2/logs")
+		c.Check(r.URL.Query(), DeepEquals, url.Values{
+			"names":  []string{"foo.bar"},
+			"n":      []string{"-1"},
+			"follow": []string{"true"},
+		})
+		w.Header().Set("Content-Type", "application/json-seq")
+		fmt.Fprint(w, "\x1e"+`{"timestamp":"2009-11-10T23:00:00Z","message":"hello","sid":"xyzzy","pid":"42"}`+"\n")
+		n++
+	})
+	rest, err := Parser().ParseArgs([]string{"logs", "-n", "all", "-f", "foo.bar"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, "2009-11-10T23:00:00Z xyzzy[42]: hello\n")
+	c.Check(n, Equals, 1)
+}
+
+func (s *SnapSuite) TestLogsDefaultN(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Query(), DeepEquals, url.Values{
+			"names": []string{"foo"},
+			"n":     []string{"10"},
+		})
+	})
+	_, err := Parser().ParseArgs([]string{"logs", "foo"})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, "")
+}
+
+func (s *SnapSuite) TestLogsBadN(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request")
+	})
+	for _, n := range []string{"-n=-1", "-nfoo"} {
+		_, err := Parser().ParseArgs([]string{"logs", n, "foo"})
+		c.Check(err, ErrorMatches, "invalid argument for flag ‘-n’: expected a non-negative integer argument, or “all”.", Commentf(n))
+	}
+}
@@ -567,6 +567,7 @@ type cmdRefresh struct {
 
 	Revision         string `long:"revision"`
 	List             bool   `long:"list"`
+	Time             bool   `long:"time"`
 	IgnoreValidation bool   `long:"ignore-validation"`
 	Positional       struct {
 		Snaps []installedSnapName `positional-arg-name:"<snap>"`
@@ -592,12 +593,23 @@ func (x *cmdRefresh) refreshMany(snaps []string, opts *client.SnapOptions) error
 	if err := chg.Get("snap-names", &refreshed); err != nil && err != client.ErrNoData {
 		return err
 	}
+	var incompatible []string
+	if err := chg.Get("incompatible-snap-names", &incompatible); err != nil && err != client.ErrNoData {
+		return err
+	}
 
 	if len(refreshed) > 0 {
-		return showDone(refreshed, "refresh")
+		if err := showDone(refreshed, "refresh"); err != nil {
+			return err
+		}
+	}
+	for _, name := range incompatible {
+		fmt.Fprintf(Stderr, i18n.G("snap %q has no compatible update available\n"), name)
 	}
 
-	fmt.Fprintln(Stderr, i18n.G("All snaps up to date."))
+	if len(refreshed) == 0 && len(incompatible) == 0 {
+		fmt.Fprintln(Stderr, i18n.G("All snaps up to date."))
+	}
 
 	return nil
 }
@@ -650,6 +662,26 @@ func (x *cmdRefresh) listRefresh() error {
 	return nil
 }
 
+func (x *cmdRefresh) showRefreshTimes() error {
+	sysinfo, err := Client().SysInfo()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(Stdout, "schedule: %s\n", sysinfo.Refresh.Schedule)
+	if sysinfo.Refresh.Last != "" {
+		fmt.Fprintf(Stdout, "last: %s\n", sysinfo.Refresh.Last)
+	} else {
+		fmt.Fprintf(Stdout, "last: n/a\n")
+	}
+	if sysinfo.Refresh.Next != "" {
+		fmt.Fprintf(Stdout, "next: %s\n", sysinfo.Refresh.Next)
+	} else {
+		fmt.Fprintf(Stdout, "next: n/a\n")
+	}
+	return nil
+}
+
 func (x *cmdRefresh) Execute([]string) error {
 	if err := x.setChannelFromCommandline(); err != nil {
 		return err
@@ -658,6 +690,14 @@ func (x *cmdRefresh) Execute([]string) error {
 		return err
 	}
 
+	if x.Time {
+		if x.asksForMode() || x.asksForChannel() || x.List || len(x.Positional.Snaps) > 0 {
+			return errors.New(i18n.G("--time does not take other arguments or flags"))
+		}
+
+		return x.showRefreshTimes()
+	}
+
 	if x.List {
 		if x.asksForMode() || x.asksForChannel() {
 			return errors.New(i18n.G("--list does not take mode nor channel flags"))
@@ -905,6 +945,7 @@ func init() {
 		waitDescs.also(channelDescs).also(modeDescs).also(map[string]string{
 			"revision":          i18n.G("Refresh to the given revision"),
 			"list":              i18n.G("Show available snaps for refresh"),
+			"time":              i18n.G("Show auto refresh information"),
 			"ignore-validation": i18n.G("Ignore validation by other snaps blocking the refresh"),
 		}), nil)
 	addCommand("try", shortTryHelp, longTryHelp, func() flags.Commander { return &cmdTry{} }, waitDescs.also(modeDescs), nil)
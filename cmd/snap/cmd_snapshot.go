@@ -0,0 +1,359 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/strutil"
+)
+
+var (
+	shortSavedHelp = i18n.G("List the snapshots of snap data")
+	longSavedHelp  = i18n.G(`
+The saved command displays a list of snapshots that have been created
+previously with the 'save' command.
+`)
+	shortSaveHelp = i18n.G("Save a snapshot of the current data")
+	longSaveHelp  = i18n.G(`
+The save command creates a snapshot of the current user, system and
+configuration data for the given snaps.
+
+By default, this command saves the data of all snaps for all users.
+Alternatively, you can specify the data of which snaps to save, or
+for which users, or a combination of these.
+
+If a snap is included in a save operation, excluding its system and
+configuration data from the snapshot is not currently possible. This
+restriction may be lifted in the future.
+`)
+	shortForgetHelp = i18n.G("Delete a snapshot")
+	longForgetHelp  = i18n.G(`
+The forget command deletes a snapshot. This operation can not be
+undone.
+
+A snapshot contains archives for the user, system and configuration
+data of each snap included in the snapshot.
+
+By default, this command forgets all the data in a snapshot.
+Alternatively, you can specify the data of which snaps to forget.
+`)
+	shortCheckHelp = i18n.G("Check a snapshot")
+	longCheckHelp  = i18n.G(`
+The check-snapshot command verifies the user, system and configuration
+data of the snaps included in the specified snapshot.
+
+The check operation runs the same data integrity verification that is
+performed when a snapshot is restored.
+
+By default, this command checks all the data in a snapshot.
+Alternatively, you can specify the data of which snaps to check, or
+for which users, or a combination of these.
+`)
+	shortRestoreHelp = i18n.G("Restore a snapshot")
+	longRestoreHelp  = i18n.G(`
+The restore command replaces the current user, system and
+configuration data of included snaps, with the corresponding data from
+the specified snapshot.
+
+By default, this command restores all the data in a snapshot.
+Alternatively, you can specify the data of which snaps to restore, or
+for which users, or a combination of these.
+
+If a snap is included in a restore operation, excluding its system and
+configuration data from the restore is not currently possible. This
+restriction may be lifted in the future.
+`)
+)
+
+type savedCmd struct {
+	ID         snapshotID `long:"id"`
+	Positional struct {
+		Snaps []installedSnapName `positional-arg-name:"<snap>"`
+	} `positional-args:"yes"`
+}
+
+type saveCmd struct {
+	waitMixin
+	Users      string `long:"users"`
+	Positional struct {
+		Snaps []installedSnapName `positional-arg-name:"<snap>"`
+	} `positional-args:"yes"`
+}
+
+type forgetCmd struct {
+	waitMixin
+	Positional struct {
+		ID    snapshotID          `positional-arg-name:"<id>"`
+		Snaps []installedSnapName `positional-arg-name:"<snap>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type checkSnapshotCmd struct {
+	waitMixin
+	Users      string `long:"users"`
+	Positional struct {
+		ID    snapshotID          `positional-arg-name:"<id>"`
+		Snaps []installedSnapName `positional-arg-name:"<snap>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type restoreCmd struct {
+	waitMixin
+	Users      string `long:"users"`
+	Positional struct {
+		ID    snapshotID          `positional-arg-name:"<id>"`
+		Snaps []installedSnapName `positional-arg-name:"<snap>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func init() {
+	usersDescs := map[string]string{
+		"users": i18n.G("Snapshot data of only specific users (comma-separated) (default: all users)"),
+	}
+	argDescs := []argDesc{{
+		// TRANSLATORS: This needs to be wrapped in <>s.
+		name: i18n.G("<id>"),
+		// TRANSLATORS: This should probably not start with a lowercase letter.
+		desc: i18n.G("Set id of snapshot to act on"),
+	}, {
+		// TRANSLATORS: This needs to be wrapped in <>s.
+		name: i18n.G("<snap>"),
+		// TRANSLATORS: This should probably not start with a lowercase letter.
+		desc: i18n.G("The snap for which data will be acted on"),
+	}}
+
+	addCommand("saved", shortSavedHelp, longSavedHelp, func() flags.Commander { return &savedCmd{} }, map[string]string{
+		"id": i18n.G("Show only a specific snapshot."),
+	}, nil)
+	addCommand("save", shortSaveHelp, longSaveHelp, func() flags.Commander { return &saveCmd{} }, waitDescs.also(usersDescs), nil)
+	addCommand("forget", shortForgetHelp, longForgetHelp, func() flags.Commander { return &forgetCmd{} }, waitDescs, argDescs)
+	addCommand("check-snapshot", shortCheckHelp, longCheckHelp, func() flags.Commander { return &checkSnapshotCmd{} }, waitDescs.also(usersDescs), argDescs)
+	addCommand("restore", shortRestoreHelp, longRestoreHelp, func() flags.Commander { return &restoreCmd{} }, waitDescs.also(usersDescs), argDescs)
+}
+
+// snapshotID is the ID of a snapshot set.
+type snapshotID uint64
+
+func (id *snapshotID) UnmarshalFlag(value string) error {
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 {
+		return fmt.Errorf(i18n.G("invalid snapshot set id %q: expected a positive number"), value)
+	}
+	*id = snapshotID(n)
+	return nil
+}
+
+func snapNames(snaps []installedSnapName) []string {
+	names := make([]string, len(snaps))
+	for i, name := range snaps {
+		names[i] = string(name)
+	}
+	return names
+}
+
+func userNames(users string) []string {
+	var names []string
+	for _, name := range strings.Split(users, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+var timeNow = time.Now
+
+// fmtAge returns a short, human-friendly rendering of how long ago t was.
+func fmtAge(t time.Time) string {
+	age := timeNow().Sub(t)
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%.0fs", age.Seconds())
+	case age < time.Hour:
+		return fmt.Sprintf("%.0fm", age.Minutes())
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%.1fh", age.Hours())
+	default:
+		return fmt.Sprintf("%.1fd", age.Hours()/24)
+	}
+}
+
+func printSnapshotSets(sets []client.SnapshotSet) {
+	w := tabWriter()
+	defer w.Flush()
+
+	fmt.Fprintln(w, i18n.G("Set\tSnap\tAge\tVersion\tRev\tSize\tNotes"))
+	for _, set := range sets {
+		for _, shot := range set.Snapshots {
+			notes := "-"
+			if shot.Auto {
+				notes = "auto"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				set.ID, shot.Snap, fmtAge(shot.Time), shot.Version,
+				shot.Revision, strutil.SizeToStr(shot.Size), notes)
+		}
+	}
+}
+
+func (x *savedCmd) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	sets, err := Client().SnapshotSets(uint64(x.ID), snapNames(x.Positional.Snaps))
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		fmt.Fprintln(Stdout, i18n.G("No snapshots found."))
+		return nil
+	}
+
+	printSnapshotSets(sets)
+	return nil
+}
+
+func (x *saveCmd) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	cli := Client()
+	snaps := snapNames(x.Positional.Snaps)
+	setID, changeID, err := cli.SnapshotMany(snaps, userNames(x.Users))
+	if err != nil {
+		return err
+	}
+	if _, err := x.wait(cli, changeID); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+
+	sets, err := cli.SnapshotSets(setID, snaps)
+	if err != nil {
+		return err
+	}
+	printSnapshotSets(sets)
+	return nil
+}
+
+func (x *forgetCmd) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	cli := Client()
+	snaps := snapNames(x.Positional.Snaps)
+	changeID, err := cli.ForgetSnapshots(uint64(x.Positional.ID), snaps)
+	if err != nil {
+		return err
+	}
+	if _, err := x.wait(cli, changeID); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+
+	if len(snaps) > 0 {
+		// TRANSLATORS: the %s is a comma-separated list of quoted snap names
+		fmt.Fprintf(Stdout, i18n.G("Snapshot #%d of snaps %s forgotten.\n"), x.Positional.ID, strutil.Quoted(snaps))
+	} else {
+		fmt.Fprintf(Stdout, i18n.G("Snapshot #%d forgotten.\n"), x.Positional.ID)
+	}
+	return nil
+}
+
+func (x *checkSnapshotCmd) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	cli := Client()
+	snaps := snapNames(x.Positional.Snaps)
+	users := userNames(x.Users)
+	changeID, err := cli.CheckSnapshots(uint64(x.Positional.ID), snaps, users)
+	if err != nil {
+		return err
+	}
+	if _, err := x.wait(cli, changeID); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+
+	// TRANSLATORS: the %s is a description of what was checked, e.g. `of snaps "foo", "bar"`
+	fmt.Fprintf(Stdout, i18n.G("Snapshot #%d verified successfully%s.\n"), x.Positional.ID, snapshotScope(snaps, users))
+	return nil
+}
+
+func (x *restoreCmd) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	cli := Client()
+	snaps := snapNames(x.Positional.Snaps)
+	users := userNames(x.Users)
+	changeID, err := cli.RestoreSnapshots(uint64(x.Positional.ID), snaps, users)
+	if err != nil {
+		return err
+	}
+	if _, err := x.wait(cli, changeID); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+
+	// TRANSLATORS: the %s is a description of what was restored, e.g. `of snaps "foo", "bar"`
+	fmt.Fprintf(Stdout, i18n.G("Restored snapshot #%d%s.\n"), x.Positional.ID, snapshotScope(snaps, users))
+	return nil
+}
+
+// snapshotScope describes the snaps and users an operation on a
+// snapshot was limited to, if any.
+func snapshotScope(snaps, users []string) string {
+	var scope []string
+	if len(snaps) > 0 {
+		// TRANSLATORS: the %s is a comma-separated list of quoted snap names
+		scope = append(scope, fmt.Sprintf(i18n.G("of snaps %s"), strutil.Quoted(snaps)))
+	}
+	if len(users) > 0 {
+		// TRANSLATORS: the %s is a comma-separated list of quoted user names
+		scope = append(scope, fmt.Sprintf(i18n.G("for users %s"), strutil.Quoted(users)))
+	}
+	if len(scope) == 0 {
+		return ""
+	}
+	return " " + strings.Join(scope, " ")
+}
@@ -0,0 +1,171 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+const snapshotSetsJSON = `{"type":"sync","status-code":200,"status":"OK","result":[{"id":1,"snapshots":[
+{"set":1,"time":"2017-11-21T10:00:00Z","snap":"foo","revision":"7","version":"1.0","size":2048,"sha3-384":{}},
+{"set":1,"time":"2017-11-21T10:00:00Z","snap":"bar","revision":"x1","version":"2.0","size":1024,"auto":true,"sha3-384":{}}]}]}`
+
+func (s *SnapSuite) mockSnapshotTime() {
+	t, err := time.Parse(time.RFC3339, "2017-11-21T12:30:00Z")
+	if err != nil {
+		panic(err)
+	}
+	restore := snap.MockTimeNow(func() time.Time { return t })
+	s.AddCleanup(restore)
+}
+
+func (s *SnapSuite) TestSnapSaved(c *check.C) {
+	s.mockSnapshotTime()
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "GET")
+		c.Check(r.URL.Path, check.Equals, "/v2/snapshots")
+		c.Check(r.URL.Query().Get("set"), check.Equals, "1")
+		c.Check(r.URL.Query().Get("snaps"), check.Equals, "foo,bar")
+		fmt.Fprint(w, snapshotSetsJSON)
+	})
+
+	rest, err := snap.Parser().ParseArgs([]string{"saved", "--id=1", "foo", "bar"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, `Set  Snap  Age   Version  Rev  Size  Notes
+1    foo   2.5h  1.0      7    2kB   -
+1    bar   2.5h  2.0      x1   1kB   auto
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestSnapSavedNone(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type":"sync","status-code":200,"status":"OK","result":[]}`)
+	})
+
+	_, err := snap.Parser().ParseArgs([]string{"saved"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "No snapshots found.\n")
+}
+
+func (s *SnapSuite) TestSnapSavedBadID(c *check.C) {
+	_, err := snap.Parser().ParseArgs([]string{"saved", "--id=x"})
+	c.Check(err, check.ErrorMatches, `.*invalid snapshot set id "x": expected a positive number`)
+}
+
+func (s *SnapSuite) TestSnapSave(c *check.C) {
+	s.mockSnapshotTime()
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/snapshots")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action": "save",
+				"snaps":  []interface{}{"foo", "bar"},
+				"users":  []interface{}{"alice", "bob"},
+			})
+			fmt.Fprint(w, `{"type":"async","status-code":202,"change":"9","result":{"set-id":1}}`)
+		case 1:
+			c.Check(r.URL.Path, check.Equals, "/v2/changes/9")
+			fmt.Fprint(w, `{"type":"sync","result":{"ready":true,"status":"Done"}}`)
+		case 2:
+			c.Check(r.URL.Path, check.Equals, "/v2/snapshots")
+			c.Check(r.URL.Query().Get("set"), check.Equals, "1")
+			fmt.Fprint(w, snapshotSetsJSON)
+		default:
+			c.Fatalf("unexpected request #%d to %q", n, r.URL.Path)
+		}
+		n++
+	})
+
+	_, err := snap.Parser().ParseArgs([]string{"save", "--users=alice,bob", "foo", "bar"})
+	c.Assert(err, check.IsNil)
+	c.Check(n, check.Equals, 3)
+	c.Check(s.Stdout(), check.Matches, `(?s)Set  Snap .*\n1    foo .*\n1    bar .*auto\n`)
+}
+
+func (s *SnapSuite) testSnapshotAction(c *check.C, args []string, expected map[string]interface{}, stdout string) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/snapshots")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, expected)
+			fmt.Fprint(w, `{"type":"async","status-code":202,"change":"9"}`)
+		case 1:
+			c.Check(r.URL.Path, check.Equals, "/v2/changes/9")
+			fmt.Fprint(w, `{"type":"sync","result":{"ready":true,"status":"Done"}}`)
+		default:
+			c.Fatalf("unexpected request #%d to %q", n, r.URL.Path)
+		}
+		n++
+	})
+
+	_, err := snap.Parser().ParseArgs(args)
+	c.Assert(err, check.IsNil)
+	c.Check(n, check.Equals, 2)
+	c.Check(s.Stdout(), check.Equals, stdout)
+}
+
+func (s *SnapSuite) TestSnapForget(c *check.C) {
+	s.testSnapshotAction(c, []string{"forget", "3"}, map[string]interface{}{
+		"action": "forget",
+		"set":    3.0,
+	}, "Snapshot #3 forgotten.\n")
+}
+
+func (s *SnapSuite) TestSnapForgetSnaps(c *check.C) {
+	s.testSnapshotAction(c, []string{"forget", "3", "foo"}, map[string]interface{}{
+		"action": "forget",
+		"set":    3.0,
+		"snaps":  []interface{}{"foo"},
+	}, "Snapshot #3 of snaps \"foo\" forgotten.\n")
+}
+
+func (s *SnapSuite) TestSnapCheckSnapshot(c *check.C) {
+	s.testSnapshotAction(c, []string{"check-snapshot", "--users=alice", "3"}, map[string]interface{}{
+		"action": "check",
+		"set":    3.0,
+		"users":  []interface{}{"alice"},
+	}, "Snapshot #3 verified successfully for users \"alice\".\n")
+}
+
+func (s *SnapSuite) TestSnapRestore(c *check.C) {
+	s.testSnapshotAction(c, []string{"restore", "3", "foo", "bar"}, map[string]interface{}{
+		"action": "restore",
+		"set":    3.0,
+		"snaps":  []interface{}{"foo", "bar"},
+	}, "Restored snapshot #3 of snaps \"foo\", \"bar\".\n")
+}
+
+func (s *SnapSuite) TestSnapRestoreNeedsID(c *check.C) {
+	_, err := snap.Parser().ParseArgs([]string{"restore"})
+	c.Check(err, check.ErrorMatches, "the required argument `<id>` was not provided")
+}
@@ -0,0 +1,64 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	snapunset "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestInvalidUnsetParameters(c *check.C) {
+	invalidParameters := []string{"unset"}
+	_, err := snapunset.Parser().ParseArgs(invalidParameters)
+	c.Check(err, check.ErrorMatches, "the required arguments `<snap>` and `<conf key> \\(at least 1 argument\\)` were not provided")
+
+	invalidParameters = []string{"unset", "snap-name"}
+	_, err = snapunset.Parser().ParseArgs(invalidParameters)
+	c.Check(err, check.ErrorMatches, "the required argument `<conf key> \\(at least 1 argument\\)` was not provided")
+}
+
+func (s *SnapSuite) TestSnapUnset(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/snaps/snapname/conf":
+			c.Check(r.Method, check.Equals, "PUT")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"key":        nil,
+				"nested.key": nil,
+			})
+			fmt.Fprintln(w, `{"type":"async", "status-code": 202, "change": "zzz"}`)
+		case "/v2/changes/zzz":
+			c.Check(r.Method, check.Equals, "GET")
+			fmt.Fprintln(w, `{"type":"sync", "result":{"ready": true, "status": "Done"}}`)
+		default:
+			c.Fatalf("unexpected path %q", r.URL.Path)
+		}
+		n++
+	})
+
+	_, err := snapunset.Parser().ParseArgs([]string{"unset", "snapname", "key", "nested.key"})
+	c.Assert(err, check.IsNil)
+	c.Check(n, check.Equals, 2)
+}
@@ -68,6 +68,31 @@ func (s anySnapName) Complete(match string) []flags.Completion {
 	return res
 }
 
+type serviceName string
+
+func (s serviceName) Complete(match string) []flags.Completion {
+	cli := Client()
+	apps, err := cli.Apps(nil, client.AppOptions{Service: true})
+	if err != nil {
+		return nil
+	}
+
+	snaps := map[string]bool{}
+	ret := make([]flags.Completion, 0, len(apps))
+	for _, app := range apps {
+		if !snaps[app.Snap] && strings.HasPrefix(app.Snap, match) {
+			snaps[app.Snap] = true
+			ret = append(ret, flags.Completion{Item: app.Snap})
+		}
+		name := app.Snap + "." + app.Name
+		if strings.HasPrefix(name, match) {
+			ret = append(ret, flags.Completion{Item: name})
+		}
+	}
+
+	return ret
+}
+
 type changeID string
 
 func (s changeID) Complete(match string) []flags.Completion {
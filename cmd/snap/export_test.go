@@ -85,6 +85,14 @@ func MockMountInfoPath(newMountInfoPath string) (restore func()) {
 	}
 }
 
+func MockTimeNow(f func() time.Time) (restore func()) {
+	timeNowOrig := timeNow
+	timeNow = f
+	return func() {
+		timeNow = timeNowOrig
+	}
+}
+
 var AutoImportCandidates = autoImportCandidates
 
 func AliasInfoLess(snapName1, alias1, app1, snapName2, alias2, app2 string) bool {
@@ -283,6 +283,9 @@ func main() {
 		}
 	}()
 
+	// let the daemon ask for authorization if we have a terminal
+	ClientConfig.Interactive = terminal.IsTerminal(0)
+
 	// no magic /o\
 	if err := run(); err != nil {
 		fmt.Fprintf(Stderr, i18n.G("error: %v\n"), err)
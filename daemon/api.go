@@ -32,6 +32,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -47,6 +48,7 @@ import (
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate"
@@ -54,6 +56,8 @@ import (
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/hookstate/ctlcmd"
 	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapshotstate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/progress"
@@ -61,6 +65,7 @@ import (
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/systemd"
 )
 
 var api = []*Command{
@@ -74,10 +79,12 @@ var api = []*Command{
 	snapCmd,
 	snapConfCmd,
 	interfacesCmd,
+	connectionsCmd,
 	assertsCmd,
 	assertsFindManyCmd,
 	stateChangeCmd,
 	stateChangesCmd,
+	eventsCmd,
 	createUserCmd,
 	buyCmd,
 	readyToBuyCmd,
@@ -85,7 +92,11 @@ var api = []*Command{
 	usersCmd,
 	sectionsCmd,
 	aliasesCmd,
+	appsCmd,
+	logsCmd,
 	debugCmd,
+	snapshotCmd,
+	modelCmd,
 }
 
 var (
@@ -125,17 +136,19 @@ var (
 	}
 
 	snapsCmd = &Command{
-		Path:   "/v2/snaps",
-		UserOK: true,
-		GET:    getSnapsInfo,
-		POST:   postSnaps,
+		Path:     "/v2/snaps",
+		UserOK:   true,
+		GET:      getSnapsInfo,
+		POST:     postSnaps,
+		PolkitOK: polkitSnapAction,
 	}
 
 	snapCmd = &Command{
-		Path:   "/v2/snaps/{name}",
-		UserOK: true,
-		GET:    getSnapInfo,
-		POST:   postSnap,
+		Path:     "/v2/snaps/{name}",
+		UserOK:   true,
+		GET:      getSnapInfo,
+		POST:     postSnap,
+		PolkitOK: polkitSnapAction,
 	}
 
 	snapConfCmd = &Command{
@@ -145,10 +158,17 @@ var (
 	}
 
 	interfacesCmd = &Command{
-		Path:   "/v2/interfaces",
+		Path:     "/v2/interfaces",
+		UserOK:   true,
+		GET:      getInterfaces,
+		POST:     changeInterfaces,
+		PolkitOK: polkitConnectAction,
+	}
+
+	connectionsCmd = &Command{
+		Path:   "/v2/connections",
 		UserOK: true,
-		GET:    getInterfaces,
-		POST:   changeInterfaces,
+		GET:    getConnections,
 	}
 
 	// TODO: allow to post assertions for UserOK? they are verified anyway
@@ -176,9 +196,17 @@ var (
 		GET:    getChanges,
 	}
 
+	eventsCmd = &Command{
+		Path:   "/v2/events",
+		UserOK: true,
+		GET:    getEvents,
+	}
+
 	debugCmd = &Command{
-		Path: "/v2/debug",
-		POST: postDebug,
+		Path:   "/v2/debug",
+		UserOK: true,
+		GET:    getDebug,
+		POST:   postDebug,
 	}
 
 	createUserCmd = &Command{
@@ -223,6 +251,33 @@ var (
 		GET:    getAliases,
 		POST:   changeAliases,
 	}
+
+	appsCmd = &Command{
+		Path:     "/v2/apps",
+		UserOK:   true,
+		GET:      getAppsInfo,
+		POST:     postApps,
+		PolkitOK: polkitManageServicesAction,
+	}
+
+	logsCmd = &Command{
+		Path: "/v2/logs",
+		GET:  getLogs,
+	}
+
+	snapshotCmd = &Command{
+		Path:   "/v2/snapshots",
+		UserOK: true,
+		GET:    listSnapshots,
+		POST:   changeSnapshots,
+	}
+
+	modelCmd = &Command{
+		Path:   "/v2/model",
+		UserOK: true,
+		GET:    getModel,
+		POST:   postModel,
+	}
 )
 
 func tbd(c *Command, r *http.Request, user *auth.UserState) Response {
@@ -231,12 +286,29 @@ func tbd(c *Command, r *http.Request, user *auth.UserState) Response {
 
 func sysInfo(c *Command, r *http.Request, user *auth.UserState) Response {
 	st := c.d.overlord.State()
+	snapMgr := c.d.overlord.SnapManager()
 	st.Lock()
 	users, err := auth.Users(st)
+	nextRefresh := snapMgr.NextRefresh()
+	lastRefresh, _ := snapMgr.LastRefresh()
+	refreshScheduleStr, scheduleErr := snapMgr.RefreshSchedule()
 	st.Unlock()
 	if err != nil && err != state.ErrNoState {
 		return InternalError("cannot get user auth data: %s", err)
 	}
+	if scheduleErr != nil {
+		return InternalError("cannot get refresh schedule: %s", scheduleErr)
+	}
+
+	refreshInfo := map[string]interface{}{
+		"schedule": refreshScheduleStr,
+	}
+	if !lastRefresh.IsZero() {
+		refreshInfo["last"] = lastRefresh.Format(time.RFC3339)
+	}
+	if !nextRefresh.IsZero() {
+		refreshInfo["next"] = nextRefresh.Format(time.RFC3339)
+	}
 
 	m := map[string]interface{}{
 		"series":     release.Series,
@@ -246,6 +318,7 @@ func sysInfo(c *Command, r *http.Request, user *auth.UserState) Response {
 		"managed":    len(users) > 0,
 
 		"kernel-version": release.KernelVersion(),
+		"refresh":        refreshInfo,
 	}
 
 	// TODO: set the store-id here from the model information
@@ -851,15 +924,23 @@ func modeFlags(devMode, jailMode, classic bool) (snapstate.Flags, error) {
 	return flags, nil
 }
 
-func snapUpdateMany(inst *snapInstruction, st *state.State) (msg string, updated []string, tasksets []*state.TaskSet, err error) {
+// snapUpdateMany also returns the snaps skipped because none of their
+// updates can read their data.
+func snapUpdateMany(inst *snapInstruction, st *state.State) (msg string, updated []string, incompatible []string, tasksets []*state.TaskSet, err error) {
 	// we need refreshed snap-declarations to enforce refresh-control as best as we can, this also ensures that snap-declarations and their prerequisite assertions are updated regularly
 	if err := assertstateRefreshSnapDeclarations(st, inst.userID); err != nil {
-		return "", nil, nil, err
+		return "", nil, nil, nil, err
 	}
 
 	updated, tasksets, err = snapstateUpdateMany(st, inst.Snaps, inst.userID)
+	if e, ok := err.(*store.IncompatibleUpdatesError); ok {
+		for _, snapErr := range e.Snaps {
+			incompatible = append(incompatible, snapErr.Snap)
+		}
+		err = nil
+	}
 	if err != nil {
-		return "", nil, nil, err
+		return "", nil, nil, nil, err
 	}
 
 	switch len(updated) {
@@ -878,7 +959,7 @@ func snapUpdateMany(inst *snapInstruction, st *state.State) (msg string, updated
 		msg = fmt.Sprintf(i18n.G("Refresh snaps %s"), quoted)
 	}
 
-	return msg, updated, tasksets, nil
+	return msg, updated, incompatible, tasksets, nil
 }
 
 func snapInstallMany(inst *snapInstruction, st *state.State) (msg string, installed []string, tasksets []*state.TaskSet, err error) {
@@ -1056,7 +1137,7 @@ func (inst *snapInstruction) errToResponse(err error) Response {
 		result.Kind = errorKindSnapAlreadyInstalled
 	case *snap.NotInstalledError:
 		result.Kind = errorKindSnapNotInstalled
-	case *snap.NoUpdateAvailableError:
+	case *snap.NoUpdateAvailableError, *snap.NoCompatibleUpdateError:
 		result.Kind = errorKindSnapNoUpdateAvailable
 	case *snapstate.ErrSnapNeedsMode:
 		result.Kind = errorKindSnapNeedsMode
@@ -1216,11 +1297,12 @@ func snapsOp(c *Command, r *http.Request, user *auth.UserState) Response {
 
 	var msg string
 	var affected []string
+	var incompatible []string
 	var tsets []*state.TaskSet
 	var err error
 	switch inst.Action {
 	case "refresh":
-		msg, affected, tsets, err = snapUpdateMany(&inst, st)
+		msg, affected, incompatible, tsets, err = snapUpdateMany(&inst, st)
 	case "install":
 		msg, affected, tsets, err = snapInstallMany(&inst, st)
 	case "remove":
@@ -1240,7 +1322,11 @@ func snapsOp(c *Command, r *http.Request, user *auth.UserState) Response {
 		chg = newChange(st, inst.Action+"-snap", msg, tsets, affected)
 		ensureStateSoon(st)
 	}
-	chg.Set("api-data", map[string]interface{}{"snap-names": affected})
+	apiData := map[string]interface{}{"snap-names": affected}
+	if len(incompatible) != 0 {
+		apiData["incompatible-snap-names"] = incompatible
+	}
+	chg.Set("api-data", apiData)
 
 	return AsyncResponse(nil, &Meta{Change: chg.ID()})
 }
@@ -1506,6 +1592,122 @@ func getInterfaces(c *Command, r *http.Request, user *auth.UserState) Response {
 	return SyncResponse(repo.Interfaces(), nil)
 }
 
+// connectionJSON aids in marshaling a connection into JSON.
+type connectionJSON struct {
+	Slot      interfaces.SlotRef `json:"slot"`
+	Plug      interfaces.PlugRef `json:"plug"`
+	Interface string             `json:"interface"`
+	Manual    bool               `json:"manual,omitempty"`
+	Gadget    bool               `json:"gadget,omitempty"`
+}
+
+// connectionsJSON aids in marshaling the connections of snaps into JSON.
+type connectionsJSON struct {
+	Established []connectionJSON   `json:"established"`
+	Undesired   []connectionJSON   `json:"undesired"`
+	Plugs       []*interfaces.Plug `json:"plugs"`
+	Slots       []*interfaces.Slot `json:"slots"`
+}
+
+func getConnections(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+	snapName := query.Get("snap")
+	qselect := query.Get("select")
+	if qselect != "" && qselect != "all" {
+		return BadRequest("unsupported select qualifier %q", qselect)
+	}
+	onlyConnected := qselect == ""
+
+	st := c.d.overlord.State()
+	st.Lock()
+	connStates, err := ifacestate.ConnectionStates(st)
+	st.Unlock()
+	if err != nil {
+		return InternalError("cannot obtain connections: %v", err)
+	}
+
+	involves := func(snaps ...string) bool {
+		if snapName == "" {
+			return true
+		}
+		for _, name := range snaps {
+			if name == snapName {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := connectionsJSON{
+		Established: []connectionJSON{},
+		Undesired:   []connectionJSON{},
+		Plugs:       []*interfaces.Plug{},
+		Slots:       []*interfaces.Slot{},
+	}
+
+	ifaces := c.d.overlord.InterfaceManager().Repository().Interfaces()
+	for _, plug := range ifaces.Plugs {
+		plugSnap := plug.Snap.Name()
+		relevant := involves(plugSnap)
+		for _, slotRef := range plug.Connections {
+			if !involves(plugSnap, slotRef.Snap) {
+				continue
+			}
+			relevant = true
+			connRef := interfaces.ConnRef{PlugRef: plug.Ref(), SlotRef: slotRef}
+			cstate, ok := connStates[connRef.ID()]
+			result.Established = append(result.Established, connectionJSON{
+				Slot:      slotRef,
+				Plug:      plug.Ref(),
+				Interface: plug.Interface,
+				Manual:    !ok || !cstate.Auto,
+				Gadget:    cstate.ByGadget,
+			})
+		}
+		if !relevant || (onlyConnected && len(plug.Connections) == 0) {
+			continue
+		}
+		result.Plugs = append(result.Plugs, plug)
+	}
+	for _, slot := range ifaces.Slots {
+		relevant := involves(slot.Snap.Name())
+		for _, plugRef := range slot.Connections {
+			relevant = relevant || involves(plugRef.Snap)
+		}
+		if !relevant || (onlyConnected && len(slot.Connections) == 0) {
+			continue
+		}
+		result.Slots = append(result.Slots, slot)
+	}
+
+	if !onlyConnected {
+		ids := make([]string, 0, len(connStates))
+		for id, cstate := range connStates {
+			if cstate.Undesired {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			connRef, err := interfaces.ParseConnRef(id)
+			if err != nil {
+				return InternalError("%v", err)
+			}
+			if !involves(connRef.PlugRef.Snap, connRef.SlotRef.Snap) {
+				continue
+			}
+			result.Undesired = append(result.Undesired, connectionJSON{
+				Slot:      connRef.SlotRef,
+				Plug:      connRef.PlugRef,
+				Interface: connStates[id].Interface,
+				Gadget:    connStates[id].ByGadget,
+			})
+		}
+	}
+
+	return SyncResponse(result, nil)
+}
+
 // plugJSON aids in marshaling Plug into JSON.
 type plugJSON struct {
 	Snap        string                 `json:"snap"`
@@ -1596,6 +1798,10 @@ func changeInterfaces(c *Command, r *http.Request, user *auth.UserState) Respons
 }
 
 func doAssert(c *Command, r *http.Request, user *auth.UserState) Response {
+	if r.Header.Get("Content-Type") == "application/x-tar" {
+		return importBundle(c, r, user)
+	}
+
 	batch := assertstate.NewBatch()
 	_, err := batch.AddStream(r.Body)
 	if err != nil {
@@ -1616,6 +1822,33 @@ func doAssert(c *Command, r *http.Request, user *auth.UserState) Response {
 	}
 }
 
+// importBundle installs the snaps of an offline bundle, a tarball of
+// snaps with the assertions needed to verify them.
+func importBundle(c *Command, r *http.Request, user *auth.UserState) Response {
+	bundle, err := assertstate.ReadBundle(r.Body, "")
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+	defer bundle.Cleanup()
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	snapNames, tsets, err := bundle.Install(st)
+	if err != nil {
+		return BadRequest("cannot import bundle: %v", err)
+	}
+
+	msg := fmt.Sprintf(i18n.G("Install snaps %s from bundle"), strutil.Quoted(snapNames))
+	chg := newChange(st, "install-bundle", msg, tsets, snapNames)
+	chg.Set("api-data", map[string]interface{}{"snap-names": snapNames})
+
+	ensureStateSoon(st)
+
+	return AsyncResponse(nil, &Meta{Change: chg.ID()})
+}
+
 func assertsFindMany(c *Command, r *http.Request, user *auth.UserState) Response {
 	assertTypeName := muxVars(r)["assertType"]
 	assertType := asserts.Type(assertTypeName)
@@ -1628,10 +1861,32 @@ func assertsFindMany(c *Command, r *http.Request, user *auth.UserState) Response
 		headers[k] = q.Get(k)
 	}
 
+	// invalid=true restricts the results to the assertions found
+	// not to be valid anymore by the last re-validation
+	onlyInvalid := false
+	if invalid, ok := headers["invalid"]; ok {
+		delete(headers, "invalid")
+		switch invalid {
+		case "true":
+			onlyInvalid = true
+		case "false":
+		default:
+			return BadRequest("invalid value for invalid: %q", invalid)
+		}
+	}
+
 	state := c.d.overlord.State()
 	state.Lock()
 	db := assertstate.DB(state)
+	var invalid []*assertstate.InvalidAssertion
+	var err error
+	if onlyInvalid {
+		invalid, err = assertstate.InvalidAssertions(state)
+	}
 	state.Unlock()
+	if err != nil {
+		return InternalError("cannot get invalid assertions: %v", err)
+	}
 
 	assertions, err := db.FindMany(assertType, headers)
 	if err == asserts.ErrNotFound {
@@ -1639,6 +1894,21 @@ func assertsFindMany(c *Command, r *http.Request, user *auth.UserState) Response
 	} else if err != nil {
 		return InternalError("searching assertions failed: %v", err)
 	}
+
+	if onlyInvalid {
+		isInvalid := make(map[string]bool, len(invalid))
+		for _, inv := range invalid {
+			isInvalid[inv.Unique()] = true
+		}
+		var found []asserts.Assertion
+		for _, a := range assertions {
+			if isInvalid[a.Ref().Unique()] {
+				found = append(found, a)
+			}
+		}
+		assertions = found
+	}
+
 	return AssertResponse(assertions, true)
 }
 
@@ -1796,6 +2066,28 @@ func getChanges(c *Command, r *http.Request, user *auth.UserState) Response {
 	return SyncResponse(chgInfos, nil)
 }
 
+func getEvents(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+	filter := state.EventFilter{
+		ChangeID:    query.Get("change-id"),
+		ChangeKinds: splitQS(query.Get("kind")),
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	if filter.ChangeID != "" && st.Change(filter.ChangeID) == nil {
+		return NotFound("cannot find change with id %q", filter.ChangeID)
+	}
+
+	// subscribing with the lock held means no event is missed
+	// between the check above and the subscription
+	return &eventSeqResponse{
+		sub:   st.Subscribe(filter),
+		dying: c.d.Dying(),
+	}
+}
+
 func abortChange(c *Command, r *http.Request, user *auth.UserState) Response {
 	chID := muxVars(r)["id"]
 	state := c.d.overlord.State()
@@ -2140,6 +2432,90 @@ type debugAction struct {
 	Action string `json:"action"`
 }
 
+type taskTimingsInfo struct {
+	ID      string             `json:"id"`
+	Kind    string             `json:"kind"`
+	Summary string             `json:"summary"`
+	Status  string             `json:"status"`
+	Timings []state.TimingSpan `json:"timings,omitempty"`
+
+	SpawnTime time.Time  `json:"spawn-time,omitempty"`
+	ReadyTime *time.Time `json:"ready-time,omitempty"`
+}
+
+type changeTimingsInfo struct {
+	ID            string             `json:"id"`
+	Kind          string             `json:"kind"`
+	Summary       string             `json:"summary"`
+	Status        string             `json:"status"`
+	Tasks         []*taskTimingsInfo `json:"tasks,omitempty"`
+	EnsureTimings []state.TimingSpan `json:"ensure-timings,omitempty"`
+
+	SpawnTime time.Time  `json:"spawn-time,omitempty"`
+	ReadyTime *time.Time `json:"ready-time,omitempty"`
+}
+
+func getChangeTimings(st *state.State, se *overlord.StateEngine, chID string) Response {
+	chg := st.Change(chID)
+	if chg == nil {
+		return NotFound("cannot find change with id %q", chID)
+	}
+
+	info := &changeTimingsInfo{
+		ID:      chg.ID(),
+		Kind:    chg.Kind(),
+		Summary: chg.Summary(),
+		Status:  chg.Status().String(),
+
+		SpawnTime: chg.SpawnTime(),
+	}
+	readyTime := chg.ReadyTime()
+	if !readyTime.IsZero() {
+		info.ReadyTime = &readyTime
+	}
+	// the ensure passes that ran while the change was in progress
+	info.EnsureTimings = se.EnsureTimings(info.SpawnTime, readyTime)
+
+	for _, t := range chg.Tasks() {
+		taskInfo := &taskTimingsInfo{
+			ID:      t.ID(),
+			Kind:    t.Kind(),
+			Summary: t.Summary(),
+			Status:  t.Status().String(),
+			Timings: t.Timings(),
+
+			SpawnTime: t.SpawnTime(),
+		}
+		readyTime := t.ReadyTime()
+		if !readyTime.IsZero() {
+			taskInfo.ReadyTime = &readyTime
+		}
+		info.Tasks = append(info.Tasks, taskInfo)
+	}
+
+	return SyncResponse(info, nil)
+}
+
+func getDebug(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+	aspect := query.Get("aspect")
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	switch aspect {
+	case "change-timings":
+		chID := query.Get("change-id")
+		if chID == "" {
+			return BadRequest("change-timings requires a change-id")
+		}
+		return getChangeTimings(st, c.d.overlord.StateEngine(), chID)
+	default:
+		return BadRequest("unknown debug aspect %q", aspect)
+	}
+}
+
 func postDebug(c *Command, r *http.Request, user *auth.UserState) Response {
 	var a debugAction
 	decoder := json.NewDecoder(r.Body)
@@ -2354,3 +2730,386 @@ func getAliases(c *Command, r *http.Request, user *auth.UserState) Response {
 
 	return SyncResponse(res, nil)
 }
+
+type appInfoOptions struct {
+	service bool
+}
+
+type byAppName []*snap.AppInfo
+
+func (a byAppName) Len() int      { return len(a) }
+func (a byAppName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAppName) Less(i, j int) bool {
+	iName := a[i].Snap.Name()
+	jName := a[j].Snap.Name()
+	if iName == jName {
+		return a[i].Name < a[j].Name
+	}
+	return iName < jName
+}
+
+// appInfosFor returns the apps matching the given names, each of
+// which is either a snap name (meaning all its apps) or a snap.app
+// name. No names means all the apps of all installed snaps.
+func appInfosFor(st *state.State, names []string, opts appInfoOptions) ([]*snap.AppInfo, Response) {
+	snapNames := make(map[string]bool)
+	requested := make(map[string]bool)
+	for _, name := range names {
+		requested[name] = true
+		snapNames[strings.SplitN(name, ".", 2)[0]] = true
+	}
+
+	snaps, err := allLocalSnapInfos(st, false, snapNames)
+	if err != nil {
+		return nil, InternalError("cannot list local snaps: %v", err)
+	}
+
+	installed := make(map[string]bool, len(snaps))
+	found := make(map[string]bool)
+	appInfos := make([]*snap.AppInfo, 0, len(requested))
+	for _, snp := range snaps {
+		snapName := snp.info.Name()
+		installed[snapName] = true
+		for _, app := range snp.info.Apps {
+			appName := snapName + "." + app.Name
+			if len(requested) != 0 && !requested[appName] && !requested[snapName] {
+				continue
+			}
+			if opts.service && !app.IsService() {
+				if requested[appName] {
+					return nil, BadRequest("%s is not a service", appName)
+				}
+				continue
+			}
+			found[appName] = true
+			found[snapName] = true
+			appInfos = append(appInfos, app)
+		}
+	}
+
+	for _, name := range names {
+		if found[name] {
+			continue
+		}
+		parts := strings.SplitN(name, ".", 2)
+		switch {
+		case !installed[parts[0]]:
+			return nil, NotFound("snap %q not found", parts[0])
+		case len(parts) == 1 && opts.service:
+			return nil, NotFound("snap %q has no services", name)
+		case len(parts) == 1:
+			return nil, NotFound("snap %q has no apps", name)
+		default:
+			return nil, NotFound("snap %q has no app %q", parts[0], parts[1])
+		}
+	}
+
+	sort.Sort(byAppName(appInfos))
+
+	return appInfos, nil
+}
+
+func splitQS(qs string) []string {
+	qsl := strings.Split(qs, ",")
+	split := make([]string, 0, len(qsl))
+	for _, elem := range qsl {
+		elem = strings.TrimSpace(elem)
+		if len(elem) > 0 {
+			split = append(split, elem)
+		}
+	}
+
+	return split
+}
+
+func getAppsInfo(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+
+	opts := appInfoOptions{}
+	switch sel := query.Get("select"); sel {
+	case "":
+		// nothing to do
+	case "service":
+		opts.service = true
+	default:
+		return BadRequest("invalid select parameter: %q", sel)
+	}
+
+	appInfos, rsp := appInfosFor(c.d.overlord.State(), splitQS(query.Get("names")), opts)
+	if rsp != nil {
+		return rsp
+	}
+
+	apps, err := appJSONsWithStatus(appInfos)
+	if err != nil {
+		return InternalError("cannot get status of services: %v", err)
+	}
+
+	return SyncResponse(apps, nil)
+}
+
+var systemdLogReader = func(serviceNames []string, n string, follow bool) (io.ReadCloser, error) {
+	return systemd.New(dirs.GlobalRootDir, nil).LogReader(serviceNames, n, follow)
+}
+
+func getLogs(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+	n := "10"
+	if s := query.Get("n"); s != "" {
+		m, err := strconv.ParseInt(s, 0, 32)
+		if err != nil {
+			return BadRequest(`invalid value for n: %q: %v`, s, err)
+		}
+		if m < 0 {
+			n = "all"
+		} else {
+			n = strconv.FormatInt(m, 10)
+		}
+	}
+	follow := false
+	if s := query.Get("follow"); s != "" {
+		f, err := strconv.ParseBool(s)
+		if err != nil {
+			return BadRequest(`invalid value for follow: %q: %v`, s, err)
+		}
+		follow = f
+	}
+
+	// only services have logs for now
+	appInfos, rsp := appInfosFor(c.d.overlord.State(), splitQS(query.Get("names")), appInfoOptions{service: true})
+	if rsp != nil {
+		return rsp
+	}
+	if len(appInfos) == 0 {
+		return NotFound("no matching services")
+	}
+
+	serviceNames := make([]string, len(appInfos))
+	for i, appInfo := range appInfos {
+		serviceNames[i] = filepath.Base(appInfo.ServiceFile())
+	}
+
+	reader, err := systemdLogReader(serviceNames, n, follow)
+	if err != nil {
+		return InternalError("cannot get logs: %v", err)
+	}
+
+	return &journalLineReaderSeqResponse{ReadCloser: reader, follow: follow}
+}
+
+func postApps(c *Command, r *http.Request, user *auth.UserState) Response {
+	var inst servicestate.Instruction
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&inst); err != nil {
+		return BadRequest("cannot decode request body into service operation: %v", err)
+	}
+	if len(inst.Names) == 0 {
+		// on POST, don't allow empty to mean all
+		return BadRequest("cannot perform operation on services without a list of services to operate on")
+	}
+	if err := inst.Validate(); err != nil {
+		return BadRequest("%v", err)
+	}
+
+	st := c.d.overlord.State()
+	appInfos, rsp := appInfosFor(st, inst.Names, appInfoOptions{service: true})
+	if rsp != nil {
+		return rsp
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	tss, err := servicestate.Control(st, appInfos, &inst, nil)
+	if err != nil {
+		return Conflict("%v", err)
+	}
+
+	snapNames := make([]string, 0, len(tss))
+	seen := make(map[string]bool)
+	for _, app := range appInfos {
+		snapName := app.Snap.Name()
+		if !seen[snapName] {
+			seen[snapName] = true
+			snapNames = append(snapNames, snapName)
+		}
+	}
+	sort.Strings(snapNames)
+
+	summary := fmt.Sprintf("Run service command %q for services of snaps %s", inst.Action, strutil.Quoted(snapNames))
+	chg := newChange(st, "service-control", summary, tss, snapNames)
+	ensureStateSoon(st)
+
+	return AsyncResponse(nil, &Meta{Change: chg.ID()})
+}
+
+var (
+	snapshotList    = snapshotstate.List
+	snapshotSave    = snapshotstate.Save
+	snapshotCheck   = snapshotstate.Check
+	snapshotRestore = snapshotstate.Restore
+	snapshotForget  = snapshotstate.Forget
+)
+
+func listSnapshots(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+	var setID uint64
+	if sid := query.Get("set"); sid != "" {
+		var err error
+		setID, err = strconv.ParseUint(sid, 10, 64)
+		if err != nil {
+			return BadRequest("'set', if given, must be a positive base 10 number; got %q", sid)
+		}
+	}
+
+	sets, err := snapshotList(setID, splitQS(query.Get("snaps")))
+	if err != nil {
+		return InternalError("%v", err)
+	}
+	if sets == nil {
+		sets = []client.SnapshotSet{}
+	}
+
+	return SyncResponse(sets, nil)
+}
+
+// snapshotAction is the request body of a POST to /v2/snapshots.
+type snapshotAction struct {
+	SetID  uint64   `json:"set"`
+	Action string   `json:"action"`
+	Snaps  []string `json:"snaps,omitempty"`
+	Users  []string `json:"users,omitempty"`
+}
+
+func (action snapshotAction) String() string {
+	// verb of snapshot #N [for snaps %q] [for users %q]
+	var snaps string
+	var users string
+	if len(action.Snaps) > 0 {
+		snaps = " for snaps " + strutil.Quoted(action.Snaps)
+	}
+	if len(action.Users) > 0 {
+		users = " for users " + strutil.Quoted(action.Users)
+	}
+	return fmt.Sprintf("%s of snapshot set #%d%s%s", strings.Title(action.Action), action.SetID, snaps, users)
+}
+
+func changeSnapshots(c *Command, r *http.Request, user *auth.UserState) Response {
+	var action snapshotAction
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&action); err != nil {
+		return BadRequest("cannot decode request body into snapshot operation: %v", err)
+	}
+	if decoder.More() {
+		return BadRequest("extra content found after snapshot operation")
+	}
+
+	if action.Action != "save" && action.SetID == 0 {
+		return BadRequest(`snapshot operation %q requires a snapshot set id`, action.Action)
+	}
+	if action.Action == "forget" && len(action.Users) > 0 {
+		return BadRequest(`snapshot operation "forget" cannot be limited to users`)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var err error
+	var setID uint64
+	var snapNames []string
+	var ts *state.TaskSet
+	var summary string
+	var result map[string]interface{}
+
+	switch action.Action {
+	case "save":
+		setID, snapNames, ts, err = snapshotSave(st, action.Snaps, action.Users)
+		if err == nil {
+			action.SetID = setID
+			if len(action.Snaps) == 0 {
+				summary = fmt.Sprintf("Save data of all snaps in snapshot set #%d", setID)
+			} else {
+				summary = fmt.Sprintf("Save data of snaps %s in snapshot set #%d", strutil.Quoted(snapNames), setID)
+			}
+			result = map[string]interface{}{"set-id": setID, "snaps": snapNames}
+		}
+	case "check":
+		snapNames, ts, err = snapshotCheck(st, action.SetID, action.Snaps, action.Users)
+		summary = action.String()
+	case "restore":
+		snapNames, ts, err = snapshotRestore(st, action.SetID, action.Snaps, action.Users)
+		summary = action.String()
+	case "forget":
+		snapNames, ts, err = snapshotForget(st, action.SetID, action.Snaps)
+		summary = action.String()
+	default:
+		return BadRequest("unknown snapshot operation %q", action.Action)
+	}
+
+	switch err {
+	case nil:
+		// woo
+	case snapshotstate.ErrNoSnapshot:
+		return NotFound("%v", err)
+	default:
+		return BadRequest("%v", err)
+	}
+
+	chg := newChange(st, action.Action+"-snapshot", summary, []*state.TaskSet{ts}, snapNames)
+	chg.Set("api-data", map[string]interface{}{"snap-names": snapNames})
+	ensureStateSoon(st)
+
+	return AsyncResponse(result, &Meta{Change: chg.ID()})
+}
+
+func getModel(c *Command, r *http.Request, user *auth.UserState) Response {
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	model, err := devicestate.Model(st)
+	if err == state.ErrNoState {
+		return NotFound("no model assertion yet")
+	}
+	if err != nil {
+		return InternalError("accessing model failed: %v", err)
+	}
+
+	return AssertResponse([]asserts.Assertion{model}, false)
+}
+
+var devicestateRemodel = devicestate.Remodel
+
+// postModelData is the request body of a POST to /v2/model.
+type postModelData struct {
+	NewModel string `json:"new-model"`
+}
+
+func postModel(c *Command, r *http.Request, user *auth.UserState) Response {
+	var data postModelData
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return BadRequest("cannot decode request body into remodel operation: %v", err)
+	}
+	a, err := asserts.Decode([]byte(data.NewModel))
+	if err != nil {
+		return BadRequest("cannot decode new model assertion: %v", err)
+	}
+	newModel, ok := a.(*asserts.Model)
+	if !ok {
+		return BadRequest("new model is not a model assertion: %v", a.Type().Name)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	chg, err := devicestateRemodel(st, newModel)
+	if err != nil {
+		return BadRequest("cannot remodel device: %v", err)
+	}
+	ensureStateSoon(st)
+
+	return AsyncResponse(nil, &Meta{Change: chg.ID()})
+}
@@ -20,6 +20,7 @@
 package daemon
 
 import (
+	"archive/tar"
 	"bytes"
 	"crypto"
 	"encoding/json"
@@ -30,6 +31,7 @@ import (
 	"go/token"
 	"io"
 	"io/ioutil"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -38,6 +40,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -45,10 +48,12 @@ import (
 	"golang.org/x/net/context"
 	"gopkg.in/check.v1"
 	"gopkg.in/macaroon.v1"
+	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/asserts/assertstest"
 	"github.com/snapcore/snapd/asserts/sysdb"
+	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/ifacetest"
@@ -56,7 +61,9 @@ import (
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/snapshotstate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/progress"
@@ -64,6 +71,7 @@ import (
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/systemd"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -110,7 +118,7 @@ func (s *apiBaseSuite) SuggestedCurrency() string {
 	return s.suggestedCurrency
 }
 
-func (s *apiBaseSuite) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState) error {
+func (s *apiBaseSuite) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState, *store.DownloadOptions) error {
 	panic("Download not expected to be called")
 }
 
@@ -503,6 +511,15 @@ func (s *apiSuite) TestListIncludesAll(c *check.C) {
 		"storeUserInfo",
 		"postCreateUserUcrednetGetUID",
 		"ensureStateSoon",
+		"systemdLogReader",
+		// snapshot vars:
+		"snapshotList",
+		"snapshotSave",
+		"snapshotCheck",
+		"snapshotRestore",
+		"snapshotForget",
+		// model vars:
+		"devicestateRemodel",
 	}
 	c.Check(found, check.Equals, len(api)+len(exceptions),
 		check.Commentf(`At a glance it looks like you've not added all the Commands defined in api to the api list. If that is not the case, please add the exception to the "exceptions" list in this test.`))
@@ -558,6 +575,9 @@ func (s *apiSuite) TestSysInfo(c *check.C) {
 		},
 		"on-classic": true,
 		"managed":    false,
+		"refresh": map[string]interface{}{
+			"schedule": "00:00-04:59/5:00-10:59/11:00-16:59/17:00-23:59",
+		},
 	}
 	var rsp resp
 	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
@@ -570,6 +590,29 @@ func (s *apiSuite) TestSysInfo(c *check.C) {
 	c.Check(rsp.Result, check.DeepEquals, expected)
 }
 
+func (s *apiSuite) TestSysInfoRefresh(c *check.C) {
+	rec := httptest.NewRecorder()
+	d := s.daemon(c)
+
+	st := d.overlord.State()
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "refresh.schedule", "mon@9:00-11:00")
+	tr.Set("core", "refresh.last", time.Date(2017, 2, 6, 10, 0, 0, 0, time.UTC))
+	tr.Commit()
+	st.Unlock()
+
+	sysInfoCmd.GET(sysInfoCmd, nil, nil).ServeHTTP(rec, nil)
+	c.Check(rec.Code, check.Equals, 200)
+
+	var rsp resp
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
+	c.Check(rsp.Result.(map[string]interface{})["refresh"], check.DeepEquals, map[string]interface{}{
+		"schedule": "mon@9:00-11:00",
+		"last":     "2017-02-06T10:00:00Z",
+	})
+}
+
 func (s *apiSuite) makeMyAppsServer(statusCode int, data string) *httptest.Server {
 	mockMyAppsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(statusCode)
@@ -2643,6 +2686,50 @@ func (s *apiSuite) TestPostSnapsOp(c *check.C) {
 	c.Check(apiData["snap-names"], check.DeepEquals, []interface{}{"fake1", "fake2"})
 }
 
+func (s *apiSuite) TestPostSnapsOpIncompatibleUpdates(c *check.C) {
+	assertstateRefreshSnapDeclarations = func(*state.State, int) error { return nil }
+	snapstateUpdateMany = func(s *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+		c.Check(names, check.DeepEquals, []string{"fake1", "fake2"})
+		t := s.NewTask("fake-refresh", "Refreshing fake1")
+		return []string{"fake1"}, []*state.TaskSet{state.NewTaskSet(t)}, &store.IncompatibleUpdatesError{
+			Snaps: []*snap.NoCompatibleUpdateError{{Snap: "fake2", Epoch: snap.E("1")}},
+		}
+	}
+
+	d := s.daemon(c)
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+
+	buf := bytes.NewBufferString(`{"action": "refresh", "snaps": ["fake1", "fake2"]}`)
+	req, err := http.NewRequest("POST", "/v2/snaps", buf)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, ok := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(ok, check.Equals, true)
+	c.Check(rsp.Type, check.Equals, ResponseTypeAsync)
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Check(chg.Summary(), check.Equals, `Refresh snap "fake1"`)
+	var apiData map[string]interface{}
+	c.Check(chg.Get("api-data", &apiData), check.IsNil)
+	c.Check(apiData["snap-names"], check.DeepEquals, []interface{}{"fake1"})
+	c.Check(apiData["incompatible-snap-names"], check.DeepEquals, []interface{}{"fake2"})
+}
+
+func (s *apiSuite) TestRefreshNoCompatibleUpdate(c *check.C) {
+	inst := &snapInstruction{Action: "refresh", Snaps: []string{"some-snap"}}
+	rsp := inst.errToResponse(&snap.NoCompatibleUpdateError{Snap: "some-snap", Epoch: snap.E("1")}).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+	c.Check(rsp.Result, check.DeepEquals, &errorResult{
+		Message: `snap "some-snap" has no compatible update available (none can read the data of epoch 1)`,
+		Kind:    errorKindSnapNoUpdateAvailable,
+	})
+}
+
 func (s *apiSuite) TestRefreshAll(c *check.C) {
 	refreshSnapDecls := false
 	assertstateRefreshSnapDeclarations = func(s *state.State, userID int) error {
@@ -2670,7 +2757,7 @@ func (s *apiSuite) TestRefreshAll(c *check.C) {
 		inst := &snapInstruction{Action: "refresh"}
 		st := d.overlord.State()
 		st.Lock()
-		summary, _, _, err := snapUpdateMany(inst, st)
+		summary, _, _, _, err := snapUpdateMany(inst, st)
 		st.Unlock()
 		c.Assert(err, check.IsNil)
 		c.Check(summary, check.Equals, tst.msg)
@@ -2694,7 +2781,7 @@ func (s *apiSuite) TestRefreshAllNoChanges(c *check.C) {
 	inst := &snapInstruction{Action: "refresh"}
 	st := d.overlord.State()
 	st.Lock()
-	summary, _, _, err := snapUpdateMany(inst, st)
+	summary, _, _, _, err := snapUpdateMany(inst, st)
 	st.Unlock()
 	c.Assert(err, check.IsNil)
 	c.Check(summary, check.Equals, `Refresh all snaps: no updates`)
@@ -2718,7 +2805,7 @@ func (s *apiSuite) TestRefreshMany(c *check.C) {
 	inst := &snapInstruction{Action: "refresh", Snaps: []string{"foo", "bar"}}
 	st := d.overlord.State()
 	st.Lock()
-	summary, updates, _, err := snapUpdateMany(inst, st)
+	summary, updates, _, _, err := snapUpdateMany(inst, st)
 	st.Unlock()
 	c.Assert(err, check.IsNil)
 	c.Check(summary, check.Equals, `Refresh snaps "foo", "bar"`)
@@ -2743,7 +2830,7 @@ func (s *apiSuite) TestRefreshMany1(c *check.C) {
 	inst := &snapInstruction{Action: "refresh", Snaps: []string{"foo"}}
 	st := d.overlord.State()
 	st.Lock()
-	summary, updates, _, err := snapUpdateMany(inst, st)
+	summary, updates, _, _, err := snapUpdateMany(inst, st)
 	st.Unlock()
 	c.Assert(err, check.IsNil)
 	c.Check(summary, check.Equals, `Refresh snap "foo"`)
@@ -3166,6 +3253,123 @@ func (s *apiSuite) TestInterfaces(c *check.C) {
 	})
 }
 
+func (s *apiSuite) getConnections(c *check.C, query string) map[string]interface{} {
+	req, err := http.NewRequest("GET", "/v2/connections"+query, nil)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	connectionsCmd.GET(connectionsCmd, req, nil).ServeHTTP(rec, req)
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+	c.Check(body["status-code"], check.Equals, float64(rec.Code))
+	return body
+}
+
+func (s *apiSuite) mockConnections(c *check.C, d *Daemon) {
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnap(c, "name: other\nversion: 1\nplugs:\n plug:\n  interface: test\n")
+
+	repo := d.overlord.InterfaceManager().Repository()
+	connRef := interfaces.ConnRef{
+		PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"},
+		SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"},
+	}
+	c.Assert(repo.Connect(connRef), check.IsNil)
+
+	st := d.overlord.State()
+	st.Lock()
+	st.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true},
+		"other:plug producer:slot":    map[string]interface{}{"interface": "test", "auto": true, "undesired": true},
+	})
+	st.Unlock()
+}
+
+func (s *apiSuite) TestConnections(c *check.C) {
+	d := s.daemon(c)
+	s.mockConnections(c, d)
+
+	body := s.getConnections(c, "")
+	c.Assert(body["result"], check.NotNil)
+	result := body["result"].(map[string]interface{})
+	c.Check(result["established"], check.DeepEquals, []interface{}{
+		map[string]interface{}{
+			"plug":      map[string]interface{}{"snap": "consumer", "plug": "plug"},
+			"slot":      map[string]interface{}{"snap": "producer", "slot": "slot"},
+			"interface": "test",
+		},
+	})
+	c.Check(result["undesired"], check.DeepEquals, []interface{}{})
+	c.Assert(result["plugs"], check.HasLen, 1)
+	c.Check(result["plugs"].([]interface{})[0].(map[string]interface{})["snap"], check.Equals, "consumer")
+	c.Assert(result["slots"], check.HasLen, 1)
+	c.Check(result["slots"].([]interface{})[0].(map[string]interface{})["snap"], check.Equals, "producer")
+}
+
+func (s *apiSuite) TestConnectionsAll(c *check.C) {
+	d := s.daemon(c)
+	s.mockConnections(c, d)
+
+	body := s.getConnections(c, "?select=all")
+	result := body["result"].(map[string]interface{})
+	c.Check(result["established"], check.HasLen, 1)
+	c.Check(result["undesired"], check.DeepEquals, []interface{}{
+		map[string]interface{}{
+			"plug":      map[string]interface{}{"snap": "other", "plug": "plug"},
+			"slot":      map[string]interface{}{"snap": "producer", "slot": "slot"},
+			"interface": "test",
+		},
+	})
+	// the unconnected plug of "other" is listed too
+	c.Check(result["plugs"], check.HasLen, 2)
+	c.Check(result["slots"], check.HasLen, 1)
+}
+
+func (s *apiSuite) TestConnectionsBySnap(c *check.C) {
+	d := s.daemon(c)
+	s.mockConnections(c, d)
+
+	body := s.getConnections(c, "?snap=other&select=all")
+	result := body["result"].(map[string]interface{})
+	c.Check(result["established"], check.DeepEquals, []interface{}{})
+	c.Check(result["undesired"], check.HasLen, 1)
+	c.Assert(result["plugs"], check.HasLen, 1)
+	c.Check(result["plugs"].([]interface{})[0].(map[string]interface{})["snap"], check.Equals, "other")
+	c.Check(result["slots"], check.HasLen, 0)
+
+	body = s.getConnections(c, "?snap=producer")
+	result = body["result"].(map[string]interface{})
+	c.Check(result["established"], check.HasLen, 1)
+	c.Check(result["plugs"], check.HasLen, 1)
+	c.Check(result["slots"], check.HasLen, 1)
+}
+
+func (s *apiSuite) TestConnectionsManual(c *check.C) {
+	d := s.daemon(c)
+	s.mockConnections(c, d)
+
+	st := d.overlord.State()
+	st.Lock()
+	st.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test"},
+	})
+	st.Unlock()
+
+	body := s.getConnections(c, "")
+	result := body["result"].(map[string]interface{})
+	c.Assert(result["established"], check.HasLen, 1)
+	c.Check(result["established"].([]interface{})[0].(map[string]interface{})["manual"], check.Equals, true)
+}
+
+func (s *apiSuite) TestConnectionsBadSelect(c *check.C) {
+	s.daemon(c)
+
+	body := s.getConnections(c, "?select=foo")
+	c.Check(body["status-code"], check.Equals, 400.0)
+	c.Check(body["result"].(map[string]interface{})["message"], check.Equals, `unsupported select qualifier "foo"`)
+}
+
 // Test for POST /v2/interfaces
 
 func (s *apiSuite) TestConnectPlugSuccess(c *check.C) {
@@ -3674,6 +3878,126 @@ func (s *apiSuite) TestAssertStreamOK(c *check.C) {
 	c.Check(err, check.IsNil)
 }
 
+func (s *apiSuite) makeBundle(c *check.C, entries map[string][]byte) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(entries[name])),
+		})
+		c.Assert(err, check.IsNil)
+		_, err = tw.Write(entries[name])
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(tw.Close(), check.IsNil)
+	return buf
+}
+
+func (s *apiSuite) TestAssertBundle(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+	}
+
+	dev1Acct := assertstest.NewAccount(s.storeSigning, "devel1", nil, "")
+	snapDecl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+		"series":       "16",
+		"snap-id":      "x-id",
+		"snap-name":    "x",
+		"publisher-id": dev1Acct.AccountID(),
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	snapRev, err := s.storeSigning.Sign(asserts.SnapRevisionType, map[string]interface{}{
+		"snap-sha3-384": "YK0GWATaZf09g_fvspYPqm_qtaiqf-KjaNj5uMEQCjQpuXWPjqQbeBINL5H_A0Lo",
+		"snap-size":     "5",
+		"snap-id":       "x-id",
+		"snap-revision": "41",
+		"developer-id":  dev1Acct.AccountID(),
+		"timestamp":     time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+
+	assertsBuf := &bytes.Buffer{}
+	enc := asserts.NewEncoder(assertsBuf)
+	for _, a := range []asserts.Assertion{s.storeSigning.StoreAccountKey(""), dev1Acct, snapDecl, snapRev} {
+		c.Assert(enc.Encode(a), check.IsNil)
+	}
+
+	body := s.makeBundle(c, map[string][]byte{
+		"x.assert": assertsBuf.Bytes(),
+		"x.snap":   []byte("xyzzy"),
+	})
+	req, err := http.NewRequest("POST", "/v2/assertions", body)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	rsp := doAssert(assertsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeAsync, check.Commentf("%v", rsp.Result))
+	c.Check(soon, check.Equals, 1)
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "install-bundle")
+	c.Check(chg.Summary(), check.Equals, `Install snaps "x" from bundle`)
+	var names []string
+	c.Assert(chg.Get("snap-names", &names), check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"x"})
+
+	snapsup, err := snapstate.TaskSnapSetup(chg.Tasks()[0])
+	c.Assert(err, check.IsNil)
+	c.Check(snapsup.SideInfo, check.DeepEquals, &snap.SideInfo{
+		RealName: "x",
+		SnapID:   "x-id",
+		Revision: snap.R(41),
+	})
+
+	// the assertions were added
+	_, err = assertstate.DB(st).Find(asserts.SnapRevisionType, map[string]string{
+		"snap-sha3-384": "YK0GWATaZf09g_fvspYPqm_qtaiqf-KjaNj5uMEQCjQpuXWPjqQbeBINL5H_A0Lo",
+	})
+	c.Check(err, check.IsNil)
+}
+
+func (s *apiSuite) TestAssertBundleUnverified(c *check.C) {
+	s.daemon(c)
+
+	body := s.makeBundle(c, map[string][]byte{
+		"x.snap": []byte("xyzzy"),
+	})
+	req, err := http.NewRequest("POST", "/v2/assertions", body)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	rsp := doAssert(assertsCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `cannot import bundle: cannot find signatures with metadata for snap "x.snap" in bundle`)
+}
+
+func (s *apiSuite) TestAssertBundleInvalid(c *check.C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("POST", "/v2/assertions", bytes.NewBufferString("garbage"))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	rsp := doAssert(assertsCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+	c.Check(rsp.Result.(*errorResult).Message, check.Matches, `cannot read bundle: .*`)
+}
+
 func (s *apiSuite) TestAssertInvalid(c *check.C) {
 	// Setup
 	buf := bytes.NewBufferString("blargh")
@@ -3794,6 +4118,52 @@ func (s *apiSuite) TestAssertsFindManyNoResults(c *check.C) {
 	c.Check(err, check.Equals, io.EOF)
 }
 
+func (s *apiSuite) TestAssertsFindManyInvalid(c *check.C) {
+	// Setup
+	d := s.daemon(c)
+	// add store key
+	st := d.overlord.State()
+	assertAdd(st, s.storeSigning.StoreAccountKey(""))
+	acct1 := assertstest.NewAccount(s.storeSigning, "developer1", nil, "")
+	assertAdd(st, acct1)
+	acct2 := assertstest.NewAccount(s.storeSigning, "developer2", nil, "")
+	assertAdd(st, acct2)
+
+	st.Lock()
+	st.Set("invalid-assertions", []*assertstate.InvalidAssertion{
+		{Type: "account", PrimaryKey: []string{acct2.AccountID()}, Reason: "signing key revoked"},
+	})
+	st.Unlock()
+
+	// Execute
+	req, err := http.NewRequest("GET", "/v2/assertions/account?invalid=true", nil)
+	c.Assert(err, check.IsNil)
+	s.vars = map[string]string{"assertType": "account"}
+	rec := httptest.NewRecorder()
+	assertsFindManyCmd.GET(assertsFindManyCmd, req, nil).ServeHTTP(rec, req)
+	// Verify
+	c.Check(rec.Code, check.Equals, http.StatusOK, check.Commentf("body %q", rec.Body))
+	c.Check(rec.HeaderMap.Get("X-Ubuntu-Assertions-Count"), check.Equals, "1")
+	dec := asserts.NewDecoder(rec.Body)
+	a1, err := dec.Decode()
+	c.Assert(err, check.IsNil)
+	c.Check(a1.(*asserts.Account).AccountID(), check.Equals, acct2.AccountID())
+	_, err = dec.Decode()
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *apiSuite) TestAssertsFindManyInvalidBadValue(c *check.C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/assertions/account?invalid=maybe", nil)
+	c.Assert(err, check.IsNil)
+	s.vars = map[string]string{"assertType": "account"}
+	rec := httptest.NewRecorder()
+	assertsFindManyCmd.GET(assertsFindManyCmd, req, nil).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 400)
+	c.Check(rec.Body.String(), testutil.Contains, `invalid value for invalid: \"maybe\"`)
+}
+
 func (s *apiSuite) TestAssertsInvalidType(c *check.C) {
 	// Execute
 	req, err := http.NewRequest("POST", "/v2/assertions/foo", nil)
@@ -4021,40 +4391,201 @@ func (s *apiSuite) TestStateChange(c *check.C) {
 	})
 }
 
-func (s *apiSuite) TestStateChangeAbort(c *check.C) {
+func readEventSeq(c *check.C, body []byte) []map[string]interface{} {
+	var evs []map[string]interface{}
+	for _, rec := range bytes.Split(body, []byte{0x1E}) {
+		if len(rec) == 0 {
+			continue
+		}
+		var ev map[string]interface{}
+		c.Assert(json.Unmarshal(rec, &ev), check.IsNil)
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func (s *apiSuite) TestEvents(c *check.C) {
 	restore := state.MockTime(time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC))
 	defer restore()
 
-	soon := 0
-	ensureStateSoon = func(st *state.State) {
-		soon++
-	}
-
-	// Setup
-	d := newTestDaemon(c)
+	d := s.daemon(c)
 	st := d.overlord.State()
 	st.Lock()
 	ids := setupChanges(st)
 	st.Unlock()
-	s.vars = map[string]string{"id": ids[0]}
 
-	buf := bytes.NewBufferString(`{"action": "abort"}`)
+	req, err := http.NewRequest("GET", "/v2/events?kind=install,refresh", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getEvents(eventsCmd, req, nil).(*eventSeqResponse)
 
-	// Execute
-	req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], buf)
+	st.Lock()
+	t1 := st.Task(ids[2])
+	t1.SetStatus(state.DoingStatus)
+	t1.SetProgress("downloading", 5, 10)
+	// filtered out
+	st.Task(ids[4]).Logf("not for us")
+	t1.Logf("hello")
+	st.Unlock()
+
+	// the stream ends once pending events are served
+	rsp.sub.Close()
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rec.HeaderMap.Get("Content-Type"), check.Equals, "application/json-seq")
+	c.Check(readEventSeq(c, rec.Body.Bytes()), check.DeepEquals, []map[string]interface{}{{
+		"type":        "task-status",
+		"time":        "2016-04-21T01:02:03Z",
+		"change-id":   ids[0],
+		"change-kind": "install",
+		"task-id":     ids[2],
+		"task-kind":   "download",
+		"status":      "Doing",
+	}, {
+		"type":        "change-status",
+		"time":        "2016-04-21T01:02:03Z",
+		"change-id":   ids[0],
+		"change-kind": "install",
+		"status":      "Doing",
+	}, {
+		"type":        "task-progress",
+		"time":        "2016-04-21T01:02:03Z",
+		"change-id":   ids[0],
+		"change-kind": "install",
+		"task-id":     ids[2],
+		"task-kind":   "download",
+		"progress":    map[string]interface{}{"label": "downloading", "done": 5., "total": 10.},
+	}, {
+		"type":        "task-log",
+		"time":        "2016-04-21T01:02:03Z",
+		"change-id":   ids[0],
+		"change-kind": "install",
+		"task-id":     ids[2],
+		"task-kind":   "download",
+		"message":     "2016-04-21T01:02:03Z INFO hello",
+	}})
+}
+
+func (s *apiSuite) TestEventsForChange(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/events?change-id="+ids[1], nil)
 	c.Assert(err, check.IsNil)
-	rsp := abortChange(stateChangeCmd, req, nil).(*resp)
+	rsp := getEvents(eventsCmd, req, nil).(*eventSeqResponse)
+
+	st.Lock()
+	st.Task(ids[2]).Logf("not for us")
+	st.Task(ids[4]).Logf("for us")
+	st.Unlock()
+
+	rsp.sub.Close()
 	rec := httptest.NewRecorder()
 	rsp.ServeHTTP(rec, req)
 
-	// Ensure scheduled
-	c.Check(soon, check.Equals, 1)
+	evs := readEventSeq(c, rec.Body.Bytes())
+	c.Assert(evs, check.HasLen, 1)
+	c.Check(evs[0]["task-id"], check.Equals, ids[4])
+	c.Check(evs[0]["message"], check.Matches, `\S+ INFO for us`)
+}
 
-	// Verify
-	c.Check(rec.Code, check.Equals, 200)
-	c.Check(rsp.Status, check.Equals, http.StatusOK)
-	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
-	c.Check(rsp.Result, check.NotNil)
+func (s *apiSuite) TestEventsUnknownChange(c *check.C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/events?change-id=42", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getEvents(eventsCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 404)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `cannot find change with id "42"`)
+}
+
+func (s *apiSuite) TestEventsOverflow(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/events", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getEvents(eventsCmd, req, nil).(*eventSeqResponse)
+
+	st.Lock()
+	t1 := st.Task(ids[2])
+	for i := 0; i < 1000 && !rsp.sub.Overflowed(); i++ {
+		t1.Logf("%d", i)
+	}
+	st.Unlock()
+	c.Assert(rsp.sub.Overflowed(), check.Equals, true)
+
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	evs := readEventSeq(c, rec.Body.Bytes())
+	c.Assert(len(evs) > 1, check.Equals, true)
+	c.Check(evs[len(evs)-1], check.DeepEquals, map[string]interface{}{
+		"error": "events were not consumed fast enough",
+	})
+}
+
+func (s *apiSuite) TestEventsStopsWhenDying(c *check.C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/events", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getEvents(eventsCmd, req, nil).(*eventSeqResponse)
+	dying := make(chan struct{})
+	close(dying)
+	rsp.dying = dying
+
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rec.Body.Len(), check.Equals, 0)
+
+	// the subscription was closed
+	_, ok := <-rsp.sub.Events()
+	c.Check(ok, check.Equals, false)
+}
+
+func (s *apiSuite) TestStateChangeAbort(c *check.C) {
+	restore := state.MockTime(time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC))
+	defer restore()
+
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+	}
+
+	// Setup
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	st.Unlock()
+	s.vars = map[string]string{"id": ids[0]}
+
+	buf := bytes.NewBufferString(`{"action": "abort"}`)
+
+	// Execute
+	req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], buf)
+	c.Assert(err, check.IsNil)
+	rsp := abortChange(stateChangeCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	// Ensure scheduled
+	c.Check(soon, check.Equals, 1)
+
+	// Verify
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rsp.Status, check.Equals, http.StatusOK)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.NotNil)
 
 	var body map[string]interface{}
 	err = json.Unmarshal(rec.Body.Bytes(), &body)
@@ -5065,6 +5596,314 @@ func (s *apiSuite) TestAliases(c *check.C) {
 
 }
 
+var _ = check.Suite(&appSuite{})
+
+type appSuite struct {
+	apiBaseSuite
+
+	sysdLog    [][]string
+	prevctlCmd func(...string) ([]byte, error)
+
+	jctlSvcs    [][]string
+	jctlNs      []string
+	jctlFollows []bool
+	jctlRCs     []io.ReadCloser
+
+	prevLogReader func([]string, string, bool) (io.ReadCloser, error)
+}
+
+const appsSnapYaml = `apps:
+ cmd1:
+  command: cmd1
+ svc1:
+  command: svc1
+  daemon: simple
+ svc2:
+  command: svc2
+  daemon: forking
+`
+
+func (s *appSuite) SetUpTest(c *check.C) {
+	s.apiBaseSuite.SetUpTest(c)
+
+	s.sysdLog = nil
+	s.prevctlCmd = systemd.SystemctlCmd
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		s.sysdLog = append(s.sysdLog, cmd)
+		if cmd[0] == "show" && cmd[2] == "snap.snap-a.svc1.service" {
+			return []byte("Id=snap.snap-a.svc1.service\nActiveState=active\nUnitFileState=enabled\n"), nil
+		}
+		return []byte("ActiveState=inactive\nUnitFileState=disabled\n"), nil
+	}
+
+	s.jctlSvcs = nil
+	s.jctlNs = nil
+	s.jctlFollows = nil
+	s.jctlRCs = nil
+	s.prevLogReader = systemdLogReader
+	systemdLogReader = func(svcs []string, n string, follow bool) (io.ReadCloser, error) {
+		s.jctlSvcs = append(s.jctlSvcs, svcs)
+		s.jctlNs = append(s.jctlNs, n)
+		s.jctlFollows = append(s.jctlFollows, follow)
+
+		if len(s.jctlRCs) == 0 {
+			return nil, errors.New("no journal for you")
+		}
+		rc := s.jctlRCs[0]
+		s.jctlRCs = s.jctlRCs[1:]
+		return rc, nil
+	}
+
+	d := s.daemon(c)
+	s.mkInstalledInState(c, d, "snap-a", "bar", "v1", snap.R(1), true, appsSnapYaml)
+	s.mkInstalledInState(c, d, "snap-b", "bar", "v1", snap.R(1), true, "apps: {cmd2: {command: cmd2}}")
+}
+
+func (s *appSuite) TearDownTest(c *check.C) {
+	systemd.SystemctlCmd = s.prevctlCmd
+	systemdLogReader = s.prevLogReader
+	s.apiBaseSuite.TearDownTest(c)
+}
+
+func (s *appSuite) getApps(c *check.C, query string) *resp {
+	req, err := http.NewRequest("GET", "/v2/apps"+query, nil)
+	c.Assert(err, check.IsNil)
+	return getAppsInfo(appsCmd, req, nil).(*resp)
+}
+
+func (s *appSuite) TestGetAppsInfo(c *check.C) {
+	rsp := s.getApps(c, "")
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, []appJSON{
+		{Snap: "snap-a", Name: "cmd1"},
+		{Snap: "snap-a", Name: "svc1", Daemon: "simple", Enabled: true, Active: true},
+		{Snap: "snap-a", Name: "svc2", Daemon: "forking"},
+		{Snap: "snap-b", Name: "cmd2"},
+	})
+}
+
+func (s *appSuite) TestGetAppsInfoServices(c *check.C) {
+	rsp := s.getApps(c, "?select=service")
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result, check.DeepEquals, []appJSON{
+		{Snap: "snap-a", Name: "svc1", Daemon: "simple", Enabled: true, Active: true},
+		{Snap: "snap-a", Name: "svc2", Daemon: "forking"},
+	})
+}
+
+func (s *appSuite) TestGetAppsInfoTimer(c *check.C) {
+	s.mkInstalledInState(c, s.d, "snap-c", "bar", "v1", snap.R(1), true, "apps: {tick: {command: tick, daemon: oneshot, timer: \"9:00-10:00\"}}")
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		s.sysdLog = append(s.sysdLog, cmd)
+		if cmd[1] == "--property=NextElapseUSecRealtime" {
+			return []byte("NextElapseUSecRealtime=Thu 2018-01-11 09:12:00 UTC\n"), nil
+		}
+		return []byte("Id=snap.snap-c.tick.timer\nActiveState=active\nUnitFileState=enabled\n"), nil
+	}
+
+	rsp := s.getApps(c, "?names=snap-c")
+	c.Assert(rsp.Status, check.Equals, 200)
+	apps := rsp.Result.([]appJSON)
+	c.Assert(apps, check.HasLen, 1)
+	c.Assert(apps[0].NextTrigger, check.NotNil)
+	c.Check(apps[0].NextTrigger.Equal(time.Date(2018, 1, 11, 9, 12, 0, 0, time.UTC)), check.Equals, true)
+	apps[0].NextTrigger = nil
+	c.Check(apps[0], check.DeepEquals, appJSON{Snap: "snap-c", Name: "tick", Daemon: "oneshot", Timer: "9:00-10:00", Enabled: true, Active: true})
+	c.Check(s.sysdLog, check.DeepEquals, [][]string{
+		{"show", "--property=Id,LoadState,ActiveState,SubState,UnitFileState", "snap.snap-c.tick.timer"},
+		{"show", "--property=NextElapseUSecRealtime", "snap.snap-c.tick.timer"},
+	})
+}
+
+func (s *appSuite) TestGetAppsInfoNames(c *check.C) {
+	rsp := s.getApps(c, "?names=snap-b,snap-a.svc2")
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result, check.DeepEquals, []appJSON{
+		{Snap: "snap-a", Name: "svc2", Daemon: "forking"},
+		{Snap: "snap-b", Name: "cmd2"},
+	})
+}
+
+func (s *appSuite) TestGetAppsInfoErrors(c *check.C) {
+	for _, t := range []struct {
+		query  string
+		status int
+		msg    string
+	}{
+		{"?select=potato", 400, `invalid select parameter: "potato"`},
+		{"?names=snap-x", 404, `snap "snap-x" not found`},
+		{"?names=snap-a.foo", 404, `snap "snap-a" has no app "foo"`},
+		{"?names=snap-b&select=service", 404, `snap "snap-b" has no services`},
+		{"?names=snap-a.cmd1&select=service", 400, `snap-a.cmd1 is not a service`},
+	} {
+		rsp := s.getApps(c, t.query)
+		c.Check(rsp.Status, check.Equals, t.status, check.Commentf(t.query))
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, t.msg, check.Commentf(t.query))
+	}
+}
+
+func (s *appSuite) postApps(c *check.C, inst map[string]interface{}) *resp {
+	text, err := json.Marshal(inst)
+	c.Assert(err, check.IsNil)
+	req, err := http.NewRequest("POST", "/v2/apps", bytes.NewBuffer(text))
+	c.Assert(err, check.IsNil)
+	return postApps(appsCmd, req, nil).(*resp)
+}
+
+func (s *appSuite) TestPostAppsStart(c *check.C) {
+	st := s.d.overlord.State()
+	s.d.overlord.Loop()
+	defer s.d.overlord.Stop()
+
+	rsp := s.postApps(c, map[string]interface{}{"action": "start", "names": []string{"snap-a"}, "enable": true})
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeAsync)
+
+	st.Lock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "service-control")
+	c.Check(chg.Summary(), check.Equals, `Run service command "start" for services of snaps "snap-a"`)
+	var snapNames []string
+	c.Check(chg.Get("snap-names", &snapNames), check.IsNil)
+	c.Check(snapNames, check.DeepEquals, []string{"snap-a"})
+	st.Unlock()
+
+	s.sysdLog = nil
+	<-chg.Ready()
+
+	st.Lock()
+	defer st.Unlock()
+	c.Assert(chg.Err(), check.IsNil)
+	c.Check(s.sysdLog, check.DeepEquals, [][]string{
+		{"--root", dirs.GlobalRootDir, "enable", "snap.snap-a.svc1.service"},
+		{"start", "snap.snap-a.svc1.service"},
+		{"--root", dirs.GlobalRootDir, "enable", "snap.snap-a.svc2.service"},
+		{"start", "snap.snap-a.svc2.service"},
+	})
+}
+
+func (s *appSuite) TestPostAppsErrors(c *check.C) {
+	for _, t := range []struct {
+		inst   map[string]interface{}
+		status int
+		msg    string
+	}{
+		{map[string]interface{}{"action": "start"}, 400, `cannot perform operation on services without a list of services to operate on`},
+		{map[string]interface{}{"action": "frob", "names": []string{"snap-a"}}, 400, `unknown service action "frob"`},
+		{map[string]interface{}{"action": "restart", "names": []string{"snap-a"}, "disable": true}, 400, `"restart" can only be combined with disable if stopping`},
+		{map[string]interface{}{"action": "stop", "names": []string{"snap-b"}}, 404, `snap "snap-b" has no services`},
+		{map[string]interface{}{"action": "stop", "names": []string{"snap-a.cmd1"}}, 400, `snap-a.cmd1 is not a service`},
+	} {
+		rsp := s.postApps(c, t.inst)
+		c.Check(rsp.Status, check.Equals, t.status, check.Commentf("%v", t.inst))
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, t.msg, check.Commentf("%v", t.inst))
+	}
+}
+
+func (s *appSuite) TestPostAppsConflict(c *check.C) {
+	st := s.d.overlord.State()
+	st.Lock()
+	t := st.NewTask("link-snap", "...")
+	t.Set("snap-setup", &snapstate.SnapSetup{SideInfo: &snap.SideInfo{RealName: "snap-a"}})
+	st.NewChange("refresh", "...").AddTask(t)
+	st.Unlock()
+
+	rsp := s.postApps(c, map[string]interface{}{"action": "restart", "names": []string{"snap-a.svc1"}})
+	c.Check(rsp.Status, check.Equals, 409)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `snap "snap-a" has changes in progress`)
+}
+
+func (s *appSuite) TestLogs(c *check.C) {
+	s.jctlRCs = []io.ReadCloser{ioutil.NopCloser(strings.NewReader(`
+{"MESSAGE": "hello1", "SYSLOG_IDENTIFIER": "xyzzy", "_PID": "42", "__REALTIME_TIMESTAMP": "42"}
+{"MESSAGE": "hello2", "SYSLOG_IDENTIFIER": "xyzzy", "_PID": "42", "__REALTIME_TIMESTAMP": "44"}
+`))}
+
+	req, err := http.NewRequest("GET", "/v2/logs?names=snap-a.svc2&n=42&follow=false", nil)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	getLogs(logsCmd, req, nil).ServeHTTP(rec, req)
+
+	c.Check(s.jctlSvcs, check.DeepEquals, [][]string{{"snap.snap-a.svc2.service"}})
+	c.Check(s.jctlNs, check.DeepEquals, []string{"42"})
+	c.Check(s.jctlFollows, check.DeepEquals, []bool{false})
+
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rec.HeaderMap.Get("Content-Type"), check.Equals, "application/json-seq")
+	c.Check(rec.Body.String(), check.Equals, "\x1e"+`{"timestamp":"1970-01-01T00:00:00.000042Z","message":"hello1","sid":"xyzzy","pid":"42"}`+"\n"+
+		"\x1e"+`{"timestamp":"1970-01-01T00:00:00.000044Z","message":"hello2","sid":"xyzzy","pid":"42"}`+"\n")
+}
+
+func (s *appSuite) TestLogsN(c *check.C) {
+	for _, t := range []struct {
+		in  string
+		out string
+	}{
+		{"", "10"},
+		{"0", "0"},
+		{"-1", "all"},
+		{strconv.Itoa(math.MinInt32), "all"},
+		{strconv.Itoa(math.MaxInt32), strconv.Itoa(math.MaxInt32)},
+	} {
+		s.jctlRCs = []io.ReadCloser{ioutil.NopCloser(strings.NewReader(""))}
+		s.jctlNs = nil
+
+		req, err := http.NewRequest("GET", "/v2/logs?n="+t.in, nil)
+		c.Assert(err, check.IsNil)
+		rec := httptest.NewRecorder()
+		getLogs(logsCmd, req, nil).ServeHTTP(rec, req)
+
+		c.Check(rec.Code, check.Equals, 200, check.Commentf(t.in))
+		c.Check(s.jctlNs, check.DeepEquals, []string{t.out}, check.Commentf(t.in))
+	}
+}
+
+func (s *appSuite) TestLogsAllServices(c *check.C) {
+	s.jctlRCs = []io.ReadCloser{ioutil.NopCloser(strings.NewReader(""))}
+
+	req, err := http.NewRequest("GET", "/v2/logs?follow=true", nil)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	getLogs(logsCmd, req, nil).ServeHTTP(rec, req)
+
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(s.jctlSvcs, check.DeepEquals, [][]string{{"snap.snap-a.svc1.service", "snap.snap-a.svc2.service"}})
+	c.Check(s.jctlFollows, check.DeepEquals, []bool{true})
+}
+
+func (s *appSuite) TestLogsRootOnly(c *check.C) {
+	req, err := http.NewRequest("GET", "/v2/logs", nil)
+	c.Assert(err, check.IsNil)
+
+	// the journal of snap services is not for any local user to read
+	req.RemoteAddr = "pid=100;uid=42;"
+	c.Check(logsCmd.canAccess(req, nil), check.Equals, false)
+
+	req.RemoteAddr = "pid=100;uid=0;"
+	c.Check(logsCmd.canAccess(req, nil), check.Equals, true)
+}
+
+func (s *appSuite) TestLogsErrors(c *check.C) {
+	for _, t := range []struct {
+		query  string
+		status int
+		msg    string
+	}{
+		{"?n=hello", 400, `invalid value for n: "hello": .*`},
+		{"?follow=hello", 400, `invalid value for follow: "hello": .*`},
+		{"?names=snap-b", 404, `snap "snap-b" has no services`},
+		{"?names=snap-a", 500, `cannot get logs: no journal for you`},
+	} {
+		req, err := http.NewRequest("GET", "/v2/logs"+t.query, nil)
+		c.Assert(err, check.IsNil)
+		rsp := getLogs(logsCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, t.status, check.Commentf(t.query))
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, t.msg, check.Commentf(t.query))
+	}
+}
+
 var _ = check.Suite(&postDebugSuite{})
 
 type postDebugSuite struct {
@@ -5092,3 +5931,371 @@ func (s *postDebugSuite) TestPostDebugEnsureStateSoon(c *check.C) {
 	c.Check(rsp.Result, check.Equals, true)
 	c.Check(soon, check.Equals, 1)
 }
+
+func (s *postDebugSuite) TestGetDebugChangeTimings(c *check.C) {
+	restore := state.MockTime(time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC))
+	defer restore()
+
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+	r.AddHandler("download", func(t *state.Task, tb *tomb.Tomb) error { return nil }, nil)
+
+	st.Lock()
+	chg := st.NewChange("install", "install...")
+	t1 := st.NewTask("download", "1...")
+	chg.AddTask(t1)
+	st.Unlock()
+
+	r.Ensure()
+	r.Wait()
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=change-timings&change-id="+chg.ID(), nil)
+	c.Assert(err, check.IsNil)
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+	c.Check(body["result"], check.DeepEquals, map[string]interface{}{
+		"id":         chg.ID(),
+		"kind":       "install",
+		"summary":    "install...",
+		"status":     "Done",
+		"spawn-time": "2016-04-21T01:02:03Z",
+		"ready-time": "2016-04-21T01:02:03Z",
+		"tasks": []interface{}{
+			map[string]interface{}{
+				"id":      t1.ID(),
+				"kind":    "download",
+				"summary": "1...",
+				"status":  "Done",
+				"timings": []interface{}{
+					map[string]interface{}{
+						"label":    "do",
+						"start":    "2016-04-21T01:02:03Z",
+						"duration": 0.,
+						"outcome":  "done",
+					},
+				},
+				"spawn-time": "2016-04-21T01:02:03Z",
+				"ready-time": "2016-04-21T01:02:03Z",
+			},
+		},
+	})
+}
+
+func (s *postDebugSuite) TestGetDebugChangeTimingsEnsure(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	st.Lock()
+	chg := st.NewChange("install", "install...")
+	chg.AddTask(st.NewTask("foo", "1..."))
+	st.Unlock()
+
+	// the change is in progress, so it covers the ensure pass
+	c.Assert(d.overlord.StateEngine().Ensure(), check.IsNil)
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=change-timings&change-id="+chg.ID(), nil)
+	c.Assert(err, check.IsNil)
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+
+	info := rsp.Result.(*changeTimingsInfo)
+	labels := make(map[string]bool)
+	for _, span := range info.EnsureTimings {
+		c.Check(span.Outcome, check.Equals, "done")
+		labels[span.Label] = true
+	}
+	c.Check(labels["snapstate.SnapManager"], check.Equals, true)
+	c.Check(labels["ifacestate.InterfaceManager"], check.Equals, true)
+}
+
+func (s *postDebugSuite) TestGetDebugErrors(c *check.C) {
+	s.daemon(c)
+
+	for _, t := range []struct {
+		query  string
+		status int
+		msg    string
+	}{
+		{"", 400, `unknown debug aspect ""`},
+		{"aspect=foo", 400, `unknown debug aspect "foo"`},
+		{"aspect=change-timings", 400, `change-timings requires a change-id`},
+		{"aspect=change-timings&change-id=42", 404, `cannot find change with id "42"`},
+	} {
+		req, err := http.NewRequest("GET", "/v2/debug?"+t.query, nil)
+		c.Assert(err, check.IsNil)
+		rsp := getDebug(debugCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, t.status, check.Commentf(t.query))
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, t.msg)
+	}
+}
+
+var _ = check.Suite(&snapshotSuite{})
+
+type snapshotSuite struct {
+	apiBaseSuite
+}
+
+func (s *snapshotSuite) SetUpTest(c *check.C) {
+	s.apiBaseSuite.SetUpTest(c)
+	s.daemon(c)
+	ensureStateSoon = func(st *state.State) {}
+}
+
+func (s *snapshotSuite) TearDownTest(c *check.C) {
+	snapshotList = snapshotstate.List
+	snapshotSave = snapshotstate.Save
+	snapshotCheck = snapshotstate.Check
+	snapshotRestore = snapshotstate.Restore
+	snapshotForget = snapshotstate.Forget
+	s.apiBaseSuite.TearDownTest(c)
+}
+
+func (s *snapshotSuite) postSnapshots(c *check.C, body string) *resp {
+	req, err := http.NewRequest("POST", "/v2/snapshots", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	return changeSnapshots(snapshotCmd, req, nil).(*resp)
+}
+
+func (s *snapshotSuite) TestListSnapshots(c *check.C) {
+	snapshots := []client.SnapshotSet{{ID: 1}, {ID: 42}}
+	snapshotList = func(setID uint64, snapNames []string) ([]client.SnapshotSet, error) {
+		c.Check(setID, check.Equals, uint64(42))
+		c.Check(snapNames, check.DeepEquals, []string{"foo", "bar"})
+		return snapshots, nil
+	}
+
+	req, err := http.NewRequest("GET", "/v2/snapshots?set=42&snaps=foo,bar", nil)
+	c.Assert(err, check.IsNil)
+	rsp := listSnapshots(snapshotCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result, check.DeepEquals, snapshots)
+}
+
+func (s *snapshotSuite) TestListSnapshotsBadSet(c *check.C) {
+	snapshotList = func(uint64, []string) ([]client.SnapshotSet, error) {
+		c.Fatal("snapshotList should not be reached")
+		return nil, nil
+	}
+
+	req, err := http.NewRequest("GET", "/v2/snapshots?set=-1", nil)
+	c.Assert(err, check.IsNil)
+	rsp := listSnapshots(snapshotCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Matches, `'set', if given, must be a positive base 10 number; got "-1"`)
+}
+
+func (s *snapshotSuite) TestSaveSnapshots(c *check.C) {
+	snapshotSave = func(st *state.State, snapNames []string, users []string) (uint64, []string, *state.TaskSet, error) {
+		c.Check(snapNames, check.HasLen, 0)
+		c.Check(users, check.DeepEquals, []string{"me"})
+		t := st.NewTask("fake-save-snapshot", "...")
+		return 42, []string{"bar", "foo"}, state.NewTaskSet(t), nil
+	}
+
+	rsp := s.postSnapshots(c, `{"action": "save", "users": ["me"]}`)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Check(rsp.Result, check.DeepEquals, map[string]interface{}{"set-id": uint64(42), "snaps": []string{"bar", "foo"}})
+
+	st := s.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "save-snapshot")
+	c.Check(chg.Summary(), check.Equals, `Save data of all snaps in snapshot set #42`)
+	var snapNames []string
+	c.Check(chg.Get("snap-names", &snapNames), check.IsNil)
+	c.Check(snapNames, check.DeepEquals, []string{"bar", "foo"})
+}
+
+func (s *snapshotSuite) TestChangeSnapshots(c *check.C) {
+	fakeTS := func(st *state.State) *state.TaskSet {
+		return state.NewTaskSet(st.NewTask("fake", "..."))
+	}
+	snapshotCheck = func(st *state.State, setID uint64, snapNames []string, users []string) ([]string, *state.TaskSet, error) {
+		c.Check(setID, check.Equals, uint64(42))
+		c.Check(users, check.DeepEquals, []string{"me"})
+		return []string{"foo"}, fakeTS(st), nil
+	}
+	snapshotRestore = func(st *state.State, setID uint64, snapNames []string, users []string) ([]string, *state.TaskSet, error) {
+		c.Check(setID, check.Equals, uint64(42))
+		c.Check(snapNames, check.DeepEquals, []string{"foo"})
+		return []string{"foo"}, fakeTS(st), nil
+	}
+	snapshotForget = func(st *state.State, setID uint64, snapNames []string) ([]string, *state.TaskSet, error) {
+		c.Check(setID, check.Equals, uint64(42))
+		return []string{"foo"}, fakeTS(st), nil
+	}
+
+	for _, t := range []struct {
+		body    string
+		kind    string
+		summary string
+	}{
+		{`{"action": "check", "set": 42, "users": ["me"]}`, "check-snapshot", `Check of snapshot set #42 for users "me"`},
+		{`{"action": "restore", "set": 42, "snaps": ["foo"]}`, "restore-snapshot", `Restore of snapshot set #42 for snaps "foo"`},
+		{`{"action": "forget", "set": 42}`, "forget-snapshot", `Forget of snapshot set #42`},
+	} {
+		rsp := s.postSnapshots(c, t.body)
+		c.Assert(rsp.Status, check.Equals, 202, check.Commentf(t.body))
+
+		st := s.d.overlord.State()
+		st.Lock()
+		chg := st.Change(rsp.Change)
+		c.Assert(chg, check.NotNil)
+		c.Check(chg.Kind(), check.Equals, t.kind)
+		c.Check(chg.Summary(), check.Equals, t.summary)
+		st.Unlock()
+	}
+}
+
+func (s *snapshotSuite) TestChangeSnapshotsErrors(c *check.C) {
+	snapshotRestore = func(*state.State, uint64, []string, []string) ([]string, *state.TaskSet, error) {
+		return nil, nil, snapshotstate.ErrNoSnapshot
+	}
+	snapshotCheck = func(*state.State, uint64, []string, []string) ([]string, *state.TaskSet, error) {
+		return nil, nil, errors.New("boom")
+	}
+
+	for _, t := range []struct {
+		body   string
+		status int
+		err    string
+	}{
+		{`{"action": "restore", "set": 42}`, 404, `no snapshot has the given set id`},
+		{`{"action": "check", "set": 42}`, 400, `boom`},
+		{`{"action": "check"}`, 400, `snapshot operation "check" requires a snapshot set id`},
+		{`{"action": "forget", "set": 42, "users": ["me"]}`, 400, `snapshot operation "forget" cannot be limited to users`},
+		{`{"action": "frob", "set": 42}`, 400, `unknown snapshot operation "frob"`},
+		{`{"action": "save"}{}`, 400, `extra content found after snapshot operation`},
+		{`garbage`, 400, `cannot decode request body into snapshot operation: .*`},
+	} {
+		rsp := s.postSnapshots(c, t.body)
+		c.Check(rsp.Status, check.Equals, t.status, check.Commentf(t.body))
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, t.err, check.Commentf(t.body))
+	}
+}
+
+var _ = check.Suite(&modelSuite{})
+
+type modelSuite struct {
+	apiBaseSuite
+}
+
+func (s *modelSuite) SetUpTest(c *check.C) {
+	s.apiBaseSuite.SetUpTest(c)
+	s.daemon(c)
+	ensureStateSoon = func(st *state.State) {}
+}
+
+func (s *modelSuite) TearDownTest(c *check.C) {
+	devicestateRemodel = devicestate.Remodel
+	s.apiBaseSuite.TearDownTest(c)
+}
+
+func (s *modelSuite) makeModel(c *check.C, model string) *asserts.Model {
+	a, err := s.storeSigning.Sign(asserts.ModelType, map[string]interface{}{
+		"series":       "16",
+		"brand-id":     "can0nical",
+		"model":        model,
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+		"architecture": "amd64",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	return a.(*asserts.Model)
+}
+
+func (s *modelSuite) TestGetModelNoModel(c *check.C) {
+	req, err := http.NewRequest("GET", "/v2/model", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getModel(modelCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 404)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "no model assertion yet")
+}
+
+func (s *modelSuite) TestGetModel(c *check.C) {
+	st := s.d.overlord.State()
+	assertAdd(st, s.storeSigning.StoreAccountKey(""))
+	assertAdd(st, s.makeModel(c, "pc"))
+	st.Lock()
+	auth.SetDevice(st, &auth.DeviceState{Brand: "can0nical", Model: "pc"})
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/model", nil)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	getModel(modelCmd, req, nil).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, http.StatusOK, check.Commentf("body %q", rec.Body))
+	c.Check(rec.HeaderMap.Get("Content-Type"), check.Equals, "application/x.ubuntu.assertion")
+
+	a, err := asserts.Decode(rec.Body.Bytes())
+	c.Assert(err, check.IsNil)
+	c.Check(a.Type(), check.Equals, asserts.ModelType)
+	c.Check(a.(*asserts.Model).Model(), check.Equals, "pc")
+}
+
+func (s *modelSuite) TestPostModel(c *check.C) {
+	newModel := s.makeModel(c, "other-pc")
+	devicestateRemodel = func(st *state.State, m *asserts.Model) (*state.Change, error) {
+		c.Check(m.Model(), check.Equals, "other-pc")
+		chg := st.NewChange("remodel", "...")
+		chg.AddTask(st.NewTask("fake-set-model", "..."))
+		return chg, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"new-model": string(asserts.Encode(newModel))})
+	c.Assert(err, check.IsNil)
+	req, err := http.NewRequest("POST", "/v2/model", bytes.NewReader(body))
+	c.Assert(err, check.IsNil)
+	rsp := postModel(modelCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 202)
+
+	st := s.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "remodel")
+}
+
+func (s *modelSuite) TestPostModelErrors(c *check.C) {
+	devicestateRemodel = func(*state.State, *asserts.Model) (*state.Change, error) {
+		return nil, errors.New("boom")
+	}
+
+	acctKey := string(asserts.Encode(s.storeSigning.StoreAccountKey("")))
+	model := string(asserts.Encode(s.makeModel(c, "pc")))
+	for _, t := range []struct {
+		newModel string
+		err      string
+	}{
+		{"garbage", `cannot decode new model assertion: .*`},
+		{acctKey, `new model is not a model assertion: account-key`},
+		{model, `cannot remodel device: boom`},
+	} {
+		body, err := json.Marshal(map[string]string{"new-model": t.newModel})
+		c.Assert(err, check.IsNil)
+		req, err := http.NewRequest("POST", "/v2/model", bytes.NewReader(body))
+		c.Assert(err, check.IsNil)
+		rsp := postModel(modelCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, 400)
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, t.err)
+	}
+
+	req, err := http.NewRequest("POST", "/v2/model", strings.NewReader("garbage"))
+	c.Assert(err, check.IsNil)
+	rsp := postModel(modelCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Matches, `cannot decode request body into remodel operation: .*`)
+}
@@ -73,6 +73,10 @@ type Command struct {
 	UserOK bool
 	// is this path accessible on the snapd-snap socket?
 	SnapOK bool
+	// PolkitOK returns the polkit action id a local non-root user
+	// needs to be authorized for to perform the request, or "" if
+	// the request cannot be authorized via polkit
+	PolkitOK func(r *http.Request) string
 
 	d *Daemon
 }
@@ -84,7 +88,7 @@ func (c *Command) canAccess(r *http.Request, user *auth.UserState) bool {
 	}
 
 	isUser := false
-	uid, err := ucrednetGetUID(r.RemoteAddr)
+	pid, uid, err := ucrednetGet(r.RemoteAddr)
 	if err == nil {
 		if uid == 0 {
 			// Superuser does anything.
@@ -100,6 +104,9 @@ func (c *Command) canAccess(r *http.Request, user *auth.UserState) bool {
 	}
 
 	if r.Method != "GET" {
+		if isUser && c.PolkitOK != nil {
+			return c.polkitAuthorized(r, pid, uid)
+		}
 		return false
 	}
 
@@ -165,6 +172,23 @@ func (w *wrappedWriter) WriteHeader(s int) {
 	w.s = s
 }
 
+// Flush makes wrappedWriter an http.Flusher, for streaming responses.
+func (w *wrappedWriter) Flush() {
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify makes wrappedWriter an http.CloseNotifier, for
+// streaming responses.
+func (w *wrappedWriter) CloseNotify() <-chan bool {
+	if n, ok := w.w.(http.CloseNotifier); ok {
+		return n.CloseNotify()
+	}
+	// never notifies
+	return make(chan bool)
+}
+
 func logit(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ww := &wrappedWriter{w: w}
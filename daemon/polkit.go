@@ -0,0 +1,123 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/polkit"
+)
+
+// polkit action ids local non-root users can be authorized for
+const (
+	polkitActionInstall        = "io.snapcraft.snapd.install"
+	polkitActionRemove         = "io.snapcraft.snapd.remove"
+	polkitActionRefresh        = "io.snapcraft.snapd.refresh"
+	polkitActionConnect        = "io.snapcraft.snapd.connect"
+	polkitActionManageServices = "io.snapcraft.snapd.manage-services"
+)
+
+// allowInteractionHeader is set by clients that can handle the user
+// being asked to authenticate during the request
+const allowInteractionHeader = "X-Allow-Interaction"
+
+var polkitCheckAuthorization = polkit.CheckAuthorization
+
+// polkitAuthorized checks with polkit whether the process pid owned
+// by uid is authorized to perform the request.
+func (c *Command) polkitAuthorized(r *http.Request, pid, uid uint32) bool {
+	actionID := c.PolkitOK(r)
+	if actionID == "" {
+		return false
+	}
+
+	var flags polkit.CheckFlags
+	if r.Header.Get(allowInteractionHeader) == "true" {
+		flags |= polkit.CheckAllowInteraction
+	}
+
+	authorized, err := polkitCheckAuthorization(pid, uid, actionID, nil, flags)
+	if err != nil {
+		logger.Noticef("cannot check polkit authorization for %q: %v", actionID, err)
+		return false
+	}
+
+	return authorized
+}
+
+// maxPolkitBodySize is how much of a request body is read to find
+// the polkit action, before the user is authorized
+const maxPolkitBodySize = 4096
+
+var snapActionPolkit = map[string]string{
+	"install": polkitActionInstall,
+	"remove":  polkitActionRemove,
+	"refresh": polkitActionRefresh,
+	"revert":  polkitActionRefresh,
+}
+
+// polkitSnapAction returns the polkit action id for a request to
+// /v2/snaps or /v2/snaps/{name}. The action is taken from the snap
+// instruction in the body, which is left for the handler to read
+// again. Larger bodies than a snap instruction can be are denied, as
+// are sideloads and instructions lowering the confinement of the snap,
+// which can only be done by root.
+func polkitSnapAction(r *http.Request) string {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return ""
+	}
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPolkitBodySize+1))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) > maxPolkitBodySize {
+		return ""
+	}
+
+	var inst struct {
+		Action  string `json:"action"`
+		DevMode bool   `json:"devmode"`
+		Classic bool   `json:"classic"`
+	}
+	if err := json.Unmarshal(body, &inst); err != nil {
+		return ""
+	}
+	if inst.DevMode || inst.Classic {
+		return ""
+	}
+
+	return snapActionPolkit[inst.Action]
+}
+
+func polkitConnectAction(*http.Request) string {
+	return polkitActionConnect
+}
+
+func polkitManageServicesAction(*http.Request) string {
+	return polkitActionManageServices
+}
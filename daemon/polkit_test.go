@@ -0,0 +1,195 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package daemon
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/polkit"
+)
+
+type polkitSuite struct {
+	restore func()
+
+	calls   []string
+	checker func(pid, uid uint32, actionID string, flags polkit.CheckFlags) (bool, error)
+}
+
+var _ = check.Suite(&polkitSuite{})
+
+func (s *polkitSuite) SetUpTest(c *check.C) {
+	s.calls = nil
+	s.checker = func(uint32, uint32, string, polkit.CheckFlags) (bool, error) {
+		return true, nil
+	}
+
+	old := polkitCheckAuthorization
+	polkitCheckAuthorization = func(pid, uid uint32, actionID string, details map[string]string, flags polkit.CheckFlags) (bool, error) {
+		s.calls = append(s.calls, actionID)
+		return s.checker(pid, uid, actionID, flags)
+	}
+	s.restore = func() { polkitCheckAuthorization = old }
+}
+
+func (s *polkitSuite) TearDownTest(c *check.C) {
+	s.restore()
+}
+
+func postReq(c *check.C, remoteAddr, contentType, body string) *http.Request {
+	req, err := http.NewRequest("POST", "/v2/snaps/foo", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func (s *polkitSuite) TestUserAccessAuthorized(c *check.C) {
+	var gotPid, gotUid uint32
+	var gotFlags polkit.CheckFlags
+	s.checker = func(pid, uid uint32, actionID string, flags polkit.CheckFlags) (bool, error) {
+		gotPid, gotUid, gotFlags = pid, uid, flags
+		return true, nil
+	}
+
+	cmd := &Command{PolkitOK: polkitSnapAction}
+	req := postReq(c, "pid=100;uid=42;", "application/json", `{"action": "install"}`)
+	c.Check(cmd.canAccess(req, nil), check.Equals, true)
+	c.Check(s.calls, check.DeepEquals, []string{"io.snapcraft.snapd.install"})
+	c.Check(gotPid, check.Equals, uint32(100))
+	c.Check(gotUid, check.Equals, uint32(42))
+	c.Check(gotFlags, check.Equals, polkit.CheckNone)
+
+	// the body is still there for the handler
+	body, err := ioutil.ReadAll(req.Body)
+	c.Assert(err, check.IsNil)
+	c.Check(string(body), check.Equals, `{"action": "install"}`)
+}
+
+func (s *polkitSuite) TestUserAccessAllowInteraction(c *check.C) {
+	var gotFlags polkit.CheckFlags
+	s.checker = func(pid, uid uint32, actionID string, flags polkit.CheckFlags) (bool, error) {
+		gotFlags = flags
+		return true, nil
+	}
+
+	cmd := &Command{PolkitOK: polkitSnapAction}
+	req := postReq(c, "pid=100;uid=42;", "application/json", `{"action": "remove"}`)
+	req.Header.Set("X-Allow-Interaction", "true")
+	c.Check(cmd.canAccess(req, nil), check.Equals, true)
+	c.Check(s.calls, check.DeepEquals, []string{"io.snapcraft.snapd.remove"})
+	c.Check(gotFlags, check.Equals, polkit.CheckAllowInteraction)
+}
+
+func (s *polkitSuite) TestUserAccessDenied(c *check.C) {
+	s.checker = func(uint32, uint32, string, polkit.CheckFlags) (bool, error) {
+		return false, nil
+	}
+
+	cmd := &Command{PolkitOK: polkitSnapAction}
+	req := postReq(c, "pid=100;uid=42;", "application/json", `{"action": "refresh"}`)
+	c.Check(cmd.canAccess(req, nil), check.Equals, false)
+	c.Check(s.calls, check.DeepEquals, []string{"io.snapcraft.snapd.refresh"})
+}
+
+func (s *polkitSuite) TestUserAccessError(c *check.C) {
+	s.checker = func(uint32, uint32, string, polkit.CheckFlags) (bool, error) {
+		return false, errors.New("boom")
+	}
+
+	cmd := &Command{PolkitOK: polkitConnectAction}
+	req := postReq(c, "pid=100;uid=42;", "application/json", `{"action": "connect"}`)
+	c.Check(cmd.canAccess(req, nil), check.Equals, false)
+	c.Check(s.calls, check.DeepEquals, []string{"io.snapcraft.snapd.connect"})
+}
+
+func (s *polkitSuite) TestUserAccessDeniedWithoutAction(c *check.C) {
+	cmd := &Command{PolkitOK: polkitSnapAction}
+
+	// sideloading
+	req := postReq(c, "pid=100;uid=42;", "multipart/form-data; boundary=foo", "--foo\r\nContent-Disposition: form-data; name=\"dangerous\"\r\n\r\ntrue\r\n--foo--\r\n")
+	c.Check(cmd.canAccess(req, nil), check.Equals, false)
+	// lowering the confinement
+	req = postReq(c, "pid=100;uid=42;", "application/json", `{"action": "install", "devmode": true}`)
+	c.Check(cmd.canAccess(req, nil), check.Equals, false)
+
+	// polkit is not even asked
+	c.Check(s.calls, check.HasLen, 0)
+}
+
+func (s *polkitSuite) TestFastPathsSkipPolkit(c *check.C) {
+	cmd := &Command{PolkitOK: polkitManageServicesAction}
+
+	// root
+	req := postReq(c, "pid=100;uid=0;", "application/json", `{}`)
+	c.Check(cmd.canAccess(req, nil), check.Equals, true)
+	// no peer credentials
+	req = postReq(c, "", "application/json", `{}`)
+	c.Check(cmd.canAccess(req, nil), check.Equals, false)
+	// reads
+	req, err := http.NewRequest("GET", "/v2/apps", nil)
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "pid=100;uid=42;"
+	c.Check(cmd.canAccess(req, nil), check.Equals, false)
+
+	c.Check(s.calls, check.HasLen, 0)
+
+	// without PolkitOK there is nothing to check
+	cmd = &Command{}
+	req = postReq(c, "pid=100;uid=42;", "application/json", `{}`)
+	c.Check(cmd.canAccess(req, nil), check.Equals, false)
+	c.Check(s.calls, check.HasLen, 0)
+}
+
+func (s *polkitSuite) TestPolkitSnapAction(c *check.C) {
+	for _, t := range []struct {
+		contentType string
+		body        string
+		actionID    string
+	}{
+		{"application/json", `{"action": "install"}`, "io.snapcraft.snapd.install"},
+		{"application/json", `{"action": "remove", "snaps": ["foo"]}`, "io.snapcraft.snapd.remove"},
+		{"application/json", `{"action": "refresh"}`, "io.snapcraft.snapd.refresh"},
+		{"application/json", `{"action": "revert"}`, "io.snapcraft.snapd.refresh"},
+		{"application/json", `{"action": "enable"}`, ""},
+		{"application/json", `garbage`, ""},
+		{"application/json", `{"action": "install", "jailmode": true}`, "io.snapcraft.snapd.install"},
+		// lowering the confinement needs root
+		{"application/json", `{"action": "install", "devmode": true}`, ""},
+		{"application/json", `{"action": "refresh", "classic": true}`, ""},
+		// so does sideloading
+		{"multipart/form-data; boundary=foo", `garbage`, ""},
+		// too big to be read before authorization
+		{"application/json", `{"action": "install", "x": "` + strings.Repeat("x", 4096) + `"}`, ""},
+	} {
+		req := postReq(c, "", t.contentType, t.body)
+		c.Check(polkitSnapAction(req), check.Equals, t.actionID, check.Commentf("%s", t.body))
+	}
+}
+
+func (s *polkitSuite) TestCommandsPolkitActions(c *check.C) {
+	c.Check(snapsCmd.PolkitOK, check.NotNil)
+	c.Check(snapCmd.PolkitOK, check.NotNil)
+	c.Check(interfacesCmd.PolkitOK(nil), check.Equals, "io.snapcraft.snapd.connect")
+	c.Check(appsCmd.PolkitOK(nil), check.Equals, "io.snapcraft.snapd.manage-services")
+}
@@ -20,15 +20,21 @@
 package daemon
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"mime"
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/systemd"
 )
 
 // ResponseType is the response type
@@ -230,6 +236,185 @@ func (ar assertResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// logJSON is the json for a single journal entry.
+type logJSON struct {
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message"`
+	SID       string    `json:"sid"`
+	PID       string    `json:"pid"`
+}
+
+// A journalLineReaderSeqResponse's ServeHTTP method reads journal
+// entries (in journalctl's JSON output format) from its reader and
+// serves them as a json-seq (RFC 7464) stream of logJSONs.
+type journalLineReaderSeqResponse struct {
+	io.ReadCloser
+	follow bool
+
+	closeOnce sync.Once
+}
+
+func (rr *journalLineReaderSeqResponse) close() {
+	rr.closeOnce.Do(func() { rr.ReadCloser.Close() })
+}
+
+func (rr *journalLineReaderSeqResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json-seq")
+
+	flusher, hasFlusher := w.(http.Flusher)
+
+	if rr.follow {
+		// closing the reader unblocks the decoder below when
+		// the client goes away
+		if notifier, ok := w.(http.CloseNotifier); ok {
+			closed := notifier.CloseNotify()
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-closed:
+					rr.close()
+				case <-done:
+				}
+			}()
+		}
+	}
+
+	var err error
+	dec := json.NewDecoder(rr)
+	writer := bufio.NewWriter(w)
+	enc := json.NewEncoder(writer)
+	for {
+		var log systemd.Log
+		if err = dec.Decode(&log); err != nil {
+			break
+		}
+
+		t, _ := log.Time()
+		writer.WriteByte(0x1E) // RS -- see ascii(7), and RFC7464
+
+		// ignore the error...
+		enc.Encode(logJSON{
+			Timestamp: t,
+			Message:   log.Message(),
+			SID:       log.SID(),
+			PID:       log.PID(),
+		})
+		if rr.follow {
+			if e := writer.Flush(); e != nil {
+				break
+			}
+			if hasFlusher {
+				flusher.Flush()
+			}
+		}
+	}
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(writer, "\x1E{\"error\": %q}\n", err)
+		logger.Noticef("cannot stream response; problem reading: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		logger.Noticef("cannot stream response; problem writing: %v", err)
+	}
+	rr.close()
+}
+
+type eventJSON struct {
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	ChangeID   string            `json:"change-id"`
+	ChangeKind string            `json:"change-kind"`
+	TaskID     string            `json:"task-id,omitempty"`
+	TaskKind   string            `json:"task-kind,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	Progress   *taskInfoProgress `json:"progress,omitempty"`
+	Message    string            `json:"message,omitempty"`
+}
+
+func event2eventJSON(ev *state.Event) *eventJSON {
+	evJSON := &eventJSON{
+		Type:       string(ev.Type),
+		Time:       ev.Time,
+		ChangeID:   ev.ChangeID,
+		ChangeKind: ev.ChangeKind,
+		TaskID:     ev.TaskID,
+		TaskKind:   ev.TaskKind,
+		Message:    ev.Message,
+	}
+	switch ev.Type {
+	case state.ChangeStatusEvent, state.TaskStatusEvent:
+		evJSON.Status = ev.Status.String()
+	case state.TaskProgressEvent:
+		evJSON.Progress = &taskInfoProgress{
+			Label: ev.Label,
+			Done:  ev.Done,
+			Total: ev.Total,
+		}
+	}
+	return evJSON
+}
+
+// An eventSeqResponse's ServeHTTP method serves the events of its
+// subscription as a json-seq (RFC 7464) stream of eventJSONs, until
+// the client goes away, the daemon stops, or the subscription ends.
+type eventSeqResponse struct {
+	sub   *state.Subscription
+	dying <-chan struct{}
+}
+
+func (er *eventSeqResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer er.sub.Close()
+
+	w.Header().Set("Content-Type", "application/json-seq")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, hasFlusher := w.(http.Flusher)
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+
+	writer := bufio.NewWriter(w)
+	enc := json.NewEncoder(writer)
+	flush := func() error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if hasFlusher {
+			flusher.Flush()
+		}
+		return nil
+	}
+	// let the client know the subscription is in place
+	if err := flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case ev, ok := <-er.sub.Events():
+			if !ok {
+				if er.sub.Overflowed() {
+					fmt.Fprintf(writer, "\x1E{\"error\": %q}\n", "events were not consumed fast enough")
+					flush()
+				}
+				return
+			}
+			writer.WriteByte(0x1E) // RS -- see ascii(7), and RFC7464
+			// ignore the error...
+			enc.Encode(event2eventJSON(&ev))
+			if err := flush(); err != nil {
+				logger.Noticef("cannot stream events; problem writing: %v", err)
+				return
+			}
+		case <-closed:
+			return
+		case <-er.dying:
+			return
+		}
+	}
+}
+
 // errorResponder is a callable that produces an error Response.
 // e.g., InternalError("something broke: %v", err), etc.
 type errorResponder func(string, ...interface{}) Response
@@ -30,6 +30,7 @@ import (
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/wrappers"
 )
 
 var errNoSnap = errors.New("no snap installed")
@@ -157,9 +158,65 @@ func allLocalSnapInfos(st *state.State, all bool, wanted map[string]bool) ([]abo
 
 // appJSON contains the json for snap.AppInfo
 type appJSON struct {
+	Snap    string   `json:"snap,omitempty"`
 	Name    string   `json:"name"`
 	Daemon  string   `json:"daemon"`
 	Aliases []string `json:"aliases,omitempty"`
+	Enabled bool     `json:"enabled,omitempty"`
+	Active  bool     `json:"active,omitempty"`
+
+	Timer       string     `json:"timer,omitempty"`
+	NextTrigger *time.Time `json:"next-trigger,omitempty"`
+}
+
+// appJSONsWithStatus returns the json for the given apps, including
+// their snap name and, for services, whether they're enabled and
+// active and, for timer-activated services, when they will next be
+// started.
+func appJSONsWithStatus(apps []*snap.AppInfo) ([]appJSON, error) {
+	out := make([]appJSON, len(apps))
+	var svcs []*snap.AppInfo
+	var svcIdx []int
+	for i, app := range apps {
+		out[i] = appJSON{
+			Snap:    app.Snap.Name(),
+			Name:    app.Name,
+			Daemon:  app.Daemon,
+			Aliases: app.Aliases,
+			Timer:   app.Timer,
+		}
+		if app.IsService() {
+			svcs = append(svcs, app)
+			svcIdx = append(svcIdx, i)
+		}
+	}
+	if len(svcs) == 0 {
+		return out, nil
+	}
+
+	sts, err := wrappers.ServicesStatus(svcs)
+	if err != nil {
+		return nil, err
+	}
+	for i, st := range sts {
+		out[svcIdx[i]].Enabled = st.UnitFileState == "enabled"
+		out[svcIdx[i]].Active = st.ActiveState == "active"
+	}
+
+	for i, app := range svcs {
+		if app.Timer == "" {
+			continue
+		}
+		next, err := wrappers.TimerNextTrigger(app)
+		if err != nil {
+			return nil, err
+		}
+		if !next.IsZero() {
+			out[svcIdx[i]].NextTrigger = &next
+		}
+	}
+
+	return out, nil
 }
 
 // screenshotJSON contains the json for snap.ScreenshotInfo
@@ -30,38 +30,67 @@ import (
 
 var errNoUID = errors.New("no uid found")
 
-const ucrednetNobody = uint32((1 << 32) - 1)
+const (
+	ucrednetNoProcess = uint32(0)
+	ucrednetNobody    = uint32((1 << 32) - 1)
+)
+
+// ucrednetGet returns the pid and uid of the peer from the remote
+// address of a connection accepted by a ucrednetListener, of the form
+// [pid=<pid>;]uid=<uid>;<address>.
+func ucrednetGet(remoteAddr string) (pid uint32, uid uint32, err error) {
+	pid = ucrednetNoProcess
+	if strings.HasPrefix(remoteAddr, "pid=") {
+		idx := strings.IndexByte(remoteAddr, ';')
+		if idx < 0 {
+			return ucrednetNoProcess, ucrednetNobody, errNoUID
+		}
+		if idx > 4 {
+			v, err := strconv.ParseUint(remoteAddr[4:idx], 10, 32)
+			if err != nil {
+				return ucrednetNoProcess, ucrednetNobody, err
+			}
+			pid = uint32(v)
+		}
+		remoteAddr = remoteAddr[idx+1:]
+	}
 
-func ucrednetGetUID(remoteAddr string) (uint32, error) {
 	idx := strings.IndexByte(remoteAddr, ';')
 	if !strings.HasPrefix(remoteAddr, "uid=") || idx < 5 {
-		return ucrednetNobody, errNoUID
+		return ucrednetNoProcess, ucrednetNobody, errNoUID
 	}
 
-	uid, err := strconv.ParseUint(remoteAddr[4:idx], 10, 32)
+	v, err := strconv.ParseUint(remoteAddr[4:idx], 10, 32)
 	if err != nil {
-		return ucrednetNobody, err
+		return ucrednetNoProcess, ucrednetNobody, err
 	}
 
-	return uint32(uid), nil
+	return pid, uint32(v), nil
+}
+
+func ucrednetGetUID(remoteAddr string) (uint32, error) {
+	_, uid, err := ucrednetGet(remoteAddr)
+	return uid, err
 }
 
 type ucrednetAddr struct {
 	net.Addr
+	pid string
 	uid string
 }
 
 func (wa *ucrednetAddr) String() string {
-	return fmt.Sprintf("uid=%s;%s", wa.uid, wa.Addr)
+	return fmt.Sprintf("pid=%s;uid=%s;%s", wa.pid, wa.uid, wa.Addr)
 }
 
 type ucrednetConn struct {
 	net.Conn
+	pid string
 	uid string
 }
 
 func (wc *ucrednetConn) RemoteAddr() net.Addr {
-	return &ucrednetAddr{wc.Conn.RemoteAddr(), wc.uid}
+	return &ucrednetAddr{wc.Conn.RemoteAddr(), wc.pid, wc.uid}
 }
 
 type ucrednetListener struct{ net.Listener }
@@ -74,7 +103,7 @@ func (wl *ucrednetListener) Accept() (net.Conn, error) {
 		return nil, err
 	}
 
-	uid := ""
+	pid, uid := "", ""
 	if ucon, ok := con.(*net.UnixConn); ok {
 		f, err := ucon.File()
 		if err != nil {
@@ -88,8 +117,9 @@ func (wl *ucrednetListener) Accept() (net.Conn, error) {
 			return nil, err
 		}
 
+		pid = strconv.FormatUint(uint64(ucred.Pid), 10)
 		uid = strconv.FormatUint(uint64(ucred.Uid), 10)
 	}
 
-	return &ucrednetConn{con, uid}, err
+	return &ucrednetConn{con, pid, uid}, err
 }
@@ -52,7 +52,7 @@ func (s *ucrednetSuite) TearDownSuite(c *check.C) {
 }
 
 func (s *ucrednetSuite) TestAcceptConnRemoteAddrString(c *check.C) {
-	s.ucred = &sys.Ucred{Uid: 42}
+	s.ucred = &sys.Ucred{Pid: 100, Uid: 42}
 	d := c.MkDir()
 	sock := filepath.Join(d, "sock")
 
@@ -73,8 +73,9 @@ func (s *ucrednetSuite) TestAcceptConnRemoteAddrString(c *check.C) {
 	defer conn.Close()
 
 	remoteAddr := conn.RemoteAddr().String()
-	c.Check(remoteAddr, check.Matches, "uid=42;.*")
-	uid, err := ucrednetGetUID(remoteAddr)
+	c.Check(remoteAddr, check.Matches, "pid=100;uid=42;.*")
+	pid, uid, err := ucrednetGet(remoteAddr)
+	c.Check(pid, check.Equals, uint32(100))
 	c.Check(uid, check.Equals, uint32(42))
 	c.Check(err, check.IsNil)
 }
@@ -99,7 +100,7 @@ func (s *ucrednetSuite) TestNonUnix(c *check.C) {
 	defer conn.Close()
 
 	remoteAddr := conn.RemoteAddr().String()
-	c.Check(remoteAddr, check.Matches, "uid=;.*")
+	c.Check(remoteAddr, check.Matches, "pid=;uid=;.*")
 	uid, err := ucrednetGetUID(remoteAddr)
 	c.Check(uid, check.Equals, ucrednetNobody)
 	c.Check(err, check.Equals, errNoUID)
@@ -170,3 +171,28 @@ func (s *ucrednetSuite) TestGet(c *check.C) {
 	c.Check(err, check.IsNil)
 	c.Check(uid, check.Equals, uint32(42))
 }
+
+func (s *ucrednetSuite) TestGetWithPid(c *check.C) {
+	pid, uid, err := ucrednetGet("pid=100;uid=42;")
+	c.Check(err, check.IsNil)
+	c.Check(pid, check.Equals, uint32(100))
+	c.Check(uid, check.Equals, uint32(42))
+
+	// no pid is fine
+	pid, uid, err = ucrednetGet("uid=42;")
+	c.Check(err, check.IsNil)
+	c.Check(pid, check.Equals, ucrednetNoProcess)
+	c.Check(uid, check.Equals, uint32(42))
+}
+
+func (s *ucrednetSuite) TestGetBadPid(c *check.C) {
+	pid, uid, err := ucrednetGet("pid=hello;uid=42;")
+	c.Check(err, check.NotNil)
+	c.Check(pid, check.Equals, ucrednetNoProcess)
+	c.Check(uid, check.Equals, ucrednetNobody)
+
+	pid, uid, err = ucrednetGet("pid=100")
+	c.Check(err, check.Equals, errNoUID)
+	c.Check(pid, check.Equals, ucrednetNoProcess)
+	c.Check(uid, check.Equals, ucrednetNobody)
+}
@@ -49,8 +49,10 @@ var (
 	SnapSocket                string
 	SnapRunNsDir              string
 
-	SnapSeedDir   string
-	SnapDeviceDir string
+	SnapSeedDir         string
+	SnapDeviceDir       string
+	SnapshotsDir        string
+	SnapGadgetBackupDir string
 
 	SnapAssertsDBDir      string
 	SnapTrustedAccountKey string
@@ -138,6 +140,8 @@ func SetRootDir(rootdir string) {
 
 	SnapSeedDir = filepath.Join(rootdir, snappyDir, "seed")
 	SnapDeviceDir = filepath.Join(rootdir, snappyDir, "device")
+	SnapshotsDir = filepath.Join(rootdir, snappyDir, "snapshots")
+	SnapGadgetBackupDir = filepath.Join(rootdir, snappyDir, "gadget-backup")
 
 	SnapBinariesDir = filepath.Join(SnapMountDir, "bin")
 	SnapServicesDir = filepath.Join(rootdir, "/etc/systemd/system")
@@ -33,8 +33,17 @@ var (
 	DownloadUnpackGadget = downloadUnpackGadget
 	BootstrapToRootDir   = bootstrapToRootDir
 	InstallCloudConfig   = installCloudConfig
+	WriteImageFile       = writeImageFile
 )
 
+func MockRandRead(f func([]byte) (int, error)) (restore func()) {
+	old := randRead
+	randRead = f
+	return func() {
+		randRead = old
+	}
+}
+
 func (tsto *ToolingStore) User() *auth.UserState {
 	return tsto.user
 }
@@ -41,7 +41,7 @@ import (
 // A Store can find metadata on snaps, download snaps and fetch assertions.
 type Store interface {
 	SnapInfo(spec store.SnapSpec, user *auth.UserState) (*snap.Info, error)
-	Download(ctx context.Context, name, targetFn string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState) error
+	Download(ctx context.Context, name, targetFn string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState, dlOpts *store.DownloadOptions) error
 
 	Assertion(assertType *asserts.AssertionType, primaryKey []string, user *auth.UserState) (asserts.Assertion, error)
 }
@@ -143,7 +143,7 @@ func (tsto *ToolingStore) DownloadSnap(name string, revision snap.Revision, opts
 	targetFn = filepath.Join(targetDir, baseName)
 
 	pb := progress.NewTextProgress()
-	if err = sto.Download(context.TODO(), name, targetFn, &snap.DownloadInfo, pb, tsto.user); err != nil {
+	if err = sto.Download(context.TODO(), name, targetFn, &snap.DownloadInfo, pb, tsto.user, nil); err != nil {
 		return "", nil, err
 	}
 
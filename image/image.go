@@ -51,6 +51,9 @@ type Options struct {
 	Channel         string
 	ModelFile       string
 	GadgetUnpackDir string
+	// ImageFile, if set, is where to write a disk image of the
+	// gadget volume, with RootDir in its writable structure.
+	ImageFile string
 }
 
 type localInfos struct {
@@ -139,7 +142,14 @@ func Prepare(opts *Options) error {
 		return err
 	}
 
-	return bootstrapToRootDir(tsto, model, opts, local)
+	if err := bootstrapToRootDir(tsto, model, opts, local); err != nil {
+		return err
+	}
+
+	if opts.ImageFile != "" {
+		return writeImageFile(opts.ImageFile, opts.GadgetUnpackDir, opts.RootDir)
+	}
+	return nil
 }
 
 // these are postponed, not implemented or abandoned, not finalized,
@@ -157,7 +157,7 @@ func (s *imageSuite) SnapInfo(spec store.SnapSpec, user *auth.UserState) (*snap.
 	return s.storeSnapInfo[spec.Name], nil
 }
 
-func (s *imageSuite) Download(ctx context.Context, name, targetFn string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState) error {
+func (s *imageSuite) Download(ctx context.Context, name, targetFn string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState, dlOpts *store.DownloadOptions) error {
 	return osutil.CopyFile(s.downloadedSnaps[name], targetFn, 0)
 }
 
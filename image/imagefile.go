@@ -0,0 +1,507 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package image
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/snapcore/snapd/snap"
+)
+
+// writableLabel is the label of the structure whose filesystem gets
+// the prepared root directory.
+const writableLabel = "writable"
+
+// randRead is used for disk signatures and GUIDs not set by the gadget.
+var randRead = rand.Read
+
+// writeImageFile writes a disk image of the volume of the gadget
+// unpacked in gadgetDir to fn: the partition table, the raw content
+// of the structures and their filesystems with the gadget content,
+// and rootDir in the filesystem of the writable structure.
+func writeImageFile(fn, gadgetDir, rootDir string) error {
+	gmeta, err := ioutil.ReadFile(filepath.Join(gadgetDir, "meta", "gadget.yaml"))
+	if err != nil {
+		return fmt.Errorf("cannot write image file: %v", err)
+	}
+	gi, err := snap.InfoFromGadgetYaml(gmeta, false)
+	if err != nil {
+		return fmt.Errorf("cannot write image file: %v", err)
+	}
+	if len(gi.Volumes) != 1 {
+		return fmt.Errorf("cannot write image file: gadget must define exactly one volume, not %d", len(gi.Volumes))
+	}
+	var name string
+	var vol snap.GadgetVolume
+	for n, v := range gi.Volumes {
+		name, vol = n, v
+	}
+	structures, err := snap.LayoutVolume(&vol)
+	if err != nil {
+		return fmt.Errorf("cannot write image file: invalid volume %q: %v", name, err)
+	}
+
+	if err := writeVolume(fn, &vol, structures, gadgetDir, rootDir); err != nil {
+		os.Remove(fn)
+		return fmt.Errorf("cannot write image file: %v", err)
+	}
+	return nil
+}
+
+func writeVolume(fn string, vol *snap.GadgetVolume, structures []snap.LaidOutStructure, gadgetDir, rootDir string) error {
+	var size uint64
+	for _, ls := range structures {
+		if end := ls.StartOffset + ls.Size; end > size {
+			size = end
+		}
+	}
+	size = (size + snap.SectorSize - 1) / snap.SectorSize * snap.SectorSize
+	if vol.VolumeSchema() == "gpt" {
+		size += snap.GPTTableSectors * snap.SectorSize
+	}
+
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(size)); err != nil {
+		return err
+	}
+
+	// where the raw content of each structure was written
+	contentStarts := make([][]uint64, len(structures))
+	for i := range structures {
+		ls := &structures[i]
+		if ls.HasFilesystem() {
+			err = writeFilesystem(f, ls, gadgetDir, rootDir)
+		} else {
+			contentStarts[i], err = writeRawContent(f, ls, gadgetDir)
+		}
+		if err != nil {
+			return fmt.Errorf("structure %s: %v", ls, err)
+		}
+	}
+
+	switch vol.VolumeSchema() {
+	case "mbr":
+		err = writeMBR(f, vol, structures)
+	case "gpt":
+		err = writeGPT(f, vol, structures, size)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeOffsets(f, structures, contentStarts, size); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func writeAt(f *os.File, r io.Reader, offset uint64) (int64, error) {
+	if _, err := f.Seek(int64(offset), os.SEEK_SET); err != nil {
+		return 0, err
+	}
+	return io.Copy(f, r)
+}
+
+// writeRawContent writes the raw content of the structure, returning
+// where each piece of content starts in the volume.
+func writeRawContent(f *os.File, ls *snap.LaidOutStructure, gadgetDir string) ([]uint64, error) {
+	starts := make([]uint64, len(ls.Content))
+	var next uint64
+	for i := range ls.Content {
+		lc := &ls.Content[i]
+		offset := next
+		if lc.StartOffset != nil {
+			offset = *lc.StartOffset
+		}
+		img, err := os.Open(filepath.Join(gadgetDir, lc.Image))
+		if err != nil {
+			return nil, err
+		}
+		st, err := img.Stat()
+		if err != nil {
+			img.Close()
+			return nil, err
+		}
+		imgSize := uint64(st.Size())
+		size := lc.Size
+		if size == 0 {
+			size = imgSize
+		}
+		if imgSize > size || offset+size > ls.Size {
+			img.Close()
+			return nil, fmt.Errorf("content %q does not fit in the structure", lc.Image)
+		}
+		starts[i] = ls.StartOffset + offset
+		_, err = writeAt(f, img, starts[i])
+		img.Close()
+		if err != nil {
+			return nil, err
+		}
+		next = offset + size
+	}
+	return starts, nil
+}
+
+func writeFilesystem(f *os.File, ls *snap.LaidOutStructure, gadgetDir, rootDir string) error {
+	tmpdir, err := ioutil.TempDir("", "snap-image-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	contentDir := filepath.Join(tmpdir, "content")
+	if err := os.Mkdir(contentDir, 0755); err != nil {
+		return err
+	}
+	// ls.Content is only the raw content, the gadget content to put
+	// in the filesystem is in the structure itself
+	content := ls.VolumeStructure.Content
+	if ls.Label == writableLabel {
+		if len(content) == 0 {
+			contentDir = rootDir
+		} else if err := runCommand("cp", "-aT", rootDir, contentDir); err != nil {
+			return err
+		}
+	}
+	for _, vc := range content {
+		src := filepath.Join(gadgetDir, vc.Source)
+		dst := filepath.Join(contentDir, vc.Target)
+		if strings.HasSuffix(vc.Target, "/") && !strings.HasSuffix(vc.Source, "/") {
+			dst = filepath.Join(dst, filepath.Base(src))
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := runCommand("cp", "-aT", src, dst); err != nil {
+			return err
+		}
+	}
+
+	fsImage := filepath.Join(tmpdir, "fs.img")
+	if err := makeFilesystem(ls, contentDir, fsImage); err != nil {
+		return err
+	}
+	img, err := os.Open(fsImage)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+	n, err := writeAt(f, img, ls.StartOffset)
+	if err != nil {
+		return err
+	}
+	if uint64(n) > ls.Size {
+		return fmt.Errorf("filesystem is larger than the structure")
+	}
+	return nil
+}
+
+// makeFilesystem creates a filesystem of the size of the structure in
+// fsImage, with the content of contentDir.
+func makeFilesystem(ls *snap.LaidOutStructure, contentDir, fsImage string) error {
+	f, err := os.Create(fsImage)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(int64(ls.Size))
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	switch ls.Filesystem {
+	case "ext4":
+		cmd := []string{"mkfs.ext4", "-q", "-F"}
+		if ls.Label != "" {
+			cmd = append(cmd, "-L", ls.Label)
+		}
+		return runCommand(append(cmd, "-d", contentDir, fsImage)...)
+	case "vfat":
+		cmd := []string{"mkfs.vfat"}
+		if ls.Label != "" {
+			cmd = append(cmd, "-n", strings.ToUpper(ls.Label))
+		}
+		if err := runCommand(append(cmd, fsImage)...); err != nil {
+			return err
+		}
+		entries, err := ioutil.ReadDir(contentDir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := runCommand("mcopy", "-s", "-i", fsImage, filepath.Join(contentDir, entry.Name()), "::"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported filesystem %q", ls.Filesystem)
+}
+
+func sectors(n uint64) (uint32, error) {
+	n = (n + snap.SectorSize - 1) / snap.SectorSize
+	if n > 0xffffffff {
+		return 0, fmt.Errorf("%d sectors do not fit in 32 bits", n)
+	}
+	return uint32(n), nil
+}
+
+const (
+	mbrDiskSignatureOffset = snap.MBRBootCodeSize
+	mbrTableOffset         = 446
+	mbrSignatureOffset     = 510
+)
+
+// mbrEntry returns a partition table entry of an MBR, with the CHS
+// addresses set to the values meaning that LBA is to be used.
+func mbrEntry(typ byte, start, size uint32) []byte {
+	entry := make([]byte, 16)
+	copy(entry[1:4], []byte{0xfe, 0xff, 0xff})
+	entry[4] = typ
+	copy(entry[5:8], []byte{0xfe, 0xff, 0xff})
+	binary.LittleEndian.PutUint32(entry[8:], start)
+	binary.LittleEndian.PutUint32(entry[12:], size)
+	return entry
+}
+
+func writeMBR(f *os.File, vol *snap.GadgetVolume, structures []snap.LaidOutStructure) error {
+	var table bytes.Buffer
+
+	signature := make([]byte, 4)
+	if vol.ID != "" {
+		id, err := strconv.ParseUint(strings.TrimPrefix(vol.ID, "0x"), 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid volume id %q: need a 32 bit hex disk signature for the mbr schema", vol.ID)
+		}
+		binary.LittleEndian.PutUint32(signature, uint32(id))
+	} else if _, err := randRead(signature); err != nil {
+		return err
+	}
+	table.Write(signature)
+	table.Write([]byte{0, 0})
+
+	for _, ls := range structures {
+		if !ls.IsPartition() {
+			continue
+		}
+		typ, _, err := snap.PartitionTypes(ls.Type)
+		if err != nil {
+			return err
+		}
+		start, err := sectors(ls.StartOffset)
+		if err != nil {
+			return err
+		}
+		size, err := sectors(ls.Size)
+		if err != nil {
+			return err
+		}
+		table.Write(mbrEntry(typ, start, size))
+	}
+	table.Write(make([]byte, mbrSignatureOffset-mbrDiskSignatureOffset-table.Len()))
+	table.Write([]byte{0x55, 0xaa})
+
+	_, err := writeAt(f, &table, mbrDiskSignatureOffset)
+	return err
+}
+
+const (
+	gptHeaderSize  = 92
+	gptEntrySize   = 128
+	gptEntries     = 128
+	gptNameSize    = 72
+	gptProtectType = 0xee
+)
+
+// encodeGUID returns the on disk encoding of a GUID, with its first
+// three fields little-endian.
+func encodeGUID(guid string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.Replace(guid, "-", "", -1))
+	if err != nil || len(b) != 16 || strings.Count(guid, "-") != 4 {
+		return nil, fmt.Errorf("invalid GUID %q", guid)
+	}
+	b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	b[4], b[5] = b[5], b[4]
+	b[6], b[7] = b[7], b[6]
+	return b, nil
+}
+
+// guidOrRandom encodes guid, or a random (version 4) GUID if it is
+// empty.
+func guidOrRandom(guid string) ([]byte, error) {
+	if guid != "" {
+		return encodeGUID(guid)
+	}
+	b := make([]byte, 16)
+	if _, err := randRead(b); err != nil {
+		return nil, err
+	}
+	// bytes 6 and 7 are the little-endian third field
+	b[7] = b[7]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return b, nil
+}
+
+func gptHeader(diskGUID []byte, current, backup, lastUsable, entriesLBA uint64, entriesCRC uint32) []byte {
+	header := make([]byte, snap.SectorSize)
+	copy(header, "EFI PART")
+	binary.LittleEndian.PutUint32(header[8:], 0x00010000)
+	binary.LittleEndian.PutUint32(header[12:], gptHeaderSize)
+	binary.LittleEndian.PutUint64(header[24:], current)
+	binary.LittleEndian.PutUint64(header[32:], backup)
+	binary.LittleEndian.PutUint64(header[40:], 1+1+snap.GPTTableSectors-1)
+	binary.LittleEndian.PutUint64(header[48:], lastUsable)
+	copy(header[56:72], diskGUID)
+	binary.LittleEndian.PutUint64(header[72:], entriesLBA)
+	binary.LittleEndian.PutUint32(header[80:], gptEntries)
+	binary.LittleEndian.PutUint32(header[84:], gptEntrySize)
+	binary.LittleEndian.PutUint32(header[88:], entriesCRC)
+	binary.LittleEndian.PutUint32(header[16:], crc32.ChecksumIEEE(header[:gptHeaderSize]))
+	return header
+}
+
+func writeGPT(f *os.File, vol *snap.GadgetVolume, structures []snap.LaidOutStructure, size uint64) error {
+	totalSectors := size / snap.SectorSize
+	lastLBA := totalSectors - 1
+	backupEntriesLBA := totalSectors - snap.GPTTableSectors
+	lastUsable := backupEntriesLBA - 1
+
+	diskGUID, err := guidOrRandom(vol.ID)
+	if err != nil {
+		return fmt.Errorf("invalid volume id: %v", err)
+	}
+
+	entries := make([]byte, gptEntries*gptEntrySize)
+	n := 0
+	for _, ls := range structures {
+		if !ls.IsPartition() {
+			continue
+		}
+		_, typ, err := snap.PartitionTypes(ls.Type)
+		if err != nil {
+			return err
+		}
+		typeGUID, err := encodeGUID(typ)
+		if err != nil {
+			return err
+		}
+		uniqueGUID, err := guidOrRandom(ls.ID)
+		if err != nil {
+			return fmt.Errorf("invalid id of structure %s: %v", &ls, err)
+		}
+		name := utf16.Encode([]rune(ls.Label))
+		if len(name)*2 > gptNameSize {
+			return fmt.Errorf("label of structure %s is too long for a GPT partition name", &ls)
+		}
+
+		entry := entries[n*gptEntrySize : (n+1)*gptEntrySize]
+		copy(entry[0:16], typeGUID)
+		copy(entry[16:32], uniqueGUID)
+		binary.LittleEndian.PutUint64(entry[32:], ls.StartOffset/snap.SectorSize)
+		binary.LittleEndian.PutUint64(entry[40:], (ls.StartOffset+ls.Size+snap.SectorSize-1)/snap.SectorSize-1)
+		for i, c := range name {
+			binary.LittleEndian.PutUint16(entry[56+2*i:], c)
+		}
+		n++
+	}
+	entriesCRC := crc32.ChecksumIEEE(entries)
+
+	protective := make([]byte, mbrSignatureOffset-mbrTableOffset+2)
+	protectiveSize := uint32(0xffffffff)
+	if lastLBA < uint64(protectiveSize) {
+		protectiveSize = uint32(lastLBA)
+	}
+	entry := mbrEntry(gptProtectType, 1, protectiveSize)
+	copy(entry[1:4], []byte{0x00, 0x02, 0x00})
+	copy(protective, entry)
+	copy(protective[len(protective)-2:], []byte{0x55, 0xaa})
+
+	for _, w := range []struct {
+		data   []byte
+		offset uint64
+	}{
+		{protective, mbrTableOffset},
+		{gptHeader(diskGUID, 1, lastLBA, lastUsable, 2, entriesCRC), 1 * snap.SectorSize},
+		{entries, 2 * snap.SectorSize},
+		{entries, backupEntriesLBA * snap.SectorSize},
+		{gptHeader(diskGUID, lastLBA, 1, lastUsable, backupEntriesLBA, entriesCRC), lastLBA * snap.SectorSize},
+	} {
+		if _, err := writeAt(f, bytes.NewReader(w.data), w.offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOffsets writes the offset-write values of the structures and
+// of their content: where they start, in sectors.
+func writeOffsets(f *os.File, structures []snap.LaidOutStructure, contentStarts [][]uint64, size uint64) error {
+	starts := make(map[string]uint64)
+	for _, ls := range structures {
+		if ls.Name != "" {
+			starts[ls.Name] = ls.StartOffset
+		}
+	}
+	write := func(ow *snap.GadgetOffsetWrite, start uint64) error {
+		if ow == nil {
+			return nil
+		}
+		offset := starts[ow.RelativeTo] + ow.Offset
+		if offset+4 > size {
+			return fmt.Errorf("offset-write at %d is outside of the volume", offset)
+		}
+		value, err := sectors(start)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, value)
+		_, err = writeAt(f, bytes.NewReader(b), offset)
+		return err
+	}
+
+	for i, ls := range structures {
+		if err := write(ls.OffsetWrite, ls.StartOffset); err != nil {
+			return err
+		}
+		for j, lc := range ls.Content {
+			if err := write(lc.OffsetWrite, contentStarts[i][j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package image_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/image"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type imageFileSuite struct {
+	gadgetDir string
+	rootDir   string
+	imageFile string
+
+	mockMkfs *testutil.MockCmd
+	restore  func()
+}
+
+var _ = Suite(&imageFileSuite{})
+
+// the mocked mkfs commands write a marker at the start of the
+// filesystem image they are given as last argument
+const mockMkfsScript = `for a; do last="$a"; done; printf "%s" "$(basename "$0")" | dd of="$last" conv=notrunc status=none`
+
+func (s *imageFileSuite) SetUpTest(c *C) {
+	s.gadgetDir = c.MkDir()
+	s.rootDir = c.MkDir()
+	s.imageFile = filepath.Join(c.MkDir(), "disk.img")
+
+	s.mockMkfs = testutil.MockCommand(c, "mkfs.ext4", mockMkfsScript).Also("mkfs.vfat", mockMkfsScript).Also("mcopy", "")
+	s.restore = image.MockRandRead(func(b []byte) (int, error) {
+		for i := range b {
+			b[i] = 0x42
+		}
+		return len(b), nil
+	})
+
+	c.Assert(os.MkdirAll(filepath.Join(s.gadgetDir, "meta"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.gadgetDir, "pc-boot.img"), bytes.Repeat([]byte{'b'}, 440), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.gadgetDir, "pc-core.img"), []byte("core image"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.gadgetDir, "grubx64.efi"), []byte("grub"), 0644), IsNil)
+}
+
+func (s *imageFileSuite) TearDownTest(c *C) {
+	s.restore()
+	s.mockMkfs.Restore()
+}
+
+func (s *imageFileSuite) writeGadgetYaml(c *C, gadgetYaml string) {
+	c.Assert(ioutil.WriteFile(filepath.Join(s.gadgetDir, "meta", "gadget.yaml"), []byte(gadgetYaml), 0644), IsNil)
+}
+
+func (s *imageFileSuite) readAt(c *C, offset, size int64) []byte {
+	f, err := os.Open(s.imageFile)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	b := make([]byte, size)
+	_, err = f.ReadAt(b, offset)
+	c.Assert(err, IsNil)
+	return b
+}
+
+const gptGadgetYaml = `
+volumes:
+  pc:
+    bootloader: grub
+    id: 00112233-4455-6677-8899-aabbccddeeff
+    structure:
+      - name: mbr
+        type: mbr
+        size: 440
+        content:
+          - image: pc-boot.img
+      - name: BIOS Boot
+        type: 21686148-6449-6E6F-744E-656564454649
+        size: 1M
+        offset: 1M
+        offset-write: 92
+        content:
+          - image: pc-core.img
+      - label: system-boot
+        type: C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+        filesystem: vfat
+        size: 50M
+        content:
+          - source: grubx64.efi
+            target: EFI/boot/grubx64.efi
+      - label: writable
+        type: 0FC63DAF-8483-4772-8E79-3D69D8477DE4
+        filesystem: ext4
+        size: 10M
+`
+
+func (s *imageFileSuite) TestWriteImageFileGPT(c *C) {
+	s.writeGadgetYaml(c, gptGadgetYaml)
+
+	err := image.WriteImageFile(s.imageFile, s.gadgetDir, s.rootDir)
+	c.Assert(err, IsNil)
+
+	// 1M + 1M + 50M + 10M, and the backup GPT
+	const size = 62<<20 + 33*512
+	st, err := os.Stat(s.imageFile)
+	c.Assert(err, IsNil)
+	c.Check(st.Size(), Equals, int64(size))
+
+	// the boot code, with the start of the BIOS Boot partition
+	// written at 92
+	bootCode := bytes.Repeat([]byte{'b'}, 440)
+	binary.LittleEndian.PutUint32(bootCode[92:], 2048)
+	c.Check(s.readAt(c, 0, 440), DeepEquals, bootCode)
+
+	// the protective MBR
+	mbr := s.readAt(c, 446, 66)
+	c.Check(mbr[4], Equals, byte(0xee))
+	c.Check(binary.LittleEndian.Uint32(mbr[8:]), Equals, uint32(1))
+	c.Check(binary.LittleEndian.Uint32(mbr[12:]), Equals, uint32(size/512-1))
+	c.Check(mbr[64:], DeepEquals, []byte{0x55, 0xaa})
+
+	// the primary and the backup GPT
+	lastLBA := uint64(size/512 - 1)
+	for _, t := range []struct {
+		headerLBA, backupLBA, entriesLBA uint64
+	}{
+		{1, lastLBA, 2},
+		{lastLBA, 1, lastLBA - 32},
+	} {
+		header := s.readAt(c, int64(t.headerLBA*512), 92)
+		c.Check(string(header[:8]), Equals, "EFI PART")
+		crc := binary.LittleEndian.Uint32(header[16:])
+		binary.LittleEndian.PutUint32(header[16:], 0)
+		c.Check(crc32.ChecksumIEEE(header), Equals, crc)
+		c.Check(binary.LittleEndian.Uint64(header[24:]), Equals, t.headerLBA)
+		c.Check(binary.LittleEndian.Uint64(header[32:]), Equals, t.backupLBA)
+		c.Check(binary.LittleEndian.Uint64(header[40:]), Equals, uint64(34))
+		c.Check(binary.LittleEndian.Uint64(header[48:]), Equals, lastLBA-33)
+		c.Check(header[56:72], DeepEquals, []byte{
+			0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66,
+			0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+		})
+		c.Check(binary.LittleEndian.Uint64(header[72:]), Equals, t.entriesLBA)
+
+		entries := s.readAt(c, int64(t.entriesLBA*512), 128*128)
+		c.Check(crc32.ChecksumIEEE(entries), Equals, binary.LittleEndian.Uint32(header[88:]))
+
+		// BIOS Boot, unnamed and with a random unique GUID
+		c.Check(entries[0:16], DeepEquals, []byte{
+			0x48, 0x61, 0x68, 0x21, 0x49, 0x64, 0x6f, 0x6e,
+			0x74, 0x4e, 0x65, 0x65, 0x64, 0x45, 0x46, 0x49,
+		})
+		c.Check(entries[16:32], DeepEquals, []byte{
+			0x42, 0x42, 0x42, 0x42, 0x42, 0x42, 0x42, 0x42&0x0f | 0x40,
+			0x42&0x3f | 0x80, 0x42, 0x42, 0x42, 0x42, 0x42, 0x42, 0x42,
+		})
+		c.Check(binary.LittleEndian.Uint64(entries[32:]), Equals, uint64(2048))
+		c.Check(binary.LittleEndian.Uint64(entries[40:]), Equals, uint64(4095))
+		// system-boot
+		entry := entries[128:256]
+		c.Check(binary.LittleEndian.Uint64(entry[32:]), Equals, uint64(4096))
+		c.Check(binary.LittleEndian.Uint64(entry[40:]), Equals, uint64(106495))
+		c.Check(entry[56:78], DeepEquals, []byte("s\x00y\x00s\x00t\x00e\x00m\x00-\x00b\x00o\x00o\x00t\x00"))
+		// writable, and nothing else
+		c.Check(binary.LittleEndian.Uint64(entries[256+32:]), Equals, uint64(106496))
+		c.Check(entries[384:], DeepEquals, make([]byte, 128*125))
+	}
+
+	// the raw content and the filesystems
+	c.Check(s.readAt(c, 1<<20, 10), DeepEquals, []byte("core image"))
+	c.Check(s.readAt(c, 2<<20, 9), DeepEquals, []byte("mkfs.vfat"))
+	c.Check(s.readAt(c, 52<<20, 9), DeepEquals, []byte("mkfs.ext4"))
+
+	calls := s.mockMkfs.Calls()
+	c.Assert(calls, HasLen, 3)
+	c.Check(calls[0][:3], DeepEquals, []string{"mkfs.vfat", "-n", "SYSTEM-BOOT"})
+	c.Check(calls[1][:4], DeepEquals, []string{"mcopy", "-s", "-i", calls[0][3]})
+	c.Check(filepath.Base(calls[1][4]), Equals, "EFI")
+	c.Check(calls[1][5], Equals, "::")
+	c.Check(calls[2], DeepEquals, []string{"mkfs.ext4", "-q", "-F", "-L", "writable", "-d", s.rootDir, calls[2][7]})
+}
+
+const mbrGadgetYaml = `
+volumes:
+  pc:
+    schema: mbr
+    bootloader: u-boot
+    id: 0x12345678
+    structure:
+      - type: mbr
+        size: 440
+        content:
+          - image: pc-boot.img
+      - type: bare
+        size: 1M
+        offset: 512
+        content:
+          - image: pc-core.img
+            offset: 1024
+            offset-write: 400
+      - label: system-boot
+        type: 0C
+        filesystem: vfat
+        size: 4M
+      - label: writable
+        type: 83
+        filesystem: ext4
+        size: 4M
+`
+
+func (s *imageFileSuite) TestWriteImageFileMBR(c *C) {
+	s.writeGadgetYaml(c, mbrGadgetYaml)
+
+	err := image.WriteImageFile(s.imageFile, s.gadgetDir, s.rootDir)
+	c.Assert(err, IsNil)
+
+	st, err := os.Stat(s.imageFile)
+	c.Assert(err, IsNil)
+	c.Check(st.Size(), Equals, int64(512+1<<20+8<<20))
+
+	bootCode := bytes.Repeat([]byte{'b'}, 440)
+	// the pc-core.img content starts at 512+1024, in sector 3
+	binary.LittleEndian.PutUint32(bootCode[400:], 3)
+	c.Check(s.readAt(c, 0, 440), DeepEquals, bootCode)
+
+	table := s.readAt(c, 440, 72)
+	c.Check(table[:4], DeepEquals, []byte{0x78, 0x56, 0x34, 0x12})
+	for i, t := range []struct {
+		typ         byte
+		start, size uint32
+	}{
+		{0x0c, 2049, 8192},
+		{0x83, 10241, 8192},
+	} {
+		entry := table[6+16*i : 6+16*(i+1)]
+		c.Check(entry[4], Equals, t.typ)
+		c.Check(binary.LittleEndian.Uint32(entry[8:]), Equals, t.start)
+		c.Check(binary.LittleEndian.Uint32(entry[12:]), Equals, t.size)
+	}
+	c.Check(table[6+32:70], DeepEquals, make([]byte, 32))
+	c.Check(table[70:], DeepEquals, []byte{0x55, 0xaa})
+
+	c.Check(s.readAt(c, 512+1024, 10), DeepEquals, []byte("core image"))
+}
+
+func (s *imageFileSuite) TestWriteImageFileErrors(c *C) {
+	err := image.WriteImageFile(s.imageFile, s.gadgetDir, s.rootDir)
+	c.Check(err, ErrorMatches, `cannot write image file: open .*/meta/gadget.yaml: no such file or directory`)
+
+	s.writeGadgetYaml(c, `
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - type: bare
+        size: 1M
+        offset: 2M
+      - type: bare
+        size: 1M
+        offset: 2M
+`)
+	err = image.WriteImageFile(s.imageFile, s.gadgetDir, s.rootDir)
+	c.Check(err, ErrorMatches, `cannot write image file: invalid volume "pc": invalid structure #1: overlaps with structure #0`)
+
+	s.writeGadgetYaml(c, `
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - type: bare
+        size: 440
+        content:
+          - image: pc-boot.img
+            offset: 1
+`)
+	err = image.WriteImageFile(s.imageFile, s.gadgetDir, s.rootDir)
+	c.Check(err, ErrorMatches, `cannot write image file: structure #0: content "pc-boot.img" does not fit in the structure`)
+	c.Check(osutil.FileExists(s.imageFile), Equals, false)
+
+	s.writeGadgetYaml(c, gptGadgetYaml)
+	s.mockMkfs.Restore()
+	s.mockMkfs = testutil.MockCommand(c, "mkfs.vfat", "echo failed; exit 1")
+	err = image.WriteImageFile(s.imageFile, s.gadgetDir, s.rootDir)
+	c.Check(err, ErrorMatches, `cannot write image file: structure #2 \("system-boot"\): cannot run \[mkfs.vfat .*\]: failed`)
+}
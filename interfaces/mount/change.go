@@ -20,9 +20,11 @@
 package mount
 
 import (
+	"fmt"
 	"path"
 	"sort"
 	"strings"
+	"syscall"
 )
 
 // Action represents a mount action (mount, remount, unmount, etc).
@@ -40,6 +42,47 @@ type Change struct {
 	Action Action
 }
 
+// String formats mount change to a human-readable line.
+func (c Change) String() string {
+	return fmt.Sprintf("%s (%s)", c.Action, c.Entry)
+}
+
+// Reverse returns the change that undoes the given change.
+func (c Change) Reverse() Change {
+	switch c.Action {
+	case Mount:
+		return Change{Action: Unmount, Entry: c.Entry}
+	case Unmount:
+		return Change{Action: Mount, Entry: c.Entry}
+	}
+	return c
+}
+
+var (
+	sysMount   = syscall.Mount
+	sysUnmount = syscall.Unmount
+)
+
+// umountNoFollow is UMOUNT_NOFOLLOW, missing from the syscall package.
+const umountNoFollow = 8
+
+// Perform executes the desired mount or unmount change using system calls.
+//
+// Mount points are not created, they must already exist.
+func (c *Change) Perform() error {
+	switch c.Action {
+	case Mount:
+		flags, err := c.Entry.mountFlags()
+		if err != nil {
+			return err
+		}
+		return sysMount(c.Entry.Name, c.Entry.Dir, c.Entry.Type, flags, "")
+	case Unmount:
+		return sysUnmount(c.Entry.Dir, umountNoFollow)
+	}
+	return fmt.Errorf("cannot process mount change, unknown action: %q", c.Action)
+}
+
 // NeededChanges computes the changes required to change current to desired mount entries.
 //
 // The current and desired profiles is a fstab like list of mount entries. The
@@ -20,6 +20,9 @@
 package mount_test
 
 import (
+	"fmt"
+	"syscall"
+
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/interfaces/mount"
@@ -158,3 +161,57 @@ func (s *changeSuite) TestNeededChangesSmartEntryComparison(c *C) {
 		{Entry: mount.Entry{Dir: "/a/b/c"}, Action: mount.Mount},
 	})
 }
+
+// Change.Perform calls the mount system call for mount changes.
+func (s *changeSuite) TestPerformMount(c *C) {
+	var calls []string
+	restore := mount.MockSystemCalls(func(source, target, fstype string, flags uintptr, data string) error {
+		calls = append(calls, fmt.Sprintf("mount %s %s %s %#x", source, target, fstype, flags))
+		return nil
+	}, nil)
+	defer restore()
+
+	chg := &mount.Change{Action: mount.Mount, Entry: mount.Entry{Name: "/source", Dir: "/target", Type: "none", Options: []string{"bind"}}}
+	c.Assert(chg.Perform(), IsNil)
+	chg = &mount.Change{Action: mount.Mount, Entry: mount.Entry{Name: "/source", Dir: "/target", Type: "none", Options: []string{"bind", "rw"}}}
+	c.Assert(chg.Perform(), IsNil)
+	c.Check(calls, DeepEquals, []string{
+		fmt.Sprintf("mount /source /target none %#x", syscall.MS_BIND|syscall.MS_RDONLY|syscall.MS_NODEV|syscall.MS_NOSUID),
+		fmt.Sprintf("mount /source /target none %#x", syscall.MS_BIND|syscall.MS_NODEV|syscall.MS_NOSUID),
+	})
+}
+
+// Change.Perform refuses to perform mounts that snap-confine would not honor.
+func (s *changeSuite) TestPerformMountUnsupported(c *C) {
+	restore := mount.MockSystemCalls(func(source, target, fstype string, flags uintptr, data string) error {
+		c.Fatalf("unexpected call")
+		return nil
+	}, nil)
+	defer restore()
+
+	chg := &mount.Change{Action: mount.Mount, Entry: mount.Entry{Name: "/dev/sda1", Dir: "/target", Type: "ext4", Options: []string{"bind"}}}
+	c.Check(chg.Perform(), ErrorMatches, "cannot honor mount profile, only 'none' filesystem type is supported")
+	chg = &mount.Change{Action: mount.Mount, Entry: mount.Entry{Name: "/source", Dir: "/target", Type: "none", Options: []string{"rw"}}}
+	c.Check(chg.Perform(), ErrorMatches, "cannot honor mount profile, the bind mount flag is mandatory")
+}
+
+// Change.Perform calls the unmount system call for unmount changes.
+func (s *changeSuite) TestPerformUnmount(c *C) {
+	var calls []string
+	restore := mount.MockSystemCalls(nil, func(target string, flags int) error {
+		calls = append(calls, fmt.Sprintf("unmount %s %d", target, flags))
+		return nil
+	})
+	defer restore()
+
+	chg := &mount.Change{Action: mount.Unmount, Entry: mount.Entry{Name: "/source", Dir: "/target", Type: "none", Options: []string{"bind"}}}
+	c.Assert(chg.Perform(), IsNil)
+	c.Check(calls, DeepEquals, []string{"unmount /target 8"})
+}
+
+// Change.Reverse swaps mounts and unmounts.
+func (s *changeSuite) TestReverse(c *C) {
+	entry := mount.Entry{Name: "/source", Dir: "/target"}
+	c.Check(mount.Change{Action: mount.Mount, Entry: entry}.Reverse(), DeepEquals, mount.Change{Action: mount.Unmount, Entry: entry})
+	c.Check(mount.Change{Action: mount.Unmount, Entry: entry}.Reverse(), DeepEquals, mount.Change{Action: mount.Mount, Entry: entry})
+}
@@ -25,6 +25,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 // Entry describes an /etc/fstab-like mount entry.
@@ -68,6 +69,31 @@ func (a *Entry) Equal(b *Entry) bool {
 		a.CheckPassNumber == b.CheckPassNumber)
 }
 
+// mountFlags returns the mount flags corresponding to the entry.
+//
+// The semantics match those of snap-confine: only bind mounts of the "none"
+// filesystem type are supported and they are read-only, nodev and nosuid
+// unless the "rw" option is used.
+func (e *Entry) mountFlags() (uintptr, error) {
+	if e.Type != "none" {
+		return 0, fmt.Errorf("cannot honor mount profile, only 'none' filesystem type is supported")
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_RDONLY | syscall.MS_NODEV | syscall.MS_NOSUID)
+	bind := false
+	for _, opt := range e.Options {
+		switch opt {
+		case "bind":
+			bind = true
+		case "rw":
+			flags &^= syscall.MS_RDONLY
+		}
+	}
+	if !bind {
+		return 0, fmt.Errorf("cannot honor mount profile, the bind mount flag is mandatory")
+	}
+	return flags, nil
+}
+
 // escape replaces whitespace characters so that getmntent can parse it correctly.
 var escape = strings.NewReplacer(
 	" ", `\040`,
@@ -168,3 +194,15 @@ func LoadFSTab(reader io.Reader) ([]Entry, error) {
 	}
 	return entries, nil
 }
+
+// SaveFSTab writes a list of entries to a fstab-like file.
+//
+// The supported format is described by fstab(5).
+func SaveFSTab(writer io.Writer, entries []Entry) error {
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(writer, "%s\n", entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -20,6 +20,7 @@
 package mount_test
 
 import (
+	"bytes"
 	"strings"
 
 	. "gopkg.in/check.v1"
@@ -177,3 +178,20 @@ func (s *entrySuite) TestLoadFSTab3(c *C) {
 		{"name-2", "dir-2", "type-2", []string{"options-2"}, 2, 2},
 	})
 }
+
+// Test that entries can be saved and loaded back.
+func (s *entrySuite) TestSaveFSTab(c *C) {
+	entries := []mount.Entry{
+		{"/snap/foo/1/dir", "/snap/bar/2/dir", "none", []string{"bind", "ro"}, 0, 0},
+		{"/var/snap/foo/common/a b", "/var/snap/bar/common/c", "none", []string{"bind"}, 0, 0},
+	}
+	var buf bytes.Buffer
+	c.Assert(mount.SaveFSTab(&buf, entries), IsNil)
+	c.Check(buf.String(), Equals, `/snap/foo/1/dir /snap/bar/2/dir none bind,ro 0 0
+/var/snap/foo/common/a\040b /var/snap/bar/common/c none bind 0 0
+`)
+
+	loaded, err := mount.LoadFSTab(&buf)
+	c.Assert(err, IsNil)
+	c.Check(loaded, DeepEquals, entries)
+}
@@ -21,21 +21,23 @@
 // ubuntu-core-launcher around seccomp.
 //
 // Snappy creates so-called seccomp profiles for each application (for each
-// snap) present in the system.  Upon each execution of ubuntu-core-launcher,
-// the profile is read and "compiled" to an eBPF program and injected into the
-// kernel for the duration of the execution of the process.
+// snap) present in the system. Each profile is written as a source file
+// (with the .src extension) and then compiled by snap-seccomp to a BPF
+// program (with the .bin extension). Upon each execution of snap-confine the
+// compiled program is loaded and injected into the kernel for the duration of
+// the execution of the process.
 //
-// There is no binary cache for seccomp, each time the launcher starts an
-// application the profile is parsed and re-compiled.
-//
-// The actual profiles are stored in /var/lib/snappy/seccomp/profiles.
-// This directory is hard-coded in ubuntu-core-launcher.
+// The actual profiles are stored in /var/lib/snapd/seccomp/profiles.
+// This directory is hard-coded in snap-confine.
 package seccomp
 
 import (
 	"bytes"
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/interfaces"
@@ -71,15 +73,83 @@ func (b *Backend) Setup(snapInfo *snap.Info, opts interfaces.ConfinementOptions,
 		return fmt.Errorf("cannot obtain expected security files for snap %q: %s", snapName, err)
 	}
 
-	glob := interfaces.SecurityTagGlob(snapName)
+	glob := interfaces.SecurityTagGlob(snapName) + srcExt
 	dir := dirs.SnapSeccompDir
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("cannot create directory for seccomp profiles %q: %s", dir, err)
 	}
-	_, _, err = osutil.EnsureDirState(dir, glob, content)
+	if err := removeLegacyProfiles(dir, snapName); err != nil {
+		return fmt.Errorf("cannot remove old seccomp profiles for snap %q: %s", snapName, err)
+	}
+	changed, removed, err := osutil.EnsureDirState(dir, glob, content)
 	if err != nil {
 		return fmt.Errorf("cannot synchronize security files for snap %q: %s", snapName, err)
 	}
+	for _, baseName := range removed {
+		bin := filepath.Join(dir, strings.TrimSuffix(baseName, srcExt)+binExt)
+		if err := os.Remove(bin); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove compiled seccomp profile %q: %s", bin, err)
+		}
+	}
+	// Compile the profiles that have changed as well as those that were
+	// not compiled before (e.g. written by an older snapd or where an earlier
+	// compilation has failed).
+	isChanged := make(map[string]bool, len(changed))
+	for _, baseName := range changed {
+		isChanged[baseName] = true
+	}
+	for baseName := range content {
+		bin := filepath.Join(dir, strings.TrimSuffix(baseName, srcExt)+binExt)
+		if !isChanged[baseName] && osutil.FileExists(bin) {
+			continue
+		}
+		if err := compileProfile(filepath.Join(dir, baseName), bin); err != nil {
+			return fmt.Errorf("cannot compile seccomp profile for snap %q: %s", snapName, err)
+		}
+	}
+	return nil
+}
+
+const (
+	srcExt = ".src"
+	binExt = ".bin"
+)
+
+// removeLegacyProfiles removes the profiles of the given snap that an
+// older snapd wrote without an extension, named after the security tag.
+func removeLegacyProfiles(dir, snapName string) error {
+	glob := interfaces.SecurityTagGlob(snapName)
+	matches, err := filepath.Glob(filepath.Join(dir, glob))
+	if err != nil {
+		return err
+	}
+	for _, path := range matches {
+		baseName := filepath.Base(path)
+		if ext := filepath.Ext(baseName); ext == srcExt || ext == binExt {
+			// a profile of the current layout, unless the tag
+			// itself ends like one (e.g. snap.foo.src)
+			if ok, _ := filepath.Match(glob, strings.TrimSuffix(baseName, ext)); ok {
+				continue
+			}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileProfile uses snap-seccomp to compile the given profile source to
+// the BPF program loaded by snap-confine.
+func compileProfile(src, bin string) error {
+	tool := filepath.Join(dirs.DistroLibExecDir, "snap-seccomp")
+	if !osutil.FileExists(tool) {
+		tool = "snap-seccomp"
+	}
+	output, err := exec.Command(tool, "compile", src, bin).CombinedOutput()
+	if err != nil {
+		return osutil.OutputErr(output, err)
+	}
 	return nil
 }
 
@@ -128,7 +198,7 @@ func addContent(securityTag string, opts interfaces.ConfinementOptions, snippetF
 	buffer.Write(defaultTemplate)
 	buffer.WriteString(snippetForTag)
 
-	content[securityTag] = &osutil.FileState{
+	content[securityTag+srcExt] = &osutil.FileState{
 		Content: buffer.Bytes(),
 		Mode:    0644,
 	}
@@ -30,12 +30,14 @@ import (
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/ifacetest"
 	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
 )
 
 type backendSuite struct {
 	ifacetest.BackendSuite
+	snapSeccomp *testutil.MockCmd
 }
 
 var _ = Suite(&backendSuite{})
@@ -56,9 +58,13 @@ func (s *backendSuite) SetUpTest(c *C) {
 	// NOTE: Normally this is a part of the OS snap.
 	err := os.MkdirAll(dirs.SnapSeccompDir, 0700)
 	c.Assert(err, IsNil)
+
+	// The fake compiler just copies the source profile.
+	s.snapSeccomp = testutil.MockCommand(c, "snap-seccomp", `cp "$2" "$3"`)
 }
 
 func (s *backendSuite) TearDownTest(c *C) {
+	s.snapSeccomp.Restore()
 	s.BackendSuite.TearDownTest(c)
 }
 
@@ -69,15 +75,36 @@ func (s *backendSuite) TestName(c *C) {
 
 func (s *backendSuite) TestInstallingSnapWritesProfiles(c *C) {
 	s.InstallSnap(c, interfaces.ConfinementOptions{}, ifacetest.SambaYamlV1, 0)
-	profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd")
+	profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.src")
 	// file called "snap.sambda.smbd" was created
 	_, err := os.Stat(profile)
 	c.Check(err, IsNil)
 }
 
+func (s *backendSuite) TestInstallingSnapRemovesLegacyProfiles(c *C) {
+	// profiles written by an older snapd, without an extension
+	for _, name := range []string{"snap.samba.smbd", "snap.samba.gone", "snap.samba.hook.configure", "snap.samba.src"} {
+		err := ioutil.WriteFile(filepath.Join(dirs.SnapSeccompDir, name), []byte("old"), 0644)
+		c.Assert(err, IsNil)
+	}
+	// and one of another snap
+	other := filepath.Join(dirs.SnapSeccompDir, "snap.sambaz.smbd")
+	c.Assert(ioutil.WriteFile(other, []byte("old"), 0644), IsNil)
+
+	s.InstallSnap(c, interfaces.ConfinementOptions{}, ifacetest.SambaYamlV1, 0)
+
+	names, err := filepath.Glob(filepath.Join(dirs.SnapSeccompDir, "snap.samba.*"))
+	c.Assert(err, IsNil)
+	c.Check(names, DeepEquals, []string{
+		filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.bin"),
+		filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.src"),
+	})
+	c.Check(osutil.FileExists(other), Equals, true)
+}
+
 func (s *backendSuite) TestInstallingSnapWritesHookProfiles(c *C) {
 	s.InstallSnap(c, interfaces.ConfinementOptions{}, ifacetest.HookYaml, 0)
-	profile := filepath.Join(dirs.SnapSeccompDir, "snap.foo.hook.configure")
+	profile := filepath.Join(dirs.SnapSeccompDir, "snap.foo.hook.configure.src")
 
 	// Verify that profile named "snap.foo.hook.configure" was created.
 	_, err := os.Stat(profile)
@@ -88,7 +115,7 @@ func (s *backendSuite) TestRemovingSnapRemovesProfiles(c *C) {
 	for _, opts := range testedConfinementOpts {
 		snapInfo := s.InstallSnap(c, opts, ifacetest.SambaYamlV1, 0)
 		s.RemoveSnap(c, snapInfo)
-		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd")
+		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.src")
 		// file called "snap.sambda.smbd" was removed
 		_, err := os.Stat(profile)
 		c.Check(os.IsNotExist(err), Equals, true)
@@ -99,7 +126,7 @@ func (s *backendSuite) TestRemovingSnapRemovesHookProfiles(c *C) {
 	for _, opts := range testedConfinementOpts {
 		snapInfo := s.InstallSnap(c, opts, ifacetest.HookYaml, 0)
 		s.RemoveSnap(c, snapInfo)
-		profile := filepath.Join(dirs.SnapSeccompDir, "snap.foo.hook.configure")
+		profile := filepath.Join(dirs.SnapSeccompDir, "snap.foo.hook.configure.src")
 
 		// Verify that profile "snap.foo.hook.configure" was removed.
 		_, err := os.Stat(profile)
@@ -111,7 +138,7 @@ func (s *backendSuite) TestUpdatingSnapToOneWithMoreApps(c *C) {
 	for _, opts := range testedConfinementOpts {
 		snapInfo := s.InstallSnap(c, opts, ifacetest.SambaYamlV1, 0)
 		snapInfo = s.UpdateSnap(c, snapInfo, opts, ifacetest.SambaYamlV1WithNmbd, 0)
-		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.nmbd")
+		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.nmbd.src")
 		_, err := os.Stat(profile)
 		// file called "snap.sambda.nmbd" was created
 		c.Check(err, IsNil)
@@ -123,7 +150,7 @@ func (s *backendSuite) TestUpdatingSnapToOneWithHooks(c *C) {
 	for _, opts := range testedConfinementOpts {
 		snapInfo := s.InstallSnap(c, opts, ifacetest.SambaYamlV1, 0)
 		snapInfo = s.UpdateSnap(c, snapInfo, opts, ifacetest.SambaYamlWithHook, 0)
-		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.hook.configure")
+		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.hook.configure.src")
 
 		_, err := os.Stat(profile)
 		// Verify that profile "snap.samba.hook.configure" was created.
@@ -136,7 +163,7 @@ func (s *backendSuite) TestUpdatingSnapToOneWithFewerApps(c *C) {
 	for _, opts := range testedConfinementOpts {
 		snapInfo := s.InstallSnap(c, opts, ifacetest.SambaYamlV1WithNmbd, 0)
 		snapInfo = s.UpdateSnap(c, snapInfo, opts, ifacetest.SambaYamlV1, 0)
-		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.nmbd")
+		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.nmbd.src")
 		// file called "snap.sambda.nmbd" was removed
 		_, err := os.Stat(profile)
 		c.Check(os.IsNotExist(err), Equals, true)
@@ -148,7 +175,7 @@ func (s *backendSuite) TestUpdatingSnapToOneWithNoHooks(c *C) {
 	for _, opts := range testedConfinementOpts {
 		snapInfo := s.InstallSnap(c, opts, ifacetest.SambaYamlWithHook, 0)
 		snapInfo = s.UpdateSnap(c, snapInfo, opts, ifacetest.SambaYamlV1, 0)
-		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.hook.configure")
+		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.hook.configure.src")
 
 		// Verify that profile snap.samba.hook.configure was removed.
 		_, err := os.Stat(profile)
@@ -157,12 +184,72 @@ func (s *backendSuite) TestUpdatingSnapToOneWithNoHooks(c *C) {
 	}
 }
 
+func (s *backendSuite) TestInstallingSnapCompilesProfiles(c *C) {
+	s.InstallSnap(c, interfaces.ConfinementOptions{}, ifacetest.SambaYamlV1, 0)
+	src := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.src")
+	bin := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.bin")
+	c.Check(s.snapSeccomp.Calls(), DeepEquals, [][]string{
+		{"snap-seccomp", "compile", src, bin},
+	})
+	_, err := os.Stat(bin)
+	c.Check(err, IsNil)
+}
+
+func (s *backendSuite) TestSetupRecompilesOnlyWhenNeeded(c *C) {
+	snapInfo := s.InstallSnap(c, interfaces.ConfinementOptions{}, ifacetest.SambaYamlV1, 0)
+	s.snapSeccomp.ForgetCalls()
+
+	// nothing changed, nothing is compiled
+	err := s.Backend.Setup(snapInfo, interfaces.ConfinementOptions{}, s.Repo)
+	c.Assert(err, IsNil)
+	c.Check(s.snapSeccomp.Calls(), HasLen, 0)
+
+	// a missing compiled profile is compiled again
+	src := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.src")
+	bin := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.bin")
+	c.Assert(os.Remove(bin), IsNil)
+	err = s.Backend.Setup(snapInfo, interfaces.ConfinementOptions{}, s.Repo)
+	c.Assert(err, IsNil)
+	c.Check(s.snapSeccomp.Calls(), DeepEquals, [][]string{
+		{"snap-seccomp", "compile", src, bin},
+	})
+}
+
+func (s *backendSuite) TestRemovingSnapRemovesCompiledProfiles(c *C) {
+	snapInfo := s.InstallSnap(c, interfaces.ConfinementOptions{}, ifacetest.SambaYamlV1WithNmbd, 0)
+	snapInfo = s.UpdateSnap(c, snapInfo, interfaces.ConfinementOptions{}, ifacetest.SambaYamlV1, 0)
+	_, err := os.Stat(filepath.Join(dirs.SnapSeccompDir, "snap.samba.nmbd.bin"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	s.RemoveSnap(c, snapInfo)
+	_, err = os.Stat(filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.bin"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *backendSuite) TestSetupCompileError(c *C) {
+	cmd := testutil.MockCommand(c, "snap-seccomp", "echo failed; exit 1")
+	defer cmd.Restore()
+
+	snapInfo := snaptest.MockInfo(c, ifacetest.SambaYamlV1, nil)
+	err := s.Backend.Setup(snapInfo, interfaces.ConfinementOptions{}, s.Repo)
+	c.Assert(err, ErrorMatches, `cannot compile seccomp profile for snap "samba": failed`)
+}
+
+func (s *backendSuite) TestSetupUsesLibExecDirCompiler(c *C) {
+	c.Assert(os.MkdirAll(dirs.DistroLibExecDir, 0755), IsNil)
+	cmd := testutil.MockCommand(c, filepath.Join(dirs.DistroLibExecDir, "snap-seccomp"), "")
+
+	s.InstallSnap(c, interfaces.ConfinementOptions{}, ifacetest.SambaYamlV1, 0)
+	c.Check(cmd.Calls(), HasLen, 1)
+	c.Check(s.snapSeccomp.Calls(), HasLen, 0)
+}
+
 func (s *backendSuite) TestRealDefaultTemplateIsNormallyUsed(c *C) {
 	snapInfo := snaptest.MockInfo(c, ifacetest.SambaYamlV1, nil)
 	// NOTE: we don't call seccomp.MockTemplate()
 	err := s.Backend.Setup(snapInfo, interfaces.ConfinementOptions{}, s.Repo)
 	c.Assert(err, IsNil)
-	profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd")
+	profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.src")
 	data, err := ioutil.ReadFile(profile)
 	c.Assert(err, IsNil)
 	for _, line := range []string{
@@ -219,7 +306,7 @@ func (s *backendSuite) TestCombineSnippets(c *C) {
 		}
 
 		snapInfo := s.InstallSnap(c, scenario.opts, ifacetest.SambaYamlV1, 0)
-		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd")
+		profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.src")
 		data, err := ioutil.ReadFile(profile)
 		c.Assert(err, IsNil)
 		c.Check(string(data), Equals, scenario.content)
@@ -258,7 +345,7 @@ func (s *backendSuite) TestCombineSnippetsOrdering(c *C) {
 	}
 
 	s.InstallSnap(c, interfaces.ConfinementOptions{}, snapYaml, 0)
-	profile := filepath.Join(dirs.SnapSeccompDir, "snap.foo.foo")
+	profile := filepath.Join(dirs.SnapSeccompDir, "snap.foo.foo.src")
 	data, err := ioutil.ReadFile(profile)
 	c.Assert(err, IsNil)
 	c.Check(string(data), Equals, "default\naaa\nzzz\n")
@@ -37,6 +37,7 @@ import (
 // nothing in it violates existing assertions, or misses required
 // ones.
 type AssertManager struct {
+	state  *state.State
 	runner *state.TaskRunner
 }
 
@@ -55,13 +56,13 @@ func Manager(s *state.State) (*AssertManager, error) {
 	ReplaceDB(s, db)
 	s.Unlock()
 
-	return &AssertManager{runner: runner}, nil
+	return &AssertManager{state: s, runner: runner}, nil
 }
 
 // Ensure implements StateManager.Ensure.
 func (m *AssertManager) Ensure() error {
 	m.runner.Ensure()
-	return nil
+	return m.ensureRevalidated()
 }
 
 // Wait implements StateManager.Wait.
@@ -207,8 +207,18 @@ func ValidateRefreshes(s *state.State, snapInfos []*snap.Info, userID int) (vali
 		}
 	}
 
+	invalid, err := invalidReasons(s)
+	if err != nil {
+		return nil, err
+	}
+
 	var errs []error
 	for _, candInfo := range snapInfos {
+		if err := checkNotRevoked(candInfo, invalid); err != nil {
+			errs = append(errs, fmt.Errorf("cannot refresh %q to revision %s: %v", candInfo.Name(), candInfo.Revision, err))
+			continue
+		}
+
 		gatedID := candInfo.SnapID
 		gating := controlled[gatedID]
 		if len(gating) == 0 { // easy case, no refresh control
@@ -267,6 +277,26 @@ func ValidateRefreshes(s *state.State, snapInfos []*snap.Info, userID int) (vali
 	return validated, nil
 }
 
+// checkNotRevoked checks that the assertions the refresh candidate
+// depends on were not found invalid by the last re-validation.
+func checkNotRevoked(candInfo *snap.Info, invalid map[string]string) error {
+	if len(invalid) == 0 {
+		return nil
+	}
+	refs := []*asserts.Ref{
+		{Type: asserts.SnapDeclarationType, PrimaryKey: []string{release.Series, candInfo.SnapID}},
+	}
+	if candInfo.Sha3_384 != "" {
+		refs = append(refs, &asserts.Ref{Type: asserts.SnapRevisionType, PrimaryKey: []string{candInfo.Sha3_384}})
+	}
+	for _, ref := range refs {
+		if reason, ok := invalid[ref.Unique()]; ok {
+			return fmt.Errorf("%s assertion is no longer valid: %s", ref.Type.Name, reason)
+		}
+	}
+	return nil
+}
+
 func init() {
 	// hook validation of refreshes into snapstate logic
 	snapstate.ValidateRefreshes = ValidateRefreshes
@@ -318,6 +348,18 @@ func Publisher(s *state.State, snapID string) (*asserts.Account, error) {
 	return a.(*asserts.Account), nil
 }
 
+// Store returns the store assertion with the given name/id if it is present in the system assertion database.
+func Store(s *state.State, store string) (*asserts.Store, error) {
+	db := DB(s)
+	a, err := db.Find(asserts.StoreType, map[string]string{
+		"store": store,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.(*asserts.Store), nil
+}
+
 // AutoAliases returns the explicit automatic aliases alias=>app mapping for the given installed snap.
 func AutoAliases(s *state.State, info *snap.Info) (map[string]string, error) {
 	if info.SnapID == "" {
@@ -96,7 +96,7 @@ func (sto *fakeStore) ListRefresh([]*store.RefreshCandidate, *auth.UserState) ([
 	panic("fakeStore.ListRefresh not expected")
 }
 
-func (sto *fakeStore) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState) error {
+func (sto *fakeStore) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState, *store.DownloadOptions) error {
 	panic("fakeStore.Download not expected")
 }
 
@@ -1096,3 +1096,253 @@ func (s *assertMgrSuite) TestPublisher(c *C) {
 	c.Check(acct.AccountID(), Equals, s.dev1Acct.AccountID())
 	c.Check(acct.Username(), Equals, "developer1")
 }
+
+// storeKeyRevision adds to the system assertion database the given
+// revision of the store account-key, valid from since until until.
+func (s *assertMgrSuite) storeKeyRevision(c *C, rev int, since, until time.Time) {
+	storeKey := s.storeSigning.StoreAccountKey("")
+	pubKey, err := s.storeSigning.PublicKey("")
+	c.Assert(err, IsNil)
+	headers := map[string]interface{}{
+		"name":     storeKey.Name(),
+		"revision": fmt.Sprintf("%d", rev),
+		"since":    since.Format(time.RFC3339),
+	}
+	if !until.IsZero() {
+		headers["until"] = until.Format(time.RFC3339)
+	}
+	accKey := assertstest.NewAccountKey(s.storeSigning.RootSigning, s.storeSigning.TrustedAccount, headers, pubKey, "")
+	err = assertstate.Add(s.state, accKey)
+	c.Assert(err, IsNil)
+}
+
+// revokeStoreKey adds to the system assertion database a new revision
+// of the store account-key whose validity ended in the past.
+func (s *assertMgrSuite) revokeStoreKey(c *C) {
+	s.storeKeyRevision(c, 1, time.Now().Add(-2*time.Hour), time.Now().Add(-1*time.Hour))
+}
+
+func (s *assertMgrSuite) addSnapRevision(c *C, snapID string, rev int) *asserts.SnapRevision {
+	headers := map[string]interface{}{
+		"snap-id":       snapID,
+		"snap-sha3-384": makeDigest(rev),
+		"snap-size":     fmt.Sprintf("%d", len(fakeSnap(rev))),
+		"snap-revision": fmt.Sprintf("%d", rev),
+		"developer-id":  s.dev1Acct.AccountID(),
+		"timestamp":     time.Now().Format(time.RFC3339),
+	}
+	snapRev, err := s.storeSigning.Sign(asserts.SnapRevisionType, headers, nil, "")
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, snapRev)
+	c.Assert(err, IsNil)
+	return snapRev.(*asserts.SnapRevision)
+}
+
+func (s *assertMgrSuite) TestRevalidate(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapDeclFoo := s.snapDecl(c, "foo", nil)
+
+	err := assertstate.Add(s.state, s.storeSigning.StoreAccountKey(""))
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, s.dev1Acct)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, snapDeclFoo)
+	c.Assert(err, IsNil)
+	snapRev := s.addSnapRevision(c, "foo-id", 9)
+
+	s.state.Unlock()
+	err = assertstate.Revalidate(s.state)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	invalid, err := assertstate.InvalidAssertions(s.state)
+	c.Assert(err, IsNil)
+	c.Check(invalid, HasLen, 0)
+
+	s.revokeStoreKey(c)
+
+	s.state.Unlock()
+	err = assertstate.Revalidate(s.state)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	invalid, err = assertstate.InvalidAssertions(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(invalid, HasLen, 3)
+	// the account of the developer is also signed by the store
+	c.Check(invalid[0].Type, Equals, "account")
+	c.Check(invalid[0].PrimaryKey, DeepEquals, []string{s.dev1Acct.AccountID()})
+	c.Check(invalid[1].Type, Equals, "snap-declaration")
+	c.Check(invalid[1].PrimaryKey, DeepEquals, []string{"16", "foo-id"})
+	c.Check(invalid[2].Type, Equals, "snap-revision")
+	c.Check(invalid[2].PrimaryKey, DeepEquals, []string{snapRev.SnapSHA3_384()})
+	c.Check(invalid[2].Reason, Matches, `assertion is signed with public key .* that was revoked .*`)
+}
+
+func (s *assertMgrSuite) TestRevalidateKeyExpiredAfterSigning(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	now := time.Now()
+	s.storeKeyRevision(c, 1, now.Add(-4*time.Hour), time.Time{})
+	dev2Acct := assertstest.NewAccount(s.storeSigning, "developer2", map[string]interface{}{
+		"timestamp": now.Add(-3 * time.Hour).Format(time.RFC3339),
+	}, "")
+	err := assertstate.Add(s.state, dev2Acct)
+	c.Assert(err, IsNil)
+
+	// the key expired after the account was signed
+	s.storeKeyRevision(c, 2, now.Add(-4*time.Hour), now.Add(-time.Hour))
+
+	s.state.Unlock()
+	err = assertstate.Revalidate(s.state)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	invalid, err := assertstate.InvalidAssertions(s.state)
+	c.Assert(err, IsNil)
+	c.Check(invalid, HasLen, 0)
+
+	// the key was revoked before the account was signed
+	s.storeKeyRevision(c, 3, now.Add(-4*time.Hour), now.Add(-3*time.Hour-30*time.Minute))
+
+	s.state.Unlock()
+	err = assertstate.Revalidate(s.state)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	invalid, err = assertstate.InvalidAssertions(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(invalid, HasLen, 1)
+	c.Check(invalid[0].Type, Equals, "account")
+	c.Check(invalid[0].PrimaryKey, DeepEquals, []string{dev2Acct.AccountID()})
+	c.Check(invalid[0].Reason, Matches, `assertion is signed with public key .* that was revoked .*`)
+}
+
+func (s *assertMgrSuite) TestEnsureRevalidates(c *C) {
+	s.state.Lock()
+	err := assertstate.Add(s.state, s.storeSigning.StoreAccountKey(""))
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, s.dev1Acct)
+	c.Assert(err, IsNil)
+	s.state.Unlock()
+
+	err = s.mgr.Ensure()
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	var last time.Time
+	err = s.state.Get("last-assertions-revalidation", &last)
+	c.Assert(err, IsNil)
+	c.Check(last.IsZero(), Equals, false)
+	invalid, err := assertstate.InvalidAssertions(s.state)
+	c.Assert(err, IsNil)
+	c.Check(invalid, HasLen, 0)
+
+	s.revokeStoreKey(c)
+	s.state.Unlock()
+
+	// too early to revalidate again
+	err = s.mgr.Ensure()
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	invalid, err = assertstate.InvalidAssertions(s.state)
+	c.Assert(err, IsNil)
+	c.Check(invalid, HasLen, 0)
+	s.state.Unlock()
+
+	restore := assertstate.MockRevalidateInterval(0)
+	defer restore()
+
+	err = s.mgr.Ensure()
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	invalid, err = assertstate.InvalidAssertions(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(invalid, HasLen, 1)
+	c.Check(invalid[0].Type, Equals, "account")
+}
+
+func (s *assertMgrSuite) TestValidateRefreshesRevokedSnapRevision(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapDeclFoo := s.snapDecl(c, "foo", nil)
+	snapDeclBar := s.snapDecl(c, "bar", nil)
+	s.stateFromDecl(snapDeclFoo, snap.R(7))
+	s.stateFromDecl(snapDeclBar, snap.R(3))
+
+	err := assertstate.Add(s.state, s.storeSigning.StoreAccountKey(""))
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, s.dev1Acct)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, snapDeclFoo)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, snapDeclBar)
+	c.Assert(err, IsNil)
+	s.addSnapRevision(c, "foo-id", 9)
+
+	// pretend the last re-validation found the snap-revision for
+	// the refresh candidate of foo to be invalid
+	s.state.Set("invalid-assertions", []*assertstate.InvalidAssertion{
+		{Type: "snap-revision", PrimaryKey: []string{makeDigest(9)}, Reason: "signing key revoked"},
+	})
+
+	fooRefresh := &snap.Info{
+		SideInfo: snap.SideInfo{RealName: "foo", SnapID: "foo-id", Revision: snap.R(9)},
+	}
+	fooRefresh.Sha3_384 = makeDigest(9)
+	barRefresh := &snap.Info{
+		SideInfo: snap.SideInfo{RealName: "bar", SnapID: "bar-id", Revision: snap.R(4)},
+	}
+
+	validated, err := assertstate.ValidateRefreshes(s.state, []*snap.Info{fooRefresh, barRefresh}, 0)
+	c.Assert(err, ErrorMatches, `cannot refresh "foo" to revision 9: snap-revision assertion is no longer valid: signing key revoked`)
+	c.Check(validated, DeepEquals, []*snap.Info{barRefresh})
+}
+
+func (s *assertMgrSuite) TestValidateSnapRevokedSignatures(c *C) {
+	s.prereqSnapAssertions(c, 10)
+
+	tempdir := c.MkDir()
+	snapPath := filepath.Join(tempdir, "foo.snap")
+	err := ioutil.WriteFile(snapPath, fakeSnap(10), 0644)
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the assertions were fetched before the store key was revoked,
+	// they get checked again when fetched anew
+	err = assertstate.DoFetch(s.state, 0, func(f asserts.Fetcher) error {
+		return f.Fetch(&asserts.Ref{Type: asserts.SnapRevisionType, PrimaryKey: []string{makeDigest(10)}})
+	})
+	c.Assert(err, IsNil)
+	s.revokeStoreKey(c)
+
+	chg := s.state.NewChange("install", "...")
+	t := s.state.NewTask("validate-snap", "Fetch and check snap assertions")
+	snapsup := snapstate.SnapSetup{
+		SnapPath: snapPath,
+		UserID:   0,
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			SnapID:   "snap-id-1",
+			Revision: snap.R(10),
+		},
+	}
+	t.Set("snap-setup", snapsup)
+	chg.AddTask(t)
+
+	s.state.Unlock()
+	defer s.mgr.Stop()
+	s.settle()
+	s.state.Lock()
+
+	c.Assert(chg.Err(), ErrorMatches, `(?s).*cannot add some assertions to the system database:.* assertion is signed with expired public key .*`)
+}
@@ -0,0 +1,187 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package assertstate
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/snapasserts"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// A Bundle holds the content of an offline bundle: a tarball,
+// optionally gzip compressed, of snaps (*.snap) together with the
+// assertions (*.assert) needed to install them without access to the
+// store, that is their snap-declarations, snap-revisions and the
+// chain of account-keys signing them.
+type Bundle struct {
+	batch     *Batch
+	snapPaths []string
+	// origNames maps the temporary paths of the snaps to their
+	// names in the bundle
+	origNames map[string]string
+	handedOff bool
+}
+
+// ReadBundle reads a bundle from r, writing its snaps to temporary
+// files in tmpDir, or in the default directory for temporary files if
+// it is empty. Call Cleanup on the result once done with it.
+func ReadBundle(r io.Reader, tmpDir string) (*Bundle, error) {
+	b := &Bundle{
+		batch:     NewBatch(),
+		origNames: make(map[string]string),
+	}
+	if err := b.read(r, tmpDir); err != nil {
+		b.Cleanup()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Bundle) read(r io.Reader, tmpDir string) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("cannot read bundle: %v", err)
+		}
+		defer gzr.Close()
+		r = gzr
+	} else {
+		r = br
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read bundle: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := filepath.Base(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return fmt.Errorf("cannot read bundle: %q is not a regular file", name)
+		}
+		switch {
+		case strings.HasSuffix(name, ".assert"):
+			if _, err := b.batch.AddStream(tr); err != nil {
+				return fmt.Errorf("cannot read assertions from %q in bundle: %v", name, err)
+			}
+		case strings.HasSuffix(name, ".snap"):
+			path, err := writeBundleSnap(tr, tmpDir)
+			if err != nil {
+				return fmt.Errorf("cannot read snap %q from bundle: %v", name, err)
+			}
+			b.snapPaths = append(b.snapPaths, path)
+			b.origNames[path] = name
+		default:
+			return fmt.Errorf("cannot read bundle: unexpected file %q", name)
+		}
+	}
+
+	if len(b.snapPaths) == 0 {
+		return fmt.Errorf("cannot read bundle: no snaps in it")
+	}
+	return nil
+}
+
+func writeBundleSnap(r io.Reader, tmpDir string) (string, error) {
+	f, err := ioutil.TempFile(tmpDir, "snapd-bundle-pkg-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Install adds the assertions of the bundle to the system assertion
+// database, verifying them in the process, and returns task sets to
+// install or refresh each snap in the bundle, which must be covered
+// by those assertions. The snaps are installed one after the other in
+// the order they appear in the bundle.
+// Note that the state must be locked by the caller.
+func (b *Bundle) Install(st *state.State) (names []string, tss []*state.TaskSet, err error) {
+	if err := b.batch.Commit(st); err != nil {
+		return nil, nil, err
+	}
+
+	db := DB(st)
+	seen := make(map[string]bool, len(b.snapPaths))
+	for _, path := range b.snapPaths {
+		si, err := snapasserts.DeriveSideInfo(path, db)
+		if err == asserts.ErrNotFound {
+			return nil, nil, fmt.Errorf("cannot find signatures with metadata for snap %q in bundle", b.origNames[path])
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen[si.RealName] {
+			return nil, nil, fmt.Errorf("cannot install more than one revision of snap %q from bundle", si.RealName)
+		}
+		seen[si.RealName] = true
+
+		ts, err := snapstate.InstallPath(st, si, path, "", snapstate.Flags{RemoveSnapPath: true})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(tss) > 0 {
+			ts.WaitAll(tss[len(tss)-1])
+		}
+		names = append(names, si.RealName)
+		tss = append(tss, ts)
+	}
+
+	// the snap files now belong to the tasks
+	b.handedOff = true
+	return names, tss, nil
+}
+
+// Cleanup removes the temporary snap files of the bundle, unless
+// Install handed them off.
+func (b *Bundle) Cleanup() {
+	if b.handedOff {
+		return
+	}
+	for _, path := range b.snapPaths {
+		os.Remove(path)
+	}
+}
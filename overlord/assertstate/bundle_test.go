@@ -0,0 +1,236 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package assertstate_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/assertstate"
+	// set up the hook and configure tasks of snapstate
+	_ "github.com/snapcore/snapd/overlord/configstate"
+	_ "github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/snap"
+)
+
+type bundleEntry struct {
+	name    string
+	content []byte
+}
+
+func makeBundle(c *C, compress bool, entries ...bundleEntry) []byte {
+	var buf bytes.Buffer
+	var gzw *gzip.Writer
+	var tw *tar.Writer
+	if compress {
+		gzw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gzw)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "bundle/", Typeflag: tar.TypeDir, Mode: 0755}), IsNil)
+	for _, e := range entries {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     "bundle/" + e.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+		})
+		c.Assert(err, IsNil)
+		_, err = tw.Write(e.content)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(tw.Close(), IsNil)
+	if gzw != nil {
+		c.Assert(gzw.Close(), IsNil)
+	}
+	return buf.Bytes()
+}
+
+func (s *assertMgrSuite) bundleAssertions(c *C, revisions ...int) []byte {
+	s.prereqSnapAssertions(c, revisions...)
+
+	var buf bytes.Buffer
+	enc := asserts.NewEncoder(&buf)
+	c.Assert(enc.Encode(s.storeSigning.StoreAccountKey("")), IsNil)
+	c.Assert(enc.Encode(s.dev1Acct), IsNil)
+	snapDecl, err := s.storeSigning.Find(asserts.SnapDeclarationType, map[string]string{
+		"series":  "16",
+		"snap-id": "snap-id-1",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(enc.Encode(snapDecl), IsNil)
+	for _, rev := range revisions {
+		snapRev, err := s.storeSigning.Find(asserts.SnapRevisionType, map[string]string{
+			"snap-sha3-384": makeDigest(rev),
+		})
+		c.Assert(err, IsNil)
+		c.Assert(enc.Encode(snapRev), IsNil)
+	}
+	return buf.Bytes()
+}
+
+func (s *assertMgrSuite) testBundleInstall(c *C, compress bool) {
+	bundle := makeBundle(c, compress,
+		bundleEntry{"foo_10.snap", fakeSnap(10)},
+		bundleEntry{"foo.assert", s.bundleAssertions(c, 10)},
+	)
+
+	tmpDir := c.MkDir()
+	b, err := assertstate.ReadBundle(bytes.NewReader(bundle), tmpDir)
+	c.Assert(err, IsNil)
+	defer b.Cleanup()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	names, tss, err := b.Install(s.state)
+	c.Assert(err, IsNil)
+	c.Check(names, DeepEquals, []string{"foo"})
+	c.Assert(tss, HasLen, 1)
+
+	snapsup, err := snapstate.TaskSnapSetup(tss[0].Tasks()[0])
+	c.Assert(err, IsNil)
+	c.Check(snapsup.SideInfo, DeepEquals, &snap.SideInfo{
+		RealName: "foo",
+		SnapID:   "snap-id-1",
+		Revision: snap.R(10),
+	})
+	c.Check(snapsup.Flags.RemoveSnapPath, Equals, true)
+	c.Check(filepath.Dir(snapsup.SnapPath), Equals, tmpDir)
+	content, err := ioutil.ReadFile(snapsup.SnapPath)
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, fakeSnap(10))
+
+	// the assertions were added
+	snapRev, err := assertstate.DB(s.state).Find(asserts.SnapRevisionType, map[string]string{
+		"snap-sha3-384": makeDigest(10),
+	})
+	c.Assert(err, IsNil)
+	c.Check(snapRev.(*asserts.SnapRevision).SnapRevision(), Equals, 10)
+
+	// the snap file was handed off to the tasks
+	b.Cleanup()
+	c.Check(osutil.FileExists(snapsup.SnapPath), Equals, true)
+}
+
+func (s *assertMgrSuite) TestBundleInstall(c *C) {
+	s.testBundleInstall(c, false)
+}
+
+func (s *assertMgrSuite) TestBundleInstallCompressed(c *C) {
+	s.testBundleInstall(c, true)
+}
+
+func (s *assertMgrSuite) TestBundleInstallMissingSnapRevision(c *C) {
+	assertions := s.bundleAssertions(c)
+	bundle := makeBundle(c, false,
+		bundleEntry{"foo_10.snap", fakeSnap(10)},
+		bundleEntry{"foo.assert", assertions},
+	)
+
+	tmpDir := c.MkDir()
+	b, err := assertstate.ReadBundle(bytes.NewReader(bundle), tmpDir)
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	_, _, err = b.Install(s.state)
+	s.state.Unlock()
+	c.Check(err, ErrorMatches, `cannot find signatures with metadata for snap "foo_10.snap" in bundle`)
+
+	// the snap file is removed
+	b.Cleanup()
+	files, err := ioutil.ReadDir(tmpDir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+}
+
+func (s *assertMgrSuite) TestBundleInstallIncompleteChain(c *C) {
+	s.prereqSnapAssertions(c, 10)
+	snapRev, err := s.storeSigning.Find(asserts.SnapRevisionType, map[string]string{
+		"snap-sha3-384": makeDigest(10),
+	})
+	c.Assert(err, IsNil)
+	bundle := makeBundle(c, false,
+		bundleEntry{"foo_10.snap", fakeSnap(10)},
+		bundleEntry{"foo.assert", asserts.Encode(snapRev)},
+	)
+
+	b, err := assertstate.ReadBundle(bytes.NewReader(bundle), c.MkDir())
+	c.Assert(err, IsNil)
+	defer b.Cleanup()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	_, _, err = b.Install(s.state)
+	c.Check(err, ErrorMatches, `cannot find snap-declaration \(snap-id-1; series:16\): assertion not found`)
+}
+
+func (s *assertMgrSuite) TestBundleInstallSameSnapTwice(c *C) {
+	bundle := makeBundle(c, false,
+		bundleEntry{"foo_10.snap", fakeSnap(10)},
+		bundleEntry{"foo_11.snap", fakeSnap(11)},
+		bundleEntry{"foo.assert", s.bundleAssertions(c, 10, 11)},
+	)
+
+	b, err := assertstate.ReadBundle(bytes.NewReader(bundle), c.MkDir())
+	c.Assert(err, IsNil)
+	defer b.Cleanup()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	_, _, err = b.Install(s.state)
+	c.Check(err, ErrorMatches, `cannot install more than one revision of snap "foo" from bundle`)
+}
+
+func (s *assertMgrSuite) TestReadBundleErrors(c *C) {
+	for _, t := range []struct {
+		bundle []byte
+		err    string
+	}{
+		{[]byte("garbage"), `cannot read bundle: .*`},
+		{makeBundle(c, false), `cannot read bundle: no snaps in it`},
+		{makeBundle(c, false, bundleEntry{"README", nil}), `cannot read bundle: unexpected file "README"`},
+		{makeBundle(c, false, bundleEntry{"foo.assert", []byte("garbage")}), `cannot read assertions from "foo.assert" in bundle: .*`},
+	} {
+		tmpDir := c.MkDir()
+		_, err := assertstate.ReadBundle(bytes.NewReader(t.bundle), tmpDir)
+		c.Check(err, ErrorMatches, t.err)
+	}
+
+	// snaps read before the error are removed
+	tmpDir := c.MkDir()
+	bundle := makeBundle(c, false,
+		bundleEntry{"foo_10.snap", fakeSnap(10)},
+		bundleEntry{"README", nil},
+	)
+	_, err := assertstate.ReadBundle(bytes.NewReader(bundle), tmpDir)
+	c.Check(err, ErrorMatches, `cannot read bundle: unexpected file "README"`)
+	files, err := ioutil.ReadDir(tmpDir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+}
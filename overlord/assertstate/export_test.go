@@ -19,7 +19,19 @@
 
 package assertstate
 
+import (
+	"time"
+)
+
 // expose for testing
 var (
 	DoFetch = doFetch
 )
+
+func MockRevalidateInterval(d time.Duration) (restore func()) {
+	old := revalidateInterval
+	revalidateInterval = d
+	return func() {
+		revalidateInterval = old
+	}
+}
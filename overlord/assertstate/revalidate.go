@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package assertstate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// InvalidAssertion describes an assertion held in the system
+// assertion database that did not pass the last re-validation, e.g.
+// because its signing key expired or was revoked after it was added.
+type InvalidAssertion struct {
+	Type       string   `json:"type"`
+	PrimaryKey []string `json:"primary-key"`
+	Reason     string   `json:"reason"`
+}
+
+// Unique returns a unique string representing the invalid assertion,
+// matching the one returned by Ref.Unique for it.
+func (inv *InvalidAssertion) Unique() string {
+	return fmt.Sprintf("%s/%s", inv.Type, strings.Join(inv.PrimaryKey, "/"))
+}
+
+var revalidateInterval = 24 * time.Hour
+
+// Revalidate re-checks all the assertions in the system assertion
+// database against the current knowledge, recording the ones that are
+// not valid anymore. Those can be retrieved with InvalidAssertions.
+//
+// It must be called without the state lock held, which is only taken
+// to get the database and to record the results.
+func Revalidate(s *state.State) error {
+	s.Lock()
+	db := cachedDB(s)
+	s.Unlock()
+
+	invalid := []*InvalidAssertion{}
+	err := db.Recheck(func(a asserts.Assertion, reason error) {
+		ref := a.Ref()
+		logger.Noticef("Assertion %v is no longer valid: %v", ref, reason)
+		invalid = append(invalid, &InvalidAssertion{
+			Type:       ref.Type.Name,
+			PrimaryKey: ref.PrimaryKey,
+			Reason:     reason.Error(),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("cannot re-validate assertions: %v", err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.Set("invalid-assertions", invalid)
+	s.Set("last-assertions-revalidation", time.Now())
+	return nil
+}
+
+// InvalidAssertions returns the assertions found not to be valid
+// anymore by the last re-validation of the system assertion database.
+func InvalidAssertions(s *state.State) ([]*InvalidAssertion, error) {
+	var invalid []*InvalidAssertion
+	err := s.Get("invalid-assertions", &invalid)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return invalid, nil
+}
+
+// invalidReasons maps the unique references of the invalid
+// assertions to the reasons they are invalid.
+func invalidReasons(s *state.State) (map[string]string, error) {
+	invalid, err := InvalidAssertions(s)
+	if err != nil {
+		return nil, err
+	}
+	reasons := make(map[string]string, len(invalid))
+	for _, inv := range invalid {
+		reasons[inv.Unique()] = inv.Reason
+	}
+	return reasons, nil
+}
+
+func (m *AssertManager) ensureRevalidated() error {
+	m.state.Lock()
+	var last time.Time
+	err := m.state.Get("last-assertions-revalidation", &last)
+	m.state.Unlock()
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	if time.Since(last) < revalidateInterval {
+		return nil
+	}
+	return Revalidate(m.state)
+}
@@ -24,6 +24,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"sort"
 	"strconv"
@@ -340,6 +341,9 @@ type DeviceAssertions interface {
 
 	// DeviceSessionRequest produces a device-session-request with the given nonce, it also returns the device serial assertion.
 	DeviceSessionRequest(nonce string) (*asserts.DeviceSessionRequest, *asserts.Serial, error)
+
+	// ProxyStore returns the store assertion for the proxy store if one is set.
+	ProxyStore() (*asserts.Store, error)
 }
 
 var (
@@ -357,6 +361,8 @@ type AuthContext interface {
 
 	StoreID(fallback string) (string, error)
 
+	ProxyStoreURL() (*url.URL, error)
+
 	DeviceSessionRequest(nonce string) (devSessionRequest []byte, serial []byte, err error)
 }
 
@@ -443,6 +449,22 @@ func (ac *authContext) StoreID(fallback string) (string, error) {
 	return fallback, nil
 }
 
+// ProxyStoreURL returns the base URL of the proxy store if one is
+// set, or nil otherwise.
+func (ac *authContext) ProxyStoreURL() (*url.URL, error) {
+	if ac.deviceAsserts == nil {
+		return nil, nil
+	}
+	sto, err := ac.deviceAsserts.ProxyStore()
+	if err == state.ErrNoState {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sto.URL(), nil
+}
+
 // DeviceSessionRequest produces a device-session-request with the given nonce, it also returns the encoded device serial assertion. It returns ErrNoSerial if the device serial is not yet initialized.
 func (ac *authContext) DeviceSessionRequest(nonce string) (deviceSessionRequest []byte, serial []byte, err error) {
 	if ac.deviceAsserts == nil {
@@ -525,6 +525,14 @@ func (as *authSuite) TestAuthContextStoreIDFromEnv(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(storeID, Equals, "env-store-id")
 }
+func (as *authSuite) TestAuthContextProxyStoreURLNilDeviceAssertions(c *C) {
+	authContext := auth.NewAuthContext(as.state, nil)
+
+	proxyURL, err := authContext.ProxyStoreURL()
+	c.Assert(err, IsNil)
+	c.Check(proxyURL, IsNil)
+}
+
 func (as *authSuite) TestAuthContextDeviceSessionRequestNilDeviceAssertions(c *C) {
 	authContext := auth.NewAuthContext(as.state, nil)
 
@@ -577,6 +585,16 @@ nonce: @NONCE@
 timestamp: @TS@
 sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij
 
+AXNpZw=`
+
+	exStore = `type: store
+authority-id: my-brand
+store: my-proxy-store
+operator-id: my-brand
+url: https://proxy.example.com/
+timestamp: 2016-08-20T13:00:00Z
+sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij
+
 AXNpZw=`
 )
 
@@ -624,6 +642,17 @@ func (da *testDeviceAssertions) DeviceSessionRequest(nonce string) (*asserts.Dev
 	return a1.(*asserts.DeviceSessionRequest), a2.(*asserts.Serial), nil
 }
 
+func (da *testDeviceAssertions) ProxyStore() (*asserts.Store, error) {
+	if da.nothing {
+		return nil, state.ErrNoState
+	}
+	a, err := asserts.Decode([]byte(exStore))
+	if err != nil {
+		return nil, err
+	}
+	return a.(*asserts.Store), nil
+}
+
 func (as *authSuite) TestAuthContextMissingDeviceAssertions(c *C) {
 	// no assertions in state
 	authContext := auth.NewAuthContext(as.state, &testDeviceAssertions{nothing: true})
@@ -634,6 +663,10 @@ func (as *authSuite) TestAuthContextMissingDeviceAssertions(c *C) {
 	storeID, err := authContext.StoreID("fallback")
 	c.Assert(err, IsNil)
 	c.Check(storeID, Equals, "fallback")
+
+	proxyURL, err := authContext.ProxyStoreURL()
+	c.Assert(err, IsNil)
+	c.Check(proxyURL, IsNil)
 }
 
 func (as *authSuite) TestAuthContextWithDeviceAssertions(c *C) {
@@ -649,6 +682,10 @@ func (as *authSuite) TestAuthContextWithDeviceAssertions(c *C) {
 	storeID, err := authContext.StoreID("store-id")
 	c.Assert(err, IsNil)
 	c.Check(storeID, Equals, "my-brand-store-id")
+
+	proxyURL, err := authContext.ProxyStoreURL()
+	c.Assert(err, IsNil)
+	c.Check(proxyURL.String(), Equals, "https://proxy.example.com/")
 }
 
 func (as *authSuite) TestUsers(c *C) {
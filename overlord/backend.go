@@ -22,18 +22,39 @@ package overlord
 import (
 	"time"
 
-	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/state"
 )
 
 type overlordStateBackend struct {
 	path           string
+	journal        *stateJournal
 	ensureBefore   func(d time.Duration)
 	requestRestart func(t state.RestartType)
 }
 
+func newOverlordStateBackend(path string, ensureBefore func(d time.Duration), requestRestart func(t state.RestartType)) *overlordStateBackend {
+	return &overlordStateBackend{
+		path:           path,
+		journal:        newStateJournal(path),
+		ensureBefore:   ensureBefore,
+		requestRestart: requestRestart,
+	}
+}
+
 func (osb *overlordStateBackend) Checkpoint(data []byte) error {
-	return osutil.AtomicWriteFile(osb.path, data, 0600, 0)
+	return osb.journal.checkpoint(data)
+}
+
+// compact writes out the whole state as a new snapshot, leaving an
+// empty journal behind.
+func (osb *overlordStateBackend) compact(s *state.State) error {
+	s.Lock()
+	defer s.Unlock()
+	data, err := s.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return osb.journal.compactData(data)
 }
 
 func (osb *overlordStateBackend) EnsureBefore(d time.Duration) {
@@ -41,5 +62,24 @@ func (osb *overlordStateBackend) EnsureBefore(d time.Duration) {
 }
 
 func (osb *overlordStateBackend) RequestRestart(t state.RestartType) {
+	// the snapd or core coming up might be an older one, or get
+	// reverted to one, that knows nothing about the journal
+	osb.journal.pause()
 	osb.requestRestart(t)
 }
+
+// ensureJournal resumes journaling, paused when a restart was
+// requested, once no change is in progress anymore.
+func (osb *overlordStateBackend) ensureJournal(s *state.State) {
+	s.Lock()
+	defer s.Unlock()
+	if !osb.journal.paused {
+		return
+	}
+	for _, chg := range s.Changes() {
+		if !chg.IsReady() {
+			return
+		}
+	}
+	osb.journal.resume()
+}
@@ -135,3 +135,38 @@ func DeleteSnapConfig(st *state.State, snapName string) error {
 	}
 	return nil
 }
+
+// GetSnapConfig retrieves the configuration of the given snap from
+// the state, as it is stored there. It returns nil if the snap has no
+// configuration.
+func GetSnapConfig(st *state.State, snapName string) (map[string]*json.RawMessage, error) {
+	var config map[string]map[string]*json.RawMessage // snap => key => value
+
+	err := st.Get("config", &config)
+	if err == state.ErrNoState {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("internal error: cannot unmarshal configuration: %v", err)
+	}
+	return config[snapName], nil
+}
+
+// SetSnapConfig replaces the configuration of the given snap in the
+// state. A nil snapConfig removes the configuration of the snap.
+func SetSnapConfig(st *state.State, snapName string, snapConfig map[string]*json.RawMessage) error {
+	var config map[string]map[string]*json.RawMessage // snap => key => value
+
+	err := st.Get("config", &config)
+	if err == state.ErrNoState {
+		config = make(map[string]map[string]*json.RawMessage)
+	} else if err != nil {
+		return fmt.Errorf("internal error: cannot unmarshal configuration: %v", err)
+	}
+	if snapConfig == nil {
+		delete(config, snapName)
+	} else {
+		config[snapName] = snapConfig
+	}
+	st.Set("config", config)
+	return nil
+}
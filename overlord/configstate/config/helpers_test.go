@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package config_test
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type helpersSuite struct {
+	state *state.State
+}
+
+var _ = Suite(&helpersSuite{})
+
+func (s *helpersSuite) SetUpTest(c *C) {
+	s.state = state.New(nil)
+}
+
+func (s *helpersSuite) TestGetSetSnapConfig(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapConfig, err := config.GetSnapConfig(s.state, "test-snap")
+	c.Assert(err, IsNil)
+	c.Check(snapConfig, IsNil)
+
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("test-snap", "foo", "bar"), IsNil)
+	c.Assert(tr.Set("other-snap", "baz", 42), IsNil)
+	tr.Commit()
+
+	snapConfig, err = config.GetSnapConfig(s.state, "test-snap")
+	c.Assert(err, IsNil)
+	c.Assert(snapConfig, HasLen, 1)
+	c.Check(string(*snapConfig["foo"]), Equals, `"bar"`)
+
+	raw := json.RawMessage(`{"qux":1}`)
+	err = config.SetSnapConfig(s.state, "test-snap", map[string]*json.RawMessage{"foo": &raw})
+	c.Assert(err, IsNil)
+
+	var value map[string]int
+	tr = config.NewTransaction(s.state)
+	c.Assert(tr.Get("test-snap", "foo", &value), IsNil)
+	c.Check(value, DeepEquals, map[string]int{"qux": 1})
+
+	err = config.SetSnapConfig(s.state, "test-snap", nil)
+	c.Assert(err, IsNil)
+	snapConfig, err = config.GetSnapConfig(s.state, "test-snap")
+	c.Assert(err, IsNil)
+	c.Check(snapConfig, IsNil)
+
+	// other snaps are unaffected
+	var baz int
+	tr = config.NewTransaction(s.state)
+	c.Assert(tr.Get("other-snap", "baz", &baz), IsNil)
+	c.Check(baz, Equals, 42)
+}
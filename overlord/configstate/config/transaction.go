@@ -65,7 +65,8 @@ func NewTransaction(st *state.State) *Transaction {
 // When the key is provided in that form, intermediate maps are mutated
 // rather than replaced, and created when necessary.
 //
-// The provided value must marshal properly by encoding/json.
+// The provided value must marshal properly by encoding/json. A nil
+// value unsets the key, as does Unset.
 // Changes are not persisted until Commit is called.
 func (t *Transaction) Set(snapName, key string, value interface{}) error {
 	t.mu.Lock()
@@ -105,6 +106,14 @@ func (t *Transaction) Set(snapName, key string, value interface{}) error {
 	return nil
 }
 
+// Unset removes the provided snap's configuration key, along with
+// anything nested under it. Unsetting a key that doesn't exist is not
+// an error.
+// Changes are not persisted until Commit is called.
+func (t *Transaction) Unset(snapName, key string) error {
+	return t.Set(snapName, key, nil)
+}
+
 // Get unmarshals into result the cached value of the provided snap's configuration key.
 // If the key does not exist, an error of type *NoOptionError is returned.
 // The provided key may be formed as a dotted key path through nested maps.
@@ -120,11 +129,15 @@ func (t *Transaction) Get(snapName, key string, result interface{}) error {
 		return err
 	}
 
-	err = GetFromChange(snapName, subkeys, 0, t.changes[snapName], result)
-	if IsNoOption(err) {
-		err = getFromPristine(snapName, subkeys, 0, t.pristine[snapName], result)
+	// Apply the changes onto a copy of the pristine configuration so
+	// that unset keys are seen as gone.
+	config := make(map[string]*json.RawMessage, len(t.pristine[snapName]))
+	for k, v := range t.pristine[snapName] {
+		config[k] = v
 	}
-	return err
+	applyChanges(config, t.changes[snapName])
+
+	return getFromPristine(snapName, subkeys, 0, config, result)
 }
 
 // GetMaybe unmarshals into result the cached value of the provided snap's configuration key.
@@ -188,9 +201,7 @@ func (t *Transaction) Commit() {
 		if !ok {
 			config = make(map[string]*json.RawMessage)
 		}
-		for k, v := range snapChanges {
-			config[k] = commitChange(config[k], v)
-		}
+		applyChanges(config, snapChanges)
 		t.pristine[snapName] = config
 	}
 
@@ -209,27 +220,57 @@ func jsonRaw(v interface{}) *json.RawMessage {
 	return &raw
 }
 
+// applyChanges applies the given changes onto config, removing the
+// keys that were unset.
+func applyChanges(config map[string]*json.RawMessage, changes map[string]interface{}) {
+	for k, v := range changes {
+		if value := commitChange(config[k], v); value != nil {
+			config[k] = value
+		} else {
+			delete(config, k)
+		}
+	}
+}
+
+// commitChange returns the result of applying change onto pristine,
+// or nil if the result is unset.
 func commitChange(pristine *json.RawMessage, change interface{}) *json.RawMessage {
 	switch change := change.(type) {
 	case *json.RawMessage:
-		return change
+		return purgeNulls(change)
 	case map[string]interface{}:
-		if pristine == nil {
-			return jsonRaw(change)
-		}
 		var pristinem map[string]*json.RawMessage
-		if err := json.Unmarshal([]byte(*pristine), &pristinem); err != nil {
-			// Not a map. Overwrite with the change.
-			return jsonRaw(change)
-		}
-		for k, v := range change {
-			pristinem[k] = commitChange(pristinem[k], v)
+		if pristine == nil || json.Unmarshal([]byte(*pristine), &pristinem) != nil || pristinem == nil {
+			// Missing or not a map. Overwrite with the change.
+			pristinem = make(map[string]*json.RawMessage)
 		}
+		applyChanges(pristinem, change)
 		return jsonRaw(pristinem)
 	}
 	panic(fmt.Errorf("internal error: unexpected configuration type %T", change))
 }
 
+// purgeNulls returns the given value without the null entries of any
+// maps in it, or nil if the value itself is null.
+func purgeNulls(value *json.RawMessage) *json.RawMessage {
+	if value == nil || string(*value) == "null" {
+		return nil
+	}
+	var valuem map[string]*json.RawMessage
+	if err := json.Unmarshal([]byte(*value), &valuem); err != nil || valuem == nil {
+		// Not a map.
+		return value
+	}
+	for k, v := range valuem {
+		if v = purgeNulls(v); v != nil {
+			valuem[k] = v
+		} else {
+			delete(valuem, k)
+		}
+	}
+	return jsonRaw(valuem)
+}
+
 // IsNoOption returns whether the provided error is a *NoOptionError.
 func IsNoOption(err error) bool {
 	_, ok := err.(*NoOptionError)
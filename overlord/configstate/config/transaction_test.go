@@ -144,6 +144,32 @@ var setGetTests = [][]setGetOp{{
 	`set one.two.three=3`,
 	`commit`,
 	`getunder one={"two":{"three":3}}`,
+}, {
+	// Unsetting.
+	`set one=1 two={"three":3,"four":4}`,
+	`commit`,
+	`set one=null two.three=null`,
+	`get one=- two={"four":4}`,
+	`get two.three=- two.four=4`,
+	`getunder one=1 two={"three":3,"four":4}`,
+	`commit`,
+	`getunder one=- two={"four":4}`,
+	`get one=- two={"four":4}`,
+	`set one=1`,
+	`get one=1`,
+}, {
+	// Unsetting via nulls in documents.
+	`set one={"two":2,"three":{"four":null}}`,
+	`get one={"two":2,"three":{}}`,
+	`get one.three.four=-`,
+	`commit`,
+	`getunder one={"two":2,"three":{}}`,
+}, {
+	// Unsetting missing options.
+	`set one=null two.three=null`,
+	`get one=- two.three=-`,
+	`commit`,
+	`getunder one=-`,
 }, {
 	// Invalid option names.
 	`set BAD=1 => invalid option name: "BAD"`,
@@ -252,6 +278,33 @@ func (s *transactionSuite) TestSetGet(c *C) {
 	}
 }
 
+func (s *transactionSuite) TestUnset(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	t := config.NewTransaction(s.state)
+	c.Assert(t.Set("test-snap", "foo", map[string]interface{}{"bar": 1, "baz": 2}), IsNil)
+	c.Assert(t.Set("test-snap", "qux", 3), IsNil)
+	t.Commit()
+
+	t = config.NewTransaction(s.state)
+	c.Assert(t.Unset("test-snap", "foo.bar"), IsNil)
+	c.Assert(t.Unset("test-snap", "qux"), IsNil)
+	c.Assert(t.Unset("test-snap", "missing"), IsNil)
+	c.Check(t.Unset("test-snap", "BAD"), ErrorMatches, `invalid option name: "BAD"`)
+
+	var value interface{}
+	c.Check(t.Get("test-snap", "qux", &value), ErrorMatches, `snap "test-snap" has no "qux" configuration option`)
+	c.Check(t.Get("test-snap", "foo.bar", &value), ErrorMatches, `snap "test-snap" has no "foo.bar" configuration option`)
+	t.Commit()
+
+	var config map[string]map[string]interface{}
+	c.Assert(s.state.Get("config", &config), IsNil)
+	c.Check(config["test-snap"], DeepEquals, map[string]interface{}{
+		"foo": map[string]interface{}{"baz": 2.0},
+	})
+}
+
 type brokenType struct {
 	on string
 }
@@ -20,11 +20,13 @@
 package configstate_test
 
 import (
+	"fmt"
 	"testing"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/hookstate/hooktest"
 	"github.com/snapcore/snapd/overlord/state"
@@ -73,3 +75,165 @@ func (s *configureHandlerSuite) TestBeforeInitializesTransaction(c *C) {
 	c.Check(tr.Get("test-snap", "foo", &value), IsNil)
 	c.Check(value, Equals, "bar")
 }
+
+func (s *configureHandlerSuite) TestBeforeUnsetsNullOptions(c *C) {
+	s.context.Lock()
+	tr := config.NewTransaction(s.context.State())
+	tr.Set("test-snap", "foo", "bar")
+	tr.Set("test-snap", "baz", "qux")
+	tr.Commit()
+	s.context.Set("patch", map[string]interface{}{
+		"foo": nil,
+	})
+	s.context.Unlock()
+
+	c.Check(s.handler.Before(), IsNil)
+
+	s.context.Lock()
+	tr = configstate.ContextTransaction(s.context)
+	s.context.Unlock()
+
+	// the hook sees the option as unset
+	var value string
+	c.Check(tr.Get("test-snap", "foo", &value), ErrorMatches, `snap "test-snap" has no "foo" configuration option`)
+	c.Check(tr.Get("test-snap", "baz", &value), IsNil)
+	c.Check(value, Equals, "qux")
+
+	// but it is only gone once the hook is done
+	s.context.Lock()
+	defer s.context.Unlock()
+	tr = config.NewTransaction(s.context.State())
+	c.Check(tr.Get("test-snap", "foo", &value), IsNil)
+	c.Check(value, Equals, "bar")
+
+	c.Check(s.context.Done(), IsNil)
+	tr = config.NewTransaction(s.context.State())
+	c.Check(tr.Get("test-snap", "foo", &value), ErrorMatches, `snap "test-snap" has no "foo" configuration option`)
+}
+
+func (s *configureHandlerSuite) TestDoneValidatesCoreRefreshSchedule(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	task := st.NewTask("test-task", "my test task")
+	st.Unlock()
+
+	setup := &hookstate.HookSetup{Snap: "core", Revision: snap.R(1), Hook: "configure"}
+	context, err := hookstate.NewContext(task, setup, hooktest.NewMockHandler())
+	c.Assert(err, IsNil)
+	handler := configstate.NewConfigureHandler(context)
+
+	context.Lock()
+	defer context.Unlock()
+
+	for _, t := range []struct {
+		schedule string
+		errStr   string
+	}{
+		{"mon,wed-fri@9:00-11:00,13:00-15:00", ""},
+		{"9:00-11:00/21:00-23:00", ""},
+		{"invalid", `cannot set refresh.schedule: cannot parse "invalid": not a valid interval`},
+		{"11:00-9:00", `cannot set refresh.schedule: cannot parse "11:00-9:00": time in an interval cannot go backwards`},
+	} {
+		tr := configstate.ContextTransaction(context)
+		tr.Set("core", "refresh.schedule", t.schedule)
+
+		context.Unlock()
+		err := handler.Done()
+		context.Lock()
+		if t.errStr == "" {
+			c.Check(err, IsNil, Commentf("%q", t.schedule))
+		} else {
+			c.Check(err, ErrorMatches, t.errStr)
+		}
+	}
+}
+
+func (s *configureHandlerSuite) TestDoneValidatesCoreDownloadOptions(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	task := st.NewTask("test-task", "my test task")
+	st.Unlock()
+
+	setup := &hookstate.HookSetup{Snap: "core", Revision: snap.R(1), Hook: "configure"}
+	context, err := hookstate.NewContext(task, setup, hooktest.NewMockHandler())
+	c.Assert(err, IsNil)
+	handler := configstate.NewConfigureHandler(context)
+
+	context.Lock()
+	defer context.Unlock()
+
+	for _, t := range []struct {
+		key    string
+		value  interface{}
+		errStr string
+	}{
+		{"refresh.bandwidth-limit", "1MB", ""},
+		{"refresh.bandwidth-limit", 4096, ""},
+		{"refresh.bandwidth-limit", "fast", `cannot set refresh.bandwidth-limit: cannot parse byte size "fast": .*`},
+		{"refresh.max-concurrent-downloads", 2, ""},
+		{"refresh.max-concurrent-downloads", -2, `cannot set refresh.max-concurrent-downloads: cannot use -2: need a non-negative whole number`},
+		{"refresh.max-concurrent-downloads", 5, `cannot set refresh.max-concurrent-downloads: cannot use 5: snapd runs at most 2 downloads at the same time`},
+	} {
+		tr := configstate.ContextTransaction(context)
+		tr.Set("core", t.key, t.value)
+
+		context.Unlock()
+		err := handler.Done()
+		context.Lock()
+		if t.errStr == "" {
+			c.Check(err, IsNil, Commentf("%s=%v", t.key, t.value))
+		} else {
+			c.Check(err, ErrorMatches, t.errStr)
+		}
+		tr.Set("core", t.key, nil)
+	}
+}
+
+func (s *configureHandlerSuite) TestDoneValidatesCoreProxyStore(c *C) {
+	var validated []string
+	old := configstate.ValidateProxyStore
+	configstate.ValidateProxyStore = func(st *state.State, proxyStore string) error {
+		validated = append(validated, proxyStore)
+		if proxyStore == "bar" {
+			return fmt.Errorf("cannot set proxy.store to %q without a matching store assertion", proxyStore)
+		}
+		return nil
+	}
+	defer func() { configstate.ValidateProxyStore = old }()
+
+	st := state.New(nil)
+	st.Lock()
+	task := st.NewTask("test-task", "my test task")
+	st.Unlock()
+
+	setup := &hookstate.HookSetup{Snap: "core", Revision: snap.R(1), Hook: "configure"}
+	context, err := hookstate.NewContext(task, setup, hooktest.NewMockHandler())
+	c.Assert(err, IsNil)
+	handler := configstate.NewConfigureHandler(context)
+
+	context.Lock()
+	defer context.Unlock()
+
+	for _, t := range []struct {
+		proxyStore string
+		errStr     string
+	}{
+		{"foo", ""},
+		{"", ""},
+		{"bar", `cannot set proxy.store to "bar" without a matching store assertion`},
+	} {
+		tr := configstate.ContextTransaction(context)
+		tr.Set("core", "proxy.store", t.proxyStore)
+
+		context.Unlock()
+		err := handler.Done()
+		context.Lock()
+		if t.errStr == "" {
+			c.Check(err, IsNil, Commentf("%q", t.proxyStore))
+		} else {
+			c.Check(err, ErrorMatches, t.errStr)
+		}
+	}
+	// an empty proxy.store needs no check
+	c.Check(validated, DeepEquals, []string{"foo", "bar"})
+}
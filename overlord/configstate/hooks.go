@@ -20,10 +20,19 @@
 package configstate
 
 import (
+	"fmt"
+
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/timeutil"
 )
 
+// ValidateProxyStore checks that the store set with the proxy.store
+// option can be used. It is set by devicestate.
+var ValidateProxyStore func(st *state.State, proxyStore string) error
+
 // configureHandler is the handler for the configure hook.
 type configureHandler struct {
 	context *hookstate.Context
@@ -80,6 +89,48 @@ func (h *configureHandler) Before() error {
 // Done is called by the HookManager after the configure hook has exited
 // successfully.
 func (h *configureHandler) Done() error {
+	h.context.Lock()
+	defer h.context.Unlock()
+
+	if h.context.SnapName() != "core" {
+		return nil
+	}
+
+	tr := ContextTransaction(h.context)
+	return validateCoreConfig(h.context.State(), tr)
+}
+
+// validateCoreConfig checks the options of the core snap that snapd
+// itself interprets.
+func validateCoreConfig(st *state.State, tr *config.Transaction) error {
+	var refreshSchedule string
+	err := tr.Get("core", "refresh.schedule", &refreshSchedule)
+	if err != nil && !config.IsNoOption(err) {
+		return err
+	}
+	if refreshSchedule != "" {
+		if _, err := timeutil.ParseSchedule(refreshSchedule); err != nil {
+			return fmt.Errorf("cannot set refresh.schedule: %v", err)
+		}
+	}
+
+	if _, err := snapstate.BandwidthLimit(tr); err != nil {
+		return fmt.Errorf("cannot set refresh.bandwidth-limit: %v", err)
+	}
+	if _, err := snapstate.MaxConcurrentDownloads(tr); err != nil {
+		return fmt.Errorf("cannot set refresh.max-concurrent-downloads: %v", err)
+	}
+
+	var proxyStore string
+	err = tr.Get("core", "proxy.store", &proxyStore)
+	if err != nil && !config.IsNoOption(err) {
+		return err
+	}
+	if proxyStore != "" && ValidateProxyStore != nil {
+		if err := ValidateProxyStore(st, proxyStore); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
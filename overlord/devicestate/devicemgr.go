@@ -67,6 +67,8 @@ func Manager(s *state.State, hookManager *hookstate.HookManager) (*DeviceManager
 	runner.AddHandler("generate-device-key", m.doGenerateDeviceKey, nil)
 	runner.AddHandler("request-serial", m.doRequestSerial, nil)
 	runner.AddHandler("mark-seeded", m.doMarkSeeded, nil)
+	runner.AddHandler("check-remodel-kernel", m.doCheckRemodelKernel, nil)
+	runner.AddHandler("set-model", m.doSetModel, m.undoSetModel)
 
 	return m, nil
 }
@@ -158,7 +160,7 @@ func (m *DeviceManager) ensureOperational() error {
 		return nil
 	}
 
-	if m.changeInFlight("become-operational") {
+	if m.changeInFlight("become-operational") || m.changeInFlight("remodel") {
 		return nil
 	}
 
@@ -367,6 +369,14 @@ func (m *DeviceManager) Serial() (*asserts.Serial, error) {
 	return Serial(m.state)
 }
 
+// ProxyStore returns the store assertion for the proxy store if one is set.
+func (m *DeviceManager) ProxyStore() (*asserts.Store, error) {
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	return ProxyStore(m.state)
+}
+
 // DeviceSessionRequest produces a device-session-request with the given nonce, it also returns the device serial assertion.
 func (m *DeviceManager) DeviceSessionRequest(nonce string) (*asserts.DeviceSessionRequest, *asserts.Serial, error) {
 	m.state.Lock()
@@ -25,9 +25,12 @@ import (
 	"fmt"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/i18n/dumb"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
@@ -60,6 +63,39 @@ func Model(st *state.State) (*asserts.Model, error) {
 	return a.(*asserts.Model), nil
 }
 
+// ProxyStore returns the store assertion for the proxy store set
+// with the proxy.store core option, if any.
+func ProxyStore(st *state.State) (*asserts.Store, error) {
+	tr := config.NewTransaction(st)
+	var proxyStore string
+	err := tr.GetMaybe("core", "proxy.store", &proxyStore)
+	if err != nil {
+		return nil, err
+	}
+	if proxyStore == "" {
+		return nil, state.ErrNoState
+	}
+
+	a, err := assertstate.Store(st, proxyStore)
+	if err == asserts.ErrNotFound {
+		return nil, state.ErrNoState
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// validateProxyStore checks that there is a store assertion for the
+// store set with the proxy.store option.
+func validateProxyStore(st *state.State, proxyStore string) error {
+	_, err := assertstate.Store(st, proxyStore)
+	if err == asserts.ErrNotFound {
+		return fmt.Errorf("cannot set proxy.store to %q without a matching store assertion", proxyStore)
+	}
+	return err
+}
+
 // Serial returns the device serial assertion.
 func Serial(st *state.State) (*asserts.Serial, error) {
 	device, err := auth.Device(st)
@@ -171,6 +207,9 @@ func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags sn
 		return fmt.Errorf("cannot find original %s snap: %v", kind, err)
 	}
 	if currentSnap != nil {
+		if flags.Remodel {
+			return checkRemodelSnap(st, kind, snapInfo.Name(), getName)
+		}
 		// already installed, snapstate takes care
 		return nil
 	}
@@ -188,7 +227,201 @@ func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags sn
 	return nil
 }
 
+// modelSnapName returns the name of the gadget or kernel snap required by
+// the device model, used to tell apart the current one from the
+// one a remodel moved away from.
+func modelSnapName(st *state.State, snapType snap.Type) string {
+	model, err := Model(st)
+	if err != nil {
+		return ""
+	}
+	switch snapType {
+	case snap.TypeGadget:
+		return model.Gadget()
+	case snap.TypeKernel:
+		return model.Kernel()
+	}
+	return ""
+}
+
+var snapstateInstall = snapstate.Install
+
+// unrequiredSnaps returns the snaps required by the current model that
+// the new model does not require anymore.
+func unrequiredSnaps(current, newModel *asserts.Model) []string {
+	required := map[string]bool{
+		newModel.Kernel(): true,
+		newModel.Gadget(): true,
+	}
+	for _, name := range newModel.RequiredSnaps() {
+		required[name] = true
+	}
+	var unrequired []string
+	for _, name := range append([]string{current.Kernel(), current.Gadget()}, current.RequiredSnaps()...) {
+		if name != "" && !required[name] {
+			unrequired = append(unrequired, name)
+			required[name] = true
+		}
+	}
+	return unrequired
+}
+
+// checkRemodelSnap checks that the kernel or gadget snap with the given
+// name, replacing the current one, is the one of the model a remodel
+// in progress moves to.
+func checkRemodelSnap(st *state.State, kind, name string, getName func(*asserts.Model) string) error {
+	newModel, err := remodelingModel(st)
+	if err != nil {
+		return err
+	}
+	if newModel == nil {
+		return fmt.Errorf("cannot replace %s snap with %q without a remodel in progress", kind, name)
+	}
+	if expectedName := getName(newModel); name != expectedName {
+		return fmt.Errorf("cannot install %s %q, new model assertion requests %q", kind, name, expectedName)
+	}
+	return nil
+}
+
+// remodelingModel returns the model that a remodel in progress moves
+// the device to, or nil if there is none.
+func remodelingModel(st *state.State) (*asserts.Model, error) {
+	for _, chg := range st.Changes() {
+		if chg.Kind() != "remodel" || chg.Status().Ready() {
+			continue
+		}
+		for _, t := range chg.Tasks() {
+			if t.Kind() == "set-model" {
+				return newModelFromTask(t)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// checkRemodel verifies that the device can move from the current
+// model to the new one.
+func checkRemodel(st *state.State, current, newModel *asserts.Model) error {
+	if current.BrandID() != newModel.BrandID() {
+		return fmt.Errorf("cannot remodel to a different brand (from %q to %q)", current.BrandID(), newModel.BrandID())
+	}
+	if current.Series() != newModel.Series() {
+		return fmt.Errorf("cannot remodel to a different series (from %q to %q)", current.Series(), newModel.Series())
+	}
+	if current.Architecture() != newModel.Architecture() {
+		return fmt.Errorf("cannot remodel to a different architecture (from %q to %q)", current.Architecture(), newModel.Architecture())
+	}
+	if current.Classic() != newModel.Classic() {
+		return fmt.Errorf("cannot remodel between classic and non-classic models")
+	}
+	if current.Model() == newModel.Model() && current.Revision() >= newModel.Revision() {
+		return fmt.Errorf("cannot remodel to revision %d of model %q, current revision is %d", newModel.Revision(), newModel.Model(), current.Revision())
+	}
+	// the new model must be signed by a key known to the system
+	return assertstate.DB(st).Check(newModel)
+}
+
+// Remodel takes a new model assertion from the same brand as the
+// current one and returns a change that moves the device to it:
+// switching kernel and gadget and installing the newly required
+// snaps, then setting the model and re-registering the device if its
+// serial no longer matches. Snaps the new model does not require
+// anymore are kept, only losing their required flag.
+// Note that the state must be locked by the caller.
+func Remodel(st *state.State, newModel *asserts.Model) (*state.Change, error) {
+	var seeded bool
+	err := st.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if !seeded {
+		return nil, fmt.Errorf("cannot remodel until fully seeded")
+	}
+
+	current, err := Model(st)
+	if err == state.ErrNoState {
+		return nil, fmt.Errorf("cannot remodel without a current model assertion")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRemodel(st, current, newModel); err != nil {
+		return nil, err
+	}
+
+	for _, chg := range st.Changes() {
+		if chg.Kind() == "remodel" && !chg.Status().Ready() {
+			return nil, fmt.Errorf("cannot remodel while another remodel is in progress")
+		}
+	}
+
+	var installs []*state.TaskSet
+	if newModel.Kernel() != current.Kernel() {
+		ts, err := snapstateInstall(st, newModel.Kernel(), "", snap.R(0), 0, snapstate.Flags{Remodel: true})
+		if err != nil {
+			return nil, err
+		}
+		installs = append(installs, ts)
+	}
+	if newModel.Gadget() != current.Gadget() {
+		ts, err := snapstateInstall(st, newModel.Gadget(), "", snap.R(0), 0, snapstate.Flags{Remodel: true})
+		if err != nil {
+			return nil, err
+		}
+		installs = append(installs, ts)
+	}
+	for _, name := range newModel.RequiredSnaps() {
+		ts, err := snapstateInstall(st, name, "", snap.R(0), 0, snapstate.Flags{Required: true})
+		if _, ok := err.(*snap.AlreadyInstalledError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		installs = append(installs, ts)
+	}
+	tss := installs
+
+	// nothing else happens until the device booted the new kernel,
+	// a rollback across the reboot undoes the remodel
+	if newModel.Kernel() != current.Kernel() {
+		checkKernel := st.NewTask("check-remodel-kernel", fmt.Sprintf(i18n.G("Check that kernel %q booted"), newModel.Kernel()))
+		checkKernel.Set("kernel", newModel.Kernel())
+		for _, ts := range installs {
+			checkKernel.WaitAll(ts)
+		}
+		tss = append(tss, state.NewTaskSet(checkKernel))
+	}
+
+	// setting the model comes last when the model name is kept, as
+	// its previous revision cannot be restored into the assertion
+	// database by undoing it
+	setModel := st.NewTask("set-model", fmt.Sprintf(i18n.G("Set new model assertion %s/%s"), newModel.BrandID(), newModel.Model()))
+	setModel.Set("new-model", string(asserts.Encode(newModel)))
+	for _, ts := range tss {
+		setModel.WaitAll(ts)
+	}
+	tss = append(tss, state.NewTaskSet(setModel))
+
+	// the serial is bound to the model, get a new one if it changed
+	if newModel.Model() != current.Model() {
+		genKey := st.NewTask("generate-device-key", i18n.G("Generate device key"))
+		genKey.WaitFor(setModel)
+		requestSerial := st.NewTask("request-serial", i18n.G("Request device serial"))
+		requestSerial.WaitFor(genKey)
+		tss = append(tss, state.NewTaskSet(genKey, requestSerial))
+	}
+
+	chg := st.NewChange("remodel", fmt.Sprintf(i18n.G("Remodel device to %s/%s"), newModel.BrandID(), newModel.Model()))
+	for _, ts := range tss {
+		chg.AddAll(ts)
+	}
+	return chg, nil
+}
+
 func init() {
 	snapstate.AddCheckSnapCallback(checkGadgetOrKernel)
 	snapstate.CanAutoRefresh = canAutoRefresh
+	snapstate.ModelSnapName = modelSnapName
+	configstate.ValidateProxyStore = validateProxyStore
 }
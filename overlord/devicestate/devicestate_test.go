@@ -40,8 +40,11 @@ import (
 	"github.com/snapcore/snapd/boot/boottest"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/httputil"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/hookstate/ctlcmd"
@@ -107,7 +110,7 @@ func (sto *fakeStore) ListRefresh([]*store.RefreshCandidate, *auth.UserState) ([
 	panic("fakeStore.ListRefresh not expected")
 }
 
-func (sto *fakeStore) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState) error {
+func (sto *fakeStore) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState, *store.DownloadOptions) error {
 	panic("fakeStore.Download not expected")
 }
 
@@ -875,6 +878,65 @@ func (s *deviceMgrSuite) TestDeviceAssertionsModelAndSerial(c *C) {
 	c.Check(ser.Serial(), Equals, "8989")
 }
 
+func (s *deviceMgrSuite) TestProxyStore(c *C) {
+	// nothing set
+	_, err := s.mgr.ProxyStore()
+	c.Check(err, Equals, state.ErrNoState)
+
+	// set but no matching assertion
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "proxy.store", "foo")
+	tr.Commit()
+	s.state.Unlock()
+	_, err = s.mgr.ProxyStore()
+	c.Check(err, Equals, state.ErrNoState)
+
+	operatorAcct := assertstest.NewAccount(s.storeSigning, "foo-operator", nil, "")
+	sto, err := s.storeSigning.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "foo",
+		"operator-id": operatorAcct.AccountID(),
+		"url":         "https://foo.internal:8080/",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	s.state.Lock()
+	err = assertstate.Add(s.state, operatorAcct)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, sto)
+	c.Assert(err, IsNil)
+	s.state.Unlock()
+
+	proxyStore, err := s.mgr.ProxyStore()
+	c.Assert(err, IsNil)
+	c.Check(proxyStore.Store(), Equals, "foo")
+	c.Check(proxyStore.URL().String(), Equals, "https://foo.internal:8080/")
+}
+
+func (s *deviceMgrSuite) TestValidateProxyStore(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	err := configstate.ValidateProxyStore(s.state, "foo")
+	c.Check(err, ErrorMatches, `cannot set proxy.store to "foo" without a matching store assertion`)
+
+	operatorAcct := assertstest.NewAccount(s.storeSigning, "foo-operator", nil, "")
+	sto, err := s.storeSigning.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "foo",
+		"operator-id": operatorAcct.AccountID(),
+		"url":         "https://foo.internal:8080/",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, operatorAcct)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, sto)
+	c.Assert(err, IsNil)
+
+	err = configstate.ValidateProxyStore(s.state, "foo")
+	c.Check(err, IsNil)
+}
+
 func (s *deviceMgrSuite) TestDeviceAssertionsDeviceSessionRequest(c *C) {
 	// nothing there
 	_, _, err := s.mgr.DeviceSessionRequest("NONCE-1")
@@ -1399,6 +1461,54 @@ name: krnl
 	c.Check(err, IsNil)
 }
 
+func (s *deviceMgrSuite) TestCheckKernelRemodel(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRemodel(c)
+
+	si := &snap.SideInfo{RealName: "pc-kernel", Revision: snap.R(1), SnapID: "pc-kernel-id"}
+	snaptest.MockSnap(c, "name: pc-kernel\ntype: kernel\nversion: 1", "", si)
+	snapstate.Set(s.state, "pc-kernel", &snapstate.SnapState{
+		SnapType: "kernel",
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+	})
+
+	s.setupSnapDecl(c, "other-kernel", "other-kernel-id", "my-brand")
+	otherKrnlInfo := snaptest.MockInfo(c, `
+type: kernel
+name: other-kernel
+`, nil)
+	otherKrnlInfo.SnapID = "other-kernel-id"
+
+	// no remodel in progress
+	err := devicestate.CheckGadgetOrKernel(s.state, otherKrnlInfo, nil, snapstate.Flags{Remodel: true})
+	c.Check(err, ErrorMatches, `cannot replace kernel snap with "other-kernel" without a remodel in progress`)
+
+	chg := s.state.NewChange("remodel", "...")
+	t := s.state.NewTask("set-model", "...")
+	t.Set("new-model", string(asserts.Encode(s.makeBrandModel(c, "other-model", map[string]interface{}{
+		"kernel": "other-kernel",
+	}))))
+	chg.AddTask(t)
+
+	// the kernel of the new model
+	err = devicestate.CheckGadgetOrKernel(s.state, otherKrnlInfo, nil, snapstate.Flags{Remodel: true})
+	c.Check(err, IsNil)
+
+	// but not another one
+	s.setupSnapDecl(c, "krnl", "krnl-id", "my-brand")
+	krnlInfo := snaptest.MockInfo(c, `
+type: kernel
+name: krnl
+`, nil)
+	krnlInfo.SnapID = "krnl-id"
+	err = devicestate.CheckGadgetOrKernel(s.state, krnlInfo, nil, snapstate.Flags{Remodel: true})
+	c.Check(err, ErrorMatches, `cannot install kernel "krnl", new model assertion requests "other-kernel"`)
+}
+
 func (s *deviceMgrSuite) makeModelAssertionInState(c *C, brandID, model string, extras map[string]string) {
 	headers := map[string]interface{}{
 		"series":    "16",
@@ -1550,3 +1660,441 @@ func (s *deviceMgrSuite) TestCanAutoRefreshOnClassic(c *C) {
 	s.state.Set("seeded", false)
 	c.Check(canAutoRefresh(), Equals, false)
 }
+
+func (s *deviceMgrSuite) makeBrandModel(c *C, model string, extras map[string]interface{}) *asserts.Model {
+	headers := map[string]interface{}{
+		"series":       "16",
+		"brand-id":     "my-brand",
+		"model":        model,
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+		"architecture": "amd64",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}
+	for k, v := range extras {
+		headers[k] = v
+	}
+	a, err := s.brandSigning.Sign(asserts.ModelType, headers, nil, "")
+	c.Assert(err, IsNil)
+	return a.(*asserts.Model)
+}
+
+func (s *deviceMgrSuite) setupRemodel(c *C) {
+	s.setupBrands(c)
+	err := assertstate.Add(s.state, s.makeBrandModel(c, "my-model", map[string]interface{}{
+		"required-snaps": []interface{}{"foo"},
+	}))
+	c.Assert(err, IsNil)
+	err = auth.SetDevice(s.state, &auth.DeviceState{
+		Brand:           "my-brand",
+		Model:           "my-model",
+		Serial:          "serial-1",
+		KeyID:           "key-id",
+		SessionMacaroon: "macaroon",
+	})
+	c.Assert(err, IsNil)
+	s.state.Set("seeded", true)
+}
+
+func (s *deviceMgrSuite) TestRemodelUnhappy(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	newModel := s.makeBrandModel(c, "other-model", nil)
+
+	// not seeded
+	_, err := devicestate.Remodel(s.state, newModel)
+	c.Check(err, ErrorMatches, "cannot remodel until fully seeded")
+
+	// no current model
+	s.state.Set("seeded", true)
+	_, err = devicestate.Remodel(s.state, newModel)
+	c.Check(err, ErrorMatches, "cannot remodel without a current model assertion")
+
+	s.setupRemodel(c)
+
+	otherBrandPrivKey, _ := assertstest.GenerateKey(752)
+	otherBrandSigning := assertstest.NewSigningDB("other-brand", otherBrandPrivKey)
+	a, err := otherBrandSigning.Sign(asserts.ModelType, map[string]interface{}{
+		"series":       "16",
+		"brand-id":     "other-brand",
+		"model":        "other-model",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+		"architecture": "amd64",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	_, err = devicestate.Remodel(s.state, a.(*asserts.Model))
+	c.Check(err, ErrorMatches, `cannot remodel to a different brand \(from "my-brand" to "other-brand"\)`)
+
+	for _, t := range []struct {
+		model  string
+		extras map[string]interface{}
+		errStr string
+	}{
+		{"other-model", map[string]interface{}{"architecture": "armhf"}, `cannot remodel to a different architecture \(from "amd64" to "armhf"\)`},
+		{"other-model", map[string]interface{}{"classic": "true", "gadget": ""}, `cannot remodel between classic and non-classic models`},
+		{"my-model", nil, `cannot remodel to revision 0 of model "my-model", current revision is 0`},
+	} {
+		headers := map[string]interface{}{
+			"series":       "16",
+			"brand-id":     "my-brand",
+			"model":        t.model,
+			"gadget":       "pc",
+			"kernel":       "pc-kernel",
+			"architecture": "amd64",
+			"timestamp":    time.Now().Format(time.RFC3339),
+		}
+		for k, v := range t.extras {
+			headers[k] = v
+		}
+		if headers["classic"] == "true" {
+			delete(headers, "gadget")
+			delete(headers, "kernel")
+		}
+		a, err := s.brandSigning.Sign(asserts.ModelType, headers, nil, "")
+		c.Assert(err, IsNil)
+		_, err = devicestate.Remodel(s.state, a.(*asserts.Model))
+		c.Check(err, ErrorMatches, t.errStr)
+	}
+
+	// the new model must be verifiable
+	otherSigning := assertstest.NewSigningDB("my-brand", otherBrandPrivKey)
+	a, err = otherSigning.Sign(asserts.ModelType, map[string]interface{}{
+		"series":       "16",
+		"brand-id":     "my-brand",
+		"model":        "other-model",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+		"architecture": "amd64",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	_, err = devicestate.Remodel(s.state, a.(*asserts.Model))
+	c.Check(err, ErrorMatches, `no matching public key.*`)
+}
+
+func (s *deviceMgrSuite) TestRemodelTasksSwitchKernelGadgetAndReregister(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRemodel(c)
+
+	var installed []string
+	restore := devicestate.MockSnapstateInstall(func(st *state.State, name, channel string, revision snap.Revision, userID int, flags snapstate.Flags) (*state.TaskSet, error) {
+		if name == "foo" {
+			return nil, &snap.AlreadyInstalledError{Snap: name}
+		}
+		c.Check(flags.Required, Equals, name == "bar")
+		c.Check(flags.Remodel, Equals, name != "bar")
+		installed = append(installed, name)
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
+		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
+		tInstall.WaitFor(tDownload)
+		return state.NewTaskSet(tDownload, tInstall), nil
+	})
+	defer restore()
+
+	newModel := s.makeBrandModel(c, "other-model", map[string]interface{}{
+		"gadget":         "other-pc",
+		"kernel":         "other-kernel",
+		"required-snaps": []interface{}{"foo", "bar"},
+	})
+	chg, err := devicestate.Remodel(s.state, newModel)
+	c.Assert(err, IsNil)
+	c.Check(chg.Kind(), Equals, "remodel")
+	c.Check(chg.Summary(), Equals, "Remodel device to my-brand/other-model")
+	c.Check(installed, DeepEquals, []string{"other-kernel", "other-pc", "bar"})
+
+	// the old kernel and gadget are not removed
+	tasks := chg.Tasks()
+	c.Assert(tasks, HasLen, 10)
+	checkKernel := tasks[6]
+	c.Check(checkKernel.Kind(), Equals, "check-remodel-kernel")
+	c.Check(checkKernel.Summary(), Equals, `Check that kernel "other-kernel" booted`)
+	c.Check(checkKernel.WaitTasks(), DeepEquals, tasks[:6])
+	setModel := tasks[7]
+	c.Check(setModel.Kind(), Equals, "set-model")
+	c.Check(setModel.Summary(), Equals, "Set new model assertion my-brand/other-model")
+	c.Check(setModel.WaitTasks(), DeepEquals, tasks[:7])
+	genKey := tasks[8]
+	c.Check(genKey.Kind(), Equals, "generate-device-key")
+	c.Check(genKey.WaitTasks(), DeepEquals, []*state.Task{setModel})
+	requestSerial := tasks[9]
+	c.Check(requestSerial.Kind(), Equals, "request-serial")
+	c.Check(requestSerial.WaitTasks(), DeepEquals, []*state.Task{genKey})
+
+	// only one remodel at a time
+	_, err = devicestate.Remodel(s.state, newModel)
+	c.Check(err, ErrorMatches, "cannot remodel while another remodel is in progress")
+}
+
+func (s *deviceMgrSuite) TestRemodelNewRevisionSameModel(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRemodel(c)
+
+	restore := devicestate.MockSnapstateInstall(func(st *state.State, name, channel string, revision snap.Revision, userID int, flags snapstate.Flags) (*state.TaskSet, error) {
+		return nil, &snap.AlreadyInstalledError{Snap: name}
+	})
+	defer restore()
+
+	newModel := s.makeBrandModel(c, "my-model", map[string]interface{}{
+		"revision":       "1",
+		"required-snaps": []interface{}{"foo"},
+	})
+	chg, err := devicestate.Remodel(s.state, newModel)
+	c.Assert(err, IsNil)
+	c.Assert(chg.Tasks(), HasLen, 1)
+	c.Check(chg.Tasks()[0].Kind(), Equals, "set-model")
+
+	s.state.Unlock()
+	s.settle()
+	s.state.Lock()
+
+	c.Check(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+
+	model, err := devicestate.Model(s.state)
+	c.Assert(err, IsNil)
+	c.Check(model.Revision(), Equals, 1)
+
+	// the serial still matches
+	device, err := auth.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device, DeepEquals, &auth.DeviceState{
+		Brand:           "my-brand",
+		Model:           "my-model",
+		Serial:          "serial-1",
+		KeyID:           "key-id",
+		SessionMacaroon: "macaroon",
+	})
+}
+
+func (s *deviceMgrSuite) TestDoCheckRemodelKernel(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var bootName string
+	var bootErr error
+	restore := devicestate.MockSnapstateCurrentBootNameAndRevision(func(typ snap.Type) (string, snap.Revision, error) {
+		c.Check(typ, Equals, snap.TypeKernel)
+		return bootName, snap.R(1), bootErr
+	})
+	defer restore()
+
+	for _, t := range []struct {
+		restarting bool
+		bootName   string
+		bootErr    error
+		status     state.Status
+		errStr     string
+	}{
+		{restarting: true, status: state.DoingStatus},
+		{bootErr: snapstate.ErrBootNameAndRevisionAgain, status: state.DoingStatus},
+		{bootName: "pc-kernel", status: state.ErrorStatus, errStr: `(?s).*cannot finish remodel, kernel "other-kernel" did not boot, there was a rollback to "pc-kernel" across reboot.*`},
+		{bootName: "other-kernel", status: state.DoneStatus},
+	} {
+		bootName = t.bootName
+		bootErr = t.bootErr
+		state.MockRestarting(s.state, t.restarting)
+
+		chg := s.state.NewChange("remodel", "...")
+		task := s.state.NewTask("check-remodel-kernel", "...")
+		task.Set("kernel", "other-kernel")
+		chg.AddTask(task)
+
+		s.state.Unlock()
+		s.mgr.Ensure()
+		s.mgr.Wait()
+		s.state.Lock()
+
+		c.Check(task.Status(), Equals, t.status)
+		if t.errStr != "" {
+			c.Check(chg.Err(), ErrorMatches, t.errStr)
+		}
+		if !chg.Status().Ready() {
+			chg.Abort()
+		}
+	}
+	state.MockRestarting(s.state, false)
+}
+
+func (s *deviceMgrSuite) setupRequiredSnap(c *C, name string) {
+	si := &snap.SideInfo{RealName: name, Revision: snap.R(1)}
+	snaptest.MockSnap(c, fmt.Sprintf("name: %s\nversion: 1", name), "", si)
+	snapstate.Set(s.state, name, &snapstate.SnapState{
+		SnapType: "app",
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+		Flags:    snapstate.Flags{Required: true},
+	})
+}
+
+func (s *deviceMgrSuite) TestDoSetModelUndo(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRemodel(c)
+	s.setupRequiredSnap(c, "foo")
+
+	newModel := s.makeBrandModel(c, "other-model", nil)
+
+	chg := s.state.NewChange("remodel", "...")
+	t := s.state.NewTask("set-model", "...")
+	t.Set("new-model", string(asserts.Encode(newModel)))
+	chg.AddTask(t)
+	// a broken follow-up task triggers the undo
+	t2 := s.state.NewTask("set-model", "...")
+	t2.Set("new-model", "garbage")
+	t2.WaitFor(t)
+	chg.AddTask(t2)
+
+	s.state.Unlock()
+	s.settle()
+	s.state.Lock()
+
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot decode new model assertion.*`)
+	c.Check(t.Status(), Equals, state.UndoneStatus)
+
+	// the new model assertion was added but is not the device one
+	// anymore
+	model, err := assertstate.DB(s.state).Find(asserts.ModelType, map[string]string{
+		"series":   "16",
+		"brand-id": "my-brand",
+		"model":    "other-model",
+	})
+	c.Assert(err, IsNil)
+	c.Check(model, NotNil)
+
+	// but the old device identity is restored
+	device, err := auth.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device, DeepEquals, &auth.DeviceState{
+		Brand:           "my-brand",
+		Model:           "my-model",
+		Serial:          "serial-1",
+		KeyID:           "key-id",
+		SessionMacaroon: "macaroon",
+	})
+
+	// and foo is required again
+	var snapst snapstate.SnapState
+	err = snapstate.Get(s.state, "foo", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Required, Equals, true)
+}
+
+func (s *deviceMgrSuite) TestDoSetModelNewModel(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRemodel(c)
+
+	newModel := s.makeBrandModel(c, "other-model", nil)
+
+	chg := s.state.NewChange("remodel", "...")
+	t := s.state.NewTask("set-model", "...")
+	t.Set("new-model", string(asserts.Encode(newModel)))
+	chg.AddTask(t)
+
+	s.state.Unlock()
+	s.settle()
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	model, err := devicestate.Model(s.state)
+	c.Assert(err, IsNil)
+	c.Check(model.Model(), Equals, "other-model")
+
+	// the serial and session are dropped, the key is kept
+	device, err := auth.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device, DeepEquals, &auth.DeviceState{
+		Brand: "my-brand",
+		Model: "other-model",
+		KeyID: "key-id",
+	})
+}
+
+func (s *deviceMgrSuite) TestDoSetModelKeepsUnrequiredSnaps(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRemodel(c)
+	s.setupRequiredSnap(c, "foo")
+	s.setupRequiredSnap(c, "bar")
+	// the old gadget is kept as well
+	si := &snap.SideInfo{RealName: "pc", Revision: snap.R(1)}
+	snaptest.MockSnap(c, "name: pc\ntype: gadget\nversion: 1", "", si)
+	snapstate.Set(s.state, "pc", &snapstate.SnapState{
+		SnapType: "gadget",
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+	})
+
+	// foo is not required anymore
+	newModel := s.makeBrandModel(c, "my-model", map[string]interface{}{
+		"revision":       "1",
+		"gadget":         "other-pc",
+		"required-snaps": []interface{}{"bar"},
+	})
+
+	chg := s.state.NewChange("remodel", "...")
+	t := s.state.NewTask("set-model", "...")
+	t.Set("new-model", string(asserts.Encode(newModel)))
+	chg.AddTask(t)
+
+	s.state.Unlock()
+	s.settle()
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+
+	var unrequired []string
+	c.Assert(t.Get("unrequired", &unrequired), IsNil)
+	c.Check(unrequired, DeepEquals, []string{"foo"})
+
+	// all the snaps and their data are still there
+	for _, t := range []struct {
+		name     string
+		required bool
+	}{
+		{"foo", false},
+		{"bar", true},
+		{"pc", false},
+	} {
+		var snapst snapstate.SnapState
+		err := snapstate.Get(s.state, t.name, &snapst)
+		c.Assert(err, IsNil)
+		c.Check(snapst.Active, Equals, true)
+		c.Check(snapst.Required, Equals, t.required)
+		c.Check(osutil.IsDirectory(snap.MountDir(t.name, snap.R(1))), Equals, true)
+	}
+}
+
+func (s *deviceMgrSuite) TestGadgetInfoPrefersModelGadget(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRemodel(c)
+
+	for _, name := range []string{"aaa-pc", "pc", "zzz-pc"} {
+		si := &snap.SideInfo{RealName: name, Revision: snap.R(1)}
+		snaptest.MockSnap(c, fmt.Sprintf("name: %s\ntype: gadget\nversion: 1", name), "", si)
+		snapstate.Set(s.state, name, &snapstate.SnapState{
+			SnapType: "gadget",
+			Active:   true,
+			Sequence: []*snap.SideInfo{si},
+			Current:  si.Revision,
+		})
+	}
+
+	info, err := snapstate.GadgetInfo(s.state)
+	c.Assert(err, IsNil)
+	c.Check(info.Name(), Equals, "pc")
+}
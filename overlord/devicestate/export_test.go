@@ -23,7 +23,9 @@ import (
 	"time"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
 )
 
 func MockKeyLength(n int) (restore func()) {
@@ -104,6 +106,22 @@ func (m *DeviceManager) SetBootOkRan(b bool) {
 	m.bootOkRan = b
 }
 
+func MockSnapstateInstall(f func(st *state.State, name, channel string, revision snap.Revision, userID int, flags snapstate.Flags) (*state.TaskSet, error)) (restore func()) {
+	old := snapstateInstall
+	snapstateInstall = f
+	return func() {
+		snapstateInstall = old
+	}
+}
+
+func MockSnapstateCurrentBootNameAndRevision(f func(typ snap.Type) (string, snap.Revision, error)) (restore func()) {
+	old := snapstateCurrentBootNameAndRevision
+	snapstateCurrentBootNameAndRevision = f
+	return func() {
+		snapstateCurrentBootNameAndRevision = old
+	}
+}
+
 var (
 	ImportAssertionsFromSeed = importAssertionsFromSeed
 	CheckGadgetOrKernel      = checkGadgetOrKernel
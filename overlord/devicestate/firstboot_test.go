@@ -40,7 +40,6 @@ import (
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/snapstate"
-	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
@@ -279,9 +278,7 @@ snaps:
 	c.Check(osutil.FileExists(filepath.Join(dirs.SnapMountDir, "local", "x1", "meta", "snap.yaml")), Equals, true)
 
 	// verify
-	r, err := os.Open(dirs.SnapStateFile)
-	c.Assert(err, IsNil)
-	state, err := state.ReadState(nil, r)
+	state, err := overlord.ReadStateFile(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
 
 	state.Lock()
@@ -446,9 +443,7 @@ snaps:
 	c.Check(osutil.FileExists(filepath.Join(dirs.SnapMountDir, "bar", "65", "meta", "snap.yaml")), Equals, true)
 
 	// verify
-	r, err := os.Open(dirs.SnapStateFile)
-	c.Assert(err, IsNil)
-	state, err := state.ReadState(nil, r)
+	state, err := overlord.ReadStateFile(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
 
 	state.Lock()
@@ -40,6 +40,7 @@ import (
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
 )
 
 func (m *DeviceManager) doMarkSeeded(t *state.Task, _ *tomb.Tomb) error {
@@ -51,6 +52,148 @@ func (m *DeviceManager) doMarkSeeded(t *state.Task, _ *tomb.Tomb) error {
 	return nil
 }
 
+var snapstateCurrentBootNameAndRevision = snapstate.CurrentBootNameAndRevision
+
+func (m *DeviceManager) doCheckRemodelKernel(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var kernel string
+	if err := t.Get("kernel", &kernel); err != nil {
+		return err
+	}
+
+	if st.Restarting() {
+		// don't continue until we are in the rebooted system
+		t.Logf("Waiting for restart...")
+		return &state.Retry{}
+	}
+	name, _, err := snapstateCurrentBootNameAndRevision(snap.TypeKernel)
+	if err == snapstate.ErrBootNameAndRevisionAgain {
+		return &state.Retry{After: 5 * time.Second}
+	}
+	if err != nil {
+		return err
+	}
+	if name != kernel {
+		return fmt.Errorf("cannot finish remodel, kernel %q did not boot, there was a rollback to %q across reboot", kernel, name)
+	}
+	return nil
+}
+
+// newModelFromTask returns the new model assertion a set-model task
+// sets.
+func newModelFromTask(t *state.Task) (*asserts.Model, error) {
+	var encoded string
+	if err := t.Get("new-model", &encoded); err != nil {
+		return nil, err
+	}
+	a, err := asserts.Decode([]byte(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("internal error: cannot decode new model assertion: %v", err)
+	}
+	newModel, ok := a.(*asserts.Model)
+	if !ok {
+		return nil, fmt.Errorf("internal error: new model assertion has unexpected type %q", a.Type().Name)
+	}
+	return newModel, nil
+}
+
+func (m *DeviceManager) doSetModel(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	newModel, err := newModelFromTask(t)
+	if err != nil {
+		return err
+	}
+
+	device, err := auth.Device(st)
+	if err != nil {
+		return err
+	}
+
+	current, err := Model(st)
+	if err != nil {
+		return err
+	}
+
+	err = assertstate.Add(st, newModel)
+	if err != nil && !asserts.IsUnaccceptedUpdate(err) {
+		return err
+	}
+
+	// the snaps the new model does not require anymore are kept,
+	// they can be removed as any other snap from now on
+	unrequired, err := setRequired(st, unrequiredSnaps(current, newModel), false)
+	if err != nil {
+		return err
+	}
+
+	// save for undoSetModel
+	t.Set("old-device", device)
+	t.Set("unrequired", unrequired)
+
+	newDevice := *device
+	if device.Model != newModel.Model() {
+		// the serial, and the store session based on it, are
+		// bound to the model
+		newDevice.Serial = ""
+		newDevice.SessionMacaroon = ""
+	}
+	newDevice.Brand = newModel.BrandID()
+	newDevice.Model = newModel.Model()
+	return auth.SetDevice(st, &newDevice)
+}
+
+// undoSetModel restores the device identity of the previous model,
+// the new model assertion stays in the assertion database but is not
+// the one of the device anymore. Remodel makes sure that this only
+// happens when the model name changed.
+func (m *DeviceManager) undoSetModel(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var oldDevice auth.DeviceState
+	if err := t.Get("old-device", &oldDevice); err != nil {
+		return err
+	}
+	var unrequired []string
+	if err := t.Get("unrequired", &unrequired); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if _, err := setRequired(st, unrequired, true); err != nil {
+		return err
+	}
+	return auth.SetDevice(st, &oldDevice)
+}
+
+// setRequired sets the required flag of the given installed snaps to
+// the given value and returns the names of the snaps it changed.
+func setRequired(st *state.State, names []string, required bool) ([]string, error) {
+	var changed []string
+	for _, name := range names {
+		var snapst snapstate.SnapState
+		err := snapstate.Get(st, name, &snapst)
+		if err == state.ErrNoState {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if snapst.Required == required {
+			continue
+		}
+		snapst.Required = required
+		snapstate.Set(st, name, &snapst)
+		changed = append(changed, name)
+	}
+	return changed, nil
+}
+
 func useStaging() bool {
 	return osutil.GetenvBool("SNAPPY_USE_STAGING_STORE")
 }
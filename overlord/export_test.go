@@ -65,3 +65,13 @@ func MockStoreNew(new func(*store.Config, auth.AuthContext) *store.Store) (resto
 		storeNew = store.New
 	}
 }
+
+// MockMinCompactSize sets the size under which the state journal is
+// never compacted.
+func MockMinCompactSize(size int64) (restore func()) {
+	old := minCompactSize
+	minCompactSize = size
+	return func() {
+		minCompactSize = old
+	}
+}
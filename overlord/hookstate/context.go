@@ -175,6 +175,11 @@ func (c *Context) State() *state.State {
 	return c.task.State()
 }
 
+// Task returns the task running the hook of this context.
+func (c *Context) Task() *state.Task {
+	return c.task
+}
+
 // Cached returns the cached value associated with the provided key. It returns
 // nil if there is no entry for key. Note that the context needs to be locked
 // and unlocked by the caller.
@@ -49,6 +49,7 @@ func (s *contextSuite) SetUpTest(c *C) {
 func (s *contextSuite) TestHookSetup(c *C) {
 	c.Check(s.context.HookName(), Equals, "test-hook")
 	c.Check(s.context.SnapName(), Equals, "test-snap")
+	c.Check(s.context.Task(), Equals, s.task)
 }
 
 func (s *contextSuite) TestSetAndGet(c *C) {
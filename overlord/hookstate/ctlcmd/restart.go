@@ -0,0 +1,55 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ctlcmd
+
+import (
+	"github.com/snapcore/snapd/i18n/dumb"
+	"github.com/snapcore/snapd/overlord/servicestate"
+)
+
+type restartCommand struct {
+	baseCommand
+	Positional struct {
+		ServiceNames []string `positional-arg-name:"<service>" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var shortRestartHelp = i18n.G("Restart services")
+var longRestartHelp = i18n.G(`
+The restart command restarts the given services of the snap once the hook has
+finished.
+
+A service is named either <snap> (meaning all the services of the snap) or
+<snap>.<app>:
+
+    $ snapctl restart mysnap.mysvc
+`)
+
+func init() {
+	addCommand("restart", shortRestartHelp, longRestartHelp, func() command { return &restartCommand{} })
+}
+
+func (c *restartCommand) Execute(args []string) error {
+	inst := &servicestate.Instruction{
+		Action: "restart",
+		Names:  c.Positional.ServiceNames,
+	}
+	return runServiceCommand(c.context(), inst, c.Positional.ServiceNames)
+}
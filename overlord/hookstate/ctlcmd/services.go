@@ -0,0 +1,194 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ctlcmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/snapcore/snapd/i18n/dumb"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/wrappers"
+)
+
+type servicesCommand struct {
+	baseCommand
+	Positional struct {
+		ServiceNames []string `positional-arg-name:"<service>"`
+	} `positional-args:"yes"`
+}
+
+var shortServicesHelp = i18n.G("Query the status of services")
+var longServicesHelp = i18n.G(`
+The services command lists information about the services specified, or about
+all the services of the snap.
+
+    $ snapctl services mysnap.mysvc
+`)
+
+func init() {
+	addCommand("services", shortServicesHelp, longServicesHelp, func() command { return &servicesCommand{} })
+}
+
+type byAppName []*snap.AppInfo
+
+func (a byAppName) Len() int           { return len(a) }
+func (a byAppName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byAppName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// getServiceInfos returns the services of the snap of the context
+// that are named by serviceNames, which can be either the snap name
+// (meaning all of its services) or <snap>.<app>. Services of other
+// snaps cannot be named.
+func getServiceInfos(context *hookstate.Context, serviceNames []string) ([]*snap.AppInfo, error) {
+	snapName := context.SnapName()
+
+	st := context.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(st, snapName, &snapst); err != nil {
+		return nil, err
+	}
+	info, err := snapst.CurrentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(serviceNames) == 0 {
+		serviceNames = []string{snapName}
+	}
+
+	seen := make(map[string]bool)
+	var svcs []*snap.AppInfo
+	for _, name := range serviceNames {
+		parts := strings.SplitN(name, ".", 2)
+		if parts[0] != snapName {
+			return nil, fmt.Errorf(i18n.G("cannot use services of snap %q from snap %q"), parts[0], snapName)
+		}
+		if len(parts) == 1 {
+			// all the services of the snap
+			for _, app := range info.Apps {
+				if app.IsService() && !seen[app.Name] {
+					seen[app.Name] = true
+					svcs = append(svcs, app)
+				}
+			}
+			continue
+		}
+		app, ok := info.Apps[parts[1]]
+		if !ok || !app.IsService() {
+			return nil, fmt.Errorf(i18n.G("unknown service: %q"), name)
+		}
+		if !seen[app.Name] {
+			seen[app.Name] = true
+			svcs = append(svcs, app)
+		}
+	}
+	sort.Sort(byAppName(svcs))
+
+	return svcs, nil
+}
+
+// runServiceCommand creates the service-control tasks for inst and
+// queues them in the change of the hook, to run once the hook is
+// done. Tasks that were waiting for the hook wait for them as well.
+func runServiceCommand(context *hookstate.Context, inst *servicestate.Instruction, serviceNames []string) error {
+	if context == nil {
+		return fmt.Errorf(i18n.G("cannot %s without a context"), inst.Action)
+	}
+
+	svcs, err := getServiceInfos(context, serviceNames)
+	if err != nil {
+		return err
+	}
+
+	st := context.State()
+	st.Lock()
+	defer st.Unlock()
+
+	hookTask := context.Task()
+	chg := hookTask.Change()
+	if chg == nil {
+		return fmt.Errorf("internal error: cannot queue service command: hook task is not in a change")
+	}
+
+	tss, err := servicestate.Control(st, svcs, inst, context)
+	if err != nil {
+		return err
+	}
+
+	haltTasks := hookTask.HaltTasks()
+	for _, ts := range tss {
+		ts.WaitFor(hookTask)
+		for _, t := range haltTasks {
+			t.WaitAll(ts)
+		}
+		chg.AddAll(ts)
+	}
+
+	return nil
+}
+
+func (c *servicesCommand) Execute(args []string) error {
+	context := c.context()
+	if context == nil {
+		return fmt.Errorf("cannot query services without a context")
+	}
+
+	svcs, err := getServiceInfos(context, c.Positional.ServiceNames)
+	if err != nil {
+		return err
+	}
+	if len(svcs) == 0 {
+		c.errorf(i18n.G("There are no services provided by snap %q.\n"), context.SnapName())
+		return nil
+	}
+
+	sts, err := wrappers.ServicesStatus(svcs)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 5, 3, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, i18n.G("Service\tStartup\tCurrent"))
+
+	for i, svc := range svcs {
+		startup := i18n.G("disabled")
+		if sts[i].UnitFileState == "enabled" {
+			startup = i18n.G("enabled")
+		}
+		current := i18n.G("inactive")
+		if sts[i].ActiveState == "active" {
+			current = i18n.G("active")
+		}
+		fmt.Fprintf(w, "%s.%s\t%s\t%s\n", svc.Snap.Name(), svc.Name, startup, current)
+	}
+
+	return nil
+}
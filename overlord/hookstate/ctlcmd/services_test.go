@@ -0,0 +1,217 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ctlcmd_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/hookstate/ctlcmd"
+	"github.com/snapcore/snapd/overlord/hookstate/hooktest"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/systemd"
+)
+
+type servicesSuite struct {
+	state       *state.State
+	chg         *state.Change
+	hookTask    *state.Task
+	nextTask    *state.Task
+	mockContext *hookstate.Context
+
+	prevctlCmd func(...string) ([]byte, error)
+}
+
+var _ = Suite(&servicesSuite{})
+
+const servicesSnapYaml = `name: test-snap
+version: 1
+apps:
+ cmd:
+  command: cmd
+ svc1:
+  command: svc1
+  daemon: simple
+ svc2:
+  command: svc2
+  daemon: forking
+`
+
+func (s *servicesSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+
+	s.prevctlCmd = systemd.SystemctlCmd
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		if cmd[0] == "show" && cmd[2] == "snap.test-snap.svc1.service" {
+			return []byte("Id=snap.test-snap.svc1.service\nActiveState=active\nUnitFileState=enabled\n"), nil
+		}
+		return []byte("ActiveState=inactive\nUnitFileState=disabled\n"), nil
+	}
+
+	s.state = state.New(nil)
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	si := &snap.SideInfo{RealName: "test-snap", Revision: snap.R(1)}
+	snaptest.MockSnap(c, servicesSnapYaml, "", si)
+	snapstate.Set(s.state, "test-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+	})
+
+	s.chg = s.state.NewChange("install", "...")
+	s.hookTask = s.state.NewTask("run-hook", "...")
+	s.nextTask = s.state.NewTask("start-snap-services", "...")
+	s.nextTask.WaitFor(s.hookTask)
+	s.chg.AddTask(s.hookTask)
+	s.chg.AddTask(s.nextTask)
+
+	setup := &hookstate.HookSetup{Snap: "test-snap", Revision: snap.R(1), Hook: "install"}
+	var err error
+	s.mockContext, err = hookstate.NewContext(s.hookTask, setup, hooktest.NewMockHandler())
+	c.Assert(err, IsNil)
+}
+
+func (s *servicesSuite) TearDownTest(c *C) {
+	systemd.SystemctlCmd = s.prevctlCmd
+	dirs.SetRootDir("")
+}
+
+func (s *servicesSuite) queuedTasks() []*state.Task {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var tasks []*state.Task
+	for _, t := range s.chg.Tasks() {
+		if t.Kind() == "service-control" {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+func (s *servicesSuite) TestServices(c *C) {
+	stdout, stderr, err := ctlcmd.Run(s.mockContext, []string{"services"})
+	c.Assert(err, IsNil)
+	c.Check(string(stdout), Equals, `Service         Startup   Current
+test-snap.svc1  enabled   active
+test-snap.svc2  disabled  inactive
+`)
+	c.Check(string(stderr), Equals, "")
+
+	stdout, _, err = ctlcmd.Run(s.mockContext, []string{"services", "test-snap.svc2"})
+	c.Assert(err, IsNil)
+	c.Check(string(stdout), Equals, `Service         Startup   Current
+test-snap.svc2  disabled  inactive
+`)
+}
+
+func (s *servicesSuite) TestQueuesAfterHook(c *C) {
+	for _, t := range []struct {
+		args    []string
+		action  string
+		enable  bool
+		disable bool
+	}{
+		{[]string{"start", "test-snap.svc1"}, "start", false, false},
+		{[]string{"start", "--enable", "test-snap.svc1"}, "start", true, false},
+		{[]string{"stop", "test-snap.svc1"}, "stop", false, false},
+		{[]string{"stop", "--disable", "test-snap.svc1"}, "stop", false, true},
+		{[]string{"restart", "test-snap.svc1"}, "restart", false, false},
+	} {
+		s.SetUpTest(c)
+
+		_, _, err := ctlcmd.Run(s.mockContext, t.args)
+		c.Assert(err, IsNil, Commentf("%v", t.args))
+
+		tasks := s.queuedTasks()
+		c.Assert(tasks, HasLen, 1)
+
+		s.state.Lock()
+		var action map[string]interface{}
+		c.Assert(tasks[0].Get("service-action", &action), IsNil)
+		c.Check(action["action"], Equals, t.action)
+		c.Check(action["services"], DeepEquals, []interface{}{"svc1"})
+		c.Check(action["enable"] == true, Equals, t.enable)
+		c.Check(action["disable"] == true, Equals, t.disable)
+		c.Check(tasks[0].WaitTasks(), DeepEquals, []*state.Task{s.hookTask})
+		c.Check(s.nextTask.WaitTasks(), DeepEquals, []*state.Task{s.hookTask, tasks[0]})
+		s.state.Unlock()
+
+		s.TearDownTest(c)
+	}
+}
+
+func (s *servicesSuite) TestRestartAllServicesOfSnap(c *C) {
+	_, _, err := ctlcmd.Run(s.mockContext, []string{"restart", "test-snap", "test-snap.svc2"})
+	c.Assert(err, IsNil)
+
+	tasks := s.queuedTasks()
+	c.Assert(tasks, HasLen, 1)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var action map[string]interface{}
+	c.Assert(tasks[0].Get("service-action", &action), IsNil)
+	c.Check(action["services"], DeepEquals, []interface{}{"svc1", "svc2"})
+}
+
+func (s *servicesSuite) TestQueueTwice(c *C) {
+	_, _, err := ctlcmd.Run(s.mockContext, []string{"stop", "test-snap.svc1"})
+	c.Assert(err, IsNil)
+	_, _, err = ctlcmd.Run(s.mockContext, []string{"start", "test-snap.svc1"})
+	c.Assert(err, IsNil)
+
+	c.Check(s.queuedTasks(), HasLen, 2)
+}
+
+func (s *servicesSuite) TestErrors(c *C) {
+	for _, t := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"start"}, "the required argument `<service> \\(at least 1 argument\\)` was not provided"},
+		{[]string{"start", "other-snap.svc"}, `cannot use services of snap "other-snap" from snap "test-snap"`},
+		{[]string{"stop", "other-snap"}, `cannot use services of snap "other-snap" from snap "test-snap"`},
+		{[]string{"restart", "test-snap.foo"}, `unknown service: "test-snap.foo"`},
+		{[]string{"restart", "test-snap.cmd"}, `unknown service: "test-snap.cmd"`},
+		{[]string{"services", "other-snap"}, `cannot use services of snap "other-snap" from snap "test-snap"`},
+	} {
+		_, _, err := ctlcmd.Run(s.mockContext, t.args)
+		c.Check(err, ErrorMatches, t.err, Commentf("%v", t.args))
+	}
+	c.Check(s.queuedTasks(), HasLen, 0)
+}
+
+func (s *servicesSuite) TestConflict(c *C) {
+	s.state.Lock()
+	t := s.state.NewTask("link-snap", "...")
+	t.Set("snap-setup", &snapstate.SnapSetup{SideInfo: &snap.SideInfo{RealName: "test-snap"}})
+	s.state.NewChange("refresh", "...").AddTask(t)
+	s.state.Unlock()
+
+	_, _, err := ctlcmd.Run(s.mockContext, []string{"restart", "test-snap"})
+	c.Check(err, ErrorMatches, `snap "test-snap" has changes in progress`)
+}
@@ -0,0 +1,57 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ctlcmd
+
+import (
+	"github.com/snapcore/snapd/i18n/dumb"
+	"github.com/snapcore/snapd/overlord/servicestate"
+)
+
+type stopCommand struct {
+	baseCommand
+	Positional struct {
+		ServiceNames []string `positional-arg-name:"<service>" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+	Disable bool `long:"disable" description:"As well as stopping the service now, arrange for it to no longer be started on boot."`
+}
+
+var shortStopHelp = i18n.G("Stop services")
+var longStopHelp = i18n.G(`
+The stop command stops, and optionally disables, the given services of the
+snap once the hook has finished.
+
+A service is named either <snap> (meaning all the services of the snap) or
+<snap>.<app>:
+
+    $ snapctl stop --disable mysnap.mysvc
+`)
+
+func init() {
+	addCommand("stop", shortStopHelp, longStopHelp, func() command { return &stopCommand{} })
+}
+
+func (c *stopCommand) Execute(args []string) error {
+	inst := &servicestate.Instruction{
+		Action:  "stop",
+		Names:   c.Positional.ServiceNames,
+		Disable: c.Disable,
+	}
+	return runServiceCommand(c.context(), inst, c.Positional.ServiceNames)
+}
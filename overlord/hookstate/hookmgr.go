@@ -91,7 +91,9 @@ func Manager(s *state.State) (*HookManager, error) {
 		contexts:   make(map[string]*Context),
 	}
 
-	runner.AddHandler("run-hook", manager.doRunHook, nil)
+	runner.AddHandler("run-hook", manager.doRunHook, manager.undoRunHook)
+
+	setupHooks(manager)
 
 	return manager, nil
 }
@@ -240,6 +242,14 @@ func (m *HookManager) doRunHook(task *state.Task, tomb *tomb.Tomb) error {
 	return nil
 }
 
+// undoRunHook does nothing as hooks cannot be undone, but having it
+// keeps hook tasks in their place in the undo order of their change,
+// e.g. so that the new revision of a snap isn't discarded before the
+// tasks that linked it are undone.
+func (m *HookManager) undoRunHook(task *state.Task, tomb *tomb.Tomb) error {
+	return nil
+}
+
 func runHookImpl(c *Context, tomb *tomb.Tomb) ([]byte, error) {
 	return runHookAndWait(c.SnapName(), c.SnapRevision(), c.HookName(), c.ID(), c.Timeout(), tomb)
 }
@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package hookstate
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/snapcore/snapd/i18n/dumb"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func init() {
+	snapstate.SetupInstallHook = SetupInstallHook
+	snapstate.SetupPreRefreshHook = SetupPreRefreshHook
+	snapstate.SetupPostRefreshHook = SetupPostRefreshHook
+	snapstate.SetupRemoveHook = SetupRemoveHook
+}
+
+// snapHookHandler is the handler of the snap lifecycle hooks, which
+// need no special support from snapd.
+type snapHookHandler struct{}
+
+func (h *snapHookHandler) Before() error {
+	return nil
+}
+
+func (h *snapHookHandler) Done() error {
+	return nil
+}
+
+func (h *snapHookHandler) Error(err error) error {
+	return nil
+}
+
+func newSnapHookHandler(context *Context) Handler {
+	return &snapHookHandler{}
+}
+
+func setupSnapHook(st *state.State, snapName, hookName string) *state.Task {
+	hooksup := &HookSetup{
+		Snap:     snapName,
+		Hook:     hookName,
+		Optional: true,
+	}
+	summary := fmt.Sprintf(i18n.G("Run %s hook of %q snap if present"), hookName, snapName)
+	return HookTask(st, summary, hooksup, nil)
+}
+
+// SetupInstallHook returns a task that runs the install hook of the
+// given snap, if it has one.
+func SetupInstallHook(st *state.State, snapName string) *state.Task {
+	return setupSnapHook(st, snapName, "install")
+}
+
+// SetupPreRefreshHook returns a task that runs the pre-refresh hook
+// of the given snap, if it has one.
+func SetupPreRefreshHook(st *state.State, snapName string) *state.Task {
+	return setupSnapHook(st, snapName, "pre-refresh")
+}
+
+// SetupPostRefreshHook returns a task that runs the post-refresh hook
+// of the given snap, if it has one.
+func SetupPostRefreshHook(st *state.State, snapName string) *state.Task {
+	return setupSnapHook(st, snapName, "post-refresh")
+}
+
+// SetupRemoveHook returns a task that runs the remove hook of the
+// given snap, if it has one.
+func SetupRemoveHook(st *state.State, snapName string) *state.Task {
+	return setupSnapHook(st, snapName, "remove")
+}
+
+func setupHooks(hookMgr *HookManager) {
+	hookMgr.Register(regexp.MustCompile("^(?:install|pre-refresh|post-refresh|remove)$"), newSnapHookHandler)
+}
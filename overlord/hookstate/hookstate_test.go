@@ -21,6 +21,7 @@ package hookstate_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -165,6 +166,30 @@ func (s *hookManagerSuite) TestHookTask(c *C) {
 	c.Check(setup.Hook, Equals, "configure")
 }
 
+func (s *hookManagerSuite) TestSetupSnapHooks(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	for _, t := range []struct {
+		setup func(*state.State, string) *state.Task
+		hook  string
+	}{
+		{hookstate.SetupInstallHook, "install"},
+		{hookstate.SetupPreRefreshHook, "pre-refresh"},
+		{hookstate.SetupPostRefreshHook, "post-refresh"},
+		{hookstate.SetupRemoveHook, "remove"},
+	} {
+		task := t.setup(s.state, "test-snap")
+		c.Check(task.Kind(), Equals, "run-hook")
+		c.Check(task.Summary(), Equals, fmt.Sprintf(`Run %s hook of "test-snap" snap if present`, t.hook))
+
+		var setup hookstate.HookSetup
+		err := task.Get("hook-setup", &setup)
+		c.Check(err, IsNil)
+		c.Check(setup, DeepEquals, hookstate.HookSetup{Snap: "test-snap", Hook: t.hook, Optional: true})
+	}
+}
+
 func (s *hookManagerSuite) TestHookTaskEnsure(c *C) {
 	s.manager.Ensure()
 	s.manager.Wait()
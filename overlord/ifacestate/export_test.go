@@ -25,6 +25,8 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 )
 
+var GadgetConnections = gadgetConnections
+
 // AddForeignTaskHandlers registers handlers for tasks handled outside of the
 // InterfaceManager.
 func (m *InterfaceManager) AddForeignTaskHandlers() {
@@ -34,3 +36,16 @@ func (m *InterfaceManager) AddForeignTaskHandlers() {
 	}
 	m.runner.AddHandler("error-trigger", erroringHandler, nil)
 }
+
+// MockSnapNamespaceTools mocks the functions used to update and discard
+// the preserved mount namespace of snaps.
+func MockSnapNamespaceTools(update, discard func(snapName string) error) (restore func()) {
+	oldUpdate := updateSnapNamespace
+	oldDiscard := discardSnapNamespace
+	updateSnapNamespace = update
+	discardSnapNamespace = discard
+	return func() {
+		updateSnapNamespace = oldUpdate
+		discardSnapNamespace = oldDiscard
+	}
+}
@@ -157,8 +157,8 @@ func (m *InterfaceManager) setupProfilesForSnap(task *state.Task, _ *tomb.Tomb,
 	if err := m.reloadConnections(snapName); err != nil {
 		return err
 	}
-	// FIXME: here we should not reconnect auto-connect plug/slot
-	// pairs that were explicitly disconnected by the user
+	// auto-connections that were explicitly disconnected by the
+	// user are remembered as undesired and not made again
 	connectedSnaps, err := m.autoConnect(task, snapName, nil)
 	if err != nil {
 		return err
@@ -422,13 +422,83 @@ func (m *InterfaceManager) doConnect(task *state.Task, _ *tomb.Tomb) error {
 	if err := m.setupSnapSecurity(task, plug.Snap, plugOpts); err != nil {
 		return err
 	}
+	m.updateSnapNamespaces(task, snapNamesFromConns([]interfaces.ConnRef{connRef}))
 
+	// remember any previous state of the connection (e.g. an undesired
+	// auto-connection) so that it can be restored on undo
+	if cstate, ok := conns[connRef.ID()]; ok {
+		task.Set("old-conn", cstate)
+	}
 	conns[connRef.ID()] = connState{Interface: plug.Interface}
 	setConns(st, conns)
 
 	return nil
 }
 
+func (m *InterfaceManager) undoConnect(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	defer st.Unlock()
+
+	plugRef, slotRef, err := getPlugAndSlotRefs(task)
+	if err != nil {
+		return err
+	}
+
+	conns, err := getConns(st)
+	if err != nil {
+		return err
+	}
+
+	var oldConn connState
+	err = task.Get("old-conn", &oldConn)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	hadOldConn := err == nil
+
+	connRef := interfaces.ConnRef{PlugRef: plugRef, SlotRef: slotRef}
+	if err := m.repo.Disconnect(plugRef.Snap, plugRef.Name, slotRef.Snap, slotRef.Name); err != nil {
+		return err
+	}
+	affectedSnaps := snapNamesFromConns([]interfaces.ConnRef{connRef})
+	if err := m.setupConnectedSnaps(task, affectedSnaps); err != nil {
+		return err
+	}
+	m.updateSnapNamespaces(task, affectedSnaps)
+
+	if hadOldConn {
+		conns[connRef.ID()] = oldConn
+	} else {
+		delete(conns, connRef.ID())
+	}
+	setConns(st, conns)
+	task.Set("old-conn", nil)
+	return nil
+}
+
+// setupConnectedSnaps sets up the security profiles of the given snaps after
+// their connections changed.
+func (m *InterfaceManager) setupConnectedSnaps(task *state.Task, snapNames []string) error {
+	st := task.State()
+	for _, snapName := range snapNames {
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, snapName, &snapst); err != nil {
+			task.Errorf("skipping security profiles setup for snap %q: %v", snapName, err)
+			continue
+		}
+		snapInfo, err := snapst.CurrentInfo()
+		if err != nil {
+			return err
+		}
+		opts := confinementOptions(snapst.Flags)
+		if err := m.setupSnapSecurity(task, snapInfo, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func snapNamesFromConns(conns []interfaces.ConnRef) []string {
 	m := make(map[string]bool)
 	for _, conn := range conns {
@@ -479,11 +549,69 @@ func (m *InterfaceManager) doDisconnect(task *state.Task, _ *tomb.Tomb) error {
 			return err
 		}
 	}
+	m.updateSnapNamespaces(task, affectedSnaps)
+	oldConns := make(map[string]connState, len(affectedConns))
 	for _, conn := range affectedConns {
-		delete(conns, conn.ID())
+		id := conn.ID()
+		cstate, ok := conns[id]
+		if ok {
+			oldConns[id] = cstate
+		}
+		if ok && cstate.Auto {
+			// remember the disconnect so that the connection
+			// is not automatically made again
+			cstate.Undesired = true
+			conns[id] = cstate
+			continue
+		}
+		delete(conns, id)
+	}
+
+	// remember the state of the disconnected connections so that
+	// they can be restored on undo
+	task.Set("old-conns", oldConns)
+	setConns(st, conns)
+	return nil
+}
+
+func (m *InterfaceManager) undoDisconnect(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var oldConns map[string]connState
+	err := task.Get("old-conns", &oldConns)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+
+	conns, err := getConns(st)
+	if err != nil {
+		return err
+	}
+
+	var affectedConns []interfaces.ConnRef
+	for id, cstate := range oldConns {
+		connRef, err := interfaces.ParseConnRef(id)
+		if err != nil {
+			return err
+		}
+		if !cstate.Undesired {
+			if err := m.repo.Connect(connRef); err != nil {
+				return err
+			}
+			affectedConns = append(affectedConns, connRef)
+		}
+		conns[id] = cstate
+	}
+	affectedSnaps := snapNamesFromConns(affectedConns)
+	if err := m.setupConnectedSnaps(task, affectedSnaps); err != nil {
+		return err
 	}
+	m.updateSnapNamespaces(task, affectedSnaps)
 
 	setConns(st, conns)
+	task.Set("old-conns", nil)
 	return nil
 }
 
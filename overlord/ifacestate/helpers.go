@@ -21,16 +21,21 @@ package ifacestate
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/backends"
 	"github.com/snapcore/snapd/interfaces/builtin"
 	"github.com/snapcore/snapd/interfaces/policy"
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/snapstate/backend"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
 )
 
@@ -161,7 +166,10 @@ func (m *InterfaceManager) reloadConnections(snapName string) error {
 	if err != nil {
 		return err
 	}
-	for id := range conns {
+	for id, conn := range conns {
+		if conn.Undesired {
+			continue
+		}
 		connRef, err := interfaces.ParseConnRef(id)
 		if err != nil {
 			return err
@@ -192,6 +200,35 @@ func (m *InterfaceManager) setupSnapSecurity(task *state.Task, snapInfo *snap.In
 	return nil
 }
 
+var (
+	updateSnapNamespace  = backend.Backend{}.UpdateSnapNamespace
+	discardSnapNamespace = backend.Backend{}.DiscardSnapNamespace
+)
+
+// updateSnapNamespaces applies the (possibly changed) mount profiles of the
+// given snaps to their preserved mount namespaces, if any. Snaps without a
+// preserved namespace are skipped. A namespace that cannot be updated is
+// discarded so that it is rebuilt from the new profile the next time the
+// snap runs.
+func (m *InterfaceManager) updateSnapNamespaces(task *state.Task, snapNames []string) {
+	st := task.State()
+	for _, snapName := range snapNames {
+		// NOTE: This path has to be synchronized with snap-confine
+		if !osutil.FileExists(filepath.Join(dirs.SnapRunNsDir, snapName+".mnt")) {
+			continue
+		}
+		st.Unlock()
+		err := updateSnapNamespace(snapName)
+		if err != nil {
+			err = discardSnapNamespace(snapName)
+		}
+		st.Lock()
+		if err != nil {
+			task.Logf("cannot update mount namespace of snap %q: %s", snapName, err)
+		}
+	}
+}
+
 func (m *InterfaceManager) removeSnapSecurity(task *state.Task, snapName string) error {
 	st := task.State()
 	for _, backend := range m.repo.Backends() {
@@ -208,7 +245,12 @@ func (m *InterfaceManager) removeSnapSecurity(task *state.Task, snapName string)
 
 type connState struct {
 	Auto      bool   `json:"auto,omitempty"`
+	ByGadget  bool   `json:"by-gadget,omitempty"`
 	Interface string `json:"interface,omitempty"`
+	// Undesired tracks connections that were made automatically
+	// and then manually disconnected; they are kept so that they
+	// are not automatically established again.
+	Undesired bool `json:"undesired,omitempty"`
 }
 
 type autoConnectChecker struct {
@@ -343,10 +385,132 @@ func (m *InterfaceManager) autoConnect(task *state.Task, snapName string, blackl
 		conns[key] = connState{Interface: plug.Interface, Auto: true}
 	}
 
+	gadgetConnected, err := m.gadgetConnect(task, snapName, conns)
+	if err != nil {
+		return nil, err
+	}
+	affectedSnapNames = append(affectedSnapNames, gadgetConnected...)
+
 	task.State().Set("conns", conns)
 	return affectedSnapNames, nil
 }
 
+// gadgetConnect establishes the connections requested by the gadget
+// that involve the given snap, recording them in conns and returning
+// the names of the snaps they affect.
+func (m *InterfaceManager) gadgetConnect(task *state.Task, snapName string, conns map[string]connState) ([]string, error) {
+	st := task.State()
+	gadget, err := snapstate.GadgetInfo(st)
+	if err == state.ErrNoState {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	gadgetConns, err := gadgetConnections(st, gadget)
+	if err != nil {
+		// a broken gadget.yaml must not keep snaps from being set up
+		task.Logf("cannot read the connections requested by the gadget: %v", err)
+		return nil, nil
+	}
+	if len(gadgetConns) == 0 {
+		return nil, nil
+	}
+
+	snapNameByID, err := snapNamesBySnapID(st)
+	if err != nil {
+		return nil, err
+	}
+
+	var affectedSnapNames []string
+	for _, gconn := range gadgetConns {
+		plugSnap := snapNameByID[gconn.Plug.SnapID]
+		slotSnap := snapNameByID[gconn.Slot.SnapID]
+		if plugSnap == "" || slotSnap == "" {
+			// not (yet) installed
+			continue
+		}
+		if plugSnap != snapName && slotSnap != snapName {
+			continue
+		}
+		plug := m.repo.Plug(plugSnap, gconn.Plug.Plug)
+		if plug == nil {
+			task.Logf("cannot connect %s:%s to %s:%s as requested by the gadget: no such plug", plugSnap, gconn.Plug.Plug, slotSnap, gconn.Slot.Slot)
+			continue
+		}
+		connRef := interfaces.ConnRef{
+			PlugRef: interfaces.PlugRef{Snap: plugSnap, Name: gconn.Plug.Plug},
+			SlotRef: interfaces.SlotRef{Snap: slotSnap, Name: gconn.Slot.Slot},
+		}
+		key := connRef.ID()
+		if _, ok := conns[key]; ok {
+			// the connection was already made, or undesired
+			continue
+		}
+		if err := m.repo.Connect(connRef); err != nil {
+			task.Logf("cannot connect %s to %s as requested by the gadget: %s", connRef.PlugRef, connRef.SlotRef, err)
+			continue
+		}
+		affectedSnapNames = append(affectedSnapNames, plugSnap, slotSnap)
+		conns[key] = connState{Interface: plug.Interface, Auto: true, ByGadget: true}
+	}
+
+	return affectedSnapNames, nil
+}
+
+type cachedGadgetConnectionsKey struct{}
+
+type cachedGadgetConnections struct {
+	name     string
+	revision snap.Revision
+	conns    []snap.GadgetConnection
+	err      error
+}
+
+// gadgetConnections returns the connections requested by the given
+// gadget, reading its gadget.yaml only once per gadget revision.
+func gadgetConnections(st *state.State, gadget *snap.Info) ([]snap.GadgetConnection, error) {
+	cached, _ := st.Cached(cachedGadgetConnectionsKey{}).(*cachedGadgetConnections)
+	if cached == nil || cached.name != gadget.Name() || cached.revision != gadget.Revision {
+		cached = &cachedGadgetConnections{
+			name:     gadget.Name(),
+			revision: gadget.Revision,
+		}
+		gadgetInfo, err := snap.ReadGadgetInfo(gadget, release.OnClassic)
+		if err != nil {
+			cached.err = err
+		} else {
+			cached.conns = gadgetInfo.Connections
+		}
+		st.Cache(cachedGadgetConnectionsKey{}, cached)
+	}
+	return cached.conns, cached.err
+}
+
+// snapNamesBySnapID maps the ids of the installed snaps to their
+// names, with the special gadget connection id "system" mapped to the
+// core snap.
+func snapNamesBySnapID(st *state.State) (map[string]string, error) {
+	all, err := snapstate.All(st)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(all)+1)
+	for name, snapst := range all {
+		if si := snapst.CurrentSideInfo(); si != nil && si.SnapID != "" {
+			names[si.SnapID] = name
+		}
+	}
+	core, err := snapstate.CoreInfo(st)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if core != nil {
+		names[snap.SystemSnapID] = core.Name()
+	}
+	return names, nil
+}
+
 func getPlugAndSlotRefs(task *state.Task) (interfaces.PlugRef, interfaces.SlotRef, error) {
 	var plugRef interfaces.PlugRef
 	var slotRef interfaces.SlotRef
@@ -58,8 +58,8 @@ func Manager(s *state.State, hookManager *hookstate.HookManager, extraInterfaces
 		return len(running) != 0
 	})
 
-	runner.AddHandler("connect", m.doConnect, nil)
-	runner.AddHandler("disconnect", m.doDisconnect, nil)
+	runner.AddHandler("connect", m.doConnect, m.undoConnect)
+	runner.AddHandler("disconnect", m.doDisconnect, m.undoDisconnect)
 	runner.AddHandler("setup-profiles", m.doSetupProfiles, m.undoSetupProfiles)
 	runner.AddHandler("remove-profiles", m.doRemoveProfiles, m.doSetupProfiles)
 	runner.AddHandler("discard-conns", m.doDiscardConns, m.undoDiscardConns)
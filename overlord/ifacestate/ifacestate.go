@@ -168,6 +168,40 @@ func Disconnect(st *state.State, plugSnap, plugName, slotSnap, slotName string)
 	return state.NewTaskSet(task), nil
 }
 
+// ConnectionState describes the state of a connection as tracked by
+// the interface manager.
+type ConnectionState struct {
+	// Interface is the name of the interface of the connection.
+	Interface string
+	// Auto is true if the connection was made automatically.
+	Auto bool
+	// ByGadget is true if the connection was requested by the gadget.
+	ByGadget bool
+	// Undesired is true if the connection was made automatically and
+	// then manually disconnected; such a connection is not active.
+	Undesired bool
+}
+
+// ConnectionStates returns the state of all the connections known to
+// the interface manager, keyed by connection id.
+func ConnectionStates(st *state.State) (map[string]ConnectionState, error) {
+	conns, err := getConns(st)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]ConnectionState, len(conns))
+	for id, cstate := range conns {
+		states[id] = ConnectionState{
+			Interface: cstate.Interface,
+			Auto:      cstate.Auto,
+			ByGadget:  cstate.ByGadget,
+			Undesired: cstate.Undesired,
+		}
+	}
+	return states, nil
+}
+
 // CheckInterfaces checks whether plugs and slots of snap are allowed for installation.
 func CheckInterfaces(st *state.State, snapInfo *snap.Info) error {
 	// XXX: AddImplicitSlots is really a brittle interface
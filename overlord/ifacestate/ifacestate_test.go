@@ -21,6 +21,9 @@ package ifacestate_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -37,6 +40,7 @@ import (
 	"github.com/snapcore/snapd/overlord/ifacestate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
@@ -599,6 +603,7 @@ func (s *interfaceManagerSuite) mockSnap(c *C, yamlText string) *snap.Info {
 		Active:   true,
 		Sequence: []*snap.SideInfo{sideInfo},
 		Current:  sideInfo.Revision,
+		SnapType: string(snapInfo.Type),
 	})
 	return snapInfo
 }
@@ -707,12 +712,21 @@ slots:
 // The setup-profiles task will not auto-connect an plug that was previously
 // explicitly disconnected by the user.
 func (s *interfaceManagerSuite) TestDoSetupSnapSecurityHonorsDisconnect(c *C) {
-	c.Skip("feature disabled until redesign/reimpl")
 	// Add an OS snap as well as a sample snap with a "network" plug.
 	// The plug is normally auto-connected.
 	s.mockSnap(c, osSnapYaml)
 	snapInfo := s.mockSnap(c, sampleSnapYaml)
 
+	// The connection was made automatically and then disconnected.
+	undesired := map[string]interface{}{
+		"snap:network ubuntu-core:network": map[string]interface{}{
+			"interface": "network", "auto": true, "undesired": true,
+		},
+	}
+	s.state.Lock()
+	s.state.Set("conns", undesired)
+	s.state.Unlock()
+
 	// Initialize the manager. This registers the two snaps.
 	mgr := s.manager(c)
 
@@ -733,11 +747,11 @@ func (s *interfaceManagerSuite) TestDoSetupSnapSecurityHonorsDisconnect(c *C) {
 	// Ensure that the task succeeded
 	c.Assert(change.Status(), Equals, state.DoneStatus)
 
-	// Ensure that "network" is not saved in the state as auto-connected.
+	// Ensure that "network" is still saved in the state as undesired.
 	var conns map[string]interface{}
 	err := s.state.Get("conns", &conns)
 	c.Assert(err, IsNil)
-	c.Check(conns, HasLen, 0)
+	c.Check(conns, DeepEquals, undesired)
 
 	// Ensure that "network" is really disconnected.
 	repo := mgr.Repository()
@@ -1458,6 +1472,226 @@ func (s *interfaceManagerSuite) TestDisconnectSetsUpSecurity(c *C) {
 	c.Check(s.secBackend.SetupCalls[1].Options, Equals, interfaces.ConfinementOptions{})
 }
 
+func (s *interfaceManagerSuite) TestConnectUpdatesNamespaces(c *C) {
+	var updated, discarded []string
+	restore := ifacestate.MockSnapNamespaceTools(func(snapName string) error {
+		updated = append(updated, snapName)
+		if snapName == "producer" {
+			return fmt.Errorf("boom")
+		}
+		return nil
+	}, func(snapName string) error {
+		discarded = append(discarded, snapName)
+		return nil
+	})
+	defer restore()
+
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnapNamespaces(c, "consumer", "producer")
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("connect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Err(), IsNil)
+	c.Check(change.Status(), Equals, state.DoneStatus)
+	c.Check(updated, DeepEquals, []string{"consumer", "producer"})
+	// the namespace that could not be updated is discarded
+	c.Check(discarded, DeepEquals, []string{"producer"})
+}
+
+func (s *interfaceManagerSuite) TestDisconnectUpdatesNamespaces(c *C) {
+	var updated []string
+	restore := ifacestate.MockSnapNamespaceTools(func(snapName string) error {
+		updated = append(updated, snapName)
+		return nil
+	}, func(snapName string) error {
+		c.Fatalf("unexpected discard of %q", snapName)
+		return nil
+	})
+	defer restore()
+
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnapNamespaces(c, "consumer", "producer")
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test"},
+	})
+	s.state.Unlock()
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	ts, err := ifacestate.Disconnect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("disconnect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Err(), IsNil)
+	c.Check(updated, DeepEquals, []string{"consumer", "producer"})
+}
+
+// mockSnapNamespaces pretends that the given snaps have preserved mount namespaces.
+func (s *interfaceManagerSuite) mockSnapNamespaces(c *C, snapNames ...string) {
+	c.Assert(os.MkdirAll(dirs.SnapRunNsDir, 0755), IsNil)
+	for _, snapName := range snapNames {
+		c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapRunNsDir, snapName+".mnt"), nil, 0644), IsNil)
+	}
+}
+
+func (s *interfaceManagerSuite) TestConnectSkipsSnapsWithoutNamespace(c *C) {
+	var updated []string
+	restore := ifacestate.MockSnapNamespaceTools(func(snapName string) error {
+		updated = append(updated, snapName)
+		return nil
+	}, func(snapName string) error {
+		c.Fatalf("unexpected discard of %q", snapName)
+		return nil
+	})
+	defer restore()
+
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	// only the consumer has a preserved mount namespace
+	s.mockSnapNamespaces(c, "consumer")
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("connect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Err(), IsNil)
+	c.Check(updated, DeepEquals, []string{"consumer"})
+}
+
+func (s *interfaceManagerSuite) TestUndoConnectUpdatesNamespaces(c *C) {
+	var updated []string
+	restore := ifacestate.MockSnapNamespaceTools(func(snapName string) error {
+		updated = append(updated, snapName)
+		return nil
+	}, func(snapName string) error {
+		c.Fatalf("unexpected discard of %q", snapName)
+		return nil
+	})
+	defer restore()
+
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnapNamespaces(c, "consumer", "producer")
+
+	mgr := s.manager(c)
+
+	s.state.Lock()
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("connect", "")
+	change.AddAll(ts)
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitAll(ts)
+	change.AddTask(terr)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Status(), Equals, state.ErrorStatus)
+	c.Check(ts.Tasks()[0].Status(), Equals, state.UndoneStatus)
+	// the namespaces are updated both when connecting and when undoing
+	c.Check(updated, DeepEquals, []string{"consumer", "producer", "consumer", "producer"})
+	c.Check(mgr.Repository().Interfaces().Plugs[0].Connections, HasLen, 0)
+
+	var conns map[string]interface{}
+	err = s.state.Get("conns", &conns)
+	c.Assert(err, IsNil)
+	c.Check(conns, HasLen, 0)
+}
+
+func (s *interfaceManagerSuite) TestUndoDisconnectUpdatesNamespaces(c *C) {
+	var updated []string
+	restore := ifacestate.MockSnapNamespaceTools(func(snapName string) error {
+		updated = append(updated, snapName)
+		return nil
+	}, func(snapName string) error {
+		c.Fatalf("unexpected discard of %q", snapName)
+		return nil
+	})
+	defer restore()
+
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnapNamespaces(c, "consumer", "producer")
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true},
+	})
+	s.state.Unlock()
+
+	mgr := s.manager(c)
+
+	s.state.Lock()
+	ts, err := ifacestate.Disconnect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("disconnect", "")
+	change.AddAll(ts)
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitAll(ts)
+	change.AddTask(terr)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Status(), Equals, state.ErrorStatus)
+	c.Check(ts.Tasks()[0].Status(), Equals, state.UndoneStatus)
+	// the namespaces are updated both when disconnecting and when undoing
+	c.Check(updated, DeepEquals, []string{"consumer", "producer", "consumer", "producer"})
+	c.Check(mgr.Repository().Interfaces().Plugs[0].Connections, HasLen, 1)
+
+	// the connection is restored as it was, not as undesired
+	var conns map[string]interface{}
+	err = s.state.Get("conns", &conns)
+	c.Assert(err, IsNil)
+	c.Check(conns, DeepEquals, map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true},
+	})
+}
+
 func (s *interfaceManagerSuite) TestDisconnectTracksConnectionsInState(c *C) {
 	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
 	s.mockSnap(c, consumerYaml)
@@ -1842,3 +2076,287 @@ func (s *interfaceManagerSuite) TestManagerTransitionConnectionsCoreUndo(c *C) {
 		},
 	})
 }
+
+func (s *interfaceManagerSuite) TestDisconnectAutoConnectionMarksItUndesired(c *C) {
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true},
+	})
+	s.state.Unlock()
+
+	mgr := s.manager(c)
+
+	s.state.Lock()
+	ts, err := ifacestate.Disconnect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("disconnect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	mgr.Ensure()
+	mgr.Wait()
+	mgr.Stop()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Err(), IsNil)
+	var conns map[string]interface{}
+	err = s.state.Get("conns", &conns)
+	c.Assert(err, IsNil)
+	c.Check(conns, DeepEquals, map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true, "undesired": true},
+	})
+
+	plug := mgr.Repository().Plug("consumer", "plug")
+	c.Check(plug.Connections, HasLen, 0)
+}
+
+func (s *interfaceManagerSuite) TestConnectUndesiredMakesItManual(c *C) {
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true, "undesired": true},
+	})
+	s.state.Unlock()
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("connect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Err(), IsNil)
+	var conns map[string]interface{}
+	err = s.state.Get("conns", &conns)
+	c.Assert(err, IsNil)
+	c.Check(conns, DeepEquals, map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test"},
+	})
+}
+
+func (s *interfaceManagerSuite) TestUndoConnectKeepsItUndesired(c *C) {
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true, "undesired": true},
+	})
+	s.state.Unlock()
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("connect", "")
+	change.AddAll(ts)
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitAll(ts)
+	change.AddTask(terr)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Status(), Equals, state.ErrorStatus)
+	c.Check(ts.Tasks()[0].Status(), Equals, state.UndoneStatus)
+	// the connection is not automatically made again
+	var conns map[string]interface{}
+	err = s.state.Get("conns", &conns)
+	c.Assert(err, IsNil)
+	c.Check(conns, DeepEquals, map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true, "undesired": true},
+	})
+}
+
+func (s *interfaceManagerSuite) TestManagerDoesNotReloadUndesiredConnections(c *C) {
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true, "undesired": true},
+	})
+	s.state.Unlock()
+
+	mgr := s.manager(c)
+	plug := mgr.Repository().Plug("consumer", "plug")
+	c.Check(plug.Connections, HasLen, 0)
+}
+
+func (s *interfaceManagerSuite) TestConnectionStates(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	states, err := ifacestate.ConnectionStates(s.state)
+	c.Assert(err, IsNil)
+	c.Check(states, HasLen, 0)
+
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot":  map[string]interface{}{"interface": "test"},
+		"consumer:plug2 producer:slot": map[string]interface{}{"interface": "test", "auto": true, "undesired": true},
+		"consumer:plug3 core:slot":     map[string]interface{}{"interface": "test", "auto": true, "by-gadget": true},
+	})
+
+	states, err = ifacestate.ConnectionStates(s.state)
+	c.Assert(err, IsNil)
+	c.Check(states, DeepEquals, map[string]ifacestate.ConnectionState{
+		"consumer:plug producer:slot":  {Interface: "test"},
+		"consumer:plug2 producer:slot": {Interface: "test", Auto: true, Undesired: true},
+		"consumer:plug3 core:slot":     {Interface: "test", Auto: true, ByGadget: true},
+	})
+}
+
+var gadgetYaml = `
+name: gadget
+version: 1
+type: gadget
+`
+
+// The setup-profiles task makes the connections requested by the gadget.
+func (s *interfaceManagerSuite) TestDoSetupSnapSecurityGadgetConnections(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnapDecl(c, "consumer", "one-publisher", nil)
+	s.mockSnapDecl(c, "producer", "two-publisher", nil)
+	gadgetInfo := s.mockSnap(c, gadgetYaml)
+	err := ioutil.WriteFile(filepath.Join(gadgetInfo.MountDir(), "meta", "gadget.yaml"), []byte(`
+connections:
+  - plug: consumeridididididididididididid:plug
+    slot: produceridididididididididididid:slot
+`), 0644)
+	c.Assert(err, IsNil)
+	s.mockSnap(c, producerYaml)
+	// with two candidate slots the plug is not auto-connected by policy
+	s.mockSnap(c, "name: producer2\nversion: 1\nslots:\n slot:\n  interface: test\n")
+
+	mgr := s.manager(c)
+
+	snapInfo := s.mockSnap(c, consumerYaml)
+	change := s.addSetupSnapSecurityChange(c, &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: snapInfo.Name(),
+			Revision: snapInfo.Revision,
+		},
+	})
+	mgr.Ensure()
+	mgr.Wait()
+	mgr.Stop()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Err(), IsNil)
+	var conns map[string]interface{}
+	err = s.state.Get("conns", &conns)
+	c.Assert(err, IsNil)
+	c.Check(conns, DeepEquals, map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true, "by-gadget": true},
+	})
+
+	plug := mgr.Repository().Plug("consumer", "plug")
+	c.Check(plug.Connections, HasLen, 1)
+}
+
+func (s *interfaceManagerSuite) TestDoSetupSnapSecurityBrokenGadgetConnections(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	gadgetInfo := s.mockSnap(c, gadgetYaml)
+	err := ioutil.WriteFile(filepath.Join(gadgetInfo.MountDir(), "meta", "gadget.yaml"), []byte(`
+connections:
+  - plug: consumeridididididididididididid
+    slot: produceridididididididididididid:slot
+`), 0644)
+	c.Assert(err, IsNil)
+	s.mockSnap(c, producerYaml)
+
+	mgr := s.manager(c)
+
+	snapInfo := s.mockSnap(c, consumerYaml)
+	change := s.addSetupSnapSecurityChange(c, &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: snapInfo.Name(),
+			Revision: snapInfo.Revision,
+		},
+	})
+	mgr.Ensure()
+	mgr.Wait()
+	mgr.Stop()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the snap is still set up and auto-connected by policy
+	c.Assert(change.Err(), IsNil)
+	var conns map[string]interface{}
+	err = s.state.Get("conns", &conns)
+	c.Assert(err, IsNil)
+	c.Check(conns, DeepEquals, map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test", "auto": true},
+	})
+	c.Check(strings.Join(change.Tasks()[0].Log(), "\n"), Matches, `(?s).*cannot read the connections requested by the gadget: .*`)
+}
+
+func (s *interfaceManagerSuite) TestGadgetConnectionsCachedByRevision(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	gadgetInfo := s.mockSnap(c, gadgetYaml)
+	writeGadgetYaml := func(info *snap.Info, plug string) {
+		err := os.MkdirAll(filepath.Join(info.MountDir(), "meta"), 0755)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(filepath.Join(info.MountDir(), "meta", "gadget.yaml"), []byte(fmt.Sprintf(`
+connections:
+  - plug: consumeridididididididididididid:%s
+    slot: produceridididididididididididid:slot
+`, plug)), 0644)
+		c.Assert(err, IsNil)
+	}
+	writeGadgetYaml(gadgetInfo, "plug")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	conns, err := ifacestate.GadgetConnections(s.state, gadgetInfo)
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	c.Check(conns[0].Plug.Plug, Equals, "plug")
+
+	// not read again for the same revision
+	writeGadgetYaml(gadgetInfo, "other-plug")
+	conns, err = ifacestate.GadgetConnections(s.state, gadgetInfo)
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	c.Check(conns[0].Plug.Plug, Equals, "plug")
+
+	// but for a new one
+	newGadgetInfo := *gadgetInfo
+	newGadgetInfo.Revision = snap.R(gadgetInfo.Revision.N + 1)
+	writeGadgetYaml(&newGadgetInfo, "new-plug")
+	conns, err = ifacestate.GadgetConnections(s.state, &newGadgetInfo)
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	c.Check(conns[0].Plug.Plug, Equals, "new-plug")
+}
@@ -47,6 +47,7 @@ import (
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/partition"
@@ -66,6 +67,7 @@ type mgrsSuite struct {
 	umount *testutil.MockCmd
 
 	snapDiscardNs *testutil.MockCmd
+	snapSeccomp   *testutil.MockCmd
 
 	prevctlCmd func(...string) ([]byte, error)
 
@@ -120,6 +122,7 @@ func (ms *mgrsSuite) SetUpTest(c *C) {
 	ms.umount = testutil.MockCommand(c, "umount", "")
 	ms.snapDiscardNs = testutil.MockCommand(c, "snap-discard-ns", "")
 	dirs.DistroLibExecDir = ms.snapDiscardNs.BinDir()
+	ms.snapSeccomp = ms.snapDiscardNs.Also("snap-seccomp", "")
 
 	ms.storeSigning = assertstest.NewStoreStack("can0nical", rootPrivKey, storePrivKey)
 	ms.restoreTrusted = sysdb.InjectTrusted(ms.storeSigning.Trusted)
@@ -300,10 +303,14 @@ func (ms *mgrsSuite) prereqSnapAssertions(c *C, extraHeaders ...map[string]inter
 }
 
 func (ms *mgrsSuite) makeStoreTestSnap(c *C, snapYaml string, revno string) (path, digest string) {
+	return ms.makeStoreTestSnapWithFiles(c, snapYaml, nil, revno)
+}
+
+func (ms *mgrsSuite) makeStoreTestSnapWithFiles(c *C, snapYaml string, files [][]string, revno string) (path, digest string) {
 	info, err := snap.InfoFromSnapYaml([]byte(snapYaml))
 	c.Assert(err, IsNil)
 
-	snapPath := makeTestSnap(c, snapYaml)
+	snapPath := snaptest.MakeTestSnapWithFiles(c, snapYaml, files)
 
 	snapDigest, size, err := asserts.SnapFileSHA3_384(snapPath)
 	c.Assert(err, IsNil)
@@ -936,6 +943,142 @@ type: kernel`
 	})
 }
 
+func (ms *mgrsSuite) TestRemodelSwitchesKernel(c *C) {
+	bootloader := boottest.NewMockBootloader("mock", c.MkDir())
+	partition.ForceBootloader(bootloader)
+	defer partition.ForceBootloader(nil)
+
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	mockServer := ms.mockStore(c)
+	defer mockServer.Close()
+
+	brandAcct := assertstest.NewAccount(ms.storeSigning, "my-brand", map[string]interface{}{
+		"account-id":   "my-brand",
+		"verification": "certified",
+	}, "")
+	err := ms.storeSigning.Add(brandAcct)
+	c.Assert(err, IsNil)
+	brandAccKey := assertstest.NewAccountKey(ms.storeSigning, brandAcct, nil, brandPrivKey.PublicKey(), "")
+
+	brandSigning := assertstest.NewSigningDB("my-brand", brandPrivKey)
+	makeModel := func(kernel, revision string) asserts.Assertion {
+		model, err := brandSigning.Sign(asserts.ModelType, map[string]interface{}{
+			"series":       "16",
+			"authority-id": "my-brand",
+			"brand-id":     "my-brand",
+			"model":        "my-model",
+			"architecture": "amd64",
+			"store":        "my-brand-store-id",
+			"gadget":       "gadget",
+			"kernel":       kernel,
+			"revision":     revision,
+			"timestamp":    time.Now().Format(time.RFC3339),
+		}, nil, "")
+		c.Assert(err, IsNil)
+		return model
+	}
+
+	kernelFiles := [][]string{
+		{"kernel.img", "I'm a kernel"},
+		{"initrd.img", "...and I'm an initrd"},
+		{"meta/kernel.yaml", "version: 4.2"},
+	}
+	// the kernel of the new model comes from the store
+	ms.prereqSnapAssertions(c, map[string]interface{}{
+		"snap-name":    "other-krnl",
+		"publisher-id": "my-brand",
+	})
+	snapPath, _ := ms.makeStoreTestSnapWithFiles(c, "name: other-krnl\nversion: 4.0-2\ntype: kernel", kernelFiles, "2")
+	ms.serveSnap(snapPath, "2")
+
+	st := ms.o.State()
+	st.Lock()
+	defer st.Unlock()
+
+	// setup model assertion
+	err = assertstate.Add(st, ms.storeSigning.StoreAccountKey(""))
+	c.Assert(err, IsNil)
+	err = assertstate.Add(st, brandAcct)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(st, brandAccKey)
+	c.Assert(err, IsNil)
+	auth.SetDevice(st, &auth.DeviceState{
+		Brand:  "my-brand",
+		Model:  "my-model",
+		Serial: "serial-1",
+	})
+	err = assertstate.Add(st, makeModel("krnl", "0"))
+	c.Assert(err, IsNil)
+
+	// install the kernel of the current model
+	krnlPath := snaptest.MakeTestSnapWithFiles(c, "name: krnl\nversion: 4.0-1\ntype: kernel", kernelFiles)
+	ts, err := snapstate.InstallPath(st, &snap.SideInfo{RealName: "krnl"}, krnlPath, "", snapstate.Flags{})
+	c.Assert(err, IsNil)
+	chg := st.NewChange("install-snap", "...")
+	chg.AddAll(ts)
+
+	st.Unlock()
+	err = ms.o.Settle()
+	st.Lock()
+	c.Assert(err, IsNil)
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("install-snap change failed with: %v", chg.Err()))
+
+	// booted it
+	state.MockRestarting(st, false)
+	bootloader.BootVars["snap_mode"] = ""
+	bootloader.BootVars["snap_kernel"] = "krnl_x1.snap"
+
+	// with some data
+	var snapst snapstate.SnapState
+	err = snapstate.Get(st, "krnl", &snapst)
+	c.Assert(err, IsNil)
+	krnlInfo, err := snapst.CurrentInfo()
+	c.Assert(err, IsNil)
+	krnlData := filepath.Join(krnlInfo.DataDir(), "canary")
+	c.Assert(os.MkdirAll(krnlInfo.DataDir(), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(krnlData, []byte("canary"), 0644), IsNil)
+
+	chg, err = devicestate.Remodel(st, makeModel("other-krnl", "1").(*asserts.Model))
+	c.Assert(err, IsNil)
+
+	st.Unlock()
+	err = ms.o.Settle()
+	st.Lock()
+	c.Assert(err, IsNil)
+
+	// the new kernel passed check-snap and is going to be tried
+	c.Assert(st.Restarting(), Equals, true)
+	c.Assert(chg.Status(), Equals, state.DoingStatus, Commentf("remodel change failed with: %v", chg.Err()))
+	c.Check(bootloader.BootVars["snap_try_kernel"], Equals, "other-krnl_2.snap")
+	c.Check(bootloader.BootVars["snap_mode"], Equals, "try")
+
+	// simulate successful restart happened
+	state.MockRestarting(st, false)
+	bootloader.BootVars["snap_mode"] = ""
+	bootloader.BootVars["snap_kernel"] = "other-krnl_2.snap"
+
+	st.Unlock()
+	err = ms.o.Settle()
+	st.Lock()
+	c.Assert(err, IsNil)
+
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("remodel change failed with: %v", chg.Err()))
+
+	// the old kernel and its data are kept
+	err = snapstate.Get(st, "krnl", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Current, Equals, krnlInfo.Revision)
+	c.Check(osutil.FileExists(krnlInfo.MountFile()), Equals, true)
+	data, err := ioutil.ReadFile(krnlData)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "canary")
+	model, err := devicestate.Model(st)
+	c.Assert(err, IsNil)
+	c.Check(model.Kernel(), Equals, "other-krnl")
+}
+
 func (ms *mgrsSuite) installLocalTestSnap(c *C, snapYamlContent string) *snap.Info {
 	st := ms.o.State()
 
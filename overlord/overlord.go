@@ -21,8 +21,8 @@
 package overlord
 
 import (
+	"bytes"
 	"fmt"
-	"os"
 	"path/filepath"
 	"sync"
 	"time"
@@ -40,6 +40,8 @@ import (
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/ifacestate"
 	"github.com/snapcore/snapd/overlord/patch"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapshotstate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/store"
@@ -57,7 +59,8 @@ var (
 // Overlord is the central manager of a snappy system, keeping
 // track of all available state managers and related helpers.
 type Overlord struct {
-	stateEng *StateEngine
+	stateEng     *StateEngine
+	stateBackend *overlordStateBackend
 	// ensure loop
 	loopTomb    *tomb.Tomb
 	ensureLock  sync.Mutex
@@ -67,12 +70,14 @@ type Overlord struct {
 	// restarts
 	restartHandler func(t state.RestartType)
 	// managers
-	snapMgr   *snapstate.SnapManager
-	assertMgr *assertstate.AssertManager
-	ifaceMgr  *ifacestate.InterfaceManager
-	hookMgr   *hookstate.HookManager
-	configMgr *configstate.ConfigManager
-	deviceMgr *devicestate.DeviceManager
+	snapMgr     *snapstate.SnapManager
+	assertMgr   *assertstate.AssertManager
+	ifaceMgr    *ifacestate.InterfaceManager
+	hookMgr     *hookstate.HookManager
+	configMgr   *configstate.ConfigManager
+	deviceMgr   *devicestate.DeviceManager
+	svcMgr      *servicestate.ServiceManager
+	snapshotMgr *snapshotstate.SnapshotManager
 }
 
 var storeNew = store.New
@@ -83,17 +88,14 @@ func New() (*Overlord, error) {
 		loopTomb: new(tomb.Tomb),
 	}
 
-	backend := &overlordStateBackend{
-		path:           dirs.SnapStateFile,
-		ensureBefore:   o.ensureBefore,
-		requestRestart: o.requestRestart,
-	}
+	backend := newOverlordStateBackend(dirs.SnapStateFile, o.ensureBefore, o.requestRestart)
 	s, err := loadState(backend)
 	if err != nil {
 		return nil, err
 	}
 
 	o.stateEng = NewStateEngine(s)
+	o.stateBackend = backend
 
 	hookMgr, err := hookstate.Manager(s)
 	if err != nil {
@@ -136,6 +138,20 @@ func New() (*Overlord, error) {
 	o.deviceMgr = deviceMgr
 	o.stateEng.AddManager(o.deviceMgr)
 
+	svcMgr, err := servicestate.Manager(s)
+	if err != nil {
+		return nil, err
+	}
+	o.svcMgr = svcMgr
+	o.stateEng.AddManager(o.svcMgr)
+
+	snapshotMgr, err := snapshotstate.Manager(s)
+	if err != nil {
+		return nil, err
+	}
+	o.snapshotMgr = snapshotMgr
+	o.stateEng.AddManager(o.snapshotMgr)
+
 	// setting up the store
 	authContext := auth.NewAuthContext(s, o.deviceMgr)
 	sto := storeNew(nil, authContext)
@@ -146,7 +162,7 @@ func New() (*Overlord, error) {
 	return o, nil
 }
 
-func loadState(backend state.Backend) (*state.State, error) {
+func loadState(backend *overlordStateBackend) (*state.State, error) {
 	if !osutil.FileExists(dirs.SnapStateFile) {
 		// fail fast, mostly interesting for tests, this dir is setup
 		// by the snapd package
@@ -154,18 +170,21 @@ func loadState(backend state.Backend) (*state.State, error) {
 		if !osutil.IsDirectory(stateDir) {
 			return nil, fmt.Errorf("fatal: directory %q must be present", stateDir)
 		}
+		// a journal without its snapshot is meaningless
+		if err := backend.journal.reset(); err != nil {
+			return nil, fmt.Errorf("cannot remove stale state journal: %v", err)
+		}
 		s := state.New(backend)
 		patch.Init(s)
 		return s, nil
 	}
 
-	r, err := os.Open(dirs.SnapStateFile)
+	data, err := backend.journal.load()
 	if err != nil {
-		return nil, fmt.Errorf("cannot read the state file: %s", err)
+		return nil, err
 	}
-	defer r.Close()
 
-	s, err := state.ReadState(backend, r)
+	s, err := state.ReadState(backend, bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
@@ -231,6 +250,7 @@ func (o *Overlord) Loop() {
 			// in case of errors engine logs them,
 			// continue to the next Ensure() try for now
 			o.stateEng.Ensure()
+			o.stateBackend.ensureJournal(o.State())
 			select {
 			case <-o.loopTomb.Dying():
 				return nil
@@ -250,9 +270,26 @@ func (o *Overlord) Stop() error {
 	o.loopTomb.Kill(nil)
 	err1 := o.loopTomb.Wait()
 	o.stateEng.Stop()
+	// leave a complete state file behind
+	if err := o.stateBackend.compact(o.State()); err != nil && err1 == nil {
+		err1 = fmt.Errorf("cannot compact the state: %v", err)
+	}
 	return err1
 }
 
+// ReadStateFile reads the state persisted at path, replaying any
+// checkpoints journaled since the state file was last written. The
+// state is then ready for inspection but not bound to an overlord.
+func ReadStateFile(path string) (*state.State, error) {
+	sj := newStateJournal(path)
+	defer sj.closeJournal()
+	data, err := sj.load()
+	if err != nil {
+		return nil, err
+	}
+	return state.ReadState(nil, bytes.NewReader(data))
+}
+
 // Settle runs first a state engine Ensure and then wait for activities to settle.
 // That's done by waiting for all managers activities to settle while
 // making sure no immediate further Ensure is scheduled. Chiefly for tests.
@@ -279,6 +316,7 @@ func (o *Overlord) Settle() error {
 	for !done {
 		next := o.ensureTimerReset()
 		err := o.stateEng.Ensure()
+		o.stateBackend.ensureJournal(o.State())
 		switch ee := err.(type) {
 		case nil:
 		case *ensureError:
@@ -302,6 +340,11 @@ func (o *Overlord) State() *state.State {
 	return o.stateEng.State()
 }
 
+// StateEngine returns the state engine used by the overlord.
+func (o *Overlord) StateEngine() *StateEngine {
+	return o.stateEng
+}
+
 // SnapManager returns the snap manager responsible for snaps under
 // the overlord.
 func (o *Overlord) SnapManager() *snapstate.SnapManager {
@@ -330,3 +373,15 @@ func (o *Overlord) HookManager() *hookstate.HookManager {
 func (o *Overlord) DeviceManager() *devicestate.DeviceManager {
 	return o.deviceMgr
 }
+
+// ServiceManager returns the manager responsible for controlling the
+// services of installed snaps under the overlord.
+func (o *Overlord) ServiceManager() *servicestate.ServiceManager {
+	return o.svcMgr
+}
+
+// SnapshotManager returns the manager responsible for the snapshots
+// of snap data under the overlord.
+func (o *Overlord) SnapshotManager() *snapshotstate.SnapshotManager {
+	return o.snapshotMgr
+}
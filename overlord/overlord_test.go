@@ -70,6 +70,8 @@ func (ovs *overlordSuite) TestNew(c *C) {
 	c.Check(o.AssertManager(), NotNil)
 	c.Check(o.InterfaceManager(), NotNil)
 	c.Check(o.DeviceManager(), NotNil)
+	c.Check(o.ServiceManager(), NotNil)
+	c.Check(o.SnapshotManager(), NotNil)
 
 	s := o.State()
 	c.Check(s, NotNil)
@@ -443,11 +445,29 @@ func (ovs *overlordSuite) TestCheckpoint(c *C) {
 	s.Set("mark", 1)
 	s.Unlock()
 
-	st, err := os.Stat(dirs.SnapStateFile)
+	for _, fn := range []string{dirs.SnapStateFile, dirs.SnapStateFile + ".journal"} {
+		st, err := os.Stat(fn)
+		c.Assert(err, IsNil)
+		c.Assert(st.Mode(), Equals, os.FileMode(0600))
+	}
+
+	// the change went to the journal
+	content, err := ioutil.ReadFile(dirs.SnapStateFile + ".journal")
+	c.Assert(err, IsNil)
+	c.Check(string(content), testutil.Contains, `"mark":1`)
+
+	persisted, err := overlord.ReadStateFile(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
-	c.Assert(st.Mode(), Equals, os.FileMode(0600))
+	persisted.Lock()
+	defer persisted.Unlock()
+	var mark int
+	c.Assert(persisted.Get("mark", &mark), IsNil)
+	c.Check(mark, Equals, 1)
 
-	content, err := ioutil.ReadFile(dirs.SnapStateFile)
+	// and stopping writes out the complete state
+	o.Loop()
+	c.Assert(o.Stop(), IsNil)
+	content, err = ioutil.ReadFile(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
 	c.Check(string(content), testutil.Contains, `"mark":1`)
 }
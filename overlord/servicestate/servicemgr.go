@@ -0,0 +1,111 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate
+
+import (
+	"fmt"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/wrappers"
+)
+
+// ServiceManager is responsible for starting, stopping and restarting
+// the services of installed snaps on request.
+type ServiceManager struct {
+	state  *state.State
+	runner *state.TaskRunner
+}
+
+// Manager returns a new service manager.
+func Manager(s *state.State) (*ServiceManager, error) {
+	runner := state.NewTaskRunner(s)
+	m := &ServiceManager{state: s, runner: runner}
+
+	runner.AddHandler("service-control", m.doServiceControl, nil)
+
+	return m, nil
+}
+
+// Ensure implements StateManager.Ensure.
+func (m *ServiceManager) Ensure() error {
+	m.runner.Ensure()
+	return nil
+}
+
+// Wait implements StateManager.Wait.
+func (m *ServiceManager) Wait() {
+	m.runner.Wait()
+}
+
+// Stop implements StateManager.Stop.
+func (m *ServiceManager) Stop() {
+	m.runner.Stop()
+}
+
+func (m *ServiceManager) doServiceControl(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var sa serviceAction
+	if err := t.Get("service-action", &sa); err != nil {
+		return err
+	}
+
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(st, sa.SnapName, &snapst); err != nil {
+		if err == state.ErrNoState {
+			return fmt.Errorf("snap %q is not installed", sa.SnapName)
+		}
+		return err
+	}
+	info, err := snapst.CurrentInfo()
+	if err != nil {
+		return err
+	}
+
+	apps := make([]*snap.AppInfo, len(sa.Services))
+	for i, name := range sa.Services {
+		app, ok := info.Apps[name]
+		if !ok || !app.IsService() {
+			return fmt.Errorf("snap %q has no service %q", sa.SnapName, name)
+		}
+		apps[i] = app
+	}
+
+	pb := snapstate.NewTaskProgressAdapterUnlocked(t)
+	st.Unlock()
+	switch sa.Action {
+	case "start":
+		err = wrappers.StartServices(apps, sa.Enable, pb)
+	case "stop":
+		err = wrappers.StopServices(apps, sa.Disable, pb)
+	case "restart":
+		err = wrappers.RestartServices(apps, pb)
+	default:
+		err = fmt.Errorf("internal error: unknown service action %q", sa.Action)
+	}
+	st.Lock()
+	return err
+}
@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package servicestate implements the manager and state aspects
+// responsible for controlling the services of installed snaps.
+package servicestate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// Instruction describes a service action to perform.
+type Instruction struct {
+	Action string   `json:"action"`
+	Names  []string `json:"names"`
+
+	// Enable makes "start" also enable the services.
+	Enable bool `json:"enable,omitempty"`
+	// Disable makes "stop" also disable the services.
+	Disable bool `json:"disable,omitempty"`
+}
+
+// Validate checks the instruction is consistent.
+func (inst *Instruction) Validate() error {
+	switch inst.Action {
+	case "start", "stop", "restart":
+	case "":
+		return fmt.Errorf("service action is required")
+	default:
+		return fmt.Errorf("unknown service action %q", inst.Action)
+	}
+	if inst.Enable && inst.Action != "start" {
+		return fmt.Errorf("%q can only be combined with enable if starting", inst.Action)
+	}
+	if inst.Disable && inst.Action != "stop" {
+		return fmt.Errorf("%q can only be combined with disable if stopping", inst.Action)
+	}
+	return nil
+}
+
+// serviceAction is the data carried by a service-control task.
+type serviceAction struct {
+	SnapName string   `json:"snap-name"`
+	Action   string   `json:"action"`
+	Services []string `json:"services"`
+	Enable   bool     `json:"enable,omitempty"`
+	Disable  bool     `json:"disable,omitempty"`
+}
+
+// Control creates the task sets needed to perform the action of inst
+// on the given service apps, one per snap. If context is not nil the
+// tasks are meant to be queued after its hook, in the hook's change,
+// so the tasks of that change are not considered conflicting.
+func Control(st *state.State, appInfos []*snap.AppInfo, inst *Instruction, context *hookstate.Context) ([]*state.TaskSet, error) {
+	if err := inst.Validate(); err != nil {
+		return nil, err
+	}
+
+	svcs := make(map[string][]string)
+	for _, app := range appInfos {
+		if !app.IsService() {
+			return nil, fmt.Errorf("%s.%s is not a service", app.Snap.Name(), app.Name)
+		}
+		snapName := app.Snap.Name()
+		svcs[snapName] = append(svcs[snapName], app.Name)
+	}
+	if len(svcs) == 0 {
+		return nil, fmt.Errorf("no services to %s", inst.Action)
+	}
+
+	snapNames := make([]string, 0, len(svcs))
+	for snapName := range svcs {
+		snapNames = append(snapNames, snapName)
+	}
+	sort.Strings(snapNames)
+
+	var ignoreChangeID string
+	if context != nil {
+		if chg := context.Task().Change(); chg != nil {
+			ignoreChangeID = chg.ID()
+		}
+	}
+
+	tss := make([]*state.TaskSet, 0, len(snapNames))
+	for _, snapName := range snapNames {
+		if err := snapstate.CheckChangeConflictIgnoringChange(st, snapName, ignoreChangeID); err != nil {
+			return nil, err
+		}
+
+		names := svcs[snapName]
+		sort.Strings(names)
+		qualified := make([]string, len(names))
+		for i, name := range names {
+			qualified[i] = snapName + "." + name
+		}
+
+		summary := fmt.Sprintf(i18n.G("Run service command %q for services %s"), inst.Action, strutil.Quoted(qualified))
+		t := st.NewTask("service-control", summary)
+		t.Set("service-action", &serviceAction{
+			SnapName: snapName,
+			Action:   inst.Action,
+			Services: names,
+			Enable:   inst.Enable,
+			Disable:  inst.Disable,
+		})
+		tss = append(tss, state.NewTaskSet(t))
+	}
+
+	return tss, nil
+}
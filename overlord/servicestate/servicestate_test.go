@@ -0,0 +1,244 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate_test
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/systemd"
+)
+
+func TestServiceState(t *testing.T) { TestingT(t) }
+
+type serviceMgrSuite struct {
+	state *state.State
+	mgr   *servicestate.ServiceManager
+	info  *snap.Info
+
+	sysdLog    [][]string
+	prevctlCmd func(...string) ([]byte, error)
+}
+
+var _ = Suite(&serviceMgrSuite{})
+
+const snapYaml = `name: some-snap
+version: 1
+apps:
+ cmd:
+  command: cmd
+ svc1:
+  command: svc1
+  daemon: simple
+ svc2:
+  command: svc2
+  daemon: forking
+`
+
+func (s *serviceMgrSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+
+	s.prevctlCmd = systemd.SystemctlCmd
+	s.sysdLog = nil
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		s.sysdLog = append(s.sysdLog, cmd)
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	s.state = state.New(nil)
+	mgr, err := servicestate.Manager(s.state)
+	c.Assert(err, IsNil)
+	s.mgr = mgr
+
+	si := &snap.SideInfo{RealName: "some-snap", Revision: snap.R(1)}
+	s.info = snaptest.MockSnap(c, snapYaml, "", si)
+
+	s.state.Lock()
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+	})
+	s.state.Unlock()
+}
+
+func (s *serviceMgrSuite) TearDownTest(c *C) {
+	systemd.SystemctlCmd = s.prevctlCmd
+	dirs.SetRootDir("")
+}
+
+func (s *serviceMgrSuite) settle() {
+	for i := 0; i < 50; i++ {
+		s.mgr.Ensure()
+		s.mgr.Wait()
+	}
+}
+
+func (s *serviceMgrSuite) control(c *C, inst *servicestate.Instruction, apps ...string) *state.Change {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	appInfos := make([]*snap.AppInfo, len(apps))
+	for i, name := range apps {
+		appInfos[i] = s.info.Apps[name]
+	}
+	tss, err := servicestate.Control(s.state, appInfos, inst, nil)
+	c.Assert(err, IsNil)
+
+	chg := s.state.NewChange("service-control", "...")
+	for _, ts := range tss {
+		chg.AddAll(ts)
+	}
+	return chg
+}
+
+func (s *serviceMgrSuite) TestStartEnable(c *C) {
+	chg := s.control(c, &servicestate.Instruction{Action: "start", Enable: true}, "svc2", "svc1")
+
+	s.state.Lock()
+	tasks := chg.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	c.Check(tasks[0].Kind(), Equals, "service-control")
+	c.Check(tasks[0].Summary(), Equals, `Run service command "start" for services "some-snap.svc1", "some-snap.svc2"`)
+	s.state.Unlock()
+
+	s.settle()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"--root", dirs.GlobalRootDir, "enable", "snap.some-snap.svc1.service"},
+		{"start", "snap.some-snap.svc1.service"},
+		{"--root", dirs.GlobalRootDir, "enable", "snap.some-snap.svc2.service"},
+		{"start", "snap.some-snap.svc2.service"},
+	})
+}
+
+func (s *serviceMgrSuite) TestStopDisable(c *C) {
+	chg := s.control(c, &servicestate.Instruction{Action: "stop", Disable: true}, "svc1")
+
+	s.settle()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"stop", "snap.some-snap.svc1.service"},
+		{"show", "--property=ActiveState", "snap.some-snap.svc1.service"},
+		{"--root", dirs.GlobalRootDir, "disable", "snap.some-snap.svc1.service"},
+	})
+}
+
+func (s *serviceMgrSuite) TestRestart(c *C) {
+	chg := s.control(c, &servicestate.Instruction{Action: "restart"}, "svc1")
+
+	s.settle()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"stop", "snap.some-snap.svc1.service"},
+		{"show", "--property=ActiveState", "snap.some-snap.svc1.service"},
+		{"start", "snap.some-snap.svc1.service"},
+	})
+}
+
+func (s *serviceMgrSuite) TestControlErrors(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	svc := []*snap.AppInfo{s.info.Apps["svc1"]}
+	for _, t := range []struct {
+		inst *servicestate.Instruction
+		apps []*snap.AppInfo
+		err  string
+	}{
+		{&servicestate.Instruction{}, svc, `service action is required`},
+		{&servicestate.Instruction{Action: "frob"}, svc, `unknown service action "frob"`},
+		{&servicestate.Instruction{Action: "stop", Enable: true}, svc, `"stop" can only be combined with enable if starting`},
+		{&servicestate.Instruction{Action: "start", Disable: true}, svc, `"start" can only be combined with disable if stopping`},
+		{&servicestate.Instruction{Action: "start"}, nil, `no services to start`},
+		{&servicestate.Instruction{Action: "start"}, []*snap.AppInfo{s.info.Apps["cmd"]}, `some-snap.cmd is not a service`},
+	} {
+		_, err := servicestate.Control(s.state, t.apps, t.inst, nil)
+		c.Check(err, ErrorMatches, t.err)
+	}
+}
+
+func (s *serviceMgrSuite) TestControlConflict(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	svc := []*snap.AppInfo{s.info.Apps["svc1"]}
+	tss, err := servicestate.Control(s.state, svc, &servicestate.Instruction{Action: "stop"}, nil)
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("service-control", "...")
+	chg.AddAll(tss[0])
+
+	// the conflict is found without a snap setup on the task
+	var snapsup snapstate.SnapSetup
+	c.Check(tss[0].Tasks()[0].Get("snap-setup", &snapsup), Equals, state.ErrNoState)
+
+	_, err = servicestate.Control(s.state, svc, &servicestate.Instruction{Action: "start"}, nil)
+	c.Check(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+
+	_, err = snapstate.Remove(s.state, "some-snap", snap.R(0))
+	c.Check(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+}
+
+func (s *serviceMgrSuite) TestControlFromHookIgnoresHookChange(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	svc := []*snap.AppInfo{s.info.Apps["svc1"]}
+	tss, err := servicestate.Control(s.state, svc, &servicestate.Instruction{Action: "stop"}, nil)
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("service-control", "...")
+	chg.AddAll(tss[0])
+
+	task := s.state.NewTask("run-hook", "...")
+	chg.AddTask(task)
+	context, err := hookstate.NewContext(task, &hookstate.HookSetup{Snap: "some-snap", Hook: "configure"}, nil)
+	c.Assert(err, IsNil)
+
+	tss, err = servicestate.Control(s.state, svc, &servicestate.Instruction{Action: "start"}, context)
+	c.Assert(err, IsNil)
+	c.Check(tss, HasLen, 1)
+
+	// tasks of other changes still conflict
+	otherTask := s.state.NewTask("run-hook", "...")
+	s.state.NewChange("configure", "...").AddTask(otherTask)
+	context, err = hookstate.NewContext(otherTask, &hookstate.HookSetup{Snap: "some-snap", Hook: "configure"}, nil)
+	c.Assert(err, IsNil)
+
+	_, err = servicestate.Control(s.state, svc, &servicestate.Instruction{Action: "start"}, context)
+	c.Check(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+}
@@ -0,0 +1,371 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package backend implements the low-level primitives to manage the
+// snapshots of snap data.
+package backend
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "golang.org/x/crypto/sha3" // expected for digests
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+const (
+	archiveName       = "archive.tgz"
+	metadataName      = "meta.json"
+	userArchivePrefix = "user/"
+	userArchiveSuffix = ".tgz"
+)
+
+var timeNow = time.Now
+
+// Filename returns the path of the file of the given snapshot.
+func Filename(snapshot *client.Snapshot) string {
+	return filepath.Join(dirs.SnapshotsDir, fmt.Sprintf("%d_%s_%s_%s.zip", snapshot.SetID, snapshot.Snap, snapshot.Version, snapshot.Revision))
+}
+
+// Iter calls f with a Reader for each of the snapshots in the system,
+// closing the reader once f returns. Snapshots that cannot be opened
+// are skipped. Iteration stops at the first error returned by f,
+// which is then returned.
+func Iter(f func(*Reader) error) error {
+	dir, err := os.Open(dirs.SnapshotsDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if filepath.Ext(name) != ".zip" {
+			continue
+		}
+		reader, err := Open(filepath.Join(dirs.SnapshotsDir, name))
+		if err != nil {
+			logger.Noticef("Cannot open snapshot %q: %v.", name, err)
+			continue
+		}
+		err = f(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List returns the snapshot sets in the system that are in the given
+// set (if non-zero) and are for the given snaps (if non-empty).
+func List(setID uint64, snapNames []string) ([]client.SnapshotSet, error) {
+	setshots := make(map[uint64][]*client.Snapshot)
+	err := Iter(func(reader *Reader) error {
+		if setID != 0 && reader.SetID != setID {
+			return nil
+		}
+		if len(snapNames) > 0 && !strutil.ListContains(snapNames, reader.Snap) {
+			return nil
+		}
+		snapshot := reader.Snapshot
+		setshots[snapshot.SetID] = append(setshots[snapshot.SetID], &snapshot)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]client.SnapshotSet, 0, len(setshots))
+	for id, shots := range setshots {
+		sort.Sort(bySnap(shots))
+		sets = append(sets, client.SnapshotSet{ID: id, Snapshots: shots})
+	}
+	sort.Sort(byID(sets))
+
+	return sets, nil
+}
+
+type bySnap []*client.Snapshot
+
+func (a bySnap) Len() int           { return len(a) }
+func (a bySnap) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySnap) Less(i, j int) bool { return a[i].Snap < a[j].Snap }
+
+type byID []client.SnapshotSet
+
+func (a byID) Len() int           { return len(a) }
+func (a byID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byID) Less(i, j int) bool { return a[i].ID < a[j].ID }
+
+// user is a user whose home might hold snap data.
+type user struct {
+	name string
+	home string
+}
+
+// homeDir returns the home directory of the given user, as a home
+// holding snap data is expected to be.
+func homeDir(username string) string {
+	return strings.Replace(filepath.Dir(dirs.SnapDataHomeGlob), "*", username, 1)
+}
+
+// usersForUsernames returns the users with the given names, or all
+// users if usernames is empty.
+func usersForUsernames(usernames []string) ([]user, error) {
+	homes, err := filepath.Glob(homeDir("*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(homes)
+
+	var users []user
+	for _, home := range homes {
+		name := filepath.Base(home)
+		if len(usernames) > 0 && !strutil.ListContains(usernames, name) {
+			continue
+		}
+		users = append(users, user{name: name, home: home})
+	}
+	return users, nil
+}
+
+func userArchiveName(username string) string {
+	return userArchivePrefix + username + userArchiveSuffix
+}
+
+// userFromArchiveName returns the user whose data is in the given
+// archive, or "" if it is not a user archive.
+func userFromArchiveName(entry string) string {
+	if !strings.HasPrefix(entry, userArchivePrefix) || !strings.HasSuffix(entry, userArchiveSuffix) {
+		return ""
+	}
+	return entry[len(userArchivePrefix) : len(entry)-len(userArchiveSuffix)]
+}
+
+// archiveDir is a directory to put into an archive, under the given
+// name.
+type archiveDir struct {
+	name string
+	path string
+}
+
+// Save saves the data and configuration of the given snap, for the
+// given users (or for all users if usernames is empty), as a snapshot
+// of the set with the given ID.
+func Save(setID uint64, si *snap.Info, cfg map[string]*json.RawMessage, usernames []string, auto bool) (*client.Snapshot, error) {
+	if err := os.MkdirAll(dirs.SnapshotsDir, 0700); err != nil {
+		return nil, err
+	}
+
+	snapshot := &client.Snapshot{
+		SetID:    setID,
+		Time:     timeNow(),
+		Snap:     si.Name(),
+		Revision: si.Revision,
+		SnapID:   si.SnapID,
+		Version:  si.Version,
+		Conf:     cfg,
+		SHA3_384: make(map[string]string),
+		Auto:     auto,
+	}
+
+	filename := Filename(snapshot)
+	f, err := ioutil.TempFile(dirs.SnapshotsDir, filepath.Base(filename)+".")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	w := zip.NewWriter(f)
+	err = addArchive(w, snapshot, archiveName, []archiveDir{
+		{"data", si.DataDir()},
+		{"common", si.CommonDataDir()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := usersForUsernames(usernames)
+	if err != nil {
+		return nil, err
+	}
+	for _, usr := range users {
+		err := addArchive(w, snapshot, userArchiveName(usr.name), []archiveDir{
+			{"data", si.UserDataDir(usr.home)},
+			{"common", si.UserCommonDataDir(usr.home)},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	metaWriter, err := w.Create(metadataName)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(metaWriter).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// addArchive adds to w a compressed tarball of the given directories
+// that exist, if any, recording its hash and size in snapshot.
+func addArchive(w *zip.Writer, snapshot *client.Snapshot, entry string, adirs []archiveDir) error {
+	var existing []archiveDir
+	for _, adir := range adirs {
+		if osutil.IsDirectory(adir.path) {
+			existing = append(existing, adir)
+		}
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+
+	hdr := &zip.FileHeader{
+		Name: entry,
+		// the archive is compressed already
+		Method: zip.Store,
+	}
+	hdr.SetModTime(snapshot.Time)
+	zw, err := w.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	hasher := crypto.SHA3_384.New()
+	cw := &countingWriter{w: io.MultiWriter(zw, hasher)}
+	gz := gzip.NewWriter(cw)
+	tw := tar.NewWriter(gz)
+	for _, adir := range existing {
+		if err := addDir(tw, adir.name, adir.path); err != nil {
+			return fmt.Errorf("cannot archive %q: %v", adir.path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	snapshot.SHA3_384[entry] = fmt.Sprintf("%x", hasher.Sum(nil))
+	snapshot.Size += cw.n
+
+	return nil
+}
+
+// addDir adds the tree under root to tw, with root itself named name.
+// Only directories, regular files and symlinks are added.
+func addDir(tw *tar.Writer, name, root string) error {
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		case fi.IsDir(), fi.Mode().IsRegular():
+		default:
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(name, rel))
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
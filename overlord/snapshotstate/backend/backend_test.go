@@ -0,0 +1,472 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/snap"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type snapshotSuite struct {
+	root    string
+	info    *snap.Info
+	restore func()
+}
+
+var _ = Suite(&snapshotSuite{})
+
+func (s *snapshotSuite) SetUpTest(c *C) {
+	s.root = c.MkDir()
+	dirs.SetRootDir(s.root)
+
+	s.info = &snap.Info{
+		SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"},
+		Version:  "v1.33",
+	}
+
+	now := time.Date(2017, 10, 17, 12, 0, 0, 0, time.UTC)
+	s.restore = backend.MockTimeNow(func() time.Time { return now })
+
+	s.writeFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "system data")
+	s.writeFile(c, filepath.Join(s.info.CommonDataDir(), "sub", "common.txt"), "system common")
+	c.Assert(os.Symlink("canary.txt", filepath.Join(s.info.DataDir(), "link")), IsNil)
+
+	home := filepath.Join(s.root, "home", "snapuser")
+	s.writeFile(c, filepath.Join(s.info.UserDataDir(home), "canary.txt"), "user data")
+	s.writeFile(c, filepath.Join(s.info.UserCommonDataDir(home), "common.txt"), "user common")
+
+	// a user with no data for the snap
+	c.Assert(os.MkdirAll(filepath.Join(s.root, "home", "otheruser"), 0755), IsNil)
+}
+
+func (s *snapshotSuite) TearDownTest(c *C) {
+	s.restore()
+	dirs.SetRootDir("")
+}
+
+func (s *snapshotSuite) writeFile(c *C, path, content string) {
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path, []byte(content), 0644), IsNil)
+}
+
+func (s *snapshotSuite) checkFile(c *C, path, content string) {
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, content)
+}
+
+func (s *snapshotSuite) save(c *C, setID uint64, usernames []string) *client.Snapshot {
+	raw := json.RawMessage(`"bar"`)
+	shot, err := backend.Save(setID, s.info, map[string]*json.RawMessage{"foo": &raw}, usernames, false)
+	c.Assert(err, IsNil)
+	return shot
+}
+
+func (s *snapshotSuite) TestSaveOpen(c *C) {
+	shot := s.save(c, 12, nil)
+
+	c.Check(shot.SetID, Equals, uint64(12))
+	c.Check(shot.Snap, Equals, "hello-snap")
+	c.Check(shot.Revision, Equals, snap.R(42))
+	c.Check(shot.SnapID, Equals, "hello-id")
+	c.Check(shot.Version, Equals, "v1.33")
+	c.Check(shot.Auto, Equals, false)
+	c.Check(shot.SHA3_384, HasLen, 2)
+	c.Check(shot.SHA3_384["archive.tgz"], HasLen, 96)
+	c.Check(shot.SHA3_384["user/snapuser.tgz"], HasLen, 96)
+	c.Check(shot.Size > 0, Equals, true)
+
+	filename := backend.Filename(shot)
+	c.Check(filename, Equals, filepath.Join(dirs.SnapshotsDir, "12_hello-snap_v1.33_42.zip"))
+	fi, err := os.Stat(filename)
+	c.Assert(err, IsNil)
+	c.Check(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	reader, err := backend.Open(filename)
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	c.Check(reader.Name, Equals, filename)
+	c.Check(reader.Time.Equal(shot.Time), Equals, true)
+	reader.Time = shot.Time
+	c.Check(&reader.Snapshot, DeepEquals, shot)
+}
+
+func (s *snapshotSuite) TestSaveSomeUsers(c *C) {
+	shot := s.save(c, 1, []string{"otheruser"})
+	c.Check(shot.SHA3_384, HasLen, 1)
+	c.Check(shot.SHA3_384["archive.tgz"], Not(Equals), "")
+}
+
+func (s *snapshotSuite) TestSaveNoData(c *C) {
+	c.Assert(os.RemoveAll(filepath.Join(s.root, "var")), IsNil)
+	c.Assert(os.RemoveAll(filepath.Join(s.root, "home")), IsNil)
+
+	shot := s.save(c, 1, nil)
+	c.Check(shot.SHA3_384, HasLen, 0)
+	c.Check(shot.Size, Equals, int64(0))
+
+	reader, err := backend.Open(backend.Filename(shot))
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	c.Check(reader.Check(nil), IsNil)
+}
+
+func (s *snapshotSuite) TestListIter(c *C) {
+	s.save(c, 1, nil)
+	s.save(c, 3, nil)
+	s.info.SideInfo.RealName = "other-snap"
+	s.save(c, 1, nil)
+
+	// things that are not snapshots are skipped
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapshotsDir, "2_foo_1_1.zip"), []byte("not a zip"), 0600), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapshotsDir, "README"), nil, 0600), IsNil)
+
+	sets, err := backend.List(0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(sets, HasLen, 2)
+	c.Check(sets[0].ID, Equals, uint64(1))
+	c.Assert(sets[0].Snapshots, HasLen, 2)
+	c.Check(sets[0].Snapshots[0].Snap, Equals, "hello-snap")
+	c.Check(sets[0].Snapshots[1].Snap, Equals, "other-snap")
+	c.Check(sets[1].ID, Equals, uint64(3))
+	c.Assert(sets[1].Snapshots, HasLen, 1)
+
+	sets, err = backend.List(1, []string{"other-snap"})
+	c.Assert(err, IsNil)
+	c.Assert(sets, HasLen, 1)
+	c.Assert(sets[0].Snapshots, HasLen, 1)
+	c.Check(sets[0].Snapshots[0].Snap, Equals, "other-snap")
+
+	sets, err = backend.List(2, nil)
+	c.Assert(err, IsNil)
+	c.Check(sets, HasLen, 0)
+}
+
+func (s *snapshotSuite) TestListNoDir(c *C) {
+	sets, err := backend.List(0, nil)
+	c.Assert(err, IsNil)
+	c.Check(sets, HasLen, 0)
+}
+
+func (s *snapshotSuite) TestCheck(c *C) {
+	shot := s.save(c, 1, nil)
+	filename := backend.Filename(shot)
+
+	reader, err := backend.Open(filename)
+	c.Assert(err, IsNil)
+	c.Check(reader.Check(nil), IsNil)
+	c.Check(reader.Check([]string{"snapuser"}), IsNil)
+	reader.Close()
+
+	s.tamper(c, filename, "user/snapuser.tgz")
+
+	reader, err = backend.Open(filename)
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	c.Check(reader.Check(nil), ErrorMatches, `snapshot entry "user/snapuser.tgz" expected hash \(.*\) does not match actual \(.*\)`)
+	// but the other user's data is fine
+	c.Check(reader.Check([]string{"otheruser"}), IsNil)
+}
+
+// tamper rewrites the snapshot with a different content for the
+// given archive.
+func (s *snapshotSuite) tamper(c *C, filename, entry string) {
+	zr, err := zip.OpenReader(filename)
+	c.Assert(err, IsNil)
+	defer zr.Close()
+
+	f, err := os.Create(filename + ".new")
+	c.Assert(err, IsNil)
+	zw := zip.NewWriter(f)
+	for _, member := range zr.File {
+		w, err := zw.Create(member.Name)
+		c.Assert(err, IsNil)
+		rc, err := member.Open()
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(rc)
+		c.Assert(err, IsNil)
+		rc.Close()
+		if member.Name == entry {
+			data = append(data, 0)
+		}
+		_, err = w.Write(data)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(zw.Close(), IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(os.Rename(filename+".new", filename), IsNil)
+}
+
+func (s *snapshotSuite) TestRestoreCleanup(c *C) {
+	shot := s.save(c, 1, nil)
+	home := filepath.Join(s.root, "home", "snapuser")
+
+	// the data changed since the snapshot, and the snap was refreshed
+	s.writeFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "changed")
+	s.writeFile(c, filepath.Join(s.info.DataDir(), "new.txt"), "new")
+	c.Assert(os.RemoveAll(s.info.UserCommonDataDir(home)), IsNil)
+	current := *s.info
+	current.SideInfo.Revision = snap.R(43)
+
+	reader, err := backend.Open(backend.Filename(shot))
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	rs, err := reader.Restore(&current, nil)
+	c.Assert(err, IsNil)
+	// only the common directory was in the way
+	c.Check(rs.Moved, HasLen, 1)
+	c.Check(rs.Created, HasLen, 4)
+
+	s.checkFile(c, filepath.Join(current.DataDir(), "canary.txt"), "system data")
+	c.Check(osutil.FileExists(filepath.Join(current.DataDir(), "new.txt")), Equals, false)
+	target, err := os.Readlink(filepath.Join(current.DataDir(), "link"))
+	c.Assert(err, IsNil)
+	c.Check(target, Equals, "canary.txt")
+	s.checkFile(c, filepath.Join(current.CommonDataDir(), "sub", "common.txt"), "system common")
+	s.checkFile(c, filepath.Join(current.UserDataDir(home), "canary.txt"), "user data")
+	s.checkFile(c, filepath.Join(current.UserCommonDataDir(home), "common.txt"), "user common")
+
+	// the revision 42 data is untouched
+	s.checkFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "changed")
+
+	rs.Cleanup()
+	for _, dir := range rs.Moved {
+		c.Check(osutil.FileExists(dir), Equals, false)
+	}
+	s.checkFile(c, filepath.Join(current.CommonDataDir(), "sub", "common.txt"), "system common")
+}
+
+func (s *snapshotSuite) TestRestoreRevert(c *C) {
+	shot := s.save(c, 1, nil)
+	home := filepath.Join(s.root, "home", "snapuser")
+
+	s.writeFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "changed")
+	c.Assert(os.RemoveAll(s.info.UserCommonDataDir(home)), IsNil)
+
+	reader, err := backend.Open(backend.Filename(shot))
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	rs, err := reader.Restore(s.info, nil)
+	c.Assert(err, IsNil)
+	s.checkFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "system data")
+	s.checkFile(c, filepath.Join(s.info.UserCommonDataDir(home), "common.txt"), "user common")
+
+	rs.Revert()
+	s.checkFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "changed")
+	s.checkFile(c, filepath.Join(s.info.CommonDataDir(), "sub", "common.txt"), "system common")
+	c.Check(osutil.FileExists(s.info.UserCommonDataDir(home)), Equals, false)
+	matches, err := filepath.Glob(filepath.Join(s.root, "var", "snap", "hello-snap", "*.~*"))
+	c.Assert(err, IsNil)
+	c.Check(matches, HasLen, 0)
+}
+
+func (s *snapshotSuite) TestRestoreOwners(c *C) {
+	shot := s.save(c, 1, nil)
+	home := filepath.Join(s.root, "home", "snapuser")
+	// the user has no snap directory anymore
+	c.Assert(os.RemoveAll(filepath.Join(home, "snap")), IsNil)
+
+	owners := make(map[string]string)
+	restore := backend.MockLchown(func(path string, uid, gid int) error {
+		owners[path] = fmt.Sprintf("%d:%d", uid, gid)
+		return nil
+	})
+	defer restore()
+
+	reader, err := backend.Open(backend.Filename(shot))
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	_, err = reader.Restore(s.info, nil)
+	c.Assert(err, IsNil)
+
+	// what is restored for the user is theirs, including the
+	// directories created on the way
+	userOwner := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	for _, path := range []string{
+		filepath.Join(home, "snap"),
+		filepath.Join(home, "snap", "hello-snap"),
+		s.info.UserDataDir(home),
+		filepath.Join(s.info.UserDataDir(home), "canary.txt"),
+		filepath.Join(s.info.UserCommonDataDir(home), "common.txt"),
+	} {
+		c.Check(owners[path], Equals, userOwner, Commentf(path))
+	}
+	// the rest is root's
+	for _, path := range []string{
+		s.info.DataDir(),
+		filepath.Join(s.info.DataDir(), "canary.txt"),
+		filepath.Join(s.info.DataDir(), "link"),
+		filepath.Join(s.info.CommonDataDir(), "sub", "common.txt"),
+	} {
+		c.Check(owners[path], Equals, "0:0", Commentf(path))
+	}
+}
+
+func (s *snapshotSuite) TestRestoreSomeUsers(c *C) {
+	shot := s.save(c, 1, nil)
+	home := filepath.Join(s.root, "home", "snapuser")
+	s.writeFile(c, filepath.Join(s.info.UserDataDir(home), "canary.txt"), "changed")
+
+	reader, err := backend.Open(backend.Filename(shot))
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	_, err = reader.Restore(s.info, []string{"otheruser"})
+	c.Assert(err, IsNil)
+	s.checkFile(c, filepath.Join(s.info.UserDataDir(home), "canary.txt"), "changed")
+}
+
+func (s *snapshotSuite) TestRestoreBadHashReverts(c *C) {
+	shot := s.save(c, 1, nil)
+	filename := backend.Filename(shot)
+	s.tamper(c, filename, "user/snapuser.tgz")
+	s.writeFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "changed")
+
+	reader, err := backend.Open(filename)
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	_, err = reader.Restore(s.info, nil)
+	c.Assert(err, ErrorMatches, `snapshot entry "user/snapuser.tgz" expected hash .* does not match actual .*`)
+	s.checkFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "changed")
+}
+
+// replaceArchive rewrites the snapshot with a tarball of the given
+// headers, with their names as content for regular files, as the
+// given archive, with a matching hash.
+func (s *snapshotSuite) replaceArchive(c *C, filename, entry string, hdrs []*tar.Header) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range hdrs {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		c.Assert(tw.WriteHeader(hdr), IsNil)
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(hdr.Name))
+			c.Assert(err, IsNil)
+		}
+	}
+	c.Assert(tw.Close(), IsNil)
+	c.Assert(gz.Close(), IsNil)
+	hasher := crypto.SHA3_384.New()
+	hasher.Write(buf.Bytes())
+
+	zr, err := zip.OpenReader(filename)
+	c.Assert(err, IsNil)
+	defer zr.Close()
+	f, err := os.Create(filename + ".new")
+	c.Assert(err, IsNil)
+	zw := zip.NewWriter(f)
+	for _, member := range zr.File {
+		rc, err := member.Open()
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(rc)
+		c.Assert(err, IsNil)
+		rc.Close()
+		switch member.Name {
+		case entry:
+			data = buf.Bytes()
+		case "meta.json":
+			var meta map[string]interface{}
+			c.Assert(json.Unmarshal(data, &meta), IsNil)
+			meta["sha3-384"].(map[string]interface{})[entry] = fmt.Sprintf("%x", hasher.Sum(nil))
+			data, err = json.Marshal(meta)
+			c.Assert(err, IsNil)
+		}
+		w, err := zw.Create(member.Name)
+		c.Assert(err, IsNil)
+		_, err = w.Write(data)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(zw.Close(), IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(os.Rename(filename+".new", filename), IsNil)
+}
+
+func (s *snapshotSuite) TestRestoreOutsideOfTargetReverts(c *C) {
+	outside := filepath.Join(s.root, "outside")
+	c.Assert(os.MkdirAll(outside, 0755), IsNil)
+
+	for _, hdrs := range [][]*tar.Header{
+		// writing through a symlink
+		{
+			{Name: "data/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "data/escape", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "data/escape/evil", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		// creating directories through a symlink
+		{
+			{Name: "data/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "data/escape", Typeflag: tar.TypeSymlink, Linkname: "../../../../outside"},
+			{Name: "data/escape/evil/", Typeflag: tar.TypeDir, Mode: 0755},
+		},
+		// changing what a symlink points to
+		{
+			{Name: "data/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "data/escape", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "data/escape/", Typeflag: tar.TypeDir, Mode: 0700},
+		},
+	} {
+		shot := s.save(c, 1, nil)
+		filename := backend.Filename(shot)
+		s.replaceArchive(c, filename, "archive.tgz", hdrs)
+		s.writeFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "changed")
+
+		reader, err := backend.Open(filename)
+		c.Assert(err, IsNil)
+		_, err = reader.Restore(s.info, nil)
+		reader.Close()
+		c.Check(err, ErrorMatches, `cannot restore "data/escape/.*" from snapshot entry "archive.tgz": path (leads outside of .*|is a symlink)`)
+
+		entries, err := ioutil.ReadDir(outside)
+		c.Assert(err, IsNil)
+		c.Check(entries, HasLen, 0)
+		fi, err := os.Stat(outside)
+		c.Assert(err, IsNil)
+		c.Check(fi.Mode().Perm(), Equals, os.FileMode(0755))
+		s.checkFile(c, filepath.Join(s.info.DataDir(), "canary.txt"), "changed")
+		c.Assert(os.Remove(filename), IsNil)
+	}
+}
@@ -0,0 +1,432 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// A Reader is a snapshot that's been opened for reading.
+type Reader struct {
+	client.Snapshot
+	// Name is the name of the file of the snapshot.
+	Name string
+
+	zr *zip.ReadCloser
+}
+
+// Open a snapshot, given its filename.
+func Open(filename string) (*Reader, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	reader := &Reader{Name: filename, zr: zr}
+
+	if err := reader.readMetadata(); err != nil {
+		zr.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+func (r *Reader) readMetadata() error {
+	f := r.member(metadataName)
+	if f == nil {
+		return fmt.Errorf("snapshot has no metadata")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := json.NewDecoder(rc).Decode(&r.Snapshot); err != nil {
+		return fmt.Errorf("cannot read snapshot metadata: %v", err)
+	}
+	return nil
+}
+
+// Close the snapshot.
+func (r *Reader) Close() error {
+	return r.zr.Close()
+}
+
+func (r *Reader) member(name string) *zip.File {
+	for _, f := range r.zr.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// archives returns the names of the archives in the snapshot that
+// hold the system data and the data of the given users (or of all
+// users if usernames is empty), in order.
+func (r *Reader) archives(usernames []string) []string {
+	entries := make([]string, 0, len(r.SHA3_384))
+	for entry := range r.SHA3_384 {
+		if entry != archiveName {
+			username := userFromArchiveName(entry)
+			if username == "" {
+				continue
+			}
+			if len(usernames) > 0 && !strutil.ListContains(usernames, username) {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+func (r *Reader) hashError(entry, actual string) error {
+	return fmt.Errorf("snapshot entry %q expected hash (%.7s…) does not match actual (%.7s…)", entry, r.SHA3_384[entry], actual)
+}
+
+// Check that the archives of the snapshot for the given users (or for
+// all users if usernames is empty) match their hashes.
+func (r *Reader) Check(usernames []string) error {
+	for _, entry := range r.archives(usernames) {
+		f := r.member(entry)
+		if f == nil {
+			return fmt.Errorf("snapshot entry %q not found", entry)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		hasher := crypto.SHA3_384.New()
+		_, err = io.Copy(hasher, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read snapshot entry %q: %v", entry, err)
+		}
+		if actual := fmt.Sprintf("%x", hasher.Sum(nil)); actual != r.SHA3_384[entry] {
+			return r.hashError(entry, actual)
+		}
+	}
+	return nil
+}
+
+// RestoreState holds what is needed to revert, or to finish, a
+// Restore.
+type RestoreState struct {
+	// Created are the directories created by the restore.
+	Created []string `json:"created,omitempty"`
+	// Moved are the directories moved aside by the restore,
+	// to make room for the restored ones.
+	Moved []string `json:"moved,omitempty"`
+}
+
+// asideSuffix is the start of the suffix of directories moved aside.
+const asideSuffix = ".~"
+
+func originalPath(aside string) string {
+	return aside[:strings.LastIndex(aside, asideSuffix)]
+}
+
+// Cleanup removes the directories moved aside by the restore, once
+// it is known to be good.
+func (rs *RestoreState) Cleanup() {
+	for _, dir := range rs.Moved {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Noticef("Cannot remove directory moved aside by snapshot restore %q: %v.", dir, err)
+		}
+	}
+}
+
+// Revert undoes the restore, removing the restored directories and
+// moving the original ones back.
+func (rs *RestoreState) Revert() {
+	for i := len(rs.Created) - 1; i >= 0; i-- {
+		if err := os.RemoveAll(rs.Created[i]); err != nil {
+			logger.Noticef("Cannot remove directory created by snapshot restore %q: %v.", rs.Created[i], err)
+		}
+	}
+	for _, dir := range rs.Moved {
+		if err := os.Rename(dir, originalPath(dir)); err != nil {
+			logger.Noticef("Cannot move back directory moved aside by snapshot restore %q: %v.", dir, err)
+		}
+	}
+}
+
+// Restore the data of the snapshot for the given users (or for all
+// users if usernames is empty) into the data directories of the
+// given snap (usually its current revision), once the archives are
+// known to match their hashes. Directories in the way are moved
+// aside, to be removed by Cleanup or moved back by Revert. What is
+// restored is owned by root, or by the owner of the home directory for
+// user data, regardless of the owners recorded in the archives.
+func (r *Reader) Restore(si *snap.Info, usernames []string) (*RestoreState, error) {
+	// nothing gets written from an archive that was tampered with
+	if err := r.Check(usernames); err != nil {
+		return nil, err
+	}
+
+	rs := &RestoreState{}
+
+	for _, entry := range r.archives(usernames) {
+		targets := make(map[string]string, 2)
+		var uid, gid int
+		if entry == archiveName {
+			targets["data"] = si.DataDir()
+			targets["common"] = si.CommonDataDir()
+		} else {
+			username := userFromArchiveName(entry)
+			home := homeDir(username)
+			if !osutil.IsDirectory(home) {
+				logger.Noticef("Not restoring snapshot data of %q for user %q: no home directory.", r.Snap, username)
+				continue
+			}
+			var err error
+			if uid, gid, err = ownerOf(home); err != nil {
+				rs.Revert()
+				return nil, err
+			}
+			targets["data"] = si.UserDataDir(home)
+			targets["common"] = si.UserCommonDataDir(home)
+		}
+
+		if err := r.restoreArchive(entry, targets, uid, gid, rs); err != nil {
+			rs.Revert()
+			return nil, err
+		}
+	}
+
+	return rs, nil
+}
+
+// ownerOf returns the uid and gid owning path.
+func ownerOf(path string) (uid, gid int, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, fmt.Errorf("cannot get the owner of %q", path)
+	}
+	return int(st.Uid), int(st.Gid), nil
+}
+
+// lchown changes the owner of what gets restored, which only root can
+// do.
+var lchown = func(path string, uid, gid int) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+// restoreArchive unpacks the given archive, whose top level entries
+// are named after the keys of targets, into the respective targets,
+// owned by uid and gid. Entries that would end up outside of their
+// target, through the symlinks of the archive, are rejected. The hash
+// of the archive is checked again as it is read, in case the snapshot
+// changed since it was checked.
+func (r *Reader) restoreArchive(entry string, targets map[string]string, uid, gid int, rs *RestoreState) error {
+	f := r.member(entry)
+	if f == nil {
+		return fmt.Errorf("snapshot entry %q not found", entry)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	hasher := crypto.SHA3_384.New()
+	tee := io.TeeReader(rc, hasher)
+	gz, err := gzip.NewReader(tee)
+	if err != nil {
+		return fmt.Errorf("cannot read snapshot entry %q: %v", entry, err)
+	}
+	tr := tar.NewReader(gz)
+
+	// the targets with any symlinks in their path resolved
+	prepared := make(map[string]string, len(targets))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read snapshot entry %q: %v", entry, err)
+		}
+
+		parts := strings.SplitN(path.Clean(hdr.Name), "/", 2)
+		target, ok := targets[parts[0]]
+		if !ok {
+			return fmt.Errorf("unexpected %q in snapshot entry %q", hdr.Name, entry)
+		}
+		resolved, ok := prepared[parts[0]]
+		if !ok {
+			if err := prepareTarget(target, uid, gid, rs); err != nil {
+				return err
+			}
+			if resolved, err = filepath.EvalSymlinks(target); err != nil {
+				return err
+			}
+			prepared[parts[0]] = resolved
+		}
+		dest := target
+		if len(parts) == 2 {
+			dest = filepath.Join(target, filepath.FromSlash(parts[1]))
+			if err := checkInside(resolved, dest); err != nil {
+				return fmt.Errorf("cannot restore %q from snapshot entry %q: %v", hdr.Name, entry, err)
+			}
+		}
+		if err := extract(tr, hdr, dest, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	// hash whatever trails the tarball as well
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return fmt.Errorf("cannot read snapshot entry %q: %v", entry, err)
+	}
+	if actual := fmt.Sprintf("%x", hasher.Sum(nil)); actual != r.SHA3_384[entry] {
+		return r.hashError(entry, actual)
+	}
+
+	return nil
+}
+
+// prepareTarget moves the target aside if it exists, and creates it
+// anew, together with any missing parent directories, owned by uid and
+// gid, recording what it did in rs.
+func prepareTarget(target string, uid, gid int, rs *RestoreState) error {
+	if _, err := os.Lstat(target); err == nil {
+		aside := target + asideSuffix + strconv.FormatInt(timeNow().UnixNano(), 36) + "~"
+		if err := os.Rename(target, aside); err != nil {
+			return err
+		}
+		rs.Moved = append(rs.Moved, aside)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// the directories to create, the topmost last
+	var missing []string
+	for dir := target; !osutil.FileExists(dir); dir = filepath.Dir(dir) {
+		missing = append(missing, dir)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	// record the topmost directory first, so that a revert removes
+	// whatever got created
+	rs.Created = append(rs.Created, missing[len(missing)-1])
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil {
+			return err
+		}
+		if err := lchown(missing[i], uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkInside checks that dest is inside root once the symlinks in its
+// path are resolved, and that it is not a symlink itself, so that
+// writing it cannot touch anything outside of root.
+func checkInside(root, dest string) error {
+	// the deepest existing directory is what counts, the rest
+	// gets created
+	dir := filepath.Dir(dest)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+				return fmt.Errorf("path leads outside of %q", root)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	if fi, err := os.Lstat(dest); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("path is a symlink")
+	}
+	return nil
+}
+
+// extract writes what hdr describes to dest, owned by uid and gid
+// whatever the archive says.
+func extract(tr *tar.Reader, hdr *tar.Header, dest string, uid, gid int) error {
+	mode := hdr.FileInfo().Mode()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(dest, mode.Perm()); err != nil {
+			return err
+		}
+		if err := os.Chmod(dest, mode.Perm()); err != nil {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, dest); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	return lchown(dest, uid, gid)
+}
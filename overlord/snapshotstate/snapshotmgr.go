@@ -0,0 +1,236 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapshotstate
+
+import (
+	"encoding/json"
+	"os"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// SnapshotManager is responsible for taking, checking, restoring and
+// forgetting snapshots of snap data.
+type SnapshotManager struct {
+	state  *state.State
+	runner *state.TaskRunner
+}
+
+// Manager returns a new snapshot manager.
+func Manager(st *state.State) (*SnapshotManager, error) {
+	runner := state.NewTaskRunner(st)
+	m := &SnapshotManager{state: st, runner: runner}
+
+	runner.AddHandler("save-snapshot", m.doSave, m.doForget)
+	runner.AddHandler("forget-snapshot", m.doForget, nil)
+	runner.AddHandler("check-snapshot", m.doCheck, nil)
+	runner.AddHandler("restore-snapshot", m.doRestore, m.undoRestore)
+	runner.AddHandler("cleanup-after-restore", m.doCleanupAfterRestore, nil)
+
+	return m, nil
+}
+
+// Ensure implements StateManager.Ensure.
+func (m *SnapshotManager) Ensure() error {
+	m.runner.Ensure()
+	return nil
+}
+
+// Wait implements StateManager.Wait.
+func (m *SnapshotManager) Wait() {
+	m.runner.Wait()
+}
+
+// Stop implements StateManager.Stop.
+func (m *SnapshotManager) Stop() {
+	m.runner.Stop()
+}
+
+func taskGetSnapshotSetup(task *state.Task) (*snapshotSetup, error) {
+	var setup snapshotSetup
+	if err := task.Get("snapshot-setup", &setup); err != nil {
+		return nil, err
+	}
+	return &setup, nil
+}
+
+func (m *SnapshotManager) doSave(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	setup, err := taskGetSnapshotSetup(task)
+	if err != nil {
+		st.Unlock()
+		return err
+	}
+	info, err := snapstate.CurrentInfo(st, setup.Snap)
+	if err != nil {
+		st.Unlock()
+		return err
+	}
+	cfg, err := config.GetSnapConfig(st, setup.Snap)
+	st.Unlock()
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := backend.Save(setup.SetID, info, cfg, setup.Users, setup.Auto)
+	if err != nil {
+		return err
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	setup.Filename = backend.Filename(snapshot)
+	task.Set("snapshot-setup", setup)
+
+	return nil
+}
+
+func (m *SnapshotManager) doForget(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	setup, err := taskGetSnapshotSetup(task)
+	st.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if setup.Filename == "" {
+		return nil
+	}
+	if err := os.Remove(setup.Filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (m *SnapshotManager) doCheck(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	setup, err := taskGetSnapshotSetup(task)
+	st.Unlock()
+	if err != nil {
+		return err
+	}
+
+	reader, err := backend.Open(setup.Filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return reader.Check(setup.Users)
+}
+
+// restoreState is what is needed to undo, or to finish, the restore
+// of a snapshot.
+type restoreState struct {
+	backend.RestoreState
+	// Config is the configuration of the snap before the restore.
+	Config map[string]*json.RawMessage `json:"config,omitempty"`
+}
+
+func (m *SnapshotManager) doRestore(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	setup, err := taskGetSnapshotSetup(task)
+	if err != nil {
+		st.Unlock()
+		return err
+	}
+	info, err := snapstate.CurrentInfo(st, setup.Snap)
+	if err != nil {
+		st.Unlock()
+		return err
+	}
+	oldConfig, err := config.GetSnapConfig(st, setup.Snap)
+	st.Unlock()
+	if err != nil {
+		return err
+	}
+
+	reader, err := backend.Open(setup.Filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	rs, err := reader.Restore(info, setup.Users)
+	if err != nil {
+		return err
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	if err := config.SetSnapConfig(st, setup.Snap, reader.Conf); err != nil {
+		rs.Revert()
+		return err
+	}
+	task.Set("restore-state", &restoreState{RestoreState: *rs, Config: oldConfig})
+
+	return nil
+}
+
+func (m *SnapshotManager) undoRestore(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var rs restoreState
+	if err := task.Get("restore-state", &rs); err != nil {
+		return err
+	}
+	setup, err := taskGetSnapshotSetup(task)
+	if err != nil {
+		return err
+	}
+
+	rs.Revert()
+	return config.SetSnapConfig(st, setup.Snap, rs.Config)
+}
+
+func (m *SnapshotManager) doCleanupAfterRestore(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	var states []*restoreState
+	for _, t := range task.WaitTasks() {
+		if t.Kind() != "restore-snapshot" {
+			continue
+		}
+		var rs restoreState
+		if err := t.Get("restore-state", &rs); err != nil {
+			st.Unlock()
+			return err
+		}
+		states = append(states, &rs)
+	}
+	st.Unlock()
+
+	for _, rs := range states {
+		rs.Cleanup()
+	}
+
+	return nil
+}
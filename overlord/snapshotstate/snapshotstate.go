@@ -0,0 +1,297 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package snapshotstate implements the manager and state aspects
+// responsible for the snapshots of snap data.
+package snapshotstate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n/dumb"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+func init() {
+	snapstate.AutomaticSnapshot = AutomaticSnapshot
+}
+
+// ErrNoSnapshot is returned when there is no snapshot set with the
+// requested ID.
+var ErrNoSnapshot = errors.New("no snapshot has the given set id")
+
+// snapshotSetup is the data carried by the snapshot tasks.
+type snapshotSetup struct {
+	SetID    uint64   `json:"set-id"`
+	Snap     string   `json:"snap"`
+	Users    []string `json:"users,omitempty"`
+	Filename string   `json:"filename,omitempty"`
+	Auto     bool     `json:"auto,omitempty"`
+}
+
+// newSnapshotSetID returns the ID for a new snapshot set.
+func newSnapshotSetID(st *state.State) (uint64, error) {
+	var lastSetID uint64
+	err := st.Get("last-snapshot-set-id", &lastSetID)
+	if err != nil && err != state.ErrNoState {
+		return 0, err
+	}
+
+	// the snapshots might have outlived the state they were taken in
+	err = backend.Iter(func(reader *backend.Reader) error {
+		if reader.SetID > lastSetID {
+			lastSetID = reader.SetID
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	lastSetID++
+	st.Set("last-snapshot-set-id", lastSetID)
+
+	return lastSetID, nil
+}
+
+func allActiveSnapNames(st *state.State) ([]string, error) {
+	all, err := snapstate.All(st)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(all))
+	for name, snapst := range all {
+		if snapst.Active {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// snapSummariesInSnapshotSet returns the setups for acting on the
+// snapshots of the given snaps (or of all snaps, if snapNames is
+// empty) in the given set.
+func snapSummariesInSnapshotSet(setID uint64, snapNames []string) ([]*snapshotSetup, error) {
+	found := false
+	var summaries []*snapshotSetup
+	err := backend.Iter(func(reader *backend.Reader) error {
+		if reader.SetID != setID {
+			return nil
+		}
+		found = true
+		if len(snapNames) > 0 && !strutil.ListContains(snapNames, reader.Snap) {
+			return nil
+		}
+		summaries = append(summaries, &snapshotSetup{
+			SetID:    setID,
+			Snap:     reader.Snap,
+			Filename: reader.Name,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNoSnapshot
+	}
+
+	for _, name := range snapNames {
+		found := false
+		for _, summary := range summaries {
+			if summary.Snap == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("snapshot set #%d has no snapshot of snap %q", setID, name)
+		}
+	}
+
+	return summaries, nil
+}
+
+// List returns the snapshot sets with the given ID (or all of them,
+// if setID is zero), limited to the given snaps (if not empty).
+func List(setID uint64, snapNames []string) ([]client.SnapshotSet, error) {
+	return backend.List(setID, snapNames)
+}
+
+// Save creates the tasks to take snapshots of the data of the given
+// snaps (or of all active snaps, if snapNames is empty) for the given
+// users (or for all users, if users is empty), as a new snapshot set.
+func Save(st *state.State, snapNames []string, users []string) (setID uint64, snapsSaved []string, ts *state.TaskSet, err error) {
+	if len(snapNames) == 0 {
+		snapNames, err = allActiveSnapNames(st)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if len(snapNames) == 0 {
+			return 0, nil, nil, fmt.Errorf("no snaps to save")
+		}
+	}
+
+	for _, name := range snapNames {
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, name, &snapst); err != nil {
+			if err == state.ErrNoState {
+				return 0, nil, nil, &snap.NotInstalledError{Snap: name}
+			}
+			return 0, nil, nil, err
+		}
+		if err := snapstate.CheckChangeConflict(st, name, nil); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	setID, err = newSnapshotSetID(st)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	ts = state.NewTaskSet()
+	for _, name := range snapNames {
+		desc := fmt.Sprintf(i18n.G("Save data of snap %q in snapshot set #%d"), name, setID)
+		task := st.NewTask("save-snapshot", desc)
+		task.Set("snapshot-setup", &snapshotSetup{
+			SetID: setID,
+			Snap:  name,
+			Users: users,
+		})
+		ts.AddTask(task)
+	}
+
+	return setID, snapNames, ts, nil
+}
+
+// AutomaticSnapshot creates the task set to take a snapshot of the
+// data of the given snap, as its own snapshot set, before the snap
+// is removed.
+func AutomaticSnapshot(st *state.State, snapName string) (*state.TaskSet, error) {
+	setID, err := newSnapshotSetID(st)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := fmt.Sprintf(i18n.G("Save data of snap %q in automatic snapshot set #%d"), snapName, setID)
+	task := st.NewTask("save-snapshot", desc)
+	task.Set("snapshot-setup", &snapshotSetup{
+		SetID: setID,
+		Snap:  snapName,
+		Auto:  true,
+	})
+
+	return state.NewTaskSet(task), nil
+}
+
+// Restore creates the tasks to restore the data and configuration of
+// the given snaps (or of all snaps in the set, if snapNames is empty)
+// from the snapshot set with the given ID, for the given users (or
+// for all users, if users is empty).
+func Restore(st *state.State, setID uint64, snapNames []string, users []string) (snapsRestored []string, ts *state.TaskSet, err error) {
+	summaries, err := snapSummariesInSnapshotSet(setID, snapNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ts = state.NewTaskSet()
+	for _, summary := range summaries {
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, summary.Snap, &snapst); err != nil {
+			if err == state.ErrNoState {
+				return nil, nil, &snap.NotInstalledError{Snap: summary.Snap}
+			}
+			return nil, nil, err
+		}
+		if err := snapstate.CheckChangeConflict(st, summary.Snap, nil); err != nil {
+			return nil, nil, err
+		}
+
+		summary.Users = users
+		desc := fmt.Sprintf(i18n.G("Restore data of snap %q from snapshot set #%d"), summary.Snap, setID)
+		task := st.NewTask("restore-snapshot", desc)
+		task.Set("snapshot-setup", summary)
+		// a snap setup lets snap operations see this task as a conflict
+		task.Set("snap-setup", &snapstate.SnapSetup{
+			SideInfo: &snap.SideInfo{RealName: summary.Snap},
+		})
+		ts.AddTask(task)
+		snapsRestored = append(snapsRestored, summary.Snap)
+	}
+
+	cleanup := st.NewTask("cleanup-after-restore", fmt.Sprintf(i18n.G("Clean up after restoring snapshot set #%d"), setID))
+	cleanup.WaitAll(ts)
+	ts.AddTask(cleanup)
+
+	return snapsRestored, ts, nil
+}
+
+// Check creates the tasks to verify the snapshots of the given snaps
+// (or of all snaps in the set, if snapNames is empty) in the snapshot
+// set with the given ID, for the given users (or for all users, if
+// users is empty).
+func Check(st *state.State, setID uint64, snapNames []string, users []string) (snapsChecked []string, ts *state.TaskSet, err error) {
+	summaries, err := snapSummariesInSnapshotSet(setID, snapNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ts = state.NewTaskSet()
+	for _, summary := range summaries {
+		summary.Users = users
+		desc := fmt.Sprintf(i18n.G("Check data of snap %q in snapshot set #%d"), summary.Snap, setID)
+		task := st.NewTask("check-snapshot", desc)
+		task.Set("snapshot-setup", summary)
+		ts.AddTask(task)
+		snapsChecked = append(snapsChecked, summary.Snap)
+	}
+
+	return snapsChecked, ts, nil
+}
+
+// Forget creates the tasks to remove the snapshots of the given snaps
+// (or of all snaps in the set, if snapNames is empty) in the snapshot
+// set with the given ID.
+func Forget(st *state.State, setID uint64, snapNames []string) (snapsForgotten []string, ts *state.TaskSet, err error) {
+	summaries, err := snapSummariesInSnapshotSet(setID, snapNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ts = state.NewTaskSet()
+	for _, summary := range summaries {
+		desc := fmt.Sprintf(i18n.G("Drop data of snap %q from snapshot set #%d"), summary.Snap, setID)
+		task := st.NewTask("forget-snapshot", desc)
+		task.Set("snapshot-setup", summary)
+		ts.AddTask(task)
+		snapsForgotten = append(snapsForgotten, summary.Snap)
+	}
+
+	return snapsForgotten, ts, nil
+}
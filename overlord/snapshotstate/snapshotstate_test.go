@@ -0,0 +1,328 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapshotstate_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapshotstate"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+)
+
+func TestSnapshotState(t *testing.T) { TestingT(t) }
+
+type snapshotSuite struct {
+	state *state.State
+	mgr   *snapshotstate.SnapshotManager
+	info  *snap.Info
+}
+
+var _ = Suite(&snapshotSuite{})
+
+func (s *snapshotSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+
+	s.state = state.New(nil)
+	mgr, err := snapshotstate.Manager(s.state)
+	c.Assert(err, IsNil)
+	s.mgr = mgr
+
+	si := &snap.SideInfo{RealName: "some-snap", Revision: snap.R(1)}
+	s.info = snaptest.MockSnap(c, "name: some-snap\nversion: 1.0\n", "", si)
+
+	s.state.Lock()
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+	})
+	s.state.Unlock()
+}
+
+func (s *snapshotSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("")
+}
+
+func (s *snapshotSuite) settle() {
+	for i := 0; i < 50; i++ {
+		s.mgr.Ensure()
+		s.mgr.Wait()
+	}
+}
+
+func (s *snapshotSuite) run(c *C, kind string, ts *state.TaskSet) *state.Change {
+	chg := s.state.NewChange(kind, "...")
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	s.settle()
+	s.state.Lock()
+
+	return chg
+}
+
+func (s *snapshotSuite) writeData(c *C, content string) {
+	c.Assert(os.MkdirAll(s.info.DataDir(), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.info.DataDir(), "canary.txt"), []byte(content), 0644), IsNil)
+}
+
+func (s *snapshotSuite) readData(c *C) string {
+	content, err := ioutil.ReadFile(filepath.Join(s.info.DataDir(), "canary.txt"))
+	c.Assert(err, IsNil)
+	return string(content)
+}
+
+func (s *snapshotSuite) setConfig(c *C, key string, value interface{}) {
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("some-snap", key, value), IsNil)
+	tr.Commit()
+}
+
+func (s *snapshotSuite) getConfig(c *C, key string) string {
+	var value string
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Get("some-snap", key, &value), IsNil)
+	return value
+}
+
+func (s *snapshotSuite) save(c *C) uint64 {
+	setID, saved, ts, err := snapshotstate.Save(s.state, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(saved, DeepEquals, []string{"some-snap"})
+
+	chg := s.run(c, "save-snapshot", ts)
+	c.Assert(chg.Err(), IsNil)
+	c.Assert(chg.Status(), Equals, state.DoneStatus)
+
+	return setID
+}
+
+func (s *snapshotSuite) TestSaveTasks(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	setID, saved, ts, err := snapshotstate.Save(s.state, []string{"some-snap"}, []string{"user"})
+	c.Assert(err, IsNil)
+	c.Check(setID, Equals, uint64(1))
+	c.Check(saved, DeepEquals, []string{"some-snap"})
+
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	c.Check(tasks[0].Kind(), Equals, "save-snapshot")
+	c.Check(tasks[0].Summary(), Equals, `Save data of snap "some-snap" in snapshot set #1`)
+
+	setID, _, _, err = snapshotstate.Save(s.state, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(setID, Equals, uint64(2))
+}
+
+func (s *snapshotSuite) TestSaveErrors(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, _, _, err := snapshotstate.Save(s.state, []string{"other-snap"}, nil)
+	c.Check(err, ErrorMatches, `snap "other-snap" is not installed`)
+
+	chg := s.state.NewChange("install", "...")
+	task := s.state.NewTask("link-snap", "...")
+	task.Set("snap-setup", &snapstate.SnapSetup{SideInfo: &snap.SideInfo{RealName: "some-snap"}})
+	chg.AddTask(task)
+
+	_, _, _, err = snapshotstate.Save(s.state, []string{"some-snap"}, nil)
+	c.Check(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+
+	snapstate.Set(s.state, "some-snap", nil)
+	_, _, _, err = snapshotstate.Save(s.state, nil, nil)
+	c.Check(err, ErrorMatches, `no snaps to save`)
+}
+
+func (s *snapshotSuite) TestSetIDConsidersSnapshotsOnDisk(c *C) {
+	_, err := backend.Save(42, s.info, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	setID, _, _, err := snapshotstate.Save(s.state, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(setID, Equals, uint64(43))
+}
+
+func (s *snapshotSuite) TestAutomaticSnapshot(c *C) {
+	s.writeData(c, "hello")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	ts, err := snapstate.AutomaticSnapshot(s.state, "some-snap")
+	c.Assert(err, IsNil)
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	c.Check(tasks[0].Summary(), Equals, `Save data of snap "some-snap" in automatic snapshot set #1`)
+
+	chg := s.run(c, "remove-snap", ts)
+	c.Assert(chg.Err(), IsNil)
+
+	sets, err := snapshotstate.List(0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(sets, HasLen, 1)
+	c.Assert(sets[0].Snapshots, HasLen, 1)
+	c.Check(sets[0].Snapshots[0].Snap, Equals, "some-snap")
+	c.Check(sets[0].Snapshots[0].Auto, Equals, true)
+}
+
+func (s *snapshotSuite) TestSaveRestoreRunThrough(c *C) {
+	s.writeData(c, "before")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setConfig(c, "key", "before")
+	setID := s.save(c)
+
+	s.writeData(c, "after")
+	s.setConfig(c, "key", "after")
+
+	restored, ts, err := snapshotstate.Restore(s.state, setID, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(restored, DeepEquals, []string{"some-snap"})
+	c.Check(taskKinds(ts.Tasks()), DeepEquals, []string{"restore-snapshot", "cleanup-after-restore"})
+
+	chg := s.run(c, "restore-snapshot", ts)
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+
+	c.Check(s.readData(c), Equals, "before")
+	c.Check(s.getConfig(c, "key"), Equals, "before")
+
+	// nothing left aside
+	matches, err := filepath.Glob(s.info.DataDir() + ".~*")
+	c.Assert(err, IsNil)
+	c.Check(matches, HasLen, 0)
+}
+
+func (s *snapshotSuite) TestRestoreUndo(c *C) {
+	s.mgr.AddAdhocTaskHandler("error-trigger", func(*state.Task, *tomb.Tomb) error {
+		return errors.New("boom")
+	}, nil)
+
+	s.writeData(c, "before")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setConfig(c, "key", "before")
+	setID := s.save(c)
+
+	s.writeData(c, "after")
+	s.setConfig(c, "key", "after")
+
+	_, ts, err := snapshotstate.Restore(s.state, setID, nil, nil)
+	c.Assert(err, IsNil)
+	// fail after the restore, but before the cleanup
+	tasks := ts.Tasks()
+	errTask := s.state.NewTask("error-trigger", "...")
+	errTask.WaitFor(tasks[0])
+	tasks[1].WaitFor(errTask)
+	ts.AddTask(errTask)
+
+	chg := s.run(c, "restore-snapshot", ts)
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+
+	c.Check(s.readData(c), Equals, "after")
+	c.Check(s.getConfig(c, "key"), Equals, "after")
+}
+
+func (s *snapshotSuite) TestRestoreConflictsWithSnapOperations(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	setID := s.save(c)
+
+	_, ts, err := snapshotstate.Restore(s.state, setID, nil, nil)
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("restore-snapshot", "...")
+	chg.AddAll(ts)
+
+	err = snapstate.CheckChangeConflict(s.state, "some-snap", nil)
+	c.Check(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+}
+
+func (s *snapshotSuite) TestCheckAndForget(c *C) {
+	s.writeData(c, "hello")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	setID := s.save(c)
+
+	checked, ts, err := snapshotstate.Check(s.state, setID, []string{"some-snap"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(checked, DeepEquals, []string{"some-snap"})
+	chg := s.run(c, "check-snapshot", ts)
+	c.Check(chg.Err(), IsNil)
+
+	forgotten, ts, err := snapshotstate.Forget(s.state, setID, nil)
+	c.Assert(err, IsNil)
+	c.Check(forgotten, DeepEquals, []string{"some-snap"})
+	chg = s.run(c, "forget-snapshot", ts)
+	c.Check(chg.Err(), IsNil)
+
+	sets, err := snapshotstate.List(0, nil)
+	c.Assert(err, IsNil)
+	c.Check(sets, HasLen, 0)
+}
+
+func (s *snapshotSuite) TestNoSnapshot(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, _, err := snapshotstate.Check(s.state, 42, nil, nil)
+	c.Check(err, Equals, snapshotstate.ErrNoSnapshot)
+	_, _, err = snapshotstate.Restore(s.state, 42, nil, nil)
+	c.Check(err, Equals, snapshotstate.ErrNoSnapshot)
+	_, _, err = snapshotstate.Forget(s.state, 42, nil)
+	c.Check(err, Equals, snapshotstate.ErrNoSnapshot)
+
+	setID := s.save(c)
+	_, _, err = snapshotstate.Forget(s.state, setID, []string{"other-snap"})
+	c.Check(err, ErrorMatches, `snapshot set #1 has no snapshot of snap "other-snap"`)
+}
+
+func taskKinds(tasks []*state.Task) []string {
+	kinds := make([]string, len(tasks))
+	for i, task := range tasks {
+		kinds[i] = task.Kind()
+	}
+	return kinds
+}
@@ -36,7 +36,7 @@ type StoreService interface {
 	Find(search *store.Search, user *auth.UserState) ([]*snap.Info, error)
 	ListRefresh([]*store.RefreshCandidate, *auth.UserState) ([]*snap.Info, error)
 	Sections(user *auth.UserState) ([]string, error)
-	Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState) error
+	Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState, *store.DownloadOptions) error
 
 	Assertion(assertType *asserts.AssertionType, primaryKey []string, user *auth.UserState) (asserts.Assertion, error)
 
@@ -52,12 +52,15 @@ type managerBackend interface {
 	LinkSnap(info *snap.Info) error
 	StartSnapServices(info *snap.Info, meter progress.Meter) error
 	StopSnapServices(info *snap.Info, meter progress.Meter) error
+	UpdateGadgetAssets(current, update *snap.Info, backupID string) error
 
 	// the undoers for install
 	UndoSetupSnap(s snap.PlaceInfo, typ snap.Type, meter progress.Meter) error
 	UndoCopySnapData(newSnap, oldSnap *snap.Info, meter progress.Meter) error
+	UndoUpdateGadgetAssets(current, update *snap.Info, backupID string) error
 	// cleanup
 	ClearTrashedData(oldSnap *snap.Info)
+	DiscardGadgetAssetsBackup(backupID string) error
 
 	// remove related
 	UnlinkSnap(info *snap.Info, meter progress.Meter) error
@@ -0,0 +1,51 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package backend
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/snap"
+)
+
+func gadgetBackupDir(backupID string) string {
+	return filepath.Join(dirs.SnapGadgetBackupDir, backupID)
+}
+
+// UpdateGadgetAssets updates the boot assets and the volume content of
+// the current gadget snap from its new revision, keeping a backup
+// under the given id.
+func (b Backend) UpdateGadgetAssets(current, update *snap.Info, backupID string) error {
+	return boot.UpdateGadgetAssets(current, update, gadgetBackupDir(backupID))
+}
+
+// UndoUpdateGadgetAssets restores the gadget assets backed up under
+// the given id.
+func (b Backend) UndoUpdateGadgetAssets(current, update *snap.Info, backupID string) error {
+	return boot.UndoGadgetAssetsUpdate(current, update, gadgetBackupDir(backupID))
+}
+
+// DiscardGadgetAssetsBackup removes the gadget assets backed up under
+// the given id.
+func (b Backend) DiscardGadgetAssetsBackup(backupID string) error {
+	return os.RemoveAll(gadgetBackupDir(backupID))
+}
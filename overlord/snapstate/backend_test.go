@@ -83,8 +83,9 @@ func (ops fakeOps) First(op string) *fakeOp {
 }
 
 type fakeDownload struct {
-	name     string
-	macaroon string
+	name      string
+	macaroon  string
+	rateLimit int64
 }
 
 type fakeStore struct {
@@ -140,6 +141,9 @@ func (f *fakeStore) SnapInfo(spec store.SnapSpec, user *auth.UserState) (*snap.I
 		Confinement: confinement,
 		Type:        typ,
 	}
+	if spec.Channel == "channel-for-epoch-2" {
+		info.Epoch = snap.E("2")
+	}
 	f.fakeBackend.ops = append(f.fakeBackend.ops, fakeOp{op: "storesvc-snap", name: spec.Name, revno: spec.Revision})
 
 	return info, nil
@@ -160,6 +164,7 @@ func (f *fakeStore) ListRefresh(cands []*store.RefreshCandidate, _ *auth.UserSta
 	}
 
 	var res []*snap.Info
+	var incompatible []*snap.NoCompatibleUpdateError
 	for _, cand := range cands {
 		snapID := cand.SnapID
 
@@ -206,6 +211,9 @@ func (f *fakeStore) ListRefresh(cands []*store.RefreshCandidate, _ *auth.UserSta
 			Confinement:   confinement,
 			Architectures: []string{"all"},
 		}
+		if cand.Channel == "channel-for-epoch-2" {
+			info.Epoch = snap.E("2")
+		}
 
 		var hit snap.Revision
 		if cand.Revision != revno {
@@ -220,11 +228,19 @@ func (f *fakeStore) ListRefresh(cands []*store.RefreshCandidate, _ *auth.UserSta
 
 		f.fakeBackend.ops = append(f.fakeBackend.ops, fakeOp{op: "storesvc-list-refresh", cand: *cand, revno: hit})
 
+		if !hit.Unset() && !info.Epoch.CanRead(cand.Epoch) {
+			incompatible = append(incompatible, &snap.NoCompatibleUpdateError{Snap: name, Epoch: cand.Epoch})
+			continue
+		}
+
 		if !hit.Unset() {
 			res = append(res, info)
 		}
 	}
 
+	if len(incompatible) > 0 {
+		return res, &store.IncompatibleUpdatesError{Snaps: incompatible}
+	}
 	return res, nil
 }
 
@@ -234,16 +250,21 @@ func (f *fakeStore) SuggestedCurrency() string {
 	return "XTS"
 }
 
-func (f *fakeStore) Download(ctx context.Context, name, targetFn string, snapInfo *snap.DownloadInfo, pb progress.Meter, user *auth.UserState) error {
+func (f *fakeStore) Download(ctx context.Context, name, targetFn string, snapInfo *snap.DownloadInfo, pb progress.Meter, user *auth.UserState, dlOpts *store.DownloadOptions) error {
 	f.pokeStateLock()
 
 	var macaroon string
 	if user != nil {
 		macaroon = user.StoreMacaroon
 	}
+	var rateLimit int64
+	if dlOpts != nil {
+		rateLimit = dlOpts.RateLimit
+	}
 	f.downloads = append(f.downloads, fakeDownload{
-		macaroon: macaroon,
-		name:     name,
+		macaroon:  macaroon,
+		name:      name,
+		rateLimit: rateLimit,
 	})
 	f.fakeBackend.ops = append(f.fakeBackend.ops, fakeOp{op: "storesvc-download", name: name})
 
@@ -398,6 +419,31 @@ func (f *fakeSnappyBackend) LinkSnap(info *snap.Info) error {
 	return nil
 }
 
+func (f *fakeSnappyBackend) UpdateGadgetAssets(current, update *snap.Info, backupID string) error {
+	f.ops = append(f.ops, fakeOp{
+		op:   "update-gadget-assets",
+		name: update.MountDir(),
+		old:  current.MountDir(),
+	})
+	return nil
+}
+
+func (f *fakeSnappyBackend) UndoUpdateGadgetAssets(current, update *snap.Info, backupID string) error {
+	f.ops = append(f.ops, fakeOp{
+		op:   "undo-update-gadget-assets",
+		name: update.MountDir(),
+		old:  current.MountDir(),
+	})
+	return nil
+}
+
+func (f *fakeSnappyBackend) DiscardGadgetAssetsBackup(backupID string) error {
+	f.ops = append(f.ops, fakeOp{
+		op: "discard-gadget-assets-backup",
+	})
+	return nil
+}
+
 func (f *fakeSnappyBackend) StartSnapServices(info *snap.Info, meter progress.Meter) error {
 	f.ops = append(f.ops, fakeOp{
 		op:   "start-snap-services",
@@ -52,7 +52,8 @@ func nameAndRevnoFromSnap(sn string) (string, snap.Revision, error) {
 // still has the "active" version set to "v2" which is
 // misleading. This code will check what kernel/os booted and set
 // those versions active.To do this it creates a Change and kicks
-// start it directly.
+// start it directly. The same goes for a gadget whose updated assets
+// failed to boot.
 func UpdateBootRevisions(st *state.State) error {
 	const errorPrefix = "cannot update revisions after boot changes: "
 
@@ -74,13 +75,19 @@ func UpdateBootRevisions(st *state.State) error {
 		return fmt.Errorf(errorPrefix+"%s", err)
 	}
 
-	m, err := bootloader.GetBootVars("snap_kernel", "snap_core")
+	m, err := bootloader.GetBootVars("snap_kernel", "snap_core", "snap_gadget")
 	if err != nil {
 		return fmt.Errorf(errorPrefix+"%s", err)
 	}
 
+	bootSnaps := []string{m["snap_kernel"], m["snap_core"]}
+	// the gadget is only tracked once its assets got updated
+	if m["snap_gadget"] != "" {
+		bootSnaps = append(bootSnaps, m["snap_gadget"])
+	}
+
 	var tsAll []*state.TaskSet
-	for _, snapNameAndRevno := range []string{m["snap_kernel"], m["snap_core"]} {
+	for _, snapNameAndRevno := range bootSnaps {
 		name, rev, err := nameAndRevnoFromSnap(snapNameAndRevno)
 		if err != nil {
 			logger.Noticef("cannot parse %q: %s", snapNameAndRevno, err)
@@ -22,11 +22,14 @@ package snapstate_test
 // test the boot releated code
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/boot/boottest"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord/snapstate"
@@ -180,6 +183,81 @@ func (bs *bootedSuite) TestUpdateBootRevisionsKernelSimple(c *C) {
 	c.Assert(snapst.Active, Equals, true)
 }
 
+func (bs *bootedSuite) TestUpdateBootRevisionsGadget(c *C) {
+	st := bs.state
+	st.Lock()
+	defer st.Unlock()
+
+	bs.makeInstalledKernelOS(c, st)
+	gadgetSI1 := &snap.SideInfo{RealName: "pc", Revision: snap.R(1)}
+	gadgetSI2 := &snap.SideInfo{RealName: "pc", Revision: snap.R(2)}
+	gadget1 := snaptest.MockSnap(c, "name: pc\ntype: gadget\nversion: 1", "", gadgetSI1)
+	gadget2 := snaptest.MockSnap(c, "name: pc\ntype: gadget\nversion: 2", "", gadgetSI2)
+	for i, info := range []*snap.Info{gadget1, gadget2} {
+		err := ioutil.WriteFile(filepath.Join(info.MountDir(), "meta", "gadget.yaml"), []byte("volumes:\n  pc:\n    bootloader: grub\n"), 0644)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(filepath.Join(info.MountDir(), "grub.conf"), []byte(fmt.Sprintf("config %d", i)), 0644)
+		c.Assert(err, IsNil)
+	}
+	snapstate.Set(st, "pc", &snapstate.SnapState{
+		SnapType: "gadget",
+		Active:   true,
+		Sequence: []*snap.SideInfo{gadgetSI1, gadgetSI2},
+		Current:  snap.R(2),
+	})
+
+	// the first update of the gadget assets, no gadget is recorded
+	// as the good one yet
+	c.Assert(bs.bootloader.BootVars["snap_gadget"], Equals, "")
+	err := boot.UpdateGadgetAssets(gadget1, gadget2, c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(bs.bootloader.BootVars["snap_mode"], Equals, "try")
+
+	// the updated assets of pc_2 never booted successfully, the
+	// bootloader fell back
+	bs.bootloader.BootVars["snap_mode"] = ""
+	err = snapstate.UpdateBootRevisions(st)
+	c.Assert(err, IsNil)
+
+	st.Unlock()
+	bs.settle()
+	st.Lock()
+
+	c.Assert(st.Changes(), HasLen, 1)
+	chg := st.Changes()[0]
+	c.Assert(chg.Err(), IsNil)
+	c.Assert(chg.IsReady(), Equals, true)
+
+	var snapst snapstate.SnapState
+	err = snapstate.Get(st, "pc", &snapst)
+	c.Assert(err, IsNil)
+	c.Assert(snapst.Current, Equals, snap.R(1))
+	c.Assert(snapst.Active, Equals, true)
+
+	// the assets of pc_1 got put back
+	var gadgetOps []string
+	for _, op := range bs.fakeBackend.ops {
+		if op.op == "update-gadget-assets" {
+			gadgetOps = append(gadgetOps, op.old+" -> "+op.name)
+		}
+	}
+	c.Check(gadgetOps, DeepEquals, []string{
+		filepath.Join(dirs.SnapMountDir, "pc/2") + " -> " + filepath.Join(dirs.SnapMountDir, "pc/1"),
+	})
+}
+
+func (bs *bootedSuite) TestUpdateBootRevisionsGadgetNotTracked(c *C) {
+	st := bs.state
+	st.Lock()
+	defer st.Unlock()
+
+	bs.makeInstalledKernelOS(c, st)
+
+	err := snapstate.UpdateBootRevisions(st)
+	c.Assert(err, IsNil)
+	c.Assert(st.Changes(), HasLen, 0)
+}
+
 func (bs *bootedSuite) TestUpdateBootRevisionsKernelErrorsEarly(c *C) {
 	st := bs.state
 	st.Lock()
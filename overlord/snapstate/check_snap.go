@@ -175,6 +175,19 @@ func validateInfoAndFlags(info *snap.Info, snapst *SnapState, flags Flags) error
 	return nil
 }
 
+// checkUpdateEpoch ensures that the update can read the data written by the
+// current revision of the snap.
+func checkUpdateEpoch(update *snap.Info, snapst *SnapState) error {
+	curInfo, err := snapst.CurrentInfo()
+	if err != nil {
+		return err
+	}
+	if !update.Epoch.CanRead(curInfo.Epoch) {
+		return &snap.NoCompatibleUpdateError{Snap: update.Name(), Epoch: curInfo.Epoch}
+	}
+	return nil
+}
+
 var openSnapFile = backend.OpenSnapFile
 
 // checkSnap ensures that the snap can be installed.
@@ -282,6 +295,15 @@ func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags Fl
 		return fmt.Errorf("cannot find original %s snap: %v", kind, err)
 	}
 
+	if currentSnap.SnapID != "" && snapInfo.SnapID == "" {
+		return fmt.Errorf("cannot replace signed %s snap with an unasserted one", kind)
+	}
+
+	if flags.Remodel {
+		// the snap of the new model, devicestate checks that
+		return nil
+	}
+
 	if currentSnap.SnapID != "" && snapInfo.SnapID != "" {
 		if currentSnap.SnapID == snapInfo.SnapID {
 			// same snap
@@ -290,10 +312,6 @@ func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags Fl
 		return fmt.Errorf("cannot replace %s snap with a different one", kind)
 	}
 
-	if currentSnap.SnapID != "" && snapInfo.SnapID == "" {
-		return fmt.Errorf("cannot replace signed %s snap with an unasserted one", kind)
-	}
-
 	if currentSnap.Name() != snapInfo.Name() {
 		return fmt.Errorf("cannot replace %s snap with a different one", kind)
 	}
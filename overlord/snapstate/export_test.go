@@ -21,7 +21,6 @@ package snapstate
 
 import (
 	"errors"
-	"time"
 
 	"gopkg.in/tomb.v2"
 
@@ -68,9 +67,11 @@ func (m *SnapManager) AddForeignTaskHandlers(tracker ForeignTaskTracker) {
 	}
 	m.runner.AddHandler("error-trigger", erroringHandler, nil)
 
-	m.runner.AddHandler("run-hook", func(task *state.Task, _ *tomb.Tomb) error {
+	nopHandler := func(task *state.Task, _ *tomb.Tomb) error {
 		return nil
-	}, nil)
+	}
+	m.runner.AddHandler("run-hook", nopHandler, nopHandler)
+	m.runner.AddHandler("save-snapshot", nopHandler, nopHandler)
 }
 
 // AddAdhocTaskHandlers registers handlers for ad hoc test handler
@@ -96,15 +97,10 @@ func MockErrtrackerReport(mock func(string, string, string, map[string]string) (
 	return func() { errtrackerReport = prev }
 }
 
-func MockRefreshInterval(newMinRefreshInterval, newRefreshRandomness time.Duration) (restore func()) {
-	prevMinRefreshInterval := minRefreshInterval
-	prevDefaultRefreshRandomness := defaultRefreshRandomness
-	minRefreshInterval = newMinRefreshInterval
-	defaultRefreshRandomness = newRefreshRandomness
-	return func() {
-		minRefreshInterval = prevMinRefreshInterval
-		defaultRefreshRandomness = prevDefaultRefreshRandomness
-	}
+func MockDefaultRefreshSchedule(schedule string) (restore func()) {
+	prev := defaultRefreshSchedule
+	defaultRefreshSchedule = schedule
+	return func() { defaultRefreshSchedule = prev }
 }
 
 var (
@@ -113,6 +109,7 @@ var (
 	CanDisable           = canDisable
 	CachedStore          = cachedStore
 	NameAndRevnoFromSnap = nameAndRevnoFromSnap
+	TaskPriority         = taskPriority
 )
 
 func PreviousSideInfo(snapst *SnapState) *snap.SideInfo {
@@ -126,3 +123,11 @@ var (
 	RefreshAliases        = refreshAliases
 	CheckAliasesConflicts = checkAliasesConflicts
 )
+
+func (m *SnapManager) StartDownload(chgID string, max int) bool {
+	return m.startDownload(chgID, max)
+}
+
+func (m *SnapManager) FinishDownload(chgID string) {
+	m.finishDownload(chgID)
+}
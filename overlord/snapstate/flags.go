@@ -45,6 +45,10 @@ type Flags struct {
 	// Required is set to mark that a snap is required
 	// and cannot be removed
 	Required bool `json:"required,omitempty"`
+
+	// Remodel is set when the snap is installed by a remodel,
+	// where it can replace the kernel or gadget snap
+	Remodel bool `json:"remodel,omitempty"`
 }
 
 // DevModeAllowed returns whether a snap can be installed with devmode confinement (either set or overridden)
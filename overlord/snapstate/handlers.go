@@ -213,6 +213,45 @@ func (m *SnapManager) undoPrepareSnap(t *state.Task, _ *tomb.Tomb) error {
 	return nil
 }
 
+// how long a download waits for others in its change to finish
+// when the limit of concurrent downloads is reached
+var downloadRetryInterval = 5 * time.Second
+
+// startDownload records a download starting as part of the change
+// with the given id, unless max of them are running already there
+// (max 0 means no limit).
+func (m *SnapManager) startDownload(chgID string, max int) bool {
+	m.downloadsMu.Lock()
+	defer m.downloadsMu.Unlock()
+	if max > 0 && m.downloads[chgID] >= max {
+		return false
+	}
+	m.downloads[chgID]++
+	return true
+}
+
+func (m *SnapManager) finishDownload(chgID string) {
+	m.downloadsMu.Lock()
+	defer m.downloadsMu.Unlock()
+	m.downloads[chgID]--
+	if m.downloads[chgID] <= 0 {
+		delete(m.downloads, chgID)
+	}
+}
+
+func downloadOptions(st *state.State) (dlOpts *store.DownloadOptions, maxDownloads int, err error) {
+	tr := config.NewTransaction(st)
+	rateLimit, err := BandwidthLimit(tr)
+	if err != nil {
+		return nil, 0, err
+	}
+	maxDownloads, err = MaxConcurrentDownloads(tr)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &store.DownloadOptions{RateLimit: rateLimit}, maxDownloads, nil
+}
+
 func (m *SnapManager) doDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 	st := t.State()
 	st.Lock()
@@ -225,11 +264,25 @@ func (m *SnapManager) doDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 	st.Lock()
 	theStore := Store(st)
 	user, err := userFromUserID(st, snapsup.UserID)
+	var dlOpts *store.DownloadOptions
+	var maxDownloads int
+	if err == nil {
+		dlOpts, maxDownloads, err = downloadOptions(st)
+	}
+	var chgID string
+	if chg := t.Change(); chg != nil {
+		chgID = chg.ID()
+	}
 	st.Unlock()
 	if err != nil {
 		return err
 	}
 
+	if !m.startDownload(chgID, maxDownloads) {
+		return &state.Retry{After: downloadRetryInterval}
+	}
+	defer m.finishDownload(chgID)
+
 	meter := NewTaskProgressAdapterUnlocked(t)
 	targetFn := snapsup.MountFile()
 	if snapsup.DownloadInfo == nil {
@@ -246,10 +299,10 @@ func (m *SnapManager) doDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 		if err != nil {
 			return err
 		}
-		err = theStore.Download(tomb.Context(nil), snapsup.Name(), targetFn, &storeInfo.DownloadInfo, meter, user)
+		err = theStore.Download(tomb.Context(nil), snapsup.Name(), targetFn, &storeInfo.DownloadInfo, meter, user, dlOpts)
 		snapsup.SideInfo = &storeInfo.SideInfo
 	} else {
-		err = theStore.Download(tomb.Context(nil), snapsup.Name(), targetFn, snapsup.DownloadInfo, meter, user)
+		err = theStore.Download(tomb.Context(nil), snapsup.Name(), targetFn, snapsup.DownloadInfo, meter, user, dlOpts)
 	}
 	if err != nil {
 		return err
@@ -459,6 +512,54 @@ func (m *SnapManager) cleanupCopySnapData(t *state.Task, _ *tomb.Tomb) error {
 	return nil
 }
 
+func (m *SnapManager) doUpdateGadgetAssets(t *state.Task, _ *tomb.Tomb) error {
+	t.State().Lock()
+	snapsup, snapst, err := snapSetupAndState(t)
+	t.State().Unlock()
+	if err != nil {
+		return err
+	}
+
+	newInfo, err := readInfo(snapsup.Name(), snapsup.SideInfo)
+	if err != nil {
+		return err
+	}
+
+	oldInfo, err := snapst.CurrentInfo()
+	if err != nil {
+		return err
+	}
+
+	return m.backend.UpdateGadgetAssets(oldInfo, newInfo, t.ID())
+}
+
+func (m *SnapManager) undoUpdateGadgetAssets(t *state.Task, _ *tomb.Tomb) error {
+	t.State().Lock()
+	snapsup, snapst, err := snapSetupAndState(t)
+	t.State().Unlock()
+	if err != nil {
+		return err
+	}
+
+	newInfo, err := readInfo(snapsup.Name(), snapsup.SideInfo)
+	if err != nil {
+		return err
+	}
+
+	oldInfo, err := snapst.CurrentInfo()
+	if err != nil {
+		return err
+	}
+
+	return m.backend.UndoUpdateGadgetAssets(oldInfo, newInfo, t.ID())
+}
+
+func (m *SnapManager) cleanupUpdateGadgetAssets(t *state.Task, _ *tomb.Tomb) error {
+	// once the change is over the backup is not needed anymore: a
+	// failed boot is dealt with by reverting to the previous gadget
+	return m.backend.DiscardGadgetAssetsBackup(t.ID())
+}
+
 func (m *SnapManager) doLinkSnap(t *state.Task, _ *tomb.Tomb) error {
 	st := t.State()
 	st.Lock()
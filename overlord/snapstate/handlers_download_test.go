@@ -20,8 +20,11 @@
 package snapstate_test
 
 import (
+	"time"
+
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
@@ -196,3 +199,76 @@ func (s *downloadSnapSuite) TestDoUndoDownloadSnap(c *C) {
 	c.Assert(err, Equals, state.ErrNoState)
 
 }
+
+func (s *downloadSnapSuite) TestDoDownloadSnapRateLimited(c *C) {
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.bandwidth-limit", "512kB")
+	tr.Commit()
+
+	t := s.state.NewTask("download-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(11),
+		},
+		DownloadInfo: &snap.DownloadInfo{
+			DownloadURL: "http://some-url.com/snap",
+		},
+	})
+	s.state.NewChange("dummy", "...").AddTask(t)
+	s.state.Unlock()
+
+	s.snapmgr.Ensure()
+	s.snapmgr.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.fakeStore.downloads, HasLen, 1)
+	c.Check(s.fakeStore.downloads[0].rateLimit, Equals, int64(512*1000))
+}
+
+func (s *downloadSnapSuite) TestDoDownloadSnapMaxConcurrentDownloads(c *C) {
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.max-concurrent-downloads", 1)
+	tr.Commit()
+
+	t := s.state.NewTask("download-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(11),
+		},
+		DownloadInfo: &snap.DownloadInfo{
+			DownloadURL: "http://some-url.com/snap",
+		},
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	// another snap of the change is being downloaded
+	c.Assert(s.snapmgr.StartDownload(chg.ID(), 0), Equals, true)
+
+	s.snapmgr.Ensure()
+	s.snapmgr.Wait()
+
+	s.state.Lock()
+	c.Check(t.Status(), Equals, state.DoingStatus)
+	c.Check(t.AtTime().IsZero(), Equals, false)
+	c.Check(s.fakeStore.downloads, HasLen, 0)
+	// try again right away once the other download is done
+	t.At(time.Time{})
+	s.state.Unlock()
+	s.snapmgr.FinishDownload(chg.ID())
+
+	s.snapmgr.Ensure()
+	s.snapmgr.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.fakeStore.downloads, HasLen, 1)
+}
@@ -44,10 +44,11 @@ func NewTaskProgressAdapterLocked(t *state.Task) progress.Meter {
 	return &taskProgressAdapter{task: t, unlocked: false}
 }
 
-// Start sets total
+// Start sets total and resets the current progress
 func (t *taskProgressAdapter) Start(label string, total float64) {
 	t.label = label
 	t.total = total
+	t.current = 0
 }
 
 // Set sets the current progress
@@ -56,6 +57,7 @@ func (t *taskProgressAdapter) Set(current float64) {
 		t.task.State().Lock()
 		defer t.task.State().Unlock()
 	}
+	t.current = current
 	t.task.SetProgress(t.label, int(current), int(t.total))
 }
 
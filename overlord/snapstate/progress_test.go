@@ -56,3 +56,23 @@ func (s *progressAdapterTestSuite) TestProgressAdapterWriteLocked(c *C) {
 	m.Write([]byte("some-bytes"))
 	c.Check(p.current, Equals, float64(len("some-bytes")))
 }
+
+func (s *progressAdapterTestSuite) TestProgressAdapterResume(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+	t := st.NewTask("op", "msg")
+	m := NewTaskProgressAdapterLocked(t)
+
+	m.Start("msg", 100)
+	m.Write(make([]byte, 30))
+	// a resumed download starts over with what it already has
+	m.Start("msg", 100)
+	m.Set(30)
+	m.Write(make([]byte, 20))
+
+	label, done, total := t.Progress()
+	c.Check(label, Equals, "msg")
+	c.Check(done, Equals, 50)
+	c.Check(total, Equals, 100)
+}
@@ -22,7 +22,9 @@ package snapstate
 import (
 	"errors"
 	"fmt"
-	"math/rand"
+	"math"
+	"strconv"
+	"sync"
 	"time"
 
 	"gopkg.in/tomb.v2"
@@ -38,36 +40,14 @@ import (
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/timeutil"
 )
 
-// FIXME: what we actually want is a schedule spec that is user configurable
-// like:
-// """
-// tue
-// tue,thu
-// tue-thu
-// 9:00
-// 9:00,15:00
-// 9:00-15:00
-// tue,thu@9:00-15:00
-// tue@9:00;thu@15:00
-// mon,wed-fri@9:00-11:00,13:00-15:00
-// """
-// where 9:00 is implicitly taken as 9:00-10:00
-// and tue is implicitly taken as tue@<our current setting?>
-//
-// it is controlled via:
-// $ snap refresh --schedule=<time spec>
-// which is a shorthand for
+// the default refresh schedule spreads refreshes over the whole day,
+// it is overridden via:
 // $ snap set core refresh.schedule=<time spec>
-// and we need to validate the time-spec, ideally internally by
-// intercepting the set call
-var (
-	minRefreshInterval = 4 * time.Hour
-
-	// random interval on top of the minmum time between refreshes
-	defaultRefreshRandomness = 4 * time.Hour
-)
+// where the time spec follows the grammar of timeutil.ParseSchedule
+var defaultRefreshSchedule = "00:00-04:59/5:00-10:59/11:00-16:59/17:00-23:59"
 
 var (
 	errtrackerReport = errtracker.Report
@@ -78,11 +58,16 @@ type SnapManager struct {
 	state   *state.State
 	backend managerBackend
 
-	refreshRandomness  time.Duration
-	lastRefreshAttempt time.Time
+	currentRefreshSchedule string
+	nextRefresh            time.Time
+	lastRefreshAttempt     time.Time
 
 	lastUbuntuCoreTransitionAttempt time.Time
 
+	// downloads in progress by change id
+	downloadsMu sync.Mutex
+	downloads   map[string]int
+
 	runner *state.TaskRunner
 }
 
@@ -300,13 +285,11 @@ func Manager(st *state.State) (*SnapManager, error) {
 	runner := state.NewTaskRunner(st)
 
 	m := &SnapManager{
-		state:   st,
-		backend: backend.Backend{},
-		runner:  runner,
-
-		refreshRandomness: time.Duration(rand.Int63n(int64(defaultRefreshRandomness))),
+		state:     st,
+		backend:   backend.Backend{},
+		downloads: make(map[string]int),
+		runner:    runner,
 	}
-	logger.Debugf("snapmgr refresh randomness %s", m.refreshRandomness)
 
 	// this handler does nothing
 	runner.AddHandler("nop", func(t *state.Task, _ *tomb.Tomb) error {
@@ -320,6 +303,8 @@ func Manager(st *state.State) (*SnapManager, error) {
 	runner.AddHandler("unlink-current-snap", m.doUnlinkCurrentSnap, m.undoUnlinkCurrentSnap)
 	runner.AddHandler("copy-snap-data", m.doCopySnapData, m.undoCopySnapData)
 	runner.AddCleanup("copy-snap-data", m.cleanupCopySnapData)
+	runner.AddHandler("update-gadget-assets", m.doUpdateGadgetAssets, m.undoUpdateGadgetAssets)
+	runner.AddCleanup("update-gadget-assets", m.cleanupUpdateGadgetAssets)
 	runner.AddHandler("link-snap", m.doLinkSnap, m.undoLinkSnap)
 	runner.AddHandler("start-snap-services", m.startSnapServices, m.stopSnapServices)
 	runner.AddHandler("switch-snap-channel", m.doSwitchSnapChannel, nil)
@@ -344,6 +329,12 @@ func Manager(st *state.State) (*SnapManager, error) {
 	// control serialisation
 	runner.SetBlocked(m.blockedTask)
 
+	// don't saturate the network and disks when many snaps are
+	// installed or refreshed at once
+	runner.SetConcurrencyLimit("download-snap", maxDownloadTasks)
+	runner.SetConcurrencyLimit("copy-snap-data", maxCopySnapDataTasks)
+	runner.SetPriority(taskPriority)
+
 	// test handlers
 	runner.AddHandler("fake-install-snap", func(t *state.Task, _ *tomb.Tomb) error {
 		return nil
@@ -355,6 +346,25 @@ func Manager(st *state.State) (*SnapManager, error) {
 	return m, nil
 }
 
+// how many tasks of the IO heavy kinds run at the same time, across
+// all changes: maxDownloadTasks is also the ceiling of the
+// refresh.max-concurrent-downloads option, which can only lower it for
+// the downloads of a single change, and the snap data copies are done
+// one at a time as they compete for the same disk.
+var (
+	maxDownloadTasks     = 2
+	maxCopySnapDataTasks = 1
+)
+
+// taskPriority puts the tasks of changes the user asked for ahead of
+// those of auto-refreshes.
+func taskPriority(t *state.Task) int {
+	if chg := t.Change(); chg != nil && chg.Kind() == "auto-refresh" {
+		return -1
+	}
+	return 0
+}
+
 func diskAliasTask(t *state.Task) bool {
 	kind := t.Kind()
 	return kind == "setup-aliases" || kind == "remove-aliases" || kind == "alias"
@@ -380,6 +390,113 @@ func setLastRefresh(st *state.State) {
 	tr.Commit()
 }
 
+// LastRefresh returns the time of the last (auto-)refresh attempt
+// that reached the store, or the zero time if there was none.
+func (m *SnapManager) LastRefresh() (time.Time, error) {
+	var lastRefresh time.Time
+	tr := config.NewTransaction(m.state)
+	err := tr.Get("core", "refresh.last", &lastRefresh)
+	if err != nil && !config.IsNoOption(err) {
+		return time.Time{}, err
+	}
+	return lastRefresh, nil
+}
+
+// NextRefresh returns the time the next auto-refresh is scheduled
+// for, or the zero time if it has not been computed yet.
+func (m *SnapManager) NextRefresh() time.Time {
+	return m.nextRefresh
+}
+
+// RefreshSchedule returns the current refresh schedule as a string.
+func (m *SnapManager) RefreshSchedule() (string, error) {
+	_, scheduleStr, err := m.refreshSchedule()
+	return scheduleStr, err
+}
+
+// refreshSchedule reads and parses the refresh.schedule core option,
+// falling back to the default schedule if the option is unset or
+// (should it get there somehow) invalid.
+func (m *SnapManager) refreshSchedule() ([]*timeutil.Schedule, string, error) {
+	scheduleStr := defaultRefreshSchedule
+
+	tr := config.NewTransaction(m.state)
+	err := tr.Get("core", "refresh.schedule", &scheduleStr)
+	if err != nil && !config.IsNoOption(err) {
+		return nil, "", err
+	}
+	schedule, err := timeutil.ParseSchedule(scheduleStr)
+	if err != nil {
+		logger.Noticef("cannot use refresh.schedule configuration: %s", err)
+		scheduleStr = defaultRefreshSchedule
+		schedule, err = timeutil.ParseSchedule(scheduleStr)
+		if err != nil {
+			panic(fmt.Sprintf("defaultRefreshSchedule cannot be parsed: %s", err))
+		}
+	}
+
+	return schedule, scheduleStr, nil
+}
+
+// BandwidthLimit returns the refresh.bandwidth-limit core option in
+// bytes per second, or 0 if downloads are not to be rate limited. The
+// option is either a number of bytes or a size like "512kB".
+func BandwidthLimit(tr *config.Transaction) (int64, error) {
+	var limit interface{}
+	if err := tr.GetMaybe("core", "refresh.bandwidth-limit", &limit); err != nil {
+		return 0, err
+	}
+	if str, ok := limit.(string); ok && str != "" {
+		return strutil.ParseByteSize(str)
+	}
+	return wholeNumberOption(limit)
+}
+
+// MaxConcurrentDownloads returns the refresh.max-concurrent-downloads
+// core option, or 0 if the downloads of a change are only limited by
+// the overall limit on download tasks. Values above that overall limit
+// are refused, as they could not be honoured.
+func MaxConcurrentDownloads(tr *config.Transaction) (int, error) {
+	var max interface{}
+	if err := tr.GetMaybe("core", "refresh.max-concurrent-downloads", &max); err != nil {
+		return 0, err
+	}
+	var n int64
+	if str, ok := max.(string); ok && str != "" {
+		u, err := strconv.ParseUint(str, 10, 31)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse %q: need a non-negative whole number", str)
+		}
+		n = int64(u)
+	} else {
+		var err error
+		n, err = wholeNumberOption(max)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if n > int64(maxDownloadTasks) {
+		return 0, fmt.Errorf("cannot use %d: snapd runs at most %d downloads at the same time", n, maxDownloadTasks)
+	}
+	return int(n), nil
+}
+
+func wholeNumberOption(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case nil:
+		return 0, nil
+	case string:
+		if x == "" {
+			return 0, nil
+		}
+	case float64:
+		if x >= 0 && x <= 1<<53 && x == math.Trunc(x) {
+			return int64(x), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot use %v: need a non-negative whole number", v)
+}
+
 // ensureRefreshes ensures that we refresh all installed snaps periodically
 func (m *SnapManager) ensureRefreshes() error {
 	m.state.Lock()
@@ -407,14 +524,33 @@ func (m *SnapManager) ensureRefreshes() error {
 		}
 	}
 
-	var lastRefresh time.Time
-	err := tr.Get("core", "refresh.last", &lastRefresh)
-	if err != nil && !config.IsNoOption(err) {
+	lastRefresh, err := m.LastRefresh()
+	if err != nil {
 		return err
 	}
 
-	nextRefresh := lastRefresh.Add(minRefreshInterval).Add(m.refreshRandomness)
-	if time.Now().Before(nextRefresh) {
+	schedule, scheduleStr, err := m.refreshSchedule()
+	if err != nil {
+		return err
+	}
+	// the schedule changed, pick a new time inside the new windows
+	if scheduleStr != m.currentRefreshSchedule {
+		m.currentRefreshSchedule = scheduleStr
+		m.nextRefresh = time.Time{}
+	}
+
+	// compute the next refresh time if needed
+	if m.nextRefresh.IsZero() {
+		if lastRefresh.IsZero() {
+			// never refreshed, do it right away
+			m.nextRefresh = time.Now()
+		} else {
+			m.nextRefresh = time.Now().Add(timeutil.Next(schedule, lastRefresh))
+		}
+		logger.Debugf("Next refresh scheduled for %s.", m.nextRefresh)
+	}
+
+	if time.Now().Before(m.nextRefresh) {
 		return nil
 	}
 
@@ -441,8 +577,9 @@ func (m *SnapManager) ensureRefreshes() error {
 	}
 
 	// Do setLastRefresh() only if the store (in AutoRefresh) gave
-	// us no error.
+	// us no error, and only then pick the next refresh time.
 	setLastRefresh(m.state)
+	m.nextRefresh = time.Time{}
 
 	var msg string
 	switch len(updated) {
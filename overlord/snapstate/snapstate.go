@@ -111,7 +111,16 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 		prev = mount
 	}
 
+	// the refresh hooks run around refreshes of active snaps only
+	runRefreshHooks := snapst.Active && !snapsup.Flags.Revert
+
 	if snapst.Active {
+		if runRefreshHooks {
+			preRefreshHook := SetupPreRefreshHook(st, snapsup.Name())
+			addTask(preRefreshHook)
+			prev = preRefreshHook
+		}
+
 		// unlink-current-snap (will stop services for copy-data)
 		stop := st.NewTask("stop-snap-services", fmt.Sprintf(i18n.G("Stop snap %q services"), snapsup.Name()))
 		addTask(stop)
@@ -138,6 +147,15 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 	addTask(setupSecurity)
 	prev = setupSecurity
 
+	// update the boot assets and volume content of the gadget
+	if snapst.HasCurrent() && !release.OnClassic {
+		if typ, err := snapst.Type(); err == nil && typ == snap.TypeGadget {
+			updateGadgetAssets := st.NewTask("update-gadget-assets", fmt.Sprintf(i18n.G("Update assets from gadget %q%s"), snapsup.Name(), revisionStr))
+			addTask(updateGadgetAssets)
+			prev = updateGadgetAssets
+		}
+	}
+
 	// finalize (wrappers+current symlink)
 	linkSnap := st.NewTask("link-snap", fmt.Sprintf(i18n.G("Make snap %q%s available to the system"), snapsup.Name(), revisionStr))
 	addTask(linkSnap)
@@ -160,6 +178,18 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 	addTask(setupAliases)
 	prev = setupAliases
 
+	// run the install hook when installing a snap for the first time,
+	// the post-refresh hook pairs with the pre-refresh one
+	if !snapst.HasCurrent() {
+		installHook := SetupInstallHook(st, snapsup.Name())
+		addTask(installHook)
+		prev = installHook
+	} else if runRefreshHooks {
+		postRefreshHook := SetupPostRefreshHook(st, snapsup.Name())
+		addTask(postRefreshHook)
+		prev = postRefreshHook
+	}
+
 	// run new serices
 	startSnapServices := st.NewTask("start-snap-services", fmt.Sprintf(i18n.G("Start snap %q%s services"), snapsup.Name(), revisionStr))
 	addTask(startSnapServices)
@@ -250,11 +280,46 @@ var Configure = func(st *state.State, snapName string, patch map[string]interfac
 	panic("internal error: snapstate.Configure is unset")
 }
 
+var SetupInstallHook = func(st *state.State, snapName string) *state.Task {
+	panic("internal error: snapstate.SetupInstallHook is unset")
+}
+
+var SetupPreRefreshHook = func(st *state.State, snapName string) *state.Task {
+	panic("internal error: snapstate.SetupPreRefreshHook is unset")
+}
+
+var SetupPostRefreshHook = func(st *state.State, snapName string) *state.Task {
+	panic("internal error: snapstate.SetupPostRefreshHook is unset")
+}
+
+var SetupRemoveHook = func(st *state.State, snapName string) *state.Task {
+	panic("internal error: snapstate.SetupRemoveHook is unset")
+}
+
+// AutomaticSnapshot returns the tasks to take a snapshot of the data
+// of a snap about to be removed, or nil if there is nothing to do. It
+// is set by snapshotstate, without it no snapshot is taken.
+var AutomaticSnapshot = func(st *state.State, snapName string) (*state.TaskSet, error) {
+	return nil, nil
+}
+
 // CheckChangeConflict ensures that for the given snapName no other
 // changes that alters the snap (like remove, install, refresh) are in
 // progress. It also ensures that snapst (if not nil) did not get
 // modified. If a conflict is detected an error is returned.
 func CheckChangeConflict(st *state.State, snapName string, snapst *SnapState) error {
+	return checkChangeConflict(st, snapName, snapst, "")
+}
+
+// CheckChangeConflictIgnoringChange is like CheckChangeConflict
+// without a SnapState, but it doesn't consider tasks of the change
+// with the given ID as in conflict, e.g. because that change is the
+// one the caller is adding tasks to.
+func CheckChangeConflictIgnoringChange(st *state.State, snapName string, ignoreChangeID string) error {
+	return checkChangeConflict(st, snapName, nil, ignoreChangeID)
+}
+
+func checkChangeConflict(st *state.State, snapName string, snapst *SnapState, ignoreChangeID string) error {
 	for _, chg := range st.Changes() {
 		if chg.Status().Ready() {
 			continue
@@ -267,12 +332,15 @@ func CheckChangeConflict(st *state.State, snapName string, snapst *SnapState) er
 	for _, task := range st.Tasks() {
 		k := task.Kind()
 		chg := task.Change()
-		if (k == "link-snap" || k == "unlink-snap" || k == "alias") && (chg == nil || !chg.Status().Ready()) {
-			snapsup, err := TaskSnapSetup(task)
+		if (k == "link-snap" || k == "unlink-snap" || k == "alias" || k == "service-control" || k == "restore-snapshot") && (chg == nil || !chg.Status().Ready()) {
+			if ignoreChangeID != "" && chg != nil && chg.ID() == ignoreChangeID {
+				continue
+			}
+			taskSnapName, err := conflictSnapName(task)
 			if err != nil {
-				return fmt.Errorf("internal error: cannot obtain snap setup from task: %s", task.Summary())
+				return err
 			}
-			if snapsup.Name() == snapName {
+			if taskSnapName == snapName {
 				return fmt.Errorf("snap %q has changes in progress", snapName)
 			}
 		}
@@ -299,6 +367,26 @@ func CheckChangeConflict(st *state.State, snapName string, snapst *SnapState) er
 	return nil
 }
 
+// conflictSnapName returns the name of the snap a task that conflicts
+// with other operations on the same snap is about.
+func conflictSnapName(task *state.Task) (string, error) {
+	if task.Kind() == "service-control" {
+		// the service-action of the servicestate task
+		var svcAction struct {
+			SnapName string `json:"snap-name"`
+		}
+		if err := task.Get("service-action", &svcAction); err != nil {
+			return "", fmt.Errorf("internal error: cannot obtain service action from task: %s", task.Summary())
+		}
+		return svcAction.SnapName, nil
+	}
+	snapsup, err := TaskSnapSetup(task)
+	if err != nil {
+		return "", fmt.Errorf("internal error: cannot obtain snap setup from task: %s", task.Summary())
+	}
+	return snapsup.Name(), nil
+}
+
 // InstallPath returns a set of tasks for installing snap from a file path.
 // Note that the state must be locked by the caller.
 // The provided SideInfo can contain just a name which results in a
@@ -412,9 +500,17 @@ func contains(ns []string, n string) bool {
 // Note that the state must be locked by the caller.
 func RefreshCandidates(st *state.State, user *auth.UserState) ([]*snap.Info, error) {
 	updates, _, err := refreshCandidates(st, nil, user)
+	if _, ok := err.(*store.IncompatibleUpdatesError); ok {
+		logger.Noticef("cannot refresh some snaps: %v", err)
+		err = nil
+	}
 	return updates, err
 }
 
+// refreshCandidates returns the available updates for the given
+// snaps, or all of them, and their states by snap id. Snaps that only
+// have updates that cannot read their data are reported with an
+// *store.IncompatibleUpdatesError returned alongside the other updates.
 func refreshCandidates(st *state.State, names []string, user *auth.UserState) ([]*snap.Info, map[string]*SnapState, error) {
 	snapStates, err := All(st)
 	if err != nil {
@@ -475,6 +571,9 @@ func refreshCandidates(st *state.State, names []string, user *auth.UserState) ([
 	st.Unlock()
 	updates, err := theStore.ListRefresh(candidatesInfo, user)
 	st.Lock()
+	if _, ok := err.(*store.IncompatibleUpdatesError); ok {
+		return updates, stateByID, err
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -487,6 +586,9 @@ var ValidateRefreshes func(st *state.State, refreshes []*snap.Info, userID int)
 
 // UpdateMany updates everything from the given list of names that the
 // store says is updateable. If the list is empty, update everything.
+// Snaps that only have updates that cannot read their data are skipped
+// and reported with an *store.IncompatibleUpdatesError returned
+// alongside the updates of the other snaps.
 // Note that the state must be locked by the caller.
 func UpdateMany(st *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
 	user, err := userFromUserID(st, userID)
@@ -495,6 +597,11 @@ func UpdateMany(st *state.State, names []string, userID int) ([]string, []*state
 	}
 
 	updates, stateByID, err := refreshCandidates(st, names, user)
+	var incompatible []*snap.NoCompatibleUpdateError
+	if e, ok := err.(*store.IncompatibleUpdatesError); ok {
+		incompatible = e.Snaps
+		err = nil
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -511,13 +618,32 @@ func UpdateMany(st *state.State, names []string, userID int) ([]string, []*state
 		}
 	}
 
+	compatible := make([]*snap.Info, 0, len(updates))
+	for _, update := range updates {
+		// other errors are dealt with by doUpdate
+		if e, ok := checkUpdateEpoch(update, stateByID[update.SnapID]).(*snap.NoCompatibleUpdateError); ok {
+			incompatible = append(incompatible, e)
+			continue
+		}
+		compatible = append(compatible, update)
+	}
+
 	params := func(update *snap.Info) (string, Flags, *SnapState) {
 		snapst := stateByID[update.SnapID]
 		return snapst.Channel, snapst.Flags, snapst
 
 	}
 
-	return doUpdate(st, names, updates, params, userID)
+	updated, tasksets, err := doUpdate(st, names, compatible, params, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(incompatible) != 0 {
+		err := &store.IncompatibleUpdatesError{Snaps: incompatible}
+		logger.Noticef("cannot refresh some snaps: %v", err)
+		return updated, tasksets, err
+	}
+	return updated, tasksets, nil
 }
 
 func doUpdate(st *state.State, names []string, updates []*snap.Info, params func(*snap.Info) (channel string, flags Flags, snapst *SnapState), userID int) ([]string, []*state.TaskSet, error) {
@@ -571,6 +697,13 @@ func doUpdate(st *state.State, names []string, updates []*snap.Info, params func
 			}
 			return nil, nil, err
 		}
+		if err := checkUpdateEpoch(update, snapst); err != nil {
+			if refreshAll {
+				logger.Noticef("cannot update %q: %v", update.Name(), err)
+				continue
+			}
+			return nil, nil, err
+		}
 
 		snapsup := &SnapSetup{
 			Channel:      channel,
@@ -838,7 +971,12 @@ func AutoRefresh(st *state.State) ([]string, []*state.TaskSet, error) {
 		}
 	}
 
-	return UpdateMany(st, nil, userID)
+	updated, tasksets, err := UpdateMany(st, nil, userID)
+	if _, ok := err.(*store.IncompatibleUpdatesError); ok {
+		// already logged, nobody to report it to
+		err = nil
+	}
+	return updated, tasksets, err
 }
 
 // Enable sets a snap to the active state
@@ -1088,10 +1226,30 @@ func Remove(st *state.State, name string, revision snap.Revision) (*state.TaskSe
 		removeSecurity.WaitFor(unlink)
 		removeSecurity.Set("snap-setup-task", stopSnapServices.ID())
 
-		addNext(state.NewTaskSet(stopSnapServices, removeAliases, unlink, removeSecurity))
+		tasks := []*state.Task{stopSnapServices, removeAliases, unlink, removeSecurity}
+		if removeAll {
+			// the remove hook runs once the services are stopped
+			// but while the snap is still available
+			removeHook := SetupRemoveHook(st, name)
+			removeHook.WaitFor(stopSnapServices)
+			removeAliases.WaitFor(removeHook)
+			tasks = []*state.Task{stopSnapServices, removeHook, removeAliases, unlink, removeSecurity}
+		}
+
+		addNext(state.NewTaskSet(tasks...))
 	}
 
 	if removeAll {
+		// keep the data and configuration of the snap around, in
+		// case it was removed by mistake
+		ts, err := AutomaticSnapshot(st, name)
+		if err != nil {
+			return nil, err
+		}
+		if ts != nil {
+			addNext(ts)
+		}
+
 		seq := snapst.Sequence
 		for i := len(seq) - 1; i >= 0; i-- {
 			si := seq[i]
@@ -1426,11 +1584,25 @@ func infosForTypes(st *state.State, snapType snap.Type) ([]*snap.Info, error) {
 	return res, nil
 }
 
+// ModelSnapName, if set, returns the name of the snap of the given
+// type required by the device model. It is used to pick the current
+// one when more than one is installed, e.g. after a remodel.
+var ModelSnapName func(st *state.State, snapType snap.Type) string
+
 func infoForType(st *state.State, snapType snap.Type) (*snap.Info, error) {
 	res, err := infosForTypes(st, snapType)
 	if err != nil {
 		return nil, err
 	}
+	if len(res) > 1 && ModelSnapName != nil {
+		if name := ModelSnapName(st, snapType); name != "" {
+			for _, info := range res {
+				if info.Name() == name {
+					return info, nil
+				}
+			}
+		}
+	}
 	return res[0], nil
 }
 
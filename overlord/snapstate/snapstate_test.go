@@ -27,6 +27,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -35,6 +36,8 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	_ "github.com/snapcore/snapd/overlord/snapshotstate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/snapstate/backend"
 	"github.com/snapcore/snapd/overlord/state"
@@ -42,6 +45,7 @@ import (
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/testutil"
 
 	// So it registers Configure.
 	_ "github.com/snapcore/snapd/overlord/configstate"
@@ -132,6 +136,7 @@ const (
 	unlinkBefore = 1 << iota
 	cleanupAfter
 	maybeCore
+	updatesGadget
 )
 
 func taskKinds(tasks []*state.Task) []string {
@@ -142,8 +147,23 @@ func taskKinds(tasks []*state.Task) []string {
 	return kinds
 }
 
+// taskKindsWithHooks is like taskKinds but also notes the hook run
+// by run-hook tasks.
+func taskKindsWithHooks(c *C, tasks []*state.Task) []string {
+	kinds := make([]string, len(tasks))
+	for i, task := range tasks {
+		kinds[i] = task.Kind()
+		if task.Kind() == "run-hook" {
+			var hooksup hookstate.HookSetup
+			c.Assert(task.Get("hook-setup", &hooksup), IsNil)
+			kinds[i] += "[" + hooksup.Hook + "]"
+		}
+	}
+	return kinds
+}
+
 func verifyInstallUpdateTasks(c *C, opts, discards int, ts *state.TaskSet, st *state.State) {
-	kinds := taskKinds(ts.Tasks())
+	kinds := taskKindsWithHooks(c, ts.Tasks())
 
 	expected := []string{
 		"download-snap",
@@ -152,6 +172,7 @@ func verifyInstallUpdateTasks(c *C, opts, discards int, ts *state.TaskSet, st *s
 	}
 	if opts&unlinkBefore != 0 {
 		expected = append(expected,
+			"run-hook[pre-refresh]",
 			"stop-snap-services",
 			"remove-aliases",
 			"unlink-current-snap",
@@ -160,6 +181,11 @@ func verifyInstallUpdateTasks(c *C, opts, discards int, ts *state.TaskSet, st *s
 	expected = append(expected,
 		"copy-snap-data",
 		"setup-profiles",
+	)
+	if opts&updatesGadget != 0 {
+		expected = append(expected, "update-gadget-assets")
+	}
+	expected = append(expected,
 		"link-snap",
 	)
 	if opts&maybeCore != 0 {
@@ -168,6 +194,17 @@ func verifyInstallUpdateTasks(c *C, opts, discards int, ts *state.TaskSet, st *s
 	expected = append(expected,
 		"set-auto-aliases",
 		"setup-aliases",
+	)
+	if opts&unlinkBefore != 0 {
+		expected = append(expected,
+			"run-hook[post-refresh]",
+		)
+	} else {
+		expected = append(expected,
+			"run-hook[install]",
+		)
+	}
+	expected = append(expected,
 		"start-snap-services",
 	)
 	for i := 0; i < discards; i++ {
@@ -182,18 +219,20 @@ func verifyInstallUpdateTasks(c *C, opts, discards int, ts *state.TaskSet, st *s
 		)
 	}
 	expected = append(expected,
-		"run-hook",
+		"run-hook[configure]",
 	)
 
 	c.Assert(kinds, DeepEquals, expected)
 }
 
 func verifyRemoveTasks(c *C, ts *state.TaskSet) {
-	c.Assert(taskKinds(ts.Tasks()), DeepEquals, []string{
+	c.Assert(taskKindsWithHooks(c, ts.Tasks()), DeepEquals, []string{
 		"stop-snap-services",
+		"run-hook[remove]",
 		"remove-aliases",
 		"unlink-snap",
 		"remove-profiles",
+		"save-snapshot",
 		"clear-snap",
 		"discard-snap",
 		"clear-aliases",
@@ -863,6 +902,54 @@ func (s *snapmgrTestSuite) TestUpdateTasks(c *C) {
 	c.Check(snapsup.Channel, Equals, "some-channel")
 }
 
+func (s *snapmgrTestSuite) TestInstallPathInactiveSnapRunsNoRefreshHooks(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   false,
+		Sequence: []*snap.SideInfo{{RealName: "some-snap", Revision: snap.R(-1)}},
+		Current:  snap.R(-1),
+		SnapType: "app",
+	})
+
+	mockSnap := makeTestSnap(c, "name: some-snap\nversion: 1.0")
+	ts, err := snapstate.InstallPath(s.state, &snap.SideInfo{RealName: "some-snap"}, mockSnap, "", snapstate.Flags{})
+	c.Assert(err, IsNil)
+
+	kinds := taskKindsWithHooks(c, ts.Tasks())
+	c.Check(kinds, Not(testutil.Contains), "run-hook[pre-refresh]")
+	c.Check(kinds, Not(testutil.Contains), "run-hook[post-refresh]")
+	c.Check(kinds, Not(testutil.Contains), "run-hook[install]")
+	c.Check(kinds, testutil.Contains, "link-snap")
+}
+
+func (s *snapmgrTestSuite) TestUpdateGadgetTasks(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Channel:  "edge",
+		Sequence: []*snap.SideInfo{{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(7)}},
+		Current:  snap.R(7),
+		SnapType: "gadget",
+	})
+
+	ts, err := snapstate.Update(s.state, "some-snap", "some-channel", snap.R(0), s.user.ID, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	verifyInstallUpdateTasks(c, unlinkBefore|cleanupAfter|updatesGadget, 0, ts, s.state)
+
+	// nothing to update on classic
+	release.MockOnClassic(true)
+	ts, err = snapstate.Update(s.state, "some-snap", "some-channel", snap.R(0), s.user.ID, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	verifyInstallUpdateTasks(c, unlinkBefore|cleanupAfter, 0, ts, s.state)
+}
+
 func (s *snapmgrTestSuite) TestUpdateTasksCoreSetsIgnoreOnConfigure(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -1119,6 +1206,50 @@ func (s *snapmgrTestSuite) TestRemoveConflict(c *C) {
 	c.Assert(err, ErrorMatches, `snap "some-snap" has changes in progress`)
 }
 
+func (s *snapmgrTestSuite) TestRemoveServiceControlConflict(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{{RealName: "some-snap", Revision: snap.R(11)}},
+		Current:  snap.R(11),
+	})
+
+	// service-control tasks carry the snap name in their service-action
+	t := s.state.NewTask("service-control", "...")
+	t.Set("service-action", map[string]interface{}{
+		"snap-name": "some-snap",
+		"action":    "stop",
+		"services":  []string{"svc"},
+	})
+	s.state.NewChange("service-control", "...").AddTask(t)
+
+	_, err := snapstate.Remove(s.state, "some-snap", snap.R(0))
+	c.Assert(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+
+	// but don't conflict with other snaps
+	err = snapstate.CheckChangeConflictIgnoringChange(s.state, "other-snap", "")
+	c.Check(err, IsNil)
+}
+
+func (s *snapmgrTestSuite) TestCheckChangeConflictIgnoringChange(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	t := s.state.NewTask("link-snap", "...")
+	t.Set("snap-setup", &snapstate.SnapSetup{SideInfo: &snap.SideInfo{RealName: "some-snap"}})
+	chg := s.state.NewChange("install", "...")
+	chg.AddTask(t)
+
+	err := snapstate.CheckChangeConflictIgnoringChange(s.state, "some-snap", "")
+	c.Check(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+	err = snapstate.CheckChangeConflictIgnoringChange(s.state, "some-snap", "other")
+	c.Check(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+	err = snapstate.CheckChangeConflictIgnoringChange(s.state, "some-snap", chg.ID())
+	c.Check(err, IsNil)
+}
+
 func (s *snapmgrTestSuite) TestInstallRunThrough(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -1223,7 +1354,7 @@ func (s *snapmgrTestSuite) TestInstallRunThrough(c *C) {
 	c.Check(task.Summary(), Equals, `Download snap "some-snap" (42) from channel "some-channel"`)
 
 	// check link/start snap summary
-	linkTask := ta[len(ta)-5]
+	linkTask := ta[len(ta)-6]
 	c.Check(linkTask.Summary(), Equals, `Make snap "some-snap" (42) available to the system`)
 	startTask := ta[len(ta)-2]
 	c.Check(startTask.Summary(), Equals, `Start snap "some-snap" (42) services`)
@@ -1299,7 +1430,7 @@ func (s *snapmgrTestSuite) TestUpdateRunThrough(c *C) {
 				Channel:  "some-channel",
 				SnapID:   "some-snap-id",
 				Revision: snap.R(7),
-				Epoch:    "",
+				Epoch:    snap.E("0"),
 			},
 			revno: snap.R(11),
 		},
@@ -1437,6 +1568,67 @@ func (s *snapmgrTestSuite) TestUpdateRunThrough(c *C) {
 	})
 }
 
+func (s *snapmgrTestSuite) TestUpdateGadgetUndoRunThrough(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	si := snap.SideInfo{
+		RealName: "some-snap",
+		SnapID:   "some-snap-id",
+		Revision: snap.R(7),
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{&si},
+		Current:  si.Revision,
+		SnapType: "gadget",
+	})
+
+	chg := s.state.NewChange("refresh", "refresh a gadget")
+	ts, err := snapstate.Update(s.state, "some-snap", "some-channel", snap.R(0), s.user.ID, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	s.fakeBackend.linkSnapFailTrigger = "/snap/some-snap/11"
+
+	s.state.Unlock()
+	defer s.snapmgr.Stop()
+	s.settle()
+	s.state.Lock()
+
+	var gadgetOps fakeOps
+	for _, op := range s.fakeBackend.ops {
+		if strings.Contains(op.op, "gadget-assets") {
+			gadgetOps = append(gadgetOps, op)
+		}
+	}
+	c.Check(gadgetOps, DeepEquals, fakeOps{
+		{
+			op:   "update-gadget-assets",
+			name: "/snap/some-snap/11",
+			old:  "/snap/some-snap/7",
+		},
+		{
+			op:   "undo-update-gadget-assets",
+			name: "/snap/some-snap/11",
+			old:  "/snap/some-snap/7",
+		},
+		{
+			op: "discard-gadget-assets-backup",
+		},
+	})
+
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	var snapst snapstate.SnapState
+	err = snapstate.Get(s.state, "some-snap", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Current, Equals, snap.R(7))
+}
+
 func (s *snapmgrTestSuite) TestUpdateUndoRunThrough(c *C) {
 	si := snap.SideInfo{
 		RealName: "some-snap",
@@ -1473,7 +1665,7 @@ func (s *snapmgrTestSuite) TestUpdateUndoRunThrough(c *C) {
 				Channel:  "some-channel",
 				SnapID:   "some-snap-id",
 				Revision: snap.R(7),
-				Epoch:    "",
+				Epoch:    snap.E("0"),
 			},
 			revno: snap.R(11),
 		},
@@ -1641,7 +1833,7 @@ func (s *snapmgrTestSuite) TestUpdateTotalUndoRunThrough(c *C) {
 				Channel:  "some-channel",
 				SnapID:   "some-snap-id",
 				Revision: snap.R(7),
-				Epoch:    "",
+				Epoch:    snap.E("0"),
 			},
 			revno: snap.R(11),
 		},
@@ -1805,6 +1997,112 @@ func (s *snapmgrTestSuite) TestUpdateSameRevision(c *C) {
 	c.Assert(err, ErrorMatches, `snap "some-snap" has no updates available`)
 }
 
+func (s *snapmgrTestSuite) TestUpdateIncompatibleEpoch(c *C) {
+	si := snap.SideInfo{
+		RealName: "some-snap",
+		SnapID:   "some-snap-id",
+		Revision: snap.R(7),
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{&si},
+		Channel:  "stable",
+		Current:  si.Revision,
+	})
+
+	_, err := snapstate.Update(s.state, "some-snap", "channel-for-epoch-2", snap.R(0), s.user.ID, snapstate.Flags{})
+	c.Assert(err, ErrorMatches, `snap "some-snap" has no compatible update available \(none can read the data of epoch 0\)`)
+	c.Check(err, FitsTypeOf, &snap.NoCompatibleUpdateError{})
+}
+
+func (s *snapmgrTestSuite) TestUpdateToRevisionIncompatibleEpoch(c *C) {
+	si := snap.SideInfo{
+		RealName: "some-snap",
+		SnapID:   "some-snap-id",
+		Revision: snap.R(7),
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{&si},
+		Channel:  "stable",
+		Current:  si.Revision,
+	})
+
+	// the revision is picked explicitly, bypassing the refresh filtering
+	_, err := snapstate.Update(s.state, "some-snap", "channel-for-epoch-2", snap.R(11), s.user.ID, snapstate.Flags{})
+	c.Assert(err, ErrorMatches, `snap "some-snap" has no compatible update available \(none can read the data of epoch 0\)`)
+}
+
+func (s *snapmgrTestSuite) TestUpdateAllSkipsIncompatibleEpoch(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(1)},
+		},
+		Current: snap.R(1),
+		Channel: "channel-for-epoch-2",
+	})
+
+	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0)
+	c.Assert(err, ErrorMatches, `snap "some-snap" has no compatible update available .*`)
+	c.Check(err, FitsTypeOf, &store.IncompatibleUpdatesError{})
+	c.Check(updates, HasLen, 0)
+	c.Check(tts, HasLen, 0)
+
+	// the same when asking for it explicitly
+	_, _, err = snapstate.UpdateMany(s.state, []string{"some-snap"}, 0)
+	c.Check(err, ErrorMatches, `snap "some-snap" has no compatible update available .*`)
+
+	// but auto-refresh just logs the problem
+	origAutoRefreshAssertions := snapstate.AutoRefreshAssertions
+	defer func() { snapstate.AutoRefreshAssertions = origAutoRefreshAssertions }()
+	snapstate.AutoRefreshAssertions = nil
+	updates, tts, err = snapstate.AutoRefresh(s.state)
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 0)
+	c.Check(tts, HasLen, 0)
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyRefreshesCompatible(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(1)},
+		},
+		Current: snap.R(1),
+		Channel: "channel-for-epoch-2",
+	})
+	snapstate.Set(s.state, "core", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "core", SnapID: "core-snap-id", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		SnapType: "os",
+	})
+
+	updates, tts, err := snapstate.UpdateMany(s.state, []string{"some-snap", "core"}, 0)
+	c.Assert(err, FitsTypeOf, &store.IncompatibleUpdatesError{})
+	c.Check(err.(*store.IncompatibleUpdatesError).Snaps, HasLen, 1)
+	c.Check(err.(*store.IncompatibleUpdatesError).Snaps[0].Snap, Equals, "some-snap")
+	c.Check(updates, DeepEquals, []string{"core"})
+	c.Check(tts, HasLen, 1)
+}
+
 func (s *snapmgrTestSuite) TestUpdateSameRevisionSwitchesChannel(c *C) {
 	si := snap.SideInfo{
 		RealName: "some-snap",
@@ -1866,7 +2164,7 @@ func (s *snapmgrTestSuite) TestUpdateSameRevisionSwitchChannelRunThrough(c *C) {
 				Channel:  "channel-for-7",
 				SnapID:   "some-snap-id",
 				Revision: snap.R(7),
-				Epoch:    "",
+				Epoch:    snap.E("0"),
 			},
 		},
 	}
@@ -2002,7 +2300,7 @@ func (s *snapmgrTestSuite) TestSingleUpdateBlockedRevision(c *C) {
 		cand: store.RefreshCandidate{
 			SnapID:   "some-snap-id",
 			Revision: snap.R(7),
-			Epoch:    "",
+			Epoch:    snap.E("0"),
 			Channel:  "some-channel",
 		},
 	})
@@ -2338,7 +2636,7 @@ func (s *snapmgrTestSuite) TestUpdateOneAutoAliasesScenarios(c *C) {
 		}
 		if scenario.update {
 			first := tasks[j]
-			j += 14
+			j += 16
 			c.Check(first.Kind(), Equals, "download-snap")
 			wait := false
 			if expectedRetiring["other-snap"]["aliasA"] != "" {
@@ -2781,6 +3079,9 @@ func (s *snapmgrTestSuite) TestRemoveRunThrough(c *C) {
 	// verify snapSetup info
 	tasks := ts.Tasks()
 	for _, t := range tasks {
+		if t.Kind() == "run-hook" || t.Kind() == "save-snapshot" {
+			continue
+		}
 		snapsup, err := snapstate.TaskSnapSetup(t)
 		c.Assert(err, IsNil)
 
@@ -2911,6 +3212,9 @@ func (s *snapmgrTestSuite) TestRemoveWithManyRevisionsRunThrough(c *C) {
 	revnos := []snap.Revision{{N: 7}, {N: 3}, {N: 5}}
 	whichRevno := 0
 	for _, t := range tasks {
+		if t.Kind() == "run-hook" || t.Kind() == "save-snapshot" {
+			continue
+		}
 		snapsup, err := snapstate.TaskSnapSetup(t)
 		c.Assert(err, IsNil)
 
@@ -2998,6 +3302,9 @@ func (s *snapmgrTestSuite) TestRemoveOneRevisionRunThrough(c *C) {
 	// verify snapSetup info
 	tasks := ts.Tasks()
 	for _, t := range tasks {
+		if t.Kind() == "run-hook" || t.Kind() == "save-snapshot" {
+			continue
+		}
 		snapsup, err := snapstate.TaskSnapSetup(t)
 		c.Assert(err, IsNil)
 
@@ -3075,6 +3382,9 @@ func (s *snapmgrTestSuite) TestRemoveLastRevisionRunThrough(c *C) {
 	// verify snapSetup info
 	tasks := ts.Tasks()
 	for _, t := range tasks {
+		if t.Kind() == "run-hook" || t.Kind() == "save-snapshot" {
+			continue
+		}
 		snapsup, err := snapstate.TaskSnapSetup(t)
 		c.Assert(err, IsNil)
 
@@ -4189,6 +4499,7 @@ link-snap: Error
  ERROR fail
 set-auto-aliases: Hold
 setup-aliases: Hold
+run-hook: Hold
 start-snap-services: Hold
 cleanup: Hold
 run-hook: Hold`)
@@ -4202,6 +4513,7 @@ link-snap: Error
  ERROR fail
 set-auto-aliases: Hold
 setup-aliases: Hold
+run-hook: Hold
 start-snap-services: Hold
 cleanup: Hold
 run-hook: Hold`)
@@ -4233,6 +4545,20 @@ func (s *snapmgrTestSuite) verifyRefreshLast(c *C) {
 	c.Check(time.Now().Year(), Equals, lastRefresh.Year())
 }
 
+func (s *snapmgrTestSuite) TestTaskPriority(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	autoRefresh := s.state.NewChange("auto-refresh", "...")
+	t1 := s.state.NewTask("download-snap", "...")
+	autoRefresh.AddTask(t1)
+	refresh := s.state.NewChange("refresh-snap", "...")
+	t2 := s.state.NewTask("download-snap", "...")
+	refresh.AddTask(t2)
+
+	c.Check(snapstate.TaskPriority(t1) < snapstate.TaskPriority(t2), Equals, true)
+}
+
 func (s *snapmgrTestSuite) TestEnsureRefreshesNoUpdate(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -4433,6 +4759,160 @@ func (s *snapmgrTestSuite) TestEnsureRefreshesWithUpdateStoreError(c *C) {
 	c.Check(autoRefreshAssertionsCalled, Equals, 1)
 }
 
+func (s *snapmgrTestSuite) TestEnsureRefreshesRespectsSchedule(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	snapstate.CanAutoRefresh = func(*state.State) (bool, error) { return true, nil }
+
+	// pick a window that starts two hours from now
+	start := time.Now().Add(2 * time.Hour)
+	end := start.Add(time.Hour)
+	if end.Day() != start.Day() {
+		c.Skip("window would cross midnight")
+	}
+	schedule := fmt.Sprintf("%d:%02d-%d:%02d", start.Hour(), start.Minute(), end.Hour(), end.Minute())
+
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.last", time.Now().Add(-time.Hour))
+	tr.Set("core", "refresh.schedule", schedule)
+	tr.Commit()
+
+	s.state.Unlock()
+	s.snapmgr.Ensure()
+	s.state.Lock()
+
+	// not inside the window yet, so nothing happened
+	c.Check(s.state.Changes(), HasLen, 0)
+	next := s.snapmgr.NextRefresh()
+	c.Check(next.Before(start.Add(-time.Minute)), Equals, false)
+	c.Check(next.After(end), Equals, false)
+
+	refreshSchedule, err := s.snapmgr.RefreshSchedule()
+	c.Assert(err, IsNil)
+	c.Check(refreshSchedule, Equals, schedule)
+}
+
+func (s *snapmgrTestSuite) TestEnsureRefreshesScheduleChanged(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	snapstate.CanAutoRefresh = func(*state.State) (bool, error) { return true, nil }
+
+	// refreshed already inside today's window
+	now := time.Now()
+	if now.Hour() == 23 && now.Minute() == 59 {
+		c.Skip("outside of the refresh window")
+	}
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.last", now)
+	tr.Set("core", "refresh.schedule", "00:00-23:59")
+	tr.Commit()
+
+	s.state.Unlock()
+	s.snapmgr.Ensure()
+	s.state.Lock()
+	first := s.snapmgr.NextRefresh()
+	c.Check(first.IsZero(), Equals, false)
+
+	// the same schedule keeps the same next refresh
+	s.state.Unlock()
+	s.snapmgr.Ensure()
+	s.state.Lock()
+	c.Check(s.snapmgr.NextRefresh().Equal(first), Equals, true)
+
+	// a new (but unparsable) schedule falls back to the default one
+	tr = config.NewTransaction(s.state)
+	tr.Set("core", "refresh.schedule", "invalid")
+	tr.Commit()
+
+	restore := snapstate.MockDefaultRefreshSchedule("00:00-00:10")
+	defer restore()
+
+	s.state.Unlock()
+	s.snapmgr.Ensure()
+	s.state.Lock()
+	refreshSchedule, err := s.snapmgr.RefreshSchedule()
+	c.Assert(err, IsNil)
+	c.Check(refreshSchedule, Equals, "00:00-00:10")
+	c.Check(s.snapmgr.NextRefresh().Hour(), Equals, 0)
+}
+
+func (s *snapmgrTestSuite) TestBandwidthLimit(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	for _, t := range []struct {
+		value interface{}
+		limit int64
+		err   string
+	}{
+		{nil, 0, ""},
+		{"", 0, ""},
+		{1000, 1000, ""},
+		{"1000", 1000, ""},
+		{"2MB", 2000000, ""},
+		{-1, 0, `cannot use -1: need a non-negative whole number`},
+		{1.5, 0, `cannot use 1.5: need a non-negative whole number`},
+		{"lots", 0, `cannot parse byte size "lots": .*`},
+	} {
+		tr := config.NewTransaction(s.state)
+		tr.Set("core", "refresh.bandwidth-limit", t.value)
+		limit, err := snapstate.BandwidthLimit(tr)
+		if t.err == "" {
+			c.Check(err, IsNil, Commentf("%v", t.value))
+			c.Check(limit, Equals, t.limit, Commentf("%v", t.value))
+		} else {
+			c.Check(err, ErrorMatches, t.err, Commentf("%v", t.value))
+		}
+	}
+}
+
+func (s *snapmgrTestSuite) TestMaxConcurrentDownloads(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	for _, t := range []struct {
+		value interface{}
+		max   int
+		err   string
+	}{
+		{nil, 0, ""},
+		{1, 1, ""},
+		{"2", 2, ""},
+		{3, 0, `cannot use 3: snapd runs at most 2 downloads at the same time`},
+		{"10", 0, `cannot use 10: snapd runs at most 2 downloads at the same time`},
+		{-1, 0, `cannot use -1: need a non-negative whole number`},
+		{"many", 0, `cannot parse "many": need a non-negative whole number`},
+	} {
+		tr := config.NewTransaction(s.state)
+		tr.Set("core", "refresh.max-concurrent-downloads", t.value)
+		max, err := snapstate.MaxConcurrentDownloads(tr)
+		if t.err == "" {
+			c.Check(err, IsNil, Commentf("%v", t.value))
+			c.Check(max, Equals, t.max, Commentf("%v", t.value))
+		} else {
+			c.Check(err, ErrorMatches, t.err, Commentf("%v", t.value))
+		}
+	}
+}
+
+func (s *snapmgrTestSuite) TestLastRefresh(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	lastRefresh, err := s.snapmgr.LastRefresh()
+	c.Assert(err, IsNil)
+	c.Check(lastRefresh.IsZero(), Equals, true)
+
+	now := time.Now().UTC()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.last", now)
+	tr.Commit()
+
+	lastRefresh, err = s.snapmgr.LastRefresh()
+	c.Assert(err, IsNil)
+	c.Check(lastRefresh.Equal(now), Equals, true)
+}
+
 type snapmgrQuerySuite struct {
 	st *state.State
 }
@@ -4799,6 +5279,7 @@ func (s *snapmgrTestSuite) testTrySetsTryMode(flags snapstate.Flags, c *C) {
 		"setup-profiles",
 		"set-auto-aliases",
 		"setup-aliases",
+		"run-hook",
 		"start-snap-services",
 		"run-hook",
 	})
@@ -5482,13 +5963,15 @@ func (s *snapmgrTestSuite) TestRemoveMany(c *C) {
 	c.Assert(tts, HasLen, 2)
 	c.Check(removed, DeepEquals, []string{"one", "two"})
 
-	c.Assert(s.state.TaskCount(), Equals, 8*2)
+	c.Assert(s.state.TaskCount(), Equals, 10*2)
 	for _, ts := range tts {
 		c.Assert(taskKinds(ts.Tasks()), DeepEquals, []string{
 			"stop-snap-services",
+			"run-hook",
 			"remove-aliases",
 			"unlink-snap",
 			"remove-profiles",
+			"save-snapshot",
 			"clear-snap",
 			"discard-snap",
 			"clear-aliases",
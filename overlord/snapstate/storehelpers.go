@@ -61,6 +61,9 @@ func updateInfo(st *state.State, snapst *SnapState, channel string, userID int)
 	st.Unlock() // calls to the store should be done without holding the state lock
 	res, err := theStore.ListRefresh([]*store.RefreshCandidate{refreshCand}, user)
 	st.Lock()
+	if e, ok := err.(*store.IncompatibleUpdatesError); ok && len(e.Snaps) == 1 {
+		return nil, e.Snaps[0]
+	}
 	if err != nil {
 		return nil, fmt.Errorf("cannot get refresh information for snap %q: %s", curInfo.Name(), err)
 	}
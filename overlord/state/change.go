@@ -301,10 +301,20 @@ func (c *Change) Status() Status {
 // SetStatus sets the change status, overriding the default behavior (see Status method).
 func (c *Change) SetStatus(s Status) {
 	c.state.writing()
+	watched := c.state.hasSubscribers()
+	var old Status
+	if watched {
+		old = c.Status()
+	}
 	c.status = s
 	if s.Ready() {
 		c.markReady()
 	}
+	if watched {
+		if new := c.Status(); new != old {
+			c.emitStatus(new)
+		}
+	}
 }
 
 func (c *Change) markReady() {
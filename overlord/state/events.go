@@ -0,0 +1,194 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package state
+
+import (
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	// ChangeStatusEvent is emitted when the status of a change changes.
+	ChangeStatusEvent EventType = "change-status"
+	// TaskStatusEvent is emitted when the status of a task changes.
+	TaskStatusEvent EventType = "task-status"
+	// TaskProgressEvent is emitted when the progress of a task is updated.
+	TaskProgressEvent EventType = "task-progress"
+	// TaskLogEvent is emitted when a message is logged into a task.
+	TaskLogEvent EventType = "task-log"
+)
+
+// An Event describes something that happened to a change or one of
+// its tasks.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	ChangeID   string
+	ChangeKind string
+	// TaskID and TaskKind are empty for change events.
+	TaskID   string
+	TaskKind string
+
+	// Status is set for status events.
+	Status Status
+	// Label, Done and Total are set for progress events.
+	Label string
+	Done  int
+	Total int
+	// Message is set for log events, prefixed with the log kind
+	// as returned by Task.Log.
+	Message string
+}
+
+// EventFilter selects the events delivered to a Subscription. Empty
+// fields match everything.
+type EventFilter struct {
+	ChangeID    string
+	ChangeKinds []string
+}
+
+func (f *EventFilter) match(ev *Event) bool {
+	if f.ChangeID != "" && f.ChangeID != ev.ChangeID {
+		return false
+	}
+	if len(f.ChangeKinds) == 0 {
+		return true
+	}
+	for _, kind := range f.ChangeKinds {
+		if kind == ev.ChangeKind {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionBufferSize is how many events a subscription can have
+// pending before it is considered to be lagging behind.
+var subscriptionBufferSize = 256
+
+// A Subscription receives the events of changes and tasks as they
+// happen in the state.
+type Subscription struct {
+	state  *State
+	filter EventFilter
+	events chan Event
+
+	overflowed bool
+}
+
+// Subscribe returns a new Subscription receiving the events matching
+// filter. The state lock does not need to be held.
+//
+// Events are delivered without blocking the state. If the subscriber
+// does not keep up with them the subscription is dropped, its events
+// channel is closed, and Overflowed returns true.
+func (s *State) Subscribe(filter EventFilter) *Subscription {
+	sub := &Subscription{
+		state:  s,
+		filter: filter,
+		events: make(chan Event, subscriptionBufferSize),
+	}
+	s.subsLck.Lock()
+	s.subs = append(s.subs, sub)
+	s.subsLck.Unlock()
+	return sub
+}
+
+// Events returns the channel on which events are delivered. It is
+// closed when the subscription ends.
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+// Overflowed returns whether the subscription was dropped because
+// events were not consumed fast enough.
+func (sub *Subscription) Overflowed() bool {
+	sub.state.subsLck.Lock()
+	defer sub.state.subsLck.Unlock()
+	return sub.overflowed
+}
+
+// Close ends the subscription. The state lock does not need to be held.
+func (sub *Subscription) Close() {
+	sub.state.subsLck.Lock()
+	defer sub.state.subsLck.Unlock()
+	sub.state.unsubscribe(sub)
+}
+
+// unsubscribe must be called with subsLck held.
+func (s *State) unsubscribe(sub *Subscription) {
+	for i, other := range s.subs {
+		if other == sub {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			close(sub.events)
+			return
+		}
+	}
+}
+
+func (s *State) hasSubscribers() bool {
+	s.subsLck.Lock()
+	defer s.subsLck.Unlock()
+	return len(s.subs) > 0
+}
+
+func (s *State) emit(ev Event) {
+	s.subsLck.Lock()
+	defer s.subsLck.Unlock()
+	if len(s.subs) == 0 {
+		return
+	}
+	ev.Time = timeNow()
+	// iterate over a copy, lagging subscriptions are dropped
+	for _, sub := range append([]*Subscription(nil), s.subs...) {
+		if !sub.filter.match(&ev) {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			sub.overflowed = true
+			s.unsubscribe(sub)
+		}
+	}
+}
+
+func (t *Task) emit(ev Event) {
+	chg := t.Change()
+	if chg == nil {
+		return
+	}
+	ev.ChangeID = chg.ID()
+	ev.ChangeKind = chg.Kind()
+	ev.TaskID = t.id
+	ev.TaskKind = t.kind
+	t.state.emit(ev)
+}
+
+func (c *Change) emitStatus(status Status) {
+	c.state.emit(Event{
+		Type:       ChangeStatusEvent,
+		ChangeID:   c.id,
+		ChangeKind: c.kind,
+		Status:     status,
+	})
+}
@@ -0,0 +1,208 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package state_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type eventsSuite struct{}
+
+var _ = Suite(&eventsSuite{})
+
+func drain(sub *state.Subscription) []state.Event {
+	var evs []state.Event
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return evs
+			}
+			evs = append(evs, ev)
+		default:
+			return evs
+		}
+	}
+}
+
+type evSummary struct {
+	typ    state.EventType
+	chg    string
+	task   string
+	status state.Status
+}
+
+func summarize(evs []state.Event) []evSummary {
+	res := make([]evSummary, len(evs))
+	for i, ev := range evs {
+		res[i] = evSummary{ev.Type, ev.ChangeID, ev.TaskID, ev.Status}
+	}
+	return res
+}
+
+func (es *eventsSuite) TestStatusEvents(c *C) {
+	st := state.New(nil)
+	sub := st.Subscribe(state.EventFilter{})
+	defer sub.Close()
+
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("download", "1...")
+	t2 := st.NewTask("link", "2...")
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+
+	t1.SetStatus(state.DoingStatus)
+	t1.SetStatus(state.DoneStatus)
+	// no actual transition
+	t2.SetStatus(state.DoStatus)
+	t2.SetStatus(state.DoneStatus)
+
+	c.Check(summarize(drain(sub)), DeepEquals, []evSummary{
+		{state.TaskStatusEvent, chg.ID(), t1.ID(), state.DoingStatus},
+		{state.ChangeStatusEvent, chg.ID(), "", state.DoingStatus},
+		{state.TaskStatusEvent, chg.ID(), t1.ID(), state.DoneStatus},
+		{state.ChangeStatusEvent, chg.ID(), "", state.DoStatus},
+		{state.TaskStatusEvent, chg.ID(), t2.ID(), state.DoneStatus},
+		{state.ChangeStatusEvent, chg.ID(), "", state.DoneStatus},
+	})
+
+	chg.SetStatus(state.ErrorStatus)
+	evs := drain(sub)
+	c.Assert(evs, HasLen, 1)
+	c.Check(evs[0].Type, Equals, state.ChangeStatusEvent)
+	c.Check(evs[0].ChangeKind, Equals, "install")
+	c.Check(evs[0].Status, Equals, state.ErrorStatus)
+	c.Check(evs[0].Time.IsZero(), Equals, false)
+}
+
+func (es *eventsSuite) TestProgressAndLogEvents(c *C) {
+	st := state.New(nil)
+	sub := st.Subscribe(state.EventFilter{})
+	defer sub.Close()
+
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "1...")
+
+	// tasks not in a change are not reported
+	t.SetProgress("foo", 1, 10)
+	c.Check(drain(sub), HasLen, 0)
+
+	chg.AddTask(t)
+	t.SetProgress("foo", 2, 10)
+	t.Logf("hello %s", "world")
+
+	evs := drain(sub)
+	c.Assert(evs, HasLen, 2)
+	c.Check(evs[0].Type, Equals, state.TaskProgressEvent)
+	c.Check(evs[0].TaskKind, Equals, "download")
+	c.Check(evs[0].Label, Equals, "foo")
+	c.Check(evs[0].Done, Equals, 2)
+	c.Check(evs[0].Total, Equals, 10)
+	c.Check(evs[1].Type, Equals, state.TaskLogEvent)
+	c.Check(evs[1].Message, Matches, `\S+ INFO hello world`)
+}
+
+func (es *eventsSuite) TestFilter(c *C) {
+	st := state.New(nil)
+
+	st.Lock()
+	defer st.Unlock()
+
+	chg1 := st.NewChange("install", "...")
+	chg2 := st.NewChange("remove", "...")
+	t1 := st.NewTask("download", "1...")
+	t2 := st.NewTask("unlink", "2...")
+	chg1.AddTask(t1)
+	chg2.AddTask(t2)
+
+	byID := st.Subscribe(state.EventFilter{ChangeID: chg2.ID()})
+	defer byID.Close()
+	byKind := st.Subscribe(state.EventFilter{ChangeKinds: []string{"install", "refresh"}})
+	defer byKind.Close()
+
+	t1.Logf("1")
+	t2.Logf("2")
+
+	evs := drain(byID)
+	c.Assert(evs, HasLen, 1)
+	c.Check(evs[0].TaskID, Equals, t2.ID())
+
+	evs = drain(byKind)
+	c.Assert(evs, HasLen, 1)
+	c.Check(evs[0].TaskID, Equals, t1.ID())
+}
+
+func (es *eventsSuite) TestClose(c *C) {
+	st := state.New(nil)
+	sub := st.Subscribe(state.EventFilter{})
+	sub.Close()
+	// closing twice is fine
+	sub.Close()
+
+	_, ok := <-sub.Events()
+	c.Check(ok, Equals, false)
+	c.Check(sub.Overflowed(), Equals, false)
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "1...")
+	chg.AddTask(t)
+	// does not panic
+	t.Logf("hello")
+}
+
+func (es *eventsSuite) TestOverflow(c *C) {
+	restore := state.MockSubscriptionBufferSize(2)
+	defer restore()
+
+	st := state.New(nil)
+	sub := st.Subscribe(state.EventFilter{})
+	defer sub.Close()
+	other := st.Subscribe(state.EventFilter{ChangeKinds: []string{"other"}})
+	defer other.Close()
+
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "1...")
+	chg.AddTask(t)
+	t.Logf("1")
+	t.Logf("2")
+	c.Check(sub.Overflowed(), Equals, false)
+	t.Logf("3")
+	c.Check(sub.Overflowed(), Equals, true)
+
+	// the pending events can still be read
+	c.Check(drain(sub), HasLen, 2)
+	_, ok := <-sub.Events()
+	c.Check(ok, Equals, false)
+
+	// other subscriptions are unaffected
+	c.Check(other.Overflowed(), Equals, false)
+}
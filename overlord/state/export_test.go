@@ -44,3 +44,12 @@ func MockTaskTimes(t *Task, spawnTime, readyTime time.Time) {
 	t.spawnTime = spawnTime
 	t.readyTime = readyTime
 }
+
+// MockSubscriptionBufferSize changes the number of events a subscription can have pending.
+func MockSubscriptionBufferSize(n int) (restore func()) {
+	old := subscriptionBufferSize
+	subscriptionBufferSize = n
+	return func() {
+		subscriptionBufferSize = old
+	}
+}
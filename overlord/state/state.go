@@ -101,6 +101,10 @@ type State struct {
 
 	restarting bool
 	restartLck sync.Mutex
+
+	// subscriptions can be managed without holding the state lock
+	subs    []*Subscription
+	subsLck sync.Mutex
 }
 
 // New returns a new empty state.
@@ -56,6 +56,8 @@ type Task struct {
 	readyTime time.Time
 
 	atTime time.Time
+
+	timings []TimingSpan
 }
 
 func newTask(state *State, id, kind, summary string) *Task {
@@ -88,6 +90,8 @@ type marshalledTask struct {
 	ReadyTime *time.Time `json:"ready-time,omitempty"`
 
 	AtTime *time.Time `json:"at-time,omitempty"`
+
+	Timings []TimingSpan `json:"timings,omitempty"`
 }
 
 // MarshalJSON makes Task a json.Marshaller
@@ -119,6 +123,8 @@ func (t *Task) MarshalJSON() ([]byte, error) {
 		ReadyTime: readyTime,
 
 		AtTime: atTime,
+
+		Timings: t.timings,
 	})
 }
 
@@ -155,6 +161,7 @@ func (t *Task) UnmarshalJSON(data []byte) error {
 	if unmarshalled.AtTime != nil {
 		t.atTime = *unmarshalled.AtTime
 	}
+	t.timings = unmarshalled.Timings
 	return nil
 }
 
@@ -186,14 +193,34 @@ func (t *Task) Status() Status {
 func (t *Task) SetStatus(new Status) {
 	t.state.writing()
 	old := t.status
+	chg := t.Change()
+	watched := chg != nil && t.state.hasSubscribers()
+	var oldChgStatus Status
+	if watched {
+		oldChgStatus = chg.Status()
+	}
 	t.status = new
 	if !old.Ready() && new.Ready() {
 		t.readyTime = timeNow()
 	}
-	chg := t.Change()
 	if chg != nil {
 		chg.taskStatusChanged(t, old, new)
 	}
+	if watched {
+		if t.Status() != effectiveStatus(old) {
+			t.emit(Event{Type: TaskStatusEvent, Status: t.Status()})
+		}
+		if chgStatus := chg.Status(); chgStatus != oldChgStatus {
+			chg.emitStatus(chgStatus)
+		}
+	}
+}
+
+func effectiveStatus(status Status) Status {
+	if status == DefaultStatus {
+		return DoStatus
+	}
+	return status
 }
 
 // IsClean returns whether the task has been cleaned. See SetClean.
@@ -256,6 +283,8 @@ func (t *Task) SetProgress(label string, done, total int) {
 	} else {
 		t.progress = &progress{Label: label, Done: done, Total: total}
 	}
+	label, done, total = t.Progress()
+	t.emit(Event{Type: TaskProgressEvent, Label: label, Done: done, Total: total})
 }
 
 // SpawnTime returns the time when the change was created.
@@ -301,6 +330,7 @@ func (t *Task) addLog(kind, format string, args []interface{}) {
 	msg := fmt.Sprintf(tstr+" "+kind+" "+format, args...)
 	t.log = append(t.log, msg)
 	logger.Debugf(msg)
+	t.emit(Event{Type: TaskLogEvent, Message: msg})
 }
 
 // Log returns the most recent messages logged into the task.
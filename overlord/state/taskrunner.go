@@ -20,6 +20,8 @@
 package state
 
 import (
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -57,6 +59,9 @@ type TaskRunner struct {
 	blocked     func(t *Task, running []*Task) bool
 	someBlocked bool
 
+	limits   map[string]int
+	priority func(t *Task) int
+
 	// go-routines lifecycle
 	tombs map[string]*tomb.Tomb
 }
@@ -71,6 +76,7 @@ func NewTaskRunner(s *State) *TaskRunner {
 		state:    s,
 		handlers: make(map[string]handlerPair),
 		cleanups: make(map[string]HandlerFunc),
+		limits:   make(map[string]int),
 		tombs:    make(map[string]*tomb.Tomb),
 	}
 }
@@ -113,21 +119,67 @@ func (r *TaskRunner) SetBlocked(pred func(t *Task, running []*Task) bool) {
 	r.blocked = pred
 }
 
+// SetConcurrencyLimit sets the maximum number of tasks of the given
+// kind that are run at the same time, across all changes. Tasks over
+// the limit are kept waiting until a running one is done. A limit of
+// 0 means no limit, which is the default.
+func (r *TaskRunner) SetConcurrencyLimit(kind string, limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit <= 0 {
+		delete(r.limits, kind)
+		return
+	}
+	r.limits[kind] = limit
+}
+
+// SetPriority sets a function deciding the priority of a task ready
+// to run. Tasks with a higher priority are started first, so they
+// get ahead of others when concurrency is limited; tasks with the
+// same priority are started in the order they were created.
+func (r *TaskRunner) SetPriority(priority func(t *Task) int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.priority = priority
+}
+
+type readyTask struct {
+	task     *Task
+	priority int
+	seq      int
+}
+
+type byPriority []readyTask
+
+func (ts byPriority) Len() int      { return len(ts) }
+func (ts byPriority) Swap(i, j int) { ts[i], ts[j] = ts[j], ts[i] }
+func (ts byPriority) Less(i, j int) bool {
+	if ts[i].priority != ts[j].priority {
+		return ts[i].priority > ts[j].priority
+	}
+	return ts[i].seq < ts[j].seq
+}
+
 // run must be called with the state lock in place
 func (r *TaskRunner) run(t *Task) {
 	var handler HandlerFunc
+	var label string
 	switch t.Status() {
 	case DoStatus:
 		t.SetStatus(DoingStatus)
 		fallthrough
 	case DoingStatus:
 		handler = r.handlers[t.Kind()].do
+		label = "do"
 
 	case UndoStatus:
 		t.SetStatus(UndoingStatus)
 		fallthrough
 	case UndoingStatus:
 		handler = r.handlers[t.Kind()].undo
+		label = "undo"
 
 	default:
 		panic("internal error: attempted to run task in status " + t.Status().String())
@@ -140,10 +192,12 @@ func (r *TaskRunner) run(t *Task) {
 	tomb := &tomb.Tomb{}
 	r.tombs[t.ID()] = tomb
 	tomb.Go(func() error {
+		start := timeNow()
 		// Capture the error result with tomb.Kill so we can
 		// use tomb.Err uniformily to consider both it or a
 		// overriding previous Kill reason.
 		tomb.Kill(handler(t, tomb))
+		span := TimingSpan{Label: label, Start: start, Duration: timeNow().Sub(start)}
 
 		// Locks must be acquired in the same order everywhere.
 		r.mu.Lock()
@@ -173,6 +227,16 @@ func (r *TaskRunner) run(t *Task) {
 			}
 		}
 
+		switch err.(type) {
+		case nil:
+			span.Outcome = "done"
+		case *Retry:
+			span.Outcome = "retry"
+		default:
+			span.Outcome = "error"
+		}
+		t.addTiming(span)
+
 		switch x := err.(type) {
 		case *Retry:
 			// Handler asked to be called again later.
@@ -229,7 +293,9 @@ func (r *TaskRunner) clean(t *Task) {
 	tomb := &tomb.Tomb{}
 	r.tombs[t.ID()] = tomb
 	tomb.Go(func() error {
+		start := timeNow()
 		tomb.Kill(cleanup(t, tomb))
+		span := TimingSpan{Label: "cleanup", Start: start, Duration: timeNow().Sub(start)}
 
 		// Locks must be acquired in the same order everywhere.
 		r.mu.Lock()
@@ -241,9 +307,12 @@ func (r *TaskRunner) clean(t *Task) {
 
 		if tomb.Err() != nil {
 			logger.Debugf("Cleaning task %s: %s", t.ID(), tomb.Err())
+			span.Outcome = "retry"
 		} else {
 			t.SetClean()
+			span.Outcome = "done"
 		}
+		t.addTiming(span)
 		return nil
 	})
 }
@@ -281,7 +350,8 @@ func (r *TaskRunner) tryUndo(t *Task) {
 }
 
 // Ensure starts new goroutines for all known tasks with no pending
-// dependencies.
+// dependencies, highest priority first and within the concurrency
+// limits of their kinds.
 // Note that Ensure will lock the state.
 func (r *TaskRunner) Ensure() {
 	r.mu.Lock()
@@ -307,6 +377,7 @@ func (r *TaskRunner) Ensure() {
 
 	ensureTime := timeNow()
 	nextTaskTime := time.Time{}
+	var ready []readyTask
 	for _, t := range r.state.Tasks() {
 		handlers, ok := r.handlers[t.Kind()]
 		if !ok {
@@ -359,6 +430,31 @@ func (r *TaskRunner) Ensure() {
 			continue
 		}
 
+		rt := readyTask{task: t}
+		rt.seq, _ = strconv.Atoi(t.ID())
+		if r.priority != nil {
+			rt.priority = r.priority(t)
+		}
+		ready = append(ready, rt)
+	}
+
+	sort.Sort(byPriority(ready))
+
+	// tasks of the same kind in flight, be it doing or undoing
+	inFlight := make(map[string]int)
+	for _, t := range running {
+		if !t.Status().Ready() {
+			inFlight[t.Kind()]++
+		}
+	}
+
+	for _, rt := range ready {
+		t := rt.task
+		if limit := r.limits[t.Kind()]; limit > 0 && inFlight[t.Kind()] >= limit {
+			r.someBlocked = true
+			continue
+		}
+
 		if r.blocked != nil && r.blocked(t, running) {
 			r.someBlocked = true
 			continue
@@ -368,6 +464,7 @@ func (r *TaskRunner) Ensure() {
 		r.run(t)
 
 		running = append(running, t)
+		inFlight[t.Kind()]++
 	}
 
 	// schedule next Ensure no later than the next task time
@@ -621,6 +621,136 @@ func (ts *taskRunnerSuite) TestTaskSerialization(c *C) {
 	c.Check(ensureBeforeTick, HasLen, 0)
 }
 
+func (ts *taskRunnerSuite) TestConcurrencyLimit(c *C) {
+	ensureBeforeTick := make(chan bool, 10)
+	sb := &stateBackend{
+		ensureBefore:     time.Hour,
+		ensureBeforeSeen: ensureBeforeTick,
+	}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	started := make(chan *state.Task, 10)
+	release := make(chan bool)
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		started <- t
+		<-release
+		return nil
+	}, nil)
+	r.SetConcurrencyLimit("download", 2)
+
+	st.Lock()
+	var downloads []*state.Task
+	for i := 0; i < 2; i++ {
+		chg := st.NewChange("install", "...")
+		for j := 0; j < 2; j++ {
+			t := st.NewTask("download", "...")
+			chg.AddTask(t)
+			downloads = append(downloads, t)
+		}
+	}
+	st.Unlock()
+
+	waitStarted := func(n int) map[*state.Task]bool {
+		seen := make(map[*state.Task]bool)
+		for i := 0; i < n; i++ {
+			select {
+			case t := <-started:
+				seen[t] = true
+			case <-time.After(2 * time.Second):
+				c.Fatal("task wasn't started")
+			}
+		}
+		return seen
+	}
+
+	r.Ensure() // starts only two downloads
+	seen := waitStarted(2)
+	c.Check(seen[downloads[0]], Equals, true)
+	c.Check(seen[downloads[1]], Equals, true)
+
+	r.Ensure() // won't start more downloads
+	c.Check(started, HasLen, 0)
+
+	// finish one download
+	release <- true
+	select {
+	case <-ensureBeforeTick:
+	case <-time.After(2 * time.Second):
+		c.Fatal("EnsureBefore wasn't called")
+	}
+
+	r.Ensure() // starts the next one, still at most two
+	seen = waitStarted(1)
+	c.Check(seen[downloads[2]], Equals, true)
+	r.Ensure()
+	c.Check(started, HasLen, 0)
+
+	release <- true
+	release <- true
+	r.Wait()
+	r.Ensure()
+	seen = waitStarted(1)
+	c.Check(seen[downloads[3]], Equals, true)
+	release <- true
+	r.Wait()
+
+	st.Lock()
+	defer st.Unlock()
+	for _, t := range downloads {
+		c.Check(t.Status(), Equals, state.DoneStatus)
+	}
+}
+
+func (ts *taskRunnerSuite) TestPriority(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	started := make(chan *state.Task, 10)
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		started <- t
+		return nil
+	}, nil)
+	r.SetConcurrencyLimit("download", 1)
+	// auto-refreshes go after everything else
+	r.SetPriority(func(t *state.Task) int {
+		if t.Change().Kind() == "auto-refresh" {
+			return -1
+		}
+		return 0
+	})
+
+	st.Lock()
+	auto1 := st.NewTask("download", "...")
+	auto2 := st.NewTask("download", "...")
+	autoChg := st.NewChange("auto-refresh", "...")
+	autoChg.AddTask(auto1)
+	autoChg.AddTask(auto2)
+	user1 := st.NewTask("download", "...")
+	user2 := st.NewTask("download", "...")
+	userChg := st.NewChange("install", "...")
+	userChg.AddTask(user1)
+	userChg.AddTask(user2)
+	st.Unlock()
+
+	var order []*state.Task
+	for i := 0; i < 4; i++ {
+		r.Ensure()
+		select {
+		case t := <-started:
+			order = append(order, t)
+		case <-time.After(2 * time.Second):
+			c.Fatal("task wasn't started")
+		}
+		r.Wait()
+	}
+	// same priority ones go in creation order
+	c.Check(order, DeepEquals, []*state.Task{user1, user2, auto1, auto2})
+}
+
 func (ts *taskRunnerSuite) TestPrematureChangeReady(c *C) {
 	sb := &stateBackend{}
 	st := state.New(sb)
@@ -0,0 +1,62 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package state
+
+import (
+	"time"
+)
+
+// A TimingSpan records how long a piece of work, such as a run of a
+// task handler or a manager's part of an ensure pass, took.
+type TimingSpan struct {
+	// Label is "do", "undo" or "cleanup" for task handler runs,
+	// and the name of the manager for ensure passes.
+	Label    string        `json:"label"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	// Outcome is one of "done", "retry" or "error".
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// maxTaskTimings is the number of handler runs kept for a task,
+// which can be retried many times.
+const maxTaskTimings = 50
+
+func appendTimings(timings []TimingSpan, max int, span TimingSpan) []TimingSpan {
+	timings = append(timings, span)
+	if len(timings) > max {
+		timings = append([]TimingSpan(nil), timings[len(timings)-max:]...)
+	}
+	return timings
+}
+
+// Timings returns the recorded runs of the task handlers, oldest
+// first. Only the most recent runs are kept.
+//
+// The returned slice should not be read from without the
+// state lock held, and should not be written to.
+func (t *Task) Timings() []TimingSpan {
+	t.state.reading()
+	return t.timings
+}
+
+func (t *Task) addTiming(span TimingSpan) {
+	t.state.writing()
+	t.timings = appendTimings(t.timings, maxTaskTimings, span)
+}
@@ -0,0 +1,123 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package state_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type timingsSuite struct{}
+
+var _ = Suite(&timingsSuite{})
+
+func outcomes(spans []state.TimingSpan) []string {
+	res := make([]string, len(spans))
+	for i, span := range spans {
+		res[i] = span.Label + ":" + span.Outcome
+	}
+	return res
+}
+
+func (ts *timingsSuite) TestTaskRunnerRecordsHandlerRuns(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	calls := 0
+	r.AddHandler("retry-then-do", func(t *state.Task, tb *tomb.Tomb) error {
+		calls++
+		if calls == 1 {
+			return &state.Retry{}
+		}
+		return nil
+	}, func(t *state.Task, tb *tomb.Tomb) error {
+		return nil
+	})
+	r.AddHandler("fail", func(t *state.Task, tb *tomb.Tomb) error {
+		return errors.New("boom")
+	}, nil)
+	r.AddCleanup("retry-then-do", func(t *state.Task, tb *tomb.Tomb) error {
+		return nil
+	})
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("retry-then-do", "...")
+	t2 := st.NewTask("fail", "...")
+	t2.WaitFor(t1)
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+	st.Unlock()
+
+	for i := 0; i < 10; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	c.Assert(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(outcomes(t1.Timings()), DeepEquals, []string{"do:retry", "do:done", "undo:done", "cleanup:done"})
+	c.Check(outcomes(t2.Timings()), DeepEquals, []string{"do:error"})
+	for _, span := range t1.Timings() {
+		c.Check(span.Start.IsZero(), Equals, false)
+		c.Check(span.Duration >= 0, Equals, true)
+	}
+}
+
+func (ts *timingsSuite) TestTaskTimingsMarshalling(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+	r.AddHandler("foo", func(t *state.Task, tb *tomb.Tomb) error { return nil }, nil)
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("foo", "...")
+	chg.AddTask(t)
+	st.Unlock()
+
+	ensureChange(c, r, sb, chg)
+
+	st.Lock()
+	data, err := json.Marshal(st)
+	st.Unlock()
+	c.Assert(err, IsNil)
+
+	st2, err := state.ReadState(nil, bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	st2.Lock()
+	defer st2.Unlock()
+	st.Lock()
+	defer st.Unlock()
+	t2 := st2.Task(t.ID())
+	c.Assert(t2.Timings(), HasLen, 1)
+	c.Check(t2.Timings()[0].Label, Equals, "do")
+	c.Check(t2.Timings()[0].Start.Equal(t.Timings()[0].Start), Equals, true)
+	c.Check(t2.Timings()[0].Duration, Equals, t.Timings()[0].Duration)
+}
@@ -21,7 +21,9 @@ package overlord
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/snapcore/snapd/logger"
 
@@ -55,8 +57,17 @@ type StateEngine struct {
 	// managers in use
 	mgrLock  sync.Mutex
 	managers []StateManager
+
+	// timings of the recent ensure passes, kept in memory only
+	timingsLock   sync.Mutex
+	ensureTimings [maxEnsureTimings]state.TimingSpan
+	nextTiming    int
+	numTimings    int
 }
 
+// maxEnsureTimings is the number of ensure spans kept.
+const maxEnsureTimings = 1000
+
 // NewStateEngine returns a new state engine.
 func NewStateEngine(s *state.State) *StateEngine {
 	return &StateEngine{
@@ -92,19 +103,64 @@ func (se *StateEngine) Ensure() error {
 		return fmt.Errorf("state engine already stopped")
 	}
 	var errs []error
+	spans := make([]state.TimingSpan, 0, len(se.managers))
 	for _, m := range se.managers {
+		start := time.Now()
 		err := m.Ensure()
+		span := state.TimingSpan{
+			Label:    strings.TrimPrefix(fmt.Sprintf("%T", m), "*"),
+			Start:    start,
+			Duration: time.Since(start),
+			Outcome:  "done",
+		}
 		if err != nil {
 			logger.Noticef("state ensure error: %v", err)
 			errs = append(errs, err)
+			span.Outcome = "error"
 		}
+		spans = append(spans, span)
 	}
+	se.addEnsureTimings(spans)
 	if len(errs) != 0 {
 		return &ensureError{errs}
 	}
 	return nil
 }
 
+func (se *StateEngine) addEnsureTimings(spans []state.TimingSpan) {
+	se.timingsLock.Lock()
+	defer se.timingsLock.Unlock()
+	for _, span := range spans {
+		se.ensureTimings[se.nextTiming] = span
+		se.nextTiming = (se.nextTiming + 1) % maxEnsureTimings
+		if se.numTimings < maxEnsureTimings {
+			se.numTimings++
+		}
+	}
+}
+
+// EnsureTimings returns the spans of the recent ensure passes that
+// overlap the period from start to end, oldest first. A zero end
+// means up to now. Only the most recent spans are kept, and only in
+// memory.
+func (se *StateEngine) EnsureTimings(start, end time.Time) []state.TimingSpan {
+	se.timingsLock.Lock()
+	defer se.timingsLock.Unlock()
+	var res []state.TimingSpan
+	first := se.nextTiming - se.numTimings + maxEnsureTimings
+	for i := 0; i < se.numTimings; i++ {
+		span := se.ensureTimings[(first+i)%maxEnsureTimings]
+		if span.Start.Add(span.Duration).Before(start) {
+			continue
+		}
+		if !end.IsZero() && span.Start.After(end) {
+			continue
+		}
+		res = append(res, span)
+	}
+	return res
+}
+
 // AddManager adds the provided manager to take part in state operations.
 func (se *StateEngine) AddManager(m StateManager) {
 	se.mgrLock.Lock()
@@ -21,6 +21,7 @@ package overlord_test
 
 import (
 	"errors"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -101,6 +102,48 @@ func (ses *stateEngineSuite) TestEnsureError(c *C) {
 	c.Check(calls, DeepEquals, []string{"ensure:mgr1", "ensure:mgr2"})
 }
 
+func (ses *stateEngineSuite) TestEnsureRecordsTimings(c *C) {
+	s := state.New(nil)
+	se := overlord.NewStateEngine(s)
+
+	calls := []string{}
+
+	mgr1 := &fakeManager{name: "mgr1", calls: &calls}
+	mgr2 := &fakeManager{name: "mgr2", calls: &calls, ensureError: errors.New("boom")}
+
+	se.AddManager(mgr1)
+	se.AddManager(mgr2)
+
+	before := time.Now()
+	se.Ensure()
+	spans := se.EnsureTimings(time.Time{}, time.Time{})
+	c.Assert(spans, HasLen, 2)
+	c.Check(spans[0].Label, Equals, "overlord_test.fakeManager")
+	c.Check(spans[0].Outcome, Equals, "done")
+	c.Check(spans[1].Outcome, Equals, "error")
+	c.Check(spans[1].Start.Before(spans[0].Start), Equals, false)
+
+	c.Check(se.EnsureTimings(time.Time{}, before), HasLen, 0)
+	c.Check(se.EnsureTimings(before, time.Time{}), HasLen, 2)
+}
+
+func (ses *stateEngineSuite) TestEnsureTimingsCapped(c *C) {
+	s := state.New(nil)
+	se := overlord.NewStateEngine(s)
+
+	calls := []string{}
+	se.AddManager(&fakeManager{name: "mgr1", calls: &calls})
+
+	var all []state.TimingSpan
+	for i := 0; i < 1100; i++ {
+		se.Ensure()
+		spans := se.EnsureTimings(time.Time{}, time.Time{})
+		all = append(all, spans[len(spans)-1])
+	}
+	c.Check(calls, HasLen, 1100)
+	c.Check(se.EnsureTimings(time.Time{}, time.Time{}), DeepEquals, all[100:])
+}
+
 func (ses *stateEngineSuite) TestStop(c *C) {
 	s := state.New(nil)
 	se := overlord.NewStateEngine(s)
@@ -0,0 +1,499 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package overlord
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// The state is persisted as a full snapshot, the state file, plus an
+// append-only journal of the deltas checkpointed since the snapshot
+// was written. The journal is compacted into a new snapshot once it
+// grows bigger than the snapshot itself.
+//
+// The journal starts with a header line carrying the generation of
+// the snapshot it applies to, which is also recorded in the snapshot,
+// so that a journal left behind by an interrupted compaction is
+// ignored. Each delta is then recorded on its own line as
+//
+//    <length> <crc32> <json delta>
+//
+// with length and crc32 of the json delta in hex. A torn or corrupt
+// record at the end of the journal, from a crash while appending, is
+// discarded together with anything after it.
+//
+// A snapd that predates the journal only reads the state file. So
+// that it finds the complete state after a revert of snapd or core,
+// journaling is paused from when a restart is requested until no
+// change is in progress anymore, and the snapshot is rewritten at
+// every checkpoint meanwhile. The pause is recorded in
+// the snapshot to survive the restart.
+
+const (
+	journalHeader = "snapd-state-journal"
+	generationKey = "journal-generation"
+	pausedKey     = "journal-paused"
+)
+
+// minCompactSize is the journal size under which it is never compacted.
+var minCompactSize int64 = 64 * 1024
+
+// stateDoc is a state document split in its top-level entries, with
+// the entries of top-level objects (data, changes, tasks) kept apart
+// so that deltas can be expressed per entry.
+type stateDoc struct {
+	entries  map[string]json.RawMessage
+	sections map[string]map[string]json.RawMessage
+}
+
+func parseStateDoc(data []byte) (*stateDoc, error) {
+	var top map[string]json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&top); err != nil {
+		return nil, err
+	}
+	doc := &stateDoc{
+		entries:  make(map[string]json.RawMessage),
+		sections: make(map[string]map[string]json.RawMessage),
+	}
+	for k, v := range top {
+		if len(v) > 0 && v[0] == '{' {
+			var section map[string]json.RawMessage
+			if err := json.Unmarshal(v, &section); err != nil {
+				return nil, err
+			}
+			doc.sections[k] = section
+		} else {
+			doc.entries[k] = v
+		}
+	}
+	return doc, nil
+}
+
+func (doc *stateDoc) marshal() ([]byte, error) {
+	top := make(map[string]interface{}, len(doc.entries)+len(doc.sections))
+	for k, v := range doc.entries {
+		top[k] = v
+	}
+	for k, section := range doc.sections {
+		top[k] = section
+	}
+	return json.Marshal(top)
+}
+
+// stateDelta holds what changed between two state documents.
+type stateDelta struct {
+	// Set holds the new or changed top-level entries that are not objects.
+	Set map[string]json.RawMessage `json:"set,omitempty"`
+	// Update holds the new or changed entries of top-level objects.
+	Update map[string]map[string]json.RawMessage `json:"update,omitempty"`
+	// Delete holds the removed entries of top-level objects.
+	Delete map[string][]string `json:"delete,omitempty"`
+	// Remove holds the removed top-level entries.
+	Remove []string `json:"remove,omitempty"`
+}
+
+func (delta *stateDelta) empty() bool {
+	return len(delta.Set) == 0 && len(delta.Update) == 0 && len(delta.Delete) == 0 && len(delta.Remove) == 0
+}
+
+func diffStateDocs(old, new *stateDoc) *stateDelta {
+	delta := &stateDelta{
+		Set:    make(map[string]json.RawMessage),
+		Update: make(map[string]map[string]json.RawMessage),
+		Delete: make(map[string][]string),
+	}
+	for k, v := range new.entries {
+		if oldv, ok := old.entries[k]; !ok || !bytes.Equal(oldv, v) {
+			delta.Set[k] = v
+		}
+	}
+	for k, section := range new.sections {
+		oldSection, ok := old.sections[k]
+		if !ok {
+			delta.Update[k] = section
+			continue
+		}
+		for key, v := range section {
+			if oldv, ok := oldSection[key]; !ok || !bytes.Equal(oldv, v) {
+				if delta.Update[k] == nil {
+					delta.Update[k] = make(map[string]json.RawMessage)
+				}
+				delta.Update[k][key] = v
+			}
+		}
+		for key := range oldSection {
+			if _, ok := section[key]; !ok {
+				delta.Delete[k] = append(delta.Delete[k], key)
+			}
+		}
+	}
+	for k := range old.entries {
+		if _, ok := new.entries[k]; !ok {
+			if _, ok := new.sections[k]; !ok {
+				delta.Remove = append(delta.Remove, k)
+			}
+		}
+	}
+	for k := range old.sections {
+		if _, ok := new.sections[k]; !ok {
+			if _, ok := new.entries[k]; !ok {
+				delta.Remove = append(delta.Remove, k)
+			}
+		}
+	}
+	return delta
+}
+
+func (doc *stateDoc) apply(delta *stateDelta) {
+	for k, v := range delta.Set {
+		delete(doc.sections, k)
+		doc.entries[k] = v
+	}
+	for k, updates := range delta.Update {
+		section := doc.sections[k]
+		if section == nil {
+			delete(doc.entries, k)
+			section = make(map[string]json.RawMessage, len(updates))
+			doc.sections[k] = section
+		}
+		for key, v := range updates {
+			section[key] = v
+		}
+	}
+	for k, keys := range delta.Delete {
+		for _, key := range keys {
+			delete(doc.sections[k], key)
+		}
+	}
+	for _, k := range delta.Remove {
+		delete(doc.entries, k)
+		delete(doc.sections, k)
+	}
+}
+
+func encodeJournalRecord(delta *stateDelta) ([]byte, error) {
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%x %08x ", len(data), crc32.ChecksumIEEE(data))
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// readJournalRecord reads the next record, returning io.EOF at the
+// clean end of the journal and another error for a torn or corrupt record.
+func readJournalRecord(r *bufio.Reader) (delta *stateDelta, n int64, err error) {
+	var length int
+	var sum uint32
+	prefix, err := r.ReadString(' ')
+	if err == io.EOF && prefix == "" {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("truncated record")
+	}
+	if _, err := fmt.Sscanf(prefix, "%x ", &length); err != nil {
+		return nil, 0, fmt.Errorf("invalid record length %q", prefix)
+	}
+	sumStr, err := r.ReadString(' ')
+	if err != nil {
+		return nil, 0, fmt.Errorf("truncated record")
+	}
+	if _, err := fmt.Sscanf(sumStr, "%08x ", &sum); err != nil {
+		return nil, 0, fmt.Errorf("invalid record checksum %q", sumStr)
+	}
+	data := make([]byte, length+1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, fmt.Errorf("truncated record")
+	}
+	if data[length] != '\n' {
+		return nil, 0, fmt.Errorf("unterminated record")
+	}
+	data = data[:length]
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, 0, fmt.Errorf("checksum mismatch")
+	}
+	if err := json.Unmarshal(data, &delta); err != nil {
+		return nil, 0, fmt.Errorf("cannot decode record: %v", err)
+	}
+	return delta, int64(len(prefix) + len(sumStr) + length + 1), nil
+}
+
+// stateJournal persists the state as a snapshot plus a journal of deltas.
+type stateJournal struct {
+	path        string
+	journalPath string
+
+	// last is the state document as persisted, nil if a full
+	// snapshot needs to be written
+	last         *stateDoc
+	generation   int
+	snapshotSize int64
+	// paused is set while every checkpoint writes a full snapshot
+	paused bool
+
+	journal     *os.File
+	journalSize int64
+}
+
+func newStateJournal(path string) *stateJournal {
+	return &stateJournal{
+		path:        path,
+		journalPath: path + ".journal",
+	}
+}
+
+// load reads the snapshot and replays the journal, returning the
+// resulting state document. A torn tail of the journal is cut off so
+// that new deltas are appended after the last good record.
+func (sj *stateJournal) load() ([]byte, error) {
+	data, err := ioutil.ReadFile(sj.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the state file: %s", err)
+	}
+	doc, err := parseStateDoc(data)
+	if err != nil {
+		return nil, err
+	}
+	generation := 0
+	if v, ok := doc.entries[generationKey]; ok {
+		if err := json.Unmarshal(v, &generation); err != nil {
+			return nil, fmt.Errorf("invalid state journal generation: %v", err)
+		}
+		delete(doc.entries, generationKey)
+	}
+	paused := false
+	if v, ok := doc.entries[pausedKey]; ok {
+		if err := json.Unmarshal(v, &paused); err != nil {
+			return nil, fmt.Errorf("invalid state journal pause: %v", err)
+		}
+		delete(doc.entries, pausedKey)
+	}
+	sj.generation = generation
+	sj.paused = paused
+	sj.snapshotSize = int64(len(data))
+
+	replayed, err := sj.replay(doc)
+	if err != nil {
+		return nil, err
+	}
+	if !replayed {
+		// no usable journal, unchanged snapshot
+		sj.last = doc
+		sj.closeJournal()
+		return data, nil
+	}
+	sj.last = doc
+	return doc.marshal()
+}
+
+func (sj *stateJournal) replay(doc *stateDoc) (replayed bool, err error) {
+	f, err := os.OpenFile(sj.journalPath, os.O_RDWR, 0600)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r := bufio.NewReader(f)
+	header, err := r.ReadString('\n')
+	if err != nil || header != fmt.Sprintf("%s %d\n", journalHeader, sj.generation) {
+		// stale journal from an interrupted compaction, or torn
+		// header; the snapshot is all there is
+		f.Close()
+		return false, nil
+	}
+	offset := int64(len(header))
+	for {
+		delta, n, err := readJournalRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Noticef("discarding state journal from offset %d: %v", offset, err)
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return false, fmt.Errorf("cannot truncate state journal: %v", err)
+			}
+			break
+		}
+		doc.apply(delta)
+		offset += n
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return false, err
+	}
+	sj.journal = f
+	sj.journalSize = offset
+	return true, nil
+}
+
+// reset discards any journal, for when there is no snapshot to apply
+// it to.
+func (sj *stateJournal) reset() error {
+	sj.closeJournal()
+	sj.last = nil
+	if err := os.Remove(sj.journalPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (sj *stateJournal) closeJournal() {
+	if sj.journal != nil {
+		sj.journal.Close()
+		sj.journal = nil
+	}
+}
+
+// checkpoint persists data, a complete state document, either as a
+// delta appended to the journal or as a new snapshot.
+func (sj *stateJournal) checkpoint(data []byte) error {
+	doc, err := parseStateDoc(data)
+	if err != nil {
+		return err
+	}
+	if sj.paused || sj.last == nil || sj.journal == nil {
+		return sj.compact(doc)
+	}
+
+	delta := diffStateDocs(sj.last, doc)
+	if delta.empty() {
+		sj.last = doc
+		return nil
+	}
+	record, err := encodeJournalRecord(delta)
+	if err != nil {
+		return err
+	}
+	if sj.journalSize+int64(len(record)) > sj.compactSize() {
+		return sj.compact(doc)
+	}
+	if err := sj.append(record); err != nil {
+		return err
+	}
+	sj.last = doc
+	return nil
+}
+
+func (sj *stateJournal) compactSize() int64 {
+	if sj.snapshotSize > minCompactSize {
+		return sj.snapshotSize
+	}
+	return minCompactSize
+}
+
+func (sj *stateJournal) append(record []byte) error {
+	_, err := sj.journal.Write(record)
+	if err == nil {
+		err = sj.journal.Sync()
+	}
+	if err != nil {
+		// don't leave a partial record behind for the next ones
+		// to be appended after
+		if terr := sj.journal.Truncate(sj.journalSize); terr != nil {
+			// start over from a new snapshot next time
+			sj.closeJournal()
+			return err
+		}
+		sj.journal.Seek(sj.journalSize, io.SeekStart)
+		return err
+	}
+	sj.journalSize += int64(len(record))
+	return nil
+}
+
+// compactData writes data, a complete state document, as a new
+// snapshot regardless of the size of the journal.
+func (sj *stateJournal) compactData(data []byte) error {
+	doc, err := parseStateDoc(data)
+	if err != nil {
+		return err
+	}
+	return sj.compact(doc)
+}
+
+// pause makes every checkpoint write a full snapshot until resume is
+// called, also across restarts.
+func (sj *stateJournal) pause() {
+	sj.paused = true
+}
+
+// resume goes back to journaling checkpoints.
+func (sj *stateJournal) resume() {
+	sj.paused = false
+}
+
+// compact writes doc as a new snapshot of the next generation and
+// starts a new journal for it, unless journaling is paused.
+func (sj *stateJournal) compact(doc *stateDoc) error {
+	generation := sj.generation + 1
+	doc.entries[generationKey] = json.RawMessage(strconv.Itoa(generation))
+	if sj.paused {
+		doc.entries[pausedKey] = json.RawMessage("true")
+	}
+	data, err := doc.marshal()
+	delete(doc.entries, generationKey)
+	delete(doc.entries, pausedKey)
+	if err != nil {
+		return err
+	}
+	// from here the old journal no longer applies, as the
+	// generations won't match
+	if err := osutil.AtomicWriteFile(sj.path, data, 0600, 0); err != nil {
+		return err
+	}
+	sj.closeJournal()
+	sj.generation = generation
+	sj.snapshotSize = int64(len(data))
+	sj.last = doc
+	if sj.paused {
+		return nil
+	}
+
+	header := fmt.Sprintf("%s %d\n", journalHeader, generation)
+	if err := osutil.AtomicWriteFile(sj.journalPath, []byte(header), 0600, 0); err != nil {
+		// the snapshot is good, try again with the journal next time
+		sj.last = nil
+		return nil
+	}
+	f, err := os.OpenFile(sj.journalPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		sj.last = nil
+		return nil
+	}
+	sj.journal = f
+	sj.journalSize = int64(len(header))
+	return nil
+}
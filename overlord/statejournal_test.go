@@ -0,0 +1,314 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package overlord_test
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/patch"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type stateJournalSuite struct {
+	journalPath string
+}
+
+var _ = Suite(&stateJournalSuite{})
+
+func (s *stateJournalSuite) SetUpTest(c *C) {
+	tmpdir := c.MkDir()
+	dirs.SetRootDir(tmpdir)
+	dirs.SnapStateFile = filepath.Join(tmpdir, "test.json")
+	s.journalPath = dirs.SnapStateFile + ".journal"
+	snapstate.CanAutoRefresh = nil
+}
+
+func (s *stateJournalSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("/")
+}
+
+func journalRecord(delta string) string {
+	return fmt.Sprintf("%x %08x %s\n", len(delta), crc32.ChecksumIEEE([]byte(delta)), delta)
+}
+
+func (s *stateJournalSuite) setMark(c *C, o *overlord.Overlord, mark int) {
+	st := o.State()
+	st.Lock()
+	st.Set("mark", mark)
+	st.Unlock()
+}
+
+func (s *stateJournalSuite) mark(c *C, o *overlord.Overlord) int {
+	st := o.State()
+	st.Lock()
+	defer st.Unlock()
+	var mark int
+	c.Assert(st.Get("mark", &mark), IsNil)
+	return mark
+}
+
+func (s *stateJournalSuite) journalSize(c *C) int64 {
+	fi, err := os.Stat(s.journalPath)
+	c.Assert(err, IsNil)
+	return fi.Size()
+}
+
+func (s *stateJournalSuite) TestCheckpointsAreJournaled(c *C) {
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+
+	s.setMark(c, o, 1)
+	snapshot, err := ioutil.ReadFile(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+
+	s.setMark(c, o, 2)
+	s.setMark(c, o, 3)
+
+	// the snapshot was not rewritten
+	content, err := ioutil.ReadFile(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, string(snapshot))
+
+	journal, err := ioutil.ReadFile(s.journalPath)
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSuffix(string(journal), "\n"), "\n")
+	c.Assert(len(lines) >= 3, Equals, true)
+	c.Check(lines[0], Matches, `snapd-state-journal [0-9]+`)
+	c.Check(lines[len(lines)-2], Equals, strings.TrimSuffix(journalRecord(`{"update":{"data":{"mark":2}}}`), "\n"))
+	c.Check(lines[len(lines)-1], Equals, strings.TrimSuffix(journalRecord(`{"update":{"data":{"mark":3}}}`), "\n"))
+}
+
+func (s *stateJournalSuite) TestJournalReplayedOnLoad(c *C) {
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	s.setMark(c, o, 1)
+	s.setMark(c, o, 2)
+
+	// no Stop, as if snapd went away abruptly
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	c.Check(s.mark(c, o), Equals, 2)
+
+	// and journaling carries on from there
+	s.setMark(c, o, 3)
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	c.Check(s.mark(c, o), Equals, 3)
+}
+
+func (s *stateJournalSuite) TestCompaction(c *C) {
+	restore := overlord.MockMinCompactSize(0)
+	defer restore()
+
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	s.setMark(c, o, 1)
+
+	fi, err := os.Stat(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	st := o.State()
+	st.Lock()
+	// bigger than the whole snapshot
+	st.Set("big", strings.Repeat("x", int(fi.Size())))
+	st.Unlock()
+
+	// the journal was folded into a new snapshot
+	journal, err := ioutil.ReadFile(s.journalPath)
+	c.Assert(err, IsNil)
+	c.Check(string(journal), Matches, "snapd-state-journal [0-9]+\n")
+	content, err := ioutil.ReadFile(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), testutil.Contains, `"big":"xxx`)
+	c.Check(string(content), testutil.Contains, `"mark":1`)
+
+	// small changes are journaled again
+	s.setMark(c, o, 2)
+	c.Check(s.journalSize(c) > int64(len(journal)), Equals, true)
+
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	c.Check(s.mark(c, o), Equals, 2)
+}
+
+func (s *stateJournalSuite) TestStopCompacts(c *C) {
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	s.setMark(c, o, 1)
+	s.setMark(c, o, 2)
+	o.Loop()
+	c.Assert(o.Stop(), IsNil)
+
+	content, err := ioutil.ReadFile(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), testutil.Contains, `"mark":2`)
+	journal, err := ioutil.ReadFile(s.journalPath)
+	c.Assert(err, IsNil)
+	c.Check(string(journal), Matches, "snapd-state-journal [0-9]+\n")
+}
+
+func (s *stateJournalSuite) TestRestartPausesJournal(c *C) {
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	o.SetRestartHandler(func(state.RestartType) {})
+	s.setMark(c, o, 1)
+
+	st := o.State()
+	st.Lock()
+	t := st.NewTask("foo", "...")
+	st.NewChange("refresh", "...").AddTask(t)
+	st.RequestRestart(state.RestartDaemon)
+	st.Unlock()
+
+	// the state file is complete for any snapd to read
+	s.setMark(c, o, 2)
+	content, err := ioutil.ReadFile(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), testutil.Contains, `"mark":2`)
+	c.Check(string(content), testutil.Contains, `"journal-paused":true`)
+
+	// also after the restart, while the change is in progress
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	c.Check(s.mark(c, o), Equals, 2)
+	s.setMark(c, o, 3)
+	content, err = ioutil.ReadFile(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), testutil.Contains, `"mark":3`)
+
+	// the change is done, journaling resumes
+	st = o.State()
+	st.Lock()
+	st.Task(t.ID()).SetStatus(state.DoneStatus)
+	st.Unlock()
+	// errors of the managers don't matter here
+	o.Settle()
+
+	s.setMark(c, o, 4)
+	s.setMark(c, o, 5)
+	content, err = ioutil.ReadFile(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Not(testutil.Contains), `"mark":5`)
+	c.Check(string(content), Not(testutil.Contains), `"journal-paused"`)
+	journal, err := ioutil.ReadFile(s.journalPath)
+	c.Assert(err, IsNil)
+	c.Check(string(journal), testutil.Contains, `{"update":{"data":{"mark":5}}}`)
+
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	c.Check(s.mark(c, o), Equals, 5)
+}
+
+func (s *stateJournalSuite) TestTornWrite(c *C) {
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	s.setMark(c, o, 1)
+	s.setMark(c, o, 2)
+	good := s.journalSize(c)
+	s.setMark(c, o, 3)
+	full := s.journalSize(c)
+
+	// cut the last record short, as from a crash halfway through
+	// appending it
+	for _, size := range []int64{good + 1, good + 5, full - 10, full - 1} {
+		err := os.Truncate(s.journalPath, size)
+		c.Assert(err, IsNil)
+
+		o, err = overlord.New()
+		c.Assert(err, IsNil)
+		c.Check(s.mark(c, o), Equals, 2)
+		// the torn record got dropped
+		c.Check(s.journalSize(c), Equals, good)
+
+		s.setMark(c, o, 3)
+		c.Check(s.journalSize(c), Equals, full)
+	}
+}
+
+func (s *stateJournalSuite) TestCorruptRecord(c *C) {
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	s.setMark(c, o, 1)
+	good := s.journalSize(c)
+	s.setMark(c, o, 2)
+	s.setMark(c, o, 3)
+
+	journal, err := ioutil.ReadFile(s.journalPath)
+	c.Assert(err, IsNil)
+	i := strings.Index(string(journal[good:]), `"mark":2`) + int(good)
+	journal[i+len(`"mark":`)] = '7'
+	c.Assert(ioutil.WriteFile(s.journalPath, journal, 0600), IsNil)
+
+	// nothing from the corrupt record on is trusted
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	c.Check(s.mark(c, o), Equals, 1)
+	c.Check(s.journalSize(c), Equals, good)
+}
+
+func (s *stateJournalSuite) TestStaleJournalIgnored(c *C) {
+	snapshot := fmt.Sprintf(`{"data":{"patch-level":%d,"mark":1},"journal-generation":5}`, patch.Level)
+	c.Assert(ioutil.WriteFile(dirs.SnapStateFile, []byte(snapshot), 0600), IsNil)
+	// left behind by a compaction interrupted after writing the snapshot
+	journal := "snapd-state-journal 4\n" + journalRecord(`{"update":{"data":{"mark":2}}}`)
+	c.Assert(ioutil.WriteFile(s.journalPath, []byte(journal), 0600), IsNil)
+
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	c.Check(s.mark(c, o), Equals, 1)
+
+	// once the right one
+	journal = "snapd-state-journal 5\n" + journalRecord(`{"update":{"data":{"mark":2}}}`)
+	c.Assert(ioutil.WriteFile(s.journalPath, []byte(journal), 0600), IsNil)
+
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	c.Check(s.mark(c, o), Equals, 2)
+
+	// the generation is not part of the state
+	st := o.State()
+	st.Lock()
+	defer st.Unlock()
+	data, err := st.MarshalJSON()
+	c.Assert(err, IsNil)
+	c.Check(string(data), Not(testutil.Contains), "journal-generation")
+}
+
+func (s *stateJournalSuite) TestJournalWithoutSnapshotRemoved(c *C) {
+	journal := "snapd-state-journal 1\n" + journalRecord(`{"update":{"data":{"mark":2}}}`)
+	c.Assert(ioutil.WriteFile(s.journalPath, []byte(journal), 0600), IsNil)
+
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	st := o.State()
+	st.Lock()
+	defer st.Unlock()
+	var mark int
+	c.Check(st.Get("mark", &mark), Equals, state.ErrNoState)
+}
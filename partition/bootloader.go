@@ -64,24 +64,32 @@ type Bootloader interface {
 	ConfigFile() string
 }
 
-// InstallBootConfig installs the bootloader config from the gadget
-// snap dir into the right place.
-func InstallBootConfig(gadgetDir string) error {
+// FindBootConfig returns the bootloader config file in the gadget
+// snap dir and where it gets installed.
+func FindBootConfig(gadgetDir string) (gadgetFile, systemFile string, err error) {
 	for _, bl := range []Bootloader{&grub{}, &uboot{}} {
 		// the bootloader config file has to be root of the gadget snap
 		gadgetFile := filepath.Join(gadgetDir, bl.Name()+".conf")
-		if !osutil.FileExists(gadgetFile) {
-			continue
+		if osutil.FileExists(gadgetFile) {
+			return gadgetFile, bl.ConfigFile(), nil
 		}
+	}
 
-		systemFile := bl.ConfigFile()
-		if err := os.MkdirAll(filepath.Dir(systemFile), 0755); err != nil {
-			return err
-		}
-		return osutil.CopyFile(gadgetFile, systemFile, osutil.CopyFlagOverwrite)
+	return "", "", fmt.Errorf("cannot find boot config in %q", gadgetDir)
+}
+
+// InstallBootConfig installs the bootloader config from the gadget
+// snap dir into the right place.
+func InstallBootConfig(gadgetDir string) error {
+	gadgetFile, systemFile, err := FindBootConfig(gadgetDir)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Errorf("cannot find boot config in %q", gadgetDir)
+	if err := os.MkdirAll(filepath.Dir(systemFile), 0755); err != nil {
+		return err
+	}
+	return osutil.CopyFile(gadgetFile, systemFile, osutil.CopyFlagOverwrite)
 }
 
 var forcedBootloader Bootloader
@@ -116,7 +124,7 @@ func ForceBootloader(booloader Bootloader) {
 // that snappy will consider this combination of kernel/os a valid
 // target for rollback
 func MarkBootSuccessful(bootloader Bootloader) error {
-	m, err := bootloader.GetBootVars("snap_mode", "snap_try_core", "snap_try_kernel")
+	m, err := bootloader.GetBootVars("snap_mode", "snap_try_core", "snap_try_kernel", "snap_try_gadget")
 	if err != nil {
 		return err
 	}
@@ -128,7 +136,7 @@ func MarkBootSuccessful(bootloader Bootloader) error {
 	}
 
 	// update the boot vars
-	for _, k := range []string{"kernel", "core"} {
+	for _, k := range []string{"kernel", "core", "gadget"} {
 		tryBootVar := fmt.Sprintf("snap_try_%s", k)
 		bootVar := fmt.Sprintf("snap_%s", k)
 		// update the boot vars
@@ -104,6 +104,7 @@ func (s *PartitionTestSuite) TestMarkBootSuccessfulAllSnap(c *C) {
 		"snap_mode":       "",
 		"snap_try_kernel": "",
 		"snap_try_core":   "",
+		"snap_try_gadget": "",
 		// updated
 		"snap_kernel": "k1",
 		"snap_core":   "os1",
@@ -130,6 +131,7 @@ func (s *PartitionTestSuite) TestMarkBootSuccessfulKKernelUpdate(c *C) {
 		"snap_mode":       "",
 		"snap_try_kernel": "",
 		"snap_try_core":   "",
+		"snap_try_gadget": "",
 		// unchanged
 		"snap_core": "os1",
 		// updated
@@ -137,6 +139,42 @@ func (s *PartitionTestSuite) TestMarkBootSuccessfulKKernelUpdate(c *C) {
 	})
 }
 
+func (s *PartitionTestSuite) TestMarkBootSuccessfulGadgetUpdate(c *C) {
+	b := newMockBootloader()
+	b.bootVars["snap_mode"] = "trying"
+	b.bootVars["snap_core"] = "os1"
+	b.bootVars["snap_kernel"] = "k1"
+	b.bootVars["snap_gadget"] = "g1"
+	b.bootVars["snap_try_gadget"] = "g2"
+	err := MarkBootSuccessful(b)
+	c.Assert(err, IsNil)
+	c.Assert(b.bootVars, DeepEquals, map[string]string{
+		// cleared
+		"snap_mode":       "",
+		"snap_try_kernel": "",
+		"snap_try_core":   "",
+		"snap_try_gadget": "",
+		// unchanged
+		"snap_core":   "os1",
+		"snap_kernel": "k1",
+		// updated
+		"snap_gadget": "g2",
+	})
+}
+
+func (s *PartitionTestSuite) TestFindBootConfig(c *C) {
+	mockGadgetDir := c.MkDir()
+	_, _, err := FindBootConfig(mockGadgetDir)
+	c.Assert(err, ErrorMatches, `cannot find boot config in.*`)
+
+	err = ioutil.WriteFile(filepath.Join(mockGadgetDir, "uboot.conf"), nil, 0644)
+	c.Assert(err, IsNil)
+	gadgetFile, systemFile, err := FindBootConfig(mockGadgetDir)
+	c.Assert(err, IsNil)
+	c.Check(gadgetFile, Equals, filepath.Join(mockGadgetDir, "uboot.conf"))
+	c.Check(systemFile, Equals, filepath.Join(dirs.GlobalRootDir, "/boot/uboot/uboot.env"))
+}
+
 func (s *PartitionTestSuite) TestInstallBootloaderConfigNoConfig(c *C) {
 	err := InstallBootConfig(c.MkDir())
 	c.Assert(err, ErrorMatches, `cannot find boot config in.*`)
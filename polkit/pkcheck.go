@@ -0,0 +1,126 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+// Package polkit checks with polkit whether processes are authorized
+// for actions.
+package polkit
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// CheckFlags change how an authorization is checked.
+type CheckFlags uint32
+
+const (
+	// CheckNone just checks the authorization.
+	CheckNone CheckFlags = 0
+	// CheckAllowInteraction lets polkit ask the user to authenticate,
+	// through the authentication agent of their session.
+	CheckAllowInteraction CheckFlags = 1
+)
+
+var (
+	// ErrInteraction is returned when the process could be authorized
+	// if the user authenticated.
+	ErrInteraction = errors.New("authorization requires interaction")
+	// ErrDismissed is returned when the user dismissed the
+	// authentication dialog.
+	ErrDismissed = errors.New("authorization was dismissed")
+)
+
+var (
+	pkcheckCmd = "pkcheck"
+	procDir    = "/proc"
+)
+
+// processStartTime returns the start time of the process, which polkit
+// uses together with the pid to tell it apart from later processes
+// with the same pid.
+func processStartTime(pid uint32) (uint64, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/stat", procDir, pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name is in parentheses and may contain spaces, the
+	// start time is the 22nd field, the 20th after it, see proc(5)
+	idx := strings.LastIndexByte(string(stat), ')')
+	if idx < 0 {
+		return 0, fmt.Errorf("cannot parse stat of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[idx+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("cannot parse stat of process %d", pid)
+	}
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse start time of process %d: %v", pid, err)
+	}
+	return startTime, nil
+}
+
+// CheckAuthorization checks whether the process with the given pid,
+// owned by uid, is authorized for the action with the given id.
+func CheckAuthorization(pid, uid uint32, actionID string, details map[string]string, flags CheckFlags) (bool, error) {
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		return false, fmt.Errorf("cannot check authorization: %v", err)
+	}
+
+	args := []string{
+		"--action-id", actionID,
+		"--process", fmt.Sprintf("%d,%d,%d", pid, startTime, uid),
+	}
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--detail", k, details[k])
+	}
+	if flags&CheckAllowInteraction != 0 {
+		args = append(args, "--allow-user-interaction")
+	}
+
+	output, err := exec.Command(pkcheckCmd, args...).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	exitCode, err := osutil.ExitCode(err)
+	if err != nil {
+		return false, fmt.Errorf("cannot check authorization: %v", err)
+	}
+	switch exitCode {
+	case 1:
+		return false, nil
+	case 2:
+		return false, ErrInteraction
+	case 3:
+		return false, ErrDismissed
+	}
+	return false, fmt.Errorf("cannot check authorization: pkcheck failed with exit status %d: %s", exitCode, strings.TrimSpace(string(output)))
+}
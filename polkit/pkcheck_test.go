@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package polkit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/polkit"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type polkitSuite struct {
+	proc    string
+	pkcheck *testutil.MockCmd
+	restore func()
+}
+
+var _ = Suite(&polkitSuite{})
+
+func (s *polkitSuite) SetUpTest(c *C) {
+	s.proc = c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(s.proc, "42"), 0755), IsNil)
+	// the command name has spaces and parentheses
+	stat := "42 (a (weird) cmd) S 1 42 42 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 987654 1000 100\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(s.proc, "42", "stat"), []byte(stat), 0644), IsNil)
+
+	s.pkcheck = testutil.MockCommand(c, "pkcheck", "")
+	s.restore = polkit.MockPkcheck(filepath.Join(s.pkcheck.BinDir(), "pkcheck"), s.proc)
+}
+
+func (s *polkitSuite) TearDownTest(c *C) {
+	s.restore()
+	s.pkcheck.Restore()
+}
+
+func (s *polkitSuite) TestCheckAuthorization(c *C) {
+	authorized, err := polkit.CheckAuthorization(42, 1000, "io.snapcraft.snapd.install", nil, polkit.CheckNone)
+	c.Assert(err, IsNil)
+	c.Check(authorized, Equals, true)
+
+	c.Check(s.pkcheck.Calls(), DeepEquals, [][]string{
+		{"pkcheck", "--action-id", "io.snapcraft.snapd.install", "--process", "42,987654,1000"},
+	})
+}
+
+func (s *polkitSuite) TestCheckAuthorizationDetailsAndInteraction(c *C) {
+	details := map[string]string{"snap": "foo", "action": "install"}
+	_, err := polkit.CheckAuthorization(42, 1000, "io.snapcraft.snapd.install", details, polkit.CheckAllowInteraction)
+	c.Assert(err, IsNil)
+
+	c.Check(s.pkcheck.Calls(), DeepEquals, [][]string{
+		{"pkcheck", "--action-id", "io.snapcraft.snapd.install", "--process", "42,987654,1000",
+			"--detail", "action", "install", "--detail", "snap", "foo", "--allow-user-interaction"},
+	})
+}
+
+func (s *polkitSuite) TestCheckAuthorizationDenied(c *C) {
+	for _, t := range []struct {
+		script string
+		err    string
+	}{
+		{"exit 1", ""},
+		{"exit 2", "authorization requires interaction"},
+		{"exit 3", "authorization was dismissed"},
+		{"echo boom; exit 4", "cannot check authorization: pkcheck failed with exit status 4: boom"},
+	} {
+		pkcheck := testutil.MockCommand(c, "pkcheck", t.script)
+		restore := polkit.MockPkcheck(filepath.Join(pkcheck.BinDir(), "pkcheck"), s.proc)
+		authorized, err := polkit.CheckAuthorization(42, 1000, "io.snapcraft.snapd.remove", nil, polkit.CheckNone)
+		restore()
+		pkcheck.Restore()
+		c.Check(authorized, Equals, false)
+		if t.err == "" {
+			c.Check(err, IsNil)
+		} else {
+			c.Check(err, ErrorMatches, t.err)
+		}
+	}
+}
+
+func (s *polkitSuite) TestCheckAuthorizationNoProcess(c *C) {
+	authorized, err := polkit.CheckAuthorization(43, 1000, "io.snapcraft.snapd.install", nil, polkit.CheckNone)
+	c.Check(err, ErrorMatches, "cannot check authorization: open .*/43/stat: no such file or directory")
+	c.Check(authorized, Equals, false)
+	c.Check(s.pkcheck.Calls(), HasLen, 0)
+}
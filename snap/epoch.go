@@ -0,0 +1,247 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Epoch describes the data formats a snap revision can read and write.
+//
+// Most snaps never need to care: they default to epoch 0 and are only
+// offered refreshes to revisions that are also at epoch 0. When the format
+// of the data changes the developer bumps the epoch with the short form
+//
+//	epoch: N
+//
+// meaning the snap reads and writes exactly the Nth format, or
+//
+//	epoch: N*
+//
+// meaning the snap can additionally read (and migrate) the data of the
+// previous epoch. If that is not enough the sets can be spelled out:
+//
+//	epoch:
+//	  read: [1, 2, 3]
+//	  write: [3]
+//
+// A revision can be refreshed to another one only if the latter can read
+// the data written by the former.
+type Epoch struct {
+	Read  []uint32 `json:"read"`
+	Write []uint32 `json:"write"`
+}
+
+// ParseEpoch returns the epoch described by the short form s ("N" or "N*").
+// See E for a function more suitable for hardcoded epochs.
+func ParseEpoch(s string) (Epoch, error) {
+	if err := ValidateEpoch(s); err != nil {
+		return Epoch{}, err
+	}
+	star := strings.HasSuffix(s, "*")
+	n, err := strconv.ParseUint(strings.TrimSuffix(s, "*"), 10, 32)
+	if err != nil {
+		return Epoch{}, fmt.Errorf("invalid snap epoch: %q", s)
+	}
+	if n == 0 {
+		return Epoch{}, nil
+	}
+	e := uint32(n)
+	if star {
+		return Epoch{Read: []uint32{e - 1, e}, Write: []uint32{e}}, nil
+	}
+	return Epoch{Read: []uint32{e}, Write: []uint32{e}}, nil
+}
+
+// E returns the epoch described by the short form s.
+// Providing an invalid epoch causes a runtime panic.
+// See ParseEpoch for a polite function that does not panic.
+func E(s string) Epoch {
+	e, err := ParseEpoch(s)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// lists returns the read and write sets of the epoch, the zero value
+// standing for epoch 0.
+func (e Epoch) lists() (read, write []uint32) {
+	if len(e.Read) == 0 && len(e.Write) == 0 {
+		return []uint32{0}, []uint32{0}
+	}
+	return e.Read, e.Write
+}
+
+// IsZero checks whether the epoch is epoch 0.
+func (e Epoch) IsZero() bool {
+	read, write := e.lists()
+	return len(read) == 1 && read[0] == 0 && len(write) == 1 && write[0] == 0
+}
+
+// Validate checks that the read and write sets are sensible.
+func (e Epoch) Validate() error {
+	read, write := e.lists()
+	if len(read) == 0 || len(write) == 0 {
+		return fmt.Errorf("invalid snap epoch: read and write sets cannot be empty")
+	}
+	for _, set := range [][]uint32{read, write} {
+		for i := 1; i < len(set); i++ {
+			if set[i] <= set[i-1] {
+				return fmt.Errorf("invalid snap epoch %s: sets must be in increasing order", e)
+			}
+		}
+	}
+	for _, w := range write {
+		if !containsEpoch(read, w) {
+			return fmt.Errorf("invalid snap epoch %s: cannot write epoch %d without reading it", e, w)
+		}
+	}
+	return nil
+}
+
+// CanRead checks whether a revision with this epoch can read the data
+// written by a revision with the other epoch.
+func (e Epoch) CanRead(other Epoch) bool {
+	read, _ := e.lists()
+	_, write := other.lists()
+	for _, w := range write {
+		if containsEpoch(read, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsEpoch(set []uint32, n uint32) bool {
+	for _, m := range set {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the short form of the epoch if there is one, or the
+// read and write sets otherwise.
+func (e Epoch) String() string {
+	read, write := e.lists()
+	if len(write) == 1 {
+		n := write[0]
+		if len(read) == 1 && read[0] == n {
+			return strconv.FormatUint(uint64(n), 10)
+		}
+		if n > 0 && len(read) == 2 && read[0] == n-1 && read[1] == n {
+			return strconv.FormatUint(uint64(n), 10) + "*"
+		}
+	}
+	return fmt.Sprintf("{read: %s, write: %s}", formatEpochSet(read), formatEpochSet(write))
+}
+
+func formatEpochSet(set []uint32) string {
+	strs := make([]string, len(set))
+	for i, n := range set {
+		strs[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
+
+func (e *Epoch) fromShortForm(s string) error {
+	parsed, err := ParseEpoch(s)
+	if err != nil {
+		return err
+	}
+	*e = parsed
+	return nil
+}
+
+func (e *Epoch) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var short string
+	if err := unmarshal(&short); err == nil {
+		return e.fromShortForm(short)
+	}
+	var sets struct {
+		Read  []uint32 `yaml:"read"`
+		Write []uint32 `yaml:"write"`
+	}
+	if err := unmarshal(&sets); err != nil {
+		return err
+	}
+	parsed := Epoch{Read: sets.Read, Write: sets.Write}
+	if len(parsed.Read) == 0 || len(parsed.Write) == 0 {
+		return fmt.Errorf("invalid snap epoch: read and write sets cannot be empty")
+	}
+	if err := parsed.Validate(); err != nil {
+		return err
+	}
+	*e = parsed
+	return nil
+}
+
+// MarshalJSON uses the short form when there is one so that the epoch
+// looks the same as it always did to clients that only know about it.
+func (e Epoch) MarshalJSON() ([]byte, error) {
+	s := e.String()
+	if !strings.HasPrefix(s, "{") {
+		return json.Marshal(s)
+	}
+	read, write := e.lists()
+	return json.Marshal(struct {
+		Read  []uint32 `json:"read"`
+		Write []uint32 `json:"write"`
+	}{read, write})
+}
+
+func (e *Epoch) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var short string
+		if err := json.Unmarshal(data, &short); err != nil {
+			return err
+		}
+		if short == "" {
+			// not all store responses carry an epoch
+			*e = Epoch{}
+			return nil
+		}
+		return e.fromShortForm(short)
+	}
+	if len(data) > 0 && data[0] != '{' {
+		return e.fromShortForm(string(data))
+	}
+	var sets struct {
+		Read  []uint32 `json:"read"`
+		Write []uint32 `json:"write"`
+	}
+	if err := json.Unmarshal(data, &sets); err != nil {
+		return err
+	}
+	parsed := Epoch{Read: sets.Read, Write: sets.Write}
+	if err := parsed.Validate(); err != nil {
+		return err
+	}
+	*e = parsed
+	return nil
+}
@@ -0,0 +1,149 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snap_test
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/snapcore/snapd/snap"
+)
+
+type epochSuite struct{}
+
+var _ = Suite(&epochSuite{})
+
+func (s *epochSuite) TestParseEpoch(c *C) {
+	for _, t := range []struct {
+		s string
+		e snap.Epoch
+	}{
+		{"0", snap.Epoch{}},
+		{"1", snap.Epoch{Read: []uint32{1}, Write: []uint32{1}}},
+		{"1*", snap.Epoch{Read: []uint32{0, 1}, Write: []uint32{1}}},
+		{"400*", snap.Epoch{Read: []uint32{399, 400}, Write: []uint32{400}}},
+	} {
+		e, err := snap.ParseEpoch(t.s)
+		c.Assert(err, IsNil, Commentf(t.s))
+		c.Check(e, DeepEquals, t.e)
+		c.Check(e.String(), Equals, t.s)
+	}
+
+	for _, bad := range []string{"", "0*", "-1", "1**", "a", "4294967296"} {
+		_, err := snap.ParseEpoch(bad)
+		c.Check(err, ErrorMatches, `invalid snap epoch: ".*"`, Commentf(bad))
+	}
+}
+
+func (s *epochSuite) TestEpochYAML(c *C) {
+	for _, t := range []struct {
+		yaml string
+		e    snap.Epoch
+	}{
+		{`epoch: 0`, snap.Epoch{}},
+		{`epoch: 2`, snap.E("2")},
+		{`epoch: "2*"`, snap.E("2*")},
+		{`epoch: 2*`, snap.E("2*")},
+		{`epoch: {read: [1, 2, 3], write: [3]}`, snap.Epoch{Read: []uint32{1, 2, 3}, Write: []uint32{3}}},
+	} {
+		var v struct{ Epoch snap.Epoch }
+		c.Assert(yaml.Unmarshal([]byte(t.yaml), &v), IsNil, Commentf(t.yaml))
+		c.Check(v.Epoch, DeepEquals, t.e, Commentf(t.yaml))
+	}
+
+	for _, t := range []struct {
+		yaml string
+		err  string
+	}{
+		{`epoch: 0*`, `invalid snap epoch: "0\*"`},
+		{`epoch: {read: [1]}`, `invalid snap epoch: read and write sets cannot be empty`},
+		{`epoch: {read: [2, 1], write: [2]}`, `invalid snap epoch .*: sets must be in increasing order`},
+		{`epoch: {read: [1, 2], write: [3]}`, `invalid snap epoch .*: cannot write epoch 3 without reading it`},
+		{`epoch: {read: [-1], write: [1]}`, `(?s).*cannot unmarshal.*`},
+	} {
+		var v struct{ Epoch snap.Epoch }
+		c.Check(yaml.Unmarshal([]byte(t.yaml), &v), ErrorMatches, t.err, Commentf(t.yaml))
+	}
+}
+
+func (s *epochSuite) TestEpochJSON(c *C) {
+	for _, t := range []struct {
+		e    snap.Epoch
+		json string
+	}{
+		{snap.Epoch{}, `"0"`},
+		{snap.E("1*"), `"1*"`},
+		{snap.Epoch{Read: []uint32{1, 2, 3}, Write: []uint32{3}}, `{"read":[1,2,3],"write":[3]}`},
+	} {
+		data, err := json.Marshal(t.e)
+		c.Assert(err, IsNil)
+		c.Check(string(data), Equals, t.json)
+
+		var e snap.Epoch
+		c.Assert(json.Unmarshal(data, &e), IsNil)
+		c.Check(e, DeepEquals, t.e)
+	}
+
+	// the store may send the epoch as a number or not at all
+	var v struct {
+		Epoch snap.Epoch `json:"epoch"`
+	}
+	c.Assert(json.Unmarshal([]byte(`{"epoch": 2}`), &v), IsNil)
+	c.Check(v.Epoch, DeepEquals, snap.E("2"))
+	v.Epoch = snap.E("2")
+	c.Assert(json.Unmarshal([]byte(`{"epoch": ""}`), &v), IsNil)
+	c.Check(v.Epoch.IsZero(), Equals, true)
+	c.Check(json.Unmarshal([]byte(`{"epoch": "x"}`), &v), ErrorMatches, `invalid snap epoch: "x"`)
+}
+
+func (s *epochSuite) TestString(c *C) {
+	c.Check(snap.Epoch{Read: []uint32{0}, Write: []uint32{0}}.String(), Equals, "0")
+	c.Check(snap.Epoch{Read: []uint32{1, 3}, Write: []uint32{3}}.String(), Equals, "{read: [1, 3], write: [3]}")
+}
+
+func (s *epochSuite) TestCanRead(c *C) {
+	for _, t := range []struct {
+		new, cur snap.Epoch
+		canRead  bool
+	}{
+		{snap.E("0"), snap.E("0"), true},
+		{snap.E("1"), snap.E("0"), false},
+		{snap.E("1*"), snap.E("0"), true},
+		{snap.E("1*"), snap.E("1"), true},
+		{snap.E("2*"), snap.E("0"), false},
+		{snap.E("0"), snap.E("1"), false},
+		{snap.E("1"), snap.E("1*"), true},
+		{snap.Epoch{Read: []uint32{0, 1, 2}, Write: []uint32{2}}, snap.E("0"), true},
+		{snap.Epoch{Read: []uint32{0, 1, 2}, Write: []uint32{2}}, snap.E("3"), false},
+		{snap.E("1"), snap.Epoch{Read: []uint32{0, 1}, Write: []uint32{0, 1}}, true},
+	} {
+		c.Check(t.new.CanRead(t.cur), Equals, t.canRead, Commentf("%s can read %s", t.new, t.cur))
+	}
+}
+
+func (s *epochSuite) TestIsZero(c *C) {
+	c.Check(snap.Epoch{}.IsZero(), Equals, true)
+	c.Check(snap.E("0").IsZero(), Equals, true)
+	c.Check(snap.E("1").IsZero(), Equals, false)
+	c.Check(snap.E("1*").IsZero(), Equals, false)
+}
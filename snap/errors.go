@@ -54,6 +54,17 @@ func (e NoUpdateAvailableError) Error() string {
 	return fmt.Sprintf("snap %q has no updates available", e.Snap)
 }
 
+// NoCompatibleUpdateError is returned when the updates available for a snap
+// cannot read the data written by its installed revision.
+type NoCompatibleUpdateError struct {
+	Snap  string
+	Epoch Epoch
+}
+
+func (e NoCompatibleUpdateError) Error() string {
+	return fmt.Sprintf("snap %q has no compatible update available (none can read the data of epoch %s)", e.Snap, e.Epoch)
+}
+
 type NotSnapError struct {
 	Path string
 }
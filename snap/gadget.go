@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -33,6 +34,75 @@ type GadgetInfo struct {
 
 	// Default configuration for snaps (snap-id => key => value).
 	Defaults map[string]map[string]interface{} `yaml:"defaults,omitempty"`
+
+	// Connections to establish when the snaps involved are installed.
+	Connections []GadgetConnection `yaml:"connections"`
+}
+
+// GadgetConnectionPlug refers to a plug of the snap with the given id.
+type GadgetConnectionPlug struct {
+	SnapID string
+	Plug   string
+}
+
+// GadgetConnectionSlot refers to a slot of the snap with the given id.
+// The special id "system" refers to the core snap.
+type GadgetConnectionSlot struct {
+	SnapID string
+	Slot   string
+}
+
+// GadgetConnection is a connection the gadget asks to be established
+// between a plug and a slot.
+type GadgetConnection struct {
+	Plug GadgetConnectionPlug `yaml:"plug"`
+	Slot GadgetConnectionSlot `yaml:"slot"`
+}
+
+// SystemSnapID is the snap id used in gadget connections to refer to
+// the core snap.
+const SystemSnapID = "system"
+
+func parseSnapIDColonName(s string) (snapID, name string, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) == 2 {
+		snapID, name = parts[0], parts[1]
+	}
+	if snapID == "" || name == "" {
+		return "", "", fmt.Errorf(`expected "(<snap-id>|system):name" not %q`, s)
+	}
+	return snapID, name, nil
+}
+
+func (gcp *GadgetConnectionPlug) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	snapID, name, err := parseSnapIDColonName(s)
+	if err != nil {
+		return fmt.Errorf("in gadget connection plug: %v", err)
+	}
+	if snapID == SystemSnapID {
+		return fmt.Errorf("in gadget connection plug: the system snap cannot be the plug side of a connection")
+	}
+	gcp.SnapID = snapID
+	gcp.Plug = name
+	return nil
+}
+
+func (gcs *GadgetConnectionSlot) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	snapID, name, err := parseSnapIDColonName(s)
+	if err != nil {
+		return fmt.Errorf("in gadget connection slot: %v", err)
+	}
+	gcs.SnapID = snapID
+	gcs.Slot = name
+	return nil
 }
 
 type GadgetVolume struct {
@@ -42,11 +112,11 @@ type GadgetVolume struct {
 	Structure  []VolumeStructure `yaml:"structure"`
 }
 
-// TODO Offsets and sizes are strings to support unit suffixes.
-// Is that a good idea? *2^N or *10^N? We'll probably want a richer
-// type when we actually handle these.
+// Offsets and sizes are strings to support the M and G unit suffixes,
+// see ParseGadgetSize and LayoutVolume.
 
 type VolumeStructure struct {
+	Name        string          `yaml:"name"`
 	Label       string          `yaml:"label"`
 	Offset      string          `yaml:"offset"`
 	OffsetWrite string          `yaml:"offset-write"`
@@ -91,13 +161,23 @@ func ReadGadgetInfo(info *Info, classic bool) (*GadgetInfo, error) {
 		return nil, fmt.Errorf(errorFormat, err)
 	}
 
+	return InfoFromGadgetYaml(gmeta, classic)
+}
+
+// InfoFromGadgetYaml parses and validates the content of a gadget.yaml.
+// Like ReadGadgetInfo it does not check the layout of the volumes,
+// use LayoutVolume for that.
+func InfoFromGadgetYaml(gmeta []byte, classic bool) (*GadgetInfo, error) {
+	const errorFormat = "cannot read gadget snap details: %s"
+
+	var gi GadgetInfo
 	if err := yaml.Unmarshal(gmeta, &gi); err != nil {
 		return nil, fmt.Errorf(errorFormat, err)
 	}
 
 	if classic && len(gi.Volumes) == 0 {
 		// volumes can be left out on classic
-		// can still specify defaults though
+		// can still specify defaults and connections though
 		return &gi, nil
 	}
 
@@ -0,0 +1,395 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package snap
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SectorSize is the size of the sectors gadget volumes are
+	// addressed in.
+	SectorSize = 512
+	// MBRBootCodeSize is the space for boot code at the start of an
+	// MBR, before the disk signature and the partition table.
+	MBRBootCodeSize = 440
+	// GPTTableSectors is the number of sectors a GPT takes at each
+	// end of the volume (the header plus 128 entries of 128 bytes),
+	// not counting the protective MBR.
+	GPTTableSectors = 33
+
+	// DefaultStructureOffset is where the first structure of a
+	// volume is placed when no offset is given.
+	DefaultStructureOffset = 1 << 20
+
+	mbrMaxPartitions = 4
+	gptMaxPartitions = 128
+)
+
+// ParseGadgetSize parses a size or offset from gadget.yaml: a number
+// of bytes optionally followed by M or G, for mebibytes or gibibytes.
+func ParseGadgetSize(s string) (uint64, error) {
+	mult := uint64(1)
+	digits := s
+	switch {
+	case strings.HasSuffix(s, "M"):
+		mult, digits = 1<<20, s[:len(s)-1]
+	case strings.HasSuffix(s, "G"):
+		mult, digits = 1<<30, s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || n > (1<<64-1)/mult {
+		return 0, fmt.Errorf("cannot parse size %q: need a number of bytes optionally followed by M or G", s)
+	}
+	return n * mult, nil
+}
+
+// GadgetOffsetWrite is where, relative to the start of the structure
+// with the given name or of the volume, the start of a structure or
+// of some content is written in sectors, as a little-endian 32-bit
+// number.
+type GadgetOffsetWrite struct {
+	RelativeTo string
+	Offset     uint64
+}
+
+// ParseGadgetOffsetWrite parses an offset-write from gadget.yaml, of
+// the form [<name>+]<offset>. It returns nil for an empty one.
+func ParseGadgetOffsetWrite(s string) (*GadgetOffsetWrite, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ow GadgetOffsetWrite
+	offset := s
+	if i := strings.LastIndex(s, "+"); i >= 0 {
+		ow.RelativeTo, offset = s[:i], s[i+1:]
+		if ow.RelativeTo == "" {
+			return nil, fmt.Errorf("cannot parse offset-write %q: missing structure name before +", s)
+		}
+	}
+	n, err := ParseGadgetSize(offset)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse offset-write %q: %v", s, err)
+	}
+	ow.Offset = n
+	return &ow, nil
+}
+
+// LaidOutStructure is a structure of a gadget volume with its place
+// in the volume worked out.
+type LaidOutStructure struct {
+	*VolumeStructure
+	// Index of the structure in the volume definition.
+	Index int
+	// StartOffset is the offset of the structure from the start of
+	// the volume.
+	StartOffset uint64
+	Size        uint64
+	OffsetWrite *GadgetOffsetWrite
+	// Content holds the raw content of structures without a
+	// filesystem.
+	Content []LaidOutContent
+}
+
+func (ls *LaidOutStructure) String() string {
+	if ls.Name != "" {
+		return fmt.Sprintf("#%d (%q)", ls.Index, ls.Name)
+	}
+	if ls.Label != "" {
+		return fmt.Sprintf("#%d (%q)", ls.Index, ls.Label)
+	}
+	return fmt.Sprintf("#%d", ls.Index)
+}
+
+// IsPartition returns whether the structure gets an entry in the
+// partition table of the volume.
+func (ls *LaidOutStructure) IsPartition() bool {
+	return ls.Type != "mbr" && ls.Type != "bare"
+}
+
+// HasFilesystem returns whether a filesystem is to be created in the
+// structure.
+func (ls *LaidOutStructure) HasFilesystem() bool {
+	return ls.Filesystem != "" && ls.Filesystem != "none"
+}
+
+// LaidOutContent is raw content of a structure, placed in the volume.
+type LaidOutContent struct {
+	*VolumeContent
+	// StartOffset is the offset of the content from the start of the
+	// structure, or nil if it follows the previous content.
+	StartOffset *uint64
+	// Size is the space reserved for the content, or 0 for the size
+	// of the image.
+	Size        uint64
+	OffsetWrite *GadgetOffsetWrite
+}
+
+// PartitionTypes returns the MBR and GPT partition types in type,
+// which is either a two digit hex MBR type, a GPT type GUID, or both
+// separated by a comma.
+func PartitionTypes(typ string) (mbrType byte, gptType string, err error) {
+	var mbr string
+	switch parts := strings.Split(typ, ","); {
+	case len(parts) == 2:
+		mbr, gptType = parts[0], parts[1]
+	case validGUID.MatchString(typ):
+		gptType = typ
+	default:
+		mbr = typ
+	}
+	if mbr != "" {
+		n, err := strconv.ParseUint(mbr, 16, 8)
+		if err != nil || len(mbr) != 2 {
+			return 0, "", fmt.Errorf("invalid type %q: %q is not a two digit hex MBR partition type", typ, mbr)
+		}
+		if n == 0 {
+			return 0, "", fmt.Errorf("invalid type %q: MBR partition type cannot be zero", typ)
+		}
+		mbrType = byte(n)
+	}
+	if gptType != "" && !validGUID.MatchString(gptType) {
+		return 0, "", fmt.Errorf("invalid type %q: %q is not a GPT partition type GUID", typ, gptType)
+	}
+	return mbrType, gptType, nil
+}
+
+var validGUID = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// VolumeSchema returns the partitioning schema of the volume, gpt by
+// default.
+func (v *GadgetVolume) VolumeSchema() string {
+	if v.Schema == "" {
+		return "gpt"
+	}
+	return v.Schema
+}
+
+// LayoutVolume works out where the structures of the volume go and
+// checks that they fit together: sizes and offsets are valid, the
+// structures are sector aligned, do not overlap with each other nor
+// with the partition table, and their types and content make sense
+// for the partitioning schema.
+func LayoutVolume(v *GadgetVolume) ([]LaidOutStructure, error) {
+	schema := v.VolumeSchema()
+	var tableEnd uint64
+	var maxPartitions int
+	switch schema {
+	case "mbr":
+		tableEnd = SectorSize
+		maxPartitions = mbrMaxPartitions
+	case "gpt":
+		tableEnd = (1 + GPTTableSectors) * SectorSize
+		maxPartitions = gptMaxPartitions
+	default:
+		return nil, fmt.Errorf("invalid schema %q: must be either gpt or mbr", v.Schema)
+	}
+
+	structures := make([]LaidOutStructure, len(v.Structure))
+	names := make(map[string]bool)
+	labels := make(map[string]bool)
+	partitions := 0
+	var previousEnd uint64
+	for i := range v.Structure {
+		vs := &v.Structure[i]
+		ls := &structures[i]
+		ls.VolumeStructure = vs
+		ls.Index = i
+		if err := layoutStructure(ls, schema, previousEnd); err != nil {
+			return nil, fmt.Errorf("invalid structure %s: %v", ls, err)
+		}
+		if ls.Type != "mbr" && ls.StartOffset < tableEnd {
+			return nil, fmt.Errorf("invalid structure %s: overlaps with the %s partition table", ls, strings.ToUpper(schema))
+		}
+		if vs.Name != "" {
+			if names[vs.Name] {
+				return nil, fmt.Errorf("invalid structure %s: name is not unique", ls)
+			}
+			names[vs.Name] = true
+		}
+		if vs.Label != "" {
+			if labels[vs.Label] {
+				return nil, fmt.Errorf("invalid structure %s: label is not unique", ls)
+			}
+			labels[vs.Label] = true
+		}
+		if ls.IsPartition() {
+			partitions++
+		}
+		if ls.Type != "mbr" {
+			previousEnd = ls.StartOffset + ls.Size
+		}
+	}
+	if partitions > maxPartitions {
+		return nil, fmt.Errorf("too many partitions for the %s schema: %d > %d", schema, partitions, maxPartitions)
+	}
+
+	checkOffsetWrite := func(what string, ow *GadgetOffsetWrite) error {
+		if ow != nil && ow.RelativeTo != "" && !names[ow.RelativeTo] {
+			return fmt.Errorf("invalid %s: offset-write refers to unknown structure %q", what, ow.RelativeTo)
+		}
+		return nil
+	}
+	for i := range structures {
+		ls := &structures[i]
+		if err := checkOffsetWrite("structure "+ls.String(), ls.OffsetWrite); err != nil {
+			return nil, err
+		}
+		for j := range ls.Content {
+			if err := checkOffsetWrite(fmt.Sprintf("content #%d of structure %s", j, ls), ls.Content[j].OffsetWrite); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	byOffset := make([]*LaidOutStructure, len(structures))
+	for i := range structures {
+		byOffset[i] = &structures[i]
+	}
+	sort.Stable(byStartOffset(byOffset))
+	for i := 1; i < len(byOffset); i++ {
+		prev, cur := byOffset[i-1], byOffset[i]
+		if cur.StartOffset < prev.StartOffset+prev.Size {
+			return nil, fmt.Errorf("invalid structure %s: overlaps with structure %s", cur, prev)
+		}
+	}
+
+	return structures, nil
+}
+
+type byStartOffset []*LaidOutStructure
+
+func (b byStartOffset) Len() int           { return len(b) }
+func (b byStartOffset) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byStartOffset) Less(i, j int) bool { return b[i].StartOffset < b[j].StartOffset }
+
+func layoutStructure(ls *LaidOutStructure, schema string, previousEnd uint64) error {
+	vs := ls.VolumeStructure
+	if vs.Size == "" {
+		return fmt.Errorf("missing size")
+	}
+	size, err := ParseGadgetSize(vs.Size)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return fmt.Errorf("size cannot be zero")
+	}
+	ls.Size = size
+
+	switch {
+	case vs.Offset != "":
+		ls.StartOffset, err = ParseGadgetSize(vs.Offset)
+		if err != nil {
+			return err
+		}
+	case vs.Type == "mbr":
+		ls.StartOffset = 0
+	case previousEnd == 0:
+		ls.StartOffset = DefaultStructureOffset
+	default:
+		ls.StartOffset = previousEnd
+	}
+	if ls.OffsetWrite, err = ParseGadgetOffsetWrite(vs.OffsetWrite); err != nil {
+		return err
+	}
+
+	switch vs.Filesystem {
+	case "", "none", "ext4", "vfat":
+	default:
+		return fmt.Errorf("invalid filesystem %q: must be one of ext4, vfat or none", vs.Filesystem)
+	}
+
+	switch vs.Type {
+	case "":
+		return fmt.Errorf("missing type")
+	case "mbr":
+		if ls.Index != 0 || ls.StartOffset != 0 {
+			return fmt.Errorf("the mbr structure must be the first one, at offset 0")
+		}
+		if ls.Size > MBRBootCodeSize {
+			return fmt.Errorf("the mbr structure cannot be larger than %d bytes", MBRBootCodeSize)
+		}
+		if ls.HasFilesystem() {
+			return fmt.Errorf("the mbr structure cannot have a filesystem")
+		}
+	case "bare":
+		if ls.HasFilesystem() {
+			return fmt.Errorf("bare structures cannot have a filesystem")
+		}
+	default:
+		mbrType, gptType, err := PartitionTypes(vs.Type)
+		if err != nil {
+			return err
+		}
+		if schema == "mbr" && mbrType == 0 {
+			return fmt.Errorf("invalid type %q: need an MBR partition type for the mbr schema", vs.Type)
+		}
+		if schema == "gpt" && gptType == "" {
+			return fmt.Errorf("invalid type %q: need a GPT partition type GUID for the gpt schema", vs.Type)
+		}
+	}
+	if ls.StartOffset%SectorSize != 0 && vs.Type != "mbr" {
+		return fmt.Errorf("offset %d is not aligned to %d byte sectors", ls.StartOffset, SectorSize)
+	}
+
+	for i := range vs.Content {
+		vc := &vs.Content[i]
+		if ls.HasFilesystem() {
+			if vc.Source == "" || vc.Target == "" {
+				return fmt.Errorf("invalid content #%d: structures with a filesystem need source and target content", i)
+			}
+			if vc.Image != "" || vc.Offset != "" || vc.OffsetWrite != "" || vc.Size != "" {
+				return fmt.Errorf("invalid content #%d: structures with a filesystem cannot have raw image content", i)
+			}
+			continue
+		}
+		if vc.Image == "" {
+			return fmt.Errorf("invalid content #%d: structures without a filesystem need image content", i)
+		}
+		if vc.Source != "" || vc.Target != "" {
+			return fmt.Errorf("invalid content #%d: structures without a filesystem cannot have source and target content", i)
+		}
+		lc := LaidOutContent{VolumeContent: vc}
+		if vc.Offset != "" {
+			offset, err := ParseGadgetSize(vc.Offset)
+			if err != nil {
+				return fmt.Errorf("invalid content #%d: %v", i, err)
+			}
+			lc.StartOffset = &offset
+		}
+		if vc.Size != "" {
+			if lc.Size, err = ParseGadgetSize(vc.Size); err != nil {
+				return fmt.Errorf("invalid content #%d: %v", i, err)
+			}
+		}
+		if lc.OffsetWrite, err = ParseGadgetOffsetWrite(vc.OffsetWrite); err != nil {
+			return fmt.Errorf("invalid content #%d: %v", i, err)
+		}
+		if lc.StartOffset != nil && *lc.StartOffset+lc.Size > ls.Size {
+			return fmt.Errorf("invalid content #%d: does not fit in the structure", i)
+		}
+		ls.Content = append(ls.Content, lc)
+	}
+	return nil
+}
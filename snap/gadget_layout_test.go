@@ -0,0 +1,335 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package snap_test
+
+import (
+	"gopkg.in/yaml.v2"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/snap"
+)
+
+type gadgetLayoutSuite struct{}
+
+var _ = Suite(&gadgetLayoutSuite{})
+
+func (s *gadgetLayoutSuite) TestParseGadgetSize(c *C) {
+	for _, t := range []struct {
+		s    string
+		size uint64
+		err  string
+	}{
+		{"0", 0, ""},
+		{"440", 440, ""},
+		{"1M", 1 << 20, ""},
+		{"3G", 3 << 30, ""},
+		{"", 0, `cannot parse size "": .*`},
+		{"1K", 0, `cannot parse size "1K": need a number of bytes optionally followed by M or G`},
+		{"-1M", 0, `cannot parse size "-1M": .*`},
+		{"99999999999999G", 0, `cannot parse size "99999999999999G": .*`},
+	} {
+		size, err := snap.ParseGadgetSize(t.s)
+		if t.err == "" {
+			c.Check(err, IsNil, Commentf("%q", t.s))
+			c.Check(size, Equals, t.size, Commentf("%q", t.s))
+		} else {
+			c.Check(err, ErrorMatches, t.err, Commentf("%q", t.s))
+		}
+	}
+}
+
+func (s *gadgetLayoutSuite) TestParseGadgetOffsetWrite(c *C) {
+	ow, err := snap.ParseGadgetOffsetWrite("")
+	c.Assert(err, IsNil)
+	c.Check(ow, IsNil)
+
+	ow, err = snap.ParseGadgetOffsetWrite("92")
+	c.Assert(err, IsNil)
+	c.Check(ow, DeepEquals, &snap.GadgetOffsetWrite{Offset: 92})
+
+	ow, err = snap.ParseGadgetOffsetWrite("u-boot+1M")
+	c.Assert(err, IsNil)
+	c.Check(ow, DeepEquals, &snap.GadgetOffsetWrite{RelativeTo: "u-boot", Offset: 1 << 20})
+
+	_, err = snap.ParseGadgetOffsetWrite("+92")
+	c.Check(err, ErrorMatches, `cannot parse offset-write "\+92": missing structure name before \+`)
+	_, err = snap.ParseGadgetOffsetWrite("foo+bar")
+	c.Check(err, ErrorMatches, `cannot parse offset-write "foo\+bar": cannot parse size "bar": .*`)
+}
+
+func (s *gadgetLayoutSuite) TestPartitionTypes(c *C) {
+	mbr, gpt, err := snap.PartitionTypes("0C")
+	c.Assert(err, IsNil)
+	c.Check(mbr, Equals, byte(0x0C))
+	c.Check(gpt, Equals, "")
+
+	mbr, gpt, err = snap.PartitionTypes("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+	c.Assert(err, IsNil)
+	c.Check(mbr, Equals, byte(0))
+	c.Check(gpt, Equals, "C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+
+	mbr, gpt, err = snap.PartitionTypes("EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+	c.Assert(err, IsNil)
+	c.Check(mbr, Equals, byte(0xEF))
+	c.Check(gpt, Equals, "C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+
+	_, _, err = snap.PartitionTypes("ext4")
+	c.Check(err, ErrorMatches, `invalid type "ext4": "ext4" is not a two digit hex MBR partition type`)
+	_, _, err = snap.PartitionTypes("00")
+	c.Check(err, ErrorMatches, `invalid type "00": MBR partition type cannot be zero`)
+	_, _, err = snap.PartitionTypes("83,not-a-guid")
+	c.Check(err, ErrorMatches, `invalid type "83,not-a-guid": "not-a-guid" is not a GPT partition type GUID`)
+}
+
+func layoutFromYaml(c *C, volumeYaml string) ([]snap.LaidOutStructure, error) {
+	var v snap.GadgetVolume
+	c.Assert(yaml.Unmarshal([]byte(volumeYaml), &v), IsNil)
+	return snap.LayoutVolume(&v)
+}
+
+const pcVolumeYaml = `
+bootloader: grub
+structure:
+  - name: mbr
+    type: mbr
+    size: 440
+    content:
+      - image: pc-boot.img
+  - label: bios-boot
+    type: DA,21686148-6449-6E6F-744E-656564454649
+    size: 1M
+    content:
+      - image: pc-core.img
+  - label: system-boot
+    type: EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+    filesystem: vfat
+    size: 50M
+    content:
+      - source: grubx64.efi
+        target: EFI/boot/grubx64.efi
+`
+
+func (s *gadgetLayoutSuite) TestLayoutVolume(c *C) {
+	structures, err := layoutFromYaml(c, pcVolumeYaml)
+	c.Assert(err, IsNil)
+	c.Assert(structures, HasLen, 3)
+
+	c.Check(structures[0].StartOffset, Equals, uint64(0))
+	c.Check(structures[0].Size, Equals, uint64(440))
+	c.Check(structures[0].IsPartition(), Equals, false)
+	c.Assert(structures[0].Content, HasLen, 1)
+	c.Check(structures[0].Content[0].Image, Equals, "pc-boot.img")
+	c.Check(structures[0].Content[0].StartOffset, IsNil)
+
+	// the first structure after the mbr goes at 1M by default
+	c.Check(structures[1].StartOffset, Equals, uint64(1<<20))
+	c.Check(structures[1].Size, Equals, uint64(1<<20))
+	c.Check(structures[1].IsPartition(), Equals, true)
+	c.Check(structures[1].HasFilesystem(), Equals, false)
+
+	// then they follow each other
+	c.Check(structures[2].StartOffset, Equals, uint64(2<<20))
+	c.Check(structures[2].Size, Equals, uint64(50<<20))
+	c.Check(structures[2].HasFilesystem(), Equals, true)
+	c.Check(structures[2].Content, HasLen, 0)
+}
+
+func (s *gadgetLayoutSuite) TestLayoutVolumeOffsetWrite(c *C) {
+	structures, err := layoutFromYaml(c, `
+schema: mbr
+structure:
+  - name: spl
+    type: bare
+    offset: 32768
+    size: 1M
+    content:
+      - image: spl.img
+        offset-write: spl+72
+  - name: other
+    type: 83
+    size: 1M
+    offset-write: spl+80
+`)
+	c.Assert(err, IsNil)
+	c.Check(structures[0].Content[0].OffsetWrite, DeepEquals, &snap.GadgetOffsetWrite{RelativeTo: "spl", Offset: 72})
+	c.Check(structures[1].OffsetWrite, DeepEquals, &snap.GadgetOffsetWrite{RelativeTo: "spl", Offset: 80})
+	c.Check(structures[1].StartOffset, Equals, uint64(32768+1<<20))
+}
+
+func (s *gadgetLayoutSuite) TestLayoutVolumePC(c *C) {
+	structures, err := layoutFromYaml(c, `
+bootloader: grub
+structure:
+  - name: mbr
+    type: mbr
+    size: 440
+    content:
+      - image: pc-boot.img
+  - name: BIOS Boot
+    type: DA,21686148-6449-6E6F-744E-656564454649
+    size: 1M
+    offset: 1M
+    offset-write: mbr+92
+    content:
+      - image: pc-core.img
+  - name: EFI System
+    type: EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+    filesystem: vfat
+    size: 50M
+    content:
+      - source: grubx64.efi
+        target: EFI/boot/grubx64.efi
+`)
+	c.Assert(err, IsNil)
+	c.Assert(structures, HasLen, 3)
+	c.Check(structures[1].String(), Equals, `#1 ("BIOS Boot")`)
+	c.Check(structures[1].OffsetWrite, DeepEquals, &snap.GadgetOffsetWrite{RelativeTo: "mbr", Offset: 92})
+	c.Check(structures[2].StartOffset, Equals, uint64(2<<20))
+}
+
+func (s *gadgetLayoutSuite) TestLayoutVolumeErrors(c *C) {
+	for _, t := range []struct {
+		yaml string
+		err  string
+	}{
+		{"schema: dos", `invalid schema "dos": must be either gpt or mbr`},
+		{`
+structure:
+  - type: bare`, `invalid structure #0: missing size`},
+		{`
+structure:
+  - type: bare
+    size: 0`, `invalid structure #0: size cannot be zero`},
+		{`
+structure:
+  - size: 1M`, `invalid structure #0: missing type`},
+		{`
+structure:
+  - type: bare
+    size: 1M
+    filesystem: btrfs`, `invalid structure #0: invalid filesystem "btrfs": must be one of ext4, vfat or none`},
+		{`
+structure:
+  - type: bare
+    size: 1M
+    filesystem: ext4`, `invalid structure #0: bare structures cannot have a filesystem`},
+		{`
+structure:
+  - type: bare
+    size: 1M
+  - type: mbr
+    size: 440`, `invalid structure #1: the mbr structure must be the first one, at offset 0`},
+		{`
+structure:
+  - type: mbr
+    size: 446`, `invalid structure #0: the mbr structure cannot be larger than 440 bytes`},
+		{`
+schema: mbr
+structure:
+  - type: C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+    size: 1M`, `invalid structure #0: invalid type "C12A7328-F81F-11D2-BA4B-00A0C93EC93B": need an MBR partition type for the mbr schema`},
+		{`
+structure:
+  - label: foo
+    type: bare
+    offset: 1000000
+    size: 1M`, `invalid structure #0 \("foo"\): offset 1000000 is not aligned to 512 byte sectors`},
+		{`
+structure:
+  - type: bare
+    offset: 512
+    size: 1M`, `invalid structure #0: overlaps with the GPT partition table`},
+		{`
+schema: mbr
+structure:
+  - type: bare
+    offset: 0
+    size: 1M`, `invalid structure #0: overlaps with the MBR partition table`},
+		{`
+structure:
+  - label: a
+    type: bare
+    size: 2M
+  - label: b
+    type: bare
+    offset: 2M
+    size: 1M`, `invalid structure #1 \("b"\): overlaps with structure #0 \("a"\)`},
+		{`
+structure:
+  - label: a
+    type: bare
+    size: 1M
+  - label: a
+    type: bare
+    size: 1M`, `invalid structure #1 \("a"\): label is not unique`},
+		{`
+structure:
+  - name: a
+    type: bare
+    size: 1M
+  - name: a
+    type: bare
+    size: 1M`, `invalid structure #1 \("a"\): name is not unique`},
+		{`
+structure:
+  - label: foo
+    type: bare
+    size: 1M
+    offset-write: foo+92`, `invalid structure #0 \("foo"\): offset-write refers to unknown structure "foo"`},
+		{`
+schema: mbr
+structure:
+  - {type: 83, size: 1M}
+  - {type: 83, size: 1M}
+  - {type: 83, size: 1M}
+  - {type: 83, size: 1M}
+  - {type: 83, size: 1M}`, `too many partitions for the mbr schema: 5 > 4`},
+		{`
+structure:
+  - type: bare
+    size: 1M
+    content:
+      - source: foo
+        target: /`, `invalid structure #0: invalid content #0: structures without a filesystem need image content`},
+		{`
+structure:
+  - type: 0FC63DAF-8483-4772-8E79-3D69D8477DE4
+    filesystem: ext4
+    size: 1M
+    content:
+      - image: foo.img`, `invalid structure #0: invalid content #0: structures with a filesystem need source and target content`},
+		{`
+structure:
+  - type: bare
+    size: 1M
+    content:
+      - image: foo.img
+        offset: 1M
+        size: 1`, `invalid structure #0: invalid content #0: does not fit in the structure`},
+		{`
+structure:
+  - type: bare
+    size: 1M
+    offset-write: foo+92`, `invalid structure #0: offset-write refers to unknown structure "foo"`},
+	} {
+		_, err := layoutFromYaml(c, t.yaml)
+		c.Check(err, ErrorMatches, t.err, Commentf(t.yaml))
+	}
+}
@@ -176,6 +176,27 @@ func (s *gadgetYamlTestSuite) TestReadGadgetYamlValid(c *C) {
 	})
 }
 
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlDoesNotCheckLayout(c *C) {
+	info := snaptest.MockSnap(c, mockGadgetSnapYaml, mockGadgetSnapContents, &snap.SideInfo{Revision: snap.R(42)})
+	err := ioutil.WriteFile(filepath.Join(info.MountDir(), "meta", "gadget.yaml"), []byte(`
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - type: 83
+        size: 1M
+`), 0644)
+	c.Assert(err, IsNil)
+
+	// the layout is only checked when it is used, by prepare-image
+	// or when refreshing the gadget
+	gi, err := snap.ReadGadgetInfo(info, false)
+	c.Assert(err, IsNil)
+	vol := gi.Volumes["pc"]
+	_, err = snap.LayoutVolume(&vol)
+	c.Check(err, ErrorMatches, `invalid structure #0: invalid type "83": need a GPT partition type GUID for the gpt schema`)
+}
+
 func (s *gadgetYamlTestSuite) TestReadGadgetYamlEmptydBootloader(c *C) {
 	info := snaptest.MockSnap(c, mockGadgetSnapYaml, mockGadgetSnapContents, &snap.SideInfo{Revision: snap.R(42)})
 	mockGadgetYamlBroken := []byte(`
@@ -215,3 +236,46 @@ func (s *gadgetYamlTestSuite) TestReadGadgetYamlMissingBootloader(c *C) {
 	_, err = snap.ReadGadgetInfo(info, false)
 	c.Assert(err, ErrorMatches, "cannot read gadget snap details: bootloader not declared in any volume")
 }
+
+var mockClassicGadgetConnectionsYaml = []byte(`
+connections:
+  - plug: snapid1:plg1
+    slot: snapid2:slot
+  - plug: snapid3:process-control
+    slot: system:process-control
+`)
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlConnections(c *C) {
+	info := snaptest.MockSnap(c, mockGadgetSnapYaml, mockGadgetSnapContents, &snap.SideInfo{Revision: snap.R(42)})
+	err := ioutil.WriteFile(filepath.Join(info.MountDir(), "meta", "gadget.yaml"), mockClassicGadgetConnectionsYaml, 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := snap.ReadGadgetInfo(info, true)
+	c.Assert(err, IsNil)
+	c.Assert(ginfo, DeepEquals, &snap.GadgetInfo{
+		Connections: []snap.GadgetConnection{
+			{Plug: snap.GadgetConnectionPlug{SnapID: "snapid1", Plug: "plg1"}, Slot: snap.GadgetConnectionSlot{SnapID: "snapid2", Slot: "slot"}},
+			{Plug: snap.GadgetConnectionPlug{SnapID: "snapid3", Plug: "process-control"}, Slot: snap.GadgetConnectionSlot{SnapID: "system", Slot: "process-control"}},
+		},
+	})
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlInvalidConnections(c *C) {
+	info := snaptest.MockSnap(c, mockGadgetSnapYaml, mockGadgetSnapContents, &snap.SideInfo{Revision: snap.R(42)})
+
+	for _, t := range []struct {
+		conn string
+		err  string
+	}{
+		{"{plug: snapid1, slot: snapid2:slot}", `.*in gadget connection plug: expected "\(<snap-id>\|system\):name" not "snapid1"`},
+		{`{plug: snapid1:plug, slot: ":slot"}`, `.*in gadget connection slot: expected "\(<snap-id>\|system\):name" not ":slot"`},
+		{"{plug: system:plug, slot: snapid2:slot}", `.*in gadget connection plug: the system snap cannot be the plug side of a connection`},
+	} {
+		gadgetYaml := []byte("connections:\n  - " + t.conn + "\n")
+		err := ioutil.WriteFile(filepath.Join(info.MountDir(), "meta", "gadget.yaml"), gadgetYaml, 0644)
+		c.Assert(err, IsNil)
+
+		_, err = snap.ReadGadgetInfo(info, true)
+		c.Check(err, ErrorMatches, t.err, Commentf(t.conn))
+	}
+}
@@ -142,7 +142,7 @@ type Info struct {
 
 	LicenseAgreement string
 	LicenseVersion   string
-	Epoch            string
+	Epoch            Epoch
 	Confinement      ConfinementType
 	Apps             map[string]*AppInfo
 	Aliases          map[string]*AppInfo
@@ -177,7 +177,7 @@ type ChannelSnapInfo struct {
 	Confinement ConfinementType `json:"confinement"`
 	Version     string          `json:"version"`
 	Channel     string          `json:"channel"`
-	Epoch       string          `json:"epoch"`
+	Epoch       Epoch           `json:"epoch"`
 	Size        int64           `json:"size"`
 }
 
@@ -368,6 +368,10 @@ type AppInfo struct {
 	PostStopCommand string
 	RestartCond     systemd.RestartCondition
 
+	// Timer is the schedule, in timeutil.ParseSchedule format, on
+	// which a timer-activated service is run.
+	Timer string
+
 	// TODO: this should go away once we have more plumbing and can change
 	// things vs refactor
 	// https://github.com/snapcore/snapd/pull/794#discussion_r58688496
@@ -444,11 +448,22 @@ func (app *AppInfo) LauncherPostStopCommand() string {
 	return app.launcherCommand("--command=post-stop")
 }
 
+// IsService returns whether the app is a daemon.
+func (app *AppInfo) IsService() bool {
+	return app.Daemon != ""
+}
+
 // ServiceFile returns the systemd service file path for the daemon app.
 func (app *AppInfo) ServiceFile() string {
 	return filepath.Join(dirs.SnapServicesDir, app.SecurityTag()+".service")
 }
 
+// TimerFile returns the systemd timer unit file path for a timer-activated
+// daemon app.
+func (app *AppInfo) TimerFile() string {
+	return filepath.Join(dirs.SnapServicesDir, app.SecurityTag()+".timer")
+}
+
 // ServiceSocketFile returns the systemd socket file path for the daemon app.
 func (app *AppInfo) ServiceSocketFile() string {
 	return filepath.Join(dirs.SnapServicesDir, app.SecurityTag()+".socket")
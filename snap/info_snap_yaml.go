@@ -41,7 +41,7 @@ type snapYaml struct {
 	Summary          string                 `yaml:"summary"`
 	LicenseAgreement string                 `yaml:"license-agreement,omitempty"`
 	LicenseVersion   string                 `yaml:"license-version,omitempty"`
-	Epoch            string                 `yaml:"epoch,omitempty"`
+	Epoch            Epoch                  `yaml:"epoch,omitempty"`
 	Confinement      ConfinementType        `yaml:"confinement,omitempty"`
 	Environment      strutil.OrderedMap     `yaml:"environment,omitempty"`
 	Plugs            map[string]interface{} `yaml:"plugs,omitempty"`
@@ -56,6 +56,7 @@ type appYaml struct {
 	Command string `yaml:"command"`
 
 	Daemon string `yaml:"daemon"`
+	Timer  string `yaml:"timer,omitempty"`
 
 	StopCommand     string          `yaml:"stop-command,omitempty"`
 	ReloadCommand   string          `yaml:"reload-command,omitempty"`
@@ -134,10 +135,6 @@ func infoSkeletonFromSnapYaml(y snapYaml) *Info {
 	if y.Type != "" {
 		typ = y.Type
 	}
-	epoch := "0"
-	if y.Epoch != "" {
-		epoch = y.Epoch
-	}
 	confinement := StrictConfinement
 	if y.Confinement != "" {
 		confinement = y.Confinement
@@ -154,7 +151,7 @@ func infoSkeletonFromSnapYaml(y snapYaml) *Info {
 		OriginalSummary:     y.Summary,
 		LicenseAgreement:    y.LicenseAgreement,
 		LicenseVersion:      y.LicenseVersion,
-		Epoch:               epoch,
+		Epoch:               y.Epoch,
 		Confinement:         confinement,
 		Apps:                make(map[string]*AppInfo),
 		Aliases:             make(map[string]*AppInfo),
@@ -223,6 +220,7 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info) error {
 			Aliases:         yApp.Aliases,
 			Command:         yApp.Command,
 			Daemon:          yApp.Daemon,
+			Timer:           yApp.Timer,
 			StopTimeout:     yApp.StopTimeout,
 			StopCommand:     yApp.StopCommand,
 			ReloadCommand:   yApp.ReloadCommand,
@@ -1088,7 +1088,7 @@ slots:
 	c.Check(info.Name(), Equals, "foo")
 	c.Check(info.Version, Equals, "1.2")
 	c.Check(info.Type, Equals, snap.TypeApp)
-	c.Check(info.Epoch, Equals, "1*")
+	c.Check(info.Epoch.String(), Equals, "1*")
 	c.Check(info.Confinement, Equals, snap.DevModeConfinement)
 	c.Check(info.Summary(), Equals, "foo app")
 	c.Check(info.Description(), Equals, "Foo provides useful services\n")
@@ -1226,7 +1226,7 @@ version: 1.0
 `)
 	info, err := snap.InfoFromSnapYaml(y)
 	c.Assert(err, IsNil)
-	c.Assert(info.Epoch, Equals, "0")
+	c.Assert(info.Epoch.String(), Equals, "0")
 }
 
 func (s *YamlSuite) TestSnapYamlConfinementDefault(c *C) {
@@ -1351,6 +1351,21 @@ apps:
 	})
 }
 
+func (s *YamlSuite) TestDaemonTimer(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ svc:
+   command: svc1
+   daemon: oneshot
+   timer: mon,fri@9:00-11:00
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	c.Check(info.Apps["svc"].Daemon, Equals, "oneshot")
+	c.Check(info.Apps["svc"].Timer, Equals, "mon,fri@9:00-11:00")
+}
+
 func (s *YamlSuite) TestSnapYamlGlobalEnvironment(c *C) {
 	y := []byte(`
 name: foo
@@ -1426,3 +1441,24 @@ apps:
 	_, err := snap.InfoFromSnapYaml(y)
 	c.Assert(err, ErrorMatches, `cannot set "bar" as alias for both ("foo" and "bar"|"bar" and "foo")`)
 }
+
+func (s *YamlSuite) TestUnmarshalLifecycleHooks(c *C) {
+	// NOTE: yaml content cannot use tabs, indent the section with spaces.
+	info, err := snap.InfoFromSnapYaml([]byte(`
+name: snap
+hooks:
+    install:
+    remove:
+    pre-refresh:
+    post-refresh:
+`))
+	c.Assert(err, IsNil)
+	c.Check(info.Hooks, HasLen, 4)
+	for _, hookName := range []string{"install", "remove", "pre-refresh", "post-refresh"} {
+		hook, ok := info.Hooks[hookName]
+		c.Check(ok, Equals, true, Commentf(hookName))
+		if ok {
+			c.Check(hook.Name, Equals, hookName)
+		}
+	}
+}
@@ -22,6 +22,8 @@ package snap
 import (
 	"fmt"
 	"regexp"
+
+	"github.com/snapcore/snapd/timeutil"
 )
 
 // Regular expression describing correct identifiers.
@@ -69,12 +71,7 @@ func Validate(info *Info) error {
 		return err
 	}
 
-	epoch := info.Epoch
-	if epoch == "" {
-		return fmt.Errorf("snap epoch cannot be empty")
-	}
-	err = ValidateEpoch(epoch)
-	if err != nil {
+	if err := info.Epoch.Validate(); err != nil {
 		return err
 	}
 
@@ -145,6 +142,15 @@ func ValidateApp(app *AppInfo) error {
 		return fmt.Errorf("cannot have %q as app name - use letters, digits, and dash as separator", app.Name)
 	}
 
+	if app.Timer != "" {
+		if app.Daemon == "" {
+			return fmt.Errorf(`"timer" field is only valid for daemons`)
+		}
+		if _, err := timeutil.ParseSchedule(app.Timer); err != nil {
+			return fmt.Errorf(`"timer" field contains invalid value %q: %v`, app.Timer, err)
+		}
+	}
+
 	// Validate the rest of the app info
 	checks := map[string]string{
 		"command":           app.Command,
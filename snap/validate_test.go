@@ -170,6 +170,15 @@ func (s *ValidateSuite) TestAppDaemonValue(c *C) {
 	}
 }
 
+func (s *ValidateSuite) TestAppTimer(c *C) {
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "oneshot", Timer: "9:00-10:00"}), IsNil)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", Timer: "mon,wed-fri@9:00-11:00/sun@23:00-23:30"}), IsNil)
+
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Timer: "9:00-10:00"}), ErrorMatches, `"timer" field is only valid for daemons`)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "oneshot", Timer: "9:00"}), ErrorMatches, `"timer" field contains invalid value "9:00": cannot parse "9:00": not a valid interval`)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "oneshot", Timer: "someday@9:00-10:00"}), ErrorMatches, `"timer" field contains invalid value .*: cannot parse "someday", want "mon", "tue", etc`)
+}
+
 func (s *ValidateSuite) TestAppWhitelistError(c *C) {
 	err := ValidateApp(&AppInfo{Name: "foo", Command: "x\n"})
 	c.Assert(err, NotNil)
@@ -226,14 +235,22 @@ version: 1.0
 }
 
 func (s *ValidateSuite) TestIllegalSnapEpoch(c *C) {
-	info, err := InfoFromSnapYaml([]byte(`name: foo
+	_, err := InfoFromSnapYaml([]byte(`name: foo
 version: 1.0
 epoch: 0*
+`))
+	c.Check(err, ErrorMatches, `info failed to parse: invalid snap epoch: "0\*"`)
+}
+
+func (s *ValidateSuite) TestIllegalSnapEpochSets(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
 `))
 	c.Assert(err, IsNil)
+	info.Epoch = Epoch{Read: []uint32{1}, Write: []uint32{2}}
 
 	err = Validate(info)
-	c.Check(err, ErrorMatches, `invalid snap epoch: "0\*"`)
+	c.Check(err, ErrorMatches, `invalid snap epoch {read: \[1\], write: \[2\]}: cannot write epoch 2 without reading it`)
 }
 
 func (s *ValidateSuite) TestMissingSnapEpochIsOkay(c *C) {
@@ -26,6 +26,8 @@ import (
 	"strings"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
 )
 
 var (
@@ -92,3 +94,20 @@ type AssertionNotFoundError struct {
 func (e *AssertionNotFoundError) Error() string {
 	return fmt.Sprintf("%v not found", e.Ref)
 }
+
+// IncompatibleUpdatesError is returned by ListRefresh when some snaps only
+// have updates that cannot read the data of their installed revision.
+type IncompatibleUpdatesError struct {
+	Snaps []*snap.NoCompatibleUpdateError
+}
+
+func (e *IncompatibleUpdatesError) Error() string {
+	if len(e.Snaps) == 1 {
+		return e.Snaps[0].Error()
+	}
+	names := make([]string, len(e.Snaps))
+	for i, s := range e.Snaps {
+		names[i] = s.Snap
+	}
+	return fmt.Sprintf("snaps %s have no compatible update available", strutil.Quoted(names))
+}
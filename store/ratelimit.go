@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package store
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// rateLimiter caps the combined throughput of all the readers
+// obtained from it, so that concurrent downloads share one limit.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+var rateLimiterNow = time.Now
+
+// setRate sets the limit in bytes per second.
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+}
+
+// reserve accounts for n bytes and returns how long the caller must
+// wait before they are considered transferred.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	now := rateLimiterNow()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	return l.next.Sub(now)
+}
+
+// reader returns r throttled by the limiter.
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+	if wait := lr.l.reserve(n); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-lr.ctx.Done():
+			return n, lr.ctx.Err()
+		}
+	}
+	return n, err
+}
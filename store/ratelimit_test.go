@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package store
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type rateLimiterSuite struct{}
+
+var _ = Suite(&rateLimiterSuite{})
+
+func (s *rateLimiterSuite) TestReserve(c *C) {
+	now := time.Now()
+	rateLimiterNow = func() time.Time { return now }
+	defer func() { rateLimiterNow = time.Now }()
+
+	var l rateLimiter
+	// no limit
+	c.Check(l.reserve(1000), Equals, time.Duration(0))
+
+	l.setRate(1000)
+	c.Check(l.reserve(500), Equals, 500*time.Millisecond)
+	// the budget is shared by all the readers
+	c.Check(l.reserve(500), Equals, time.Second)
+
+	// time passing frees up the budget again
+	now = now.Add(3 * time.Second)
+	c.Check(l.reserve(250), Equals, 250*time.Millisecond)
+}
@@ -30,7 +30,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
@@ -74,7 +73,7 @@ func infoFromRemote(d snapDetails) *snap.Info {
 	info.Architectures = d.Architectures
 	info.Type = d.Type
 	info.Version = d.Version
-	info.Epoch = "0"
+	info.Epoch = d.Epoch
 	info.RealName = d.Name
 	info.SnapID = d.SnapID
 	info.Revision = snap.R(d.Revision)
@@ -169,6 +168,8 @@ type Store struct {
 
 	mu                sync.Mutex
 	suggestedCurrency string
+
+	downloadLimiter rateLimiter
 }
 
 func respToError(resp *http.Response, msg string) error {
@@ -201,12 +202,6 @@ func getStructFields(s interface{}) []string {
 
 // Deltas enabled by default on classic, but allow opting in or out on both classic and core.
 func useDeltas() bool {
-	// only xdelta3 is supported for now, so check the binary exists here
-	// TODO: have a per-format checker instead
-	if _, err := getXdelta3Cmd(); err != nil {
-		return false
-	}
-
 	deltasDefault := release.OnClassic
 	return osutil.GetenvBool("SNAPD_USE_DELTAS_EXPERIMENTAL", deltasDefault)
 }
@@ -718,6 +713,55 @@ func (s *Store) doRequest(ctx context.Context, client *http.Client, reqOptions *
 	return resp, err
 }
 
+// proxiedEndpoint associates a store endpoint with its path relative
+// to the base URL of a proxy store.
+type proxiedEndpoint struct {
+	uri  *url.URL
+	path string
+}
+
+// proxiedEndpoints returns the store endpoints that go through a
+// proxy store when one is set. Purchases and authentication always go
+// directly to the store.
+func (s *Store) proxiedEndpoints() []proxiedEndpoint {
+	return []proxiedEndpoint{
+		{s.searchURI, "api/v1/snaps/search"},
+		{s.detailsURI, "api/v1/snaps/details/"},
+		{s.bulkURI, "api/v1/snaps/metadata"},
+		{s.sectionsURI, "api/v1/snaps/sections"},
+		{s.assertionsURI, "v1/assertions/"},
+	}
+}
+
+// proxiedURL returns u rewritten to point to the proxy store if one is
+// set and u is for one of the proxied store endpoints, otherwise u.
+func (s *Store) proxiedURL(u *url.URL) (*url.URL, error) {
+	if s.authContext == nil {
+		return u, nil
+	}
+	proxyURL, err := s.authContext.ProxyStoreURL()
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return u, nil
+	}
+	base := *proxyURL
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	for _, ep := range s.proxiedEndpoints() {
+		if ep.uri == nil || ep.uri.Scheme != u.Scheme || ep.uri.Host != u.Host || !strings.HasPrefix(u.Path, ep.uri.Path) {
+			continue
+		}
+		proxied := base.ResolveReference(&url.URL{Path: ep.path + strings.TrimPrefix(u.Path, ep.uri.Path)})
+		proxied.RawQuery = u.RawQuery
+		return proxied, nil
+	}
+	return u, nil
+}
+
 // build a new http.Request with headers for the store
 func (s *Store) newRequest(reqOptions *requestOptions, user *auth.UserState) (*http.Request, error) {
 	var body io.Reader
@@ -725,7 +769,12 @@ func (s *Store) newRequest(reqOptions *requestOptions, user *auth.UserState) (*h
 		body = bytes.NewBuffer(reqOptions.Data)
 	}
 
-	req, err := http.NewRequest(reqOptions.Method, reqOptions.URL.String(), body)
+	reqURL, err := s.proxiedURL(reqOptions.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(reqOptions.Method, reqURL.String(), body)
 	if err != nil {
 		return nil, err
 	}
@@ -1146,7 +1195,7 @@ func (s *Store) Sections(user *auth.UserState) ([]string, error) {
 type RefreshCandidate struct {
 	SnapID   string
 	Revision snap.Revision
-	Epoch    string
+	Epoch    snap.Epoch
 	Block    []snap.Revision
 
 	// the desired channel
@@ -1155,11 +1204,11 @@ type RefreshCandidate struct {
 
 // the exact bits that we need to send to the store
 type currentSnapJson struct {
-	SnapID      string `json:"snap_id"`
-	Channel     string `json:"channel"`
-	Revision    int    `json:"revision,omitempty"`
-	Epoch       string `json:"epoch"`
-	Confinement string `json:"confinement"`
+	SnapID      string     `json:"snap_id"`
+	Channel     string     `json:"channel"`
+	Revision    int        `json:"revision,omitempty"`
+	Epoch       snap.Epoch `json:"epoch"`
+	Confinement string     `json:"confinement"`
 }
 
 type metadataWrapper struct {
@@ -1168,6 +1217,9 @@ type metadataWrapper struct {
 }
 
 // ListRefresh returns the available updates for a list of snap identified by fullname with channel.
+// Updates that cannot read the data of the installed revision are left out
+// and reported with an IncompatibleUpdatesError returned alongside the
+// compatible ones.
 func (s *Store) ListRefresh(installed []*RefreshCandidate, user *auth.UserState) (snaps []*snap.Info, err error) {
 
 	candidateMap := map[string]*RefreshCandidate{}
@@ -1211,9 +1263,10 @@ func (s *Store) ListRefresh(installed []*RefreshCandidate, user *auth.UserState)
 	}
 
 	if useDeltas() {
-		logger.Debugf("Deltas enabled. Adding header X-Ubuntu-Delta-Formats: %v", s.deltaFormat)
+		logger.Debugf("Deltas enabled. Adding header Snap-Accept-Delta-Format: %v", s.deltaFormat)
 		reqOptions.ExtraHeaders = map[string]string{
-			"X-Ubuntu-Delta-Formats": s.deltaFormat,
+			"Snap-Accept-Delta-Format": s.deltaFormat,
+			"X-Ubuntu-Delta-Formats":   s.deltaFormat,
 		}
 	}
 
@@ -1229,6 +1282,7 @@ func (s *Store) ListRefresh(installed []*RefreshCandidate, user *auth.UserState)
 	}
 
 	res := make([]*snap.Info, 0, len(updateData.Payload.Packages))
+	var incompatible []*snap.NoCompatibleUpdateError
 	for _, rsnap := range updateData.Payload.Packages {
 		rrev := snap.R(rsnap.Revision)
 		cand := candidateMap[rsnap.SnapID]
@@ -1242,11 +1296,21 @@ func (s *Store) ListRefresh(installed []*RefreshCandidate, user *auth.UserState)
 		if findRev(rrev, cand.Block) {
 			continue
 		}
+		// do not refresh to a revision that cannot read the data of
+		// the installed one
+		if !rsnap.Epoch.CanRead(cand.Epoch) {
+			logger.Debugf("cannot refresh snap %q to revision %s: epoch %s cannot read epoch %s", rsnap.Name, rrev, rsnap.Epoch, cand.Epoch)
+			incompatible = append(incompatible, &snap.NoCompatibleUpdateError{Snap: rsnap.Name, Epoch: cand.Epoch})
+			continue
+		}
 		res = append(res, infoFromRemote(rsnap))
 	}
 
 	s.extractSuggestedCurrency(resp)
 
+	if len(incompatible) > 0 {
+		return res, &IncompatibleUpdatesError{Snaps: incompatible}
+	}
 	return res, nil
 }
 
@@ -1269,11 +1333,20 @@ func (e HashError) Error() string {
 	return fmt.Sprintf("sha3-384 mismatch after patching %q: got %s but expected %s", e.name, e.sha3_384, e.targetSha3_384)
 }
 
+// DownloadOptions carries options for Download.
+type DownloadOptions struct {
+	// RateLimit, when positive, caps in bytes per second the combined
+	// bandwidth of all the rate limited downloads in progress.
+	RateLimit int64
+}
+
 // Download downloads the snap addressed by download info and returns its
 // filename.
 // The file is saved in temporary storage, and should be removed
 // after use to prevent the disk from running out of space.
-func (s *Store) Download(ctx context.Context, name string, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState) error {
+// What was downloaded before a failure is kept in targetPath.partial
+// and a later Download to the same path resumes from there.
+func (s *Store) Download(ctx context.Context, name string, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState, dlOpts *DownloadOptions) error {
 	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
 		return err
 	}
@@ -1281,7 +1354,7 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		logger.Debugf("Available deltas returned by store: %v", downloadInfo.Deltas)
 	}
 	if useDeltas() && len(downloadInfo.Deltas) == 1 {
-		err := s.downloadAndApplyDelta(name, targetPath, downloadInfo, pbar, user)
+		err := s.downloadAndApplyDelta(name, targetPath, downloadInfo, pbar, user, dlOpts)
 		if err == nil {
 			return nil
 		}
@@ -1302,8 +1375,8 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		if cerr := w.Close(); cerr != nil && err == nil {
 			err = cerr
 		}
-		if err != nil {
-			os.Remove(w.Name())
+		if err != nil && !resumable(partialPath, err) {
+			os.Remove(partialPath)
 		}
 	}()
 
@@ -1317,7 +1390,7 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		url = downloadInfo.DownloadURL
 	}
 
-	err = download(ctx, name, downloadInfo.Sha3_384, url, user, s, w, resume, pbar)
+	err = download(ctx, name, downloadInfo.Sha3_384, url, user, s, w, resume, pbar, dlOpts)
 	// If sha3 checksum is incorrect and it was a resumed download, retry from scratch.
 	// Note that we will retry this way only once.
 	if _, ok := err.(HashError); ok && resume > 0 {
@@ -1330,7 +1403,7 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		if err != nil {
 			return err
 		}
-		err = download(ctx, name, downloadInfo.Sha3_384, url, user, s, w, 0, pbar)
+		err = download(ctx, name, downloadInfo.Sha3_384, url, user, s, w, 0, pbar, dlOpts)
 	}
 
 	if err != nil {
@@ -1344,8 +1417,23 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 	return w.Sync()
 }
 
+// resumable returns whether the partial download at partialPath
+// that failed with err is worth resuming later.
+func resumable(partialPath string, err error) bool {
+	switch e := err.(type) {
+	case HashError:
+		return false
+	case *ErrDownload:
+		if e.Code == http.StatusRequestedRangeNotSatisfiable {
+			return false
+		}
+	}
+	fi, serr := os.Stat(partialPath)
+	return serr == nil && fi.Size() > 0
+}
+
 // download writes an http.Request showing a progress.Meter
-var download = func(ctx context.Context, name, sha3_384, downloadURL string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+var download = func(ctx context.Context, name, sha3_384, downloadURL string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 	storeURL, err := url.Parse(downloadURL)
 	if err != nil {
 		return err
@@ -1411,12 +1499,31 @@ var download = func(ctx context.Context, name, sha3_384, downloadURL string, use
 			return &ErrDownload{Code: resp.StatusCode, URL: resp.Request.URL}
 		}
 
+		if resume > 0 && resp.StatusCode == http.StatusOK {
+			// the server ignored the Range header, start over
+			if err := truncate(w); err != nil {
+				return err
+			}
+			h = crypto.SHA3_384.New()
+			resume = 0
+		}
+
 		if pbar == nil {
 			pbar = &progress.NullProgress{}
 		}
-		pbar.Start(name, float64(resp.ContentLength))
+		total := resp.ContentLength
+		if total >= 0 {
+			total += resume
+		}
+		pbar.Start(name, float64(total))
+		pbar.Set(float64(resume))
+		var body io.Reader = resp.Body
+		if dlOpts != nil && dlOpts.RateLimit > 0 {
+			s.downloadLimiter.setRate(dlOpts.RateLimit)
+			body = s.downloadLimiter.reader(ctx, body)
+		}
 		mw := io.MultiWriter(w, h, pbar)
-		_, finalErr = io.Copy(mw, resp.Body)
+		_, finalErr = io.Copy(mw, body)
 		pbar.Finished()
 		if finalErr != nil {
 			if shouldRetryError(attempt, finalErr) {
@@ -1444,8 +1551,22 @@ var download = func(ctx context.Context, name, sha3_384, downloadURL string, use
 	return finalErr
 }
 
+// truncate empties w and rewinds it.
+func truncate(w io.Seeker) error {
+	if _, err := w.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	t, ok := w.(interface {
+		Truncate(int64) error
+	})
+	if !ok {
+		return fmt.Errorf("internal error: cannot truncate %T", w)
+	}
+	return t.Truncate(0)
+}
+
 // downloadDelta downloads the delta for the preferred format, returning the path.
-func (s *Store) downloadDelta(deltaName string, downloadInfo *snap.DownloadInfo, w io.ReadWriteSeeker, pbar progress.Meter, user *auth.UserState) error {
+func (s *Store) downloadDelta(deltaName string, downloadInfo *snap.DownloadInfo, w io.ReadWriteSeeker, pbar progress.Meter, user *auth.UserState, dlOpts *DownloadOptions) error {
 
 	if len(downloadInfo.Deltas) != 1 {
 		return errors.New("store returned more than one download delta")
@@ -1467,17 +1588,36 @@ func (s *Store) downloadDelta(deltaName string, downloadInfo *snap.DownloadInfo,
 		url = deltaInfo.DownloadURL
 	}
 
-	return download(context.TODO(), deltaName, deltaInfo.Sha3_384, url, user, s, w, 0, pbar)
+	return download(context.TODO(), deltaName, deltaInfo.Sha3_384, url, user, s, w, 0, pbar, dlOpts)
 }
 
-func getXdelta3Cmd(args ...string) (*exec.Cmd, error) {
-	switch {
-	case osutil.ExecutableExists("xdelta3"):
-		return exec.Command("xdelta3", args...), nil
-	case osutil.FileExists(filepath.Join(dirs.SnapMountDir, "/core/current/usr/bin/xdelta3")):
-		return osutil.CommandFromCore("/usr/bin/xdelta3", args...)
+// decodeDelta writes to targetPath the result of applying the
+// xdelta3 (VCDIFF) delta at deltaPath to the snap at snapPath.
+func decodeDelta(snapPath, deltaPath, targetPath string) (err error) {
+	source, err := os.Open(snapPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	delta, err := os.Open(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer delta.Close()
+	target, err := os.OpenFile(targetPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := target.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if err := vcdiffDecode(source, delta, target); err != nil {
+		return err
 	}
-	return nil, fmt.Errorf("cannot find xdelta3 binary in PATH or core snap")
+	return target.Sync()
 }
 
 // applyDelta generates a target snap from a previously downloaded snap and a downloaded delta.
@@ -1493,16 +1633,10 @@ var applyDelta = func(name string, deltaPath string, deltaInfo *snap.DeltaInfo,
 		return fmt.Errorf("cannot apply unsupported delta format %q (only xdelta3 currently)", deltaInfo.Format)
 	}
 
-	partialTargetPath := targetPath + ".partial"
-
-	xdelta3Args := []string{"-d", "-s", snapPath, deltaPath, partialTargetPath}
-	cmd, err := getXdelta3Cmd(xdelta3Args...)
-	if err != nil {
-		return err
-	}
+	partialTargetPath := targetPath + ".delta-partial"
 
-	if err := cmd.Run(); err != nil {
-		if err := os.Remove(partialTargetPath); err != nil {
+	if err := decodeDelta(snapPath, deltaPath, partialTargetPath); err != nil {
+		if err := os.Remove(partialTargetPath); err != nil && !os.IsNotExist(err) {
 			logger.Noticef("failed to remove partial delta target %q: %s", partialTargetPath, err)
 		}
 		return err
@@ -1528,7 +1662,7 @@ var applyDelta = func(name string, deltaPath string, deltaInfo *snap.DeltaInfo,
 }
 
 // downloadAndApplyDelta downloads and then applies the delta to the current snap.
-func (s *Store) downloadAndApplyDelta(name, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState) error {
+func (s *Store) downloadAndApplyDelta(name, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState, dlOpts *DownloadOptions) error {
 	deltaInfo := &downloadInfo.Deltas[0]
 
 	deltaPath := fmt.Sprintf("%s.%s-%d-to-%d.partial", targetPath, deltaInfo.Format, deltaInfo.FromRevision, deltaInfo.ToRevision)
@@ -1545,7 +1679,7 @@ func (s *Store) downloadAndApplyDelta(name, targetPath string, downloadInfo *sna
 		os.Remove(deltaPath)
 	}()
 
-	err = s.downloadDelta(deltaName, downloadInfo, w, pbar, user)
+	err = s.downloadDelta(deltaName, downloadInfo, w, pbar, user, dlOpts)
 	if err != nil {
 		return err
 	}
@@ -62,8 +62,8 @@ type remoteRepoTestSuite struct {
 	localUser *auth.UserState
 	device    *auth.DeviceState
 
-	origDownloadFunc func(context.Context, string, string, string, *auth.UserState, *Store, io.ReadWriteSeeker, int64, progress.Meter) error
-	mockXDelta       *testutil.MockCmd
+	origDownloadFunc func(context.Context, string, string, string, *auth.UserState, *Store, io.ReadWriteSeeker, int64, progress.Meter, *DownloadOptions) error
+	origApplyDelta   func(string, string, *snap.DeltaInfo, string, string) error
 }
 
 func TestStore(t *testing.T) { TestingT(t) }
@@ -109,7 +109,8 @@ type testAuthContext struct {
 	device *auth.DeviceState
 	user   *auth.UserState
 
-	storeID string
+	storeID       string
+	proxyStoreURL *url.URL
 }
 
 func (ac *testAuthContext) Device() (*auth.DeviceState, error) {
@@ -142,6 +143,10 @@ func (ac *testAuthContext) StoreID(fallback string) (string, error) {
 	return fallback, nil
 }
 
+func (ac *testAuthContext) ProxyStoreURL() (*url.URL, error) {
+	return ac.proxyStoreURL, nil
+}
+
 func (ac *testAuthContext) DeviceSessionRequest(nonce string) ([]byte, []byte, error) {
 	serial, err := asserts.Decode([]byte(exSerial))
 	if err != nil {
@@ -218,6 +223,7 @@ func createTestDevice() *auth.DeviceState {
 func (t *remoteRepoTestSuite) SetUpTest(c *C) {
 	t.store = New(nil, nil)
 	t.origDownloadFunc = download
+	t.origApplyDelta = applyDelta
 	dirs.SetRootDir(c.MkDir())
 	c.Assert(os.MkdirAll(dirs.SnapMountDir, 0755), IsNil)
 
@@ -238,7 +244,6 @@ func (t *remoteRepoTestSuite) SetUpTest(c *C) {
 		Macaroon: "snapd-macaroon",
 	}
 	t.device = createTestDevice()
-	t.mockXDelta = testutil.MockCommand(c, "xdelta3", "")
 
 	MockDefaultRetryStrategy(&t.BaseTest, retry.LimitCount(5, retry.LimitTime(1*time.Second,
 		retry.Exponential{
@@ -250,7 +255,7 @@ func (t *remoteRepoTestSuite) SetUpTest(c *C) {
 
 func (t *remoteRepoTestSuite) TearDownTest(c *C) {
 	download = t.origDownloadFunc
-	t.mockXDelta.Restore()
+	applyDelta = t.origApplyDelta
 }
 
 func (t *remoteRepoTestSuite) TearDownSuite(c *C) {
@@ -277,7 +282,7 @@ func (t *remoteRepoTestSuite) expectedAuthorization(c *C, user *auth.UserState)
 
 func (t *remoteRepoTestSuite) TestDownloadOK(c *C) {
 
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		c.Check(url, Equals, "anon-url")
 		w.Write([]byte("I was downloaded"))
 		return nil
@@ -289,7 +294,7 @@ func (t *remoteRepoTestSuite) TestDownloadOK(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, IsNil)
 	defer os.Remove(path)
 
@@ -301,7 +306,7 @@ func (t *remoteRepoTestSuite) TestDownloadOK(c *C) {
 func (t *remoteRepoTestSuite) TestDownloadRangeRequest(c *C) {
 	partialContentStr := "partial content "
 
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		c.Check(resume, Equals, int64(len(partialContentStr)))
 		c.Check(url, Equals, "anon-url")
 		w.Write([]byte("was downloaded"))
@@ -318,7 +323,7 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequest(c *C) {
 	err := ioutil.WriteFile(targetFn+".partial", []byte(partialContentStr), 0644)
 	c.Assert(err, IsNil)
 
-	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil)
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, IsNil)
 
 	content, err := ioutil.ReadFile(targetFn)
@@ -330,7 +335,7 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequestRetryOnHashError(c *C) {
 	partialContentStr := "partial content "
 
 	n := 0
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		n++
 		if n == 1 {
 			// force sha3 error on first download
@@ -351,7 +356,7 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequestRetryOnHashError(c *C) {
 	err := ioutil.WriteFile(targetFn+".partial", []byte(partialContentStr), 0644)
 	c.Assert(err, IsNil)
 
-	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil)
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, IsNil)
 	c.Assert(n, Equals, 2)
 
@@ -364,7 +369,7 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequestFailOnHashError(c *C) {
 	partialContentStr := "partial content "
 
 	n := 0
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		n++
 		return HashError{"foo", "1234", "5678"}
 	}
@@ -379,14 +384,14 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequestFailOnHashError(c *C) {
 	err := ioutil.WriteFile(targetFn+".partial", []byte(partialContentStr), 0644)
 	c.Assert(err, IsNil)
 
-	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil)
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, NotNil)
 	c.Assert(err, ErrorMatches, `sha3-384 mismatch after patching "foo": got 1234 but expected 5678`)
 	c.Assert(n, Equals, 2)
 }
 
 func (t *remoteRepoTestSuite) TestAuthenticatedDownloadDoesNotUseAnonURL(c *C) {
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		// check user is pass and auth url is used
 		c.Check(user, Equals, t.user)
 		c.Check(url, Equals, "AUTH-URL")
@@ -401,7 +406,7 @@ func (t *remoteRepoTestSuite) TestAuthenticatedDownloadDoesNotUseAnonURL(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, t.user)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, t.user, nil)
 	c.Assert(err, IsNil)
 	defer os.Remove(path)
 
@@ -411,7 +416,7 @@ func (t *remoteRepoTestSuite) TestAuthenticatedDownloadDoesNotUseAnonURL(c *C) {
 }
 
 func (t *remoteRepoTestSuite) TestAuthenticatedDeviceDoesNotUseAnonURL(c *C) {
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		// check auth url is used
 		c.Check(url, Equals, "AUTH-URL")
 
@@ -429,7 +434,7 @@ func (t *remoteRepoTestSuite) TestAuthenticatedDeviceDoesNotUseAnonURL(c *C) {
 	c.Assert(repo, NotNil)
 
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := repo.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil)
+	err := repo.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, IsNil)
 	defer os.Remove(path)
 
@@ -439,7 +444,7 @@ func (t *remoteRepoTestSuite) TestAuthenticatedDeviceDoesNotUseAnonURL(c *C) {
 }
 
 func (t *remoteRepoTestSuite) TestLocalUserDownloadUsesAnonURL(c *C) {
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		c.Check(url, Equals, "anon-url")
 
 		w.Write([]byte("I was downloaded"))
@@ -452,7 +457,7 @@ func (t *remoteRepoTestSuite) TestLocalUserDownloadUsesAnonURL(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, t.localUser)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, t.localUser, nil)
 	c.Assert(err, IsNil)
 	defer os.Remove(path)
 
@@ -463,7 +468,7 @@ func (t *remoteRepoTestSuite) TestLocalUserDownloadUsesAnonURL(c *C) {
 
 func (t *remoteRepoTestSuite) TestDownloadFails(c *C) {
 	var tmpfile *os.File
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		tmpfile = w.(*os.File)
 		return fmt.Errorf("uh, it failed")
 	}
@@ -474,15 +479,110 @@ func (t *remoteRepoTestSuite) TestDownloadFails(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 	// simulate a failed download
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, ErrorMatches, "uh, it failed")
 	// ... and ensure that the tempfile is removed
 	c.Assert(osutil.FileExists(tmpfile.Name()), Equals, false)
 }
 
+func (t *remoteRepoTestSuite) TestDownloadFailsKeepsPartial(c *C) {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		c.Check(resume, Equals, int64(0))
+		w.Write([]byte("partial "))
+		return fmt.Errorf("connection reset")
+	}
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.AnonDownloadURL = "anon-url"
+	snap.DownloadURL = "AUTH-URL"
+
+	targetFn := filepath.Join(c.MkDir(), "foo_1.0_all.snap")
+	err := t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, ErrorMatches, "connection reset")
+	content, err := ioutil.ReadFile(targetFn + ".partial")
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "partial ")
+
+	// the next attempt resumes
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		c.Check(resume, Equals, int64(len("partial ")))
+		w.Write([]byte("content"))
+		return nil
+	}
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+	content, err = ioutil.ReadFile(targetFn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "partial content")
+	c.Check(osutil.FileExists(targetFn+".partial"), Equals, false)
+}
+
+func (t *remoteRepoTestSuite) TestDownloadFailedDeltaKeepsPartial(c *C) {
+	origUseDeltas := os.Getenv("SNAPD_USE_DELTAS_EXPERIMENTAL")
+	defer os.Setenv("SNAPD_USE_DELTAS_EXPERIMENTAL", origUseDeltas)
+	c.Assert(os.Setenv("SNAPD_USE_DELTAS_EXPERIMENTAL", "1"), IsNil)
+
+	c.Assert(os.MkdirAll(dirs.SnapBlobDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapBlobDir, "foo_24.snap"), []byte("current snap"), 0644), IsNil)
+
+	info := &snap.DownloadInfo{
+		AnonDownloadURL: "anon-url",
+		Deltas: []snap.DeltaInfo{
+			{AnonDownloadURL: "anon-delta-url", Format: "xdelta3", FromRevision: 24, ToRevision: 26},
+		},
+	}
+	targetFn := filepath.Join(c.MkDir(), "foo_26.snap")
+
+	// an interrupted full download
+	c.Assert(ioutil.WriteFile(targetFn+".partial", []byte("partial "), 0644), IsNil)
+
+	var urls []string
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		urls = append(urls, url)
+		if url == "anon-delta-url" {
+			// a broken delta that fails to apply
+			w.Write(testDelta[:len(testDelta)-2])
+			return nil
+		}
+		c.Check(resume, Equals, int64(len("partial ")))
+		w.Write([]byte("content"))
+		return nil
+	}
+
+	err := t.store.Download(context.TODO(), "foo", targetFn, info, nil, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(urls, DeepEquals, []string{"anon-delta-url", "anon-url"})
+	c.Check(t.logbuf.String(), Matches, `(?s).*Cannot download or apply deltas for foo: cannot decode delta: truncated.*`)
+
+	// the full download resumed where it was interrupted
+	content, err := ioutil.ReadFile(targetFn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "partial content")
+	c.Check(osutil.FileExists(targetFn+".partial"), Equals, false)
+	c.Check(osutil.FileExists(targetFn+".delta-partial"), Equals, false)
+}
+
+func (t *remoteRepoTestSuite) TestDownloadRangeNotSatisfiableDropsPartial(c *C) {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		return &ErrDownload{Code: http.StatusRequestedRangeNotSatisfiable}
+	}
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.AnonDownloadURL = "anon-url"
+
+	targetFn := filepath.Join(c.MkDir(), "foo_1.0_all.snap")
+	err := ioutil.WriteFile(targetFn+".partial", []byte("too much"), 0644)
+	c.Assert(err, IsNil)
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, NotNil)
+	c.Check(osutil.FileExists(targetFn+".partial"), Equals, false)
+}
+
 func (t *remoteRepoTestSuite) TestDownloadSyncFails(c *C) {
 	var tmpfile *os.File
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		tmpfile = w.(*os.File)
 		w.Write([]byte("sync will fail"))
 		err := tmpfile.Close()
@@ -497,7 +597,7 @@ func (t *remoteRepoTestSuite) TestDownloadSyncFails(c *C) {
 
 	// simulate a failed sync
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, ErrorMatches, "fsync:.*")
 	// ... and ensure that the tempfile is removed
 	c.Assert(osutil.FileExists(tmpfile.Name()), Equals, false)
@@ -516,7 +616,7 @@ func (t *remoteRepoTestSuite) TestActualDownload(c *C) {
 	var buf SillyBuffer
 	// keep tests happy
 	sha3 := ""
-	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil)
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 	c.Assert(err, IsNil)
 	c.Check(buf.String(), Equals, "response-data")
 	c.Check(n, Equals, 1)
@@ -545,7 +645,7 @@ func (t *remoteRepoTestSuite) TestDownloadCancellation(c *C) {
 	go func() {
 		sha3 := ""
 		var buf SillyBuffer
-		err := download(ctx, "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil)
+		err := download(ctx, "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 		result <- err.Error()
 		close(result)
 	}()
@@ -575,7 +675,7 @@ func (t *remoteRepoTestSuite) TestActualDownloadNonPurchased401(c *C) {
 
 	theStore := New(&Config{}, nil)
 	var buf bytes.Buffer
-	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, nopeSeeker{&buf}, -1, nil)
+	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, nopeSeeker{&buf}, -1, nil, nil)
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "Please buy foo before installing it.")
 	c.Check(n, Equals, 1)
@@ -592,7 +692,7 @@ func (t *remoteRepoTestSuite) TestActualDownload404(c *C) {
 
 	theStore := New(&Config{}, nil)
 	var buf SillyBuffer
-	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil)
+	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 	c.Assert(err, NotNil)
 	c.Assert(err, FitsTypeOf, &ErrDownload{})
 	c.Check(err.(*ErrDownload).Code, Equals, http.StatusNotFound)
@@ -610,7 +710,7 @@ func (t *remoteRepoTestSuite) TestActualDownload500(c *C) {
 
 	theStore := New(&Config{}, nil)
 	var buf SillyBuffer
-	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil)
+	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 	c.Assert(err, NotNil)
 	c.Assert(err, FitsTypeOf, &ErrDownload{})
 	c.Check(err.(*ErrDownload).Code, Equals, http.StatusInternalServerError)
@@ -634,7 +734,7 @@ func (t *remoteRepoTestSuite) TestActualDownload500Once(c *C) {
 	var buf SillyBuffer
 	// keep tests happy
 	sha3 := ""
-	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil)
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 	c.Assert(err, IsNil)
 	c.Check(buf.String(), Equals, "response-data")
 	c.Check(n, Equals, 2)
@@ -682,6 +782,16 @@ func (sb *SillyBuffer) Write(p []byte) (n int, err error) {
 	}
 	return n, nil
 }
+func (sb *SillyBuffer) Truncate(size int64) error {
+	if size < 0 || size > sb.end {
+		return fmt.Errorf("truncate out of bounds: %d", size)
+	}
+	sb.end = size
+	if sb.pos > sb.end {
+		sb.pos = sb.end
+	}
+	return nil
+}
 func (sb *SillyBuffer) String() string {
 	return string(sb.buf[0:sb.pos])
 }
@@ -690,6 +800,8 @@ func (t *remoteRepoTestSuite) TestActualDownloadResume(c *C) {
 	n := 0
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		n++
+		c.Check(r.Header.Get("Range"), Equals, "bytes=5-")
+		w.WriteHeader(http.StatusPartialContent)
 		io.WriteString(w, "data")
 	}))
 	c.Assert(mockServer, NotNil)
@@ -701,75 +813,118 @@ func (t *remoteRepoTestSuite) TestActualDownloadResume(c *C) {
 	h := crypto.SHA3_384.New()
 	h.Write([]byte("some data"))
 	sha3 := fmt.Sprintf("%x", h.Sum(nil))
-	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, buf, int64(len("some ")), nil)
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, buf, int64(len("some ")), nil, nil)
 	c.Check(err, IsNil)
 	c.Check(buf.String(), Equals, "some data")
 	c.Check(n, Equals, 1)
 }
 
+func (t *remoteRepoTestSuite) TestActualDownloadResumeRangeIgnored(c *C) {
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Header.Get("Range"), Equals, "bytes=5-")
+		io.WriteString(w, "some data")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	theStore := New(&Config{}, nil)
+	buf := NewSillyBufferString("some ")
+	h := crypto.SHA3_384.New()
+	h.Write([]byte("some data"))
+	sha3 := fmt.Sprintf("%x", h.Sum(nil))
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, buf, int64(len("some ")), nil, nil)
+	c.Check(err, IsNil)
+	c.Check(buf.String(), Equals, "some data")
+	c.Check(n, Equals, 1)
+}
+
+type recordingMeter struct {
+	progress.NullProgress
+	total   float64
+	current float64
+}
+
+func (m *recordingMeter) Start(label string, total float64) {
+	m.total = total
+	m.current = 0
+}
+
+func (m *recordingMeter) Set(current float64) {
+	m.current = current
+}
+
+func (m *recordingMeter) Write(p []byte) (int, error) {
+	m.current += float64(len(p))
+	return len(p), nil
+}
+
+func (t *remoteRepoTestSuite) TestActualDownloadResumeProgress(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4")
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, "data")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	theStore := New(&Config{}, nil)
+	buf := NewSillyBufferString("some ")
+	pbar := &recordingMeter{}
+	err := download(context.TODO(), "foo", "", mockServer.URL, nil, theStore, buf, int64(len("some ")), pbar, nil)
+	c.Check(err, IsNil)
+	// progress covers the whole snap, not just the resumed part
+	c.Check(pbar.total, Equals, float64(len("some data")))
+	c.Check(pbar.current, Equals, float64(len("some data")))
+}
+
+func (t *remoteRepoTestSuite) TestActualDownloadRateLimited(c *C) {
+	data := strings.Repeat("x", 1000)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, data)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	theStore := New(&Config{}, nil)
+	var buf SillyBuffer
+	start := time.Now()
+	err := download(context.TODO(), "foo", "", mockServer.URL, nil, theStore, &buf, 0, nil, &DownloadOptions{RateLimit: 10000})
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, data)
+	// 1000 bytes at 10000 bytes/s
+	c.Check(time.Since(start) >= 100*time.Millisecond, Equals, true)
+}
+
 func (t *remoteRepoTestSuite) TestUseDeltas(c *C) {
-	origPath := os.Getenv("PATH")
-	defer os.Setenv("PATH", origPath)
 	origUseDeltas := os.Getenv("SNAPD_USE_DELTAS_EXPERIMENTAL")
 	defer os.Setenv("SNAPD_USE_DELTAS_EXPERIMENTAL", origUseDeltas)
 	restore := release.MockOnClassic(false)
 	defer restore()
-	altPath := c.MkDir()
-	origSnapMountDir := dirs.SnapMountDir
-	defer func() { dirs.SnapMountDir = origSnapMountDir }()
-	dirs.SnapMountDir = c.MkDir()
-	exeInCorePath := filepath.Join(dirs.SnapMountDir, "/core/current/usr/bin/xdelta3")
-	os.MkdirAll(filepath.Dir(exeInCorePath), 0755)
+
+	// deltas are applied natively, so no xdelta3 binary is needed
+	origPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", origPath)
+	os.Setenv("PATH", c.MkDir())
 
 	scenarios := []struct {
-		env       string
-		classic   bool
-		exeInHost bool
-		exeInCore bool
+		env     string
+		classic bool
 
 		wantDelta bool
 	}{
-		{env: "", classic: false, exeInHost: false, exeInCore: false, wantDelta: false},
-		{env: "", classic: false, exeInHost: false, exeInCore: true, wantDelta: false},
-		{env: "", classic: false, exeInHost: true, exeInCore: false, wantDelta: false},
-		{env: "", classic: false, exeInHost: true, exeInCore: true, wantDelta: false},
-		{env: "", classic: true, exeInHost: false, exeInCore: false, wantDelta: false},
-		{env: "", classic: true, exeInHost: false, exeInCore: true, wantDelta: true},
-		{env: "", classic: true, exeInHost: true, exeInCore: false, wantDelta: true},
-		{env: "", classic: true, exeInHost: true, exeInCore: true, wantDelta: true},
-
-		{env: "0", classic: false, exeInHost: false, exeInCore: false, wantDelta: false},
-		{env: "0", classic: false, exeInHost: false, exeInCore: true, wantDelta: false},
-		{env: "0", classic: false, exeInHost: true, exeInCore: false, wantDelta: false},
-		{env: "0", classic: false, exeInHost: true, exeInCore: true, wantDelta: false},
-		{env: "0", classic: true, exeInHost: false, exeInCore: false, wantDelta: false},
-		{env: "0", classic: true, exeInHost: false, exeInCore: true, wantDelta: false},
-		{env: "0", classic: true, exeInHost: true, exeInCore: false, wantDelta: false},
-		{env: "0", classic: true, exeInHost: true, exeInCore: true, wantDelta: false},
-
-		{env: "1", classic: false, exeInHost: false, exeInCore: false, wantDelta: false},
-		{env: "1", classic: false, exeInHost: false, exeInCore: true, wantDelta: true},
-		{env: "1", classic: false, exeInHost: true, exeInCore: false, wantDelta: true},
-		{env: "1", classic: false, exeInHost: true, exeInCore: true, wantDelta: true},
-		{env: "1", classic: true, exeInHost: false, exeInCore: false, wantDelta: false},
-		{env: "1", classic: true, exeInHost: false, exeInCore: true, wantDelta: true},
-		{env: "1", classic: true, exeInHost: true, exeInCore: false, wantDelta: true},
-		{env: "1", classic: true, exeInHost: true, exeInCore: true, wantDelta: true},
+		{env: "", classic: false, wantDelta: false},
+		{env: "", classic: true, wantDelta: true},
+		{env: "0", classic: false, wantDelta: false},
+		{env: "0", classic: true, wantDelta: false},
+		{env: "1", classic: false, wantDelta: true},
+		{env: "1", classic: true, wantDelta: true},
 	}
 
 	for _, scenario := range scenarios {
-		if scenario.exeInCore {
-			osutil.CopyFile("/bin/true", exeInCorePath, 0)
-		} else {
-			os.Remove(exeInCorePath)
-		}
 		os.Setenv("SNAPD_USE_DELTAS_EXPERIMENTAL", scenario.env)
 		release.MockOnClassic(scenario.classic)
-		if scenario.exeInHost {
-			os.Setenv("PATH", origPath)
-		} else {
-			os.Setenv("PATH", altPath)
-		}
 
 		c.Check(useDeltas(), Equals, scenario.wantDelta, Commentf("%#v", scenario))
 	}
@@ -834,7 +989,7 @@ func (t *remoteRepoTestSuite) TestDownloadWithDelta(c *C) {
 
 	for _, testCase := range deltaTests {
 		downloadIndex := 0
-		download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+		download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 			if testCase.downloads[downloadIndex].error {
 				downloadIndex++
 				return errors.New("Bang")
@@ -852,7 +1007,7 @@ func (t *remoteRepoTestSuite) TestDownloadWithDelta(c *C) {
 		}
 
 		path := filepath.Join(c.MkDir(), "subdir", "downloaded-file")
-		err := t.store.Download(context.TODO(), "foo", path, &testCase.info, nil, nil)
+		err := t.store.Download(context.TODO(), "foo", path, &testCase.info, nil, nil, nil)
 
 		c.Assert(err, IsNil)
 		defer os.Remove(path)
@@ -966,7 +1121,7 @@ func (t *remoteRepoTestSuite) TestDownloadDelta(c *C) {
 
 	for _, testCase := range downloadDeltaTests {
 		repo.deltaFormat = testCase.format
-		download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+		download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 			expectedUser := t.user
 			if testCase.useLocalUser {
 				expectedUser = t.localUser
@@ -997,7 +1152,7 @@ func (t *remoteRepoTestSuite) TestDownloadDelta(c *C) {
 			authedUser = nil
 		}
 
-		err = repo.downloadDelta("snapname", &testCase.info, w, nil, authedUser)
+		err = repo.downloadDelta("snapname", &testCase.info, w, nil, authedUser, nil)
 
 		if testCase.expectError {
 			c.Assert(err, NotNil)
@@ -1031,6 +1186,42 @@ var applyDeltaTests = []struct {
 	error:           "cannot apply unsupported delta format \"nodelta\" (only xdelta3 currently)",
 }}
 
+// testDelta turns "current snap" into "new snap"
+var testDelta = vcdiffTestDelta(0, vcdiffTestWindow(vcdSource|vcdAdler32, []int{len("current snap"), 0}, "new snap",
+	[]byte("new"),
+	[]byte{
+		4,      // ADD 3
+		19 + 2, // COPY 5, self mode
+	},
+	[]byte{7},
+))
+
+func (t *remoteRepoTestSuite) TestApplyDeltaFailures(c *C) {
+	name := "foo"
+	deltaInfo := &snap.DeltaInfo{Format: "xdelta3", FromRevision: 24, ToRevision: 26}
+	currentSnapPath := filepath.Join(dirs.SnapBlobDir, "foo_24.snap")
+	targetSnapPath := filepath.Join(dirs.SnapBlobDir, "foo_26.snap")
+	deltaPath := filepath.Join(dirs.SnapBlobDir, "the.delta")
+	c.Assert(os.MkdirAll(dirs.SnapBlobDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(currentSnapPath, []byte("current snap"), 0644), IsNil)
+	defer os.Remove(currentSnapPath)
+	defer os.Remove(deltaPath)
+
+	// the reconstructed snap is checked against the expected hash
+	c.Assert(ioutil.WriteFile(deltaPath, testDelta, 0644), IsNil)
+	err := applyDelta(name, deltaPath, deltaInfo, targetSnapPath, "expected-sha3")
+	c.Check(err, FitsTypeOf, HashError{})
+	c.Check(osutil.FileExists(targetSnapPath+".delta-partial"), Equals, false)
+	c.Check(osutil.FileExists(targetSnapPath), Equals, false)
+
+	// a broken delta is not applied
+	c.Assert(ioutil.WriteFile(deltaPath, testDelta[:len(testDelta)-2], 0644), IsNil)
+	err = applyDelta(name, deltaPath, deltaInfo, targetSnapPath, "")
+	c.Check(err, ErrorMatches, "cannot decode delta: truncated")
+	c.Check(osutil.FileExists(targetSnapPath+".delta-partial"), Equals, false)
+	c.Check(osutil.FileExists(targetSnapPath), Equals, false)
+}
+
 func (t *remoteRepoTestSuite) TestApplyDelta(c *C) {
 	for _, testCase := range applyDeltaTests {
 		name := "foo"
@@ -1040,33 +1231,25 @@ func (t *remoteRepoTestSuite) TestApplyDelta(c *C) {
 		targetSnapPath := filepath.Join(dirs.SnapBlobDir, targetSnapName)
 		err := os.MkdirAll(filepath.Dir(currentSnapPath), 0755)
 		c.Assert(err, IsNil)
-		err = ioutil.WriteFile(currentSnapPath, nil, 0644)
+		err = ioutil.WriteFile(currentSnapPath, []byte("current snap"), 0644)
 		c.Assert(err, IsNil)
 		deltaPath := filepath.Join(dirs.SnapBlobDir, "the.delta")
-		err = ioutil.WriteFile(deltaPath, nil, 0644)
+		err = ioutil.WriteFile(deltaPath, testDelta, 0644)
 		c.Assert(err, IsNil)
-		// When testing a case where the call to the external
-		// xdelta3 is successful,
-		// simulate the resulting .partial.
-		if testCase.error == "" {
-			err = ioutil.WriteFile(targetSnapPath+".partial", nil, 0644)
-			c.Assert(err, IsNil)
-		}
 
 		err = applyDelta(name, deltaPath, &testCase.deltaInfo, targetSnapPath, "")
 
 		if testCase.error == "" {
 			c.Assert(err, IsNil)
-			c.Assert(t.mockXDelta.Calls(), DeepEquals, [][]string{
-				{"xdelta3", "-d", "-s", currentSnapPath, deltaPath, targetSnapPath + ".partial"},
-			})
-			c.Assert(osutil.FileExists(targetSnapPath+".partial"), Equals, false)
-			c.Assert(osutil.FileExists(targetSnapPath), Equals, true)
+			c.Assert(osutil.FileExists(targetSnapPath+".delta-partial"), Equals, false)
+			content, err := ioutil.ReadFile(targetSnapPath)
+			c.Assert(err, IsNil)
+			c.Check(string(content), Equals, "new snap")
 			c.Assert(os.Remove(targetSnapPath), IsNil)
 		} else {
 			c.Assert(err, NotNil)
 			c.Assert(err.Error()[0:len(testCase.error)], Equals, testCase.error)
-			c.Assert(osutil.FileExists(targetSnapPath+".partial"), Equals, false)
+			c.Assert(osutil.FileExists(targetSnapPath+".delta-partial"), Equals, false)
 			c.Assert(osutil.FileExists(targetSnapPath), Equals, false)
 		}
 		c.Assert(os.Remove(currentSnapPath), IsNil)
@@ -1573,7 +1756,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryDetails(c *C) {
 	c.Check(result.Contact, Equals, "mailto:snappy-devel@lists.ubuntu.com")
 
 	// Make sure the epoch (currently not sent by the store) defaults to "0"
-	c.Check(result.Epoch, Equals, "0")
+	c.Check(result.Epoch, DeepEquals, snap.E("0"))
 
 	c.Check(repo.SuggestedCurrency(), Equals, "GBP")
 
@@ -2474,7 +2657,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefresh(c *C) {
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(1),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 		},
 	}, nil)
 	c.Assert(err, IsNil)
@@ -2487,6 +2670,50 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefresh(c *C) {
 	c.Assert(results[0].Deltas, HasLen, 0)
 }
 
+func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshIncompatibleEpoch(c *C) {
+	epoch := `"2"`
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the store sends the epoch of the update along
+		io.WriteString(w, strings.Replace(MockUpdatesJSON, `"revision": 26,`, `"revision": 26, "epoch": `+epoch+`,`, 1))
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	bulkURI, err := url.Parse(mockServer.URL + "/updates/")
+	c.Assert(err, IsNil)
+	cfg := Config{
+		BulkURI: bulkURI,
+	}
+	authContext := &testAuthContext{c: c, device: t.device}
+	repo := New(&cfg, authContext)
+	c.Assert(repo, NotNil)
+
+	cands := []*RefreshCandidate{
+		{
+			SnapID:   helloWorldSnapID,
+			Channel:  "stable",
+			Revision: snap.R(1),
+			Epoch:    snap.E("0"),
+		},
+	}
+	results, err := repo.ListRefresh(cands, nil)
+	c.Assert(err, FitsTypeOf, &IncompatibleUpdatesError{})
+	c.Check(err, ErrorMatches, `snap "hello-world" has no compatible update available \(none can read the data of epoch 0\)`)
+	c.Check(err.(*IncompatibleUpdatesError).Snaps, DeepEquals, []*snap.NoCompatibleUpdateError{
+		{Snap: "hello-world", Epoch: snap.E("0")},
+	})
+	c.Check(results, HasLen, 0)
+
+	// an update that can migrate the data is fine
+	epoch = `"1*"`
+	cands[0].Epoch = snap.E("1")
+	results, err = repo.ListRefresh(cands, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Epoch, DeepEquals, snap.E("1*"))
+}
+
 func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshUnauthorised(c *C) {
 	n := 0
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -2515,7 +2742,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshUnauthorised(c
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(24),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 		},
 	}, nil)
 	c.Assert(n, Equals, 1)
@@ -2545,7 +2772,7 @@ func (t *remoteRepoTestSuite) TestListRefresh500(c *C) {
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(24),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 		},
 	}, nil)
 	c.Assert(err, ErrorMatches, `cannot query the store for updates: got unexpected HTTP status code 500 via POST to "http://.*?/updates/"`)
@@ -2577,7 +2804,7 @@ func (t *remoteRepoTestSuite) TestListRefresh500DurationExceeded(c *C) {
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(24),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 		},
 	}, nil)
 	c.Assert(err, ErrorMatches, `cannot query the store for updates: got unexpected HTTP status code 500 via POST to "http://.*?/updates/"`)
@@ -2624,7 +2851,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshSkipCurrent(c
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(26),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 		},
 	}, nil)
 	c.Assert(err, IsNil)
@@ -2672,7 +2899,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshSkipBlocked(c
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(25),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 			Block:    []snap.Revision{snap.R(26)},
 		},
 	}, nil)
@@ -2780,7 +3007,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryDefaultsDeltasOnClassicOn
 				SnapID:   helloWorldSnapID,
 				Channel:  "stable",
 				Revision: snap.R(24),
-				Epoch:    "0",
+				Epoch:    snap.E("0"),
 			},
 		}, nil)
 	}
@@ -2793,6 +3020,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshWithDeltas(c *
 
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		c.Check(r.Header.Get("X-Ubuntu-Delta-Formats"), Equals, `xdelta3`)
+		c.Check(r.Header.Get("Snap-Accept-Delta-Format"), Equals, `xdelta3`)
 		jsonReq, err := ioutil.ReadAll(r.Body)
 		c.Assert(err, IsNil)
 		var resp struct {
@@ -2833,7 +3061,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshWithDeltas(c *
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(24),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 		},
 	}, nil)
 	c.Assert(err, IsNil)
@@ -2907,7 +3135,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshWithoutDeltas(
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(24),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 		},
 	}, nil)
 	c.Assert(err, IsNil)
@@ -2954,7 +3182,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryUpdateNotSendLocalRevs(c
 			SnapID:   helloWorldSnapID,
 			Channel:  "stable",
 			Revision: snap.R(-2),
-			Epoch:    "0",
+			Epoch:    snap.E("0"),
 		},
 	}, nil)
 	c.Assert(err, IsNil)
@@ -3089,6 +3317,63 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryAssertion(c *C) {
 	c.Check(a.Type(), Equals, asserts.SnapDeclarationType)
 }
 
+func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryViaProxyStore(c *C) {
+	restore := asserts.MockMaxSupportedFormat(asserts.SnapDeclarationType, 88)
+	defer restore()
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// check device authorization is passed along to the proxy
+		c.Check(r.Header.Get("X-Device-Authorization"), Equals, `Macaroon root="device-macaroon"`)
+
+		switch n {
+		case 0:
+			c.Check(r.URL.Path, Equals, "/proxy/api/v1/snaps/details/hello-world")
+			c.Check(r.URL.Query().Get("channel"), Equals, "edge")
+			io.WriteString(w, MockDetailsJSON)
+		case 1:
+			c.Check(r.URL.Path, Equals, "/proxy/v1/assertions/snap-declaration/16/snapidfoo")
+			c.Check(r.URL.Query().Get("max-format"), Equals, "88")
+			io.WriteString(w, testAssertion)
+		default:
+			c.Fatalf("expected 2 requests, now on %d", n+1)
+		}
+		n++
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	// the main store is not contacted at all
+	detailsURI, err := url.Parse("https://search.apps.example.com/api/v1/snaps/details/")
+	c.Assert(err, IsNil)
+	assertionsURI, err := url.Parse("https://assertions.example.com/v1/assertions/")
+	c.Assert(err, IsNil)
+	proxyStoreURL, err := url.Parse(mockServer.URL + "/proxy")
+	c.Assert(err, IsNil)
+
+	cfg := Config{
+		DetailsURI:    detailsURI,
+		AssertionsURI: assertionsURI,
+	}
+	authContext := &testAuthContext{c: c, device: t.device, proxyStoreURL: proxyStoreURL}
+	repo := New(&cfg, authContext)
+
+	spec := SnapSpec{
+		Name:     "hello-world",
+		Channel:  "edge",
+		Revision: snap.R(0),
+	}
+	result, err := repo.SnapInfo(spec, nil)
+	c.Assert(err, IsNil)
+	c.Check(result.Name(), Equals, "hello-world")
+
+	a, err := repo.Assertion(asserts.SnapDeclarationType, []string{"16", "snapidfoo"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(a.Type(), Equals, asserts.SnapDeclarationType)
+
+	c.Check(n, Equals, 2)
+}
+
 func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryAssertionNotFound(c *C) {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		c.Check(r.Header.Get("Accept"), Equals, "application/x.ubuntu.assertion")
@@ -0,0 +1,414 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"io/ioutil"
+)
+
+// This is a decoder for the VCDIFF generic differencing format (RFC
+// 3284) as produced by xdelta3, including its extensions for
+// application headers and adler32 checksums of the target windows.
+// Secondary compression and custom code tables are not supported;
+// xdelta3 only uses them when asked to.
+
+var vcdiffMagic = []byte{0xD6, 0xC3, 0xC4}
+
+const (
+	// header indicator bits
+	vcdDecompress = 1 << 0
+	vcdCodeTable  = 1 << 1
+	vcdAppHeader  = 1 << 2 // xdelta3
+
+	// window indicator bits
+	vcdSource  = 1 << 0
+	vcdTarget  = 1 << 1
+	vcdAdler32 = 1 << 2 // xdelta3
+
+	// instruction types
+	vcdNoop = 0
+	vcdAdd  = 1
+	vcdRun  = 2
+	vcdCopy = 3
+
+	// address cache sizes of the default code table
+	vcdNearSize = 4
+	vcdSameSize = 3
+
+	// sanity cap on the sizes found in a delta
+	vcdMaxWindow = 1 << 30
+)
+
+type vcdiffInst struct {
+	typ  byte
+	size byte
+	mode byte
+}
+
+// vcdiffCodeTable is the default instruction code table of RFC 3284
+// section 5.6.
+var vcdiffCodeTable [256][2]vcdiffInst
+
+func init() {
+	t := &vcdiffCodeTable
+	t[0][0] = vcdiffInst{typ: vcdRun}
+	i := 1
+	for size := 0; size <= 17; size++ {
+		t[i][0] = vcdiffInst{typ: vcdAdd, size: byte(size)}
+		i++
+	}
+	for mode := 0; mode <= 8; mode++ {
+		t[i][0] = vcdiffInst{typ: vcdCopy, mode: byte(mode)}
+		i++
+		for size := 4; size <= 18; size++ {
+			t[i][0] = vcdiffInst{typ: vcdCopy, size: byte(size), mode: byte(mode)}
+			i++
+		}
+	}
+	for mode := 0; mode <= 5; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			for copySize := 4; copySize <= 6; copySize++ {
+				t[i][0] = vcdiffInst{typ: vcdAdd, size: byte(addSize)}
+				t[i][1] = vcdiffInst{typ: vcdCopy, size: byte(copySize), mode: byte(mode)}
+				i++
+			}
+		}
+	}
+	for mode := 6; mode <= 8; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			t[i][0] = vcdiffInst{typ: vcdAdd, size: byte(addSize)}
+			t[i][1] = vcdiffInst{typ: vcdCopy, size: 4, mode: byte(mode)}
+			i++
+		}
+	}
+	for mode := 0; mode <= 8; mode++ {
+		t[i][0] = vcdiffInst{typ: vcdCopy, size: 4, mode: byte(mode)}
+		t[i][1] = vcdiffInst{typ: vcdAdd, size: 1}
+		i++
+	}
+	if i != 256 {
+		panic("internal error: the VCDIFF code table is not complete")
+	}
+}
+
+var errVCDIFFTruncated = errors.New("cannot decode delta: truncated")
+
+// vcdiffSection is one of the sections of a delta window.
+type vcdiffSection struct {
+	name string
+	buf  []byte
+}
+
+func (s *vcdiffSection) ReadByte() (byte, error) {
+	if len(s.buf) == 0 {
+		return 0, fmt.Errorf("cannot decode delta: %s section exhausted", s.name)
+	}
+	b := s.buf[0]
+	s.buf = s.buf[1:]
+	return b, nil
+}
+
+func (s *vcdiffSection) next(n uint64) ([]byte, error) {
+	if n > uint64(len(s.buf)) {
+		return nil, fmt.Errorf("cannot decode delta: %s section exhausted", s.name)
+	}
+	b := s.buf[:n]
+	s.buf = s.buf[n:]
+	return b, nil
+}
+
+// vcdiffReadInt reads a VCDIFF integer: base 128, most significant
+// digit first, with the high bit set on all but the last byte.
+func vcdiffReadInt(r io.ByteReader) (uint64, error) {
+	var n uint64
+	for i := 0; i < 10; i++ {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return 0, errVCDIFFTruncated
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n > (1<<64-1)>>7 {
+			break
+		}
+		n = n<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errors.New("cannot decode delta: integer overflow")
+}
+
+// vcdiffAddrCache implements the address caches of RFC 3284 section 5.1.
+type vcdiffAddrCache struct {
+	near     [vcdNearSize]uint64
+	nextSlot int
+	same     [vcdSameSize * 256]uint64
+}
+
+func (c *vcdiffAddrCache) decode(addrs *vcdiffSection, here uint64, mode byte) (uint64, error) {
+	var addr uint64
+	switch {
+	case mode == 0:
+		n, err := vcdiffReadInt(addrs)
+		if err != nil {
+			return 0, err
+		}
+		addr = n
+	case mode == 1:
+		n, err := vcdiffReadInt(addrs)
+		if err != nil {
+			return 0, err
+		}
+		if n > here {
+			return 0, fmt.Errorf("cannot decode delta: invalid address")
+		}
+		addr = here - n
+	case mode < 2+vcdNearSize:
+		n, err := vcdiffReadInt(addrs)
+		if err != nil {
+			return 0, err
+		}
+		addr = c.near[mode-2] + n
+	case mode < 2+vcdNearSize+vcdSameSize:
+		b, err := addrs.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		addr = c.same[int(mode-2-vcdNearSize)*256+int(b)]
+	default:
+		return 0, fmt.Errorf("cannot decode delta: invalid address mode %d", mode)
+	}
+	if addr >= here {
+		return 0, fmt.Errorf("cannot decode delta: invalid address")
+	}
+	c.near[c.nextSlot] = addr
+	c.nextSlot = (c.nextSlot + 1) % vcdNearSize
+	c.same[addr%(vcdSameSize*256)] = addr
+	return addr, nil
+}
+
+// vcdiffTarget is where the delta is decoded to; it is read back
+// from for windows that copy from earlier target data.
+type vcdiffTarget interface {
+	io.Writer
+	io.ReaderAt
+}
+
+// vcdiffDecode applies the VCDIFF delta read from delta to source,
+// writing the result to target.
+func vcdiffDecode(source io.ReaderAt, delta io.Reader, target vcdiffTarget) error {
+	r := bufio.NewReader(delta)
+
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return errVCDIFFTruncated
+	}
+	if header[0] != vcdiffMagic[0] || header[1] != vcdiffMagic[1] || header[2] != vcdiffMagic[2] {
+		return errors.New("cannot decode delta: not in VCDIFF format")
+	}
+	if header[3] != 0 {
+		return fmt.Errorf("cannot decode delta: unsupported VCDIFF version %d", header[3])
+	}
+	hdrIndicator := header[4]
+	if hdrIndicator&vcdDecompress != 0 {
+		return errors.New("cannot decode delta: secondary compression is not supported")
+	}
+	if hdrIndicator&vcdCodeTable != 0 {
+		return errors.New("cannot decode delta: custom code tables are not supported")
+	}
+	if hdrIndicator&vcdAppHeader != 0 {
+		n, err := vcdiffReadInt(r)
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(n)); err != nil {
+			return errVCDIFFTruncated
+		}
+	}
+
+	var written uint64
+	for {
+		winIndicator, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		n, err := vcdiffDecodeWindow(winIndicator, r, source, target, written)
+		if err != nil {
+			return err
+		}
+		written += n
+	}
+}
+
+func vcdiffDecodeWindow(winIndicator byte, r *bufio.Reader, source io.ReaderAt, target vcdiffTarget, written uint64) (uint64, error) {
+	if winIndicator&^(vcdSource|vcdTarget|vcdAdler32) != 0 || winIndicator&(vcdSource|vcdTarget) == vcdSource|vcdTarget {
+		return 0, fmt.Errorf("cannot decode delta: invalid window indicator %#x", winIndicator)
+	}
+
+	var segment []byte
+	if winIndicator&(vcdSource|vcdTarget) != 0 {
+		segLen, err := vcdiffReadInt(r)
+		if err != nil {
+			return 0, err
+		}
+		segPos, err := vcdiffReadInt(r)
+		if err != nil {
+			return 0, err
+		}
+		if segLen > vcdMaxWindow || segPos > 1<<62 {
+			return 0, errors.New("cannot decode delta: source segment too large")
+		}
+		from, what := source, "source"
+		if winIndicator&vcdTarget != 0 {
+			if segPos+segLen > written {
+				return 0, errors.New("cannot decode delta: target segment out of range")
+			}
+			from, what = target, "target"
+		}
+		segment = make([]byte, segLen)
+		if _, err := from.ReadAt(segment, int64(segPos)); err != nil {
+			return 0, fmt.Errorf("cannot decode delta: cannot read %s segment: %v", what, err)
+		}
+	}
+
+	encLen, err := vcdiffReadInt(r)
+	if err != nil {
+		return 0, err
+	}
+	if encLen > 3*vcdMaxWindow {
+		return 0, errors.New("cannot decode delta: window too large")
+	}
+	enc := make([]byte, encLen)
+	if _, err := io.ReadFull(r, enc); err != nil {
+		return 0, errVCDIFFTruncated
+	}
+	encr := &vcdiffSection{name: "window", buf: enc}
+
+	targetLen, err := vcdiffReadInt(encr)
+	if err != nil {
+		return 0, err
+	}
+	if targetLen > vcdMaxWindow {
+		return 0, errors.New("cannot decode delta: window too large")
+	}
+	deltaIndicator, err := encr.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if deltaIndicator != 0 {
+		return 0, errors.New("cannot decode delta: secondary compression is not supported")
+	}
+	var lens [3]uint64
+	for i := range lens {
+		if lens[i], err = vcdiffReadInt(encr); err != nil {
+			return 0, err
+		}
+	}
+	var checksum uint32
+	if winIndicator&vcdAdler32 != 0 {
+		b, err := encr.next(4)
+		if err != nil {
+			return 0, err
+		}
+		checksum = binary.BigEndian.Uint32(b)
+	}
+	var sections [3]*vcdiffSection
+	for i, name := range []string{"data", "instructions", "addresses"} {
+		b, err := encr.next(lens[i])
+		if err != nil {
+			return 0, err
+		}
+		sections[i] = &vcdiffSection{name: name, buf: b}
+	}
+	data, insts, addrs := sections[0], sections[1], sections[2]
+
+	segLen := uint64(len(segment))
+	out := make([]byte, 0, targetLen)
+	var cache vcdiffAddrCache
+	for len(insts.buf) > 0 {
+		code, _ := insts.ReadByte()
+		for _, inst := range vcdiffCodeTable[code] {
+			if inst.typ == vcdNoop {
+				continue
+			}
+			size := uint64(inst.size)
+			if size == 0 {
+				if size, err = vcdiffReadInt(insts); err != nil {
+					return 0, err
+				}
+			}
+			if uint64(len(out))+size > targetLen {
+				return 0, errors.New("cannot decode delta: target window overflow")
+			}
+			switch inst.typ {
+			case vcdAdd:
+				b, err := data.next(size)
+				if err != nil {
+					return 0, err
+				}
+				out = append(out, b...)
+			case vcdRun:
+				b, err := data.ReadByte()
+				if err != nil {
+					return 0, err
+				}
+				for i := uint64(0); i < size; i++ {
+					out = append(out, b)
+				}
+			case vcdCopy:
+				addr, err := cache.decode(addrs, segLen+uint64(len(out)), inst.mode)
+				if err != nil {
+					return 0, err
+				}
+				// byte by byte, as copies from the target window
+				// may overlap with what they produce
+				for i := uint64(0); i < size; i++ {
+					if addr < segLen {
+						out = append(out, segment[addr])
+					} else {
+						out = append(out, out[addr-segLen])
+					}
+					addr++
+				}
+			}
+		}
+	}
+	if uint64(len(out)) != targetLen {
+		return 0, errors.New("cannot decode delta: target window size mismatch")
+	}
+	if winIndicator&vcdAdler32 != 0 && adler32.Checksum(out) != checksum {
+		return 0, errors.New("cannot decode delta: target window checksum mismatch")
+	}
+
+	if _, err := target.Write(out); err != nil {
+		return 0, err
+	}
+	return targetLen, nil
+}
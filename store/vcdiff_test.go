@@ -0,0 +1,239 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+type vcdiffSuite struct{}
+
+var _ = Suite(&vcdiffSuite{})
+
+func vcdiffInt(n int) []byte {
+	out := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		out = append([]byte{byte(n&0x7f) | 0x80}, out...)
+	}
+	return out
+}
+
+// vcdiffTestWindow encodes a delta window producing targetWin; seg
+// holds the length and position of the source segment, if any.
+func vcdiffTestWindow(winIndicator byte, seg []int, targetWin string, data, inst, addr []byte) []byte {
+	var body bytes.Buffer
+	body.Write(vcdiffInt(len(targetWin)))
+	body.WriteByte(0)
+	body.Write(vcdiffInt(len(data)))
+	body.Write(vcdiffInt(len(inst)))
+	body.Write(vcdiffInt(len(addr)))
+	if winIndicator&vcdAdler32 != 0 {
+		binary.Write(&body, binary.BigEndian, adler32.Checksum([]byte(targetWin)))
+	}
+	body.Write(data)
+	body.Write(inst)
+	body.Write(addr)
+
+	out := []byte{winIndicator}
+	for _, n := range seg {
+		out = append(out, vcdiffInt(n)...)
+	}
+	out = append(out, vcdiffInt(body.Len())...)
+	return append(out, body.Bytes()...)
+}
+
+func vcdiffTestDelta(hdrIndicator byte, windows ...[]byte) []byte {
+	out := []byte{0xD6, 0xC3, 0xC4, 0, hdrIndicator}
+	for _, w := range windows {
+		out = append(out, w...)
+	}
+	return out
+}
+
+func (s *vcdiffSuite) decode(c *C, source string, delta []byte) (string, error) {
+	target, err := os.Create(filepath.Join(c.MkDir(), "target"))
+	c.Assert(err, IsNil)
+	defer target.Close()
+	err = vcdiffDecode(strings.NewReader(source), bytes.NewReader(delta), target)
+	if err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(target.Name())
+	c.Assert(err, IsNil)
+	return string(content), nil
+}
+
+func (s *vcdiffSuite) TestCodeTable(c *C) {
+	// spot checks against RFC 3284 section 5.6
+	c.Check(vcdiffCodeTable[0], DeepEquals, [2]vcdiffInst{{typ: vcdRun}})
+	c.Check(vcdiffCodeTable[18], DeepEquals, [2]vcdiffInst{{typ: vcdAdd, size: 17}})
+	c.Check(vcdiffCodeTable[19], DeepEquals, [2]vcdiffInst{{typ: vcdCopy}})
+	c.Check(vcdiffCodeTable[162], DeepEquals, [2]vcdiffInst{{typ: vcdCopy, size: 18, mode: 8}})
+	c.Check(vcdiffCodeTable[163], DeepEquals, [2]vcdiffInst{{typ: vcdAdd, size: 1}, {typ: vcdCopy, size: 4}})
+	c.Check(vcdiffCodeTable[234], DeepEquals, [2]vcdiffInst{{typ: vcdAdd, size: 4}, {typ: vcdCopy, size: 6, mode: 5}})
+	c.Check(vcdiffCodeTable[246], DeepEquals, [2]vcdiffInst{{typ: vcdAdd, size: 4}, {typ: vcdCopy, size: 4, mode: 8}})
+	c.Check(vcdiffCodeTable[255], DeepEquals, [2]vcdiffInst{{typ: vcdCopy, size: 4, mode: 8}, {typ: vcdAdd, size: 1}})
+}
+
+func (s *vcdiffSuite) TestDecodeFromSource(c *C) {
+	source := "The quick brown fox jumps"
+	target := "The quick red fox jumps!!!!"
+	delta := vcdiffTestDelta(0, vcdiffTestWindow(vcdSource|vcdAdler32, []int{len(source), 0}, target,
+		[]byte("red!"),
+		[]byte{
+			19 + 7,      // COPY 10, self mode
+			4,           // ADD 3
+			19 + 16 + 7, // COPY 10, here mode
+			0, 4,        // RUN 4
+		},
+		[]byte{0, 23},
+	))
+	out, err := s.decode(c, source, delta)
+	c.Assert(err, IsNil)
+	c.Check(out, Equals, target)
+}
+
+func (s *vcdiffSuite) TestDecodeOverlappingTargetCopy(c *C) {
+	delta := vcdiffTestDelta(0, vcdiffTestWindow(0, nil, "abababab",
+		[]byte("ab"),
+		[]byte{
+			3,      // ADD 2
+			19 + 3, // COPY 6, self mode
+		},
+		[]byte{0},
+	))
+	out, err := s.decode(c, "", delta)
+	c.Assert(err, IsNil)
+	c.Check(out, Equals, "abababab")
+}
+
+func (s *vcdiffSuite) TestDecodeCombinedInstructionsAndCaches(c *C) {
+	source := "abcdefgh"
+	delta := vcdiffTestDelta(0, vcdiffTestWindow(vcdSource, []int{len(source), 0}, "XabcdefghYefghZ",
+		[]byte("XYZ"),
+		[]byte{
+			163,     // ADD 1 + COPY 4, self mode
+			247 + 2, // COPY 4, first near slot + ADD 1
+			247 + 6, // COPY 4, first same slot + ADD 1
+		},
+		[]byte{0, 4, 4},
+	))
+	out, err := s.decode(c, source, delta)
+	c.Assert(err, IsNil)
+	c.Check(out, Equals, "XabcdefghYefghZ")
+}
+
+func (s *vcdiffSuite) TestDecodeTargetWindowAndAppHeader(c *C) {
+	delta := vcdiffTestDelta(vcdAppHeader,
+		append(vcdiffInt(3), "foo"...),
+		vcdiffTestWindow(0, nil, "hello ", []byte("hello "), []byte{7}, nil),
+		vcdiffTestWindow(vcdTarget, []int{5, 0}, "hello!",
+			[]byte("!"),
+			[]byte{
+				19 + 2, // COPY 5, self mode
+				2,      // ADD 1
+			},
+			[]byte{0},
+		),
+	)
+	out, err := s.decode(c, "", delta)
+	c.Assert(err, IsNil)
+	c.Check(out, Equals, "hello hello!")
+}
+
+func (s *vcdiffSuite) TestDecodeErrors(c *C) {
+	okWindow := vcdiffTestWindow(0, nil, "hi", []byte("hi"), []byte{3}, nil)
+	for _, t := range []struct {
+		delta []byte
+		err   string
+	}{
+		{[]byte("BSDIFF40"), "cannot decode delta: not in VCDIFF format"},
+		{[]byte{0xD6, 0xC3}, "cannot decode delta: truncated"},
+		{[]byte{0xD6, 0xC3, 0xC4, 1, 0}, "cannot decode delta: unsupported VCDIFF version 1"},
+		{vcdiffTestDelta(vcdDecompress, []byte{2}), "cannot decode delta: secondary compression is not supported"},
+		{vcdiffTestDelta(vcdCodeTable), "cannot decode delta: custom code tables are not supported"},
+		{vcdiffTestDelta(0, okWindow[:len(okWindow)-1]), "cannot decode delta: truncated"},
+		{vcdiffTestDelta(0, vcdiffTestWindow(0, nil, "hi!", []byte("hi"), []byte{3}, nil)), "cannot decode delta: target window size mismatch"},
+		{vcdiffTestDelta(0, vcdiffTestWindow(0, nil, "h", []byte("hi"), []byte{3}, nil)), "cannot decode delta: target window overflow"},
+		{vcdiffTestDelta(0, vcdiffTestWindow(0, nil, "hi", []byte("h"), []byte{3}, nil)), "cannot decode delta: data section exhausted"},
+		{vcdiffTestDelta(0, vcdiffTestWindow(0, nil, "hihihi", []byte("hi"), []byte{3, 19 + 16 + 1}, []byte{3})), "cannot decode delta: invalid address"},
+		{vcdiffTestDelta(0, vcdiffTestWindow(vcdSource, []int{10, 0}, "hi", []byte("hi"), []byte{3}, nil)), "cannot decode delta: cannot read source segment: EOF"},
+		{vcdiffTestDelta(0, vcdiffTestWindow(vcdTarget, []int{1, 0}, "hi", []byte("hi"), []byte{3}, nil)), "cannot decode delta: target segment out of range"},
+		{vcdiffTestDelta(0, vcdiffTestWindow(vcdSource|vcdTarget, []int{1, 0}, "hi", []byte("hi"), []byte{3}, nil)), "cannot decode delta: invalid window indicator 0x3"},
+	} {
+		_, err := s.decode(c, "", t.delta)
+		c.Check(err, ErrorMatches, t.err, Commentf("%q", t.delta))
+	}
+}
+
+func (s *vcdiffSuite) TestDecodeChecksumMismatch(c *C) {
+	window := vcdiffTestWindow(vcdAdler32, nil, "hi", []byte("ho"), []byte{3}, nil)
+	_, err := s.decode(c, "", vcdiffTestDelta(0, window))
+	c.Check(err, ErrorMatches, "cannot decode delta: target window checksum mismatch")
+}
+
+func (s *vcdiffSuite) TestDecodeXdelta3Output(c *C) {
+	if !osutil.ExecutableExists("xdelta3") {
+		c.Skip("xdelta3 not installed")
+	}
+
+	var source, target bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&source, "line %d of the source snap\n", i)
+		if i%7 == 0 {
+			fmt.Fprintf(&target, "changed line %d\n", i*3)
+			continue
+		}
+		fmt.Fprintf(&target, "line %d of the source snap\n", i)
+	}
+
+	dir := c.MkDir()
+	sourcePath := filepath.Join(dir, "source")
+	targetPath := filepath.Join(dir, "target")
+	deltaPath := filepath.Join(dir, "delta")
+	c.Assert(ioutil.WriteFile(sourcePath, source.Bytes(), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(targetPath, target.Bytes(), 0644), IsNil)
+	// default options, like the deltas the store serves
+	output, err := exec.Command("xdelta3", "-e", "-s", sourcePath, targetPath, deltaPath).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", output))
+
+	delta, err := ioutil.ReadFile(deltaPath)
+	c.Assert(err, IsNil)
+	// xdelta3 writes its application header and window checksums
+	c.Assert(len(delta) > 6, Equals, true)
+	c.Check(delta[4]&vcdAppHeader, Equals, byte(vcdAppHeader))
+	c.Assert(delta[5] < 0x80, Equals, true)
+	c.Check(delta[6+int(delta[5])]&vcdAdler32, Equals, byte(vcdAdler32))
+
+	out, err := s.decode(c, source.String(), delta)
+	c.Assert(err, IsNil)
+	c.Check([]byte(out), DeepEquals, target.Bytes())
+}
@@ -22,6 +22,7 @@ package strutil
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"math/rand"
 	"strconv"
 	"strings"
@@ -60,6 +61,36 @@ func SizeToStr(size int64) string {
 	panic("SizeToStr got a size bigger than math.MaxInt64")
 }
 
+// ParseByteSize parses a size in bytes as produced by SizeToStr, e.g.
+// "400B", "20MB" or simply "1000". Units are powers of 1000 and the
+// trailing B is optional ("20M" is the same as "20MB").
+func ParseByteSize(str string) (int64, error) {
+	s := strings.TrimSpace(str)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == 0 || s == "" {
+		return 0, fmt.Errorf("cannot parse byte size %q: need a number with an optional unit", str)
+	}
+	digits, unit := s, ""
+	if i > 0 {
+		digits, unit = s[:i], strings.TrimSuffix(s[i:], "B")
+	}
+	mult := int64(1)
+	if unit != "" {
+		idx := strings.Index("kMGTPE", unit)
+		if len(unit) != 1 || idx < 0 {
+			return 0, fmt.Errorf("cannot parse byte size %q: unknown unit %q", str, s[i:])
+		}
+		for ; idx >= 0; idx-- {
+			mult *= 1000
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("cannot parse byte size %q: out of range", str)
+	}
+	return n * mult, nil
+}
+
 // Quoted formats a slice of strings to a quoted list of
 // comma-separated strings, e.g. `"snap1", "snap2"`
 func Quoted(names []string) string {
@@ -71,6 +102,17 @@ func Quoted(names []string) string {
 	return strings.Join(quoted, ", ")
 }
 
+// ListContains determines whether the given string is contained in the
+// given list of strings.
+func ListContains(list []string, str string) bool {
+	for _, k := range list {
+		if k == str {
+			return true
+		}
+	}
+	return false
+}
+
 // WordWrap takes a input string and word wraps after `n` chars
 // into a new slice.
 //
@@ -22,6 +22,7 @@ package strutil_test
 import (
 	"math"
 	"math/rand"
+	"regexp"
 	"testing"
 
 	"gopkg.in/check.v1"
@@ -61,6 +62,62 @@ func (*strutilSuite) TestQuoted(c *check.C) {
 	}
 }
 
+func (*strutilSuite) TestListContains(c *check.C) {
+	for _, xs := range [][]string{
+		{},
+		nil,
+		{"foo"},
+		{"foo", "baz", "barbar"},
+	} {
+		c.Check(strutil.ListContains(xs, "bar"), check.Equals, false)
+	}
+
+	for _, xs := range [][]string{
+		{"bar"},
+		{"foo", "bar", "baz"},
+		{"bar", "foo", "baz"},
+		{"foo", "baz", "bar"},
+	} {
+		c.Check(strutil.ListContains(xs, "bar"), check.Equals, true)
+	}
+}
+
+func (ts *strutilSuite) TestParseByteSize(c *check.C) {
+	for _, t := range []struct {
+		str  string
+		size int64
+	}{
+		{"0", 0},
+		{"400", 400},
+		{"400B", 400},
+		{"1kB", 1000},
+		{"1k", 1000},
+		{"20MB", 20 * 1000 * 1000},
+		{" 20M ", 20 * 1000 * 1000},
+		{"31GB", 31 * 1000 * 1000 * 1000},
+		{"9EB", 9 * 1000 * 1000 * 1000 * 1000 * 1000 * 1000},
+	} {
+		size, err := strutil.ParseByteSize(t.str)
+		c.Check(err, check.IsNil, check.Commentf("%q", t.str))
+		c.Check(size, check.Equals, t.size, check.Commentf("%q", t.str))
+	}
+
+	for _, t := range []struct {
+		str string
+		err string
+	}{
+		{"", `cannot parse byte size "": need a number with an optional unit`},
+		{"MB", `cannot parse byte size "MB": need a number with an optional unit`},
+		{"-1", `cannot parse byte size "-1": need a number with an optional unit`},
+		{"1.5MB", `cannot parse byte size "1.5MB": unknown unit ".5MB"`},
+		{"10KiB", `cannot parse byte size "10KiB": unknown unit "KiB"`},
+		{"10EB", `cannot parse byte size "10EB": out of range`},
+	} {
+		_, err := strutil.ParseByteSize(t.str)
+		c.Check(err, check.ErrorMatches, regexp.QuoteMeta(t.err))
+	}
+}
+
 func (ts *strutilSuite) TestSizeToStr(c *check.C) {
 	for _, t := range []struct {
 		size int64
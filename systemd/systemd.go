@@ -25,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os/exec"
 	"path/filepath"
 	"regexp"
@@ -62,21 +63,48 @@ func run(args ...string) ([]byte, error) {
 // systemctl. It's exported so it can be overridden by testing.
 var SystemctlCmd = run
 
-// jctl calls journalctl to get the JSON logs of the given services, wrapping the error if any.
-func jctl(svcs []string) ([]byte, error) {
-	cmd := []string{"journalctl", "-o", "json"}
+// jctlReader is the output of a running journalctl.
+type jctlReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+// Close stops journalctl, returning an error if it had failed on its own.
+func (r *jctlReader) Close() error {
+	r.ReadCloser.Close()
+	// ignore errors for kill; journalctl might have finished already
+	r.cmd.Process.Kill()
+	if err := r.cmd.Wait(); err != nil {
+		// a negative exit code means we killed it
+		if exitCode, e := osutil.ExitCode(err); e == nil && exitCode > 0 {
+			return &Error{cmd: r.cmd.Args, exitCode: exitCode}
+		}
+	}
+	return nil
+}
+
+// jctl calls journalctl to get the JSON logs of the given services,
+// the last n of them (or "all"), following them if asked to.
+func jctl(svcs []string, n string, follow bool) (io.ReadCloser, error) {
+	cmd := []string{"journalctl", "-o", "json", "--no-pager", "-n", n}
+	if follow {
+		cmd = append(cmd, "-f")
+	}
 
 	for i := range svcs {
 		cmd = append(cmd, "-u", svcs[i])
 	}
 
-	bs, err := exec.Command(cmd[0], cmd[1:]...).Output() // journalctl can be messy with its stderr
+	c := exec.Command(cmd[0], cmd[1:]...) // journalctl can be messy with its stderr
+	stdout, err := c.StdoutPipe()
 	if err != nil {
-		exitCode, _ := osutil.ExitCode(err)
-		return nil, &Error{cmd: cmd, exitCode: exitCode, msg: bs}
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
 	}
 
-	return bs, nil
+	return &jctlReader{ReadCloser: stdout, cmd: c}, nil
 }
 
 // JournalctlCmd is called from Logs to run journalctl; exported for testing.
@@ -93,7 +121,9 @@ type Systemd interface {
 	Restart(service string, timeout time.Duration) error
 	Status(service string) (string, error)
 	ServiceStatus(service string) (*ServiceStatus, error)
+	TimerNextElapse(timer string) (time.Time, error)
 	Logs(services []string) ([]Log, error)
+	LogReader(services []string, n string, follow bool) (io.ReadCloser, error)
 	WriteMountUnitFile(name, what, where, fstype string) (string, error)
 }
 
@@ -156,6 +186,9 @@ const (
 
 	// the default target for systemd units that we generate
 	SocketsTarget = "sockets.target"
+
+	// the default target for systemd timer units that we generate
+	TimersTarget = "timers.target"
 )
 
 type reporter interface {
@@ -197,8 +230,15 @@ func (*systemd) Start(serviceName string) error {
 }
 
 // Logs for the given service
-func (*systemd) Logs(serviceNames []string) ([]Log, error) {
-	bs, err := JournalctlCmd(serviceNames)
+func (s *systemd) Logs(serviceNames []string) ([]Log, error) {
+	r, err := s.LogReader(serviceNames, "all", false)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := ioutil.ReadAll(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -228,6 +268,14 @@ func (*systemd) Logs(serviceNames []string) ([]Log, error) {
 	return logs, nil
 }
 
+// LogReader returns a reader of the JSON-encoded journal entries of
+// the given services, the last n of them (or "all"). If follow is
+// true the reader keeps returning new entries as they arrive, until
+// closed.
+func (*systemd) LogReader(serviceNames []string, n string, follow bool) (io.ReadCloser, error) {
+	return JournalctlCmd(serviceNames, n, follow)
+}
+
 var statusregex = regexp.MustCompile(`(?m)^(?:(.*?)=(.*))?$`)
 
 func (s *systemd) Status(serviceName string) (string, error) {
@@ -276,6 +324,40 @@ func (s *systemd) ServiceStatus(serviceName string) (*ServiceStatus, error) {
 	return status, nil
 }
 
+// timerElapseLayout is the layout systemctl uses when showing timestamps.
+const timerElapseLayout = "Mon 2006-01-02 15:04:05 MST"
+
+// TimerNextElapse returns when the given timer unit will next trigger,
+// or the zero time if it is not scheduled to trigger (e.g. because it
+// is not active).
+func (s *systemd) TimerNextElapse(timerName string) (time.Time, error) {
+	bs, err := SystemctlCmd("show", "--property=NextElapseUSecRealtime", timerName)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, bs := range statusregex.FindAllSubmatch(bs, -1) {
+		if len(bs[0]) == 0 || string(bs[1]) != "NextElapseUSecRealtime" {
+			continue
+		}
+		v := string(bs[2])
+		if v == "" || v == "n/a" || v == "0" {
+			return time.Time{}, nil
+		}
+		// older systemd versions show the raw number of microseconds
+		if usec, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(0, usec*int64(time.Microsecond)), nil
+		}
+		t, err := time.ParseInLocation(timerElapseLayout, v, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse next elapse time of %s: %v", timerName, err)
+		}
+		return t, nil
+	}
+
+	return time.Time{}, nil
+}
+
 // Stop the given service, and wait until it has stopped.
 func (s *systemd) Stop(serviceName string, timeout time.Duration) error {
 	if _, err := SystemctlCmd("stop", serviceName); err != nil {
@@ -360,28 +442,40 @@ func IsTimeout(err error) bool {
 
 const myFmt = "2006-01-02T15:04:05.000000Z07:00"
 
+// Time of the Log, from its realtime timestamp.
+func (l Log) Time() (time.Time, error) {
+	ius, ok := l["__REALTIME_TIMESTAMP"]
+	if !ok {
+		return time.Time{}, errors.New("no timestamp")
+	}
+	// according to systemd.journal-fields(7) it's microseconds as a decimal string
+	sus, ok := ius.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("timestamp not a string: %#v", ius)
+	}
+	us, err := strconv.ParseInt(sus, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp not a decimal number: %#v", sus)
+	}
+
+	return time.Unix(us/1000000, 1000*(us%1000000)).UTC(), nil
+}
+
 // Timestamp of the Log, formatted like RFC3339 to µs precision.
 //
 // If no timestamp, the string "-(no timestamp!)-" -- and something is
 // wrong with your system. Some other "impossible" error conditions
 // also result in "-(errror message)-" timestamps.
 func (l Log) Timestamp() string {
-	t := "-(no timestamp!)-"
-	if ius, ok := l["__REALTIME_TIMESTAMP"]; ok {
-		// according to systemd.journal-fields(7) it's microseconds as a decimal string
-		sus, ok := ius.(string)
-		if ok {
-			if us, err := strconv.ParseInt(sus, 10, 64); err == nil {
-				t = time.Unix(us/1000000, 1000*(us%1000000)).UTC().Format(myFmt)
-			} else {
-				t = fmt.Sprintf("-(timestamp not a decimal number: %#v)-", sus)
-			}
-		} else {
-			t = fmt.Sprintf("-(timestamp not a string: %#v)-", ius)
+	t, err := l.Time()
+	if err != nil {
+		if _, ok := l["__REALTIME_TIMESTAMP"]; !ok {
+			return "-(no timestamp!)-"
 		}
+		return fmt.Sprintf("-(%v)-", err)
 	}
 
-	return t
+	return t.Format(myFmt)
 }
 
 // Message of the Log, if any; otherwise, "-".
@@ -402,6 +496,15 @@ func (l Log) SID() string {
 	return "-"
 }
 
+// PID is the pid of the process that generated the Log, if any; otherwise, "-".
+func (l Log) PID() string {
+	if pid, ok := l["_PID"].(string); ok {
+		return pid
+	}
+
+	return "-"
+}
+
 func (l Log) String() string {
 	return fmt.Sprintf("%s %s %s", l.Timestamp(), l.SID(), l.Message())
 }
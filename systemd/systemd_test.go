@@ -20,7 +20,9 @@
 package systemd_test
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -55,10 +57,12 @@ type SystemdTestSuite struct {
 	errors []error
 	outs   [][]byte
 
-	j     int
-	jsvcs [][]string
-	jouts [][]byte
-	jerrs []error
+	j        int
+	jns      []string
+	jsvcs    [][]string
+	jouts    [][]byte
+	jerrs    []error
+	jfollows []bool
 
 	rep *testreporter
 }
@@ -81,7 +85,9 @@ func (s *SystemdTestSuite) SetUpTest(c *C) {
 
 	JournalctlCmd = s.myJctl
 	s.j = 0
+	s.jns = nil
 	s.jsvcs = nil
+	s.jfollows = nil
 	s.jouts = nil
 	s.jerrs = nil
 
@@ -105,8 +111,13 @@ func (s *SystemdTestSuite) myRun(args ...string) (out []byte, err error) {
 	return out, err
 }
 
-func (s *SystemdTestSuite) myJctl(svcs []string) (out []byte, err error) {
+func (s *SystemdTestSuite) myJctl(svcs []string, n string, follow bool) (io.ReadCloser, error) {
+	var err error
+	var out []byte
+
+	s.jns = append(s.jns, n)
 	s.jsvcs = append(s.jsvcs, svcs)
+	s.jfollows = append(s.jfollows, follow)
 
 	if s.j < len(s.jouts) {
 		out = s.jouts[s.j]
@@ -116,7 +127,11 @@ func (s *SystemdTestSuite) myJctl(svcs []string) (out []byte, err error) {
 	}
 	s.j++
 
-	return out, err
+	if out == nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(out)), err
 }
 
 func (s *SystemdTestSuite) TestDaemonReload(c *C) {
@@ -176,6 +191,36 @@ func (s *SystemdTestSuite) TestStatusObj(c *C) {
 	})
 }
 
+func (s *SystemdTestSuite) TestTimerNextElapse(c *C) {
+	s.outs = [][]byte{
+		[]byte("NextElapseUSecRealtime=Thu 2018-01-11 10:30:00 UTC\n"),
+	}
+	s.errors = []error{nil}
+	next, err := New("", s.rep).TimerNextElapse("foo.timer")
+	c.Assert(err, IsNil)
+	c.Check(next.Equal(time.Date(2018, 1, 11, 10, 30, 0, 0, time.UTC)), Equals, true)
+	c.Check(s.argses, DeepEquals, [][]string{{"show", "--property=NextElapseUSecRealtime", "foo.timer"}})
+}
+
+func (s *SystemdTestSuite) TestTimerNextElapseNotScheduled(c *C) {
+	s.outs = [][]byte{
+		[]byte("NextElapseUSecRealtime=\n"),
+	}
+	s.errors = []error{nil}
+	next, err := New("", s.rep).TimerNextElapse("foo.timer")
+	c.Assert(err, IsNil)
+	c.Check(next.IsZero(), Equals, true)
+}
+
+func (s *SystemdTestSuite) TestTimerNextElapseBad(c *C) {
+	s.outs = [][]byte{
+		[]byte("NextElapseUSecRealtime=potato\n"),
+	}
+	s.errors = []error{nil}
+	_, err := New("", s.rep).TimerNextElapse("foo.timer")
+	c.Assert(err, ErrorMatches, `cannot parse next elapse time of foo.timer: .*`)
+}
+
 func (s *SystemdTestSuite) TestStopTimeout(c *C) {
 	restore := MockStopDelays(time.Millisecond, 25*time.Second)
 	defer restore()
@@ -253,9 +298,43 @@ func (s *SystemdTestSuite) TestLogs(c *C) {
 	c.Check(err, IsNil)
 	c.Check(logs, DeepEquals, []Log{{"a": 1.}, {"a": 2.}})
 	c.Check(s.jsvcs, DeepEquals, [][]string{{"foo"}})
+	c.Check(s.jns, DeepEquals, []string{"all"})
+	c.Check(s.jfollows, DeepEquals, []bool{false})
 	c.Check(s.j, Equals, 1)
 }
 
+func (s *SystemdTestSuite) TestLogReader(c *C) {
+	expected := `{"a": 1}
+{"a": 2}
+`
+	s.jouts = [][]byte{[]byte(expected)}
+
+	reader, err := New("", s.rep).LogReader([]string{"foo", "bar"}, "24", true)
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	bs, err := ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Check(string(bs), Equals, expected)
+	c.Check(s.jsvcs, DeepEquals, [][]string{{"foo", "bar"}})
+	c.Check(s.jns, DeepEquals, []string{"24"})
+	c.Check(s.jfollows, DeepEquals, []bool{true})
+}
+
+func (s *SystemdTestSuite) TestLogPID(c *C) {
+	c.Check(Log{}.PID(), Equals, "-")
+	c.Check(Log{"_PID": "99"}.PID(), Equals, "99")
+}
+
+func (s *SystemdTestSuite) TestLogTime(c *C) {
+	t, err := Log{"__REALTIME_TIMESTAMP": "42"}.Time()
+	c.Assert(err, IsNil)
+	c.Check(t, Equals, time.Unix(0, 42000).UTC())
+
+	_, err = Log{}.Time()
+	c.Check(err, ErrorMatches, "no timestamp")
+}
+
 func (s *SystemdTestSuite) TestLogString(c *C) {
 	c.Check(Log{}.String(), Equals, "-(no timestamp!)- - -")
 	c.Check(Log{
@@ -41,6 +41,19 @@ func (s *mockCommandSuite) TestMockCommand(c *C) {
 	})
 }
 
+func (s *mockCommandSuite) TestMockCommandLogsArgsVerbatim(c *C) {
+	// echo in /bin/sh takes these as options or escapes, for
+	// example "mkfs.vfat -n LABEL" was logged without its "-n"
+	mock := MockCommand(c, "cmd", "")
+	defer mock.Restore()
+
+	err := exec.Command("cmd", "-n", "LABEL", "-e", `a\tb`, `c\nd`).Run()
+	c.Assert(err, IsNil)
+	c.Check(mock.Calls(), DeepEquals, [][]string{
+		{"cmd", "-n", "LABEL", "-e", `a\tb`, `c\nd`},
+	})
+}
+
 func (s *mockCommandSuite) TestMockCommandAlso(c *C) {
 	mock := MockCommand(c, "fst", "")
 	also := mock.Also("snd", "")
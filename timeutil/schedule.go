@@ -92,6 +92,10 @@ func (sched *Schedule) Next(last time.Time) (start, end time.Time) {
 }
 
 func randDur(dur time.Duration) time.Duration {
+	// windows shorter than the safety margin are not randomized
+	if dur <= 0 {
+		return 0
+	}
 	return time.Duration(rand.Int63n(int64(dur)))
 }
 
@@ -139,24 +143,43 @@ var weekdayMap = map[string]int{
 	"sat": 6,
 }
 
-// parseWeekday gets an input like "mon@9:00-11:00" or "9:00-11:00"
-// and extracts the weekday of that schedule string (which can be
-// empty). It returns the remainder of the string, the weekday
-// and an error.
-func parseWeekday(s string) (weekday, rest string, err error) {
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseWeekdays gets an input like "mon,wed-fri@9:00-11:00" or
+// "9:00-11:00" and extracts the list of weekdays of that schedule
+// string (which can be empty), expanding ranges like "wed-fri"
+// into "wed", "thu", "fri". It returns the remainder of the string,
+// the weekdays and an error.
+func parseWeekdays(s string) (weekdays []string, rest string, err error) {
 	if !strings.Contains(s, "@") {
-		return "", s, nil
+		return nil, s, nil
 	}
-	s = strings.ToLower(s)
 	l := strings.SplitN(s, "@", 2)
-	weekday = l[0]
-	_, ok := weekdayMap[weekday]
-	if !ok {
-		return "", "", fmt.Errorf(`cannot parse %q, want "mon", "tue", etc`, l[0])
-	}
 	rest = l[1]
+	for _, spec := range strings.Split(l[0], ",") {
+		days := strings.SplitN(strings.ToLower(spec), "-", 2)
+		if len(days) == 1 {
+			if _, ok := weekdayMap[days[0]]; !ok {
+				return nil, "", fmt.Errorf(`cannot parse %q, want "mon", "tue", etc`, spec)
+			}
+			weekdays = append(weekdays, days[0])
+			continue
+		}
+		first, ok1 := weekdayMap[days[0]]
+		last, ok2 := weekdayMap[days[1]]
+		if !ok1 || !ok2 {
+			return nil, "", fmt.Errorf(`cannot parse %q, want "mon", "tue", etc`, spec)
+		}
+		// ranges may wrap around the end of the week, e.g. "fri-mon"
+		for i := first; ; i = (i + 1) % 7 {
+			weekdays = append(weekdays, weekdayNames[i])
+			if i == last {
+				break
+			}
+		}
+	}
 
-	return weekday, rest, nil
+	return weekdays, rest, nil
 }
 
 // parseTimeInterval gets an input like "9:00-11:00"
@@ -179,39 +202,54 @@ func parseTimeInterval(s string) (start, end TimeOfDay, err error) {
 	if err != nil {
 		return start, end, fmt.Errorf("cannot parse %q: not a valid time", l[1])
 	}
-	if !(start.Hour <= end.Hour && start.Minute <= end.Minute) {
+	if start.Hour*60+start.Minute > end.Hour*60+end.Minute {
 		return start, end, fmt.Errorf("cannot parse %q: time in an interval cannot go backwards", s)
 	}
 
 	return start, end, nil
 }
 
-// parseSingleSchedule parses a schedule string like "mon@9:00-11:00" or
-// "9:00-11:00" and returns a Schedule struct and an error.
-func parseSingleSchedule(s string) (*Schedule, error) {
-	weekday, rest, err := parseWeekday(s)
+// parseSingleSchedule parses a schedule string like "mon@9:00-11:00",
+// "mon,wed-fri@9:00-11:00,13:00-15:00" or "9:00-11:00" and returns
+// the Schedule structs for every weekday and interval combination and
+// an error.
+func parseSingleSchedule(s string) ([]*Schedule, error) {
+	weekdays, rest, err := parseWeekdays(s)
 	if err != nil {
 		return nil, err
 	}
-	start, end, err := parseTimeInterval(rest)
-	if err != nil {
-		return nil, err
+	if len(weekdays) == 0 {
+		// every day
+		weekdays = []string{""}
 	}
 
-	return &Schedule{
-		Weekday: weekday,
-		Start:   start,
-		End:     end,
-	}, nil
+	var schedule []*Schedule
+	for _, interval := range strings.Split(rest, ",") {
+		start, end, err := parseTimeInterval(interval)
+		if err != nil {
+			return nil, err
+		}
+		for _, weekday := range weekdays {
+			schedule = append(schedule, &Schedule{
+				Weekday: weekday,
+				Start:   start,
+				End:     end,
+			})
+		}
+	}
+
+	return schedule, nil
 }
 
 // ParseSchedule takes a schedule string in the form of:
 //
 // 9:00-15:00 (every day between 9am and 3pm)
 // 9:00-15:00/21:00-22:00 (every day between 9am,5pm and 9pm,10pm)
+// 9:00-11:00,13:00-15:00 (every day between 9am,11am and 1pm,3pm)
 // thu@9:00-15:00 (only Thursday between 9am and 3pm)
 // fri@9:00-11:00/mon@13:00-15:00 (only Friday between 9am and 3pm and Monday between 1pm and 3pm)
 // fri@9:00-11:00/13:00-15:00  (only Friday between 9am and 3pm and every day between 1pm and 3pm)
+// mon,wed-fri@9:00-11:00,13:00-15:00 (Monday and Wednesday to Friday between 9am,11am and 1pm,3pm)
 //
 // and returns a list of Schedule types or an error
 func ParseSchedule(scheduleSpec string) ([]*Schedule, error) {
@@ -222,7 +260,7 @@ func ParseSchedule(scheduleSpec string) ([]*Schedule, error) {
 		if err != nil {
 			return nil, err
 		}
-		schedule = append(schedule, sched)
+		schedule = append(schedule, sched...)
 	}
 
 	return schedule, nil
@@ -75,12 +75,29 @@ func (ts *timeutilSuite) TestParseSchedule(c *C) {
 		{"23:00-01:00", nil, `cannot parse "23:00-01:00": time in an interval cannot go backwards`},
 		// FIXME: error message sucks
 		{"9:00-mon@11:00", nil, `cannot parse "9:00-mon", want "mon", "tue", etc`},
+		{"mon,foo@9:00-11:00", nil, `cannot parse "foo", want "mon", "tue", etc`},
+		{"mon-foo@9:00-11:00", nil, `cannot parse "mon-foo", want "mon", "tue", etc`},
+		{"mon@9:00-11:00,invalid", nil, `cannot parse "invalid": not a valid interval`},
 
 		// valid
 		{"9:00-11:00", []*timeutil.Schedule{{Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}}}, ""},
 		{"mon@9:00-11:00", []*timeutil.Schedule{{Weekday: "mon", Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}}}, ""},
 		{"9:00-11:00/20:00-22:00", []*timeutil.Schedule{{Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}}, {Start: timeutil.TimeOfDay{Hour: 20}, End: timeutil.TimeOfDay{Hour: 22}}}, ""},
 		{"mon@9:00-11:00/Wed@22:00-23:00", []*timeutil.Schedule{{Weekday: "mon", Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}}, {Weekday: "wed", Start: timeutil.TimeOfDay{Hour: 22}, End: timeutil.TimeOfDay{Hour: 23}}}, ""},
+		{"9:30-11:00", []*timeutil.Schedule{{Start: timeutil.TimeOfDay{Hour: 9, Minute: 30}, End: timeutil.TimeOfDay{Hour: 11}}}, ""},
+		{"9:00-11:00,13:00-15:00", []*timeutil.Schedule{{Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}}, {Start: timeutil.TimeOfDay{Hour: 13}, End: timeutil.TimeOfDay{Hour: 15}}}, ""},
+		{"mon,wed-fri@9:00-11:00", []*timeutil.Schedule{
+			{Weekday: "mon", Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}},
+			{Weekday: "wed", Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}},
+			{Weekday: "thu", Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}},
+			{Weekday: "fri", Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}},
+		}, ""},
+		{"sat-sun@9:00-11:00,13:00-15:00", []*timeutil.Schedule{
+			{Weekday: "sat", Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}},
+			{Weekday: "sun", Start: timeutil.TimeOfDay{Hour: 9}, End: timeutil.TimeOfDay{Hour: 11}},
+			{Weekday: "sat", Start: timeutil.TimeOfDay{Hour: 13}, End: timeutil.TimeOfDay{Hour: 15}},
+			{Weekday: "sun", Start: timeutil.TimeOfDay{Hour: 13}, End: timeutil.TimeOfDay{Hour: 15}},
+		}, ""},
 	} {
 		schedule, err := timeutil.ParseSchedule(t.in)
 		if t.errStr != "" {
@@ -214,6 +231,22 @@ func (ts *timeutilSuite) TestScheduleNext(c *C) {
 			now:      "2017-02-06 12:00",
 			next:     "0s-0s",
 		},
+		{
+			// window shorter than the safety margin, no
+			// randomization
+			schedule: "9:00-9:02",
+			last:     "2017-02-05 22:00",
+			now:      "2017-02-06 08:00",
+			next:     "1h-1h",
+		},
+		{
+			// multiple weekdays and intervals
+			// (2017-02-06 is a monday)
+			schedule: "mon,wed-fri@9:00-11:00,13:00-15:00",
+			last:     "2017-02-06 10:00",
+			now:      "2017-02-06 12:00",
+			next:     "1h-3h",
+		},
 	} {
 		last, err := time.ParseInLocation(shortForm, t.last, time.Local)
 		c.Assert(err, IsNil)
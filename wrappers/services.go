@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
 	"time"
 
@@ -33,6 +34,7 @@ import (
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/systemd"
 	"github.com/snapcore/snapd/timeout"
+	"github.com/snapcore/snapd/timeutil"
 )
 
 type interacter interface {
@@ -58,6 +60,25 @@ func generateSnapServiceFile(app *snap.AppInfo) (string, error) {
 	return genServiceFile(app), nil
 }
 
+func generateSnapTimerFile(app *snap.AppInfo) (string, error) {
+	schedule, err := timeutil.ParseSchedule(app.Timer)
+	if err != nil {
+		return "", err
+	}
+
+	return genTimerFile(app, schedule), nil
+}
+
+// startupUnit returns the name of the unit that starts the given
+// service: its timer for timer-activated services, otherwise the
+// service itself.
+func startupUnit(app *snap.AppInfo) string {
+	if app.Timer != "" {
+		return filepath.Base(app.TimerFile())
+	}
+	return filepath.Base(app.ServiceFile())
+}
+
 // StartSnapServices starts service units for the applications from the snap which are services.
 func StartSnapServices(s *snap.Info, inter interacter) error {
 	for _, app := range s.Apps {
@@ -65,7 +86,7 @@ func StartSnapServices(s *snap.Info, inter interacter) error {
 			continue
 		}
 		// daemon-reload and enable plus start
-		serviceName := filepath.Base(app.ServiceFile())
+		serviceName := startupUnit(app)
 		sysd := systemd.New(dirs.GlobalRootDir, inter)
 		if err := sysd.DaemonReload(); err != nil {
 			return err
@@ -99,11 +120,45 @@ func AddSnapServices(s *snap.Info, inter interacter) error {
 		if err := osutil.AtomicWriteFile(svcFilePath, []byte(content), 0644, 0); err != nil {
 			return err
 		}
+
+		if app.Timer == "" {
+			continue
+		}
+		// Generate timer file
+		content, err = generateSnapTimerFile(app)
+		if err != nil {
+			return err
+		}
+		if err := osutil.AtomicWriteFile(app.TimerFile(), []byte(content), 0644, 0); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func stopService(sysd systemd.Systemd, app *snap.AppInfo, inter interacter) error {
+	serviceName := filepath.Base(app.ServiceFile())
+	tout := serviceStopTimeout(app)
+	if app.Timer != "" {
+		// stop the timer first so it does not start the service again
+		if err := sysd.Stop(filepath.Base(app.TimerFile()), tout); err != nil {
+			return err
+		}
+	}
+	if err := sysd.Stop(serviceName, tout); err != nil {
+		if !systemd.IsTimeout(err) {
+			return err
+		}
+		inter.Notify(fmt.Sprintf("%s refused to stop, killing.", serviceName))
+		// ignore errors for kill; nothing we'd do differently at this point
+		sysd.Kill(serviceName, "TERM")
+		time.Sleep(killWait)
+		sysd.Kill(serviceName, "KILL")
+	}
+	return nil
+}
+
 // StopSnapServices stops service units for the applications from the snap which are services.
 func StopSnapServices(s *snap.Info, inter interacter) error {
 	sysd := systemd.New(dirs.GlobalRootDir, inter)
@@ -114,22 +169,120 @@ func StopSnapServices(s *snap.Info, inter interacter) error {
 		if app.Daemon == "" || !osutil.FileExists(app.ServiceFile()) {
 			continue
 		}
-		serviceName := filepath.Base(app.ServiceFile())
-		tout := serviceStopTimeout(app)
-		if err := sysd.Stop(serviceName, tout); err != nil {
-			if !systemd.IsTimeout(err) {
+		if err := stopService(sysd, app, inter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func checkServices(apps []*snap.AppInfo) error {
+	for _, app := range apps {
+		if !app.IsService() {
+			return fmt.Errorf("%s.%s is not a service", app.Snap.Name(), app.Name)
+		}
+	}
+	return nil
+}
+
+// StartServices starts the service units of the given applications,
+// which must all be services. If enable is true the units are also
+// enabled, so they start on boot.
+func StartServices(apps []*snap.AppInfo, enable bool, inter interacter) error {
+	if err := checkServices(apps); err != nil {
+		return err
+	}
+	sysd := systemd.New(dirs.GlobalRootDir, inter)
+
+	for _, app := range apps {
+		serviceName := startupUnit(app)
+		if enable {
+			if err := sysd.Enable(serviceName); err != nil {
 				return err
 			}
-			inter.Notify(fmt.Sprintf("%s refused to stop, killing.", serviceName))
-			// ignore errors for kill; nothing we'd do differently at this point
-			sysd.Kill(serviceName, "TERM")
-			time.Sleep(killWait)
-			sysd.Kill(serviceName, "KILL")
+		}
+		if err := sysd.Start(serviceName); err != nil {
+			return err
 		}
 	}
 
 	return nil
+}
+
+// StopServices stops the service units of the given applications,
+// which must all be services. If disable is true the units are also
+// disabled, so they no longer start on boot.
+func StopServices(apps []*snap.AppInfo, disable bool, inter interacter) error {
+	if err := checkServices(apps); err != nil {
+		return err
+	}
+	sysd := systemd.New(dirs.GlobalRootDir, inter)
+
+	for _, app := range apps {
+		if err := stopService(sysd, app, inter); err != nil {
+			return err
+		}
+		if disable {
+			if err := sysd.Disable(startupUnit(app)); err != nil {
+				return err
+			}
+		}
+	}
 
+	return nil
+}
+
+// RestartServices restarts the units that start the given
+// applications, which must all be services. For timer-activated
+// services that is the timer unit.
+func RestartServices(apps []*snap.AppInfo, inter interacter) error {
+	if err := checkServices(apps); err != nil {
+		return err
+	}
+	sysd := systemd.New(dirs.GlobalRootDir, inter)
+
+	for _, app := range apps {
+		if err := sysd.Restart(startupUnit(app), serviceStopTimeout(app)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ServicesStatus returns the systemd status of the units that start
+// the given applications, which must all be services. For
+// timer-activated services that is the status of the timer unit.
+func ServicesStatus(apps []*snap.AppInfo) ([]*systemd.ServiceStatus, error) {
+	if err := checkServices(apps); err != nil {
+		return nil, err
+	}
+	sysd := systemd.New(dirs.GlobalRootDir, nil)
+
+	sts := make([]*systemd.ServiceStatus, len(apps))
+	for i, app := range apps {
+		st, err := sysd.ServiceStatus(startupUnit(app))
+		if err != nil {
+			return nil, err
+		}
+		sts[i] = st
+	}
+
+	return sts, nil
+}
+
+// TimerNextTrigger returns when the timer of the given
+// timer-activated service will next start it, or the zero time if the
+// timer is not scheduled to trigger.
+func TimerNextTrigger(app *snap.AppInfo) (time.Time, error) {
+	if app.Timer == "" {
+		return time.Time{}, fmt.Errorf("%s.%s is not a timer-activated service", app.Snap.Name(), app.Name)
+	}
+	sysd := systemd.New(dirs.GlobalRootDir, nil)
+
+	return sysd.TimerNextElapse(filepath.Base(app.TimerFile()))
 }
 
 // RemoveSnapServices disables and removes service units for the applications from the snap which are services.
@@ -145,7 +298,7 @@ func RemoveSnapServices(s *snap.Info, inter interacter) error {
 		nservices++
 
 		serviceName := filepath.Base(app.ServiceFile())
-		if err := sysd.Disable(serviceName); err != nil {
+		if err := sysd.Disable(startupUnit(app)); err != nil {
 			return err
 		}
 
@@ -156,6 +309,10 @@ func RemoveSnapServices(s *snap.Info, inter interacter) error {
 		if err := os.Remove(app.ServiceSocketFile()); err != nil && !os.IsNotExist(err) {
 			logger.Noticef("Failed to remove socket file for %q: %v", serviceName, err)
 		}
+
+		if err := os.Remove(app.TimerFile()); err != nil && !os.IsNotExist(err) {
+			logger.Noticef("Failed to remove timer file for %q: %v", serviceName, err)
+		}
 	}
 
 	// only reload if we actually had services
@@ -188,10 +345,10 @@ WorkingDirectory={{.App.Snap.DataDir}}
 Type={{.App.Daemon}}
 {{if .Remain}}RemainAfterExit={{.Remain}}{{end}}
 {{if .App.BusName}}BusName={{.App.BusName}}{{end}}
-
+{{if not .App.Timer}}
 [Install]
 WantedBy={{.ServicesTarget}}
-`
+{{end}}`
 	var templateOut bytes.Buffer
 	t := template.Must(template.New("service-wrapper").Parse(serviceTemplate))
 
@@ -249,3 +406,71 @@ WantedBy={{.ServicesTarget}}
 
 	return templateOut.String()
 }
+
+// timerCalendar returns the systemd calendar event (as used by
+// OnCalendar=) at which the given schedule window starts.
+func timerCalendar(sched *timeutil.Schedule) string {
+	event := fmt.Sprintf("*-*-* %02d:%02d", sched.Start.Hour, sched.Start.Minute)
+	if sched.Weekday != "" {
+		event = strings.Title(sched.Weekday) + " " + event
+	}
+	return event
+}
+
+func genTimerFile(appInfo *snap.AppInfo, schedule []*timeutil.Schedule) string {
+	timerTemplate := `[Unit]
+# Auto-generated, DO NOT EDIT
+Description=Timer for snap application {{.App.Snap.Name}}.{{.App.Name}}
+Requires={{.MountUnit}}
+After={{.MountUnit}}
+X-Snappy=yes
+
+[Timer]
+Unit={{.ServiceFileName}}
+{{range .Calendars}}OnCalendar={{.}}
+{{end}}{{if .RandomizedDelay}}RandomizedDelaySec={{.RandomizedDelay.Seconds}}
+{{end}}
+[Install]
+WantedBy={{.TimersTarget}}
+`
+	var templateOut bytes.Buffer
+	t := template.Must(template.New("timer-wrapper").Parse(timerTemplate))
+
+	calendars := make([]string, len(schedule))
+	// the service is started at a random time within the shortest
+	// window, so that it runs within every window of the schedule
+	var delay time.Duration
+	for i, sched := range schedule {
+		calendars[i] = timerCalendar(sched)
+		start := time.Duration(sched.Start.Hour)*time.Hour + time.Duration(sched.Start.Minute)*time.Minute
+		end := time.Duration(sched.End.Hour)*time.Hour + time.Duration(sched.End.Minute)*time.Minute
+		if i == 0 || end-start < delay {
+			delay = end - start
+		}
+	}
+
+	wrapperData := struct {
+		App *snap.AppInfo
+
+		ServiceFileName string
+		Calendars       []string
+		RandomizedDelay time.Duration
+		TimersTarget    string
+		MountUnit       string
+	}{
+		App: appInfo,
+
+		ServiceFileName: filepath.Base(appInfo.ServiceFile()),
+		Calendars:       calendars,
+		RandomizedDelay: delay,
+		TimersTarget:    systemd.TimersTarget,
+		MountUnit:       filepath.Base(systemd.MountUnitPath(appInfo.Snap.MountDir())),
+	}
+
+	if err := t.Execute(&templateOut, wrapperData); err != nil {
+		// this can never happen, except we forget a variable
+		logger.Panicf("Unable to execute template: %v", err)
+	}
+
+	return templateOut.String()
+}
@@ -226,3 +226,63 @@ apps:
 
 	c.Assert(wrapperText, Equals, expectedOneshotService)
 }
+
+func (s *servicesWrapperGenSuite) TestGenerateSnapTimerFile(c *C) {
+	yamlText := `
+name: snap
+version: 1.0
+apps:
+    app:
+        command: bin/start
+        daemon: oneshot
+        timer: mon,fri@9:00-11:00/23:00-23:30
+`
+	info, err := snap.InfoFromSnapYaml([]byte(yamlText))
+	c.Assert(err, IsNil)
+	info.Revision = snap.R(44)
+	app := info.Apps["app"]
+
+	generatedWrapper, err := wrappers.GenerateSnapTimerFile(app)
+	c.Assert(err, IsNil)
+	c.Check(generatedWrapper, Equals, `[Unit]
+# Auto-generated, DO NOT EDIT
+Description=Timer for snap application snap.app
+Requires=snap-snap-44.mount
+After=snap-snap-44.mount
+X-Snappy=yes
+
+[Timer]
+Unit=snap.snap.app.service
+OnCalendar=Mon *-*-* 09:00
+OnCalendar=Fri *-*-* 09:00
+OnCalendar=*-*-* 23:00
+RandomizedDelaySec=1800
+
+[Install]
+WantedBy=timers.target
+`)
+
+	// a timer-activated service is not started on its own
+	generatedWrapper, err = wrappers.GenerateSnapServiceFile(app)
+	c.Assert(err, IsNil)
+	c.Check(generatedWrapper, Not(Matches), `(?ms).*\[Install\].*`)
+}
+
+func (s *servicesWrapperGenSuite) TestGenerateSnapTimerFileExactTime(c *C) {
+	yamlText := `
+name: snap
+version: 1.0
+apps:
+    app:
+        command: bin/start
+        daemon: oneshot
+        timer: 6:30-6:30
+`
+	info, err := snap.InfoFromSnapYaml([]byte(yamlText))
+	c.Assert(err, IsNil)
+	info.Revision = snap.R(44)
+
+	generatedWrapper, err := wrappers.GenerateSnapTimerFile(info.Apps["app"])
+	c.Assert(err, IsNil)
+	c.Check(generatedWrapper, Matches, `(?ms).*^OnCalendar=\*-\*-\* 06:30\n\n\[Install\].*`)
+}
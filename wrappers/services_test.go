@@ -159,3 +159,183 @@ func (s *servicesTestSuite) TestStartSnapServices(c *C) {
 	c.Check(sysdLog[1], DeepEquals, []string{"--root", dirs.GlobalRootDir, "enable", filepath.Base(svcFile)})
 	c.Check(sysdLog[2], DeepEquals, []string{"start", filepath.Base(svcFile)})
 }
+
+func (s *servicesTestSuite) TestStartStopRestartServices(c *C) {
+	var sysdLog [][]string
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		sysdLog = append(sysdLog, cmd)
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	info := snaptest.MockSnap(c, packageHello, contentsHello, &snap.SideInfo{Revision: snap.R(12)})
+	apps := []*snap.AppInfo{info.Apps["svc1"]}
+	svcFName := "snap.hello-snap.svc1.service"
+
+	err := wrappers.StartServices(apps, true, nil)
+	c.Assert(err, IsNil)
+	c.Check(sysdLog, DeepEquals, [][]string{
+		{"--root", dirs.GlobalRootDir, "enable", svcFName},
+		{"start", svcFName},
+	})
+
+	sysdLog = nil
+	err = wrappers.StopServices(apps, true, &progress.NullProgress{})
+	c.Assert(err, IsNil)
+	c.Check(sysdLog, DeepEquals, [][]string{
+		{"stop", svcFName},
+		{"show", "--property=ActiveState", svcFName},
+		{"--root", dirs.GlobalRootDir, "disable", svcFName},
+	})
+
+	sysdLog = nil
+	err = wrappers.RestartServices(apps, nil)
+	c.Assert(err, IsNil)
+	c.Check(sysdLog, DeepEquals, [][]string{
+		{"stop", svcFName},
+		{"show", "--property=ActiveState", svcFName},
+		{"start", svcFName},
+	})
+}
+
+func (s *servicesTestSuite) TestServicesRejectNonServices(c *C) {
+	info := snaptest.MockSnap(c, packageHello, contentsHello, &snap.SideInfo{Revision: snap.R(12)})
+	apps := []*snap.AppInfo{info.Apps["hello"]}
+
+	c.Check(wrappers.StartServices(apps, false, nil), ErrorMatches, `hello-snap.hello is not a service`)
+	c.Check(wrappers.StopServices(apps, false, nil), ErrorMatches, `hello-snap.hello is not a service`)
+	c.Check(wrappers.RestartServices(apps, nil), ErrorMatches, `hello-snap.hello is not a service`)
+	_, err := wrappers.ServicesStatus(apps)
+	c.Check(err, ErrorMatches, `hello-snap.hello is not a service`)
+}
+
+func (s *servicesTestSuite) TestServicesStatus(c *C) {
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		c.Check(cmd, DeepEquals, []string{"show", "--property=Id,LoadState,ActiveState,SubState,UnitFileState", "snap.hello-snap.svc1.service"})
+		return []byte("Id=snap.hello-snap.svc1.service\nLoadState=loaded\nActiveState=active\nSubState=running\nUnitFileState=enabled\n"), nil
+	}
+
+	info := snaptest.MockSnap(c, packageHello, contentsHello, &snap.SideInfo{Revision: snap.R(12)})
+
+	sts, err := wrappers.ServicesStatus([]*snap.AppInfo{info.Apps["svc1"]})
+	c.Assert(err, IsNil)
+	c.Check(sts, DeepEquals, []*systemd.ServiceStatus{{
+		ServiceFileName: "snap.hello-snap.svc1.service",
+		LoadState:       "loaded",
+		ActiveState:     "active",
+		SubState:        "running",
+		UnitFileState:   "enabled",
+	}})
+}
+
+const packageTimer = `name: timer-snap
+version: 1.0
+apps:
+ svc:
+  command: bin/tick
+  daemon: oneshot
+  timer: 9:00-10:00
+`
+
+func (s *servicesTestSuite) TestAddSnapServicesWithTimerAndRemove(c *C) {
+	var sysdLog [][]string
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		sysdLog = append(sysdLog, cmd)
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	info := snaptest.MockSnap(c, packageTimer, "", &snap.SideInfo{Revision: snap.R(12)})
+
+	err := wrappers.AddSnapServices(info, nil)
+	c.Assert(err, IsNil)
+
+	svcFile := filepath.Join(s.tempdir, "/etc/systemd/system/snap.timer-snap.svc.service")
+	timerFile := filepath.Join(s.tempdir, "/etc/systemd/system/snap.timer-snap.svc.timer")
+	c.Check(osutil.FileExists(svcFile), Equals, true)
+	content, err := ioutil.ReadFile(timerFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Matches, "(?ms).*^Unit=snap.timer-snap.svc.service$.*")
+	c.Check(string(content), Matches, "(?ms).*^OnCalendar=\\*-\\*-\\* 09:00$.*")
+
+	sysdLog = nil
+	err = wrappers.StartSnapServices(info, nil)
+	c.Assert(err, IsNil)
+	c.Check(sysdLog, DeepEquals, [][]string{
+		{"daemon-reload"},
+		{"--root", dirs.GlobalRootDir, "enable", "snap.timer-snap.svc.timer"},
+		{"start", "snap.timer-snap.svc.timer"},
+	})
+
+	sysdLog = nil
+	err = wrappers.StopSnapServices(info, &progress.NullProgress{})
+	c.Assert(err, IsNil)
+	c.Check(sysdLog, DeepEquals, [][]string{
+		{"stop", "snap.timer-snap.svc.timer"},
+		{"show", "--property=ActiveState", "snap.timer-snap.svc.timer"},
+		{"stop", "snap.timer-snap.svc.service"},
+		{"show", "--property=ActiveState", "snap.timer-snap.svc.service"},
+	})
+
+	sysdLog = nil
+	err = wrappers.RemoveSnapServices(info, &progress.NullProgress{})
+	c.Assert(err, IsNil)
+	c.Check(osutil.FileExists(svcFile), Equals, false)
+	c.Check(osutil.FileExists(timerFile), Equals, false)
+	c.Check(sysdLog, DeepEquals, [][]string{
+		{"--root", dirs.GlobalRootDir, "disable", "snap.timer-snap.svc.timer"},
+		{"daemon-reload"},
+	})
+}
+
+func (s *servicesTestSuite) TestRestartServicesTimer(c *C) {
+	var sysdLog [][]string
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		sysdLog = append(sysdLog, cmd)
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	info := snaptest.MockSnap(c, packageTimer, "", &snap.SideInfo{Revision: snap.R(12)})
+
+	err := wrappers.RestartServices([]*snap.AppInfo{info.Apps["svc"]}, nil)
+	c.Assert(err, IsNil)
+	c.Check(sysdLog, DeepEquals, [][]string{
+		{"stop", "snap.timer-snap.svc.timer"},
+		{"show", "--property=ActiveState", "snap.timer-snap.svc.timer"},
+		{"start", "snap.timer-snap.svc.timer"},
+	})
+}
+
+func (s *servicesTestSuite) TestServicesStatusTimer(c *C) {
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		c.Check(cmd, DeepEquals, []string{"show", "--property=Id,LoadState,ActiveState,SubState,UnitFileState", "snap.timer-snap.svc.timer"})
+		return []byte("Id=snap.timer-snap.svc.timer\nLoadState=loaded\nActiveState=active\nSubState=waiting\nUnitFileState=enabled\n"), nil
+	}
+
+	info := snaptest.MockSnap(c, packageTimer, "", &snap.SideInfo{Revision: snap.R(12)})
+
+	sts, err := wrappers.ServicesStatus([]*snap.AppInfo{info.Apps["svc"]})
+	c.Assert(err, IsNil)
+	c.Check(sts, DeepEquals, []*systemd.ServiceStatus{{
+		ServiceFileName: "snap.timer-snap.svc.timer",
+		LoadState:       "loaded",
+		ActiveState:     "active",
+		SubState:        "waiting",
+		UnitFileState:   "enabled",
+	}})
+}
+
+func (s *servicesTestSuite) TestTimerNextTrigger(c *C) {
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		c.Check(cmd, DeepEquals, []string{"show", "--property=NextElapseUSecRealtime", "snap.timer-snap.svc.timer"})
+		return []byte("NextElapseUSecRealtime=Thu 2018-01-11 09:12:00 UTC\n"), nil
+	}
+
+	info := snaptest.MockSnap(c, packageTimer, "", &snap.SideInfo{Revision: snap.R(12)})
+
+	next, err := wrappers.TimerNextTrigger(info.Apps["svc"])
+	c.Assert(err, IsNil)
+	c.Check(next.Equal(time.Date(2018, 1, 11, 9, 12, 0, 0, time.UTC)), Equals, true)
+
+	info = snaptest.MockSnap(c, packageHello, contentsHello, &snap.SideInfo{Revision: snap.R(12)})
+	_, err = wrappers.TimerNextTrigger(info.Apps["svc1"])
+	c.Check(err, ErrorMatches, `hello-snap.svc1 is not a timer-activated service`)
+}